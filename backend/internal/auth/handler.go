@@ -1,8 +1,14 @@
 package auth
 
 import (
+	"all-me-backend/pkg/models"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
 )
@@ -12,13 +18,50 @@ type Handler struct {
 	frontendURL string
 }
 
-func NewHandler(authService *Service) *Handler {
-	frontendURL := os.Getenv("FRONTEND_URL")
+func NewHandler(authService *Service) (*Handler, error) {
+	// FRONTEND_URL defaults to the main domain when not specified
+	rawFrontendURL := os.Getenv("FRONTEND_URL")
+	if strings.TrimSpace(rawFrontendURL) == "" {
+		rawFrontendURL = os.Getenv("DOMAIN")
+	}
+
+	frontendURL, err := normalizeFrontendURL(rawFrontendURL)
+	if err != nil {
+		return nil, err
+	}
 
 	return &Handler{
 		authService: authService,
 		frontendURL: frontendURL,
+	}, nil
+}
+
+// normalizeFrontendURL validates the frontend base URL used for OAuth redirects
+// A missing scheme defaults to https and trailing slashes are removed
+func normalizeFrontendURL(rawURL string) (string, error) {
+	frontendURL := strings.TrimSpace(rawURL)
+	if frontendURL == "" {
+		return "", errors.New("FRONTEND_URL (or DOMAIN) is not set")
+	}
+
+	if !strings.Contains(frontendURL, "://") {
+		frontendURL = "https://" + frontendURL
+	}
+
+	parsedURL, err := url.Parse(frontendURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid FRONTEND_URL: %w", err)
+	}
+
+	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
+		return "", fmt.Errorf("invalid FRONTEND_URL: unsupported scheme %q", parsedURL.Scheme)
+	}
+
+	if parsedURL.Hostname() == "" {
+		return "", errors.New("invalid FRONTEND_URL: missing host")
 	}
+
+	return strings.TrimRight(frontendURL, "/"), nil
 }
 
 func (h *Handler) RegisterRoutes(e *echo.Echo) {
@@ -27,21 +70,30 @@ func (h *Handler) RegisterRoutes(e *echo.Echo) {
 	auth.GET("/:provider/login", h.handleLogin)
 	auth.GET("/:provider/callback", h.handleCallback)
 	auth.GET("/validate-session", h.handleValidateSession)
+	auth.GET("/accounts", h.handleListAccounts)
 	auth.POST("/signout", h.handleSignOut)
 }
 
 // handleLogin initiates the OAuth flow by redirecting to the provider's auth page
+// next is an optional comma-separated list of providers to sign in to afterwards, in the same flow
 func (h *Handler) handleLogin(c echo.Context) error {
 	provider := c.Param("provider")
 	sessionID := c.QueryParam("session_id")
 
+	var next []string
+	if rawNext := strings.TrimSpace(c.QueryParam("next")); rawNext != "" {
+		for _, nextProvider := range strings.Split(rawNext, ",") {
+			next = append(next, strings.TrimSpace(nextProvider))
+		}
+	}
+
 	if sessionID == "" {
 		return c.JSON(http.StatusBadRequest, map[string]string{
 			"error": "session_id is required",
 		})
 	}
 
-	authURL, err := h.authService.InitiateOAuth(provider, sessionID)
+	authURL, err := h.authService.InitiateOAuth(provider, sessionID, next...)
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{
 			"error": err.Error(),
@@ -76,21 +128,28 @@ func (h *Handler) handleCallback(c echo.Context) error {
 			h.frontendURL+"/callback?error=missing_state")
 	}
 
-	token, err := h.authService.HandleCallback(provider, code, state)
+	token, nextAuthURL, err := h.authService.HandleCallback(provider, code, state)
 	if err != nil {
 		return c.Redirect(http.StatusTemporaryRedirect,
 			h.frontendURL+"/callback?error=auth_failed&message="+err.Error())
 	}
 
+	// A chained sign-in continues with the next provider, and only the last one returns to the frontend
+	if nextAuthURL != "" {
+		return c.Redirect(http.StatusTemporaryRedirect, nextAuthURL)
+	}
+
 	// Redirect to frontend callback with success
 	return c.Redirect(http.StatusTemporaryRedirect,
 		h.frontendURL+"/callback?success=true&provider="+token.Provider)
 }
 
 // handleValidateSession checks if the session is valid and has a token for the specified provider
+// account_id selects one of the provider's accounts and is required when several are signed in
 func (h *Handler) handleValidateSession(c echo.Context) error {
 	sessionID := c.QueryParam("session_id")
 	provider := c.QueryParam("provider")
+	accountID := c.QueryParam("account_id")
 
 	if sessionID == "" {
 		return c.JSON(http.StatusBadRequest, map[string]string{
@@ -104,7 +163,22 @@ func (h *Handler) handleValidateSession(c echo.Context) error {
 		})
 	}
 
-	token, err := h.authService.GetSessionToken(sessionID, provider)
+	token, err := h.authService.GetSessionToken(sessionID, provider, accountID)
+	if errors.Is(err, models.ErrAmbiguousAccount) {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	if errors.Is(err, models.ErrTokenExpired) {
+		// The provider will reject the token, so the user has to sign in again
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"valid":         false,
+			"requires_auth": true,
+			"expired":       true,
+			"code":          models.ErrorCodeTokenExpired,
+			"provider":      provider,
+		})
+	}
 	if err != nil || token == nil {
 		// Session doesn't exist, is expired, or lacks token for provider
 		return c.JSON(http.StatusOK, map[string]interface{}{
@@ -114,17 +188,49 @@ func (h *Handler) handleValidateSession(c echo.Context) error {
 	}
 
 	// Session is valid and has the right token
-	return c.JSON(http.StatusOK, map[string]interface{}{
+	response := map[string]interface{}{
 		"valid":         true,
 		"requires_auth": false,
 		"provider":      provider,
+	}
+	if token.AccountID != "" {
+		response["account_id"] = token.AccountID
+	}
+	if !token.ExpiresAt.IsZero() {
+		// Lets the frontend prompt for re-authentication before the token runs out
+		response["expires_at"] = token.ExpiresAt
+		response["expires_in"] = int(time.Until(token.ExpiresAt).Seconds())
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// handleListAccounts returns the accounts signed in to the session, with their display names
+func (h *Handler) handleListAccounts(c echo.Context) error {
+	sessionID := c.QueryParam("session_id")
+	if sessionID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "session_id is required",
+		})
+	}
+
+	accounts, err := h.authService.ListAccounts(sessionID)
+	if err != nil {
+		// A session that does not exist or expired has no accounts signed in
+		accounts = []models.Account{}
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"accounts": accounts,
 	})
 }
 
 // handleSignOut signs out from the specified provider by revoking the token
+// account_id signs out one of the provider's accounts, all of them when omitted
 func (h *Handler) handleSignOut(c echo.Context) error {
 	sessionID := c.QueryParam("session_id")
 	provider := c.QueryParam("provider")
+	accountID := c.QueryParam("account_id")
 
 	if sessionID == "" {
 		return c.JSON(http.StatusBadRequest, map[string]string{
@@ -138,7 +244,7 @@ func (h *Handler) handleSignOut(c echo.Context) error {
 		})
 	}
 
-	err := h.authService.SignOutProvider(sessionID, provider)
+	err := h.authService.SignOutProvider(sessionID, provider, accountID)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": err.Error(),
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"all-me-backend/pkg/models"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestNormalizeFrontendURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{"well formed", "https://allme.example.com", "https://allme.example.com", false},
+		{"trailing slash", "https://allme.example.com/", "https://allme.example.com", false},
+		{"schemeless", "localhost:3000", "https://localhost:3000", false},
+		{"explicit http", "http://localhost:4200", "http://localhost:4200", false},
+		{"empty", "", "", true},
+		{"whitespace", "   ", "", true},
+		{"missing host", "https://", "", true},
+		{"unsupported scheme", "ftp://example.com", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeFrontendURL(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("normalizeFrontendURL(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("normalizeFrontendURL(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewHandler_FailsWithoutFrontendURL(t *testing.T) {
+	t.Setenv("FRONTEND_URL", "")
+	t.Setenv("DOMAIN", "")
+
+	if _, err := NewHandler(createTestService("")); err == nil {
+		t.Error("Expected error when FRONTEND_URL is empty, got nil")
+	}
+}
+
+func TestNewHandler_FallsBackToDomain(t *testing.T) {
+	t.Setenv("FRONTEND_URL", "")
+	t.Setenv("DOMAIN", "allme.example.com")
+
+	handler, err := NewHandler(createTestService(""))
+	if err != nil {
+		t.Fatalf("NewHandler failed: %v", err)
+	}
+
+	if handler.frontendURL != "https://allme.example.com" {
+		t.Errorf("Expected frontend URL from DOMAIN, got %s", handler.frontendURL)
+	}
+}
+
+func TestHandleCallback_RedirectsToNormalizedFrontend(t *testing.T) {
+	t.Setenv("FRONTEND_URL", "localhost:3000/")
+
+	handler, err := NewHandler(createTestService(""))
+	if err != nil {
+		t.Fatalf("NewHandler failed: %v", err)
+	}
+
+	e := echo.New()
+	handler.RegisterRoutes(e)
+
+	req := httptest.NewRequest(http.MethodGet, "/auth/onedrive/callback?error=access_denied", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	location := rec.Header().Get("Location")
+	if !strings.HasPrefix(location, "https://localhost:3000/callback?") {
+		t.Errorf("Expected redirect to normalized frontend URL, got %s", location)
+	}
+}
+
+func TestHandleValidateSession_TokenExpiry(t *testing.T) {
+	t.Setenv("FRONTEND_URL", "https://allme.example.com")
+
+	service := createTestService("")
+	handler, err := NewHandler(service)
+	if err != nil {
+		t.Fatalf("NewHandler failed: %v", err)
+	}
+
+	e := echo.New()
+	handler.RegisterRoutes(e)
+
+	tests := []struct {
+		name          string
+		expiresAt     time.Time
+		wantValid     bool
+		wantExpiresIn bool
+		wantCode      string
+	}{
+		{"no known expiry", time.Time{}, true, false, ""},
+		{"not yet expired", time.Now().Add(30 * time.Minute), true, true, ""},
+		{"expired", time.Now().Add(-time.Minute), false, false, models.ErrorCodeTokenExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := &models.UserSession{SessionID: "test-session"}
+			session.SetToken("onedrive", &models.Token{AccessToken: "token", Provider: "onedrive", ExpiresAt: tt.expiresAt})
+			if err := service.store.StoreSession(session); err != nil {
+				t.Fatalf("Failed to store session: %v", err)
+			}
+			// The token was replaced behind the service's back, as a sign-in would have dropped the cached one
+			service.tokens.invalidateSession("test-session")
+
+			req := httptest.NewRequest(http.MethodGet, "/auth/validate-session?session_id=test-session&provider=onedrive", nil)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			var body map[string]interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+
+			if body["valid"] != tt.wantValid || body["requires_auth"] != !tt.wantValid {
+				t.Errorf("Expected valid=%v requires_auth=%v, got %v", tt.wantValid, !tt.wantValid, body)
+			}
+
+			if code, _ := body["code"].(string); code != tt.wantCode {
+				t.Errorf("Expected code %q, got %v", tt.wantCode, body)
+			}
+
+			expiresIn, hasExpiresIn := body["expires_in"].(float64)
+			if hasExpiresIn != tt.wantExpiresIn {
+				t.Errorf("Expected expires_in present=%v, got %v", tt.wantExpiresIn, body)
+			}
+			if hasExpiresIn && (expiresIn <= 0 || expiresIn > 1800) {
+				t.Errorf("Expected expires_in within the next 30 minutes, got %v", expiresIn)
+			}
+		})
+	}
+}
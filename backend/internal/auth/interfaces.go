@@ -5,5 +5,43 @@ import "all-me-backend/pkg/models"
 // Provider defines the interface needed from an OAuth provider
 type Provider interface {
 	GetOAuthConfig() *models.OAuthConfig
-	BuildAuthURL(state string) (string, error)
+	// BuildAuthURL returns the provider's consent page URL, carrying the state and the S256 PKCE code challenge
+	BuildAuthURL(state, codeChallenge string) (string, error)
+	// GetAccount returns the account a token was issued for, so several accounts of the provider can be told apart
+	GetAccount(token *models.Token) (*models.Account, error)
+}
+
+// StateStore keeps the short-lived OAuth states that protect sign-in callbacks against CSRF
+type StateStore interface {
+	GenerateState(provider, sessionID string, next []string) (*OAuthState, error)
+	// ValidateState consumes the state, returning it if it existed and had not expired; a state validates
+	// once, so a callback can't be replayed
+	ValidateState(state string) (*OAuthState, error)
+	DeleteState(state string) error
+}
+
+// SessionStore keeps user sessions with their provider tokens
+// Sessions returned by GetSession are only persisted again by StoreSession
+type SessionStore interface {
+	StoreSession(session *models.UserSession) error
+	// GetSession returns an unexpired session; MemoryStore extends its lifetime on each read, while
+	// RedisStore only does so when the session is written, so reads stay cheap
+	GetSession(sessionID string) (*models.UserSession, error)
+	// GetSessionToken returns the token of the provider's account, reporting one past its expiry as models.ErrTokenExpired
+	// An empty accountID selects the provider's only account
+	GetSessionToken(sessionID, provider, accountID string) (*models.Token, error)
+	// LookupSessionToken returns the account's token whether or not it expired, e.g. to refresh it
+	LookupSessionToken(sessionID, provider, accountID string) (*models.Token, error)
+	// SetSessionToken replaces the provider's token for the token's account
+	SetSessionToken(sessionID, provider string, token *models.Token) error
+	GetSessionProviders(sessionID string) ([]string, error)
+	RecordRecentFolder(sessionID string, folder models.RecentFolder) error
+	GetRecentFolders(sessionID string) ([]models.RecentFolder, error)
+}
+
+// Store persists everything the auth service keeps between requests
+// MemoryStore is the default; a shared store lets several backend instances serve the same sessions
+type Store interface {
+	StateStore
+	SessionStore
 }
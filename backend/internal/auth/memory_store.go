@@ -1,13 +1,17 @@
 package auth
 
 import (
+	"all-me-backend/pkg/clock"
+	"all-me-backend/pkg/config"
 	"all-me-backend/pkg/models"
 	"errors"
+	"slices"
 	"sync"
 	"time"
 )
 
-// MemoryStore provides in-memory storage for OAuth states and sessions
+// MemoryStore provides in-memory storage for OAuth states and sessions, the default Store
+// Its contents are lost on restart and only visible to the instance holding them
 type MemoryStore struct {
 	// OAuth states for CSRF protection (short-lived)
 	states map[string]*OAuthState // state -> OAuthState
@@ -15,13 +19,34 @@ type MemoryStore struct {
 	// User sessions (long-lived)
 	sessions map[string]*models.UserSession // sessionID -> session (with tokens)
 
+	// recentFolderLimit is how many recent folder links each session keeps
+	recentFolderLimit int
+
+	clock clock.Clock
 	mutex sync.RWMutex
 }
 
+const defaultRecentFolderLimit = 5
+
+// oauthStateTTL is how long a sign-in has to complete before its state is rejected
+const oauthStateTTL = 10 * time.Minute
+
 func NewMemoryStore() *MemoryStore {
+	return NewMemoryStoreWithClock(clock.Real{})
+}
+
+// NewMemoryStoreWithClock creates a store that measures state and session expiry with the given clock
+func NewMemoryStoreWithClock(clk clock.Clock) *MemoryStore {
+	recentFolderLimit := config.GetInt("RECENT_FOLDERS_LIMIT", defaultRecentFolderLimit)
+	if recentFolderLimit < 0 {
+		recentFolderLimit = defaultRecentFolderLimit
+	}
+
 	store := &MemoryStore{
-		states:   make(map[string]*OAuthState),
-		sessions: make(map[string]*models.UserSession),
+		states:            make(map[string]*OAuthState),
+		sessions:          make(map[string]*models.UserSession),
+		recentFolderLimit: recentFolderLimit,
+		clock:             clk,
 	}
 
 	go store.startCleanupRoutine()
@@ -31,36 +56,30 @@ func NewMemoryStore() *MemoryStore {
 
 // === OAuth State Management (CSRF Protection) ===
 
-func (m *MemoryStore) GenerateState(provider, sessionID string) (*OAuthState, error) {
-	state, err := GenerateSecureState()
+func (m *MemoryStore) GenerateState(provider, sessionID string, next []string) (*OAuthState, error) {
+	oauthState, err := newOAuthState(provider, sessionID, next, m.clock.Now())
 	if err != nil {
 		return nil, err
 	}
 
-	oauthState := &OAuthState{
-		State:     state,
-		Provider:  provider,
-		SessionID: sessionID,
-		ExpiresAt: time.Now().Add(10 * time.Minute),
-	}
-
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	m.states[state] = oauthState
+	m.states[oauthState.State] = oauthState
 	return oauthState, nil
 }
 
 func (m *MemoryStore) ValidateState(state string) (*OAuthState, error) {
-	m.mutex.RLock()
-	defer m.mutex.RUnlock()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
 
 	oauthState, exists := m.states[state]
 	if !exists {
 		return nil, errors.New("invalid state")
 	}
+	delete(m.states, state)
 
-	if !oauthState.IsValid() {
+	if !oauthState.IsValid(m.clock.Now()) {
 		return nil, errors.New("state expired")
 	}
 
@@ -78,15 +97,21 @@ func (m *MemoryStore) DeleteState(state string) error {
 // === Session Management ===
 
 func (m *MemoryStore) StoreSession(session *models.UserSession) error {
+	// A stored nil session would later be returned without an error
+	if session == nil {
+		return errors.New("session is nil")
+	}
+
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
 	// Set timestamps if this is a new session
+	now := m.clock.Now()
 	if session.CreatedAt.IsZero() {
-		session.CreatedAt = time.Now()
+		session.CreatedAt = now
 	}
 	if session.LastAccessed.IsZero() {
-		session.LastAccessed = time.Now()
+		session.LastAccessed = now
 	}
 
 	m.sessions[session.SessionID] = session
@@ -103,30 +128,99 @@ func (m *MemoryStore) GetSession(sessionID string) (*models.UserSession, error)
 	}
 
 	// Check if session is expired
-	if session.IsExpired() {
+	now := m.clock.Now()
+	if session.IsExpired(now) {
 		delete(m.sessions, sessionID)
 		return nil, errors.New("session expired")
 	}
 
 	// Update last accessed time
-	session.UpdateLastAccessed()
+	session.UpdateLastAccessed(now)
 
 	return session, nil
 }
 
-// GetSessionToken retrieves a session and returns the token for the specified provider
-func (m *MemoryStore) GetSessionToken(sessionID, provider string) (*models.Token, error) {
+// GetSessionToken retrieves a session and returns the token for the provider's account
+// A token past its expiry is reported as models.ErrTokenExpired
+func (m *MemoryStore) GetSessionToken(sessionID, provider, accountID string) (*models.Token, error) {
+	token, err := m.LookupSessionToken(sessionID, provider, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	if token.IsExpired() {
+		return nil, models.ErrTokenExpired
+	}
+	return token, nil
+}
+
+// LookupSessionToken returns the token for the provider's account whether or not it expired, e.g. to refresh it
+func (m *MemoryStore) LookupSessionToken(sessionID, provider, accountID string) (*models.Token, error) {
 	session, err := m.GetSession(sessionID)
 	if err != nil {
 		return nil, err
 	}
 
-	token := session.GetToken(provider)
-	if token == nil {
-		return nil, errors.New("no token found for provider: " + provider)
+	// Tokens may be replaced concurrently when they are refreshed
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return session.FindToken(provider, accountID)
+}
+
+// SetSessionToken replaces the token for the provider's account in an existing session
+func (m *MemoryStore) SetSessionToken(sessionID, provider string, token *models.Token) error {
+	session, err := m.GetSession(sessionID)
+	if err != nil {
+		return err
 	}
 
-	return token, nil
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	session.SetToken(provider, token)
+	return nil
+}
+
+// RecordRecentFolder remembers a folder link the session opened, most recent first
+func (m *MemoryStore) RecordRecentFolder(sessionID string, folder models.RecentFolder) error {
+	session, err := m.GetSession(sessionID)
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	folder.UsedAt = m.clock.Now()
+	session.AddRecentFolder(folder, m.recentFolderLimit)
+	return nil
+}
+
+// GetRecentFolders returns the folder links the session opened recently, most recent first
+func (m *MemoryStore) GetRecentFolders(sessionID string) ([]models.RecentFolder, error) {
+	session, err := m.GetSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return slices.Clone(session.RecentFolders), nil
+}
+
+// GetSessionProviders returns the providers that currently have a token in the session
+func (m *MemoryStore) GetSessionProviders(sessionID string) ([]string, error) {
+	session, err := m.GetSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return session.Providers(), nil
 }
 
 func (m *MemoryStore) startCleanupRoutine() {
@@ -143,9 +237,9 @@ func (m *MemoryStore) cleanupExpiredSessions() {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	now := time.Now()
+	now := m.clock.Now()
 	for sessionID, session := range m.sessions {
-		if now.Sub(session.LastAccessed) > 24*time.Hour {
+		if session.IsExpired(now) {
 			delete(m.sessions, sessionID)
 		}
 	}
@@ -155,8 +249,9 @@ func (m *MemoryStore) cleanupExpiredStates() {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
+	now := m.clock.Now()
 	for state, oauthState := range m.states {
-		if !oauthState.IsValid() {
+		if !oauthState.IsValid(now) {
 			delete(m.states, state)
 		}
 	}
@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"all-me-backend/pkg/clock"
+	"all-me-backend/pkg/models"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore_SessionExpiresAfterInactivity(t *testing.T) {
+	fakeClock := clock.NewFake(time.Now())
+	store := NewMemoryStoreWithClock(fakeClock)
+
+	if err := store.StoreSession(&models.UserSession{SessionID: "session-1"}); err != nil {
+		t.Fatalf("Failed to store session: %v", err)
+	}
+
+	// Each access extends the session
+	fakeClock.Advance(23 * time.Hour)
+	if _, err := store.GetSession("session-1"); err != nil {
+		t.Fatalf("Expected session to be valid after 23 hours, got %v", err)
+	}
+
+	fakeClock.Advance(23 * time.Hour)
+	if _, err := store.GetSession("session-1"); err != nil {
+		t.Fatalf("Expected accessed session to stay valid, got %v", err)
+	}
+
+	fakeClock.Advance(25 * time.Hour)
+	if _, err := store.GetSession("session-1"); err == nil {
+		t.Error("Expected session to expire after 25 hours without access")
+	}
+}
+
+func TestMemoryStore_StateValidatesOnce(t *testing.T) {
+	store := NewMemoryStore()
+
+	state, err := store.GenerateState("onedrive", "session-1", nil)
+	if err != nil {
+		t.Fatalf("GenerateState failed: %v", err)
+	}
+
+	if _, err := store.ValidateState(state.State); err != nil {
+		t.Fatalf("ValidateState failed: %v", err)
+	}
+	if _, err := store.ValidateState(state.State); err == nil {
+		t.Error("Expected a validated state to be rejected the second time")
+	}
+}
+
+func TestMemoryStore_GetSessionTokenNeverReturnsNilWithoutError(t *testing.T) {
+	store := NewMemoryStore()
+
+	if err := store.StoreSession(nil); err == nil {
+		t.Error("Expected storing a nil session to fail")
+	}
+
+	session := &models.UserSession{SessionID: "session-1"}
+	session.SetToken("onedrive", nil)
+	store.StoreSession(session)
+
+	tests := []struct{ sessionID, provider string }{
+		{"missing-session", "googledrive"},
+		{"session-1", "googledrive"}, // No token for the provider
+		{"session-1", "onedrive"},    // Token entry present but nil
+	}
+	for _, tt := range tests {
+		token, err := store.GetSessionToken(tt.sessionID, tt.provider, "")
+		if token != nil || err == nil {
+			t.Errorf("Expected an error for %s/%s, got token %v and error %v", tt.sessionID, tt.provider, token, err)
+		}
+	}
+}
+
+func TestMemoryStore_GetSessionTokenReportsExpiredToken(t *testing.T) {
+	store := NewMemoryStore()
+
+	session := &models.UserSession{SessionID: "session-1"}
+	session.SetToken("onedrive", &models.Token{AccessToken: "token", Provider: "onedrive", ExpiresAt: time.Now().Add(-time.Minute)})
+	store.StoreSession(session)
+
+	if _, err := store.GetSessionToken("session-1", "onedrive", ""); !errors.Is(err, models.ErrTokenExpired) {
+		t.Errorf("Expected ErrTokenExpired, got %v", err)
+	}
+
+	// Refreshing still needs the expired token
+	if token, err := store.LookupSessionToken("session-1", "onedrive", ""); err != nil || token.AccessToken != "token" {
+		t.Errorf("Expected the expired token from LookupSessionToken, got %v and %v", token, err)
+	}
+}
+
+func TestMemoryStore_CleanupRemovesExpiredEntries(t *testing.T) {
+	fakeClock := clock.NewFake(time.Now())
+	store := NewMemoryStoreWithClock(fakeClock)
+
+	store.StoreSession(&models.UserSession{SessionID: "old-session"})
+	oldState, err := store.GenerateState("onedrive", "old-session", nil)
+	if err != nil {
+		t.Fatalf("Failed to generate state: %v", err)
+	}
+
+	fakeClock.Advance(24*time.Hour + time.Minute)
+
+	store.StoreSession(&models.UserSession{SessionID: "new-session"})
+	newState, err := store.GenerateState("onedrive", "new-session", nil)
+	if err != nil {
+		t.Fatalf("Failed to generate state: %v", err)
+	}
+
+	store.cleanupExpiredSessions()
+	store.cleanupExpiredStates()
+
+	if _, exists := store.sessions["old-session"]; exists {
+		t.Error("Expected expired session to be removed")
+	}
+	if _, exists := store.sessions["new-session"]; !exists {
+		t.Error("Expected fresh session to be kept")
+	}
+	if _, exists := store.states[oldState.State]; exists {
+		t.Error("Expected expired state to be removed")
+	}
+	if _, exists := store.states[newState.State]; !exists {
+		t.Error("Expected fresh state to be kept")
+	}
+}
@@ -2,6 +2,8 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"time"
 )
@@ -12,6 +14,32 @@ type OAuthState struct {
 	Provider  string    `json:"provider"`
 	SessionID string    `json:"session_id"`
 	ExpiresAt time.Time `json:"expires_at"` // Unix timestamp
+	// CodeVerifier is the PKCE secret whose challenge goes into the auth URL; the token exchange proves it
+	CodeVerifier string `json:"code_verifier"`
+	// Next lists the providers to sign in to after this one, in order, when several are connected in one flow
+	Next []string `json:"next,omitempty"`
+}
+
+// newOAuthState creates the state of a new sign-in, with a random state string and PKCE code verifier
+func newOAuthState(provider, sessionID string, next []string, now time.Time) (*OAuthState, error) {
+	state, err := GenerateSecureState()
+	if err != nil {
+		return nil, err
+	}
+
+	verifier, err := GenerateCodeVerifier()
+	if err != nil {
+		return nil, err
+	}
+
+	return &OAuthState{
+		State:        state,
+		Provider:     provider,
+		SessionID:    sessionID,
+		ExpiresAt:    now.Add(oauthStateTTL),
+		CodeVerifier: verifier,
+		Next:         next,
+	}, nil
 }
 
 // GenerateSecureState creates a cryptographically secure random state string
@@ -23,7 +51,22 @@ func GenerateSecureState() (string, error) {
 	return hex.EncodeToString(bytes), nil
 }
 
-// IsValid checks if the OAuth state is still valid
-func (s *OAuthState) IsValid() bool {
-	return time.Now().Before(s.ExpiresAt)
+// IsValid checks if the OAuth state is still valid at the given time
+func (s *OAuthState) IsValid(now time.Time) bool {
+	return now.Before(s.ExpiresAt)
+}
+
+// GenerateCodeVerifier creates a PKCE code verifier: 32 random bytes as 43 URL-safe characters (RFC 7636)
+func GenerateCodeVerifier() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// CodeChallenge derives the S256 PKCE code challenge of a verifier, sent with the authorization request
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
 }
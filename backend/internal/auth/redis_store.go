@@ -0,0 +1,351 @@
+package auth
+
+import (
+	"all-me-backend/pkg/clock"
+	"all-me-backend/pkg/config"
+	"all-me-backend/pkg/models"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis keys, namespaced so the store can share a database with other data
+const (
+	redisStatePrefix   = "auth:state:"
+	redisSessionPrefix = "auth:session:"
+	redisTokensSuffix  = ":tokens"
+)
+
+// redisTimeout bounds connecting to Redis and each command round trip
+const redisTimeout = 5 * time.Second
+
+// RedisStore keeps OAuth states and sessions in Redis, so they survive restarts and are shared by every
+// backend instance using the same Redis
+// States expire 10 minutes after they are generated and sessions 24 hours after they were last written, so
+// reading a session costs no write; each session's tokens are kept in a hash of JSON-encoded tokens by
+// models.TokenKey, expiring with the session
+type RedisStore struct {
+	client *redis.Client
+
+	// recentFolderLimit is how many recent folder links each session keeps
+	recentFolderLimit int
+
+	clock clock.Clock
+}
+
+// NewRedisStore connects to the Redis server at addr, failing when it cannot be reached
+func NewRedisStore(addr, password string, db int) (*RedisStore, error) {
+	return NewRedisStoreWithClock(addr, password, db, clock.Real{})
+}
+
+// NewRedisStoreWithClock creates a Redis store that stamps states and sessions with the given clock
+// Redis still expires keys by its own clock
+func NewRedisStoreWithClock(addr, password string, db int, clk clock.Clock) (*RedisStore, error) {
+	recentFolderLimit := config.GetInt("RECENT_FOLDERS_LIMIT", defaultRecentFolderLimit)
+	if recentFolderLimit < 0 {
+		recentFolderLimit = defaultRecentFolderLimit
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:         addr,
+		Password:     password,
+		DB:           db,
+		DialTimeout:  redisTimeout,
+		ReadTimeout:  redisTimeout,
+		WriteTimeout: redisTimeout,
+	})
+
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	store := &RedisStore{
+		client:            client,
+		recentFolderLimit: recentFolderLimit,
+		clock:             clk,
+	}
+
+	return store, nil
+}
+
+// === OAuth State Management (CSRF Protection) ===
+
+func (r *RedisStore) GenerateState(provider, sessionID string, next []string) (*OAuthState, error) {
+	oauthState, err := newOAuthState(provider, sessionID, next, r.clock.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(oauthState)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.client.Set(context.Background(), redisStatePrefix+oauthState.State, data, oauthStateTTL).Err(); err != nil {
+		return nil, fmt.Errorf("failed to store state: %w", err)
+	}
+	return oauthState, nil
+}
+
+// ValidateState reads and deletes the state in one GETDEL, so two callbacks racing with the same state
+// can't both get it
+func (r *RedisStore) ValidateState(state string) (*OAuthState, error) {
+	data, err := r.client.GetDel(context.Background(), redisStatePrefix+state).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, errors.New("invalid state")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state: %w", err)
+	}
+
+	var oauthState OAuthState
+	if err := json.Unmarshal(data, &oauthState); err != nil {
+		return nil, errors.New("invalid state")
+	}
+
+	if !oauthState.IsValid(r.clock.Now()) {
+		return nil, errors.New("state expired")
+	}
+
+	return &oauthState, nil
+}
+
+func (r *RedisStore) DeleteState(state string) error {
+	return r.client.Del(context.Background(), redisStatePrefix+state).Err()
+}
+
+// === Session Management ===
+
+// StoreSession replaces the session and all its tokens, restarting its 24 hour lifetime
+func (r *RedisStore) StoreSession(session *models.UserSession) error {
+	// A stored nil session would later be returned without an error
+	if session == nil {
+		return errors.New("session is nil")
+	}
+	ctx := context.Background()
+
+	// Set timestamps if this is a new session
+	now := r.clock.Now()
+	if session.CreatedAt.IsZero() {
+		session.CreatedAt = now
+	}
+	session.UpdateLastAccessed(now)
+
+	metadata, err := sessionMetadata(session)
+	if err != nil {
+		return err
+	}
+	tokens, err := encodeTokens(tokenFields(session.Tokens))
+	if err != nil {
+		return err
+	}
+
+	sessionKey, tokensKey := redisSessionKeys(session.SessionID)
+	if _, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, sessionKey, metadata, models.SessionTTL)
+		pipe.Del(ctx, tokensKey)
+		if len(tokens) > 0 {
+			pipe.HSet(ctx, tokensKey, tokens)
+			pipe.Expire(ctx, tokensKey, models.SessionTTL)
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to store session: %w", err)
+	}
+	return nil
+}
+
+// GetSession returns the session with its tokens
+// Reads leave the session's lifetime alone; it restarts whenever the session is written
+func (r *RedisStore) GetSession(sessionID string) (*models.UserSession, error) {
+	ctx := context.Background()
+	sessionKey, tokensKey := redisSessionKeys(sessionID)
+
+	var metadata *redis.StringCmd
+	var fields *redis.MapStringStringCmd
+	if _, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		metadata = pipe.Get(ctx, sessionKey)
+		fields = pipe.HGetAll(ctx, tokensKey)
+		return nil
+	}); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("failed to read session: %w", err)
+	}
+
+	data, err := metadata.Bytes()
+	if err != nil {
+		return nil, errors.New("session not found")
+	}
+
+	var session models.UserSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("failed to decode session: %w", err)
+	}
+
+	if len(fields.Val()) > 0 {
+		session.Tokens = make(map[string]*models.Token, len(fields.Val()))
+	}
+	for key, encoded := range fields.Val() {
+		var token models.Token
+		if err := json.Unmarshal([]byte(encoded), &token); err != nil {
+			return nil, fmt.Errorf("failed to decode %s token: %w", key, err)
+		}
+		session.Tokens[key] = &token
+	}
+
+	// Redis expires idle sessions itself; this catches sessions stamped by a clock ahead of it
+	if session.IsExpired(r.clock.Now()) {
+		r.client.Del(ctx, sessionKey, tokensKey)
+		return nil, errors.New("session expired")
+	}
+
+	return &session, nil
+}
+
+// GetSessionToken retrieves a session and returns the token for the provider's account
+// A token past its expiry is reported as models.ErrTokenExpired
+func (r *RedisStore) GetSessionToken(sessionID, provider, accountID string) (*models.Token, error) {
+	token, err := r.LookupSessionToken(sessionID, provider, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	if token.IsExpired() {
+		return nil, models.ErrTokenExpired
+	}
+	return token, nil
+}
+
+// LookupSessionToken returns the token for the provider's account whether or not it expired, e.g. to refresh it
+func (r *RedisStore) LookupSessionToken(sessionID, provider, accountID string) (*models.Token, error) {
+	session, err := r.GetSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return session.FindToken(provider, accountID)
+}
+
+// SetSessionToken replaces the token for the provider's account in an existing session, restarting its lifetime
+func (r *RedisStore) SetSessionToken(sessionID, provider string, token *models.Token) error {
+	session, err := r.GetSession(sessionID)
+	if err != nil {
+		return err
+	}
+
+	tokens, err := encodeTokens(map[string]*models.Token{models.TokenKey(provider, token.AccountID): token})
+	if err != nil {
+		return err
+	}
+
+	if err := r.touchSession(session, func(pipe redis.Pipeliner, tokensKey string) {
+		pipe.HSet(context.Background(), tokensKey, tokens)
+	}); err != nil {
+		return fmt.Errorf("failed to store token: %w", err)
+	}
+	return nil
+}
+
+// RecordRecentFolder remembers a folder link the session opened, most recent first, restarting its lifetime
+func (r *RedisStore) RecordRecentFolder(sessionID string, folder models.RecentFolder) error {
+	session, err := r.GetSession(sessionID)
+	if err != nil {
+		return err
+	}
+
+	folder.UsedAt = r.clock.Now()
+	session.AddRecentFolder(folder, r.recentFolderLimit)
+
+	if err := r.touchSession(session, nil); err != nil {
+		return fmt.Errorf("failed to store session: %w", err)
+	}
+	return nil
+}
+
+// GetRecentFolders returns the folder links the session opened recently, most recent first
+func (r *RedisStore) GetRecentFolders(sessionID string) ([]models.RecentFolder, error) {
+	session, err := r.GetSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return session.RecentFolders, nil
+}
+
+// GetSessionProviders returns the providers that currently have a token in the session
+func (r *RedisStore) GetSessionProviders(sessionID string) ([]string, error) {
+	session, err := r.GetSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return session.Providers(), nil
+}
+
+// touchSession writes the session's metadata and restarts the lifetime of it and its tokens in one
+// transaction, along with the changes update queues to its tokens
+func (r *RedisStore) touchSession(session *models.UserSession, update func(pipe redis.Pipeliner, tokensKey string)) error {
+	ctx := context.Background()
+	session.UpdateLastAccessed(r.clock.Now())
+	metadata, err := sessionMetadata(session)
+	if err != nil {
+		return err
+	}
+
+	sessionKey, tokensKey := redisSessionKeys(session.SessionID)
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, sessionKey, metadata, models.SessionTTL)
+		if update != nil {
+			update(pipe, tokensKey)
+		}
+		pipe.Expire(ctx, tokensKey, models.SessionTTL)
+		return nil
+	})
+	return err
+}
+
+// redisSessionKeys returns the keys of a session's metadata and of its tokens
+func redisSessionKeys(sessionID string) (sessionKey, tokensKey string) {
+	sessionKey = redisSessionPrefix + sessionID
+	return sessionKey, sessionKey + redisTokensSuffix
+}
+
+// sessionMetadata encodes the session without its tokens, which are stored separately per provider
+func sessionMetadata(session *models.UserSession) ([]byte, error) {
+	metadata := *session
+	metadata.Tokens = nil
+
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode session: %w", err)
+	}
+	return data, nil
+}
+
+// tokenFields returns the session's tokens without keys that have none
+func tokenFields(tokens map[string]*models.Token) map[string]*models.Token {
+	fields := make(map[string]*models.Token, len(tokens))
+	for tokenKey, token := range tokens {
+		if token != nil {
+			fields[tokenKey] = token
+		}
+	}
+	return fields
+}
+
+// encodeTokens encodes each token as JSON under its token key, as the fields of the session's token hash
+func encodeTokens(tokens map[string]*models.Token) (map[string]any, error) {
+	fields := make(map[string]any, len(tokens))
+	for tokenKey, token := range tokens {
+		data, err := json.Marshal(token)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode %s token: %w", tokenKey, err)
+		}
+		fields[tokenKey] = string(data)
+	}
+	return fields, nil
+}
@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"all-me-backend/pkg/clock"
+	"all-me-backend/pkg/models"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestRedisStore_States(t *testing.T) {
+	server := miniredis.RunT(t)
+	fakeClock := clock.NewFake(time.Now())
+	store, err := NewRedisStoreWithClock(server.Addr(), "", 0, fakeClock)
+	if err != nil {
+		t.Fatalf("NewRedisStoreWithClock failed: %v", err)
+	}
+
+	state, err := store.GenerateState("googledrive", "session-1", nil)
+	if err != nil {
+		t.Fatalf("GenerateState failed: %v", err)
+	}
+	if ttl := server.TTL(redisStatePrefix + state.State); ttl != oauthStateTTL {
+		t.Errorf("Expected the state to expire in %v, got %v", oauthStateTTL, ttl)
+	}
+
+	validated, err := store.ValidateState(state.State)
+	if err != nil {
+		t.Fatalf("ValidateState failed: %v", err)
+	}
+	if validated.Provider != "googledrive" || validated.SessionID != "session-1" {
+		t.Errorf("Expected the generated state back, got %+v", validated)
+	}
+
+	// A state validates once, so a callback can't be replayed
+	if _, err := store.ValidateState(state.State); err == nil {
+		t.Error("Expected a validated state to be rejected the second time")
+	}
+
+	if _, err := store.ValidateState("unknown"); err == nil {
+		t.Error("Expected an unknown state to be rejected")
+	}
+
+	expired, err := store.GenerateState("googledrive", "session-1", nil)
+	if err != nil {
+		t.Fatalf("GenerateState failed: %v", err)
+	}
+	fakeClock.Advance(11 * time.Minute)
+	if _, err := store.ValidateState(expired.State); err == nil {
+		t.Error("Expected the state to be rejected once expired")
+	}
+
+	deleted, err := store.GenerateState("googledrive", "session-1", nil)
+	if err != nil {
+		t.Fatalf("GenerateState failed: %v", err)
+	}
+	if err := store.DeleteState(deleted.State); err != nil {
+		t.Fatalf("DeleteState failed: %v", err)
+	}
+	if _, err := store.ValidateState(deleted.State); err == nil {
+		t.Error("Expected a deleted state to be rejected")
+	}
+}
+
+func TestRedisStore_ValidateStateOnce(t *testing.T) {
+	server := miniredis.RunT(t)
+	store, err := NewRedisStore(server.Addr(), "", 0)
+	if err != nil {
+		t.Fatalf("NewRedisStore failed: %v", err)
+	}
+
+	state, err := store.GenerateState("googledrive", "session-1", nil)
+	if err != nil {
+		t.Fatalf("GenerateState failed: %v", err)
+	}
+
+	var validated atomic.Int64
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Go(func() {
+			if _, err := store.ValidateState(state.State); err == nil {
+				validated.Add(1)
+			}
+		})
+	}
+	wg.Wait()
+
+	if n := validated.Load(); n != 1 {
+		t.Errorf("Expected callbacks racing with one state to validate it once, got %d", n)
+	}
+}
+
+func TestRedisStore_SessionRoundTrip(t *testing.T) {
+	server := miniredis.RunT(t)
+	server.RequireAuth("secret")
+	store, err := NewRedisStore(server.Addr(), "secret", 1)
+	if err != nil {
+		t.Fatalf("NewRedisStore failed: %v", err)
+	}
+
+	session := &models.UserSession{SessionID: "session-1"}
+	session.SetToken("googledrive", &models.Token{AccessToken: "g-access", RefreshToken: "g-refresh", Provider: "googledrive", ExpiresAt: time.Now().Add(time.Hour)})
+	if err := store.StoreSession(session); err != nil {
+		t.Fatalf("StoreSession failed: %v", err)
+	}
+
+	// Tokens are stored as JSON per provider
+	var stored models.Token
+	if err := json.Unmarshal([]byte(server.DB(1).HGet(redisSessionPrefix+"session-1"+redisTokensSuffix, "googledrive")), &stored); err != nil || stored.RefreshToken != "g-refresh" {
+		t.Errorf("Expected the Google Drive token stored as JSON, got %+v (%v)", stored, err)
+	}
+
+	// Another instance sharing the Redis sees the session
+	other, err := NewRedisStore(server.Addr(), "secret", 1)
+	if err != nil {
+		t.Fatalf("NewRedisStore failed: %v", err)
+	}
+
+	token, err := other.GetSessionToken("session-1", "googledrive", "")
+	if err != nil || token.AccessToken != "g-access" {
+		t.Fatalf("Expected the stored token, got %+v (%v)", token, err)
+	}
+
+	if err := other.SetSessionToken("session-1", "onedrive", &models.Token{AccessToken: "o-access", Provider: "onedrive", ExpiresAt: time.Now().Add(-time.Minute)}); err != nil {
+		t.Fatalf("SetSessionToken failed: %v", err)
+	}
+	if providers, _ := store.GetSessionProviders("session-1"); len(providers) != 2 || providers[0] != "googledrive" || providers[1] != "onedrive" {
+		t.Errorf("Expected both providers, got %v", providers)
+	}
+	if _, err := store.GetSessionToken("session-1", "onedrive", ""); !errors.Is(err, models.ErrTokenExpired) {
+		t.Errorf("Expected ErrTokenExpired for the expired token, got %v", err)
+	}
+	if token, err := store.LookupSessionToken("session-1", "onedrive", ""); err != nil || token.AccessToken != "o-access" {
+		t.Errorf("Expected the expired token to be looked up, got %+v (%v)", token, err)
+	}
+
+	if err := store.RecordRecentFolder("session-1", models.RecentFolder{Link: "https://drive.google.com/drive/folders/abc", Provider: "googledrive"}); err != nil {
+		t.Fatalf("RecordRecentFolder failed: %v", err)
+	}
+	if folders, _ := other.GetRecentFolders("session-1"); len(folders) != 1 || folders[0].Link != "https://drive.google.com/drive/folders/abc" {
+		t.Errorf("Expected the recorded folder, got %+v", folders)
+	}
+
+	if _, err := store.GetSession("unknown"); err == nil {
+		t.Error("Expected an unknown session to be rejected")
+	}
+
+	if _, err := NewRedisStore(server.Addr(), "wrong", 1); err == nil {
+		t.Error("Expected connecting with the wrong password to fail")
+	}
+}
+
+func TestRedisStore_OnlyWritesExtendSession(t *testing.T) {
+	server := miniredis.RunT(t)
+	store, err := NewRedisStore(server.Addr(), "", 0)
+	if err != nil {
+		t.Fatalf("NewRedisStore failed: %v", err)
+	}
+
+	session := &models.UserSession{SessionID: "session-1"}
+	session.SetToken("googledrive", &models.Token{AccessToken: "g-access", Provider: "googledrive"})
+	if err := store.StoreSession(session); err != nil {
+		t.Fatalf("StoreSession failed: %v", err)
+	}
+
+	sessionKey, tokensKey := redisSessionKeys("session-1")
+	server.SetTTL(sessionKey, 10*time.Second)
+	server.SetTTL(tokensKey, 10*time.Second)
+
+	// Reads write nothing
+	if _, err := store.GetSession("session-1"); err != nil {
+		t.Fatalf("GetSession failed: %v", err)
+	}
+	if _, err := store.GetSessionToken("session-1", "googledrive", ""); err != nil {
+		t.Fatalf("GetSessionToken failed: %v", err)
+	}
+	if server.TTL(sessionKey) != 10*time.Second || server.TTL(tokensKey) != 10*time.Second {
+		t.Errorf("Expected reading the session to leave its lifetime alone, got %v and %v", server.TTL(sessionKey), server.TTL(tokensKey))
+	}
+
+	for name, update := range map[string]func() error{
+		"SetSessionToken": func() error {
+			return store.SetSessionToken("session-1", "onedrive", &models.Token{AccessToken: "o-access", Provider: "onedrive"})
+		},
+		"RecordRecentFolder": func() error {
+			return store.RecordRecentFolder("session-1", models.RecentFolder{Link: "https://drive.google.com/drive/folders/abc", Provider: "googledrive"})
+		},
+	} {
+		server.SetTTL(sessionKey, 10*time.Second)
+		server.SetTTL(tokensKey, 10*time.Second)
+
+		if err := update(); err != nil {
+			t.Fatalf("%s failed: %v", name, err)
+		}
+		if server.TTL(sessionKey) != models.SessionTTL || server.TTL(tokensKey) != models.SessionTTL {
+			t.Errorf("Expected %s to extend the session and its tokens to %v, got %v and %v", name, models.SessionTTL, server.TTL(sessionKey), server.TTL(tokensKey))
+		}
+	}
+
+	server.FastForward(models.SessionTTL + time.Second)
+	if _, err := store.GetSession("session-1"); err == nil {
+		t.Error("Expected the session to be gone once its lifetime ran out")
+	}
+}
@@ -1,51 +1,90 @@
 package auth
 
 import (
+	"all-me-backend/internal/providers/httptransport"
+	"all-me-backend/pkg/clock"
+	"all-me-backend/pkg/config"
 	"all-me-backend/pkg/models"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 )
 
 // Service handles OAuth authentication for cloud storage providers
 type Service struct {
-	store           *MemoryStore
+	store           Store
 	httpClient      *http.Client
 	googleDriveAuth Provider
 	oneDriveAuth    Provider
+	dropboxAuth     Provider
+
+	// tokens skips the store for repeated lookups of a session's token, such as a gallery's thumbnails
+	tokens *tokenCache
+
+	// refreshMu serializes token refreshes, so concurrent requests near expiry refresh a token only once
+	refreshMu sync.Mutex
 }
 
-func NewService(googleDriveAuth, oneDriveAuth Provider) *Service {
+func NewService(googleDriveAuth, oneDriveAuth, dropboxAuth Provider) *Service {
+	return NewServiceWithStore(googleDriveAuth, oneDriveAuth, dropboxAuth, NewMemoryStore())
+}
+
+// NewServiceWithStore creates a service keeping OAuth states and sessions in the given store
+func NewServiceWithStore(googleDriveAuth, oneDriveAuth, dropboxAuth Provider, store Store) *Service {
+	tokenCacheTTL := config.GetInt("AUTH_TOKEN_CACHE_TTL_SECONDS", defaultTokenCacheTTL)
+	if tokenCacheTTL < 0 {
+		tokenCacheTTL = defaultTokenCacheTTL
+	}
+
 	return &Service{
-		store:           NewMemoryStore(),
-		httpClient:      &http.Client{Timeout: 30 * time.Second},
+		store:           store,
+		httpClient:      &http.Client{Timeout: 30 * time.Second, Transport: httptransport.Shared()},
 		googleDriveAuth: googleDriveAuth,
 		oneDriveAuth:    oneDriveAuth,
+		dropboxAuth:     dropboxAuth,
+		tokens:          newTokenCache(time.Duration(tokenCacheTTL)*time.Second, clock.Real{}),
 	}
 }
 
 // InitiateOAuth starts the OAuth flow for a provider, returning the auth URL
-func (s *Service) InitiateOAuth(provider, sessionID string) (string, error) {
+// next chains sign-ins to further providers: each callback continues with the next one, see HandleCallback
+func (s *Service) InitiateOAuth(provider, sessionID string, next ...string) (string, error) {
 	if !s.validateProvider(provider) {
 		return "", errors.New("unsupported provider: " + provider)
 	}
 
-	oauthState, err := s.store.GenerateState(provider, sessionID)
+	seen := map[string]bool{provider: true}
+	for _, nextProvider := range next {
+		if !s.validateProvider(nextProvider) {
+			return "", errors.New("unsupported provider: " + nextProvider)
+		}
+		if seen[nextProvider] {
+			return "", errors.New("provider listed more than once: " + nextProvider)
+		}
+		seen[nextProvider] = true
+	}
+
+	oauthState, err := s.store.GenerateState(provider, sessionID, next)
 	if err != nil {
 		return "", err
 	}
 
 	// Build authorization URL using provider-specific implementation
+	codeChallenge := CodeChallenge(oauthState.CodeVerifier)
 	var authURL string
 	switch provider {
 	case "googledrive":
-		authURL, err = s.googleDriveAuth.BuildAuthURL(oauthState.State)
+		authURL, err = s.googleDriveAuth.BuildAuthURL(oauthState.State, codeChallenge)
 	case "onedrive":
-		authURL, err = s.oneDriveAuth.BuildAuthURL(oauthState.State)
+		authURL, err = s.oneDriveAuth.BuildAuthURL(oauthState.State, codeChallenge)
+	case "dropbox":
+		authURL, err = s.dropboxAuth.BuildAuthURL(oauthState.State, codeChallenge)
 	default:
 		return "", errors.New("unsupported provider: " + provider)
 	}
@@ -58,31 +97,39 @@ func (s *Service) InitiateOAuth(provider, sessionID string) (string, error) {
 }
 
 // HandleCallback processes the OAuth callback and exchanges code for token
-func (s *Service) HandleCallback(provider, code, state string) (*models.Token, error) {
+// When the sign-in was chained to further providers, nextAuthURL starts the next one's sign-in
+func (s *Service) HandleCallback(provider, code, state string) (token *models.Token, nextAuthURL string, err error) {
 	if !s.validateProvider(provider) {
-		return nil, errors.New("unsupported provider: " + provider)
+		return nil, "", errors.New("unsupported provider: " + provider)
 	}
 
 	oauthState, err := s.store.ValidateState(state)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	// Verify provider matches the one in state
 	if oauthState.Provider != provider {
-		return nil, errors.New("provider mismatch in OAuth state")
+		return nil, "", errors.New("provider mismatch in OAuth state")
 	}
 
-	defer s.store.DeleteState(state)
-
 	config, err := s.getProviderConfig(oauthState.Provider)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	token, err := s.exchangeCodeForToken(config, code)
+	token, err = s.exchangeCodeForToken(config, code, oauthState.CodeVerifier)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+
+	// Tokens are kept per account, so signing in with another account of the provider adds to the session
+	// Without the account the token is kept as the provider's only one, as before accounts were tracked
+	if account, err := s.getProvider(provider).GetAccount(token); err != nil {
+		log.Printf("Failed to look up the %s account signed in: %v", provider, err)
+	} else {
+		token.AccountID = account.ID
+		token.AccountName = account.DisplayName
 	}
 
 	// Get or create session
@@ -94,26 +141,47 @@ func (s *Service) HandleCallback(provider, code, state string) (*models.Token, e
 		}
 	}
 
+	// A token stored without its account would make the provider's accounts ambiguous
+	if token.AccountID != "" {
+		delete(session.Tokens, oauthState.Provider)
+	}
 	session.SetToken(oauthState.Provider, token)
 
 	err = s.store.StoreSession(session)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+	s.tokens.invalidateSession(oauthState.SessionID)
+
+	// The provider just signed in to stays connected even if the next one can't be started
+	if len(oauthState.Next) > 0 {
+		nextAuthURL, err = s.InitiateOAuth(oauthState.Next[0], oauthState.SessionID, oauthState.Next[1:]...)
+		if err != nil {
+			log.Printf("Failed to continue the sign-in with %s: %v", oauthState.Next[0], err)
+			nextAuthURL = ""
+		}
 	}
 
-	return token, nil
+	return token, nextAuthURL, nil
 }
 
 // exchangeCodeForToken exchanges authorization code for access token
-func (s *Service) exchangeCodeForToken(config *models.OAuthConfig, code string) (*models.Token, error) {
+// The code verifier proves the exchange comes from whoever started the sign-in (PKCE)
+func (s *Service) exchangeCodeForToken(config *models.OAuthConfig, code, codeVerifier string) (*models.Token, error) {
 	data := url.Values{}
 	data.Set("client_id", config.ClientID)
 	data.Set("client_secret", config.ClientSecret)
 	data.Set("code", code)
+	data.Set("code_verifier", codeVerifier)
 	data.Set("grant_type", "authorization_code")
 	data.Set("redirect_uri", config.RedirectURI)
 	data.Set("scope", strings.Join(config.Scopes, " "))
 
+	return s.requestToken(config, data, "token exchange")
+}
+
+// requestToken posts a token request to the provider's token endpoint; operation names it in errors
+func (s *Service) requestToken(config *models.OAuthConfig, data url.Values, operation string) (*models.Token, error) {
 	req, err := http.NewRequest("POST", config.TokenURL, strings.NewReader(data.Encode()))
 	if err != nil {
 		return nil, err
@@ -129,12 +197,14 @@ func (s *Service) exchangeCodeForToken(config *models.OAuthConfig, code string)
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("token exchange failed with status: %d", resp.StatusCode)
+		return nil, fmt.Errorf("%s failed with status: %d", operation, resp.StatusCode)
 	}
 
 	var tokenResponse struct {
-		AccessToken string `json:"access_token"`
-		Scope       string `json:"scope"`
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		Scope        string `json:"scope"`
+		ExpiresIn    int    `json:"expires_in"` // Seconds until the access token expires
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(&tokenResponse); err != nil {
@@ -142,14 +212,30 @@ func (s *Service) exchangeCodeForToken(config *models.OAuthConfig, code string)
 	}
 
 	token := &models.Token{
-		AccessToken: tokenResponse.AccessToken,
-		Provider:    config.Provider,
-		Scope:       tokenResponse.Scope,
+		AccessToken:  tokenResponse.AccessToken,
+		Provider:     config.Provider,
+		Scope:        tokenResponse.Scope,
+		RefreshToken: tokenResponse.RefreshToken,
+	}
+	if tokenResponse.ExpiresIn > 0 {
+		token.ExpiresAt = time.Now().Add(time.Duration(tokenResponse.ExpiresIn) * time.Second)
 	}
 
 	return token, nil
 }
 
+// getProvider returns the implementation of a provider that passed validateProvider
+func (s *Service) getProvider(provider string) Provider {
+	switch provider {
+	case "onedrive":
+		return s.oneDriveAuth
+	case "dropbox":
+		return s.dropboxAuth
+	default:
+		return s.googleDriveAuth
+	}
+}
+
 func (s *Service) getProviderConfig(provider string) (*models.OAuthConfig, error) {
 	switch provider {
 	case "googledrive":
@@ -164,23 +250,239 @@ func (s *Service) getProviderConfig(provider string) (*models.OAuthConfig, error
 			return nil, errors.New("OAuth configuration incomplete for provider: " + provider)
 		}
 		return config, nil
+	case "dropbox":
+		config := s.dropboxAuth.GetOAuthConfig()
+		if config.ClientID == "" || config.ClientSecret == "" {
+			return nil, errors.New("OAuth configuration incomplete for provider: " + provider)
+		}
+		return config, nil
 	default:
 		return nil, errors.New("unsupported provider: " + provider)
 	}
 }
 
+// EnabledProviders returns the providers whose OAuth client is configured, so users can sign in with them
+func (s *Service) EnabledProviders() []string {
+	var providers []string
+	for _, provider := range []string{"googledrive", "onedrive", "dropbox"} {
+		if _, err := s.getProviderConfig(provider); err == nil {
+			providers = append(providers, provider)
+		}
+	}
+	return providers
+}
+
 // validateProvider checks if a provider is supported (internal use only)
 func (s *Service) validateProvider(provider string) bool {
-	return provider == "googledrive" || provider == "onedrive"
+	return provider == "googledrive" || provider == "onedrive" || provider == "dropbox"
+}
+
+// GetSessionToken retrieves a session and returns the token for the provider's account
+// An empty accountID selects the provider's only account; several are reported as models.ErrAmbiguousAccount
+// A token about to expire is refreshed first when the provider issued a refresh token
+// If refreshing fails the current token is returned while it is still valid, otherwise models.ErrTokenExpired
+func (s *Service) GetSessionToken(sessionID, provider, accountID string) (*models.Token, error) {
+	// Cached tokens about to expire are looked up again, so they are refreshed below
+	if token, cached := s.tokens.get(sessionID, provider, accountID); cached && !token.ExpiresWithin(models.TokenRefreshWindow) {
+		return token, nil
+	}
+
+	lookup := s.tokens.startLookup()
+	token, err := s.store.LookupSessionToken(sessionID, provider, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	if token.RefreshToken != "" && token.ExpiresWithin(models.TokenRefreshWindow) {
+		token, err = s.refreshIfExpiring(sessionID, provider, token.AccountID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if token.IsExpired() {
+		return nil, models.ErrTokenExpired
+	}
+	if !token.ExpiresWithin(models.TokenRefreshWindow) {
+		s.tokens.put(sessionID, provider, accountID, token, lookup)
+	}
+	return token, nil
+}
+
+// refreshIfExpiring refreshes the session's token unless another request already did while this one waited
+func (s *Service) refreshIfExpiring(sessionID, provider, accountID string) (*models.Token, error) {
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+
+	token, err := s.store.LookupSessionToken(sessionID, provider, accountID)
+	if err != nil {
+		return nil, err
+	}
+	if !token.ExpiresWithin(models.TokenRefreshWindow) {
+		return token, nil
+	}
+
+	refreshed, err := s.refreshToken(sessionID, token)
+	if err != nil {
+		log.Printf("Failed to refresh %s token for session: %v", provider, err)
+		return token, nil
+	}
+	return refreshed, nil
+}
+
+// RefreshToken obtains a new access token for the session's provider account with its refresh token
+// The new token replaces the old one in the session
+func (s *Service) RefreshToken(sessionID, provider, accountID string) (*models.Token, error) {
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+
+	// An expired token can still be refreshed
+	token, err := s.store.LookupSessionToken(sessionID, provider, accountID)
+	if err != nil {
+		return nil, err
+	}
+	return s.refreshToken(sessionID, token)
+}
+
+// refreshToken exchanges the token's refresh token for a new token, with refreshMu held
+func (s *Service) refreshToken(sessionID string, token *models.Token) (*models.Token, error) {
+	if token.RefreshToken == "" {
+		return nil, errors.New("no refresh token for provider: " + token.Provider)
+	}
+
+	config, err := s.getProviderConfig(token.Provider)
+	if err != nil {
+		return nil, err
+	}
+
+	data := url.Values{}
+	data.Set("client_id", config.ClientID)
+	data.Set("client_secret", config.ClientSecret)
+	data.Set("grant_type", "refresh_token")
+	data.Set("refresh_token", token.RefreshToken)
+
+	refreshed, err := s.requestToken(config, data, "token refresh")
+	if err != nil {
+		return nil, err
+	}
+
+	// Providers may omit what did not change: Google only issues a refresh token on the first sign-in
+	if refreshed.RefreshToken == "" {
+		refreshed.RefreshToken = token.RefreshToken
+	}
+	if refreshed.Scope == "" {
+		refreshed.Scope = token.Scope
+	}
+	refreshed.AccountID = token.AccountID
+	refreshed.AccountName = token.AccountName
+
+	if err := s.store.SetSessionToken(sessionID, token.Provider, refreshed); err != nil {
+		return nil, err
+	}
+	s.tokens.invalidateSession(sessionID)
+	return refreshed, nil
+}
+
+// RecordRecentFolder remembers a folder link the session opened successfully
+func (s *Service) RecordRecentFolder(sessionID string, folder models.RecentFolder) error {
+	return s.store.RecordRecentFolder(sessionID, folder)
+}
+
+// GetRecentFolders returns the folder links the session opened recently, most recent first
+func (s *Service) GetRecentFolders(sessionID string) ([]models.RecentFolder, error) {
+	return s.store.GetRecentFolders(sessionID)
+}
+
+// HasScope reports whether the session's token for the provider's account was granted the scope
+// models.ScopeWrite checks the provider's configured write scope; when none is configured there is nothing to check
+func (s *Service) HasScope(sessionID, provider, accountID, scope string) (bool, error) {
+	token, err := s.store.GetSessionToken(sessionID, provider, accountID)
+	if err != nil {
+		return false, err
+	}
+
+	if scope == models.ScopeWrite {
+		config, err := s.getProviderConfig(provider)
+		if err != nil {
+			return false, err
+		}
+		if config.WriteScope == "" {
+			return true, nil
+		}
+		scope = config.WriteScope
+	}
+
+	return token.HasScope(scope), nil
+}
+
+// GetSessionProviders returns the providers connected in the session
+func (s *Service) GetSessionProviders(sessionID string) ([]string, error) {
+	return s.store.GetSessionProviders(sessionID)
 }
 
-// GetSessionToken retrieves a session and returns the token for the specified provider
-func (s *Service) GetSessionToken(sessionID, provider string) (*models.Token, error) {
-	return s.store.GetSessionToken(sessionID, provider)
+// ListAccounts returns the accounts signed in to the session, by provider and display name
+// Tokens stored before accounts were tracked are listed without an account ID
+func (s *Service) ListAccounts(sessionID string) ([]models.Account, error) {
+	session, err := s.store.GetSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	accounts := []models.Account{}
+	for _, provider := range session.Providers() {
+		for _, key := range session.TokenKeys(provider) {
+			token := session.Tokens[key]
+			accounts = append(accounts, models.Account{
+				Provider:    provider,
+				ID:          token.AccountID,
+				DisplayName: token.AccountName,
+			})
+		}
+	}
+	return accounts, nil
 }
 
-// SignOutProvider removes the token for a specific provider from the session
-func (s *Service) SignOutProvider(sessionID, provider string) error {
+// RevokeToken asks the provider to invalidate the token's grant, so it stops working before it expires
+// The refresh token is revoked when there is one, which also invalidates its access tokens at Google
+// Providers without a RevokeURL are skipped
+func (s *Service) RevokeToken(token *models.Token) error {
+	config, err := s.getProviderConfig(token.Provider)
+	if err != nil {
+		return err
+	}
+	if config.RevokeURL == "" {
+		return nil
+	}
+
+	value := token.RefreshToken
+	if value == "" {
+		value = token.AccessToken
+	}
+
+	data := url.Values{}
+	data.Set("token", value)
+
+	req, err := http.NewRequest("POST", config.RevokeURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("token revocation failed with status: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// SignOutProvider removes the token of one of the provider's accounts from the session, or of all of them
+// when accountID is empty
+func (s *Service) SignOutProvider(sessionID, provider, accountID string) error {
 	if !s.validateProvider(provider) {
 		return errors.New("unsupported provider: " + provider)
 	}
@@ -191,11 +493,30 @@ func (s *Service) SignOutProvider(sessionID, provider string) error {
 		return nil
 	}
 
-	// Remove the token for this provider
-	if session.Tokens != nil {
-		delete(session.Tokens, provider)
+	keys := session.TokenKeys(provider)
+	if accountID != "" {
+		keys = []string{models.TokenKey(provider, accountID)}
+	}
+
+	for _, key := range keys {
+		// Revoking is best effort, the token is removed locally either way
+		if token := session.GetToken(key); token != nil {
+			if err := s.RevokeToken(token); err != nil {
+				log.Printf("Failed to revoke %s token on sign-out: %v", provider, err)
+			}
+		}
+		delete(session.Tokens, key)
+	}
+
+	// The folders opened with the provider are forgotten once none of its accounts is signed in
+	if !session.HasTokenForProvider(provider) {
+		session.ClearRecentFolders(provider)
 	}
 
 	// Update the session in the store
-	return s.store.StoreSession(session)
+	if err := s.store.StoreSession(session); err != nil {
+		return err
+	}
+	s.tokens.invalidateSession(sessionID)
+	return nil
 }
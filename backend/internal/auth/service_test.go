@@ -1,10 +1,15 @@
 package auth
 
 import (
+	"all-me-backend/pkg/clock"
 	"all-me-backend/pkg/models"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -13,7 +18,23 @@ import (
 func createTestService(tokenURL string) *Service {
 	mockOneDrive := &mockAuthProvider{tokenURL: tokenURL, provider: "onedrive"}
 	mockGoogleDrive := &mockAuthProvider{tokenURL: tokenURL, provider: "googledrive"}
-	return NewService(mockGoogleDrive, mockOneDrive)
+	mockDropbox := &mockAuthProvider{tokenURL: tokenURL, provider: "dropbox"}
+	return NewService(mockGoogleDrive, mockOneDrive, mockDropbox)
+}
+
+// peekState returns a pending OAuth state of the service's memory store without consuming it
+func peekState(t *testing.T, service *Service, state string) *OAuthState {
+	t.Helper()
+
+	store := service.store.(*MemoryStore)
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	oauthState, exists := store.states[state]
+	if !exists {
+		t.Fatalf("Expected state %q to be pending", state)
+	}
+	return oauthState
 }
 
 func TestAuthService_HandleCallback_Success(t *testing.T) {
@@ -58,13 +79,13 @@ func TestAuthService_HandleCallback_Success(t *testing.T) {
 	}
 
 	// Generate a valid state
-	state, err := service.store.GenerateState("onedrive", "test-session")
+	state, err := service.store.GenerateState("onedrive", "test-session", nil)
 	if err != nil {
 		t.Fatalf("Failed to generate state: %v", err)
 	}
 
 	// Test callback handling
-	token, err := service.HandleCallback("onedrive", "test-code", state.State)
+	token, _, err := service.HandleCallback("onedrive", "test-code", state.State)
 	if err != nil {
 		t.Fatalf("HandleCallback failed: %v", err)
 	}
@@ -77,12 +98,17 @@ func TestAuthService_HandleCallback_Success(t *testing.T) {
 	if token.Provider != "onedrive" {
 		t.Errorf("Expected provider 'onedrive', got '%s'", token.Provider)
 	}
+
+	// expires_in of 3600 seconds should put the expiry about an hour out
+	if remaining := time.Until(token.ExpiresAt); remaining < 59*time.Minute || remaining > time.Hour {
+		t.Errorf("Expected token to expire in about an hour, got %v", remaining)
+	}
 }
 
 func TestAuthService_HandleCallback_InvalidState(t *testing.T) {
 	service := createTestService("")
 
-	_, err := service.HandleCallback("onedrive", "test-code", "invalid-state")
+	_, _, err := service.HandleCallback("onedrive", "test-code", "invalid-state")
 	if err == nil {
 		t.Error("Expected error for invalid state, got nil")
 	}
@@ -94,6 +120,8 @@ func TestAuthService_HandleCallback_InvalidState(t *testing.T) {
 
 func TestAuthService_HandleCallback_ExpiredState(t *testing.T) {
 	service := createTestService("")
+	fakeClock := clock.NewFake(time.Now())
+	service.store = NewMemoryStoreWithClock(fakeClock)
 
 	// Create a session first
 	session := &models.UserSession{
@@ -104,17 +132,15 @@ func TestAuthService_HandleCallback_ExpiredState(t *testing.T) {
 		t.Fatalf("Failed to store session: %v", err)
 	}
 
-	// Generate state and manually expire it
-	state, err := service.store.GenerateState("onedrive", "test-session")
+	// Generate state and let it expire
+	state, err := service.store.GenerateState("onedrive", "test-session", nil)
 	if err != nil {
 		t.Fatalf("Failed to generate state: %v", err)
 	}
 
-	// Manually expire the state by setting its expiry to the past
-	state.ExpiresAt = time.Now().Add(-1 * time.Hour)
-	service.store.states[state.State] = state
+	fakeClock.Advance(11 * time.Minute)
 
-	_, err = service.HandleCallback("onedrive", "test-code", state.State)
+	_, _, err = service.HandleCallback("onedrive", "test-code", state.State)
 	if err == nil {
 		t.Error("Expected error for expired state, got nil")
 	}
@@ -140,7 +166,7 @@ func TestAuthService_InitiateOAuth_UnsupportedProvider(t *testing.T) {
 func TestAuthService_HandleCallback_UnsupportedProvider(t *testing.T) {
 	service := createTestService("")
 
-	_, err := service.HandleCallback("unsupported", "test-code", "test-state")
+	_, _, err := service.HandleCallback("unsupported", "test-code", "test-state")
 	if err == nil {
 		t.Error("Expected error for unsupported provider, got nil")
 	}
@@ -163,13 +189,13 @@ func TestAuthService_HandleCallback_ProviderMismatch(t *testing.T) {
 	}
 
 	// Generate state for onedrive
-	state, err := service.store.GenerateState("onedrive", "test-session")
+	state, err := service.store.GenerateState("onedrive", "test-session", nil)
 	if err != nil {
 		t.Fatalf("Failed to generate state: %v", err)
 	}
 
 	// Try to use the state with a different provider
-	_, err = service.HandleCallback("googledrive", "test-code", state.State)
+	_, _, err = service.HandleCallback("googledrive", "test-code", state.State)
 	if err == nil {
 		t.Error("Expected error for provider mismatch, got nil")
 	}
@@ -181,8 +207,12 @@ func TestAuthService_HandleCallback_ProviderMismatch(t *testing.T) {
 
 // mockAuthProvider is a test implementation of AuthProvider
 type mockAuthProvider struct {
-	tokenURL string
-	provider string
+	tokenURL   string
+	revokeURL  string
+	provider   string
+	writeScope string
+	// accounts maps access tokens to the account they were issued for; tokens without one have no known account
+	accounts map[string]*models.Account
 }
 
 func (m *mockAuthProvider) GetOAuthConfig() *models.OAuthConfig {
@@ -191,13 +221,464 @@ func (m *mockAuthProvider) GetOAuthConfig() *models.OAuthConfig {
 		ClientSecret: "test-client-secret",
 		RedirectURI:  "http://localhost:8080/auth/callback",
 		Scopes:       []string{"Files.Read"},
+		WriteScope:   m.writeScope,
 		AuthURL:      "https://example.com/auth",
 		TokenURL:     m.tokenURL,
+		RevokeURL:    m.revokeURL,
 		Provider:     m.provider,
 	}
 }
 
-func (m *mockAuthProvider) BuildAuthURL(state string) (string, error) {
+func (m *mockAuthProvider) BuildAuthURL(state, codeChallenge string) (string, error) {
 	config := m.GetOAuthConfig()
-	return config.AuthURL + "?client_id=" + config.ClientID + "&state=" + state, nil
+	return config.AuthURL + "?client_id=" + config.ClientID + "&code_challenge=" + codeChallenge + "&state=" + state, nil
+}
+
+func (m *mockAuthProvider) GetAccount(token *models.Token) (*models.Account, error) {
+	if account, ok := m.accounts[token.AccessToken]; ok {
+		return account, nil
+	}
+	return nil, errors.New("account lookup failed")
+}
+
+func TestResolveProvider_InfersSingleProvider(t *testing.T) {
+	service := createTestService("")
+
+	session := &models.UserSession{SessionID: "test-session"}
+	session.SetToken("onedrive", &models.Token{AccessToken: "token", Provider: "onedrive"})
+	if err := service.store.StoreSession(session); err != nil {
+		t.Fatalf("Failed to store session: %v", err)
+	}
+
+	provider, err := models.ResolveProvider(service, "test-session", "")
+	if err != nil {
+		t.Fatalf("ResolveProvider failed: %v", err)
+	}
+
+	if provider != "onedrive" {
+		t.Errorf("Expected provider 'onedrive', got '%s'", provider)
+	}
+}
+
+func TestResolveProvider_AmbiguousProvider(t *testing.T) {
+	service := createTestService("")
+
+	session := &models.UserSession{SessionID: "test-session"}
+	session.SetToken("onedrive", &models.Token{AccessToken: "token", Provider: "onedrive"})
+	session.SetToken("googledrive", &models.Token{AccessToken: "token", Provider: "googledrive"})
+	if err := service.store.StoreSession(session); err != nil {
+		t.Fatalf("Failed to store session: %v", err)
+	}
+
+	_, err := models.ResolveProvider(service, "test-session", "")
+	if !errors.Is(err, models.ErrAmbiguousProvider) {
+		t.Errorf("Expected ErrAmbiguousProvider, got: %v", err)
+	}
+
+	provider, err := models.ResolveProvider(service, "test-session", "googledrive")
+	if err != nil || provider != "googledrive" {
+		t.Errorf("Expected explicit provider 'googledrive', got '%s' (err: %v)", provider, err)
+	}
+}
+
+func TestRecentFolders_CappedAndClearedOnSignOut(t *testing.T) {
+	t.Setenv("RECENT_FOLDERS_LIMIT", "2")
+	service := createTestService("")
+
+	session := &models.UserSession{SessionID: "test-session"}
+	session.SetToken("onedrive", &models.Token{AccessToken: "token", Provider: "onedrive"})
+	session.SetToken("googledrive", &models.Token{AccessToken: "token", Provider: "googledrive"})
+	if err := service.store.StoreSession(session); err != nil {
+		t.Fatalf("Failed to store session: %v", err)
+	}
+
+	record := func(link, provider, name string) {
+		if err := service.RecordRecentFolder("test-session", models.RecentFolder{Link: link, Provider: provider, Name: name}); err != nil {
+			t.Fatalf("RecordRecentFolder failed: %v", err)
+		}
+	}
+	record("https://1drv.ms/f/a", "onedrive", "Wedding")
+	record("https://drive.google.com/drive/folders/b", "googledrive", "Party")
+	record("https://1drv.ms/f/a", "onedrive", "") // Opened again, keeping its name
+	record("https://1drv.ms/f/c", "onedrive", "Trip")
+
+	folders, err := service.GetRecentFolders("test-session")
+	if err != nil {
+		t.Fatalf("GetRecentFolders failed: %v", err)
+	}
+	if len(folders) != 2 || folders[0].Name != "Trip" || folders[1].Link != "https://1drv.ms/f/a" || folders[1].Name != "Wedding" {
+		t.Errorf("Expected Trip then Wedding, got %+v", folders)
+	}
+
+	if err := service.SignOutProvider("test-session", "onedrive", ""); err != nil {
+		t.Fatalf("SignOutProvider failed: %v", err)
+	}
+	folders, _ = service.GetRecentFolders("test-session")
+	if len(folders) != 0 {
+		t.Errorf("Expected folders opened with OneDrive to be forgotten on sign-out, got %+v", folders)
+	}
+
+	if _, err := service.GetRecentFolders("missing-session"); err == nil {
+		t.Error("Expected an error for a missing session")
+	}
+}
+
+func TestHasScope_ChecksProviderWriteScope(t *testing.T) {
+	oneDrive := &mockAuthProvider{provider: "onedrive", writeScope: "Files.ReadWrite.All"}
+	googleDrive := &mockAuthProvider{provider: "googledrive"}
+	service := NewService(googleDrive, oneDrive, &unconfiguredAuthProvider{})
+
+	session := &models.UserSession{SessionID: "test-session"}
+	session.SetToken("onedrive", &models.Token{AccessToken: "token", Provider: "onedrive", Scope: "https://graph.microsoft.com/Files.Read.All"})
+	session.SetToken("googledrive", &models.Token{AccessToken: "token", Provider: "googledrive", Scope: "https://www.googleapis.com/auth/drive.readonly"})
+	if err := service.store.StoreSession(session); err != nil {
+		t.Fatalf("Failed to store session: %v", err)
+	}
+
+	tests := []struct {
+		provider string
+		scope    string
+		want     bool
+	}{
+		{"onedrive", models.ScopeWrite, false},
+		{"onedrive", "Files.Read.All", true},     // Reported qualified with its resource
+		{"googledrive", models.ScopeWrite, true}, // No write scope configured
+		{"googledrive", "https://www.googleapis.com/auth/drive", false},
+	}
+	for _, tt := range tests {
+		got, err := service.HasScope("test-session", tt.provider, "", tt.scope)
+		if err != nil {
+			t.Fatalf("HasScope(%s, %s) failed: %v", tt.provider, tt.scope, err)
+		}
+		if got != tt.want {
+			t.Errorf("HasScope(%s, %s) = %v, want %v", tt.provider, tt.scope, got, tt.want)
+		}
+	}
+
+	// Signing in again with the write scope granted
+	session.SetToken("onedrive", &models.Token{AccessToken: "token", Provider: "onedrive", Scope: "Files.Read.All Files.ReadWrite.All"})
+	if err := service.store.StoreSession(session); err != nil {
+		t.Fatalf("Failed to store session: %v", err)
+	}
+	if got, _ := service.HasScope("test-session", "onedrive", "", models.ScopeWrite); !got {
+		t.Error("Expected the write scope to be granted after re-authenticating")
+	}
+
+	if _, err := service.HasScope("missing-session", "onedrive", "", models.ScopeWrite); err == nil {
+		t.Error("Expected an error for a missing session")
+	}
+}
+
+func TestRefreshToken_ReplacesSessionToken(t *testing.T) {
+	var refreshes int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatalf("Failed to parse form: %v", err)
+		}
+		if r.PostForm.Get("grant_type") != "refresh_token" || r.PostForm.Get("refresh_token") != "refresh-1" {
+			t.Errorf("Expected a refresh_token grant for refresh-1, got %v", r.PostForm)
+		}
+		refreshes++
+
+		// Like Google, no new refresh token is issued
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access-2",
+			"expires_in":   3600,
+		})
+	}))
+	defer server.Close()
+
+	service := createTestService(server.URL)
+
+	session := &models.UserSession{SessionID: "test-session"}
+	session.SetToken("onedrive", &models.Token{
+		AccessToken:  "access-1",
+		Provider:     "onedrive",
+		Scope:        "Files.Read.All",
+		ExpiresAt:    time.Now().Add(time.Hour),
+		RefreshToken: "refresh-1",
+	})
+	if err := service.store.StoreSession(session); err != nil {
+		t.Fatalf("Failed to store session: %v", err)
+	}
+
+	// Far from expiry, the stored token is returned as is
+	token, err := service.GetSessionToken("test-session", "onedrive", "")
+	if err != nil || token.AccessToken != "access-1" || refreshes != 0 {
+		t.Fatalf("Expected the stored token without a refresh, got %+v (%d refreshes, err %v)", token, refreshes, err)
+	}
+
+	token, err = service.RefreshToken("test-session", "onedrive", "")
+	if err != nil {
+		t.Fatalf("RefreshToken failed: %v", err)
+	}
+	if token.AccessToken != "access-2" || token.RefreshToken != "refresh-1" || token.Scope != "Files.Read.All" {
+		t.Errorf("Expected a new access token keeping the refresh token and scope, got %+v", token)
+	}
+
+	stored, _ := service.store.GetSessionToken("test-session", "onedrive", "")
+	if stored.AccessToken != "access-2" {
+		t.Errorf("Expected the session to hold the refreshed token, got %q", stored.AccessToken)
+	}
+
+	// About to expire, the token is refreshed transparently
+	stored.ExpiresAt = time.Now().Add(time.Minute)
+	token, err = service.GetSessionToken("test-session", "onedrive", "")
+	if err != nil || refreshes != 2 || !token.ExpiresWithin(time.Hour+time.Minute) || token.ExpiresWithin(models.TokenRefreshWindow) {
+		t.Errorf("Expected the token to be refreshed near expiry, got %+v (%d refreshes, err %v)", token, refreshes, err)
+	}
+
+	session.SetToken("googledrive", &models.Token{AccessToken: "access", Provider: "googledrive"})
+	if _, err := service.RefreshToken("test-session", "googledrive", ""); err == nil {
+		t.Error("Expected an error refreshing a token without a refresh token")
+	}
+}
+
+func TestSignOutProvider_RevokesToken(t *testing.T) {
+	var revoked []string
+	failRevocation := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		revoked = append(revoked, r.FormValue("token"))
+		if failRevocation {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	googleDrive := &mockAuthProvider{provider: "googledrive", revokeURL: server.URL}
+	oneDrive := &mockAuthProvider{provider: "onedrive"} // No revocation endpoint
+	service := NewService(googleDrive, oneDrive, &unconfiguredAuthProvider{})
+
+	signIn := func() {
+		session := &models.UserSession{SessionID: "test-session"}
+		session.SetToken("googledrive", &models.Token{AccessToken: "access", RefreshToken: "refresh", Provider: "googledrive"})
+		session.SetToken("onedrive", &models.Token{AccessToken: "access", Provider: "onedrive"})
+		if err := service.store.StoreSession(session); err != nil {
+			t.Fatalf("Failed to store session: %v", err)
+		}
+	}
+
+	signIn()
+	if err := service.SignOutProvider("test-session", "googledrive", ""); err != nil {
+		t.Fatalf("SignOutProvider failed: %v", err)
+	}
+	if err := service.SignOutProvider("test-session", "onedrive", ""); err != nil {
+		t.Fatalf("SignOutProvider failed: %v", err)
+	}
+	if len(revoked) != 1 || revoked[0] != "refresh" {
+		t.Errorf("Expected only the Google refresh token to be revoked, got %v", revoked)
+	}
+
+	// A failed revocation still signs out locally
+	signIn()
+	failRevocation = true
+	if err := service.SignOutProvider("test-session", "googledrive", ""); err != nil {
+		t.Fatalf("SignOutProvider failed: %v", err)
+	}
+	if _, err := service.store.GetSessionToken("test-session", "googledrive", ""); err == nil {
+		t.Error("Expected the token to be removed although revocation failed")
+	}
+}
+
+func TestEnabledProviders_RequiresClientCredentials(t *testing.T) {
+	service := NewService(&mockAuthProvider{provider: "googledrive"}, &unconfiguredAuthProvider{}, &unconfiguredAuthProvider{})
+
+	if providers := service.EnabledProviders(); !slices.Equal(providers, []string{"googledrive"}) {
+		t.Errorf("Expected only Google Drive to be enabled, got %v", providers)
+	}
+}
+
+// unconfiguredAuthProvider is an auth provider deployed without OAuth client credentials
+type unconfiguredAuthProvider struct{}
+
+func (u *unconfiguredAuthProvider) GetOAuthConfig() *models.OAuthConfig {
+	return &models.OAuthConfig{Provider: "onedrive"}
+}
+
+func (u *unconfiguredAuthProvider) BuildAuthURL(state, codeChallenge string) (string, error) {
+	return "", errors.New("not configured")
+}
+
+func (u *unconfiguredAuthProvider) GetAccount(token *models.Token) (*models.Account, error) {
+	return nil, errors.New("not configured")
+}
+
+func TestNewServiceWithStore_SharesSessionsBetweenInstances(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "access"})
+	}))
+	defer server.Close()
+
+	// Two instances behind a load balancer sharing one store
+	store := NewMemoryStore()
+	first := NewServiceWithStore(&mockAuthProvider{tokenURL: server.URL, provider: "googledrive"}, &mockAuthProvider{tokenURL: server.URL, provider: "onedrive"}, &mockAuthProvider{tokenURL: server.URL, provider: "dropbox"}, store)
+	second := NewServiceWithStore(&mockAuthProvider{tokenURL: server.URL, provider: "googledrive"}, &mockAuthProvider{tokenURL: server.URL, provider: "onedrive"}, &mockAuthProvider{tokenURL: server.URL, provider: "dropbox"}, store)
+
+	authURL, err := first.InitiateOAuth("onedrive", "test-session")
+	if err != nil {
+		t.Fatalf("InitiateOAuth failed: %v", err)
+	}
+	state := authURL[strings.Index(authURL, "state=")+len("state="):]
+
+	// The callback reaches the other instance
+	if _, _, err := second.HandleCallback("onedrive", "code", state); err != nil {
+		t.Fatalf("HandleCallback failed: %v", err)
+	}
+
+	token, err := first.GetSessionToken("test-session", "onedrive", "")
+	if err != nil || token.AccessToken != "access" {
+		t.Errorf("Expected the session signed in through the other instance, got %v and %v", token, err)
+	}
+}
+
+func TestHandleCallback_ContinuesChainedSignIn(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "access"})
+	}))
+	defer server.Close()
+
+	service := createTestService(server.URL)
+	stateOf := func(authURL string) string {
+		parsed, err := url.Parse(authURL)
+		if err != nil {
+			t.Fatalf("Invalid auth URL: %v", err)
+		}
+		return parsed.Query().Get("state")
+	}
+
+	authURL, err := service.InitiateOAuth("googledrive", "test-session", "onedrive")
+	if err != nil {
+		t.Fatalf("InitiateOAuth failed: %v", err)
+	}
+
+	_, nextAuthURL, err := service.HandleCallback("googledrive", "code", stateOf(authURL))
+	if err != nil {
+		t.Fatalf("HandleCallback failed: %v", err)
+	}
+	nextState := peekState(t, service, stateOf(nextAuthURL))
+	if nextState.Provider != "onedrive" || nextState.SessionID != "test-session" {
+		t.Fatalf("Expected the sign-in to continue with onedrive in the same session, got %+v", nextState)
+	}
+
+	_, nextAuthURL, err = service.HandleCallback("onedrive", "code", nextState.State)
+	if err != nil || nextAuthURL != "" {
+		t.Fatalf("Expected the chain to end after onedrive, got %q (%v)", nextAuthURL, err)
+	}
+	if providers, _ := service.GetSessionProviders("test-session"); len(providers) != 2 {
+		t.Errorf("Expected both providers connected, got %v", providers)
+	}
+
+	for _, next := range [][]string{{"unsupported"}, {"googledrive"}, {"onedrive", "onedrive"}} {
+		if _, err := service.InitiateOAuth("googledrive", "test-session", next...); err == nil {
+			t.Errorf("Expected a chain continuing with %v to be rejected", next)
+		}
+	}
+}
+
+func TestCodeChallenge_RFC7636Vector(t *testing.T) {
+	// Appendix B of RFC 7636
+	challenge := CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	if challenge != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Errorf("Expected the RFC 7636 sample challenge, got %s", challenge)
+	}
+}
+
+func TestAuthService_PKCE_VerifierTiedToState(t *testing.T) {
+	var receivedVerifier string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedVerifier = r.FormValue("code_verifier")
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "access"})
+	}))
+	defer server.Close()
+
+	service := createTestService(server.URL)
+
+	authURL, err := service.InitiateOAuth("googledrive", "test-session")
+	if err != nil {
+		t.Fatalf("InitiateOAuth failed: %v", err)
+	}
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("Invalid auth URL: %v", err)
+	}
+	state := parsed.Query().Get("state")
+
+	oauthState := peekState(t, service, state)
+	if len(oauthState.CodeVerifier) != 43 {
+		t.Errorf("Expected a 43 character code verifier, got %q", oauthState.CodeVerifier)
+	}
+	if challenge := parsed.Query().Get("code_challenge"); challenge != CodeChallenge(oauthState.CodeVerifier) {
+		t.Errorf("Expected the auth URL to carry the challenge of the state's verifier, got %q", challenge)
+	}
+
+	if _, _, err := service.HandleCallback("googledrive", "code", state); err != nil {
+		t.Fatalf("HandleCallback failed: %v", err)
+	}
+	if receivedVerifier != oauthState.CodeVerifier {
+		t.Errorf("Expected the token exchange to send the state's code verifier, got %q", receivedVerifier)
+	}
+}
+
+func TestHandleCallback_KeepsSeveralAccountsPerProvider(t *testing.T) {
+	var issued int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		issued++
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "access-" + strconv.Itoa(issued)})
+	}))
+	defer server.Close()
+
+	googleDrive := &mockAuthProvider{tokenURL: server.URL, provider: "googledrive", accounts: map[string]*models.Account{
+		"access-2": {Provider: "googledrive", ID: "personal", DisplayName: "Personal"},
+		"access-3": {Provider: "googledrive", ID: "work", DisplayName: "Work"},
+	}}
+	service := NewService(googleDrive, &mockAuthProvider{tokenURL: server.URL, provider: "onedrive"}, &mockAuthProvider{tokenURL: server.URL, provider: "dropbox"})
+
+	signIn := func() {
+		t.Helper()
+		state, err := service.store.GenerateState("googledrive", "test-session", nil)
+		if err != nil {
+			t.Fatalf("GenerateState failed: %v", err)
+		}
+		if _, _, err := service.HandleCallback("googledrive", "code", state.State); err != nil {
+			t.Fatalf("HandleCallback failed: %v", err)
+		}
+	}
+
+	// A token stored before accounts were tracked is replaced once the account is known
+	signIn()
+	if token, err := service.GetSessionToken("test-session", "googledrive", ""); err != nil || token.AccountID != "" {
+		t.Fatalf("Expected the token without a known account under the provider, got %+v (%v)", token, err)
+	}
+	signIn()
+	signIn()
+
+	accounts, err := service.ListAccounts("test-session")
+	if err != nil {
+		t.Fatalf("ListAccounts failed: %v", err)
+	}
+	want := []models.Account{
+		{Provider: "googledrive", ID: "personal", DisplayName: "Personal"},
+		{Provider: "googledrive", ID: "work", DisplayName: "Work"},
+	}
+	if !slices.Equal(accounts, want) {
+		t.Errorf("Expected both accounts, got %+v", accounts)
+	}
+
+	if token, err := service.GetSessionToken("test-session", "googledrive", "work"); err != nil || token.AccessToken != "access-3" {
+		t.Errorf("Expected the work account's token, got %+v (%v)", token, err)
+	}
+	if _, err := service.GetSessionToken("test-session", "googledrive", ""); !errors.Is(err, models.ErrAmbiguousAccount) {
+		t.Errorf("Expected ErrAmbiguousAccount without an account, got %v", err)
+	}
+	if providers, _ := service.GetSessionProviders("test-session"); !slices.Equal(providers, []string{"googledrive"}) {
+		t.Errorf("Expected the provider listed once, got %v", providers)
+	}
+
+	// Signing out one account leaves the other as the provider's only one
+	if err := service.SignOutProvider("test-session", "googledrive", "personal"); err != nil {
+		t.Fatalf("SignOutProvider failed: %v", err)
+	}
+	if token, err := service.GetSessionToken("test-session", "googledrive", ""); err != nil || token.AccountID != "work" {
+		t.Errorf("Expected the remaining account's token, got %+v (%v)", token, err)
+	}
 }
@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"all-me-backend/pkg/clock"
+	"all-me-backend/pkg/models"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultTokenCacheTTL keeps tokens just long enough to serve a burst of requests, such as a gallery
+// loading its thumbnails, while a sign-out elsewhere is noticed almost right away
+const defaultTokenCacheTTL = 2 // seconds
+
+// tokenCacheSweepInterval is how often expired entries of sessions that stopped making requests are dropped
+const tokenCacheSweepInterval = time.Minute
+
+// tokenCache keeps recently looked up session tokens, so bursts of requests skip the session store
+// Reads take no lock; entries are dropped after ttl and whenever the session's tokens change
+// A lookup that read the store before the session's tokens changed may only finish after the cache was
+// invalidated; its entry is stamped with the lookup's sequence number and ignored, so it can't bring back
+// a token that was signed out
+type tokenCache struct {
+	entries sync.Map // session ID + provider + account ID -> *cachedToken
+	ttl     time.Duration
+	clock   clock.Clock
+
+	sequence      atomic.Uint64 // Incremented by every invalidation
+	invalidations sync.Map      // session ID -> *tokenInvalidation, kept for ttl
+
+	lastSweep atomic.Int64 // Unix nanoseconds of the last sweep of expired entries
+}
+
+type cachedToken struct {
+	token     *models.Token
+	sequence  uint64
+	expiresAt time.Time
+}
+
+// tokenLookup marks when a lookup of the session store started
+type tokenLookup struct {
+	sequence  uint64
+	startedAt time.Time
+}
+
+// tokenInvalidation records the last invalidation of a session's tokens
+type tokenInvalidation struct {
+	sequence uint64
+	at       time.Time
+}
+
+func newTokenCache(ttl time.Duration, clk clock.Clock) *tokenCache {
+	return &tokenCache{ttl: ttl, clock: clk}
+}
+
+func tokenCacheKey(sessionID, provider, accountID string) string {
+	return sessionID + "\x00" + provider + "\x00" + accountID
+}
+
+// get returns a cached token that has not outlived the cache's TTL nor been invalidated
+func (c *tokenCache) get(sessionID, provider, accountID string) (*models.Token, bool) {
+	if c.ttl <= 0 {
+		return nil, false
+	}
+
+	key := tokenCacheKey(sessionID, provider, accountID)
+	value, exists := c.entries.Load(key)
+	if !exists {
+		return nil, false
+	}
+
+	entry := value.(*cachedToken)
+	if !c.clock.Now().Before(entry.expiresAt) || c.invalidatedSince(sessionID, entry.sequence) {
+		c.entries.CompareAndDelete(key, entry)
+		return nil, false
+	}
+	return entry.token, true
+}
+
+// startLookup is called before reading a token from the session store, which put is then given
+func (c *tokenCache) startLookup() tokenLookup {
+	return tokenLookup{sequence: c.sequence.Load(), startedAt: c.clock.Now()}
+}
+
+// put caches a token read by the lookup; it expires ttl after the lookup started
+func (c *tokenCache) put(sessionID, provider, accountID string, token *models.Token, lookup tokenLookup) {
+	if c.ttl <= 0 || c.invalidatedSince(sessionID, lookup.sequence) {
+		return
+	}
+
+	c.entries.Store(tokenCacheKey(sessionID, provider, accountID), &cachedToken{
+		token:     token,
+		sequence:  lookup.sequence,
+		expiresAt: lookup.startedAt.Add(c.ttl),
+	})
+
+	now := c.clock.Now()
+	if last := c.lastSweep.Load(); now.UnixNano()-last >= int64(tokenCacheSweepInterval) && c.lastSweep.CompareAndSwap(last, now.UnixNano()) {
+		c.sweep(now)
+	}
+}
+
+// invalidatedSince reports whether the session's tokens were invalidated after the lookup with the sequence number started
+func (c *tokenCache) invalidatedSince(sessionID string, sequence uint64) bool {
+	value, exists := c.invalidations.Load(sessionID)
+	return exists && value.(*tokenInvalidation).sequence > sequence
+}
+
+// sweep drops expired entries, which are otherwise only dropped when they are read again, and invalidations
+// older than ttl, as every entry of a lookup started before them has expired by then
+func (c *tokenCache) sweep(now time.Time) {
+	c.entries.Range(func(key, value any) bool {
+		if !now.Before(value.(*cachedToken).expiresAt) {
+			c.entries.CompareAndDelete(key, value)
+		}
+		return true
+	})
+	c.invalidations.Range(func(key, value any) bool {
+		if !now.Before(value.(*tokenInvalidation).at.Add(c.ttl)) {
+			c.invalidations.CompareAndDelete(key, value)
+		}
+		return true
+	})
+}
+
+// invalidateSession drops every cached token of the session, including the ones of lookups still under way
+func (c *tokenCache) invalidateSession(sessionID string) {
+	// Of invalidations racing each other, the latest one is kept
+	invalidation := &tokenInvalidation{sequence: c.sequence.Add(1), at: c.clock.Now()}
+	for {
+		value, loaded := c.invalidations.LoadOrStore(sessionID, invalidation)
+		if !loaded || value.(*tokenInvalidation).sequence > invalidation.sequence || c.invalidations.CompareAndSwap(sessionID, value, invalidation) {
+			break
+		}
+	}
+
+	prefix := sessionID + "\x00"
+	c.entries.Range(func(key, _ any) bool {
+		if strings.HasPrefix(key.(string), prefix) {
+			c.entries.Delete(key)
+		}
+		return true
+	})
+}
@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"all-me-backend/pkg/clock"
+	"all-me-backend/pkg/models"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingStore counts the token lookups reaching the store
+type countingStore struct {
+	Store
+	lookups atomic.Int64
+}
+
+func (c *countingStore) LookupSessionToken(sessionID, provider, accountID string) (*models.Token, error) {
+	c.lookups.Add(1)
+	return c.Store.LookupSessionToken(sessionID, provider, accountID)
+}
+
+// pausingStore holds its next token lookup after reading the token, until resumed
+type pausingStore struct {
+	Store
+	pause  atomic.Bool
+	looked chan struct{}
+	resume chan struct{}
+}
+
+func (p *pausingStore) LookupSessionToken(sessionID, provider, accountID string) (*models.Token, error) {
+	token, err := p.Store.LookupSessionToken(sessionID, provider, accountID)
+	if p.pause.CompareAndSwap(true, false) {
+		close(p.looked)
+		<-p.resume
+	}
+	return token, err
+}
+
+func createCachingTestService(t testing.TB, ttl time.Duration, clk clock.Clock) (*Service, *countingStore) {
+	store := &countingStore{Store: NewMemoryStore()}
+	service := NewServiceWithStore(&mockAuthProvider{provider: "googledrive"}, &mockAuthProvider{provider: "onedrive"},
+		&mockAuthProvider{provider: "dropbox"}, store)
+	service.tokens = newTokenCache(ttl, clk)
+
+	session := &models.UserSession{SessionID: "test-session"}
+	session.SetToken("onedrive", &models.Token{AccessToken: "access", Provider: "onedrive", ExpiresAt: time.Now().Add(time.Hour)})
+	if err := store.StoreSession(session); err != nil {
+		t.Fatalf("Failed to store session: %v", err)
+	}
+	return service, store
+}
+
+func TestGetSessionToken_CachesTokenBriefly(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	service, store := createCachingTestService(t, 2*time.Second, fake)
+
+	for range 10 {
+		if _, err := service.GetSessionToken("test-session", "onedrive", ""); err != nil {
+			t.Fatalf("GetSessionToken failed: %v", err)
+		}
+	}
+	if lookups := store.lookups.Load(); lookups != 1 {
+		t.Errorf("Expected a burst of lookups to reach the store once, got %d", lookups)
+	}
+
+	fake.Advance(2 * time.Second)
+	if _, err := service.GetSessionToken("test-session", "onedrive", ""); err != nil {
+		t.Fatalf("GetSessionToken failed: %v", err)
+	}
+	if lookups := store.lookups.Load(); lookups != 2 {
+		t.Errorf("Expected the token to be looked up again after the TTL, got %d lookups", lookups)
+	}
+
+	if err := service.SignOutProvider("test-session", "onedrive", ""); err != nil {
+		t.Fatalf("SignOutProvider failed: %v", err)
+	}
+	if _, err := service.GetSessionToken("test-session", "onedrive", ""); err == nil {
+		t.Error("Expected no token right after signing out")
+	}
+}
+
+func TestGetSessionToken_LookupFinishingAfterSignOutIsNotCached(t *testing.T) {
+	service, _ := createCachingTestService(t, 2*time.Second, clock.NewFake(time.Now()))
+	store := &pausingStore{Store: service.store, looked: make(chan struct{}), resume: make(chan struct{})}
+	service.store = store
+	store.pause.Store(true)
+
+	// The lookup reads the token, then the session signs out before the lookup caches it
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		service.GetSessionToken("test-session", "onedrive", "")
+	}()
+	<-store.looked
+
+	if err := service.SignOutProvider("test-session", "onedrive", ""); err != nil {
+		t.Fatalf("SignOutProvider failed: %v", err)
+	}
+	close(store.resume)
+	<-done
+
+	if token, err := service.GetSessionToken("test-session", "onedrive", ""); err == nil {
+		t.Errorf("Expected the signed out token to stay gone, got %+v", token)
+	}
+}
+
+func TestTokenCache_SweepDropsOldInvalidations(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	cache := newTokenCache(2*time.Second, fake)
+	cache.lastSweep.Store(fake.Now().UnixNano())
+
+	cache.invalidateSession("session-1")
+	fake.Advance(tokenCacheSweepInterval)
+	cache.put("session-2", "onedrive", "", &models.Token{AccessToken: "access"}, cache.startLookup())
+
+	if _, exists := cache.invalidations.Load("session-1"); exists {
+		t.Error("Expected the sweep to drop an invalidation older than the TTL")
+	}
+}
+
+func TestGetSessionToken_DisabledCacheReadsStore(t *testing.T) {
+	service, store := createCachingTestService(t, 0, clock.Real{})
+
+	for range 3 {
+		if _, err := service.GetSessionToken("test-session", "onedrive", ""); err != nil {
+			t.Fatalf("GetSessionToken failed: %v", err)
+		}
+	}
+	if lookups := store.lookups.Load(); lookups != 3 {
+		t.Errorf("Expected every lookup to reach the store, got %d", lookups)
+	}
+}
+
+// BenchmarkGetSessionToken_GalleryBurst looks up one session's token from many goroutines at once, as the
+// thumbnail requests of a gallery do, with and without the token cache
+func BenchmarkGetSessionToken_GalleryBurst(b *testing.B) {
+	for _, bench := range []struct {
+		name string
+		ttl  time.Duration
+	}{
+		{"uncached", 0},
+		{"cached", defaultTokenCacheTTL * time.Second},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			service, _ := createCachingTestService(b, bench.ttl, clock.Real{})
+			b.SetParallelism(8)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := service.GetSessionToken("test-session", "onedrive", ""); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
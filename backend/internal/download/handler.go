@@ -2,6 +2,7 @@ package download
 
 import (
 	"all-me-backend/pkg/models"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
@@ -23,11 +24,29 @@ func NewHandler(service *Service, sessionStore models.SessionStore) *Handler {
 
 func (h *Handler) RegisterRoutes(e *echo.Echo) {
 	e.POST("/downloads/zip", h.DownloadZip)
+	e.POST("/downloads/retry", h.RetryDownload)
 }
 
 // DownloadZip handles POST /downloads/zip
 // It streams multiple files as a ZIP archive directly to the response
 func (h *Handler) DownloadZip(c echo.Context) error {
+	return h.streamZip(c, "photos")
+}
+
+// RetryDownload handles POST /downloads/retry
+// It streams only the files that failed in a previous ZIP (taken from its error manifest) as a small ZIP
+func (h *Handler) RetryDownload(c echo.Context) error {
+	return h.streamZip(c, "photos-retry")
+}
+
+// streamZip validates a ZIP request and streams the archive to the response
+func (h *Handler) streamZip(c echo.Context, filenamePrefix string) error {
+	if h.service.NewJobsDisabled() {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{
+			"error": "Downloads are paused for maintenance. Please try again later.",
+		})
+	}
+
 	var req ZipRequest
 	if err := c.Bind(&req); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{
@@ -41,38 +60,71 @@ func (h *Handler) DownloadZip(c echo.Context) error {
 		})
 	}
 
+	if len(req.Files) > h.service.MaxZipFiles() {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": fmt.Sprintf("Too many files, a ZIP can contain at most %d files", h.service.MaxZipFiles()),
+		})
+	}
+
 	if req.SessionID == "" {
 		return c.JSON(http.StatusBadRequest, map[string]string{
 			"error": "Session ID is required",
 		})
 	}
 
-	if req.Provider == "" {
+	if !ValidCompression(req.Compression) {
 		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "Provider is required",
+			"error": fmt.Sprintf("Invalid compression '%s', expected '%s' or '%s'", req.Compression, CompressionStore, CompressionDeflate),
 		})
 	}
 
-	token, err := h.sessionStore.GetSessionToken(req.SessionID, req.Provider)
-	if err != nil {
-		return c.JSON(http.StatusUnauthorized, map[string]string{
-			"error": fmt.Sprintf("Authentication failed: %v", err),
+	// Without a request-level provider, files are routed by their own provider so that
+	// results mixing providers can be downloaded together
+	if err := ValidateFiles(req.Files, req.Provider); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": fmt.Sprintf("Invalid file: %v", err),
 		})
 	}
 
+	tokens := make(map[string]*models.Token)
+	for _, provider := range FileProviders(req.Files) {
+		accountID := ""
+		if provider == req.Provider {
+			accountID = req.AccountID
+		}
+
+		token, err := h.sessionStore.GetSessionToken(req.SessionID, provider, accountID)
+		if errors.Is(err, models.ErrAmbiguousAccount) {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": err.Error(),
+			})
+		}
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, map[string]string{
+				"error": fmt.Sprintf("Authentication failed: %v", err),
+			})
+		}
+		tokens[provider] = token
+	}
+
 	// Set appropriate headers for ZIP download
 	timestamp := time.Now().Format("20060102-150405")
-	filename := fmt.Sprintf("photos-%s.zip", timestamp)
+	filename := fmt.Sprintf("%s-%s.zip", filenamePrefix, timestamp)
 
 	c.Response().Header().Set("Content-Type", "application/zip")
 	c.Response().Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
 	c.Response().WriteHeader(http.StatusOK)
 
 	// Stream the ZIP archive directly to the response
-	if err := h.service.StreamZipArchive(c.Response().Writer, req.Files, token); err != nil {
+	failed, err := h.service.StreamZipArchive(c.Response().Writer, req.Files, tokens, req.Compression)
+	if err != nil {
 		c.Logger().Errorf("Failed to stream ZIP archive: %v", err)
 		return nil
 	}
 
+	if len(failed) > 0 {
+		c.Logger().Warnf("%d of %d files failed to download, listed in %s", len(failed), len(req.Files), ErrorManifestName)
+	}
+
 	return nil
 }
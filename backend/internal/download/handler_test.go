@@ -0,0 +1,31 @@
+package download
+
+import (
+	"all-me-backend/internal/maintenance"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestDownloadZip_RejectedWhileNewJobsDisabled(t *testing.T) {
+	service := NewService(&mockStorageService{})
+	service.drain = maintenance.NewSwitch(true)
+
+	e := echo.New()
+	NewHandler(service, nil).RegisterRoutes(e)
+
+	for _, path := range []string{"/downloads/zip", "/downloads/retry"} {
+		body := `{"session_id": "session-1", "provider": "googledrive", "files": [{"id": "file-1", "name": "a.jpg"}]}`
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("Expected 503 for %s, got %d", path, rec.Code)
+		}
+	}
+}
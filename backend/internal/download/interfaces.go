@@ -6,5 +6,6 @@ import (
 )
 
 type StorageService interface {
+	GetItem(item *models.CloudItem, token *models.Token) (*models.CloudItem, error)
 	GetFileStream(item *models.CloudItem, token *models.Token) (io.ReadCloser, error)
 }
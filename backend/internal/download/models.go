@@ -7,4 +7,26 @@ type ZipRequest struct {
 	Files     []*models.CloudItem `json:"files"`
 	SessionID string              `json:"session_id"`
 	Provider  string              `json:"provider"`
+	// AccountID selects which of the provider's accounts downloads the files, when several are signed in
+	// It applies to the files of Provider only, so it requires one
+	AccountID string `json:"account_id,omitempty"`
+	// Compression is CompressionStore or CompressionDeflate; empty stores images, which are already
+	// compressed, and deflates everything else
+	Compression string `json:"compression,omitempty"`
+}
+
+const (
+	CompressionStore   = "store"
+	CompressionDeflate = "deflate"
+)
+
+// FailedFile describes a file that could not be added to a ZIP archive
+type FailedFile struct {
+	File  *models.CloudItem `json:"file"`
+	Error string            `json:"error"`
+}
+
+// ErrorManifest is written into the ZIP archive when some files fail, so they can be retried
+type ErrorManifest struct {
+	Failed []FailedFile `json:"failed"`
 }
@@ -0,0 +1,115 @@
+package download
+
+import (
+	"all-me-backend/pkg/models"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	defaultPrefetchDepth       = 4
+	defaultPrefetchMemoryBytes = 8 * 1024 * 1024 // 8MB
+)
+
+// prefetchedFile is a downloaded file waiting to be written to the ZIP archive
+type prefetchedFile struct {
+	resolved *models.CloudItem
+	content  io.ReadCloser
+	err      error
+}
+
+// prefetchFiles downloads files ahead of the ZIP writer, at most each provider's prefetch depth at
+// a time, and delivers them in their original order on the returned channels
+// The caller must receive from every channel, close each file's content and release its index,
+// which frees the slot of the file's provider
+func (s *Service) prefetchFiles(files []*models.CloudItem, tokens map[string]*models.Token) ([]chan prefetchedFile, func(int)) {
+	results := make([]chan prefetchedFile, len(files))
+	for i := range results {
+		results[i] = make(chan prefetchedFile, 1)
+	}
+
+	slots := make(map[string]chan struct{})
+	for _, provider := range FileProviders(files) {
+		slots[provider] = make(chan struct{}, max(s.prefetchDepthFor(provider), 1))
+	}
+
+	go func() {
+		for i, file := range files {
+			slots[file.Provider] <- struct{}{}
+			go func() {
+				token, exists := tokens[file.Provider]
+				if !exists || token == nil {
+					results[i] <- prefetchedFile{err: fmt.Errorf("not connected to provider '%s'", file.Provider)}
+					return
+				}
+				results[i] <- s.fetchFile(file, token)
+			}()
+		}
+	}()
+
+	release := func(i int) { <-slots[files[i].Provider] }
+	return results, release
+}
+
+// fetchFile downloads a file completely, keeping it in memory when small and spooling it to a
+// temporary file otherwise
+func (s *Service) fetchFile(file *models.CloudItem, token *models.Token) prefetchedFile {
+	resolved, stream, err := s.getFileStreamWithRetry(file, token)
+	if err != nil {
+		return prefetchedFile{err: fmt.Errorf("failed to get file stream: %w", err)}
+	}
+	defer stream.Close()
+
+	content, err := spool(stream, s.prefetchMemoryBytes)
+	if err != nil {
+		return prefetchedFile{err: fmt.Errorf("failed to download file: %w", err)}
+	}
+
+	return prefetchedFile{resolved: resolved, content: content}
+}
+
+// spool reads a stream to the end, into memory when it fits in memoryLimit bytes or into a
+// temporary file that is removed on Close otherwise
+func spool(stream io.Reader, memoryLimit int64) (io.ReadCloser, error) {
+	var buf bytes.Buffer
+	_, err := io.CopyN(&buf, stream, memoryLimit+1)
+	if errors.Is(err, io.EOF) {
+		return io.NopCloser(&buf), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp("", "allme-zip-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	spooled := &tempFile{File: tmp}
+
+	if _, err := io.Copy(tmp, io.MultiReader(&buf, stream)); err != nil {
+		spooled.Close()
+		return nil, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		spooled.Close()
+		return nil, err
+	}
+
+	return spooled, nil
+}
+
+// tempFile is a temporary file that is deleted when closed
+type tempFile struct {
+	*os.File
+}
+
+func (t *tempFile) Close() error {
+	err := t.File.Close()
+	if removeErr := os.Remove(t.File.Name()); err == nil {
+		err = removeErr
+	}
+	return err
+}
@@ -1,58 +1,280 @@
 package download
 
 import (
+	"all-me-backend/internal/maintenance"
+	"all-me-backend/pkg/config"
 	"all-me-backend/pkg/models"
 	"archive/zip"
+	"encoding/json"
 	"fmt"
 	"io"
+	"path"
+	"regexp"
+	"strings"
+	"time"
 )
 
+const (
+	// ErrorManifestName is the ZIP entry listing files that failed to download
+	ErrorManifestName = "download-errors.json"
+
+	maxDownloadAttempts = 3
+	defaultMaxZipFiles  = 1000
+)
+
+// validItemID matches the ID formats used by the supported providers (e.g. "1a2B_c-3", "ABC123!456")
+var validItemID = regexp.MustCompile(`^[A-Za-z0-9!_.\-]{1,256}$`)
+
 type Service struct {
 	storageService StorageService
+	retryBackoff   time.Duration
+	maxZipFiles    int
+
+	// prefetchDepth is how many files each provider downloads ahead of the ZIP writer
+	prefetchDepth map[string]int
+	// prefetchMemoryBytes is the largest prefetched file kept in memory, larger ones go to temporary files
+	prefetchMemoryBytes int64
+	// drain rejects new ZIP downloads while the server drains, shared with the face service
+	drain *maintenance.Switch
 }
 
 func NewService(storageService StorageService) *Service {
+	maxZipFiles := config.GetInt("DOWNLOAD_MAX_ZIP_FILES", defaultMaxZipFiles)
+	if maxZipFiles < 1 {
+		maxZipFiles = defaultMaxZipFiles
+	}
+
+	prefetchMemory := config.GetInt("DOWNLOAD_PREFETCH_MEMORY_BYTES", defaultPrefetchMemoryBytes)
+	if prefetchMemory < 0 {
+		prefetchMemory = defaultPrefetchMemoryBytes
+	}
+
 	return &Service{
 		storageService: storageService,
+		retryBackoff:   500 * time.Millisecond,
+		maxZipFiles:    maxZipFiles,
+		prefetchDepth: map[string]int{
+			"googledrive": prefetchDepthFromEnv("GOOGLEDRIVE_DOWNLOAD_PREFETCH"),
+			"onedrive":    prefetchDepthFromEnv("ONEDRIVE_DOWNLOAD_PREFETCH"),
+			"dropbox":     prefetchDepthFromEnv("DROPBOX_DOWNLOAD_PREFETCH"),
+		},
+		prefetchMemoryBytes: int64(prefetchMemory),
+		drain:               maintenance.Shared(),
+	}
+}
+
+// NewJobsDisabled reports whether new ZIP downloads are currently rejected
+func (s *Service) NewJobsDisabled() bool {
+	return s.drain.On()
+}
+
+// prefetchDepthFromEnv reads a provider's prefetch depth, where 1 downloads files one at a time
+func prefetchDepthFromEnv(key string) int {
+	depth := config.GetInt(key, defaultPrefetchDepth)
+	if depth < 1 {
+		return defaultPrefetchDepth
+	}
+	return depth
+}
+
+// prefetchDepthFor returns the prefetch depth for a provider, falling back to the default
+func (s *Service) prefetchDepthFor(provider string) int {
+	if depth, exists := s.prefetchDepth[provider]; exists {
+		return depth
 	}
+	return defaultPrefetchDepth
+}
+
+// MaxZipFiles returns the largest number of files allowed in a single ZIP download
+func (s *Service) MaxZipFiles() int {
+	return s.maxZipFiles
+}
+
+// ArchiveFormats returns the archive formats downloads are packaged in
+func (s *Service) ArchiveFormats() []string {
+	return []string{"zip"}
+}
+
+// ValidateFiles checks that every requested file belongs to the given provider and has a well-formed ID
+// An empty provider allows files from different providers, as long as each names its own
+// Client-supplied URLs are never used, so provider and ID are all that need to be trusted
+func ValidateFiles(files []*models.CloudItem, provider string) error {
+	for i, file := range files {
+		if file == nil {
+			return fmt.Errorf("file %d is empty", i)
+		}
+
+		if provider == "" && file.Provider == "" {
+			return fmt.Errorf("file %d has no provider", i)
+		}
+
+		if provider != "" && file.Provider != provider {
+			return fmt.Errorf("file %d has provider '%s', expected '%s'", i, file.Provider, provider)
+		}
+
+		if !validItemID.MatchString(file.ID) {
+			return fmt.Errorf("file %d has an invalid ID", i)
+		}
+
+		if file.DriveID != "" && !validItemID.MatchString(file.DriveID) {
+			return fmt.Errorf("file %d has an invalid drive ID", i)
+		}
+
+		if file.IsFolder {
+			return fmt.Errorf("file %d is a folder", i)
+		}
+	}
+
+	return nil
+}
+
+// ValidCompression reports whether a ZIP request's compression is one the archive can be written with
+func ValidCompression(compression string) bool {
+	return compression == "" || compression == CompressionStore || compression == CompressionDeflate
+}
+
+// zipMethod returns the ZIP compression method for a file
+// Without an explicit compression, images are stored as they are, since deflating them only costs CPU
+func zipMethod(compression, mimeType string) uint16 {
+	switch compression {
+	case CompressionStore:
+		return zip.Store
+	case CompressionDeflate:
+		return zip.Deflate
+	}
+
+	if strings.HasPrefix(mimeType, "image/") {
+		return zip.Store
+	}
+	return zip.Deflate
+}
+
+// FileProviders returns the distinct providers of the files, in the order they first appear
+func FileProviders(files []*models.CloudItem) []string {
+	var providers []string
+	seen := make(map[string]bool)
+	for _, file := range files {
+		if !seen[file.Provider] {
+			seen[file.Provider] = true
+			providers = append(providers, file.Provider)
+		}
+	}
+	return providers
 }
 
 // StreamZipArchive streams multiple files into a ZIP archive directly to the writer
-// It downloads files from cloud storage and adds them to the ZIP without temporary storage
-func (s *Service) StreamZipArchive(writer io.Writer, files []*models.CloudItem, token *models.Token) error {
+// Each file is downloaded with the token of its own provider, so one archive can mix providers
+// The next few files are downloaded in parallel while the current one is written, keeping the archive order
+// Files that fail are listed in an error manifest inside the archive and returned to the caller
+// compression selects how entries are compressed, see ZipRequest.Compression
+func (s *Service) StreamZipArchive(writer io.Writer, files []*models.CloudItem, tokens map[string]*models.Token, compression string) ([]FailedFile, error) {
 	zipWriter := zip.NewWriter(writer)
 	defer zipWriter.Close()
 
-	for _, file := range files {
-		if err := s.addFileToZip(zipWriter, file, token); err != nil {
+	results, release := s.prefetchFiles(files, tokens)
+
+	// The error manifest's name is taken up front, so a file with the same name can't collide with it
+	usedNames := map[string]bool{strings.ToLower(ErrorManifestName): true}
+
+	var failed []FailedFile
+	for i, file := range files {
+		prefetched := <-results[i]
+		err := prefetched.err
+		if err == nil {
+			name := uniqueEntryName(prefetched.resolved.Name, usedNames)
+			method := zipMethod(compression, prefetched.resolved.MimeType)
+			err = addFileToZip(zipWriter, name, method, prefetched.content)
+			prefetched.content.Close()
+		}
+		release(i)
+
+		if err != nil {
 			// Continue with other files even if one fails
-			continue
+			failed = append(failed, FailedFile{File: file, Error: err.Error()})
 		}
 	}
 
-	return nil
+	if len(failed) > 0 {
+		if err := writeErrorManifest(zipWriter, failed); err != nil {
+			return failed, err
+		}
+	}
+
+	return failed, nil
 }
 
-// addFileToZip downloads a file from cloud storage and adds it to the ZIP archive
-func (s *Service) addFileToZip(zipWriter *zip.Writer, file *models.CloudItem, token *models.Token) error {
-	// Get file stream from cloud storage
-	fileStream, err := s.storageService.GetFileStream(file, token)
-	if err != nil {
-		return fmt.Errorf("failed to get file stream: %w", err)
+// uniqueEntryName returns the name a file is added to the archive under and marks it as used
+// Files from different folders often share a name, like IMG_0001.jpg, so later ones are numbered
+// before the extension ("IMG_0001 (1).jpg") rather than overwriting earlier ones when extracted
+// Names are compared case-insensitively, as they would collide on case-insensitive file systems
+func uniqueEntryName(name string, used map[string]bool) string {
+	unique := name
+	ext := path.Ext(name)
+	for n := 1; used[strings.ToLower(unique)]; n++ {
+		unique = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), n, ext)
 	}
-	defer fileStream.Close()
 
+	used[strings.ToLower(unique)] = true
+	return unique
+}
+
+// addFileToZip adds a downloaded file to the ZIP archive
+func addFileToZip(zipWriter *zip.Writer, name string, method uint16, content io.Reader) error {
 	// Create a new file entry in the ZIP archive
-	zipFile, err := zipWriter.Create(file.Name)
+	zipFile, err := zipWriter.CreateHeader(&zip.FileHeader{Name: name, Method: method})
 	if err != nil {
 		return fmt.Errorf("failed to create ZIP entry: %w", err)
 	}
 
 	// Copy the file content to the ZIP archive
-	_, err = io.Copy(zipFile, fileStream)
+	_, err = io.Copy(zipFile, content)
 	if err != nil {
 		return fmt.Errorf("failed to write file to ZIP: %w", err)
 	}
 
 	return nil
 }
+
+// getFileStreamWithRetry re-resolves a file from the provider by ID and opens its stream,
+// retrying transient failures with exponential backoff
+// The client-supplied item is only used for its provider and ID, so its URLs are never fetched
+// Retries happen before the ZIP entry is created, since a partially written entry can't be rewound
+func (s *Service) getFileStreamWithRetry(file *models.CloudItem, token *models.Token) (*models.CloudItem, io.ReadCloser, error) {
+	backoff := s.retryBackoff
+
+	var lastErr error
+	for attempt := 1; attempt <= maxDownloadAttempts; attempt++ {
+		resolved, err := s.storageService.GetItem(file, token)
+		if err == nil {
+			var stream io.ReadCloser
+			stream, err = s.storageService.GetFileStream(resolved, token)
+			if err == nil {
+				return resolved, stream, nil
+			}
+		}
+		lastErr = err
+
+		if attempt < maxDownloadAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+
+	return nil, nil, lastErr
+}
+
+// writeErrorManifest adds a JSON manifest of failed files to the ZIP archive
+func writeErrorManifest(zipWriter *zip.Writer, failed []FailedFile) error {
+	manifestFile, err := zipWriter.Create(ErrorManifestName)
+	if err != nil {
+		return fmt.Errorf("failed to create error manifest: %w", err)
+	}
+
+	encoder := json.NewEncoder(manifestFile)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(ErrorManifest{Failed: failed}); err != nil {
+		return fmt.Errorf("failed to write error manifest: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,442 @@
+package download
+
+import (
+	"all-me-backend/pkg/models"
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func createTestService(storage StorageService) *Service {
+	service := NewService(storage)
+	service.retryBackoff = 0
+	return service
+}
+
+// tokensFor returns a token for each of the given providers, keyed by provider
+func tokensFor(providers ...string) map[string]*models.Token {
+	tokens := make(map[string]*models.Token)
+	for _, provider := range providers {
+		tokens[provider] = &models.Token{Provider: provider, AccessToken: provider + "-token"}
+	}
+	return tokens
+}
+
+func readZipEntries(t *testing.T, data []byte) map[string][]byte {
+	t.Helper()
+
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("Failed to open ZIP: %v", err)
+	}
+
+	entries := make(map[string][]byte)
+	for _, file := range reader.File {
+		rc, err := file.Open()
+		if err != nil {
+			t.Fatalf("Failed to open ZIP entry %s: %v", file.Name, err)
+		}
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		entries[file.Name] = content
+	}
+
+	return entries
+}
+
+func TestStreamZipArchive_RetriesTransientFailures(t *testing.T) {
+	storage := &mockStorageService{failuresBeforeSuccess: map[string]int{"flaky": 2}}
+	service := createTestService(storage)
+
+	files := []*models.CloudItem{{ID: "flaky", Name: "flaky.jpg", Provider: "googledrive"}}
+
+	var buf bytes.Buffer
+	failed, err := service.StreamZipArchive(&buf, files, tokensFor("googledrive"), "")
+	if err != nil {
+		t.Fatalf("StreamZipArchive failed: %v", err)
+	}
+
+	if len(failed) != 0 {
+		t.Errorf("Expected no failed files after retries, got %d", len(failed))
+	}
+
+	entries := readZipEntries(t, buf.Bytes())
+	if string(entries["flaky.jpg"]) != "content-flaky" {
+		t.Errorf("Expected flaky.jpg in archive, got entries %v", entries)
+	}
+
+	if _, exists := entries[ErrorManifestName]; exists {
+		t.Error("Expected no error manifest when all files succeed")
+	}
+}
+
+func TestStreamZipArchive_WritesErrorManifest(t *testing.T) {
+	storage := &mockStorageService{failuresBeforeSuccess: map[string]int{"broken": maxDownloadAttempts}}
+	service := createTestService(storage)
+
+	files := []*models.CloudItem{
+		{ID: "ok", Name: "ok.jpg", Provider: "googledrive"},
+		{ID: "broken", Name: "broken.jpg", Provider: "googledrive"},
+	}
+
+	var buf bytes.Buffer
+	failed, err := service.StreamZipArchive(&buf, files, tokensFor("googledrive"), "")
+	if err != nil {
+		t.Fatalf("StreamZipArchive failed: %v", err)
+	}
+
+	if len(failed) != 1 || failed[0].File.ID != "broken" {
+		t.Fatalf("Expected broken.jpg to fail, got %+v", failed)
+	}
+
+	entries := readZipEntries(t, buf.Bytes())
+	if _, exists := entries["ok.jpg"]; !exists {
+		t.Error("Expected ok.jpg in archive")
+	}
+
+	var manifest ErrorManifest
+	if err := json.Unmarshal(entries[ErrorManifestName], &manifest); err != nil {
+		t.Fatalf("Failed to parse error manifest: %v", err)
+	}
+
+	if len(manifest.Failed) != 1 || manifest.Failed[0].File.Name != "broken.jpg" {
+		t.Errorf("Expected manifest to list broken.jpg, got %+v", manifest.Failed)
+	}
+
+	if storage.attempts["broken"] != maxDownloadAttempts {
+		t.Errorf("Expected %d attempts, got %d", maxDownloadAttempts, storage.attempts["broken"])
+	}
+}
+
+func TestStreamZipArchive_IgnoresClientDownloadURL(t *testing.T) {
+	storage := &mockStorageService{}
+	service := createTestService(storage)
+
+	files := []*models.CloudItem{{
+		ID:          "photo",
+		Name:        "../../evil.jpg",
+		DownloadURL: "http://169.254.169.254/latest/meta-data",
+		Provider:    "googledrive",
+	}}
+
+	var buf bytes.Buffer
+	if _, err := service.StreamZipArchive(&buf, files, tokensFor("googledrive"), ""); err != nil {
+		t.Fatalf("StreamZipArchive failed: %v", err)
+	}
+
+	if len(storage.streamedURLs) != 1 || storage.streamedURLs[0] != "https://provider.example/photo" {
+		t.Errorf("Expected server-resolved download URL, got %v", storage.streamedURLs)
+	}
+
+	entries := readZipEntries(t, buf.Bytes())
+	if _, exists := entries["photo.jpg"]; !exists {
+		t.Errorf("Expected entry named from resolved item, got entries %v", entries)
+	}
+}
+
+func TestStreamZipArchive_PrefetchesInOrder(t *testing.T) {
+	storage := &mockStorageService{delays: map[string]time.Duration{
+		"f0": 60 * time.Millisecond,
+		"f1": 20 * time.Millisecond,
+		"f2": 40 * time.Millisecond,
+		"f3": 20 * time.Millisecond,
+		"f4": 20 * time.Millisecond,
+		"f5": 20 * time.Millisecond,
+	}}
+	service := createTestService(storage)
+	service.prefetchDepth["googledrive"] = 3
+
+	var files []*models.CloudItem
+	for i := 0; i < 6; i++ {
+		files = append(files, &models.CloudItem{ID: fmt.Sprintf("f%d", i), Provider: "googledrive"})
+	}
+
+	var buf bytes.Buffer
+	failed, err := service.StreamZipArchive(&buf, files, tokensFor("googledrive"), "")
+	if err != nil || len(failed) != 0 {
+		t.Fatalf("StreamZipArchive failed: %v (failed files: %v)", err, failed)
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Failed to open ZIP: %v", err)
+	}
+
+	var names []string
+	for _, file := range reader.File {
+		names = append(names, file.Name)
+	}
+	if strings.Join(names, ",") != "f0.jpg,f1.jpg,f2.jpg,f3.jpg,f4.jpg,f5.jpg" {
+		t.Errorf("Expected entries in request order, got %v", names)
+	}
+
+	if storage.maxActive < 2 || storage.maxActive > 3 {
+		t.Errorf("Expected between 2 and 3 concurrent downloads, got %d", storage.maxActive)
+	}
+}
+
+func TestStreamZipArchive_PrefetchDepthOneIsSequential(t *testing.T) {
+	storage := &mockStorageService{delays: map[string]time.Duration{"a": 10 * time.Millisecond, "b": 10 * time.Millisecond}}
+	service := createTestService(storage)
+	service.prefetchDepth["onedrive"] = 1
+
+	files := []*models.CloudItem{{ID: "a", Provider: "onedrive"}, {ID: "b", Provider: "onedrive"}}
+
+	var buf bytes.Buffer
+	if _, err := service.StreamZipArchive(&buf, files, tokensFor("onedrive"), ""); err != nil {
+		t.Fatalf("StreamZipArchive failed: %v", err)
+	}
+
+	if storage.maxActive != 1 {
+		t.Errorf("Expected one download at a time, got %d", storage.maxActive)
+	}
+}
+
+func TestSpool(t *testing.T) {
+	small, err := spool(strings.NewReader("tiny"), 16)
+	if err != nil {
+		t.Fatalf("spool failed: %v", err)
+	}
+	if _, isTemp := small.(*tempFile); isTemp {
+		t.Error("Expected small content to stay in memory")
+	}
+	if content, _ := io.ReadAll(small); string(content) != "tiny" {
+		t.Errorf("Expected content 'tiny', got %q", content)
+	}
+	small.Close()
+
+	large := strings.Repeat("x", 100)
+	spooled, err := spool(strings.NewReader(large), 16)
+	if err != nil {
+		t.Fatalf("spool failed: %v", err)
+	}
+	temp, isTemp := spooled.(*tempFile)
+	if !isTemp {
+		t.Fatal("Expected large content to be spooled to a temporary file")
+	}
+	if content, _ := io.ReadAll(spooled); string(content) != large {
+		t.Errorf("Expected %d bytes of content, got %d", len(large), len(content))
+	}
+
+	spooled.Close()
+	if _, err := os.Stat(temp.Name()); !os.IsNotExist(err) {
+		t.Errorf("Expected temporary file to be removed on close, got %v", err)
+	}
+}
+
+func TestValidateFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    *models.CloudItem
+		wantErr bool
+	}{
+		{"google drive ID", &models.CloudItem{ID: "1a2B_c-3", Provider: "googledrive"}, false},
+		{"onedrive style ID", &models.CloudItem{ID: "ABC123!456", DriveID: "b!xYz_1", Provider: "googledrive"}, false},
+		{"provider mismatch", &models.CloudItem{ID: "abc", Provider: "onedrive"}, true},
+		{"empty ID", &models.CloudItem{Provider: "googledrive"}, true},
+		{"path in ID", &models.CloudItem{ID: "../me/drive", Provider: "googledrive"}, true},
+		{"URL in drive ID", &models.CloudItem{ID: "abc", DriveID: "http://evil", Provider: "googledrive"}, true},
+		{"folder", &models.CloudItem{ID: "abc", Provider: "googledrive", IsFolder: true}, true},
+		{"nil item", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateFiles([]*models.CloudItem{tt.file}, "googledrive")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error: %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateFiles_MixedProviders(t *testing.T) {
+	files := []*models.CloudItem{
+		{ID: "drive-photo", Provider: "googledrive"},
+		{ID: "onedrive-photo", Provider: "onedrive"},
+	}
+	if err := ValidateFiles(files, ""); err != nil {
+		t.Errorf("Expected mixed providers to be valid without a request provider, got %v", err)
+	}
+	if err := ValidateFiles(files, "googledrive"); err == nil {
+		t.Error("Expected error for mixed providers when a request provider is given")
+	}
+
+	if err := ValidateFiles([]*models.CloudItem{{ID: "abc"}}, ""); err == nil {
+		t.Error("Expected error for file without a provider")
+	}
+
+	providers := FileProviders(files)
+	if len(providers) != 2 || providers[0] != "googledrive" || providers[1] != "onedrive" {
+		t.Errorf("Expected [googledrive onedrive], got %v", providers)
+	}
+}
+
+func TestStreamZipArchive_StoresImagesByDefault(t *testing.T) {
+	files := []*models.CloudItem{
+		{ID: "photo", Provider: "googledrive", MimeType: "image/jpeg"},
+		{ID: "notes", Provider: "googledrive", MimeType: "text/plain"},
+	}
+
+	tests := map[string]map[string]uint16{
+		"":                 {"photo.jpg": zip.Store, "notes.jpg": zip.Deflate},
+		CompressionStore:   {"photo.jpg": zip.Store, "notes.jpg": zip.Store},
+		CompressionDeflate: {"photo.jpg": zip.Deflate, "notes.jpg": zip.Deflate},
+	}
+
+	for compression, expected := range tests {
+		var buf bytes.Buffer
+		if _, err := createTestService(&mockStorageService{}).StreamZipArchive(&buf, files, tokensFor("googledrive"), compression); err != nil {
+			t.Fatalf("StreamZipArchive failed: %v", err)
+		}
+
+		reader, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatalf("Failed to open ZIP: %v", err)
+		}
+		for _, file := range reader.File {
+			if file.Method != expected[file.Name] {
+				t.Errorf("Compression %q: expected method %d for %s, got %d", compression, expected[file.Name], file.Name, file.Method)
+			}
+		}
+	}
+}
+
+func TestStreamZipArchive_RoutesFilesByProvider(t *testing.T) {
+	storage := &mockStorageService{}
+	service := createTestService(storage)
+
+	files := []*models.CloudItem{
+		{ID: "drive-photo", Provider: "googledrive"},
+		{ID: "onedrive-photo", Provider: "onedrive"},
+		{ID: "dropbox-photo", Provider: "dropbox"},
+	}
+
+	var buf bytes.Buffer
+	failed, err := service.StreamZipArchive(&buf, files, tokensFor("googledrive", "onedrive"), "")
+	if err != nil {
+		t.Fatalf("StreamZipArchive failed: %v", err)
+	}
+
+	if storage.tokenProviders["drive-photo"] != "googledrive" {
+		t.Errorf("Expected drive-photo to use the googledrive token, got %q", storage.tokenProviders["drive-photo"])
+	}
+	if storage.tokenProviders["onedrive-photo"] != "onedrive" {
+		t.Errorf("Expected onedrive-photo to use the onedrive token, got %q", storage.tokenProviders["onedrive-photo"])
+	}
+
+	if len(failed) != 1 || failed[0].File.ID != "dropbox-photo" {
+		t.Fatalf("Expected only the file without a token to fail, got %+v", failed)
+	}
+
+	entries := readZipEntries(t, buf.Bytes())
+	for _, name := range []string{"drive-photo.jpg", "onedrive-photo.jpg", ErrorManifestName} {
+		if _, exists := entries[name]; !exists {
+			t.Errorf("Expected %s in archive, got entries %v", name, entries)
+		}
+	}
+}
+
+func TestStreamZipArchive_NumbersDuplicateNames(t *testing.T) {
+	storage := &mockStorageService{names: map[string]string{
+		"a": "IMG_0001.jpg",
+		"b": "IMG_0001.jpg",
+		"c": "img_0001.JPG",
+		"d": "IMG_0001 (1).jpg",
+		"e": ErrorManifestName,
+	}}
+	service := createTestService(storage)
+
+	var files []*models.CloudItem
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		files = append(files, &models.CloudItem{ID: id, Provider: "googledrive"})
+	}
+
+	var buf bytes.Buffer
+	if _, err := service.StreamZipArchive(&buf, files, tokensFor("googledrive"), ""); err != nil {
+		t.Fatalf("StreamZipArchive failed: %v", err)
+	}
+
+	expected := map[string]string{
+		"IMG_0001.jpg":             "content-a",
+		"IMG_0001 (1).jpg":         "content-b",
+		"img_0001 (2).JPG":         "content-c",
+		"IMG_0001 (1) (1).jpg":     "content-d",
+		"download-errors (1).json": "content-e",
+	}
+	entries := readZipEntries(t, buf.Bytes())
+	if len(entries) != len(expected) {
+		t.Errorf("Expected %d distinct entries, got %v", len(expected), entries)
+	}
+	for name, content := range expected {
+		if string(entries[name]) != content {
+			t.Errorf("Expected %s to hold %q, got %q", name, content, entries[name])
+		}
+	}
+}
+
+// mockStorageService is a test implementation of StorageService
+type mockStorageService struct {
+	mu                    sync.Mutex
+	failuresBeforeSuccess map[string]int
+	attempts              map[string]int
+	streamedURLs          []string
+	tokenProviders        map[string]string // item ID -> provider of the token it was streamed with
+
+	delays    map[string]time.Duration // item ID -> time taken to open its stream
+	names     map[string]string        // item ID -> file name, which defaults to the ID with a .jpg extension
+	active    int
+	maxActive int
+}
+
+func (m *mockStorageService) GetItem(item *models.CloudItem, token *models.Token) (*models.CloudItem, error) {
+	name, exists := m.names[item.ID]
+	if !exists {
+		name = item.ID + ".jpg"
+	}
+
+	return &models.CloudItem{
+		ID:          item.ID,
+		Name:        name,
+		MimeType:    item.MimeType,
+		DownloadURL: "https://provider.example/" + item.ID,
+	}, nil
+}
+
+func (m *mockStorageService) GetFileStream(item *models.CloudItem, token *models.Token) (io.ReadCloser, error) {
+	m.mu.Lock()
+	m.active++
+	m.maxActive = max(m.maxActive, m.active)
+	delay := m.delays[item.ID]
+	m.mu.Unlock()
+
+	time.Sleep(delay)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.active--
+
+	if m.attempts == nil {
+		m.attempts = make(map[string]int)
+	}
+	m.attempts[item.ID]++
+	m.streamedURLs = append(m.streamedURLs, item.DownloadURL)
+	if m.tokenProviders == nil {
+		m.tokenProviders = make(map[string]string)
+	}
+	m.tokenProviders[item.ID] = token.Provider
+
+	if m.attempts[item.ID] <= m.failuresBeforeSuccess[item.ID] {
+		return nil, errors.New("transient provider error")
+	}
+
+	return io.NopCloser(bytes.NewReader([]byte("content-" + item.ID))), nil
+}
@@ -0,0 +1,28 @@
+package face
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Aggregation selects how an image's faces are compared when several reference images are registered
+type Aggregation string
+
+const (
+	// AggregationAny matches a face within the threshold of any single reference
+	AggregationAny Aggregation = "any"
+	// AggregationMean matches a face against the mean of the reference encodings
+	AggregationMean Aggregation = "mean"
+)
+
+// ParseAggregation parses an aggregation mode ("any" or "mean"), defaulting to any
+func ParseAggregation(value string) (Aggregation, error) {
+	switch Aggregation(strings.ToLower(strings.TrimSpace(value))) {
+	case "", AggregationAny:
+		return AggregationAny, nil
+	case AggregationMean:
+		return AggregationMean, nil
+	default:
+		return "", fmt.Errorf("unknown aggregation %q, expected any or mean", value)
+	}
+}
@@ -0,0 +1,71 @@
+package face
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+const (
+	defaultMatchPageSize = 100
+	maxMatchPageSize     = 1000
+	defaultCursorTTL     = 60 // minutes
+)
+
+// MatchPage selects a page of a job's matches; a zero MatchPage returns every match
+type MatchPage struct {
+	Size   int
+	Cursor string
+}
+
+// matchCursor marks where the next page of matches starts
+// Matches are ordered by image index, so a page resumes after the last index returned rather than at an offset
+type matchCursor struct {
+	JobID     string `json:"j"`
+	After     int    `json:"a"`
+	ExpiresAt int64  `json:"e"`
+}
+
+// encodeCursor serializes a cursor and signs it so clients cannot forge positions or reuse it for another job
+func encodeCursor(key []byte, cursor matchCursor) string {
+	payload, _ := json.Marshal(cursor)
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(signCursor(key, encoded))
+}
+
+// decodeCursor verifies a cursor's signature and expiry and that it belongs to jobID
+func decodeCursor(key []byte, token, jobID string, now time.Time) (matchCursor, error) {
+	encoded, signature, found := strings.Cut(token, ".")
+	if !found {
+		return matchCursor{}, ErrInvalidCursor
+	}
+
+	expected, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(expected, signCursor(key, encoded)) {
+		return matchCursor{}, ErrInvalidCursor
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return matchCursor{}, ErrInvalidCursor
+	}
+
+	var cursor matchCursor
+	if err := json.Unmarshal(payload, &cursor); err != nil {
+		return matchCursor{}, ErrInvalidCursor
+	}
+	if cursor.JobID != jobID || now.Unix() > cursor.ExpiresAt {
+		return matchCursor{}, ErrInvalidCursor
+	}
+
+	return cursor, nil
+}
+
+func signCursor(key []byte, encoded string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(encoded))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,112 @@
+package face
+
+import (
+	"all-me-backend/pkg/clock"
+	"all-me-backend/pkg/models"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetJobStatus_PagesMatchesWithCursor(t *testing.T) {
+	service := createTestService(&mockStorageService{}, "")
+
+	images := make([]*models.CloudItem, 8)
+	for i := range images {
+		images[i] = &models.CloudItem{ID: fmt.Sprintf("img-%d", i)}
+	}
+	service.jobManager.Store("job-1", "session-1", images, nil, compareOptions{})
+	service.jobManager.MarkCompleted("job-1", []pythonMatchResult{
+		{Index: 1}, {Index: 2}, {Index: 4}, {Index: 6}, {Index: 7},
+	})
+
+	var pages []string
+	page := MatchPage{Size: 2}
+	for {
+		status, err := service.GetJobStatus("job-1", false, page)
+		if err != nil {
+			t.Fatalf("GetJobStatus failed: %v", err)
+		}
+
+		var ids []string
+		for _, match := range status.Matches {
+			ids = append(ids, match.ID)
+		}
+		pages = append(pages, strings.Join(ids, ","))
+
+		if status.NextCursor == "" {
+			break
+		}
+		page.Cursor = status.NextCursor
+	}
+
+	expected := "img-1,img-2|img-4,img-6|img-7"
+	if strings.Join(pages, "|") != expected {
+		t.Errorf("Expected pages %s, got %s", expected, strings.Join(pages, "|"))
+	}
+}
+
+func TestGetJobStatus_CursorExpiresWithServiceClock(t *testing.T) {
+	fakeClock := clock.NewFake(time.Now())
+	service := createTestService(&mockStorageService{}, "")
+	service.jobManager = NewJobManagerWithClock(fakeClock)
+
+	images := []*models.CloudItem{{ID: "img-0"}, {ID: "img-1"}, {ID: "img-2"}}
+	service.jobManager.Store("job-1", "session-1", images, nil, compareOptions{})
+	service.jobManager.MarkCompleted("job-1", []pythonMatchResult{{Index: 0}, {Index: 1}, {Index: 2}})
+
+	status, err := service.GetJobStatus("job-1", false, MatchPage{Size: 1})
+	if err != nil || status.NextCursor == "" {
+		t.Fatalf("Expected a first page with a cursor, got %+v, %v", status, err)
+	}
+
+	fakeClock.Advance(service.cursorTTL - time.Second)
+	if _, err := service.GetJobStatus("job-1", false, MatchPage{Size: 1, Cursor: status.NextCursor}); err != nil {
+		t.Errorf("Expected the cursor to be valid within its TTL, got %v", err)
+	}
+
+	fakeClock.Advance(2 * time.Second)
+	if _, err := service.GetJobStatus("job-1", false, MatchPage{Size: 1, Cursor: status.NextCursor}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("Expected ErrInvalidCursor once the cursor's TTL passed, got %v", err)
+	}
+}
+
+func TestDecodeCursor_RejectsInvalidCursors(t *testing.T) {
+	key := []byte("cursor-key")
+	now := time.Now()
+	token := encodeCursor(key, matchCursor{JobID: "job-1", After: 3, ExpiresAt: now.Add(time.Minute).Unix()})
+
+	cursor, err := decodeCursor(key, token, "job-1", now)
+	if err != nil {
+		t.Fatalf("Expected valid cursor, got %v", err)
+	}
+	if cursor.After != 3 {
+		t.Errorf("Expected cursor after index 3, got %d", cursor.After)
+	}
+
+	tests := []struct {
+		name  string
+		key   []byte
+		token string
+		jobID string
+		now   time.Time
+	}{
+		{"tampered", key, "x" + token, "job-1", now},
+		{"wrong key", []byte("other-key"), token, "job-1", now},
+		{"other job", key, token, "job-2", now},
+		{"expired", key, token, "job-1", now.Add(2 * time.Minute)},
+		{"malformed", key, "not-a-cursor", "job-1", now},
+	}
+
+	for _, tt := range tests {
+		if _, err := decodeCursor(tt.key, tt.token, tt.jobID, tt.now); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("%s: expected ErrInvalidCursor, got %v", tt.name, err)
+		}
+	}
+
+	if resp := GetErrorResponse(ErrInvalidCursor); resp.StatusCode != 400 {
+		t.Errorf("Expected status 400 for an invalid cursor, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,87 @@
+package face
+
+import (
+	"all-me-backend/pkg/config"
+	"fmt"
+	"image"
+	"io"
+	"time"
+)
+
+// Limits on decoding images server-side, against crafted images whose small files decode into huge
+// bitmaps (decompression bombs)
+const (
+	defaultMaxImageMegapixels   = 100
+	defaultDecodeTimeoutSeconds = 10
+)
+
+// decodeGuard bounds the images the backend decodes: their declared size is checked from the header
+// before any pixel data is decoded, and decoding itself is given a deadline
+// A zero maxPixels or timeout disables that check
+type decodeGuard struct {
+	maxPixels int64
+	timeout   time.Duration
+}
+
+// decodeGuardFromEnv reads FACE_MAX_IMAGE_MEGAPIXELS and FACE_DECODE_TIMEOUT_SECONDS
+func decodeGuardFromEnv() decodeGuard {
+	megapixels := config.GetInt("FACE_MAX_IMAGE_MEGAPIXELS", defaultMaxImageMegapixels)
+	if megapixels < 1 {
+		megapixels = defaultMaxImageMegapixels
+	}
+
+	timeout := config.GetInt("FACE_DECODE_TIMEOUT_SECONDS", defaultDecodeTimeoutSeconds)
+	if timeout < 1 {
+		timeout = defaultDecodeTimeoutSeconds
+	}
+
+	return decodeGuard{
+		maxPixels: int64(megapixels) * 1_000_000,
+		timeout:   time.Duration(timeout) * time.Second,
+	}
+}
+
+// check rejects an image whose header declares more pixels than allowed
+func (g decodeGuard) check(cfg image.Config) error {
+	if g.maxPixels > 0 && int64(cfg.Width)*int64(cfg.Height) > g.maxPixels {
+		return fmt.Errorf("%w: %dx%d exceeds %d megapixels", ErrImageTooLarge, cfg.Width, cfg.Height, g.maxPixels/1_000_000)
+	}
+	return nil
+}
+
+// decode decodes an image whose header has already passed check
+// Go cannot stop a decode in progress, so on timeout the decode finishes in the background and its result
+// is dropped; the pixel limit is what bounds the memory it can take meanwhile
+func (g decodeGuard) decode(src io.Reader) (image.Image, error) {
+	return g.decodeWith(src, func(r io.Reader) (image.Image, error) {
+		img, _, err := image.Decode(r)
+		return img, err
+	})
+}
+
+// decodeWith decodes an image with the given decoder, for formats image.Decode does not recognize
+func (g decodeGuard) decodeWith(src io.Reader, decode func(io.Reader) (image.Image, error)) (image.Image, error) {
+	if g.timeout <= 0 {
+		return decode(src)
+	}
+
+	type decoded struct {
+		img image.Image
+		err error
+	}
+	done := make(chan decoded, 1)
+	go func() {
+		img, err := decode(src)
+		done <- decoded{img, err}
+	}()
+
+	timer := time.NewTimer(g.timeout)
+	defer timer.Stop()
+
+	select {
+	case result := <-done:
+		return result.img, result.err
+	case <-timer.C:
+		return nil, ErrDecodeTimeout
+	}
+}
@@ -0,0 +1,59 @@
+package face
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"testing"
+	"time"
+)
+
+// pngHeader returns the start of a grayscale PNG declaring the given dimensions, without any pixel data,
+// as a decompression bomb would declare them
+func pngHeader(width, height uint32) []byte {
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:4], width)
+	binary.BigEndian.PutUint32(ihdr[4:8], height)
+	ihdr[8] = 8 // Bit depth; color type, compression, filter and interlace stay 0
+
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	binary.Write(&buf, binary.BigEndian, uint32(len(ihdr)))
+	chunk := append([]byte("IHDR"), ihdr...)
+	buf.Write(chunk)
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	return buf.Bytes()
+}
+
+func TestPreprocessImage_RejectsOversizedImagesFromHeader(t *testing.T) {
+	guard := decodeGuard{maxPixels: 100 * 1_000_000}
+
+	// 50000x50000 would take 2.5GB to decode; the header alone must be enough to reject it
+	bomb := pngHeader(50000, 50000)
+	if _, err := preprocessImage(bomb, PreprocessSteps{}, 0, guard); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("Expected ErrImageTooLarge, got %v", err)
+	}
+
+	if _, ok := perceptualHash(bomb, guard); ok {
+		t.Error("Expected an oversized image not to be hashed")
+	}
+
+	if _, err := preprocessImage(encodeTestJPEG(t, 40, 20), DefaultPreprocessSteps, 10, guard); err != nil {
+		t.Errorf("Expected an image within the limit to be preprocessed, got %v", err)
+	}
+}
+
+func TestDecodeGuard_Timeout(t *testing.T) {
+	data := encodeTestJPEG(t, 1000, 1000)
+
+	guard := decodeGuard{timeout: time.Nanosecond}
+	if _, err := preprocessImage(data, PreprocessSteps{Downscale: true}, 100, guard); !errors.Is(err, ErrDecodeTimeout) {
+		t.Errorf("Expected ErrDecodeTimeout, got %v", err)
+	}
+
+	guard.timeout = time.Minute
+	if _, err := preprocessImage(data, PreprocessSteps{Downscale: true}, 100, guard); err != nil {
+		t.Errorf("Expected decoding within the timeout to succeed, got %v", err)
+	}
+}
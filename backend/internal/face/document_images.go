@@ -0,0 +1,324 @@
+package face
+
+import (
+	"all-me-backend/pkg/models"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+// Limits on images extracted from documents, which are downloaded while the job is being set up
+const (
+	defaultMaxDocuments      = 20
+	defaultMaxDocumentImages = 50
+	defaultMaxDocumentBytes  = 50 * 1024 * 1024
+)
+
+const pdfMimeType = "application/pdf"
+
+var errNotPDF = errors.New("not a PDF document")
+
+// pdfcpu otherwise writes a configuration directory into the user's home the first time it is used
+func init() {
+	model.ConfigPath = "disable"
+}
+
+// pdfImageTypes maps the image file types pdfcpu renders to the MIME types the face service decodes
+// JPEG images are passed through as stored; Flate, LZW and run-length encoded pixels are rendered as PNG
+var pdfImageTypes = map[string]string{
+	"jpg": "image/jpeg",
+	"png": "image/png",
+}
+
+// documentImage is an image extracted from a document, with the page it is shown on
+type documentImage struct {
+	page     int
+	mimeType string
+	data     []byte
+}
+
+// extractPDFImages returns up to limit images embedded in a PDF, page by page, and how many images
+// the document shows in total. An image shown on several pages, such as a logo, counts once, on its first page
+// Images declaring more pixels than the guard allows and types the face service can't decode are skipped
+func extractPDFImages(data []byte, limit int, guard decodeGuard) (images []documentImage, total int, err error) {
+	if !bytes.HasPrefix(bytes.TrimLeft(data, " \t\r\n"), []byte("%PDF-")) {
+		return nil, 0, errNotPDF
+	}
+
+	// Documents come from shared folders, a malformed one must not take the server down
+	defer func() {
+		if r := recover(); r != nil {
+			images, total, err = nil, 0, fmt.Errorf("failed to parse: %v", r)
+		}
+	}()
+
+	conf := model.NewDefaultConfiguration()
+	conf.ValidationMode = model.ValidationRelaxed
+	ctx, err := api.ReadValidateAndOptimize(bytes.NewReader(data), conf)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to parse: %w", err)
+	}
+
+	seen := make(map[int]bool)
+	for page := 1; page <= ctx.PageCount; page++ {
+		for _, objNr := range pdfcpu.ImageObjNrs(ctx, page) {
+			if seen[objNr] {
+				continue
+			}
+			seen[objNr] = true
+
+			imageObject := ctx.Optimize.ImageObjects[objNr]
+			if imageObject == nil || isPDFImageMask(imageObject) {
+				continue
+			}
+
+			// Images past the limit are only counted
+			if len(images) == limit {
+				total++
+				continue
+			}
+
+			extracted, err := extractPDFImage(ctx, imageObject, page, objNr, guard)
+			if err != nil {
+				log.Printf("Skipping image %d on page %d of a document: %v", objNr, page, err)
+				continue
+			}
+			images = append(images, extracted)
+			total++
+		}
+	}
+
+	return images, total, nil
+}
+
+// isPDFImageMask reports whether an image only masks another one, which holds no photo of its own
+func isPDFImageMask(imageObject *model.ImageObject) bool {
+	mask := imageObject.ImageDict.BooleanEntry("ImageMask")
+	return mask != nil && *mask
+}
+
+// extractPDFImage decodes one image object of the page, checking its declared size against the guard first
+func extractPDFImage(ctx *model.Context, imageObject *model.ImageObject, page, objNr int, guard decodeGuard) (documentImage, error) {
+	width, height := imageObject.ImageDict.IntEntry("Width"), imageObject.ImageDict.IntEntry("Height")
+	if width != nil && height != nil {
+		if err := guard.check(image.Config{Width: *width, Height: *height}); err != nil {
+			return documentImage{}, err
+		}
+	}
+
+	extracted, err := pdfcpu.ExtractImage(ctx, imageObject.ImageDict, false, imageObject.ResourceNames[page-1], objNr, false)
+	if err != nil {
+		return documentImage{}, err
+	}
+
+	mimeType, ok := pdfImageTypes[extracted.FileType]
+	if !ok {
+		return documentImage{}, fmt.Errorf("unsupported image type %s", extracted.FileType)
+	}
+
+	data, err := io.ReadAll(extracted)
+	if err != nil {
+		return documentImage{}, err
+	}
+	return documentImage{page: page, mimeType: mimeType, data: data}, nil
+}
+
+// documentImageItem is the synthetic item for the index-th (from 1) image embedded in a document
+func documentImageItem(document *models.CloudItem, index int, image extractedImage) *models.CloudItem {
+	return &models.CloudItem{
+		ID:             fmt.Sprintf("%s#image-%d", document.ID, index),
+		Name:           fmt.Sprintf("%s (page %d, image %d)", document.Name, image.page, index),
+		MimeType:       image.mimeType,
+		Provider:       document.Provider,
+		DriveID:        document.DriveID,
+		ModifiedTime:   document.ModifiedTime,
+		SourceDocument: document,
+		SourceImage:    index,
+		SourcePage:     image.page,
+	}
+}
+
+// listDocumentImages extracts the images embedded in the PDFs among files into documents and returns
+// a synthetic item for each of them
+// Documents that can't be read are reported as warnings rather than failing the job
+func (s *Service) listDocumentImages(files []*models.CloudItem, token *models.Token, documents *documentCache) ([]*models.CloudItem, []string) {
+	var items []*models.CloudItem
+	var warnings []string
+
+	var count int
+	for _, file := range files {
+		if file.MimeType != pdfMimeType {
+			continue
+		}
+
+		count++
+		if count > s.maxDocuments {
+			warnings = append(warnings, fmt.Sprintf("Only the first %d PDF documents were searched for photos", s.maxDocuments))
+			break
+		}
+
+		document := documents.load(s, file, token)
+		if document.err != nil {
+			log.Printf("Skipping images in %s: %v", file.Name, document.err)
+			warnings = append(warnings, fmt.Sprintf("Could not read photos from %s: %v", file.Name, document.err))
+			continue
+		}
+
+		if document.total > len(document.images) {
+			warnings = append(warnings, fmt.Sprintf("Only the first %d of %d photos in %s were compared", len(document.images), document.total, file.Name))
+		}
+		for i, image := range document.images {
+			items = append(items, documentImageItem(file, i+1, image))
+		}
+	}
+
+	return items, warnings
+}
+
+// downloadDocument downloads a document, failing when it is larger than the per-document limit
+func (s *Service) downloadDocument(document *models.CloudItem, token *models.Token) ([]byte, error) {
+	s.workers.Acquire()
+	defer s.workers.Release()
+
+	stream, err := s.storageService.GetFileStream(document, token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download: %w", err)
+	}
+	defer stream.Close()
+
+	data, err := io.ReadAll(io.LimitReader(stream, s.maxDocumentBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read: %w", err)
+	}
+	if int64(len(data)) > s.maxDocumentBytes {
+		return nil, fmt.Errorf("larger than %dMB", s.maxDocumentBytes/(1024*1024))
+	}
+	return data, nil
+}
+
+// documentCache keeps the images extracted from a job's documents in a temporary directory, so each
+// document is downloaded and parsed once for the whole job rather than for every batch holding its images
+// Jobs close their cache when they finish, which removes the directory
+type documentCache struct {
+	dir       string // Created when the first image is kept
+	documents map[string]*cachedDocument
+	mu        sync.Mutex
+}
+
+type cachedDocument struct {
+	once   sync.Once
+	images []extractedImage
+	total  int // Images the document shows, including the ones past the per-document limit
+	err    error
+}
+
+// extractedImage is a document image kept in the cache's directory
+type extractedImage struct {
+	page     int
+	mimeType string
+	path     string
+}
+
+func newDocumentCache() *documentCache {
+	return &documentCache{
+		documents: make(map[string]*cachedDocument),
+	}
+}
+
+// load downloads and extracts a document the first time it is asked for
+func (c *documentCache) load(s *Service, item *models.CloudItem, token *models.Token) *cachedDocument {
+	c.mu.Lock()
+	document, exists := c.documents[item.ID]
+	if !exists {
+		document = &cachedDocument{}
+		c.documents[item.ID] = document
+	}
+	c.mu.Unlock()
+
+	document.once.Do(func() {
+		document.images, document.total, document.err = c.extract(s, item, token)
+	})
+	return document
+}
+
+func (c *documentCache) extract(s *Service, item *models.CloudItem, token *models.Token) ([]extractedImage, int, error) {
+	data, err := s.downloadDocument(item, token)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	images, total, err := extractPDFImages(data, s.maxDocumentImages, s.decodeGuard)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	extracted := make([]extractedImage, 0, len(images))
+	for _, image := range images {
+		path, err := c.write(image.data)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to keep extracted image: %w", err)
+		}
+		extracted = append(extracted, extractedImage{page: image.page, mimeType: image.mimeType, path: path})
+	}
+	return extracted, total, nil
+}
+
+// write stores an image in the cache's directory, returning its path
+func (c *documentCache) write(data []byte) (string, error) {
+	c.mu.Lock()
+	if c.dir == "" {
+		dir, err := os.MkdirTemp("", "allme-documents-*")
+		if err != nil {
+			c.mu.Unlock()
+			return "", err
+		}
+		c.dir = dir
+	}
+	dir := c.dir
+	c.mu.Unlock()
+
+	file, err := os.CreateTemp(dir, "image-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return "", err
+	}
+	return file.Name(), file.Close()
+}
+
+// image returns the data of a synthetic document image item
+func (c *documentCache) image(s *Service, item *models.CloudItem, token *models.Token) ([]byte, error) {
+	document := c.load(s, item.SourceDocument, token)
+	if document.err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", item.SourceDocument.Name, document.err)
+	}
+
+	if item.SourceImage < 1 || item.SourceImage > len(document.images) {
+		return nil, fmt.Errorf("image %d is no longer in %s", item.SourceImage, item.SourceDocument.Name)
+	}
+	return os.ReadFile(document.images[item.SourceImage-1].path)
+}
+
+// close removes the images kept for the job
+func (c *documentCache) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.dir != "" {
+		if err := os.RemoveAll(c.dir); err != nil {
+			log.Printf("Failed to remove extracted document images: %v", err)
+		}
+		c.dir = ""
+	}
+}
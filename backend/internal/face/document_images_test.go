@@ -0,0 +1,200 @@
+package face
+
+import (
+	"all-me-backend/pkg/models"
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+// buildTestPDF creates a PDF showing each image on a page of its own, as written by pdfcpu: JPEG images
+// are embedded as they are, others as Flate-compressed pixels, and objects are packed into object streams
+func buildTestPDF(t *testing.T, images ...[]byte) []byte {
+	t.Helper()
+
+	readers := make([]io.Reader, len(images))
+	for i, data := range images {
+		readers[i] = bytes.NewReader(data)
+	}
+
+	var buf bytes.Buffer
+	if err := api.ImportImages(nil, &buf, readers, pdfcpu.DefaultImportConfig(), nil); err != nil {
+		t.Fatalf("Failed to build PDF: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func encodeTestPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage(width, height)); err != nil {
+		t.Fatalf("Failed to encode PNG: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractPDFImages(t *testing.T) {
+	pdf := buildTestPDF(t, encodeTestJPEG(t, 40, 30), encodeTestPNG(t, 30, 40), encodeTestJPEG(t, 20, 20))
+
+	images, total, err := extractPDFImages(pdf, 2, decodeGuard{})
+	if err != nil {
+		t.Fatalf("extractPDFImages failed: %v", err)
+	}
+
+	if total != 3 {
+		t.Errorf("Expected 3 images in total, got %d", total)
+	}
+	if len(images) != 2 {
+		t.Fatalf("Expected the first 2 images, got %d", len(images))
+	}
+
+	expected := []struct {
+		page          int
+		mimeType      string
+		width, height int
+	}{
+		{1, "image/jpeg", 40, 30},
+		{2, "image/png", 30, 40},
+	}
+	for i, want := range expected {
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(images[i].data))
+		if err != nil {
+			t.Fatalf("Image %d can't be decoded: %v", i+1, err)
+		}
+		if images[i].page != want.page || images[i].mimeType != want.mimeType || cfg.Width != want.width || cfg.Height != want.height {
+			t.Errorf("Image %d: expected a %dx%d %s on page %d, got a %dx%d %s on page %d", i+1,
+				want.width, want.height, want.mimeType, want.page, cfg.Width, cfg.Height, images[i].mimeType, images[i].page)
+		}
+	}
+
+	// Images declaring more pixels than allowed are skipped without decoding them
+	images, _, err = extractPDFImages(pdf, 3, decodeGuard{maxPixels: 1000})
+	if err != nil || len(images) != 1 || images[0].page != 3 {
+		t.Errorf("Expected only the 20x20 image within the pixel limit, got %d images, %v", len(images), err)
+	}
+
+	if _, _, err := extractPDFImages([]byte("image-data"), 2, decodeGuard{}); err != errNotPDF {
+		t.Errorf("Expected errNotPDF for other content, got %v", err)
+	}
+	if _, _, err := extractPDFImages([]byte("%PDF-1.4\ngarbage"), 2, decodeGuard{}); err == nil {
+		t.Error("Expected an error for a malformed PDF")
+	}
+}
+
+func TestDocumentCache_DownloadsEachDocumentOncePerJob(t *testing.T) {
+	sheet := &models.CloudItem{ID: "sheet", Name: "sheet.pdf", MimeType: pdfMimeType}
+	storage := &mockStorageService{
+		contents: map[string]string{"sheet": string(buildTestPDF(t, encodeTestJPEG(t, 40, 30), encodeTestJPEG(t, 30, 40)))},
+	}
+	service := createTestService(storage, "")
+	token := &models.Token{AccessToken: "token", Provider: "googledrive"}
+
+	documents := newDocumentCache()
+	items, _ := service.listDocumentImages([]*models.CloudItem{sheet}, token, documents)
+	if len(items) != 2 {
+		t.Fatalf("Expected 2 document images, got %d", len(items))
+	}
+
+	// Each image in a batch of its own, as when a document's images are split across batches
+	for _, item := range items {
+		encoded, _, err := service.downloadAndEncodeBatch(context.Background(), []*models.CloudItem{item}, token, DefaultPreprocessSteps, documents)
+		if err != nil || len(encoded) != 1 {
+			t.Fatalf("downloadAndEncodeBatch failed: %d images, %v", len(encoded), err)
+		}
+	}
+	if storage.fileDownloads != 1 {
+		t.Errorf("Expected the document to be downloaded once, got %d downloads", storage.fileDownloads)
+	}
+
+	dir := documents.dir
+	documents.close()
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("Expected the extracted images to be removed, got %v", err)
+	}
+}
+
+func TestCompareFolderImages_ComparesImagesEmbeddedInPDFs(t *testing.T) {
+	pythonServer := newMockPythonServer(t)
+	pythonServer.matchFirstImage = true
+
+	sheet := &models.CloudItem{ID: "sheet", Name: "sheet.pdf", MimeType: pdfMimeType, Provider: "googledrive"}
+	pdf := buildTestPDF(t, encodeTestJPEG(t, 40, 30), encodeTestJPEG(t, 30, 40), encodeTestJPEG(t, 20, 20))
+	storage := &mockStorageService{
+		otherFiles: []*models.CloudItem{sheet, {ID: "notes", Name: "notes.txt", MimeType: "text/plain"}},
+		contents:   map[string]string{"sheet": string(pdf)},
+	}
+	service := createTestService(storage, pythonServer.URL)
+	service.documentImagesEnabled = true
+	service.maxDocumentImages = 2
+	token := &models.Token{AccessToken: "token", Provider: "googledrive"}
+
+	jobID, err := service.CompareFolderImages("session-1", "https://drive.google.com/drive/folders/abc", token, compareOptions{preprocess: DefaultPreprocessSteps, aggregation: AggregationAny, model: FaceModelSmall})
+	if err != nil {
+		t.Fatalf("CompareFolderImages failed: %v", err)
+	}
+	waitForJobStatus(t, service, jobID, JobStatusCompleted)
+
+	// Downloaded once to list its images, which the batch holding them then reuses
+	if storage.fileDownloads != 1 {
+		t.Errorf("Expected the PDF to be downloaded once, got %d", storage.fileDownloads)
+	}
+
+	batches := pythonServer.submittedBatches()
+	if len(batches) != 1 || len(batches[0].Images) != 2 {
+		t.Fatalf("Expected the first 2 embedded images to be compared, got %+v", batches)
+	}
+
+	status, err := service.GetJobStatus(jobID, false, MatchPage{})
+	if err != nil {
+		t.Fatalf("GetJobStatus failed: %v", err)
+	}
+	if !slices.Contains(status.Warnings, "Only the first 2 of 3 photos in sheet.pdf were compared") {
+		t.Errorf("Expected a warning about the image limit, got %v", status.Warnings)
+	}
+	if len(status.Matches) != 1 {
+		t.Fatalf("Expected 1 match, got %d", len(status.Matches))
+	}
+	match := status.Matches[0]
+	if match.Name != "sheet.pdf (page 1, image 1)" || match.SourceDocument == nil || match.SourceDocument.ID != "sheet" || match.SourceImage != 1 || match.SourcePage != 1 {
+		t.Errorf("Expected the match to reference image 1 on page 1 of sheet.pdf, got %+v", match)
+	}
+
+	// Saving the match copies the document it came from
+	service.saveToDriveEnabled = true
+	saveJobID, err := service.SaveMatchesToDrive("session-1", jobID, &models.CloudItem{ID: "dest", IsFolder: true}, token, SaveOptions{})
+	if err != nil {
+		t.Fatalf("SaveMatchesToDrive failed: %v", err)
+	}
+	waitForSaveJobStatus(t, service, "session-1", saveJobID, JobStatusCompleted)
+	if !slices.Equal(storage.copied, []string{"sheet"}) {
+		t.Errorf("Expected sheet.pdf to be copied, got %v", storage.copied)
+	}
+}
+
+func TestListDocumentImages_ReportsUnreadableDocuments(t *testing.T) {
+	storage := &mockStorageService{
+		contents: map[string]string{"broken": "not a pdf"},
+	}
+	service := createTestService(storage, "")
+	token := &models.Token{AccessToken: "token", Provider: "googledrive"}
+
+	files := []*models.CloudItem{{ID: "broken", Name: "broken.pdf", MimeType: pdfMimeType}}
+	items, warnings := service.listDocumentImages(files, token, newDocumentCache())
+
+	if len(items) != 0 {
+		t.Errorf("Expected no images from an unreadable document, got %d", len(items))
+	}
+	if len(warnings) != 1 || !strings.HasPrefix(warnings[0], "Could not read photos from broken.pdf") {
+		t.Errorf("Expected a warning about broken.pdf, got %v", warnings)
+	}
+}
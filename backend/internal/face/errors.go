@@ -1,21 +1,42 @@
 package face
 
 import (
+	"all-me-backend/pkg/models"
 	"errors"
 	"net/http"
 )
 
 var (
-	ErrNoBaseFace         = errors.New("no base face registered for this session")
-	ErrSessionNotFound    = errors.New("session not found")
-	ErrNoFaceDetected     = errors.New("no face detected in image")
-	ErrMultipleFaces      = errors.New("multiple faces detected, please use image with single face")
-	ErrInvalidImageFormat = errors.New("invalid image format")
-	ErrServiceUnavailable = errors.New("face comparison service is temporarily unavailable")
-	ErrTimeout            = errors.New("request timed out")
-	ErrInvalidFolderLink  = errors.New("invalid folder link")
-	ErrFolderAccess       = errors.New("unable to access folder")
-	ErrJobNotFound        = errors.New("job not found")
+	ErrNoBaseFace          = errors.New("no base face registered for this session")
+	ErrSessionNotFound     = errors.New("session not found")
+	ErrNoFaceDetected      = errors.New("no face detected in image")
+	ErrMultipleFaces       = errors.New("multiple faces detected, please use image with single face")
+	ErrInvalidImageFormat  = errors.New("invalid image format")
+	ErrServiceUnavailable  = errors.New("face comparison service is temporarily unavailable")
+	ErrTimeout             = errors.New("request timed out")
+	ErrInvalidFolderLink   = errors.New("invalid folder link")
+	ErrFolderAccess        = errors.New("unable to access folder")
+	ErrItemAccess          = errors.New("unable to access image")
+	ErrJobNotFound         = errors.New("job not found")
+	ErrJobNotCompleted     = errors.New("job has not completed yet")
+	ErrJobFinished         = errors.New("job has already finished")
+	ErrNothingToRerun      = errors.New("all images in this job already matched")
+	ErrTooManyImages       = errors.New("too many images for a single comparison")
+	ErrResultNotFound      = errors.New("saved result not found")
+	ErrInvalidCursor       = errors.New("invalid or expired cursor")
+	ErrTooManyReferences   = errors.New("too many reference images registered for this session")
+	ErrNoSavedReference    = errors.New("no saved reference face for this account")
+	ErrSavingDisabled      = errors.New("saving reference faces is not enabled on this server")
+	ErrNewJobsDisabled     = errors.New("new comparison jobs are disabled")
+	ErrSaveToDriveDisabled = errors.New("saving matches to a drive is not enabled on this server")
+	ErrInvalidDestination  = errors.New("invalid destination folder")
+	ErrModelMismatch       = errors.New("comparison model does not match the base face model")
+	ErrNoMatchesToSave     = errors.New("job has no matches to save")
+	ErrInsufficientScope   = errors.New("insufficient permissions, please re-authenticate with write access")
+	ErrImageTooLarge       = errors.New("image dimensions are too large")
+	ErrDecodeTimeout       = errors.New("decoding the image took too long")
+	ErrImageTooSmall       = errors.New("image is too small to recognize a face in")
+	ErrHEIFDecode          = errors.New("the HEIC/HEIF image could not be read, please use a JPEG or PNG image")
 )
 
 type ErrorResponse struct {
@@ -36,16 +57,54 @@ func GetErrorResponse(err error) ErrorResponse {
 		return ErrorResponse{http.StatusBadRequest, err.Error()}
 	case errors.Is(err, ErrInvalidImageFormat):
 		return ErrorResponse{http.StatusBadRequest, err.Error()}
+	case errors.Is(err, ErrImageTooSmall):
+		return ErrorResponse{http.StatusBadRequest, err.Error()}
+	case errors.Is(err, ErrHEIFDecode):
+		return ErrorResponse{http.StatusBadRequest, err.Error()}
 	case errors.Is(err, ErrServiceUnavailable):
 		return ErrorResponse{http.StatusServiceUnavailable, "Face comparison service is temporarily unavailable. Please try again later."}
 	case errors.Is(err, ErrTimeout):
 		return ErrorResponse{http.StatusGatewayTimeout, "Request timed out. Please try again with fewer images or a smaller folder."}
+	case errors.Is(err, models.ErrRestrictedLocation):
+		return ErrorResponse{http.StatusForbidden, models.ErrRestrictedLocation.Error()}
 	case errors.Is(err, ErrInvalidFolderLink):
 		return ErrorResponse{http.StatusBadRequest, err.Error()}
 	case errors.Is(err, ErrFolderAccess):
 		return ErrorResponse{http.StatusBadRequest, "Unable to access folder. Please check the folder link and permissions."}
+	case errors.Is(err, ErrItemAccess):
+		return ErrorResponse{http.StatusBadRequest, "Unable to access the image. Please check it is still in the folder and shared with you."}
 	case errors.Is(err, ErrJobNotFound):
 		return ErrorResponse{http.StatusNotFound, err.Error()}
+	case errors.Is(err, ErrJobNotCompleted):
+		return ErrorResponse{http.StatusConflict, err.Error()}
+	case errors.Is(err, ErrJobFinished):
+		return ErrorResponse{http.StatusConflict, err.Error()}
+	case errors.Is(err, ErrNothingToRerun):
+		return ErrorResponse{http.StatusBadRequest, err.Error()}
+	case errors.Is(err, ErrTooManyImages):
+		return ErrorResponse{http.StatusBadRequest, err.Error()}
+	case errors.Is(err, ErrResultNotFound):
+		return ErrorResponse{http.StatusNotFound, err.Error()}
+	case errors.Is(err, ErrInvalidCursor):
+		return ErrorResponse{http.StatusBadRequest, err.Error()}
+	case errors.Is(err, ErrTooManyReferences):
+		return ErrorResponse{http.StatusBadRequest, err.Error()}
+	case errors.Is(err, ErrNoSavedReference):
+		return ErrorResponse{http.StatusNotFound, err.Error()}
+	case errors.Is(err, ErrSavingDisabled):
+		return ErrorResponse{http.StatusNotImplemented, err.Error()}
+	case errors.Is(err, ErrNewJobsDisabled):
+		return ErrorResponse{http.StatusServiceUnavailable, "New comparisons are paused for maintenance. Running jobs will finish; please try again later."}
+	case errors.Is(err, ErrSaveToDriveDisabled):
+		return ErrorResponse{http.StatusNotImplemented, err.Error()}
+	case errors.Is(err, ErrInvalidDestination):
+		return ErrorResponse{http.StatusBadRequest, err.Error()}
+	case errors.Is(err, ErrModelMismatch):
+		return ErrorResponse{http.StatusConflict, err.Error()}
+	case errors.Is(err, ErrNoMatchesToSave):
+		return ErrorResponse{http.StatusBadRequest, err.Error()}
+	case errors.Is(err, ErrInsufficientScope):
+		return ErrorResponse{http.StatusForbidden, err.Error()}
 	default:
 		return ErrorResponse{http.StatusInternalServerError, "An unexpected error occurred. Please try again."}
 	}
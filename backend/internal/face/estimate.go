@@ -0,0 +1,141 @@
+package face
+
+import (
+	"all-me-backend/pkg/models"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// throughputWindow is how many recently completed jobs the throughput average covers
+	throughputWindow = 20
+
+	// defaultImagesPerSecond is assumed until a job has completed
+	defaultImagesPerSecond = 5
+)
+
+type throughputSample struct {
+	images  int
+	elapsed time.Duration
+}
+
+// throughputTracker keeps a rolling window of how fast recent comparison jobs processed their images
+type throughputTracker struct {
+	samples  []throughputSample
+	fallback float64
+	mu       sync.Mutex
+}
+
+func newThroughputTracker(fallback float64) *throughputTracker {
+	return &throughputTracker{fallback: fallback}
+}
+
+// record adds a completed job, dropping the oldest once the window is full
+func (t *throughputTracker) record(images int, elapsed time.Duration) {
+	if images < 1 || elapsed <= 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.samples = append(t.samples, throughputSample{images: images, elapsed: elapsed})
+	if len(t.samples) > throughputWindow {
+		t.samples = t.samples[len(t.samples)-throughputWindow:]
+	}
+}
+
+// imagesPerSecond returns the average throughput of the recent jobs and how many jobs it is based on
+// Jobs are weighted by their size, so a few tiny jobs dominated by startup time don't skew the average
+func (t *throughputTracker) imagesPerSecond() (float64, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var images int
+	var elapsed time.Duration
+	for _, sample := range t.samples {
+		images += sample.images
+		elapsed += sample.elapsed
+	}
+
+	if images == 0 {
+		return t.fallback, 0
+	}
+	return float64(images) / elapsed.Seconds(), len(t.samples)
+}
+
+// EstimateFolderComparison lists a folder's images and estimates how long comparing them would take,
+// without starting a job or needing a base face
+func (s *Service) EstimateFolderComparison(folderLink string, folderItem *models.CloudItem, token *models.Token, recursive bool, dates models.DateRange) (*EstimateResponse, error) {
+	if folderItem == nil {
+		item, err := s.storageService.ParseShareLink(folderLink, token)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidFolderLink, err)
+		}
+		folderItem = item
+	} else if folderItem.Provider != "" && folderItem.Provider != token.Provider {
+		return nil, fmt.Errorf("%w: folder provider %s does not match %s", ErrInvalidFolderLink, folderItem.Provider, token.Provider)
+	}
+
+	allImages, warnings, err := s.storageService.ListImages(folderItem, token, recursive, dates)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFolderAccess, err)
+	}
+
+	imagesPerSecond, basedOnJobs := s.throughput.imagesPerSecond()
+	seconds := int(math.Ceil(float64(len(allImages)) / imagesPerSecond))
+
+	response := &EstimateResponse{
+		ImageCount:       len(allImages),
+		EstimatedSeconds: seconds,
+		ImagesPerSecond:  math.Round(imagesPerSecond*100) / 100,
+		BasedOnJobs:      basedOnJobs,
+		ExceedsLimit:     len(allImages) > s.maxImagesPerJob,
+		Warnings:         warnings,
+	}
+
+	switch {
+	case len(allImages) == 0:
+		response.Message = "No photos found in this folder"
+	case response.ExceedsLimit:
+		response.Message = fmt.Sprintf("This folder has %s photos, more than the %s a single scan can take",
+			formatCount(len(allImages)), formatCount(s.maxImagesPerJob))
+	default:
+		response.Message = fmt.Sprintf("About %s to scan %s photos", formatDuration(seconds), formatCount(len(allImages)))
+	}
+
+	return response, nil
+}
+
+// formatDuration describes a duration in seconds the way a person would round it
+func formatDuration(seconds int) string {
+	switch {
+	case seconds < 60:
+		return "less than a minute"
+	case seconds < 90:
+		return "1 minute"
+	case seconds < 3600:
+		return fmt.Sprintf("%d minutes", (seconds+30)/60)
+	default:
+		hours := float64(seconds) / 3600
+		if hours < 1.5 {
+			return "1 hour"
+		}
+		return fmt.Sprintf("%d hours", int(math.Round(hours)))
+	}
+}
+
+// formatCount formats a count with thousands separators, e.g. 1,240
+func formatCount(n int) string {
+	digits := fmt.Sprintf("%d", n)
+	var formatted []byte
+	for i := range len(digits) {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			formatted = append(formatted, ',')
+		}
+		formatted = append(formatted, digits[i])
+	}
+	return string(formatted)
+}
@@ -0,0 +1,90 @@
+package face
+
+import (
+	"all-me-backend/pkg/models"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestThroughputTracker_RollingAverage(t *testing.T) {
+	tracker := newThroughputTracker(5)
+
+	if rate, jobs := tracker.imagesPerSecond(); rate != 5 || jobs != 0 {
+		t.Errorf("Expected the default 5 images/sec with no history, got %v from %d jobs", rate, jobs)
+	}
+
+	// Jobs are weighted by size: 10 images in 10s and 90 images in 10s average to 5 images/sec
+	tracker.record(10, 10*time.Second)
+	tracker.record(90, 10*time.Second)
+	tracker.record(0, time.Second)
+	if rate, jobs := tracker.imagesPerSecond(); rate != 5 || jobs != 2 {
+		t.Errorf("Expected 5 images/sec from 2 jobs, got %v from %d jobs", rate, jobs)
+	}
+
+	// Once the window is full, the oldest jobs stop counting
+	for range throughputWindow {
+		tracker.record(20, time.Second)
+	}
+	if rate, jobs := tracker.imagesPerSecond(); rate != 20 || jobs != throughputWindow {
+		t.Errorf("Expected 20 images/sec from %d jobs, got %v from %d jobs", throughputWindow, rate, jobs)
+	}
+}
+
+func TestEstimateFolderComparison_UsesRecentThroughput(t *testing.T) {
+	pythonServer := newMockPythonServer(t)
+	storage := &mockStorageService{}
+	for i := range 1240 {
+		storage.images = append(storage.images, &models.CloudItem{ID: fmt.Sprintf("img-%d", i), Name: "img.jpg"})
+	}
+	service := createTestService(storage, pythonServer.URL)
+	service.throughput = newThroughputTracker(5)
+	token := &models.Token{AccessToken: "token", Provider: "googledrive"}
+
+	estimate, err := service.EstimateFolderComparison("https://drive.google.com/drive/folders/abc", nil, token, true, models.DateRange{})
+	if err != nil {
+		t.Fatalf("EstimateFolderComparison failed: %v", err)
+	}
+
+	if estimate.ImageCount != 1240 || estimate.EstimatedSeconds != 248 || estimate.BasedOnJobs != 0 {
+		t.Errorf("Expected 1240 images in 248s at the default rate, got %+v", estimate)
+	}
+	if estimate.Message != "About 4 minutes to scan 1,240 photos" {
+		t.Errorf("Unexpected message: %q", estimate.Message)
+	}
+	if batches := pythonServer.submittedBatches(); len(batches) != 0 {
+		t.Errorf("Expected no images to be compared for an estimate, got %d batches", len(batches))
+	}
+
+	// A completed job updates the average
+	storage.images = storage.images[:3]
+	jobID, err := service.CompareFolderImages("session-1", "https://drive.google.com/drive/folders/abc", token, compareOptions{preprocess: DefaultPreprocessSteps, aggregation: AggregationAny, model: FaceModelSmall, recursive: true})
+	if err != nil {
+		t.Fatalf("CompareFolderImages failed: %v", err)
+	}
+	waitForJobStatus(t, service, jobID, JobStatusCompleted)
+
+	estimate, err = service.EstimateFolderComparison("https://drive.google.com/drive/folders/abc", nil, token, true, models.DateRange{})
+	if err != nil {
+		t.Fatalf("EstimateFolderComparison failed: %v", err)
+	}
+	if estimate.BasedOnJobs != 1 || estimate.ImagesPerSecond == 5 {
+		t.Errorf("Expected the estimate to use the completed job's throughput, got %+v", estimate)
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := map[int]string{
+		20:    "less than a minute",
+		75:    "1 minute",
+		248:   "4 minutes",
+		4000:  "1 hour",
+		10000: "3 hours",
+	}
+
+	for seconds, want := range tests {
+		if got := formatDuration(seconds); got != want {
+			t.Errorf("Expected %q for %ds, got %q", want, seconds, got)
+		}
+	}
+}
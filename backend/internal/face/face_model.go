@@ -0,0 +1,106 @@
+package face
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FaceModel selects the landmark model faces are aligned with before they are encoded
+// Encodings from different models are not comparable, so a session compares with the model its base face used
+type FaceModel string
+
+const (
+	// FaceModelSmall aligns faces on 5 landmarks, which is faster
+	FaceModelSmall FaceModel = "small"
+	// FaceModelLarge aligns faces on 68 landmarks, which is slower but can be more accurate for angled faces
+	FaceModelLarge FaceModel = "large"
+)
+
+// sessionModelTTL matches how long the Python service keeps an idle session's encodings
+const sessionModelTTL = 24 * time.Hour
+
+// ParseFaceModel parses a face model ("small" or "large"), defaulting to small
+func ParseFaceModel(value string) (FaceModel, error) {
+	switch FaceModel(strings.ToLower(strings.TrimSpace(value))) {
+	case "", FaceModelSmall:
+		return FaceModelSmall, nil
+	case FaceModelLarge:
+		return FaceModelLarge, nil
+	default:
+		return "", fmt.Errorf("unknown model %q, expected small or large", value)
+	}
+}
+
+type sessionModel struct {
+	model    FaceModel
+	lastUsed time.Time
+}
+
+// sessionModelTracker remembers the face model each session's reference faces were encoded with
+// Entries idle for longer than the Python session TTL are pruned whenever a model is recorded
+type sessionModelTracker struct {
+	sessions map[string]*sessionModel
+	mu       sync.Mutex
+}
+
+func newSessionModelTracker() *sessionModelTracker {
+	return &sessionModelTracker{
+		sessions: make(map[string]*sessionModel),
+	}
+}
+
+// set records the model the session's reference faces were encoded with
+func (t *sessionModelTracker) set(sessionID string, model FaceModel) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	for id, session := range t.sessions {
+		if now.Sub(session.lastUsed) > sessionModelTTL {
+			delete(t.sessions, id)
+		}
+	}
+
+	t.sessions[sessionID] = &sessionModel{model: model, lastUsed: now}
+}
+
+// check returns ErrModelMismatch if the session's reference faces were encoded with a different model
+// Sessions the backend has no record of, e.g. after a restart, are not rejected
+func (t *sessionModelTracker) check(sessionID string, model FaceModel) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	session, exists := t.sessions[sessionID]
+	if !exists {
+		return nil
+	}
+	session.lastUsed = time.Now()
+
+	if session.model != model {
+		return fmt.Errorf("%w: the base face was registered with the %s model but the comparison requested %s; register the base face again to switch models",
+			ErrModelMismatch, session.model, model)
+	}
+	return nil
+}
+
+// get returns the model the session's reference faces were encoded with, if the backend recorded one
+func (t *sessionModelTracker) get(sessionID string) (FaceModel, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	session, exists := t.sessions[sessionID]
+	if !exists {
+		return "", false
+	}
+	return session.model, true
+}
+
+// clear forgets the session's model once its reference faces are cleared
+func (t *sessionModelTracker) clear(sessionID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.sessions, sessionID)
+}
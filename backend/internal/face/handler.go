@@ -1,17 +1,33 @@
 package face
 
 import (
+	"all-me-backend/pkg/mediatypes"
 	"all-me-backend/pkg/models"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
+	"log"
 	"mime/multipart"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
 )
 
+const (
+	// maxIdempotencyKeyLength bounds the Idempotency-Key header kept in memory per request
+	maxIdempotencyKeyLength = 255
+
+	// uploadMemoryBytes is how much of a multipart upload is kept in memory before spilling to disk
+	uploadMemoryBytes = 1024 * 1024
+
+	// streamKeepAlive is how often a job status stream sends a comment while nothing changes,
+	// so proxies don't close it as idle
+	streamKeepAlive = 15 * time.Second
+)
+
 type Handler struct {
 	service      *Service
 	sessionStore models.SessionStore
@@ -28,12 +44,36 @@ func (h *Handler) RegisterRoutes(e *echo.Echo) {
 	face := e.Group("/face")
 
 	face.POST("/register-base", h.RegisterBaseFace)
+	face.POST("/register-from-folder-item", h.RegisterBaseFaceFromFolderItem)
 	face.POST("/compare-folder", h.CompareFolder)
+	face.POST("/compare-drive", h.CompareDrive)
+	face.POST("/estimate", h.EstimateComparison)
 	face.GET("/job-status/:jobId", h.GetJobStatus)
+	face.GET("/job-stream/:jobId", h.StreamJobStatus)
+	face.DELETE("/job/:jobId", h.CancelJob)
+	face.DELETE("/jobs", h.DeleteSessionJobs)
+	face.POST("/job/:jobId/rerun", h.RerunUnmatched)
+	face.POST("/job/:jobId/save", h.SaveResult)
+	face.GET("/result/:token", h.GetResult)
 	face.DELETE("/clear-reference/:sessionId", h.ClearReferenceImage)
+	face.POST("/saved-reference", h.SaveReferenceFace)
+	face.POST("/use-saved-reference", h.UseSavedReferenceFace)
+	face.DELETE("/saved-reference/:sessionId", h.DeleteSavedReferenceFace)
+	face.POST("/job/:jobId/save-to-drive", h.SaveMatchesToDrive)
+	face.GET("/save-job/:saveJobId", h.GetSaveJobStatus)
 }
 
 func (h *Handler) RegisterBaseFace(c echo.Context) error {
+	// Parse the form before binding so uploads beyond the memory limit are spooled to temporary files
+	if err := c.Request().ParseMultipartForm(uploadMemoryBytes); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "Invalid request format",
+		})
+	}
+	if form := c.Request().MultipartForm; form != nil {
+		defer form.RemoveAll()
+	}
+
 	var req RegisterBaseFaceRequest
 	if err := c.Bind(&req); err != nil {
 		return c.JSON(http.StatusBadRequest, echo.Map{
@@ -47,6 +87,20 @@ func (h *Handler) RegisterBaseFace(c echo.Context) error {
 		})
 	}
 
+	preprocess, err := ParsePreprocessSteps(req.Preprocess)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	model, err := ParseFaceModel(req.Model)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
 	file, err := c.FormFile("image")
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, echo.Map{
@@ -54,7 +108,7 @@ func (h *Handler) RegisterBaseFace(c echo.Context) error {
 		})
 	}
 
-	if err := validateImageFile(file); err != nil {
+	if err := validateImageFile(file, h.service.BaseFaceMimeTypes()); err != nil {
 		return c.JSON(http.StatusBadRequest, echo.Map{
 			"error": err.Error(),
 		})
@@ -68,19 +122,66 @@ func (h *Handler) RegisterBaseFace(c echo.Context) error {
 	}
 	defer src.Close()
 
-	imageData, err := io.ReadAll(src)
+	referenceCount, err := h.service.RegisterBaseFace(req.SessionID, src, preprocess, model, req.Append)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{
-			"error": "Failed to read image file",
+		return handleServiceError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, RegisterBaseFaceResponse{
+		Success:        true,
+		ReferenceCount: referenceCount,
+	})
+}
+
+// RegisterBaseFaceFromFolderItem registers a photo picked from the folder being browsed as the base face
+func (h *Handler) RegisterBaseFaceFromFolderItem(c echo.Context) error {
+	var req RegisterFromFolderItemRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "Invalid request format",
+		})
+	}
+
+	if strings.TrimSpace(req.SessionID) == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "session_id is required",
+		})
+	}
+
+	if strings.TrimSpace(req.ItemID) == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "item_id is required",
+		})
+	}
+
+	preprocess, err := ParsePreprocessSteps(req.Preprocess)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	model, err := ParseFaceModel(req.Model)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
 		})
 	}
 
-	if err := h.service.RegisterBaseFace(req.SessionID, imageData); err != nil {
+	token, status, err := h.resolveSessionToken(req.SessionID, req.Provider, req.AccountID)
+	if err != nil {
+		return c.JSON(status, tokenErrorResponse(err))
+	}
+
+	item := &models.CloudItem{ID: req.ItemID, DriveID: req.DriveID, Provider: token.Provider}
+	referenceCount, err := h.service.RegisterBaseFaceFromItem(req.SessionID, item, token, preprocess, model, req.Append)
+	if err != nil {
 		return handleServiceError(c, err)
 	}
 
 	return c.JSON(http.StatusOK, RegisterBaseFaceResponse{
-		Success: true,
+		Success:        true,
+		ReferenceCount: referenceCount,
 	})
 }
 
@@ -98,24 +199,149 @@ func (h *Handler) CompareFolder(c echo.Context) error {
 		})
 	}
 
-	token, err := h.sessionStore.GetSessionToken(req.SessionID, req.Provider)
+	preprocess, err := ParsePreprocessSteps(req.Preprocess)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	aggregation, err := ParseAggregation(req.Aggregation)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	model, err := ParseFaceModel(req.Model)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	dates, err := models.ParseDateRange(req.ModifiedAfter, req.ModifiedBefore)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	token, status, err := h.resolveSessionToken(req.SessionID, req.Provider, req.AccountID)
+	if err != nil {
+		return c.JSON(status, tokenErrorResponse(err))
+	}
+
+	idempotencyKey := strings.TrimSpace(c.Request().Header.Get("Idempotency-Key"))
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": fmt.Sprintf("Idempotency-Key must be at most %d characters", maxIdempotencyKeyLength),
+		})
+	}
+
+	// A non-zero MaxDistance replaces the Python service's default match threshold
+	options := compareOptions{
+		threshold:       req.MaxDistance,
+		preprocess:      preprocess,
+		aggregation:     aggregation,
+		model:           model,
+		recursive:       req.Recursive,
+		dates:           dates,
+		includeAllFiles: req.IncludeAllFiles,
+	}
+	jobID, err := h.service.WithIdempotencyKey(req.SessionID, idempotencyKey, func() (string, error) {
+		if req.Folder != nil {
+			return h.service.CompareFolderItemImages(req.SessionID, req.Folder, token, options)
+		}
+		return h.service.CompareFolderImages(req.SessionID, req.FolderLink, token, options)
+	})
+	if err != nil {
+		return handleServiceError(c, err)
+	}
+
+	if req.FolderLink != "" {
+		if err := h.sessionStore.RecordRecentFolder(req.SessionID, models.RecentFolder{Link: strings.TrimSpace(req.FolderLink), Provider: token.Provider}); err != nil {
+			log.Printf("Failed to record recent folder: %v", err)
+		}
+	}
+
+	return c.JSON(http.StatusOK, CompareFolderResponse{
+		JobID:  jobID,
+		Status: JobStatusProcessing,
+	})
+}
+
+func (h *Handler) CompareDrive(c echo.Context) error {
+	var req CompareDriveRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "Invalid request format",
+		})
+	}
+
+	if strings.TrimSpace(req.SessionID) == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "session_id is required",
+		})
+	}
+
+	model, err := ParseFaceModel(req.Model)
 	if err != nil {
-		return c.JSON(http.StatusUnauthorized, echo.Map{
-			"error": fmt.Sprintf("Authentication failed: %v", err),
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
 		})
 	}
 
-	jobID, err := h.service.CompareFolderImages(req.SessionID, req.FolderLink, token, req.Recursive)
+	token, status, err := h.resolveSessionToken(req.SessionID, req.Provider, req.AccountID)
+	if err != nil {
+		return c.JSON(status, tokenErrorResponse(err))
+	}
+
+	jobID, err := h.service.CompareDriveImages(req.SessionID, token, model)
 	if err != nil {
 		return handleServiceError(c, err)
 	}
 
 	return c.JSON(http.StatusOK, CompareFolderResponse{
 		JobID:  jobID,
-		Status: "processing",
+		Status: JobStatusProcessing,
 	})
 }
 
+func (h *Handler) EstimateComparison(c echo.Context) error {
+	var req EstimateRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "Invalid request format",
+		})
+	}
+
+	if err := validateCompareFolderRequest(&CompareFolderRequest{SessionID: req.SessionID, FolderLink: req.FolderLink, Folder: req.Folder}); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	dates, err := models.ParseDateRange(req.ModifiedAfter, req.ModifiedBefore)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	token, status, err := h.resolveSessionToken(req.SessionID, req.Provider, req.AccountID)
+	if err != nil {
+		return c.JSON(status, tokenErrorResponse(err))
+	}
+
+	estimate, err := h.service.EstimateFolderComparison(req.FolderLink, req.Folder, token, req.Recursive, dates)
+	if err != nil {
+		return handleServiceError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, estimate)
+}
+
 func (h *Handler) GetJobStatus(c echo.Context) error {
 	jobID := c.Param("jobId")
 
@@ -125,7 +351,20 @@ func (h *Handler) GetJobStatus(c echo.Context) error {
 		})
 	}
 
-	status, err := h.service.GetJobStatus(jobID)
+	debug := c.QueryParam("debug") == "true"
+
+	page := MatchPage{Cursor: c.QueryParam("cursor")}
+	if pageSize := c.QueryParam("page_size"); pageSize != "" {
+		size, err := strconv.Atoi(pageSize)
+		if err != nil || size < 1 || size > maxMatchPageSize {
+			return c.JSON(http.StatusBadRequest, echo.Map{
+				"error": fmt.Sprintf("page_size must be between 1 and %d", maxMatchPageSize),
+			})
+		}
+		page.Size = size
+	}
+
+	status, err := h.service.GetJobStatus(jobID, debug, page)
 	if err != nil {
 		return handleServiceError(c, err)
 	}
@@ -133,6 +372,230 @@ func (h *Handler) GetJobStatus(c echo.Context) error {
 	return c.JSON(http.StatusOK, status)
 }
 
+// StreamJobStatus pushes a job's status as server-sent events instead of the client polling for it
+// An event with the current status is sent right away and again on every change; the stream ends
+// once the job reaches a terminal status, is removed, or the client disconnects
+// Only the session that started the job can stream it, others get a 404 as for an unknown job
+func (h *Handler) StreamJobStatus(c echo.Context) error {
+	jobID := c.Param("jobId")
+	sessionID := c.QueryParam("session_id")
+
+	if strings.TrimSpace(jobID) == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "job_id is required",
+		})
+	}
+
+	if strings.TrimSpace(sessionID) == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "session_id is required",
+		})
+	}
+
+	changes, unsubscribe, err := h.service.SubscribeJob(sessionID, jobID)
+	if err != nil {
+		return handleServiceError(c, err)
+	}
+	defer unsubscribe()
+
+	response := c.Response()
+	response.Header().Set(echo.HeaderContentType, "text/event-stream")
+	response.Header().Set("Cache-Control", "no-store")
+	response.Header().Set("X-Accel-Buffering", "no")
+	response.WriteHeader(http.StatusOK)
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		status, err := h.service.GetJobStatus(jobID, false, MatchPage{})
+		if err != nil {
+			// The job was removed between the change and reading it
+			return nil
+		}
+		if err := writeStatusEvent(response, status); err != nil || status.Status.IsTerminal() {
+			return nil
+		}
+
+	wait:
+		for {
+			select {
+			case <-c.Request().Context().Done():
+				return nil
+			case _, open := <-changes:
+				if !open {
+					return nil
+				}
+				break wait
+			case <-keepAlive.C:
+				if _, err := fmt.Fprint(response, ": keep-alive\n\n"); err != nil {
+					return nil
+				}
+				response.Flush()
+			}
+		}
+	}
+}
+
+// writeStatusEvent writes a job status as one server-sent event and flushes it to the client
+func writeStatusEvent(response *echo.Response, status *JobStatusResponse) error {
+	data, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(response, "event: status\ndata: %s\n\n", data); err != nil {
+		return err
+	}
+	response.Flush()
+	return nil
+}
+
+// CancelJob stops a running comparison job of the caller's session, e.g. one started on the wrong folder
+func (h *Handler) CancelJob(c echo.Context) error {
+	jobID := c.Param("jobId")
+	sessionID := c.QueryParam("session_id")
+
+	if strings.TrimSpace(jobID) == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "job_id is required",
+		})
+	}
+
+	if strings.TrimSpace(sessionID) == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "session_id is required",
+		})
+	}
+
+	if err := h.service.CancelJob(sessionID, jobID); err != nil {
+		return handleServiceError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, CancelJobResponse{
+		JobID:  jobID,
+		Status: JobStatusCancelled,
+	})
+}
+
+// DeleteSessionJobs removes all jobs of the caller's session, cancelling running ones first,
+// and with clear_reference=true also clears the session's reference face
+func (h *Handler) DeleteSessionJobs(c echo.Context) error {
+	sessionID := c.QueryParam("session_id")
+
+	if strings.TrimSpace(sessionID) == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "session_id is required",
+		})
+	}
+
+	if _, err := h.sessionStore.GetSessionProviders(sessionID); err != nil {
+		return c.JSON(http.StatusUnauthorized, tokenErrorResponse(fmt.Errorf("Authentication failed: %w", err)))
+	}
+
+	clearReference := c.QueryParam("clear_reference") == "true"
+	response, err := h.service.DeleteSessionJobs(sessionID, clearReference)
+	if err != nil {
+		return handleServiceError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+func (h *Handler) RerunUnmatched(c echo.Context) error {
+	jobID := c.Param("jobId")
+
+	var req RerunUnmatchedRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "Invalid request format",
+		})
+	}
+
+	if strings.TrimSpace(jobID) == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "job_id is required",
+		})
+	}
+
+	if strings.TrimSpace(req.SessionID) == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "session_id is required",
+		})
+	}
+
+	if req.Threshold <= 0 || req.Threshold > 1 {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "threshold must be between 0 and 1",
+		})
+	}
+
+	newJobID, err := h.service.RerunUnmatched(req.SessionID, jobID, req.Threshold)
+	if err != nil {
+		return handleServiceError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, CompareFolderResponse{
+		JobID:  newJobID,
+		Status: JobStatusProcessing,
+	})
+}
+
+func (h *Handler) SaveResult(c echo.Context) error {
+	jobID := c.Param("jobId")
+
+	var req SaveResultRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "Invalid request format",
+		})
+	}
+
+	if strings.TrimSpace(jobID) == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "job_id is required",
+		})
+	}
+
+	if strings.TrimSpace(req.SessionID) == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "session_id is required",
+		})
+	}
+
+	manifest, err := h.service.SaveResult(req.SessionID, jobID)
+	if err != nil {
+		return handleServiceError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, SaveResultResponse{
+		Token:     manifest.Token,
+		ExpiresAt: manifest.ExpiresAt,
+	})
+}
+
+func (h *Handler) GetResult(c echo.Context) error {
+	resultToken := c.Param("token")
+	sessionID := c.QueryParam("session_id")
+
+	if strings.TrimSpace(sessionID) == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "session_id is required",
+		})
+	}
+
+	manifest, err := h.service.GetResultManifest(sessionID, resultToken)
+	if err != nil {
+		return handleServiceError(c, err)
+	}
+
+	token, err := h.sessionStore.GetSessionToken(sessionID, manifest.Provider, manifest.AccountID)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, tokenErrorResponse(fmt.Errorf("Authentication failed: %w", err)))
+	}
+
+	return c.JSON(http.StatusOK, h.service.ResolveResult(manifest, token))
+}
+
 func (h *Handler) ClearReferenceImage(c echo.Context) error {
 	sessionID := c.Param("sessionId")
 
@@ -152,6 +615,223 @@ func (h *Handler) ClearReferenceImage(c echo.Context) error {
 	})
 }
 
+// SaveReferenceFace keeps the session's reference face for the signed-in account, on the user's explicit request
+func (h *Handler) SaveReferenceFace(c echo.Context) error {
+	var req SavedReferenceRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "Invalid request format",
+		})
+	}
+
+	if strings.TrimSpace(req.SessionID) == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "session_id is required",
+		})
+	}
+
+	token, status, err := h.resolveSessionToken(req.SessionID, req.Provider, req.AccountID)
+	if err != nil {
+		return c.JSON(status, tokenErrorResponse(err))
+	}
+
+	reference, err := h.service.SaveReferenceFace(req.SessionID, token)
+	if err != nil {
+		return handleServiceError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, SaveReferenceResponse{
+		Success: true,
+		SavedAt: reference.SavedAt,
+	})
+}
+
+// UseSavedReferenceFace registers the signed-in account's saved reference face for the session
+func (h *Handler) UseSavedReferenceFace(c echo.Context) error {
+	var req SavedReferenceRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "Invalid request format",
+		})
+	}
+
+	if strings.TrimSpace(req.SessionID) == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "session_id is required",
+		})
+	}
+
+	token, status, err := h.resolveSessionToken(req.SessionID, req.Provider, req.AccountID)
+	if err != nil {
+		return c.JSON(status, tokenErrorResponse(err))
+	}
+
+	referenceCount, err := h.service.UseSavedReferenceFace(req.SessionID, token)
+	if err != nil {
+		return handleServiceError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, RegisterBaseFaceResponse{
+		Success:        true,
+		ReferenceCount: referenceCount,
+	})
+}
+
+// DeleteSavedReferenceFace forgets the signed-in account's saved reference face
+func (h *Handler) DeleteSavedReferenceFace(c echo.Context) error {
+	sessionID := c.Param("sessionId")
+
+	if strings.TrimSpace(sessionID) == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "session_id is required",
+		})
+	}
+
+	token, status, err := h.resolveSessionToken(sessionID, c.QueryParam("provider"), c.QueryParam("account_id"))
+	if err != nil {
+		return c.JSON(status, tokenErrorResponse(err))
+	}
+
+	if err := h.service.DeleteSavedReferenceFace(token); err != nil {
+		return handleServiceError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"success": true,
+		"message": "Saved reference face deleted successfully",
+	})
+}
+
+// SaveMatchesToDrive starts copying a completed job's matches into a folder of the user's own drive
+func (h *Handler) SaveMatchesToDrive(c echo.Context) error {
+	jobID := c.Param("jobId")
+
+	var req SaveToDriveRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "Invalid request format",
+		})
+	}
+
+	if strings.TrimSpace(jobID) == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "job_id is required",
+		})
+	}
+
+	if strings.TrimSpace(req.SessionID) == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "session_id is required",
+		})
+	}
+
+	if req.Destination == nil || strings.TrimSpace(req.Destination.ID) == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "destination folder is required",
+		})
+	}
+
+	exportFormats, err := ParseExportFormats(req.ExportFormats)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	if req.SkipPhotos && len(exportFormats) == 0 {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "export_formats is required when skip_photos is set",
+		})
+	}
+
+	token, status, err := h.resolveSessionToken(req.SessionID, req.Destination.Provider, req.AccountID)
+	if err != nil {
+		return c.JSON(status, tokenErrorResponse(err))
+	}
+
+	// A read-only token would only fail once the first copy reaches the provider
+	hasWriteAccess, err := h.sessionStore.HasScope(req.SessionID, token.Provider, token.AccountID, models.ScopeWrite)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, tokenErrorResponse(fmt.Errorf("Authentication failed: %w", err)))
+	}
+	if !hasWriteAccess {
+		// Signing in again requests the write scope along with the read scope
+		return c.JSON(http.StatusForbidden, echo.Map{
+			"error":           ErrInsufficientScope.Error(),
+			"reauth_provider": token.Provider,
+		})
+	}
+
+	saveJobID, err := h.service.SaveMatchesToDrive(req.SessionID, jobID, req.Destination, token, SaveOptions{
+		ExportFormats: exportFormats,
+		SkipPhotos:    req.SkipPhotos,
+	})
+	if err != nil {
+		return handleServiceError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, SaveToDriveResponse{
+		SaveJobID: saveJobID,
+		Status:    JobStatusProcessing,
+	})
+}
+
+// GetSaveJobStatus reports how far copying matches into a drive folder has progressed
+func (h *Handler) GetSaveJobStatus(c echo.Context) error {
+	saveJobID := c.Param("saveJobId")
+	sessionID := c.QueryParam("session_id")
+
+	if strings.TrimSpace(saveJobID) == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "save_job_id is required",
+		})
+	}
+
+	if strings.TrimSpace(sessionID) == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "session_id is required",
+		})
+	}
+
+	status, err := h.service.GetSaveJobStatus(sessionID, saveJobID)
+	if err != nil {
+		return handleServiceError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, status)
+}
+
+// resolveSessionToken returns the session's token for the requested provider, or the only one it has,
+// and for the requested account of the provider, or its only one
+// On failure it also returns the HTTP status to respond with
+func (h *Handler) resolveSessionToken(sessionID, requestedProvider, accountID string) (*models.Token, int, error) {
+	provider, err := models.ResolveProvider(h.sessionStore, sessionID, requestedProvider)
+	if errors.Is(err, models.ErrAmbiguousProvider) {
+		return nil, http.StatusBadRequest, err
+	}
+	if err != nil {
+		return nil, http.StatusUnauthorized, fmt.Errorf("Authentication failed: %w", err)
+	}
+
+	token, err := h.sessionStore.GetSessionToken(sessionID, provider, accountID)
+	if errors.Is(err, models.ErrAmbiguousAccount) {
+		return nil, http.StatusBadRequest, err
+	}
+	if err != nil {
+		return nil, http.StatusUnauthorized, fmt.Errorf("Authentication failed: %w", err)
+	}
+
+	return token, http.StatusOK, nil
+}
+
+// tokenErrorResponse is the body of a failed token lookup, with a code telling the frontend when to sign in again
+func tokenErrorResponse(err error) echo.Map {
+	response := echo.Map{"error": err.Error()}
+	if code := models.TokenErrorCode(err); code != "" {
+		response["code"] = code
+	}
+	return response
+}
+
 func validateRegisterRequest(req *RegisterBaseFaceRequest) error {
 	if strings.TrimSpace(req.SessionID) == "" {
 		return errors.New("session_id is required")
@@ -164,22 +844,37 @@ func validateCompareFolderRequest(req *CompareFolderRequest) error {
 		return errors.New("session_id is required")
 	}
 
-	if strings.TrimSpace(req.FolderLink) == "" {
-		return errors.New("folder_link is required")
+	if req.MaxDistance < 0 || req.MaxDistance > 1 {
+		return errors.New("max_distance must be between 0 and 1")
+	}
+
+	hasLink := strings.TrimSpace(req.FolderLink) != ""
+	hasFolder := req.Folder != nil
+	if !hasLink && !hasFolder {
+		return errors.New("either folder_link or folder is required")
+	}
+	if hasLink && hasFolder {
+		return errors.New("only one of folder_link or folder may be provided")
 	}
 
-	if strings.TrimSpace(req.Provider) == "" {
-		return errors.New("provider is required")
+	if hasFolder {
+		if strings.TrimSpace(req.Folder.ID) == "" {
+			return errors.New("folder.id is required")
+		}
+		if !req.Folder.IsFolder {
+			return errors.New("folder must be a folder item")
+		}
 	}
 
 	return nil
 }
 
-func validateImageFile(file *multipart.FileHeader) error {
-	const maxFileSize = 20 * 1024 * 1024 // 20MB
+// MaxBaseFaceSize is the largest base face image accepted for upload, in bytes
+const MaxBaseFaceSize = 20 * 1024 * 1024 // 20MB
 
-	if file.Size > maxFileSize {
-		return errors.New("image file size exceeds maximum allowed size of 20MB")
+func validateImageFile(file *multipart.FileHeader, contentTypes []string) error {
+	if file.Size > MaxBaseFaceSize {
+		return fmt.Errorf("image file size exceeds maximum allowed size of %dMB", MaxBaseFaceSize/(1024*1024))
 	}
 
 	if file.Size == 0 {
@@ -187,16 +882,8 @@ func validateImageFile(file *multipart.FileHeader) error {
 	}
 
 	contentType := file.Header.Get("Content-Type")
-	validTypes := map[string]bool{
-		"image/jpeg": true,
-		"image/jpg":  true,
-		"image/png":  true,
-		"image/heic": true,
-		"image/heif": true,
-	}
-
-	if !validTypes[contentType] {
-		return errors.New("invalid image format. Supported formats: JPEG, PNG, HEIC")
+	if !mediatypes.Contains(contentTypes, contentType) {
+		return fmt.Errorf("invalid image format. Supported formats: %s", strings.Join(contentTypes, ", "))
 	}
 
 	return nil
@@ -0,0 +1,39 @@
+package face
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestStreamJobStatus_OnlyStreamsOwnJobs(t *testing.T) {
+	service := createTestService(&mockStorageService{}, "")
+	service.jobManager.Store("job-1", "session-1", nil, nil, compareOptions{})
+	service.jobManager.MarkCompleted("job-1", nil)
+
+	e := echo.New()
+	NewHandler(service, nil).RegisterRoutes(e)
+
+	stream := func(query string) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/face/job-stream/job-1"+query, nil))
+		return rec
+	}
+
+	if rec := stream(""); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a session, got %d", rec.Code)
+	}
+
+	if rec := stream("?session_id=session-2"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for another session's job, got %d", rec.Code)
+	}
+
+	rec := stream("?session_id=session-1")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"status":"completed"`) {
+		t.Errorf("Expected the owner to get the completed status, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
@@ -7,6 +7,31 @@ import (
 
 type StorageService interface {
 	ParseShareLink(shareURL string, token *models.Token) (*models.CloudItem, error)
-	ListImages(item *models.CloudItem, token *models.Token, recursive bool) ([]*models.CloudItem, error)
+	ListImages(item *models.CloudItem, token *models.Token, recursive bool, dates models.DateRange) ([]*models.CloudItem, []string, error)
+	ListNonImageFiles(item *models.CloudItem, token *models.Token, recursive bool) ([]*models.CloudItem, error)
+	ListAllImages(token *models.Token, limit int) ([]*models.CloudItem, error)
 	GetFaceRecognitionOptimizedStream(item *models.CloudItem, token *models.Token) (io.ReadCloser, error)
+	GetFileStream(item *models.CloudItem, token *models.Token) (io.ReadCloser, error)
+	GetItem(item *models.CloudItem, token *models.Token) (*models.CloudItem, error)
+	GetAccountID(token *models.Token) (string, error)
+	CopyToFolder(item *models.CloudItem, destination *models.CloudItem, token *models.Token) error
+	UploadFile(destination *models.CloudItem, name, mimeType string, content []byte, token *models.Token) error
+}
+
+// TokenSource returns a session's current token for a provider account, refreshed when it was about to expire
+type TokenSource interface {
+	GetSessionToken(sessionID, provider, accountID string) (*models.Token, error)
+}
+
+// ResultStore persists saved result manifests by token
+type ResultStore interface {
+	SaveResult(manifest *ResultManifest) error
+	GetResult(token string) (*ResultManifest, error)
+}
+
+// ReferenceStore persists reference faces saved by accounts that opted in, by account ID
+type ReferenceStore interface {
+	SaveReference(reference *SavedReference) error
+	GetReference(accountID string) (*SavedReference, error)
+	DeleteReference(accountID string) error
 }
@@ -1,33 +1,94 @@
 package face
 
 import (
+	"all-me-backend/pkg/clock"
 	"all-me-backend/pkg/models"
+	"context"
+	"log"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// compareOptions holds per-job settings that affect how images are compared
+type compareOptions struct {
+	threshold  float64         // Maximum match distance, 0 uses the Python service default
+	rerunOf    string          // Job ID this job re-runs the unmatched images of
+	preprocess PreprocessSteps // Normalization applied to images before they are sent for comparison
+	// aggregation is how images are compared with several reference images, empty uses the Python service default
+	aggregation Aggregation
+	// model is the face model images are encoded with, which must match the session's base face
+	model FaceModel
+
+	recursive       bool             // Include images in subfolders of the compared folder
+	dates           models.DateRange // Only images modified within it are compared
+	includeAllFiles bool             // Return every listed file, not just matches, once the job completes
+
+	// documents holds the images extracted from the job's documents for all of its batches, see documentCache
+	documents *documentCache
+}
+
 type jobContext struct {
-	allImages    []*models.CloudItem
-	token        *models.Token
-	createdAt    time.Time
-	status       string
-	currentImage int
-	totalImages  int
-	matchesFound int
-	matches      []pythonMatchResult
-	errorMessage string
+	sessionID       string
+	options         compareOptions
+	allImages       []*models.CloudItem
+	token           *models.Token
+	createdAt       time.Time
+	status          JobStatus
+	currentImage    int
+	totalImages     int
+	matchesFound    int
+	imagesWithFaces int // Images in which the face service detected any face, counted once the job completes
+	matches         []pythonMatchResult
+	imageErrors     []pythonImageError  // Images the face service could not process, by global index
+	exactDuplicates int                 // Images skipped as byte-identical to an earlier one
+	nearDuplicates  int                 // Images skipped as looking the same as an earlier one
+	failedImages    []FailedImage       // Images left out because they could not be downloaded or read
+	otherFiles      []*models.CloudItem // Non-image files of the folder, kept when the listing is requested
+	folder          *models.CloudItem   // Folder the job compares, nil for whole-drive and re-run jobs
+	folderLink      string              // Share link the folder was resolved from, if any
+	warnings        []string
+	errorMessage    string
+	cancel          context.CancelFunc // Stops the job's downloads and polling when it is cancelled
+}
+
+// idempotencyEntry records the job started for an idempotency key
+// done is closed once the first request for the key has finished starting its job
+type idempotencyEntry struct {
+	jobID     string
+	err       error
+	done      chan struct{}
+	expiresAt time.Time
 }
 
 // JobManager manages job contexts for face comparison operations
 // It provides thread-safe storage and retrieval of job contexts
 type JobManager struct {
-	contexts map[string]*jobContext
-	mu       sync.RWMutex
+	contexts        map[string]*jobContext
+	sessionJobs     map[string][]string          // session ID -> job IDs, oldest first
+	idempotencyKeys map[string]*idempotencyEntry // session ID + idempotency key -> entry
+	subscribers     map[string][]chan struct{}   // job ID -> channels signalled when the job changes
+	clock           clock.Clock
+	mu              sync.RWMutex
+
+	// maxJobsPerSession caps the jobs kept per session, evicting the oldest finished ones; 0 keeps all
+	maxJobsPerSession int
+	evictedJobs       atomic.Int64
 }
 
 func NewJobManager() *JobManager {
+	return NewJobManagerWithClock(clock.Real{})
+}
+
+// NewJobManagerWithClock creates a job manager that measures job and idempotency key expiry with the given clock
+func NewJobManagerWithClock(clk clock.Clock) *JobManager {
 	jm := &JobManager{
-		contexts: make(map[string]*jobContext),
+		contexts:        make(map[string]*jobContext),
+		sessionJobs:     make(map[string][]string),
+		idempotencyKeys: make(map[string]*idempotencyEntry),
+		subscribers:     make(map[string][]chan struct{}),
+		clock:           clk,
 	}
 
 	go jm.cleanupExpiredJobs()
@@ -40,30 +101,109 @@ func (jm *JobManager) cleanupExpiredJobs() {
 	defer ticker.Stop()
 
 	for range ticker.C {
-		jm.mu.Lock()
-		now := time.Now()
-		for jobID, ctx := range jm.contexts {
-			// Remove contexts older than 24 hours
-			if now.Sub(ctx.createdAt) > 24*time.Hour {
-				delete(jm.contexts, jobID)
-			}
+		jm.removeExpired()
+	}
+}
+
+// removeExpired deletes job contexts older than 24 hours and expired idempotency keys
+func (jm *JobManager) removeExpired() {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+
+	now := jm.clock.Now()
+	for jobID, ctx := range jm.contexts {
+		if now.Sub(ctx.createdAt) > 24*time.Hour {
+			jm.remove(jobID)
+		}
+	}
+	for key, entry := range jm.idempotencyKeys {
+		if now.After(entry.expiresAt) {
+			delete(jm.idempotencyKeys, key)
 		}
-		jm.mu.Unlock()
 	}
 }
 
-func (jm *JobManager) Store(jobID string, allImages []*models.CloudItem, token *models.Token) {
+// Store registers a new running job
+// The returned context is done once the job is cancelled, and should bound all of the job's background work
+func (jm *JobManager) Store(jobID, sessionID string, allImages []*models.CloudItem, token *models.Token, options compareOptions) context.Context {
 	jm.mu.Lock()
 	defer jm.mu.Unlock()
 
+	ctx, cancel := context.WithCancel(context.Background())
 	jm.contexts[jobID] = &jobContext{
+		sessionID:    sessionID,
+		options:      options,
 		allImages:    allImages,
 		token:        token,
-		createdAt:    time.Now(),
-		status:       "processing",
+		createdAt:    jm.clock.Now(),
+		status:       JobStatusProcessing,
 		totalImages:  len(allImages),
 		currentImage: 0,
 		matchesFound: 0,
+		cancel:       cancel,
+	}
+	jm.sessionJobs[sessionID] = append(jm.sessionJobs[sessionID], jobID)
+
+	jm.evictOldJobs(sessionID)
+
+	return ctx
+}
+
+// evictOldJobs removes a session's oldest finished jobs while it has more than maxJobsPerSession
+// Running jobs are never evicted, so a session with many of them can stay over the limit
+// The caller must hold the lock
+func (jm *JobManager) evictOldJobs(sessionID string) {
+	excess := len(jm.sessionJobs[sessionID]) - jm.maxJobsPerSession
+	if jm.maxJobsPerSession <= 0 || excess <= 0 {
+		return
+	}
+
+	var evicted []string
+	for _, jobID := range jm.sessionJobs[sessionID] {
+		if len(evicted) == excess {
+			break
+		}
+		if jm.contexts[jobID].status.IsTerminal() {
+			evicted = append(evicted, jobID)
+		}
+	}
+
+	for _, jobID := range evicted {
+		jm.remove(jobID)
+	}
+
+	if len(evicted) > 0 {
+		jm.evictedJobs.Add(int64(len(evicted)))
+		log.Printf("Session %s: evicted %d oldest finished jobs to stay within %d jobs per session", sessionID, len(evicted), jm.maxJobsPerSession)
+	}
+}
+
+// EvictedJobs returns how many finished jobs were evicted to respect the per-session job limit
+func (jm *JobManager) EvictedJobs() int64 {
+	return jm.evictedJobs.Load()
+}
+
+// remove deletes a job context and its entry in the session index
+// The caller must hold the lock
+func (jm *JobManager) remove(jobID string) {
+	ctx, exists := jm.contexts[jobID]
+	if !exists {
+		return
+	}
+	delete(jm.contexts, jobID)
+	ctx.cancel()
+
+	// Subscribers learn the job is gone from their channel closing
+	for _, ch := range jm.subscribers[jobID] {
+		close(ch)
+	}
+	delete(jm.subscribers, jobID)
+
+	jobIDs := slices.DeleteFunc(jm.sessionJobs[ctx.sessionID], func(id string) bool { return id == jobID })
+	if len(jobIDs) == 0 {
+		delete(jm.sessionJobs, ctx.sessionID)
+	} else {
+		jm.sessionJobs[ctx.sessionID] = jobIDs
 	}
 }
 
@@ -75,6 +215,45 @@ func (jm *JobManager) UpdateProgress(jobID string, currentImage, totalImages, ma
 		ctx.currentImage = currentImage
 		ctx.totalImages = totalImages
 		ctx.matchesFound = matchesFound
+		jm.notify(jobID)
+	}
+}
+
+// Subscribe returns a channel signalled whenever the job's status changes, and closed once the job is removed
+// Signals are coalesced, so a slow subscriber sees at least the latest change; unsubscribe releases the channel
+func (jm *JobManager) Subscribe(jobID string) (changes <-chan struct{}, unsubscribe func(), ok bool) {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+
+	if _, exists := jm.contexts[jobID]; !exists {
+		return nil, nil, false
+	}
+
+	ch := make(chan struct{}, 1)
+	jm.subscribers[jobID] = append(jm.subscribers[jobID], ch)
+
+	unsubscribe = func() {
+		jm.mu.Lock()
+		defer jm.mu.Unlock()
+
+		channels := slices.DeleteFunc(jm.subscribers[jobID], func(c chan struct{}) bool { return c == ch })
+		if len(channels) == 0 {
+			delete(jm.subscribers, jobID)
+		} else {
+			jm.subscribers[jobID] = channels
+		}
+	}
+	return ch, unsubscribe, true
+}
+
+// notify signals the job's subscribers without blocking on ones that have not caught up
+// The caller must hold the lock
+func (jm *JobManager) notify(jobID string) {
+	for _, ch := range jm.subscribers[jobID] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
 	}
 }
 
@@ -82,24 +261,154 @@ func (jm *JobManager) MarkCompleted(jobID string, matches []pythonMatchResult) {
 	jm.mu.Lock()
 	defer jm.mu.Unlock()
 
-	if ctx, exists := jm.contexts[jobID]; exists {
-		ctx.status = "completed"
+	if ctx, exists := jm.contexts[jobID]; exists && ctx.status.CanTransitionTo(JobStatusCompleted) {
+		ctx.status = JobStatusCompleted
 		ctx.matches = matches
 		ctx.matchesFound = len(matches)
 		ctx.currentImage = ctx.totalImages
+		jm.notify(jobID)
 	}
 }
 
-func (jm *JobManager) MarkFailed(jobID string, errorMessage string) {
+// AppendMatches adds the matches of a finished batch to a running job, kept sorted by image index
+// The slice is replaced rather than appended to, so a status being read keeps a consistent view
+// MarkCompleted later replaces them with the matches of all batches
+func (jm *JobManager) AppendMatches(jobID string, matches []pythonMatchResult) {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+
+	ctx, exists := jm.contexts[jobID]
+	if !exists || ctx.status != JobStatusProcessing || len(matches) == 0 {
+		return
+	}
+
+	merged := append(slices.Clone(ctx.matches), matches...)
+	slices.SortFunc(merged, func(a, b pythonMatchResult) int {
+		return a.Index - b.Index
+	})
+	ctx.matches = merged
+	jm.notify(jobID)
+}
+
+// Elapsed returns how long ago a job was created
+func (jm *JobManager) Elapsed(jobID string) (time.Duration, bool) {
+	jm.mu.RLock()
+	defer jm.mu.RUnlock()
+
+	ctx, exists := jm.contexts[jobID]
+	if !exists {
+		return 0, false
+	}
+	return jm.clock.Now().Sub(ctx.createdAt), true
+}
+
+// AddWarnings attaches non-fatal warnings to a job
+func (jm *JobManager) AddWarnings(jobID string, warnings []string) {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+
+	if ctx, exists := jm.contexts[jobID]; exists {
+		ctx.warnings = append(ctx.warnings, warnings...)
+	}
+}
+
+// SetOtherFiles stores the non-image files listed alongside the job's images
+func (jm *JobManager) SetOtherFiles(jobID string, files []*models.CloudItem) {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+
+	if ctx, exists := jm.contexts[jobID]; exists {
+		ctx.otherFiles = files
+	}
+}
+
+// SetFolder records which folder a job compares
+func (jm *JobManager) SetFolder(jobID string, folder *models.CloudItem, folderLink string) {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+
+	if ctx, exists := jm.contexts[jobID]; exists {
+		ctx.folder = folder
+		ctx.folderLink = folderLink
+	}
+}
+
+// RecordImageErrors stores per-image processing errors for diagnostics
+func (jm *JobManager) RecordImageErrors(jobID string, imageErrors []pythonImageError) {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+
+	if ctx, exists := jm.contexts[jobID]; exists {
+		ctx.imageErrors = imageErrors
+	}
+}
+
+// RecordFailedImages adds images that were left out because they could not be downloaded or read
+func (jm *JobManager) RecordFailedImages(jobID string, failed []FailedImage) {
+	if len(failed) == 0 {
+		return
+	}
+
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+
+	if ctx, exists := jm.contexts[jobID]; exists {
+		ctx.failedImages = append(ctx.failedImages, failed...)
+	}
+}
+
+// RecordDuplicates stores how many images were skipped as exact or near duplicates of another
+func (jm *JobManager) RecordDuplicates(jobID string, exact, near int) {
 	jm.mu.Lock()
 	defer jm.mu.Unlock()
 
 	if ctx, exists := jm.contexts[jobID]; exists {
-		ctx.status = "failed"
+		ctx.exactDuplicates = exact
+		ctx.nearDuplicates = near
+	}
+}
+
+// RecordImagesWithFaces stores how many images had any detectable face
+func (jm *JobManager) RecordImagesWithFaces(jobID string, count int) {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+
+	if ctx, exists := jm.contexts[jobID]; exists {
+		ctx.imagesWithFaces = count
+	}
+}
+
+func (jm *JobManager) MarkFailed(jobID string, errorMessage string) {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+
+	if ctx, exists := jm.contexts[jobID]; exists && ctx.status.CanTransitionTo(JobStatusFailed) {
+		ctx.status = JobStatusFailed
 		ctx.errorMessage = errorMessage
+		jm.notify(jobID)
 	}
 }
 
+// MarkCancelled stops a running job and marks it cancelled
+// The job stays until its status is read, so the client polling it learns it was cancelled
+func (jm *JobManager) MarkCancelled(jobID string) error {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+
+	ctx, exists := jm.contexts[jobID]
+	if !exists {
+		return ErrJobNotFound
+	}
+	if !ctx.status.CanTransitionTo(JobStatusCancelled) {
+		return ErrJobFinished
+	}
+
+	ctx.status = JobStatusCancelled
+	ctx.cancel()
+	jm.notify(jobID)
+	return nil
+}
+
 func (jm *JobManager) Get(jobID string) (*jobContext, bool) {
 	jm.mu.RLock()
 	defer jm.mu.RUnlock()
@@ -108,9 +417,91 @@ func (jm *JobManager) Get(jobID string) (*jobContext, bool) {
 	return ctx, exists
 }
 
+// Snapshot returns a copy of a job's context, safe to read while the job keeps running
+// Slices in it are only ever replaced or appended to, so the copy's view of them stays consistent
+func (jm *JobManager) Snapshot(jobID string) (*jobContext, bool) {
+	jm.mu.RLock()
+	defer jm.mu.RUnlock()
+
+	ctx, exists := jm.contexts[jobID]
+	if !exists {
+		return nil, false
+	}
+	snapshot := *ctx
+	return &snapshot, true
+}
+
+// HasRunningJobs reports whether any job of the session has not reached a terminal status
+func (jm *JobManager) HasRunningJobs(sessionID string) bool {
+	jm.mu.RLock()
+	defer jm.mu.RUnlock()
+
+	for _, jobID := range jm.sessionJobs[sessionID] {
+		if !jm.contexts[jobID].status.IsTerminal() {
+			return true
+		}
+	}
+	return false
+}
+
 func (jm *JobManager) Delete(jobID string) {
 	jm.mu.Lock()
 	defer jm.mu.Unlock()
 
-	delete(jm.contexts, jobID)
+	jm.remove(jobID)
+}
+
+// DeleteSessionJobs removes every job of the session, cancelling the ones still running
+// It returns how many running jobs were cancelled and how many finished jobs were deleted
+func (jm *JobManager) DeleteSessionJobs(sessionID string) (cancelled, deleted int) {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+
+	for _, jobID := range slices.Clone(jm.sessionJobs[sessionID]) {
+		if jm.contexts[jobID].status.IsTerminal() {
+			deleted++
+		} else {
+			cancelled++
+		}
+		jm.remove(jobID)
+	}
+	return cancelled, deleted
+}
+
+// ClaimIdempotencyKey returns the entry for a session's idempotency key
+// owner is true when no live entry existed, in which case the caller must start the job
+// and report it with CompleteIdempotencyKey; other callers wait on the entry's done channel
+func (jm *JobManager) ClaimIdempotencyKey(sessionID, key string, ttl time.Duration) (entry *idempotencyEntry, owner bool) {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+
+	mapKey := sessionID + "\x00" + key
+	if existing, exists := jm.idempotencyKeys[mapKey]; exists && jm.clock.Now().Before(existing.expiresAt) {
+		return existing, false
+	}
+
+	entry = &idempotencyEntry{
+		done:      make(chan struct{}),
+		expiresAt: jm.clock.Now().Add(ttl),
+	}
+	jm.idempotencyKeys[mapKey] = entry
+
+	return entry, true
+}
+
+// CompleteIdempotencyKey records the outcome of starting a job for a claimed key
+// Failed starts are forgotten so the client can retry with the same key
+func (jm *JobManager) CompleteIdempotencyKey(sessionID, key string, entry *idempotencyEntry, jobID string, err error) {
+	jm.mu.Lock()
+	defer jm.mu.Unlock()
+
+	entry.jobID = jobID
+	entry.err = err
+
+	mapKey := sessionID + "\x00" + key
+	if err != nil && jm.idempotencyKeys[mapKey] == entry {
+		delete(jm.idempotencyKeys, mapKey)
+	}
+
+	close(entry.done)
 }
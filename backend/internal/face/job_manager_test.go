@@ -0,0 +1,174 @@
+package face
+
+import (
+	"all-me-backend/pkg/clock"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestJobManager_RemovesExpiredJobs(t *testing.T) {
+	fakeClock := clock.NewFake(time.Now())
+	jm := NewJobManagerWithClock(fakeClock)
+
+	jm.Store("old-job", "session-1", nil, nil, compareOptions{})
+	fakeClock.Advance(23 * time.Hour)
+	jm.Store("new-job", "session-1", nil, nil, compareOptions{})
+	fakeClock.Advance(2 * time.Hour)
+
+	jm.removeExpired()
+
+	if _, exists := jm.Get("old-job"); exists {
+		t.Error("Expected job older than 24 hours to be removed")
+	}
+	if _, exists := jm.Get("new-job"); !exists {
+		t.Error("Expected recent job to be kept")
+	}
+}
+
+func TestJobManager_IdempotencyKeyExpires(t *testing.T) {
+	fakeClock := clock.NewFake(time.Now())
+	jm := NewJobManagerWithClock(fakeClock)
+
+	entry, owner := jm.ClaimIdempotencyKey("session-1", "key-1", time.Minute)
+	if !owner {
+		t.Fatal("Expected first claim to own the key")
+	}
+	jm.CompleteIdempotencyKey("session-1", "key-1", entry, "job-1", nil)
+
+	if _, owner := jm.ClaimIdempotencyKey("session-1", "key-1", time.Minute); owner {
+		t.Error("Expected live key to return the existing entry")
+	}
+
+	fakeClock.Advance(2 * time.Minute)
+	if _, owner := jm.ClaimIdempotencyKey("session-1", "key-1", time.Minute); !owner {
+		t.Error("Expected expired key to be claimable again")
+	}
+}
+
+func TestJobManager_EvictsOldestFinishedJobsPerSession(t *testing.T) {
+	jm := NewJobManagerWithClock(clock.NewFake(time.Now()))
+	jm.maxJobsPerSession = 2
+
+	jm.Store("running-job", "session-1", nil, nil, compareOptions{})
+	jm.Store("finished-job", "session-1", nil, nil, compareOptions{})
+	jm.MarkCompleted("finished-job", nil)
+	jm.Store("other-session-job", "session-2", nil, nil, compareOptions{})
+	jm.MarkCompleted("other-session-job", nil)
+
+	// The oldest job is still running, so the oldest finished job goes instead
+	jm.Store("new-job", "session-1", nil, nil, compareOptions{})
+
+	if _, exists := jm.Get("finished-job"); exists {
+		t.Error("Expected the oldest finished job to be evicted")
+	}
+	for _, jobID := range []string{"running-job", "new-job", "other-session-job"} {
+		if _, exists := jm.Get(jobID); !exists {
+			t.Errorf("Expected %s to be kept", jobID)
+		}
+	}
+
+	// With only running jobs left, the session may exceed the limit
+	jm.Store("another-job", "session-1", nil, nil, compareOptions{})
+
+	if len(jm.sessionJobs["session-1"]) != 3 {
+		t.Errorf("Expected running jobs to be kept over the limit, got %v", jm.sessionJobs["session-1"])
+	}
+	if jm.EvictedJobs() != 1 {
+		t.Errorf("Expected 1 evicted job, got %d", jm.EvictedJobs())
+	}
+}
+
+func TestJobManager_MarkCancelled(t *testing.T) {
+	jm := NewJobManagerWithClock(clock.NewFake(time.Now()))
+
+	ctx := jm.Store("running-job", "session-1", nil, nil, compareOptions{})
+	if err := jm.MarkCancelled("running-job"); err != nil {
+		t.Fatalf("MarkCancelled failed: %v", err)
+	}
+	if ctx.Err() == nil {
+		t.Error("Expected the job's context to be done once it is cancelled")
+	}
+	if job, _ := jm.Get("running-job"); job.status != JobStatusCancelled {
+		t.Errorf("Expected status %q, got %q", JobStatusCancelled, job.status)
+	}
+
+	jm.Store("finished-job", "session-1", nil, nil, compareOptions{})
+	jm.MarkCompleted("finished-job", nil)
+	if err := jm.MarkCancelled("finished-job"); !errors.Is(err, ErrJobFinished) {
+		t.Errorf("Expected ErrJobFinished for a completed job, got %v", err)
+	}
+
+	if err := jm.MarkCancelled("unknown-job"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Expected ErrJobNotFound for an unknown job, got %v", err)
+	}
+}
+
+func TestJobManager_DeleteSessionJobs(t *testing.T) {
+	jm := NewJobManagerWithClock(clock.NewFake(time.Now()))
+
+	running := jm.Store("running-job", "session-1", nil, nil, compareOptions{})
+	jm.Store("finished-job", "session-1", nil, nil, compareOptions{})
+	jm.MarkCompleted("finished-job", nil)
+	jm.Store("other-job", "session-2", nil, nil, compareOptions{})
+
+	cancelled, deleted := jm.DeleteSessionJobs("session-1")
+	if cancelled != 1 || deleted != 1 {
+		t.Errorf("Expected 1 cancelled and 1 deleted job, got %d and %d", cancelled, deleted)
+	}
+	if running.Err() == nil {
+		t.Error("Expected the running job's context to be done")
+	}
+	for _, jobID := range []string{"running-job", "finished-job"} {
+		if _, exists := jm.Get(jobID); exists {
+			t.Errorf("Expected %s to be removed", jobID)
+		}
+	}
+	if _, exists := jm.Get("other-job"); !exists {
+		t.Error("Expected another session's job to be kept")
+	}
+}
+
+func TestJobManager_SubscribeSignalsChanges(t *testing.T) {
+	jm := NewJobManagerWithClock(clock.NewFake(time.Now()))
+
+	if _, _, ok := jm.Subscribe("unknown-job"); ok {
+		t.Error("Expected subscribing to an unknown job to fail")
+	}
+
+	jm.Store("job-1", "session-1", nil, nil, compareOptions{})
+	changes, unsubscribe, ok := jm.Subscribe("job-1")
+	if !ok {
+		t.Fatal("Expected to subscribe to the job")
+	}
+
+	// Changes a subscriber has not caught up with are coalesced into one signal
+	jm.UpdateProgress("job-1", 1, 10, 0)
+	jm.UpdateProgress("job-1", 2, 10, 0)
+	select {
+	case <-changes:
+	default:
+		t.Fatal("Expected a signal after the job's progress changed")
+	}
+	select {
+	case <-changes:
+		t.Error("Expected pending signals to be coalesced")
+	default:
+	}
+
+	other, unsubscribeOther, _ := jm.Subscribe("job-1")
+	unsubscribeOther()
+	jm.MarkCompleted("job-1", nil)
+	select {
+	case <-other:
+		t.Error("Expected no signal after unsubscribing")
+	default:
+	}
+
+	jm.Delete("job-1")
+	<-changes // The completion signal
+	if _, open := <-changes; open {
+		t.Error("Expected the channel to be closed once the job is removed")
+	}
+	unsubscribe()
+}
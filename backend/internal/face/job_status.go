@@ -0,0 +1,52 @@
+package face
+
+// JobStatus is the lifecycle state of a comparison job
+type JobStatus string
+
+const (
+	JobStatusQueued     JobStatus = "queued"     // Accepted, waiting for capacity to start
+	JobStatusProcessing JobStatus = "processing" // Downloading and comparing images
+	JobStatusStalled    JobStatus = "stalled"    // Still running, but has not made progress for a while
+	JobStatusCompleted  JobStatus = "completed"
+	JobStatusFailed     JobStatus = "failed"
+	JobStatusCancelled  JobStatus = "cancelled"
+)
+
+// jobTransitions lists the states each non-terminal state may move to
+var jobTransitions = map[JobStatus][]JobStatus{
+	JobStatusQueued:     {JobStatusProcessing, JobStatusFailed, JobStatusCancelled},
+	JobStatusProcessing: {JobStatusStalled, JobStatusCompleted, JobStatusFailed, JobStatusCancelled},
+	JobStatusStalled:    {JobStatusProcessing, JobStatusCompleted, JobStatusFailed, JobStatusCancelled},
+}
+
+func (s JobStatus) String() string {
+	return string(s)
+}
+
+// IsTerminal reports whether the job has finished and its status will not change again
+func (s JobStatus) IsTerminal() bool {
+	return s == JobStatusCompleted || s == JobStatusFailed || s == JobStatusCancelled
+}
+
+// CanTransitionTo reports whether a job may move from this status to next
+func (s JobStatus) CanTransitionTo(next JobStatus) bool {
+	for _, allowed := range jobTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePythonJobStatus maps a status reported by the Python service to a JobStatus
+// The Python service reports failures as "failed", older versions used "error"
+func parsePythonJobStatus(status string) JobStatus {
+	switch status {
+	case "completed":
+		return JobStatusCompleted
+	case "failed", "error":
+		return JobStatusFailed
+	default:
+		return JobStatusProcessing
+	}
+}
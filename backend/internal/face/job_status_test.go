@@ -0,0 +1,74 @@
+package face
+
+import "testing"
+
+func TestJobStatus_Transitions(t *testing.T) {
+	tests := []struct {
+		from     JobStatus
+		to       JobStatus
+		expected bool
+	}{
+		{from: JobStatusQueued, to: JobStatusProcessing, expected: true},
+		{from: JobStatusQueued, to: JobStatusCompleted, expected: false},
+		{from: JobStatusProcessing, to: JobStatusStalled, expected: true},
+		{from: JobStatusStalled, to: JobStatusProcessing, expected: true},
+		{from: JobStatusProcessing, to: JobStatusCancelled, expected: true},
+		{from: JobStatusCancelled, to: JobStatusCompleted, expected: false},
+		{from: JobStatusCompleted, to: JobStatusFailed, expected: false},
+		{from: JobStatusFailed, to: JobStatusProcessing, expected: false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.from.CanTransitionTo(tt.to); got != tt.expected {
+			t.Errorf("Expected %s -> %s to be %v, got %v", tt.from, tt.to, tt.expected, got)
+		}
+	}
+}
+
+func TestJobStatus_IsTerminal(t *testing.T) {
+	terminal := map[JobStatus]bool{
+		JobStatusQueued:     false,
+		JobStatusProcessing: false,
+		JobStatusStalled:    false,
+		JobStatusCompleted:  true,
+		JobStatusFailed:     true,
+		JobStatusCancelled:  true,
+	}
+
+	for status, expected := range terminal {
+		if got := status.IsTerminal(); got != expected {
+			t.Errorf("Expected IsTerminal for %s to be %v, got %v", status, expected, got)
+		}
+		if status.String() != string(status) {
+			t.Errorf("Expected String() to return %q, got %q", string(status), status.String())
+		}
+	}
+}
+
+func TestJobManager_TerminalStatusIsFinal(t *testing.T) {
+	jm := NewJobManager()
+	jm.Store("job-1", "session-1", nil, nil, compareOptions{})
+
+	jm.MarkFailed("job-1", "boom")
+	jm.MarkCompleted("job-1", nil)
+
+	ctx, _ := jm.Get("job-1")
+	if ctx.status != JobStatusFailed {
+		t.Errorf("Expected status to stay %s, got %s", JobStatusFailed, ctx.status)
+	}
+}
+
+func TestParsePythonJobStatus(t *testing.T) {
+	tests := map[string]JobStatus{
+		"processing": JobStatusProcessing,
+		"completed":  JobStatusCompleted,
+		"failed":     JobStatusFailed,
+		"error":      JobStatusFailed,
+	}
+
+	for value, expected := range tests {
+		if got := parsePythonJobStatus(value); got != expected {
+			t.Errorf("Expected %q to map to %s, got %s", value, expected, got)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package face
+
+import "sync"
+
+// defaultMaxInFlightBytes bounds the image data buffered by all jobs together
+const defaultMaxInFlightBytes = 512 * 1024 * 1024
+
+// imageReservationBytes is how much of the budget a batch reserves per image before downloading it,
+// as image sizes are only known once downloaded
+const imageReservationBytes = 2 * 1024 * 1024
+
+// memoryBudget bounds how many bytes of downloaded images all jobs hold at once, from download until
+// their batch is sent to the Python service, so many concurrent jobs cannot exhaust memory together
+// Batches reserve their share up front and never wait again while holding it, so jobs cannot deadlock
+type memoryBudget struct {
+	mu    sync.Mutex
+	freed *sync.Cond
+	limit int64
+	used  int64
+}
+
+func newMemoryBudget(limit int64) *memoryBudget {
+	budget := &memoryBudget{limit: limit}
+	budget.freed = sync.NewCond(&budget.mu)
+	return budget
+}
+
+// acquire blocks until n bytes fit in the budget and reserves them, returning the bytes reserved
+// A reservation larger than the whole budget is capped to it, so it can always be granted eventually
+func (b *memoryBudget) acquire(n int64) int64 {
+	n = min(n, b.limit)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for b.used+n > b.limit {
+		b.freed.Wait()
+	}
+	b.used += n
+	return n
+}
+
+// resize replaces a reservation of from bytes with one of to bytes, e.g. once the actual size is known
+// It never blocks: growing may exceed the budget, which then holds back new reservations until it is freed
+func (b *memoryBudget) resize(from, to int64) int64 {
+	b.mu.Lock()
+	b.used += to - from
+	b.mu.Unlock()
+
+	if to < from {
+		b.freed.Broadcast()
+	}
+	return to
+}
+
+// release frees a reservation returned by acquire or resize
+func (b *memoryBudget) release(n int64) {
+	b.resize(n, 0)
+}
+
+// inUse returns how many bytes are currently reserved
+func (b *memoryBudget) inUse() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.used
+}
+
+// encodedSize is the image data held by a downloaded batch
+func encodedSize(images []encodedImage) int64 {
+	var size int64
+	for _, image := range images {
+		size += int64(len(image.data))
+	}
+	return size
+}
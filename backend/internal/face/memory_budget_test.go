@@ -0,0 +1,65 @@
+package face
+
+import (
+	"all-me-backend/pkg/models"
+	"testing"
+	"time"
+)
+
+func TestMemoryBudget_BlocksUntilReleased(t *testing.T) {
+	budget := newMemoryBudget(100)
+
+	first := budget.acquire(60)
+
+	acquired := make(chan int64)
+	go func() {
+		acquired <- budget.acquire(60)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("Expected the second reservation to wait for the budget")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Shrinking the first reservation to its actual size makes room
+	first = budget.resize(first, 30)
+	select {
+	case second := <-acquired:
+		if second != 60 || budget.inUse() != 90 {
+			t.Errorf("Expected 90 bytes in use, got %d", budget.inUse())
+		}
+		budget.release(second)
+	case <-time.After(time.Second):
+		t.Fatal("Expected the second reservation once the first shrank")
+	}
+
+	budget.release(first)
+	if budget.inUse() != 0 {
+		t.Errorf("Expected the budget to be free, got %d in use", budget.inUse())
+	}
+
+	// Reservations larger than the budget are capped so they can be granted
+	if capped := budget.acquire(500); capped != 100 {
+		t.Errorf("Expected the reservation capped to 100, got %d", capped)
+	}
+}
+
+func TestProcessBatches_ReleasesMemoryBudget(t *testing.T) {
+	pythonServer := newMockPythonServer(t)
+	storage := &mockStorageService{}
+	service := createTestService(storage, pythonServer.URL)
+
+	images := []*models.CloudItem{{ID: "1", Name: "a.jpg"}, {ID: "2", Name: "b.jpg"}}
+	token := &models.Token{AccessToken: "token", Provider: "googledrive"}
+	jobID, err := service.processFolderInBatches("session-1", images, token, compareOptions{})
+	if err != nil {
+		t.Fatalf("processFolderInBatches failed: %v", err)
+	}
+
+	waitForJobStatus(t, service, jobID, JobStatusCompleted)
+
+	if inUse := service.memoryBudget.inUse(); inUse != 0 {
+		t.Errorf("Expected the memory budget to be released once batches are sent, got %d bytes in use", inUse)
+	}
+}
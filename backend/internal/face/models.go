@@ -1,52 +1,254 @@
 package face
 
-import "all-me-backend/pkg/models"
+import (
+	"all-me-backend/pkg/models"
+	"time"
+)
 
 type RegisterBaseFaceRequest struct {
-	SessionID string `form:"session_id"`
+	SessionID  string `form:"session_id"`
+	Preprocess string `form:"preprocess"` // Comma-separated steps (orientation, downscale, transcode) or "none", all by default
+	// Append adds the image to the session's reference images instead of replacing them,
+	// e.g. to register the same person from several angles
+	Append bool `form:"append"`
+	// Model is the face model the image is encoded with, "small" (default) or "large"
+	// Comparisons for the session must use the same model
+	Model string `form:"model"`
+}
+
+// RegisterFromFolderItemRequest registers an image of a listed folder as the base face, instead of an upload
+type RegisterFromFolderItemRequest struct {
+	SessionID  string `json:"session_id"`
+	Provider   string `json:"provider"`
+	ItemID     string `json:"item_id"`
+	AccountID  string `json:"account_id,omitempty"` // Account of the provider, required when several are signed in
+	DriveID    string `json:"drive_id,omitempty"`   // OneDrive drive the item was listed in
+	Preprocess string `json:"preprocess,omitempty"` // Same steps as for uploads, all by default
+	Append     bool   `json:"append,omitempty"`     // Add the image to the session's reference images instead of replacing them
+	Model      string `json:"model,omitempty"`      // Face model, "small" (default) or "large"
 }
 
 type RegisterBaseFaceResponse struct {
-	Success bool `json:"success"`
+	Success        bool `json:"success"`
+	ReferenceCount int  `json:"reference_count"` // Reference images now registered for the session
 }
 
 type CompareFolderRequest struct {
-	SessionID  string `json:"session_id"`
-	FolderLink string `json:"folder_link"`
-	Provider   string `json:"provider"`
-	Recursive  bool   `json:"recursive"`
+	SessionID  string            `json:"session_id"`
+	FolderLink string            `json:"folder_link,omitempty"`
+	Folder     *models.CloudItem `json:"folder,omitempty"` // Already-resolved folder from browsing, used instead of folder_link
+	Provider   string            `json:"provider"`
+	AccountID  string            `json:"account_id,omitempty"` // Account of the provider, required when several are signed in
+	Recursive  bool              `json:"recursive"`
+	Preprocess string            `json:"preprocess,omitempty"` // Comma-separated steps (orientation, downscale, transcode) or "none", all by default
+	// Aggregation is how faces are compared with several reference images: "any" (default) matches
+	// faces close to any one reference, "mean" matches faces close to their average
+	Aggregation string `json:"aggregation,omitempty"`
+	// IncludeAllFiles returns the full folder listing, including non-image files, with the completed job
+	IncludeAllFiles bool `json:"include_all_files,omitempty"`
+	// Model is the face model images are encoded with, which must match the one the base face was registered with
+	Model string `json:"model,omitempty"`
+	// MaxDistance is the maximum match distance (0.0-1.0), stricter when lower; 0 uses the Python service default
+	MaxDistance float64 `json:"max_distance,omitempty"`
+	// ModifiedAfter and ModifiedBefore (YYYY-MM-DD or RFC3339) only compare images modified within the range
+	ModifiedAfter  string `json:"modified_after,omitempty"`
+	ModifiedBefore string `json:"modified_before,omitempty"`
+}
+
+type CompareDriveRequest struct {
+	SessionID string `json:"session_id"`
+	Provider  string `json:"provider"`
+	AccountID string `json:"account_id,omitempty"` // Account of the provider, required when several are signed in
+	Model     string `json:"model,omitempty"`      // Face model, which must match the one the base face was registered with
+}
+
+type RerunUnmatchedRequest struct {
+	SessionID string  `json:"session_id"`
+	Threshold float64 `json:"threshold"` // Maximum match distance for the re-run (0.0-1.0)
+}
+
+// EstimateRequest selects a folder the same way CompareFolderRequest does
+type EstimateRequest struct {
+	SessionID  string            `json:"session_id"`
+	FolderLink string            `json:"folder_link,omitempty"`
+	Folder     *models.CloudItem `json:"folder,omitempty"`
+	Provider   string            `json:"provider"`
+	AccountID  string            `json:"account_id,omitempty"`
+	Recursive  bool              `json:"recursive"`
+	// ModifiedAfter and ModifiedBefore narrow the estimate to images modified within the range
+	ModifiedAfter  string `json:"modified_after,omitempty"`
+	ModifiedBefore string `json:"modified_before,omitempty"`
+}
+
+// EstimateResponse predicts how long comparing a folder would take from the throughput of recent jobs
+type EstimateResponse struct {
+	ImageCount       int      `json:"image_count"`
+	EstimatedSeconds int      `json:"estimated_seconds"`
+	ImagesPerSecond  float64  `json:"images_per_second"`
+	BasedOnJobs      int      `json:"based_on_jobs"` // Recent jobs the throughput is averaged over, 0 when it is the configured default
+	ExceedsLimit     bool     `json:"exceeds_limit"` // The folder has more images than a single job accepts
+	Message          string   `json:"message"`
+	Warnings         []string `json:"warnings,omitempty"`
 }
 
 type CompareFolderResponse struct {
-	JobID  string `json:"job_id"`
-	Status string `json:"status"`
+	JobID  string    `json:"job_id"`
+	Status JobStatus `json:"status"`
+}
+
+type CancelJobResponse struct {
+	JobID  string    `json:"job_id"`
+	Status JobStatus `json:"status"`
+}
+
+// DeleteJobsResponse reports what deleting a session's jobs removed
+type DeleteJobsResponse struct {
+	Cancelled        int  `json:"cancelled"`
+	Deleted          int  `json:"deleted"`
+	ReferenceCleared bool `json:"reference_cleared"`
 }
 
 type JobStatusResponse struct {
-	JobID        string              `json:"job_id"`
-	Status       string              `json:"status"`
-	Progress     int                 `json:"progress"`
-	CurrentImage int                 `json:"current_image"`
-	TotalImages  int                 `json:"total_images"`
-	MatchesFound int                 `json:"matches_found"`
-	Message      string              `json:"message"`
-	Matches      []*models.CloudItem `json:"matches,omitempty"`
-	Error        string              `json:"error,omitempty"`
+	JobID        string    `json:"job_id"`
+	RerunOf      string    `json:"rerun_of,omitempty"` // Original job whose unmatched images this job re-checks
+	Status       JobStatus `json:"status"`
+	Progress     int       `json:"progress"`
+	CurrentImage int       `json:"current_image"`
+	TotalImages  int       `json:"total_images"`
+	MatchesFound int       `json:"matches_found"`
+	// ImagesWithFaces is how many images had any detectable face, telling "no faces" apart from "no match"
+	ImagesWithFaces int `json:"images_with_faces"`
+	// NoMatches is set once a job completes without any match, and its message then suggests why
+	NoMatches  bool                `json:"no_matches"`
+	Message    string              `json:"message"`
+	Matches    []*models.CloudItem `json:"matches,omitempty"`
+	NextCursor string              `json:"next_cursor,omitempty"` // Set when a paged request has more matches
+	Warnings   []string            `json:"warnings,omitempty"`    // Non-fatal issues, e.g. a folder tree deeper than recommended
+	// FailedImages are images left out of the comparison because they could not be downloaded or read
+	FailedImages []FailedImage   `json:"failed_images,omitempty"`
+	Error        string          `json:"error,omitempty"`
+	Diagnostics  *JobDiagnostics `json:"diagnostics,omitempty"` // Only included when requested with ?debug=true
+	Listing      *FolderListing  `json:"listing,omitempty"`     // Only included for completed jobs started with include_all_files
 }
 
-type pythonRegisterRequest struct {
+// FolderListing is everything found in the compared folder
+type FolderListing struct {
+	Images     []*models.CloudItem `json:"images"`
+	OtherFiles []*models.CloudItem `json:"other_files"`
+}
+
+type SaveResultRequest struct {
+	SessionID string `json:"session_id"`
+}
+
+type SaveResultResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ResultResponse is a saved result with freshly resolved match items
+type ResultResponse struct {
+	Token       string              `json:"token"`
+	JobID       string              `json:"job_id"`
+	FolderLink  string              `json:"folder_link,omitempty"`
+	FolderName  string              `json:"folder_name,omitempty"`
+	CreatedAt   time.Time           `json:"created_at"`
+	Matches     []*models.CloudItem `json:"matches"`
+	Unavailable []string            `json:"unavailable"` // Names of matched items the provider no longer returns
+}
+
+// JobDiagnostics explains incomplete results, such as images the face service could not process
+type JobDiagnostics struct {
+	ImageErrors []ImageError `json:"image_errors"`
+	// Images not sent for comparison because they duplicate another image, which they share the result of
+	DuplicatesSkipped     int `json:"duplicates_skipped"`
+	NearDuplicatesSkipped int `json:"near_duplicates_skipped"` // Only found when near-duplicate dedup is enabled
+}
+
+// FailedImage is an image left out of a comparison, and why
+type FailedImage struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// ImageError describes an image that was skipped during comparison and why
+type ImageError struct {
+	Item  *models.CloudItem `json:"item"`
+	Error string            `json:"error"`
+}
+
+// SavedReferenceRequest identifies the session and signed-in account a saved reference face belongs to
+type SavedReferenceRequest struct {
 	SessionID string `json:"session_id"`
-	Image     string `json:"image"`
+	Provider  string `json:"provider"`             // Account whose saved reference is used, optional with a single signed-in provider
+	AccountID string `json:"account_id,omitempty"` // Required when several accounts of the provider are signed in
+}
+
+type SaveReferenceResponse struct {
+	Success bool      `json:"success"`
+	SavedAt time.Time `json:"saved_at"`
+}
+
+// SaveToDriveRequest asks for a job's matches to be copied into a folder of the user's own drive
+type SaveToDriveRequest struct {
+	SessionID   string            `json:"session_id"`
+	Destination *models.CloudItem `json:"destination"`
+	// AccountID is the account of the destination's provider whose drive it is, required when several are signed in
+	AccountID string `json:"account_id,omitempty"`
+	// ExportFormats ("csv", "json") also writes matches.csv or matches.json with the results into the folder
+	ExportFormats []string `json:"export_formats,omitempty"`
+	// SkipPhotos writes only the results files, without copying the photos
+	SkipPhotos bool `json:"skip_photos,omitempty"`
+}
+
+type SaveToDriveResponse struct {
+	SaveJobID string    `json:"save_job_id"`
+	Status    JobStatus `json:"status"`
+}
+
+// SaveJobStatusResponse reports the progress of copying matches into a drive folder
+type SaveJobStatusResponse struct {
+	SaveJobID   string            `json:"save_job_id"`
+	Status      JobStatus         `json:"status"`
+	Destination *models.CloudItem `json:"destination"`
+	Progress    int               `json:"progress"`
+	Copied      int               `json:"copied"`
+	Uploaded    []string          `json:"uploaded"` // Results files written
+	Total       int               `json:"total"`    // Photos to copy plus results files to write
+	Failures    []SaveFailure     `json:"failures"`
+	Message     string            `json:"message"`
+}
+
+// SaveFailure describes a matched file that could not be copied, or a results file that could not be written, and why
+type SaveFailure struct {
+	Name  string `json:"name"`
+	Error string `json:"error"`
+}
+
+type pythonRegisterRequest struct {
+	SessionID string    `json:"session_id"`
+	Model     FaceModel `json:"model"`
+	Append    bool      `json:"append"` // Add to the session's reference images instead of replacing them
+	Image     string    `json:"image"`
 }
 
 type pythonRegisterResponse struct {
-	Success bool   `json:"success"`
-	Error   string `json:"error,omitempty"`
+	Success        bool   `json:"success"`
+	ReferenceCount int    `json:"reference_count"`
+	Error          string `json:"error,omitempty"`
+}
+
+// pythonSessionEncodings carries a session's reference face encodings to and from the Python service
+type pythonSessionEncodings struct {
+	Encodings [][]float64 `json:"encodings"`
 }
 
 type pythonCompareBatchRequest struct {
-	SessionID string   `json:"session_id"`
-	Images    []string `json:"images"`
+	SessionID   string      `json:"session_id"`
+	Images      []string    `json:"images"`
+	Threshold   float64     `json:"threshold,omitempty"`
+	Aggregation Aggregation `json:"aggregation,omitempty"`
+	Model       FaceModel   `json:"model,omitempty"`
 }
 
 type pythonCompareBatchResponse struct {
@@ -56,18 +258,28 @@ type pythonCompareBatchResponse struct {
 }
 
 type pythonJobStatusResponse struct {
-	JobID        string              `json:"job_id"`
-	Status       string              `json:"status"`
-	Progress     int                 `json:"progress"`
-	CurrentImage int                 `json:"current_image"`
-	TotalImages  int                 `json:"total_images"`
-	MatchesFound int                 `json:"matches_found"`
-	Message      string              `json:"message"`
-	Matches      []pythonMatchResult `json:"matches,omitempty"`
-	Error        string              `json:"error,omitempty"`
+	JobID           string              `json:"job_id"`
+	Status          string              `json:"status"`
+	Progress        int                 `json:"progress"`
+	CurrentImage    int                 `json:"current_image"`
+	TotalImages     int                 `json:"total_images"`
+	MatchesFound    int                 `json:"matches_found"`
+	ImagesWithFaces int                 `json:"images_with_faces"`
+	Message         string              `json:"message"`
+	Matches         []pythonMatchResult `json:"matches,omitempty"`
+	ImageErrors     []pythonImageError  `json:"image_errors,omitempty"`
+	FaceIndices     []int               `json:"face_indices,omitempty"` // Images in which a face was detected, once completed
+	Error           string              `json:"error,omitempty"`
 }
 
 type pythonMatchResult struct {
-	Index    int     `json:"index"`
-	Distance float64 `json:"distance"`
+	Index      int     `json:"index"`
+	Distance   float64 `json:"distance"`
+	Confidence float64 `json:"confidence"`          // Distance mapped along the face model's calibration curve
+	Reference  *int    `json:"reference,omitempty"` // Closest reference image, only reported with the "any" aggregation
+}
+
+type pythonImageError struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
 }
@@ -0,0 +1,103 @@
+package face
+
+import (
+	"bytes"
+	"image"
+	"math/bits"
+)
+
+// nearDuplicateMaxDistance is how many of the 64 hash bits two photos may differ in and still count as
+// the same shot, e.g. one re-saved at another size or quality
+const nearDuplicateMaxDistance = 4
+
+// dHash grid: each row compares 9 neighbouring cells, giving 8 bits per row and 64 in total
+const (
+	dHashWidth  = 9
+	dHashHeight = 8
+)
+
+// dHashSamples bounds how many pixels are averaged per cell along each axis, keeping large images cheap to hash
+const dHashSamples = 8
+
+// perceptualHash computes a difference hash of an encoded image: the image is reduced to a 9x8 grid of
+// average brightness, and each bit records whether a cell is brighter than its right neighbour
+// Re-encoding or resizing a photo barely changes the hash, while different photos differ in many bits
+// It reports false for images Go cannot decode, like HEIF images other than HEIC, which are then only
+// deduplicated when identical, and for images the guard rejects
+func perceptualHash(data []byte, guard decodeGuard) (uint64, bool) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || guard.check(cfg) != nil {
+		return 0, false
+	}
+
+	img, err := guard.decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, false
+	}
+
+	bounds := img.Bounds()
+	if bounds.Dx() < dHashWidth || bounds.Dy() < dHashHeight {
+		return 0, false
+	}
+
+	var grid [dHashHeight][dHashWidth]uint32
+	for row := range dHashHeight {
+		for col := range dHashWidth {
+			grid[row][col] = cellBrightness(img, bounds, col, row)
+		}
+	}
+
+	var hash uint64
+	for row := range dHashHeight {
+		for col := range dHashWidth - 1 {
+			hash <<= 1
+			if grid[row][col] > grid[row][col+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash, true
+}
+
+// cellBrightness averages the luminance of evenly spaced pixels within one grid cell
+func cellBrightness(img image.Image, bounds image.Rectangle, col, row int) uint32 {
+	x0 := bounds.Min.X + col*bounds.Dx()/dHashWidth
+	x1 := bounds.Min.X + (col+1)*bounds.Dx()/dHashWidth
+	y0 := bounds.Min.Y + row*bounds.Dy()/dHashHeight
+	y1 := bounds.Min.Y + (row+1)*bounds.Dy()/dHashHeight
+
+	stepX := max(1, (x1-x0)/dHashSamples)
+	stepY := max(1, (y1-y0)/dHashSamples)
+
+	var sum, count uint32
+	for y := y0; y < y1; y += stepY {
+		for x := x0; x < x1; x += stepX {
+			r, g, b, _ := img.At(x, y).RGBA()
+			sum += uint32(luminance(uint8(r>>8), uint8(g>>8), uint8(b>>8)))
+			count++
+		}
+	}
+	return sum / count
+}
+
+// nearDuplicateIndex remembers the perceptual hash of every image sent for comparison in a job
+// Lookups scan all hashes, which stays cheap for the few thousand images a job may hold
+type nearDuplicateIndex struct {
+	hashes  []uint64
+	indices []int
+}
+
+// find returns the global index of an earlier image the hash is a near duplicate of
+func (n *nearDuplicateIndex) find(hash uint64) (int, bool) {
+	for i, existing := range n.hashes {
+		if bits.OnesCount64(existing^hash) <= nearDuplicateMaxDistance {
+			return n.indices[i], true
+		}
+	}
+	return 0, false
+}
+
+func (n *nearDuplicateIndex) add(hash uint64, index int) {
+	n.hashes = append(n.hashes, hash)
+	n.indices = append(n.indices, index)
+}
@@ -0,0 +1,119 @@
+package face
+
+import (
+	"all-me-backend/pkg/models"
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math/bits"
+	"testing"
+)
+
+// encodeJPEGQuality encodes an image as JPEG at the given quality
+func encodeJPEGQuality(t *testing.T, img image.Image, quality int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		t.Fatalf("Failed to encode JPEG: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// otherTestImage differs from testImage: its brightness falls from left to right instead of rising
+func otherTestImage(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(255 - x*6), G: uint8((x * y) % 256), B: 40, A: 255})
+		}
+	}
+	return img
+}
+
+func TestPerceptualHash(t *testing.T) {
+	original, ok := perceptualHash(encodeJPEGQuality(t, testImage(40, 30), 95), decodeGuard{})
+	if !ok {
+		t.Fatal("Expected a hash for a JPEG image")
+	}
+
+	variants := map[string][]byte{
+		"lower quality": encodeJPEGQuality(t, testImage(40, 30), 40),
+		"downscaled":    encodeJPEGQuality(t, downscale(testImage(40, 30), 20), 95),
+	}
+	for name, data := range variants {
+		hash, ok := perceptualHash(data, decodeGuard{})
+		if distance := bits.OnesCount64(hash ^ original); !ok || distance > nearDuplicateMaxDistance {
+			t.Errorf("Expected the %s copy to be a near duplicate, got distance %d", name, distance)
+		}
+	}
+
+	other, _ := perceptualHash(encodeJPEGQuality(t, otherTestImage(40, 30), 95), decodeGuard{})
+	if distance := bits.OnesCount64(other ^ original); distance <= nearDuplicateMaxDistance {
+		t.Errorf("Expected a different photo not to be a near duplicate, got distance %d", distance)
+	}
+
+	if _, ok := perceptualHash([]byte("not an image"), decodeGuard{}); ok {
+		t.Error("Expected no hash for undecodable data")
+	}
+}
+
+func TestProcessBatches_DeduplicatesNearDuplicates(t *testing.T) {
+	tests := []struct {
+		name          string
+		enabled       bool
+		wantCompared  int
+		wantNearSkips int
+	}{
+		{"enabled", true, 2, 1},
+		{"disabled", false, 3, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pythonServer := newMockPythonServer(t)
+			pythonServer.matchFirstImage = true
+
+			storage := &mockStorageService{contents: map[string]string{
+				"original": string(encodeJPEGQuality(t, testImage(40, 30), 95)),
+				"other":    string(encodeJPEGQuality(t, otherTestImage(40, 30), 95)),
+				"resaved":  string(encodeJPEGQuality(t, testImage(40, 30), 40)),
+			}}
+			service := createTestService(storage, pythonServer.URL)
+			service.dedupNearDuplicates = tt.enabled
+
+			images := []*models.CloudItem{
+				{ID: "original", Name: "photo.jpg"},
+				{ID: "other", Name: "other.jpg"},
+				{ID: "resaved", Name: "photo-small.jpg"},
+			}
+
+			token := &models.Token{AccessToken: "token", Provider: "googledrive"}
+			jobID, err := service.processFolderInBatches("session-1", images, token, compareOptions{})
+			if err != nil {
+				t.Fatalf("processFolderInBatches failed: %v", err)
+			}
+
+			waitForJobStatus(t, service, jobID, JobStatusCompleted)
+
+			batches := pythonServer.submittedBatches()
+			if len(batches) != 1 || len(batches[0].Images) != tt.wantCompared {
+				t.Fatalf("Expected one batch with %d images, got %+v", tt.wantCompared, batches)
+			}
+
+			status, err := service.GetJobStatus(jobID, true, MatchPage{})
+			if err != nil {
+				t.Fatalf("GetJobStatus failed: %v", err)
+			}
+			if status.Diagnostics == nil || status.Diagnostics.NearDuplicatesSkipped != tt.wantNearSkips || status.Diagnostics.DuplicatesSkipped != 0 {
+				t.Errorf("Expected %d near duplicates in diagnostics, got %+v", tt.wantNearSkips, status.Diagnostics)
+			}
+
+			// The resaved copy shares the original's match when it was skipped
+			if tt.enabled && (len(status.Matches) != 2 || status.Matches[1].ID != "resaved") {
+				t.Errorf("Expected the original and its resaved copy to match, got %d matches", len(status.Matches))
+			}
+		})
+	}
+}
@@ -0,0 +1,373 @@
+package face
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	_ "image/gif" // Register decoders for the candidate image types the storage layer accepts
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+
+	_ "golang.org/x/image/bmp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	defaultPreprocessMaxDimension = 1600
+	preprocessJPEGQuality         = 90
+
+	// exifHeaderBytes is how much of a JPEG is read to find its EXIF segment, which is at most 64KB
+	// and comes before the image data
+	exifHeaderBytes = 128 * 1024
+)
+
+// PreprocessSteps selects which normalization steps run on an image before face recognition
+type PreprocessSteps struct {
+	Orientation bool // Apply the EXIF orientation so faces are upright
+	Downscale   bool // Shrink images larger than the configured maximum dimension
+	Transcode   bool // Convert non-JPEG images to JPEG
+
+	// Enhancements help with difficult (low-light, backlit) photos but cost extra CPU per image
+	// They are only set from FACE_PREPROCESS_ENHANCE, never per request, so references and
+	// candidates are always enhanced the same way
+	Grayscale bool // Convert images to grayscale
+	Equalize  bool // Stretch contrast by equalizing the luminance histogram
+}
+
+// DefaultPreprocessSteps runs every step
+var DefaultPreprocessSteps = PreprocessSteps{Orientation: true, Downscale: true, Transcode: true}
+
+// ParsePreprocessSteps parses a comma-separated list of steps ("orientation,downscale,transcode")
+// An empty value selects every step and "none" disables them all
+func ParsePreprocessSteps(value string) (PreprocessSteps, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return DefaultPreprocessSteps, nil
+	}
+
+	var steps PreprocessSteps
+	if value == "none" {
+		return steps, nil
+	}
+
+	for _, step := range strings.Split(value, ",") {
+		switch strings.TrimSpace(step) {
+		case "orientation":
+			steps.Orientation = true
+		case "downscale":
+			steps.Downscale = true
+		case "transcode":
+			steps.Transcode = true
+		default:
+			return PreprocessSteps{}, fmt.Errorf("unknown preprocess step %q, expected orientation, downscale, transcode or none", step)
+		}
+	}
+
+	return steps, nil
+}
+
+// parseEnhancements parses a comma-separated list of enhancements ("grayscale,equalize")
+// The returned steps only have enhancement fields set, an empty value enables none
+func parseEnhancements(value string) (PreprocessSteps, error) {
+	var steps PreprocessSteps
+	if strings.TrimSpace(value) == "" {
+		return steps, nil
+	}
+
+	for _, step := range strings.Split(value, ",") {
+		switch strings.TrimSpace(step) {
+		case "grayscale":
+			steps.Grayscale = true
+		case "equalize":
+			steps.Equalize = true
+		default:
+			return PreprocessSteps{}, fmt.Errorf("unknown enhancement %q, expected grayscale or equalize", step)
+		}
+	}
+
+	return steps, nil
+}
+
+// preprocessImage applies the selected steps to encoded image data
+// Images that need no changes (or that Go cannot decode, like HEIF images other than HEIC) are returned as-is.
+func preprocessImage(data []byte, steps PreprocessSteps, maxDimension int, guard decodeGuard) ([]byte, error) {
+	modified, changed, err := preprocessStream(bytes.NewReader(data), steps, maxDimension, guard)
+	if err != nil || !changed {
+		return data, err
+	}
+	return modified, nil
+}
+
+// preprocessStream applies the selected steps to an encoded image read from src
+// Only the image header is read unless a step changes the image, in which case the re-encoded image
+// is returned with changed set. Callers reading src afterwards must seek back to its start.
+// Modified images are encoded as JPEG when the source was JPEG or transcoding is on, PNG otherwise
+// Images declaring more pixels than the guard allows are rejected from their header alone, whatever the steps
+func preprocessStream(src io.ReadSeeker, steps PreprocessSteps, maxDimension int, guard decodeGuard) ([]byte, bool, error) {
+	cfg, format, err := image.DecodeConfig(src)
+	if err != nil {
+		return nil, false, nil
+	}
+	if err := guard.check(cfg); err != nil {
+		return nil, false, err
+	}
+
+	orientation := 1
+	if steps.Orientation && format == "jpeg" {
+		if _, err := src.Seek(0, io.SeekStart); err != nil {
+			return nil, false, err
+		}
+		header, err := io.ReadAll(io.LimitReader(src, exifHeaderBytes))
+		if err != nil {
+			return nil, false, err
+		}
+		orientation = exifOrientation(header)
+	}
+
+	needsDownscale := steps.Downscale && maxDimension > 0 && max(cfg.Width, cfg.Height) > maxDimension
+	needsTranscode := steps.Transcode && format != "jpeg"
+	needsEnhance := steps.Grayscale || steps.Equalize
+
+	if orientation == 1 && !needsDownscale && !needsTranscode && !needsEnhance {
+		return nil, false, nil
+	}
+
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, false, err
+	}
+	img, err := guard.decode(src)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	if orientation != 1 {
+		img = applyOrientation(img, orientation)
+	}
+
+	if needsDownscale {
+		img = downscale(img, maxDimension)
+	}
+
+	// Enhance after downscaling, which leaves fewer pixels to process
+	if needsEnhance {
+		img = enhance(img, steps.Grayscale, steps.Equalize)
+	}
+
+	var buf bytes.Buffer
+	if format == "jpeg" || steps.Transcode {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: preprocessJPEGQuality})
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to encode image: %w", err)
+	}
+
+	return buf.Bytes(), true, nil
+}
+
+// downscale resizes an image so its longest side is maxDimension, keeping the aspect ratio
+func downscale(img image.Image, maxDimension int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	if width >= height {
+		height = max(1, height*maxDimension/width)
+		width = maxDimension
+	} else {
+		width = max(1, width*maxDimension/height)
+		height = maxDimension
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+
+	return dst
+}
+
+// enhance converts an image to grayscale and/or equalizes its luminance histogram
+// Equalizing shifts each pixel's channels by the same amount, so colors keep their hue
+func enhance(img image.Image, grayscale, equalize bool) image.Image {
+	bounds := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, bounds.Min, draw.Src)
+
+	var table [256]uint8
+	for i := range table {
+		table[i] = uint8(i)
+	}
+	if equalize {
+		table = equalizationTable(dst)
+	}
+
+	for i := 0; i < len(dst.Pix); i += 4 {
+		y := luminance(dst.Pix[i], dst.Pix[i+1], dst.Pix[i+2])
+		if grayscale {
+			dst.Pix[i], dst.Pix[i+1], dst.Pix[i+2] = table[y], table[y], table[y]
+			continue
+		}
+
+		delta := int(table[y]) - int(y)
+		for c := i; c < i+3; c++ {
+			dst.Pix[c] = uint8(min(max(int(dst.Pix[c])+delta, 0), 255))
+		}
+	}
+
+	return dst
+}
+
+// equalizationTable maps each luminance to its position in the image's cumulative histogram,
+// spreading the luminances present over the full 0-255 range
+func equalizationTable(img *image.NRGBA) [256]uint8 {
+	var histogram [256]int
+	for i := 0; i < len(img.Pix); i += 4 {
+		histogram[luminance(img.Pix[i], img.Pix[i+1], img.Pix[i+2])]++
+	}
+
+	var table [256]uint8
+	total := len(img.Pix) / 4
+	cumulative, darkest := 0, 0
+	for value, count := range histogram {
+		cumulative += count
+		if darkest == 0 {
+			darkest = cumulative
+		}
+
+		// An image of a single luminance has nothing to spread
+		if total == darkest {
+			table[value] = uint8(value)
+		} else {
+			table[value] = uint8((cumulative - darkest) * 255 / (total - darkest))
+		}
+	}
+
+	return table
+}
+
+// luminance returns the perceived brightness of a color (ITU-R BT.601)
+func luminance(r, g, b uint8) uint8 {
+	return uint8((299*int(r) + 587*int(g) + 114*int(b)) / 1000)
+}
+
+// applyOrientation rotates and flips an image according to an EXIF orientation value (2-8)
+func applyOrientation(img image.Image, orientation int) image.Image {
+	bounds := img.Bounds()
+	src := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	w, h := bounds.Dx(), bounds.Dy()
+	dstW, dstH := w, h
+	if orientation >= 5 {
+		dstW, dstH = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dstW, dstH))
+
+	for sy := 0; sy < h; sy++ {
+		for sx := 0; sx < w; sx++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // Mirrored horizontally
+				dx, dy = w-1-sx, sy
+			case 3: // Rotated 180
+				dx, dy = w-1-sx, h-1-sy
+			case 4: // Mirrored vertically
+				dx, dy = sx, h-1-sy
+			case 5: // Transposed
+				dx, dy = sy, sx
+			case 6: // Needs 90 clockwise rotation
+				dx, dy = h-1-sy, sx
+			case 7: // Transversed
+				dx, dy = h-1-sy, w-1-sx
+			case 8: // Needs 90 counter-clockwise rotation
+				dx, dy = sy, w-1-sx
+			default:
+				dx, dy = sx, sy
+			}
+
+			copy(dst.Pix[dst.PixOffset(dx, dy):dst.PixOffset(dx, dy)+4], src.Pix[src.PixOffset(sx, sy):src.PixOffset(sx, sy)+4])
+		}
+	}
+
+	return dst
+}
+
+// exifOrientation reads the orientation tag from a JPEG's EXIF data, returning 1 (upright) if absent
+func exifOrientation(data []byte) int {
+	const orientationTag = 0x0112
+
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+
+	// Walk the JPEG segments looking for the APP1 Exif segment
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return 1
+		}
+
+		marker := data[pos+1]
+		length := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+		if length < 2 || pos+2+length > len(data) {
+			return 1
+		}
+		segment := data[pos+4 : pos+2+length]
+
+		// Image data starts at SOS, EXIF always comes before it
+		if marker == 0xDA {
+			return 1
+		}
+
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return orientationFromTIFF(segment[6:], orientationTag)
+		}
+
+		pos += 2 + length
+	}
+
+	return 1
+}
+
+// orientationFromTIFF looks up the orientation tag in the first IFD of TIFF-formatted EXIF data
+func orientationFromTIFF(tiff []byte, tag uint16) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifdOffset := int(order.Uint32(tiff[4:8]))
+	if ifdOffset+2 > len(tiff) {
+		return 1
+	}
+
+	entries := int(order.Uint16(tiff[ifdOffset : ifdOffset+2]))
+	for i := 0; i < entries; i++ {
+		entry := ifdOffset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+
+		if order.Uint16(tiff[entry:entry+2]) == tag {
+			orientation := int(order.Uint16(tiff[entry+8 : entry+10]))
+			if orientation < 1 || orientation > 8 {
+				return 1
+			}
+			return orientation
+		}
+	}
+
+	return 1
+}
@@ -0,0 +1,295 @@
+package face
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestPreprocessImage_Orientation(t *testing.T) {
+	data := withEXIFOrientation(t, encodeTestJPEG(t, 40, 20), 6)
+
+	rotated, err := preprocessImage(data, PreprocessSteps{Orientation: true}, 0, decodeGuard{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if w, h := imageSize(t, rotated); w != 20 || h != 40 {
+		t.Errorf("Expected rotated image to be 20x40, got %dx%d", w, h)
+	}
+
+	unchanged, err := preprocessImage(data, PreprocessSteps{}, 0, decodeGuard{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !bytes.Equal(unchanged, data) {
+		t.Error("Expected image to be returned unchanged when orientation is skipped")
+	}
+}
+
+func TestPreprocessImage_Downscale(t *testing.T) {
+	data := encodeTestJPEG(t, 40, 20)
+
+	scaled, err := preprocessImage(data, PreprocessSteps{Downscale: true}, 10, decodeGuard{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if w, h := imageSize(t, scaled); w != 10 || h != 5 {
+		t.Errorf("Expected downscaled image to be 10x5, got %dx%d", w, h)
+	}
+
+	unchanged, err := preprocessImage(data, PreprocessSteps{Orientation: true, Transcode: true}, 10, decodeGuard{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !bytes.Equal(unchanged, data) {
+		t.Error("Expected image to be returned unchanged when downscale is skipped")
+	}
+}
+
+func TestPreprocessImage_Transcode(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage(8, 8)); err != nil {
+		t.Fatalf("Failed to encode PNG: %v", err)
+	}
+	data := buf.Bytes()
+
+	transcoded, err := preprocessImage(data, PreprocessSteps{Transcode: true}, 0, decodeGuard{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, format, _ := image.DecodeConfig(bytes.NewReader(transcoded)); format != "jpeg" {
+		t.Errorf("Expected transcoded image to be jpeg, got %s", format)
+	}
+
+	unchanged, err := preprocessImage(data, PreprocessSteps{Orientation: true, Downscale: true}, 1600, decodeGuard{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !bytes.Equal(unchanged, data) {
+		t.Error("Expected PNG to be returned unchanged when transcode is skipped")
+	}
+}
+
+func TestPreprocessImage_UndecodableDataPassesThrough(t *testing.T) {
+	data := []byte("not an image")
+
+	result, err := preprocessImage(data, DefaultPreprocessSteps, 10, decodeGuard{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !bytes.Equal(result, data) {
+		t.Error("Expected undecodable data to be returned unchanged")
+	}
+}
+
+func TestPreprocessImage_Enhance(t *testing.T) {
+	// A dim, low-contrast image whose luminance only spans a narrow band
+	img := image.NewRGBA(image.Rect(0, 0, 16, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 16; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(40 + x), G: uint8(50 + x), B: uint8(30 + y), A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("Failed to encode PNG: %v", err)
+	}
+	raw := buf.Bytes()
+
+	tests := []struct {
+		name       string
+		steps      PreprocessSteps
+		wantFormat string
+	}{
+		{"equalize keeps png", PreprocessSteps{Equalize: true}, "png"},
+		{"grayscale keeps png", PreprocessSteps{Grayscale: true}, "png"},
+		{"equalize with transcode", PreprocessSteps{Equalize: true, Transcode: true}, "jpeg"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enhanced, err := preprocessImage(raw, tt.steps, 0, decodeGuard{})
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			rawCfg, _, _ := image.DecodeConfig(bytes.NewReader(raw))
+			cfg, format, err := image.DecodeConfig(bytes.NewReader(enhanced))
+			if err != nil {
+				t.Fatalf("Failed to decode enhanced image: %v", err)
+			}
+			if cfg.Width != rawCfg.Width || cfg.Height != rawCfg.Height {
+				t.Errorf("Expected enhanced image to stay %dx%d, got %dx%d", rawCfg.Width, rawCfg.Height, cfg.Width, cfg.Height)
+			}
+			if format != tt.wantFormat {
+				t.Errorf("Expected %s output, got %s", tt.wantFormat, format)
+			}
+		})
+	}
+
+	equalized, _ := preprocessImage(raw, PreprocessSteps{Equalize: true}, 0, decodeGuard{})
+	if rawLow, rawHigh := luminanceRange(t, raw); rawHigh-rawLow > 30 {
+		t.Fatalf("Expected a low-contrast test image, got luminance %d-%d", rawLow, rawHigh)
+	}
+	if low, high := luminanceRange(t, equalized); low > 10 || high < 245 {
+		t.Errorf("Expected equalized luminance to span nearly 0-255, got %d-%d", low, high)
+	}
+
+	gray, _ := preprocessImage(raw, PreprocessSteps{Grayscale: true}, 0, decodeGuard{})
+	decoded, _, _ := image.Decode(bytes.NewReader(gray))
+	if r, g, b, _ := decoded.At(5, 5).RGBA(); r != g || g != b {
+		t.Errorf("Expected a gray pixel, got r=%d g=%d b=%d", r, g, b)
+	}
+}
+
+func TestParseEnhancements(t *testing.T) {
+	steps, err := parseEnhancements("grayscale, equalize")
+	if err != nil || !steps.Grayscale || !steps.Equalize || steps.Orientation {
+		t.Errorf("Expected only grayscale and equalize, got %+v (%v)", steps, err)
+	}
+
+	if steps, err := parseEnhancements(""); err != nil || steps != (PreprocessSteps{}) {
+		t.Errorf("Expected no enhancements for an empty value, got %+v (%v)", steps, err)
+	}
+
+	if _, err := parseEnhancements("sharpen"); err == nil {
+		t.Error("Expected error for unknown enhancement")
+	}
+}
+
+// BenchmarkPreprocessImage measures the extra CPU cost of enhancing a typical downscaled photo
+func BenchmarkPreprocessImage(b *testing.B) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(2000, 1500), nil); err != nil {
+		b.Fatalf("Failed to encode JPEG: %v", err)
+	}
+	data := buf.Bytes()
+
+	benchmarks := []struct {
+		name  string
+		steps PreprocessSteps
+	}{
+		{"downscale", PreprocessSteps{Downscale: true}},
+		{"downscale+equalize", PreprocessSteps{Downscale: true, Equalize: true}},
+		{"downscale+grayscale", PreprocessSteps{Downscale: true, Grayscale: true}},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			for b.Loop() {
+				if _, err := preprocessImage(data, bm.steps, 1600, decodeGuard{}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestParsePreprocessSteps(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected PreprocessSteps
+		wantErr  bool
+	}{
+		{value: "", expected: DefaultPreprocessSteps},
+		{value: "none", expected: PreprocessSteps{}},
+		{value: "orientation", expected: PreprocessSteps{Orientation: true}},
+		{value: "downscale, transcode", expected: PreprocessSteps{Downscale: true, Transcode: true}},
+		{value: "orientation,sharpen", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		steps, err := ParsePreprocessSteps(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Expected error for %q, got nil", tt.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Expected no error for %q, got %v", tt.value, err)
+		}
+		if steps != tt.expected {
+			t.Errorf("Expected %+v for %q, got %+v", tt.expected, tt.value, steps)
+		}
+	}
+}
+
+func testImage(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 6), G: uint8(y * 6), B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+func encodeTestJPEG(t *testing.T, width, height int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(width, height), nil); err != nil {
+		t.Fatalf("Failed to encode JPEG: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// withEXIFOrientation inserts an APP1 Exif segment holding only the orientation tag after the SOI marker
+func withEXIFOrientation(t *testing.T, data []byte, orientation uint16) []byte {
+	t.Helper()
+
+	tiff := make([]byte, 26)
+	copy(tiff, "MM")
+	binary.BigEndian.PutUint16(tiff[2:], 42)
+	binary.BigEndian.PutUint32(tiff[4:], 8)
+	binary.BigEndian.PutUint16(tiff[8:], 1)
+	binary.BigEndian.PutUint16(tiff[10:], 0x0112)
+	binary.BigEndian.PutUint16(tiff[12:], 3)
+	binary.BigEndian.PutUint32(tiff[14:], 1)
+	binary.BigEndian.PutUint16(tiff[18:], orientation)
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	segment = append(segment, payload...)
+
+	result := append([]byte{}, data[:2]...)
+	result = append(result, segment...)
+	return append(result, data[2:]...)
+}
+
+func imageSize(t *testing.T, data []byte) (int, int) {
+	t.Helper()
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to decode image: %v", err)
+	}
+	return cfg.Width, cfg.Height
+}
+
+// luminanceRange returns the darkest and brightest luminance in an encoded image
+func luminanceRange(t *testing.T, data []byte) (uint8, uint8) {
+	t.Helper()
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to decode image: %v", err)
+	}
+
+	low, high := uint8(255), uint8(0)
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			l := luminance(uint8(r>>8), uint8(g>>8), uint8(b>>8))
+			low, high = min(low, l), max(high, l)
+		}
+	}
+	return low, high
+}
@@ -0,0 +1,90 @@
+package face
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"slices"
+
+	"github.com/gen2brain/heic"
+)
+
+// minReferenceDimension is the smallest width and height of a base face image; the face detector finds
+// no face in smaller ones anyway
+const minReferenceDimension = 80
+
+// heifBrands are the ftyp major brands of HEIC/HEIF images, as written by phones
+var heifBrands = []string{"heic", "heix", "hevc", "hevx", "heim", "heis", "hevm", "hevs", "mif1", "msf1"}
+
+// heifMimeTypes are accepted for base faces on top of the comparable types, as they are transcoded to JPEG
+var heifMimeTypes = []string{"image/heic", "image/heif"}
+
+// prepareReferenceImage readies a base face image for the face service, which decodes neither HEIC nor HEIF:
+// those are transcoded to JPEG. Images Go decodes must then be at least minReferenceDimension pixels on
+// both sides. It returns the image to send, which is src left at its start unless it was transcoded
+func prepareReferenceImage(src io.ReadSeeker, guard decodeGuard) (io.ReadSeeker, error) {
+	header := make([]byte, 12)
+	n, err := io.ReadFull(src, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+
+	if isHEIF(header[:n]) {
+		transcoded, err := transcodeHEIF(src, guard)
+		if err != nil {
+			return nil, err
+		}
+		src = bytes.NewReader(transcoded)
+	}
+
+	cfg, _, decodeErr := image.DecodeConfig(src)
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+
+	// Formats Go can't decode are left to the face service
+	if decodeErr == nil && (cfg.Width < minReferenceDimension || cfg.Height < minReferenceDimension) {
+		return nil, fmt.Errorf("%w: %dx%d is below the minimum of %dx%d pixels", ErrImageTooSmall, cfg.Width, cfg.Height, minReferenceDimension, minReferenceDimension)
+	}
+	return src, nil
+}
+
+// transcodeHEIF decodes a HEIC/HEIF image read from src and encodes it as JPEG, checking its declared
+// size against the guard before decoding it
+func transcodeHEIF(src io.ReadSeeker, guard decodeGuard) ([]byte, error) {
+	cfg, err := heic.DecodeConfig(src)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrHEIFDecode, err)
+	}
+	if err := guard.check(cfg); err != nil {
+		return nil, err
+	}
+
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+	img, err := guard.decodeWith(src, heic.Decode)
+	if errors.Is(err, ErrDecodeTimeout) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrHEIFDecode, err)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: preprocessJPEGQuality}); err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// isHEIF reports whether the header starts with the ftyp box of a HEIC/HEIF image
+func isHEIF(header []byte) bool {
+	return len(header) >= 12 && string(header[4:8]) == "ftyp" && slices.Contains(heifBrands, string(header[8:12]))
+}
@@ -0,0 +1,55 @@
+package face
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestPrepareReferenceImage_TranscodesHEIC(t *testing.T) {
+	photo, err := os.ReadFile("testdata/sample.heic")
+	if err != nil {
+		t.Fatalf("Failed to read the HEIC fixture: %v", err)
+	}
+
+	prepared, err := prepareReferenceImage(bytes.NewReader(photo), decodeGuard{})
+	if err != nil {
+		t.Fatalf("prepareReferenceImage failed: %v", err)
+	}
+
+	data, err := io.ReadAll(prepared)
+	if err != nil {
+		t.Fatalf("Failed to read the prepared image: %v", err)
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || format != "jpeg" {
+		t.Fatalf("Expected the HEIC photo to be sent as JPEG, got %q (%v)", format, err)
+	}
+	if cfg.Width != 512 || cfg.Height != 512 {
+		t.Errorf("Expected the photo's 512x512 pixels to be kept, got %dx%d", cfg.Width, cfg.Height)
+	}
+
+	// The declared size is checked before the photo is decoded
+	if _, err := prepareReferenceImage(bytes.NewReader(photo), decodeGuard{maxPixels: 100_000}); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("Expected ErrImageTooLarge for a HEIC photo above the pixel limit, got %v", err)
+	}
+}
+
+func TestPrepareReferenceImage_KeepsOtherImages(t *testing.T) {
+	original := encodeTestJPEG(t, 120, 120)
+	src := bytes.NewReader(original)
+
+	prepared, err := prepareReferenceImage(src, decodeGuard{})
+	if err != nil {
+		t.Fatalf("prepareReferenceImage failed: %v", err)
+	}
+	if prepared != src {
+		t.Fatal("Expected a JPEG image to be sent as it is")
+	}
+	if data, _ := io.ReadAll(prepared); !bytes.Equal(data, original) {
+		t.Error("Expected the image to be read again from its start")
+	}
+}
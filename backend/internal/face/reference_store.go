@@ -0,0 +1,137 @@
+package face
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// SavedReference is a reference face an account opted to keep across sessions
+// Only the face encodings are kept, never the uploaded images
+type SavedReference struct {
+	AccountID string      `json:"account_id"`
+	Encodings [][]float64 `json:"encodings"`
+	Model     FaceModel   `json:"model,omitempty"` // Face model the encodings were made with, small for references saved before models were tracked
+	SavedAt   time.Time   `json:"saved_at"`
+}
+
+// FileReferenceStore keeps saved references in a JSON file, so they survive restarts
+// The whole file is rewritten on every change, which suits the small number of accounts that opt in
+type FileReferenceStore struct {
+	path       string
+	references map[string]*SavedReference // account ID -> reference
+	mu         sync.RWMutex
+}
+
+// NewFileReferenceStore opens the store at path, loading any references saved there before
+func NewFileReferenceStore(path string) (*FileReferenceStore, error) {
+	store := &FileReferenceStore{
+		path:       path,
+		references: make(map[string]*SavedReference),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read saved references: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &store.references); err != nil {
+		return nil, fmt.Errorf("failed to parse saved references: %w", err)
+	}
+
+	return store, nil
+}
+
+func (f *FileReferenceStore) SaveReference(reference *SavedReference) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	previous, existed := f.references[reference.AccountID]
+	f.references[reference.AccountID] = reference
+
+	if err := f.persist(); err != nil {
+		if existed {
+			f.references[reference.AccountID] = previous
+		} else {
+			delete(f.references, reference.AccountID)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (f *FileReferenceStore) GetReference(accountID string) (*SavedReference, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	reference, exists := f.references[accountID]
+	if !exists {
+		return nil, ErrNoSavedReference
+	}
+
+	return reference, nil
+}
+
+func (f *FileReferenceStore) DeleteReference(accountID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	reference, exists := f.references[accountID]
+	if !exists {
+		return ErrNoSavedReference
+	}
+
+	delete(f.references, accountID)
+
+	if err := f.persist(); err != nil {
+		f.references[accountID] = reference
+		return err
+	}
+
+	return nil
+}
+
+// persist writes all references to a temporary file and renames it over the store,
+// so a crash mid-write never leaves a truncated file behind
+// Callers must hold the write lock
+func (f *FileReferenceStore) persist() error {
+	data, err := json.Marshal(f.references)
+	if err != nil {
+		return fmt.Errorf("failed to encode saved references: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write saved references: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	// Face encodings are biometric data, so only the backend's own user may read them
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write saved references: %w", err)
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write saved references: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write saved references: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		return fmt.Errorf("failed to write saved references: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package face
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileReferenceStore_SurvivesReopening(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "references.json")
+
+	store, err := NewFileReferenceStore(path)
+	if err != nil {
+		t.Fatalf("NewFileReferenceStore failed: %v", err)
+	}
+
+	reference := &SavedReference{AccountID: "googledrive:abc", Encodings: [][]float64{{0.25, -0.5}}, SavedAt: time.Now()}
+	if err := store.SaveReference(reference); err != nil {
+		t.Fatalf("SaveReference failed: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Expected the store file to exist: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected store file permissions 0600, got %o", info.Mode().Perm())
+	}
+
+	reopened, err := NewFileReferenceStore(path)
+	if err != nil {
+		t.Fatalf("NewFileReferenceStore failed on reopen: %v", err)
+	}
+
+	loaded, err := reopened.GetReference("googledrive:abc")
+	if err != nil {
+		t.Fatalf("GetReference failed after reopening: %v", err)
+	}
+	if len(loaded.Encodings) != 1 || loaded.Encodings[0][1] != -0.5 {
+		t.Errorf("Expected the saved encoding, got %v", loaded.Encodings)
+	}
+
+	if err := reopened.DeleteReference("googledrive:abc"); err != nil {
+		t.Fatalf("DeleteReference failed: %v", err)
+	}
+	if err := reopened.DeleteReference("googledrive:abc"); !errors.Is(err, ErrNoSavedReference) {
+		t.Errorf("Expected ErrNoSavedReference deleting twice, got %v", err)
+	}
+
+	afterDelete, err := NewFileReferenceStore(path)
+	if err != nil {
+		t.Fatalf("NewFileReferenceStore failed after delete: %v", err)
+	}
+	if _, err := afterDelete.GetReference("googledrive:abc"); !errors.Is(err, ErrNoSavedReference) {
+		t.Errorf("Expected the deletion to be persisted, got %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package face
+
+import (
+	"all-me-backend/pkg/clock"
+	"crypto/rand"
+	"encoding/base64"
+	"sync"
+	"time"
+)
+
+const defaultResultTTL = 7 * 24 // hours
+
+// ResultManifest is a saved snapshot of a completed job's matches
+// Only item references are kept; fresh URLs are resolved from the provider on retrieval
+type ResultManifest struct {
+	Token      string
+	SessionID  string
+	JobID      string
+	Provider   string
+	AccountID  string // Account of the provider the job ran with, empty when it was not tracked
+	FolderLink string
+	FolderName string
+	Matches    []ManifestMatch
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+}
+
+// ManifestMatch references a matched item by its provider IDs
+type ManifestMatch struct {
+	ItemID   string
+	DriveID  string
+	Name     string
+	Distance float64
+}
+
+// MemoryResultStore keeps saved result manifests in memory until they expire
+type MemoryResultStore struct {
+	results map[string]*ResultManifest // token -> manifest
+	clock   clock.Clock
+	mu      sync.RWMutex
+}
+
+func NewMemoryResultStore() *MemoryResultStore {
+	return NewMemoryResultStoreWithClock(clock.Real{})
+}
+
+// NewMemoryResultStoreWithClock creates a store that measures result expiry with the given clock
+func NewMemoryResultStoreWithClock(clk clock.Clock) *MemoryResultStore {
+	store := &MemoryResultStore{
+		results: make(map[string]*ResultManifest),
+		clock:   clk,
+	}
+
+	go store.cleanupExpiredResults()
+
+	return store
+}
+
+func (m *MemoryResultStore) SaveResult(manifest *ResultManifest) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.results[manifest.Token] = manifest
+	return nil
+}
+
+func (m *MemoryResultStore) GetResult(token string) (*ResultManifest, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	manifest, exists := m.results[token]
+	if !exists || m.clock.Now().After(manifest.ExpiresAt) {
+		return nil, ErrResultNotFound
+	}
+
+	return manifest, nil
+}
+
+func (m *MemoryResultStore) cleanupExpiredResults() {
+	ticker := time.NewTicker(1 * time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		m.mu.Lock()
+		now := m.clock.Now()
+		for token, manifest := range m.results {
+			if now.After(manifest.ExpiresAt) {
+				delete(m.results, token)
+			}
+		}
+		m.mu.Unlock()
+	}
+}
+
+// generateResultToken returns a short random URL-safe token
+func generateResultToken() (string, error) {
+	bytes := make([]byte, 9)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
@@ -0,0 +1,162 @@
+package face
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExportFormat selects how a job's matches are written out as a results file
+type ExportFormat string
+
+const (
+	ExportFormatCSV  ExportFormat = "csv"
+	ExportFormatJSON ExportFormat = "json"
+)
+
+// ParseExportFormats parses the requested results file formats, dropping duplicates
+func ParseExportFormats(values []string) ([]ExportFormat, error) {
+	var formats []ExportFormat
+	for _, value := range values {
+		format := ExportFormat(strings.ToLower(strings.TrimSpace(value)))
+		switch format {
+		case ExportFormatCSV, ExportFormatJSON:
+		default:
+			return nil, fmt.Errorf("unknown export format %q, expected csv or json", value)
+		}
+
+		if !slices.Contains(formats, format) {
+			formats = append(formats, format)
+		}
+	}
+	return formats, nil
+}
+
+// FileName is the name the results file is written under
+func (f ExportFormat) FileName() string {
+	return "matches." + string(f)
+}
+
+// MimeType is the content type the results file is uploaded with
+func (f ExportFormat) MimeType() string {
+	if f == ExportFormatCSV {
+		return "text/csv"
+	}
+	return "application/json"
+}
+
+// ResultsExport is a job's matches as written to a results file
+type ResultsExport struct {
+	JobID      string        `json:"job_id"`
+	FolderName string        `json:"folder_name,omitempty"`
+	FolderLink string        `json:"folder_link,omitempty"`
+	ExportedAt time.Time     `json:"exported_at"`
+	Matches    []MatchExport `json:"matches"`
+}
+
+// MatchExport is one matched image; matches keep the order the job listed the images in
+type MatchExport struct {
+	Name           string    `json:"name"`
+	ItemID         string    `json:"item_id"`
+	Provider       string    `json:"provider"`
+	Distance       float64   `json:"distance"`
+	Reference      *int      `json:"reference,omitempty"`
+	SourceDocument string    `json:"source_document,omitempty"` // Name of the document an extracted image came from
+	SourceImage    int       `json:"source_image,omitempty"`
+	SourcePage     int       `json:"source_page,omitempty"`
+	ModifiedTime   time.Time `json:"modified_time,omitzero"`
+}
+
+// buildResultsExport collects a completed job's matches for a results file
+func buildResultsExport(jobID string, ctx *jobContext) *ResultsExport {
+	export := &ResultsExport{
+		JobID:      jobID,
+		FolderLink: ctx.folderLink,
+		ExportedAt: time.Now().UTC(),
+		Matches:    make([]MatchExport, 0, len(ctx.matches)),
+	}
+	if ctx.folder != nil {
+		export.FolderName = ctx.folder.Name
+	}
+
+	for _, match := range ctx.matches {
+		if match.Index < 0 || match.Index >= len(ctx.allImages) {
+			continue
+		}
+
+		item := ctx.allImages[match.Index]
+		row := MatchExport{
+			Name:         item.Name,
+			ItemID:       item.ID,
+			Provider:     item.Provider,
+			Distance:     match.Distance,
+			Reference:    match.Reference,
+			SourceImage:  item.SourceImage,
+			SourcePage:   item.SourcePage,
+			ModifiedTime: item.ModifiedTime,
+		}
+		if row.Provider == "" && ctx.token != nil {
+			row.Provider = ctx.token.Provider
+		}
+		if item.SourceDocument != nil {
+			row.SourceDocument = item.SourceDocument.Name
+		}
+		export.Matches = append(export.Matches, row)
+	}
+
+	return export
+}
+
+// encode serializes the export in the format
+func (e *ResultsExport) encode(format ExportFormat) ([]byte, error) {
+	if format == ExportFormatJSON {
+		return json.MarshalIndent(e, "", "  ")
+	}
+
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Write([]string{"name", "item_id", "provider", "distance", "reference", "source_document", "source_image", "source_page", "modified_time"})
+	for _, match := range e.Matches {
+		var reference, sourceImage, sourcePage, modified string
+		if match.Reference != nil {
+			reference = strconv.Itoa(*match.Reference)
+		}
+		if match.SourceImage > 0 {
+			sourceImage = strconv.Itoa(match.SourceImage)
+		}
+		if match.SourcePage > 0 {
+			sourcePage = strconv.Itoa(match.SourcePage)
+		}
+		if !match.ModifiedTime.IsZero() {
+			modified = match.ModifiedTime.UTC().Format(time.RFC3339)
+		}
+
+		writer.Write([]string{
+			csvSafe(match.Name),
+			match.ItemID,
+			match.Provider,
+			strconv.FormatFloat(match.Distance, 'f', 4, 64),
+			reference,
+			csvSafe(match.SourceDocument),
+			sourceImage,
+			sourcePage,
+			modified,
+		})
+	}
+	writer.Flush()
+
+	return buf.Bytes(), writer.Error()
+}
+
+// csvSafe keeps spreadsheet apps from running a file name as a formula when the CSV is opened
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
@@ -0,0 +1,256 @@
+package face
+
+import (
+	"all-me-backend/pkg/models"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// saveJobRetention is how long a finished save job's status stays available
+const saveJobRetention = 24 * time.Hour
+
+// saveJob tracks copying a comparison job's matches, and writing its results files, into a folder of the user's own drive
+type saveJob struct {
+	sessionID   string
+	destination *models.CloudItem
+	status      JobStatus
+	photos      int
+	exports     int
+	copied      int
+	uploaded    []string // Results files written
+	failures    []SaveFailure
+	finishedAt  time.Time
+}
+
+// SaveOptions selects what saving a job's matches writes into the destination folder
+type SaveOptions struct {
+	// ExportFormats are the results files to write alongside the photos, none by default
+	ExportFormats []ExportFormat
+	// SkipPhotos writes only the results files, without copying the matched photos
+	SkipPhotos bool
+}
+
+// saveJobTracker keeps the progress of save-to-drive jobs
+// Finished jobs are pruned whenever a new one starts, so no cleanup goroutine is needed
+type saveJobTracker struct {
+	jobs map[string]*saveJob
+	mu   sync.RWMutex
+}
+
+func newSaveJobTracker() *saveJobTracker {
+	return &saveJobTracker{
+		jobs: make(map[string]*saveJob),
+	}
+}
+
+// start registers a new save job and returns its ID
+func (t *saveJobTracker) start(sessionID string, destination *models.CloudItem, photos, exports int) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	for id, job := range t.jobs {
+		if !job.finishedAt.IsZero() && now.Sub(job.finishedAt) > saveJobRetention {
+			delete(t.jobs, id)
+		}
+	}
+
+	id := fmt.Sprintf("save-%d-%s", now.UnixNano(), sessionID)
+	t.jobs[id] = &saveJob{
+		sessionID:   sessionID,
+		destination: destination,
+		status:      JobStatusProcessing,
+		photos:      photos,
+		exports:     exports,
+	}
+
+	return id
+}
+
+// record adds the outcome of copying one file
+func (t *saveJobTracker) record(id string, failure *SaveFailure) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if job, exists := t.jobs[id]; exists {
+		if failure != nil {
+			job.failures = append(job.failures, *failure)
+		} else {
+			job.copied++
+		}
+	}
+}
+
+// recordUpload adds the outcome of writing one results file
+func (t *saveJobTracker) recordUpload(id, name string, failure *SaveFailure) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if job, exists := t.jobs[id]; exists {
+		if failure != nil {
+			job.failures = append(job.failures, *failure)
+		} else {
+			job.uploaded = append(job.uploaded, name)
+		}
+	}
+}
+
+// finish marks a save job as done; it fails only when nothing could be saved
+func (t *saveJobTracker) finish(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if job, exists := t.jobs[id]; exists {
+		job.status = JobStatusCompleted
+		if job.copied == 0 && len(job.uploaded) == 0 && len(job.failures) > 0 {
+			job.status = JobStatusFailed
+		}
+		job.finishedAt = time.Now()
+	}
+}
+
+// status returns a snapshot of a save job's progress if it belongs to the session
+func (t *saveJobTracker) status(sessionID, id string) (*SaveJobStatusResponse, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	job, exists := t.jobs[id]
+	if !exists || job.sessionID != sessionID {
+		return nil, false
+	}
+
+	response := &SaveJobStatusResponse{
+		SaveJobID:   id,
+		Status:      job.status,
+		Destination: job.destination,
+		Total:       job.photos + job.exports,
+		Copied:      job.copied,
+		Uploaded:    append([]string{}, job.uploaded...),
+		Failures:    append([]SaveFailure{}, job.failures...),
+	}
+
+	done := job.copied + len(job.uploaded) + len(job.failures)
+	if response.Total > 0 {
+		response.Progress = done * 100 / response.Total
+	}
+
+	switch job.status {
+	case JobStatusProcessing:
+		if job.exports > 0 {
+			response.Message = fmt.Sprintf("Saving file %d of %d", done, response.Total)
+		} else {
+			response.Message = fmt.Sprintf("Saving photo %d of %d", done, job.photos)
+		}
+	case JobStatusCompleted:
+		switch {
+		case job.exports == 0:
+			response.Message = fmt.Sprintf("Saved %d of %d photos to %s", job.copied, job.photos, job.destination.Name)
+		case job.photos == 0:
+			response.Message = fmt.Sprintf("Saved %d of %d results files to %s", len(job.uploaded), job.exports, job.destination.Name)
+		default:
+			response.Message = fmt.Sprintf("Saved %d of %d photos and %d of %d results files to %s",
+				job.copied, job.photos, len(job.uploaded), job.exports, job.destination.Name)
+		}
+	case JobStatusFailed:
+		response.Message = "Nothing could be saved. Check that you granted write access and can add files to the folder."
+	}
+
+	return response, true
+}
+
+// SaveMatchesToDrive starts copying a completed job's matches into a folder of the user's own drive,
+// and writing the requested results files next to them
+// Copies are made by the provider, so photos never pass through the backend; progress is tracked as a save job
+func (s *Service) SaveMatchesToDrive(sessionID, jobID string, destination *models.CloudItem, token *models.Token, options SaveOptions) (string, error) {
+	if !s.saveToDriveEnabled {
+		return "", ErrSaveToDriveDisabled
+	}
+	if s.drain.On() {
+		return "", ErrNewJobsDisabled
+	}
+
+	ctx, exists := s.jobManager.Get(jobID)
+	if !exists || ctx.sessionID != sessionID {
+		return "", ErrJobNotFound
+	}
+	if ctx.status != JobStatusCompleted {
+		return "", ErrJobNotCompleted
+	}
+
+	// Provider copy APIs only work within one provider
+	if ctx.token != nil && ctx.token.Provider != token.Provider {
+		return "", fmt.Errorf("%w: the matches are stored in %s", ErrInvalidDestination, ctx.token.Provider)
+	}
+	if !destination.IsFolder || (destination.Provider != "" && destination.Provider != token.Provider) {
+		return "", fmt.Errorf("%w: expected a %s folder", ErrInvalidDestination, token.Provider)
+	}
+
+	// Images extracted from a document are copied as the document, once
+	var items []*models.CloudItem
+	seen := make(map[string]bool, len(ctx.matches))
+	for _, match := range ctx.matches {
+		if match.Index < 0 || match.Index >= len(ctx.allImages) {
+			continue
+		}
+
+		item := ctx.allImages[match.Index].Stored()
+		if !seen[item.ID] {
+			seen[item.ID] = true
+			items = append(items, item)
+		}
+	}
+	if len(items) == 0 {
+		return "", ErrNoMatchesToSave
+	}
+
+	// The results are collected now, as the job may be pruned before the copies finish
+	var export *ResultsExport
+	if len(options.ExportFormats) > 0 {
+		export = buildResultsExport(jobID, ctx)
+	}
+	if options.SkipPhotos {
+		items = nil
+	}
+
+	saveJobID := s.saveJobs.start(sessionID, destination, len(items), len(options.ExportFormats))
+
+	go func() {
+		for _, format := range options.ExportFormats {
+			name := format.FileName()
+			content, err := export.encode(format)
+			if err == nil {
+				err = s.storageService.UploadFile(destination, name, format.MimeType(), content, token)
+			}
+			if err != nil {
+				log.Printf("Save job %s: failed to write %s: %v", saveJobID, name, err)
+				s.saveJobs.recordUpload(saveJobID, name, &SaveFailure{Name: name, Error: err.Error()})
+				continue
+			}
+			s.saveJobs.recordUpload(saveJobID, name, nil)
+		}
+
+		for _, item := range items {
+			token = s.freshToken(sessionID, token)
+			if err := s.storageService.CopyToFolder(item, destination, token); err != nil {
+				log.Printf("Save job %s: failed to copy %s: %v", saveJobID, item.Name, err)
+				s.saveJobs.record(saveJobID, &SaveFailure{Name: item.Name, Error: err.Error()})
+				continue
+			}
+			s.saveJobs.record(saveJobID, nil)
+		}
+		s.saveJobs.finish(saveJobID)
+	}()
+
+	return saveJobID, nil
+}
+
+// GetSaveJobStatus returns the progress of a save-to-drive job started by the session
+func (s *Service) GetSaveJobStatus(sessionID, saveJobID string) (*SaveJobStatusResponse, error) {
+	status, exists := s.saveJobs.status(sessionID, saveJobID)
+	if !exists {
+		return nil, ErrJobNotFound
+	}
+	return status, nil
+}
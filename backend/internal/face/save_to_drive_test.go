@@ -0,0 +1,225 @@
+package face
+
+import (
+	"all-me-backend/pkg/models"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitForSaveJobStatus polls a save job until it reaches the expected status
+func waitForSaveJobStatus(t *testing.T, service *Service, sessionID, saveJobID string, expected JobStatus) *SaveJobStatusResponse {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		status, err := service.GetSaveJobStatus(sessionID, saveJobID)
+		if err != nil {
+			t.Fatalf("GetSaveJobStatus failed: %v", err)
+		}
+		if status.Status == expected {
+			return status
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("Save job %s did not reach status %s", saveJobID, expected)
+	return nil
+}
+
+func newCompletedSaveTestJob(service *Service) {
+	images := []*models.CloudItem{
+		{ID: "img-0", Name: "0.jpg", Provider: "onedrive"},
+		{ID: "img-1", Name: "1.jpg", Provider: "onedrive"},
+		{ID: "img-2", Name: "2.jpg", Provider: "onedrive"},
+	}
+	token := &models.Token{AccessToken: "token", Provider: "onedrive"}
+
+	service.jobManager.Store("job-1", "session-1", images, token, compareOptions{})
+	service.jobManager.MarkCompleted("job-1", []pythonMatchResult{
+		{Index: 0, Distance: 0.3},
+		{Index: 2, Distance: 0.5},
+	})
+}
+
+func TestSaveMatchesToDrive_CopiesMatchedItems(t *testing.T) {
+	storage := &mockStorageService{}
+	service := createTestService(storage, "http://unused")
+	service.saveToDriveEnabled = true
+	newCompletedSaveTestJob(service)
+
+	destination := &models.CloudItem{ID: "folder-1", Name: "Me", IsFolder: true, Provider: "onedrive"}
+	token := &models.Token{AccessToken: "token", Provider: "onedrive"}
+
+	saveJobID, err := service.SaveMatchesToDrive("session-1", "job-1", destination, token, SaveOptions{})
+	if err != nil {
+		t.Fatalf("SaveMatchesToDrive failed: %v", err)
+	}
+
+	status := waitForSaveJobStatus(t, service, "session-1", saveJobID, JobStatusCompleted)
+
+	if status.Copied != 2 || status.Total != 2 || status.Progress != 100 {
+		t.Errorf("Expected 2 of 2 copied at 100%%, got %d of %d at %d%%", status.Copied, status.Total, status.Progress)
+	}
+	if len(status.Failures) != 0 {
+		t.Errorf("Expected no failures, got %v", status.Failures)
+	}
+
+	storage.mu.Lock()
+	copied := slices.Clone(storage.copied)
+	storage.mu.Unlock()
+	if !slices.Equal(copied, []string{"img-0", "img-2"}) {
+		t.Errorf("Expected img-0 and img-2 to be copied, got %v", copied)
+	}
+
+	if _, err := service.GetSaveJobStatus("other-session", saveJobID); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Expected ErrJobNotFound for another session, got %v", err)
+	}
+}
+
+func TestSaveMatchesToDrive_ReportsFailures(t *testing.T) {
+	storage := &mockStorageService{copyErrors: map[string]bool{"img-2": true}}
+	service := createTestService(storage, "http://unused")
+	service.saveToDriveEnabled = true
+	newCompletedSaveTestJob(service)
+
+	destination := &models.CloudItem{ID: "folder-1", Name: "Me", IsFolder: true, Provider: "onedrive"}
+	token := &models.Token{AccessToken: "token", Provider: "onedrive"}
+
+	saveJobID, err := service.SaveMatchesToDrive("session-1", "job-1", destination, token, SaveOptions{})
+	if err != nil {
+		t.Fatalf("SaveMatchesToDrive failed: %v", err)
+	}
+
+	status := waitForSaveJobStatus(t, service, "session-1", saveJobID, JobStatusCompleted)
+
+	if status.Copied != 1 {
+		t.Errorf("Expected 1 copied, got %d", status.Copied)
+	}
+	if len(status.Failures) != 1 || status.Failures[0].Name != "2.jpg" {
+		t.Errorf("Expected a failure for 2.jpg, got %v", status.Failures)
+	}
+}
+
+func TestSaveMatchesToDrive_WritesResultsFiles(t *testing.T) {
+	storage := &mockStorageService{uploadErrors: map[string]bool{"matches.json": true}}
+	service := createTestService(storage, "http://unused")
+	service.saveToDriveEnabled = true
+	newCompletedSaveTestJob(service)
+	service.jobManager.SetFolder("job-1", &models.CloudItem{ID: "event", Name: "Event"}, "https://1drv.ms/f/event")
+
+	destination := &models.CloudItem{ID: "folder-1", Name: "Me", IsFolder: true, Provider: "onedrive"}
+	token := &models.Token{AccessToken: "token", Provider: "onedrive"}
+
+	saveJobID, err := service.SaveMatchesToDrive("session-1", "job-1", destination, token, SaveOptions{
+		ExportFormats: []ExportFormat{ExportFormatCSV, ExportFormatJSON},
+		SkipPhotos:    true,
+	})
+	if err != nil {
+		t.Fatalf("SaveMatchesToDrive failed: %v", err)
+	}
+
+	status := waitForSaveJobStatus(t, service, "session-1", saveJobID, JobStatusCompleted)
+
+	if status.Copied != 0 || status.Total != 2 || status.Progress != 100 {
+		t.Errorf("Expected no photos and 2 results files at 100%%, got %d copied of %d at %d%%", status.Copied, status.Total, status.Progress)
+	}
+	if !slices.Equal(status.Uploaded, []string{"matches.csv"}) {
+		t.Errorf("Expected matches.csv to be written, got %v", status.Uploaded)
+	}
+	if len(status.Failures) != 1 || status.Failures[0].Name != "matches.json" {
+		t.Errorf("Expected a failure for matches.json, got %v", status.Failures)
+	}
+	if status.Message != "Saved 1 of 2 results files to Me" {
+		t.Errorf("Unexpected message: %s", status.Message)
+	}
+
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	if len(storage.copied) != 0 {
+		t.Errorf("Expected no photos to be copied, got %v", storage.copied)
+	}
+	expected := "name,item_id,provider,distance,reference,source_document,source_image,source_page,modified_time\n" +
+		"0.jpg,img-0,onedrive,0.3000,,,,,\n" +
+		"2.jpg,img-2,onedrive,0.5000,,,,,\n"
+	if storage.uploads["matches.csv"] != expected {
+		t.Errorf("Unexpected matches.csv:\n%s", storage.uploads["matches.csv"])
+	}
+}
+
+func TestResultsExport_EncodesJSONAndEscapesFormulas(t *testing.T) {
+	reference := 1
+	export := &ResultsExport{
+		JobID:      "job-1",
+		FolderName: "Event",
+		Matches: []MatchExport{
+			{Name: "=HYPERLINK(\"x\").jpg", ItemID: "img-0", Provider: "googledrive", Distance: 0.25, Reference: &reference},
+			{Name: "sheet.pdf (page 3, image 2)", ItemID: "sheet#image-2", Provider: "googledrive", Distance: 0.4, SourceDocument: "sheet.pdf", SourceImage: 2, SourcePage: 3},
+		},
+	}
+
+	csvContent, err := export.encode(ExportFormatCSV)
+	if err != nil {
+		t.Fatalf("Failed to encode CSV: %v", err)
+	}
+	if !strings.Contains(string(csvContent), "\"'=HYPERLINK(\"\"x\"\").jpg\",img-0,googledrive,0.2500,1,,,,\n") {
+		t.Errorf("Expected the formula-like name to be escaped, got:\n%s", csvContent)
+	}
+	if !strings.Contains(string(csvContent), "\"sheet.pdf (page 3, image 2)\",sheet#image-2,googledrive,0.4000,,sheet.pdf,2,3,\n") {
+		t.Errorf("Expected the document image row, got:\n%s", csvContent)
+	}
+
+	jsonContent, err := export.encode(ExportFormatJSON)
+	if err != nil {
+		t.Fatalf("Failed to encode JSON: %v", err)
+	}
+	var decoded ResultsExport
+	if err := json.Unmarshal(jsonContent, &decoded); err != nil {
+		t.Fatalf("Failed to decode JSON: %v", err)
+	}
+	if decoded.JobID != "job-1" || len(decoded.Matches) != 2 || decoded.Matches[1].SourceDocument != "sheet.pdf" {
+		t.Errorf("Unexpected JSON export: %+v", decoded)
+	}
+
+	if _, err := ParseExportFormats([]string{"csv", "xlsx"}); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+	if formats, _ := ParseExportFormats([]string{"CSV", "json", "csv"}); !slices.Equal(formats, []ExportFormat{ExportFormatCSV, ExportFormatJSON}) {
+		t.Errorf("Expected csv and json once each, got %v", formats)
+	}
+}
+
+func TestSaveMatchesToDrive_Errors(t *testing.T) {
+	folder := &models.CloudItem{ID: "folder-1", IsFolder: true, Provider: "onedrive"}
+	token := &models.Token{AccessToken: "token", Provider: "onedrive"}
+
+	tests := []struct {
+		name        string
+		disabled    bool
+		jobID       string
+		destination *models.CloudItem
+		token       *models.Token
+		expected    error
+	}{
+		{"saving disabled", true, "job-1", folder, token, ErrSaveToDriveDisabled},
+		{"unknown job", false, "missing", folder, token, ErrJobNotFound},
+		{"destination is a file", false, "job-1", &models.CloudItem{ID: "file-1", Provider: "onedrive"}, token, ErrInvalidDestination},
+		{"other provider", false, "job-1", &models.CloudItem{ID: "folder-1", IsFolder: true, Provider: "googledrive"}, &models.Token{Provider: "googledrive"}, ErrInvalidDestination},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := createTestService(&mockStorageService{}, "http://unused")
+			service.saveToDriveEnabled = !tt.disabled
+			newCompletedSaveTestJob(service)
+
+			_, err := service.SaveMatchesToDrive("session-1", tt.jobID, tt.destination, tt.token, SaveOptions{})
+			if !errors.Is(err, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, err)
+			}
+		})
+	}
+}
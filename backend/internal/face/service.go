@@ -1,111 +1,669 @@
 package face
 
 import (
+	"all-me-backend/internal/maintenance"
+	"all-me-backend/internal/providers/httptransport"
+	"all-me-backend/internal/providers/workers"
+	"all-me-backend/pkg/config"
+	"all-me-backend/pkg/mediatypes"
 	"all-me-backend/pkg/models"
 	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log"
+	mathrand "math/rand/v2"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	defaultMaxImagesPerJob       = 5000
+	defaultMaxBatchPayloadBytes  = 50 * 1024 * 1024
+	defaultMaxConcurrentBatches  = 4
+	defaultDownloadWorkers       = 10
+	maxDownloadWorkers           = 50
+	defaultIdempotencyKeyTTL     = 60 // minutes
+	defaultStatusPollMaxFailures = 5
+	defaultMaxJobsPerSession     = 20
+	defaultConnectTimeout        = 5   // seconds
+	defaultResponseTimeout       = 120 // seconds
+
+	// Image downloads are tried maxImageDownloadAttempts times, waiting 200ms, 400ms, then 800ms between attempts
+	maxImageDownloadAttempts    = 4
+	defaultDownloadRetryBackoff = 200 * time.Millisecond
+
+	// Python job status is polled every statusPollInterval, backing off up to maxStatusPollBackoff after failures
+	defaultStatusPollInterval = 500 * time.Millisecond
+	maxStatusPollBackoff      = 10 * time.Second
+
+	// While a job makes no progress, polls slow down up to statusPollMaxInterval
+	defaultStatusPollMaxInterval = 5 // seconds
+
+	// A job whose face service batches haven't all finished after processingTimeout is failed
+	processingTimeout = 60 * time.Minute
+
+	// statusPollJitter spreads each poll delay by up to ±10%, so concurrent jobs don't poll in lockstep
+	statusPollJitter = 0.1
+
+	// payloadBytesPerImage approximates the JSON quoting and separator overhead for each encoded image
+	payloadBytesPerImage = 3
+)
+
 type Service struct {
 	pythonServiceURL string
 	httpClient       *http.Client
 	storageService   StorageService
 	jobManager       *JobManager
+	maxImagesPerJob  int
+
+	// maxBatchPayloadBytes caps the encoded image data sent to the Python service in one request
+	maxBatchPayloadBytes int
+
+	// downloadRetryBackoff is the wait before retrying a failed image download, doubling with each attempt
+	downloadRetryBackoff time.Duration
+
+	// downloadWorkers is how many images of a batch are downloaded at once, within the shared provider request limit
+	downloadWorkers int
+
+	// maxConcurrentBatches caps how many Python jobs one job has running at once, so a large job
+	// can't occupy the Python service while other users' jobs wait
+	maxConcurrentBatches int
+
+	// memoryBudget caps the downloaded image data all jobs hold at once
+	memoryBudget *memoryBudget
+
+	// batchTransport is how candidate images are sent to the Python service
+	batchTransport BatchTransport
+
+	// collapseDuplicates reports byte-identical images once instead of once per original item
+	collapseDuplicates bool
+
+	// dedupNearDuplicates also skips photos that look the same as an earlier one, such as the same shot
+	// uploaded at another size; it costs decoding every image to hash it
+	dedupNearDuplicates bool
+
+	// preprocessMaxDimension is the longest side images are downscaled to before face recognition
+	preprocessMaxDimension int
+
+	// decodeGuard bounds the pixel count and decode time of images decoded for preprocessing and hashing
+	decodeGuard decodeGuard
+
+	// enhancements are the opt-in grayscale/equalize steps applied to both base faces and candidates
+	enhancements PreprocessSteps
+
+	// documentImagesEnabled compares photos embedded in PDFs found in compared folders
+	// At most maxDocuments documents of up to maxDocumentBytes are read, each contributing up to maxDocumentImages images
+	documentImagesEnabled bool
+	maxDocuments          int
+	maxDocumentImages     int
+	maxDocumentBytes      int64
+
+	// imageMimeTypes are the content types compared against the base face
+	imageMimeTypes []string
+	// baseFaceMimeTypes are the content types accepted for base faces: imageMimeTypes and HEIC/HEIF
+	baseFaceMimeTypes []string
+
+	// tokens provides refreshed session tokens to jobs that outlive the token they started with, nil in tests
+	tokens TokenSource
+
+	// resultStore keeps saved result manifests, resultTTL is how long they stay retrievable
+	resultStore ResultStore
+	resultTTL   time.Duration
+
+	// referenceStore keeps reference faces accounts opted to save, nil when saving is disabled
+	referenceStore ReferenceStore
+
+	// autoClearReference clears a session's reference face from the Python service once its jobs finish,
+	// so biometric data does not linger; re-running a job then needs the base face registered again
+	autoClearReference bool
+
+	// drain rejects new comparison jobs while it is on, e.g. to drain the server before a deploy
+	// Jobs already running finish and their status stays available
+	drain *maintenance.Switch
+
+	// saveToDriveEnabled allows copying matches into the user's own drive, which needs write scopes
+	// saveJobs tracks the progress of those copies
+	saveToDriveEnabled bool
+	saveJobs           *saveJobTracker
+
+	// throughput averages how fast recent jobs processed their images, for estimating new ones
+	throughput *throughputTracker
+
+	// sessionModels remembers the face model each session's base face was registered with
+	sessionModels *sessionModelTracker
+
+	// cursorKey signs match page cursors, cursorTTL is how long a cursor stays valid
+	cursorKey []byte
+	cursorTTL time.Duration
+
+	// idempotencyKeyTTL is how long a retried request with the same Idempotency-Key returns the same job
+	idempotencyKeyTTL time.Duration
+
+	// statusPollInterval is how often Python jobs are polled, statusPollMaxFailures how many
+	// consecutive failed polls of one Python job are tolerated before the whole job fails
+	statusPollInterval    time.Duration
+	statusPollMaxFailures int
+	// statusPollMaxInterval caps how far polling slows down while a job makes no progress
+	statusPollMaxInterval time.Duration
+
+	// workers bounds provider downloads across all jobs to protect shared provider quotas
+	// The pool is shared with recursive folder listings
+	workers *workers.Pool
+
+	// outOfRangeIndices counts match and error indices that did not map to an image, which indicates a bug
+	outOfRangeIndices atomic.Int64
 }
 
-func NewService(storageService StorageService) *Service {
+func NewService(storageService StorageService, tokens TokenSource) *Service {
+	maxImages := config.GetInt("FACE_MAX_IMAGES_PER_JOB", defaultMaxImagesPerJob)
+	if maxImages < 1 {
+		maxImages = defaultMaxImagesPerJob
+	}
+
+	maxPayload := config.GetInt("FACE_MAX_BATCH_PAYLOAD_BYTES", defaultMaxBatchPayloadBytes)
+	if maxPayload < 1 {
+		maxPayload = defaultMaxBatchPayloadBytes
+	}
+
+	maxConcurrentBatches := config.GetInt("FACE_MAX_CONCURRENT_BATCHES", defaultMaxConcurrentBatches)
+	if maxConcurrentBatches < 1 {
+		maxConcurrentBatches = defaultMaxConcurrentBatches
+	}
+
+	downloadWorkers := config.GetInt("FACE_DOWNLOAD_WORKERS", defaultDownloadWorkers)
+	if downloadWorkers < 1 {
+		log.Printf("FACE_DOWNLOAD_WORKERS must be positive, using default %d", defaultDownloadWorkers)
+		downloadWorkers = defaultDownloadWorkers
+	}
+	if downloadWorkers > maxDownloadWorkers {
+		log.Printf("FACE_DOWNLOAD_WORKERS exceeds %d, clamping to it", maxDownloadWorkers)
+		downloadWorkers = maxDownloadWorkers
+	}
+
+	maxInFlight := config.GetInt("FACE_MAX_IN_FLIGHT_BYTES", defaultMaxInFlightBytes)
+	if maxInFlight < 1 {
+		maxInFlight = defaultMaxInFlightBytes
+	}
+
+	maxDimension := config.GetInt("FACE_PREPROCESS_MAX_DIMENSION", defaultPreprocessMaxDimension)
+	if maxDimension < 1 {
+		maxDimension = defaultPreprocessMaxDimension
+	}
+
+	resultTTL := config.GetInt("FACE_RESULT_TTL_HOURS", defaultResultTTL)
+	if resultTTL < 1 {
+		resultTTL = defaultResultTTL
+	}
+
+	idempotencyKeyTTL := config.GetInt("FACE_IDEMPOTENCY_KEY_TTL_MINUTES", defaultIdempotencyKeyTTL)
+	if idempotencyKeyTTL < 1 {
+		idempotencyKeyTTL = defaultIdempotencyKeyTTL
+	}
+
+	statusPollMaxFailures := config.GetInt("FACE_STATUS_POLL_MAX_FAILURES", defaultStatusPollMaxFailures)
+	if statusPollMaxFailures < 1 {
+		statusPollMaxFailures = defaultStatusPollMaxFailures
+	}
+
+	statusPollMaxInterval := config.GetInt("FACE_STATUS_POLL_MAX_INTERVAL_SECONDS", defaultStatusPollMaxInterval)
+	if statusPollMaxInterval < 1 {
+		statusPollMaxInterval = defaultStatusPollMaxInterval
+	}
+
+	enhancements, err := parseEnhancements(config.GetString("FACE_PREPROCESS_ENHANCE", ""))
+	if err != nil {
+		log.Printf("Ignoring FACE_PREPROCESS_ENHANCE: %v", err)
+	}
+
+	batchTransport, err := ParseBatchTransport(config.GetString("FACE_BATCH_TRANSPORT", ""))
+	if err != nil {
+		log.Printf("Ignoring FACE_BATCH_TRANSPORT: %v", err)
+		batchTransport = TransportBase64
+	}
+
+	maxJobsPerSession := config.GetInt("FACE_MAX_JOBS_PER_SESSION", defaultMaxJobsPerSession)
+	if maxJobsPerSession < 1 {
+		maxJobsPerSession = defaultMaxJobsPerSession
+	}
+	jobManager := NewJobManager()
+	jobManager.maxJobsPerSession = maxJobsPerSession
+
+	connectTimeout := config.GetInt("FACE_SERVICE_CONNECT_TIMEOUT_SECONDS", defaultConnectTimeout)
+	if connectTimeout < 1 {
+		connectTimeout = defaultConnectTimeout
+	}
+
+	responseTimeout := config.GetInt("FACE_SERVICE_RESPONSE_TIMEOUT_SECONDS", defaultResponseTimeout)
+	if responseTimeout < 1 {
+		responseTimeout = defaultResponseTimeout
+	}
+
+	defaultThroughput := config.GetInt("FACE_ESTIMATE_DEFAULT_IMAGES_PER_SECOND", defaultImagesPerSecond)
+	if defaultThroughput < 1 {
+		defaultThroughput = defaultImagesPerSecond
+	}
+
+	maxDocuments := config.GetInt("FACE_PDF_MAX_DOCUMENTS", defaultMaxDocuments)
+	if maxDocuments < 1 {
+		maxDocuments = defaultMaxDocuments
+	}
+
+	maxDocumentImages := config.GetInt("FACE_PDF_MAX_IMAGES", defaultMaxDocumentImages)
+	if maxDocumentImages < 1 {
+		maxDocumentImages = defaultMaxDocumentImages
+	}
+
+	maxDocumentBytes := config.GetInt("FACE_PDF_MAX_BYTES", defaultMaxDocumentBytes)
+	if maxDocumentBytes < 1 {
+		maxDocumentBytes = defaultMaxDocumentBytes
+	}
+
+	var referenceStore ReferenceStore
+	if path := config.GetString("FACE_SAVED_REFERENCES_FILE", ""); path != "" {
+		store, err := NewFileReferenceStore(path)
+		if err != nil {
+			log.Printf("Saving reference faces is disabled: %v", err)
+		} else {
+			referenceStore = store
+		}
+	}
+
+	// Cursors only need to survive for the lifetime of the process holding the jobs they point into
+	cursorKey := make([]byte, 32)
+	if _, err := rand.Read(cursorKey); err != nil {
+		panic(fmt.Sprintf("failed to generate cursor key: %v", err))
+	}
+
+	imageMimeTypes := mediatypes.FaceComparableFromEnv()
+	baseFaceMimeTypes := slices.Clone(imageMimeTypes)
+	for _, mimeType := range heifMimeTypes {
+		if !slices.Contains(baseFaceMimeTypes, mimeType) {
+			baseFaceMimeTypes = append(baseFaceMimeTypes, mimeType)
+		}
+	}
+
 	return &Service{
-		pythonServiceURL: os.Getenv("FACE_SERVICE_URL"),
-		httpClient: &http.Client{
-			Timeout: 60 * time.Minute,
-		},
-		storageService: storageService,
-		jobManager:     NewJobManager(),
+		pythonServiceURL:       os.Getenv("FACE_SERVICE_URL"),
+		httpClient:             newPythonServiceClient(time.Duration(connectTimeout)*time.Second, time.Duration(responseTimeout)*time.Second),
+		storageService:         storageService,
+		tokens:                 tokens,
+		jobManager:             jobManager,
+		maxImagesPerJob:        maxImages,
+		maxBatchPayloadBytes:   maxPayload,
+		downloadWorkers:        downloadWorkers,
+		maxConcurrentBatches:   maxConcurrentBatches,
+		downloadRetryBackoff:   defaultDownloadRetryBackoff,
+		memoryBudget:           newMemoryBudget(int64(maxInFlight)),
+		batchTransport:         batchTransport,
+		collapseDuplicates:     config.GetBool("FACE_COLLAPSE_DUPLICATES", false),
+		dedupNearDuplicates:    config.GetBool("FACE_DEDUP_NEAR_DUPLICATES", false),
+		idempotencyKeyTTL:      time.Duration(idempotencyKeyTTL) * time.Minute,
+		cursorKey:              cursorKey,
+		cursorTTL:              defaultCursorTTL * time.Minute,
+		statusPollInterval:     defaultStatusPollInterval,
+		statusPollMaxFailures:  statusPollMaxFailures,
+		statusPollMaxInterval:  time.Duration(statusPollMaxInterval) * time.Second,
+		resultStore:            NewMemoryResultStoreWithClock(jobManager.clock),
+		resultTTL:              time.Duration(resultTTL) * time.Hour,
+		referenceStore:         referenceStore,
+		drain:                  maintenance.Shared(),
+		autoClearReference:     config.GetBool("FACE_AUTO_CLEAR_REFERENCE", false),
+		saveToDriveEnabled:     config.GetBool("SAVE_TO_DRIVE_ENABLED", false),
+		saveJobs:               newSaveJobTracker(),
+		sessionModels:          newSessionModelTracker(),
+		throughput:             newThroughputTracker(float64(defaultThroughput)),
+		preprocessMaxDimension: maxDimension,
+		decodeGuard:            decodeGuardFromEnv(),
+		enhancements:           enhancements,
+		documentImagesEnabled:  config.GetBool("FACE_PDF_IMAGES_ENABLED", false),
+		maxDocuments:           maxDocuments,
+		maxDocumentImages:      maxDocumentImages,
+		maxDocumentBytes:       int64(maxDocumentBytes),
+		imageMimeTypes:         imageMimeTypes,
+		baseFaceMimeTypes:      baseFaceMimeTypes,
+		workers:                workers.Shared(),
+	}
+}
+
+// newPythonServiceClient creates the HTTP client used for the Python service
+// It starts from the shared connection tuning, but connecting and waiting for response headers time out
+// quickly so an unreachable service fails fast, while the overall deadline of each call comes from its request context
+func newPythonServiceClient(connectTimeout, responseTimeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   connectTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+
+	transport := httptransport.New()
+	transport.DialContext = dialer.DialContext
+	transport.TLSHandshakeTimeout = connectTimeout
+	transport.ResponseHeaderTimeout = responseTimeout
+
+	return &http.Client{
+		Transport: transport,
 	}
 }
 
+// now tells the time from the job manager's clock, so tests control job and cursor expiry alike
+func (s *Service) now() time.Time {
+	return s.jobManager.clock.Now()
+}
+
+// ImageMimeTypes returns the content types of the folder images compared against the base face
+func (s *Service) ImageMimeTypes() []string {
+	return s.imageMimeTypes
+}
+
+// BaseFaceMimeTypes returns the content types accepted for base faces, which include HEIC/HEIF as they
+// are transcoded to JPEG before the face service sees them
+func (s *Service) BaseFaceMimeTypes() []string {
+	return s.baseFaceMimeTypes
+}
+
+// withEnhancements adds the configured enhancements to per-request preprocessing steps
+func (s *Service) withEnhancements(steps PreprocessSteps) PreprocessSteps {
+	steps.Grayscale = s.enhancements.Grayscale
+	steps.Equalize = s.enhancements.Equalize
+	return steps
+}
+
+// MaxImagesPerJob returns the largest number of images a single comparison job may contain
+func (s *Service) MaxImagesPerJob() int {
+	return s.maxImagesPerJob
+}
+
+// NewJobsDisabled reports whether new comparison jobs are currently rejected
+func (s *Service) NewJobsDisabled() bool {
+	return s.drain.On()
+}
+
+// SaveToDriveEnabled reports whether matches can be copied into the user's own drive
+func (s *Service) SaveToDriveEnabled() bool {
+	return s.saveToDriveEnabled
+}
+
+// SavedReferencesEnabled reports whether accounts can save their reference face for later sessions
+func (s *Service) SavedReferencesEnabled() bool {
+	return s.referenceStore != nil
+}
+
+// DocumentImagesEnabled reports whether photos embedded in PDFs are compared too
+func (s *Service) DocumentImagesEnabled() bool {
+	return s.documentImagesEnabled
+}
+
 // RegisterBaseFace registers a base face image with the Python service
 // This image is used as the reference for future comparisons in a given session
-func (s *Service) RegisterBaseFace(sessionID string, imageData []byte) error {
-	encodedImage := base64.StdEncoding.EncodeToString(imageData)
+// With appendReference set it is added to the session's existing references, e.g. another angle of the same face
+// Returns how many reference images the session has afterwards
+// The image is streamed into the request as base64, so large uploads are never held in memory twice
+func (s *Service) RegisterBaseFace(sessionID string, image io.ReadSeeker, preprocess PreprocessSteps, model FaceModel, appendReference bool) (int, error) {
+	// References appended to a session must be encoded like the ones already registered
+	if appendReference {
+		if err := s.sessionModels.check(sessionID, model); err != nil {
+			return 0, err
+		}
+	}
+
+	image, err := prepareReferenceImage(image, s.decodeGuard)
+	if err != nil {
+		return 0, err
+	}
+
+	modified, changed, err := preprocessStream(image, s.withEnhancements(preprocess), s.preprocessMaxDimension, s.decodeGuard)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidImageFormat, err)
+	}
 
-	payload := pythonRegisterRequest{
-		SessionID: sessionID,
-		Image:     encodedImage,
+	var source io.Reader = image
+	if changed {
+		source = bytes.NewReader(modified)
+	} else if _, err := image.Seek(0, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("failed to read image: %w", err)
 	}
 
+	body := registerRequestBody(sessionID, model, appendReference, source)
+	defer body.Close()
+
 	var result pythonRegisterResponse
-	if err := s.callPythonServicePost("/face/register", payload, &result); err != nil {
-		return err
+	if err := s.postToPythonService("/face/register", "application/json", body, &result); err != nil {
+		return 0, err
 	}
 
 	if !result.Success {
 		if result.Error != "" {
 			// Map Python service errors to custom error types
 			if strings.Contains(strings.ToLower(result.Error), "no face detected") {
-				return ErrNoFaceDetected
+				return 0, ErrNoFaceDetected
 			}
 			if strings.Contains(strings.ToLower(result.Error), "multiple faces") {
-				return ErrMultipleFaces
+				return 0, ErrMultipleFaces
 			}
-			return fmt.Errorf("%w: %s", ErrInvalidImageFormat, result.Error)
+			return 0, fmt.Errorf("%w: %s", ErrInvalidImageFormat, result.Error)
 		}
-		return ErrInvalidImageFormat
+		return 0, ErrInvalidImageFormat
 	}
 
-	return nil
+	s.sessionModels.set(sessionID, model)
+
+	return max(result.ReferenceCount, 1), nil
+}
+
+// RegisterBaseFaceFromItem registers an image from a listed folder as a base face, so a photo already in
+// the shared folder can be the reference. The item is looked up again with the provider rather than trusting
+// the client's copy of it, and RegisterBaseFace then checks it shows exactly one face
+func (s *Service) RegisterBaseFaceFromItem(sessionID string, itemRef *models.CloudItem, token *models.Token, preprocess PreprocessSteps, model FaceModel, appendReference bool) (int, error) {
+	item, err := s.storageService.GetItem(itemRef, token)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrItemAccess, err)
+	}
+
+	if item.IsFolder || !mediatypes.Contains(s.baseFaceMimeTypes, item.MimeType) {
+		return 0, fmt.Errorf("%w: %s is not a supported image", ErrInvalidImageFormat, item.Name)
+	}
+
+	imageData, err := s.downloadImage(context.Background(), item, token, nil)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrItemAccess, err)
+	}
+
+	if len(imageData) > MaxBaseFaceSize {
+		return 0, fmt.Errorf("%w: image exceeds the maximum size of %dMB", ErrInvalidImageFormat, MaxBaseFaceSize/(1024*1024))
+	}
+
+	return s.RegisterBaseFace(sessionID, bytes.NewReader(imageData), preprocess, model, appendReference)
 }
 
 // CompareFolderImages starts an async comparison job and returns the job ID
-func (s *Service) CompareFolderImages(sessionID string, folderLink string, token *models.Token, recursive bool) (string, error) {
+func (s *Service) CompareFolderImages(sessionID string, folderLink string, token *models.Token, options compareOptions) (string, error) {
+	if s.drain.On() {
+		return "", ErrNewJobsDisabled
+	}
+
+	// Checked before calling the provider, as comparing encodings from different models gives meaningless distances
+	if err := s.sessionModels.check(sessionID, options.model); err != nil {
+		return "", err
+	}
+
 	folderItem, err := s.storageService.ParseShareLink(folderLink, token)
 	if err != nil {
-		return "", fmt.Errorf("%w: %v", ErrInvalidFolderLink, err)
+		return "", fmt.Errorf("%w: %w", ErrInvalidFolderLink, err)
 	}
 
-	allImages, err := s.storageService.ListImages(folderItem, token, recursive)
+	return s.compareFolder(sessionID, folderLink, folderItem, token, options)
+}
+
+// WithIdempotencyKey runs start at most once per session and idempotency key within the key TTL
+// Retries (including concurrent ones) with the same key get the job ID from the first request
+// An empty key always runs start
+func (s *Service) WithIdempotencyKey(sessionID, key string, start func() (string, error)) (string, error) {
+	if key == "" {
+		return start()
+	}
+
+	entry, owner := s.jobManager.ClaimIdempotencyKey(sessionID, key, s.idempotencyKeyTTL)
+	if !owner {
+		<-entry.done
+		return entry.jobID, entry.err
+	}
+
+	jobID, err := start()
+	s.jobManager.CompleteIdempotencyKey(sessionID, key, entry, jobID, err)
+
+	return jobID, err
+}
+
+// CompareFolderItemImages starts an async comparison job for a folder the client has already resolved,
+// skipping share link parsing
+func (s *Service) CompareFolderItemImages(sessionID string, folderItem *models.CloudItem, token *models.Token, options compareOptions) (string, error) {
+	if s.drain.On() {
+		return "", ErrNewJobsDisabled
+	}
+
+	if err := s.sessionModels.check(sessionID, options.model); err != nil {
+		return "", err
+	}
+
+	if folderItem.Provider != "" && folderItem.Provider != token.Provider {
+		return "", fmt.Errorf("%w: folder provider %s does not match %s", ErrInvalidFolderLink, folderItem.Provider, token.Provider)
+	}
+
+	return s.compareFolder(sessionID, "", folderItem, token, options)
+}
+
+// compareFolder lists the images in a resolved folder and starts the batch comparison job
+// folderLink is the share link the folder was resolved from, if any
+func (s *Service) compareFolder(sessionID string, folderLink string, folderItem *models.CloudItem, token *models.Token, options compareOptions) (string, error) {
+	allImages, warnings, err := s.storageService.ListImages(folderItem, token, options.recursive, options.dates)
 	if err != nil {
 		return "", fmt.Errorf("%w: %v", ErrFolderAccess, err)
 	}
 
+	// Checked before any document is downloaded, and again once their images are added
+	if len(allImages) > s.maxImagesPerJob {
+		return "", fmt.Errorf("%w: folder has %d images, maximum is %d", ErrTooManyImages, len(allImages), s.maxImagesPerJob)
+	}
+
+	var otherFiles []*models.CloudItem
+	if options.includeAllFiles || s.documentImagesEnabled {
+		otherFiles, err = s.storageService.ListNonImageFiles(folderItem, token, options.recursive)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrFolderAccess, err)
+		}
+	}
+
+	var documents *documentCache
+	if s.documentImagesEnabled {
+		documents = newDocumentCache()
+		documentImages, documentWarnings := s.listDocumentImages(otherFiles, token, documents)
+		allImages = append(allImages, documentImages...)
+		warnings = append(warnings, documentWarnings...)
+	}
+
+	// Once the job runs it removes the extracted images itself
+	started := false
+	defer func() {
+		if !started && documents != nil {
+			documents.close()
+		}
+	}()
+	if !options.includeAllFiles {
+		otherFiles = nil
+	}
+
 	if len(allImages) == 0 {
 		return "", fmt.Errorf("%w: no images found in folder", ErrFolderAccess)
 	}
 
+	if len(allImages) > s.maxImagesPerJob {
+		return "", fmt.Errorf("%w: folder has %d images, maximum is %d", ErrTooManyImages, len(allImages), s.maxImagesPerJob)
+	}
+
 	// Process images in batches of 100
-	jobID, err := s.processFolderInBatches(sessionID, allImages, token)
+	options.documents = documents
+	jobID, err := s.processFolderInBatches(sessionID, allImages, token, options)
 	if err != nil {
 		return "", err
 	}
+	started = true
+
+	s.jobManager.AddWarnings(jobID, warnings)
+	s.jobManager.SetOtherFiles(jobID, otherFiles)
+	s.jobManager.SetFolder(jobID, folderItem, folderLink)
 
 	return jobID, nil
 }
 
+// CompareDriveImages starts an async comparison job over every image in the user's drive
+// The listing is capped at the per-job image limit
+func (s *Service) CompareDriveImages(sessionID string, token *models.Token, model FaceModel) (string, error) {
+	if s.drain.On() {
+		return "", ErrNewJobsDisabled
+	}
+
+	if err := s.sessionModels.check(sessionID, model); err != nil {
+		return "", err
+	}
+
+	allImages, err := s.storageService.ListAllImages(token, s.maxImagesPerJob)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrFolderAccess, err)
+	}
+
+	if len(allImages) == 0 {
+		return "", fmt.Errorf("%w: no images found in drive", ErrFolderAccess)
+	}
+
+	return s.processFolderInBatches(sessionID, allImages, token, compareOptions{preprocess: DefaultPreprocessSteps, model: model})
+}
+
 // GetJobStatus retrieves the status of a comparison job
-func (s *Service) GetJobStatus(jobID string) (*JobStatusResponse, error) {
+// With debug set, the response also lists images that could not be processed
+// A non-zero page limits the matches returned, with next_cursor pointing at the following page
+func (s *Service) GetJobStatus(jobID string, debug bool, page MatchPage) (*JobStatusResponse, error) {
+	after := -1
+	if page.Cursor != "" {
+		cursor, err := decodeCursor(s.cursorKey, page.Cursor, jobID, s.now())
+		if err != nil {
+			return nil, err
+		}
+		after = cursor.After
+	}
+	if page.Cursor != "" && page.Size == 0 {
+		page.Size = defaultMatchPageSize
+	}
+
 	// Check if this is a batch job managed by Go
-	ctx, isBatchJob := s.jobManager.Get(jobID)
+	// A running job keeps being updated, so its status is read from a snapshot
+	ctx, isBatchJob := s.jobManager.Snapshot(jobID)
 
 	if isBatchJob {
 		// Return status from our job manager
 		response := &JobStatusResponse{
-			JobID:        jobID,
-			Status:       ctx.status,
-			CurrentImage: ctx.currentImage,
-			TotalImages:  ctx.totalImages,
-			MatchesFound: ctx.matchesFound,
-			Error:        ctx.errorMessage,
+			JobID:           jobID,
+			RerunOf:         ctx.options.rerunOf,
+			Status:          ctx.status,
+			CurrentImage:    ctx.currentImage,
+			TotalImages:     ctx.totalImages,
+			MatchesFound:    ctx.matchesFound,
+			ImagesWithFaces: ctx.imagesWithFaces,
+			Warnings:        ctx.warnings,
+			FailedImages:    ctx.failedImages,
+			Error:           ctx.errorMessage,
 		}
 
 		// Calculate progress percentage
@@ -114,181 +672,642 @@ func (s *Service) GetJobStatus(jobID string) (*JobStatusResponse, error) {
 		}
 
 		// Set message
-		if ctx.status == "processing" {
+		switch ctx.status {
+		case JobStatusProcessing:
 			response.Message = fmt.Sprintf("Processing image %d of %d", ctx.currentImage, ctx.totalImages)
-		} else if ctx.status == "completed" {
-			response.Message = fmt.Sprintf("Completed! Found %d matches", ctx.matchesFound)
-		} else if ctx.status == "failed" {
+		case JobStatusCompleted:
+			response.NoMatches = ctx.matchesFound == 0
+			response.Message = completionMessage(ctx.totalImages, ctx.imagesWithFaces, ctx.matchesFound)
+			if len(ctx.failedImages) > 0 {
+				response.Message += fmt.Sprintf(". Skipped %d unreadable files", len(ctx.failedImages))
+			}
+		case JobStatusFailed:
 			response.Message = fmt.Sprintf("Failed: %s", ctx.errorMessage)
+		case JobStatusCancelled:
+			response.Message = "Cancelled"
 		}
 
-		// Map matches to cloud items if completed
-		if ctx.status == "completed" && ctx.matches != nil {
+		// Map matches to cloud items, the ones found so far while the job is still processing
+		// Batches can finish out of order, so clients page through matches once the job completes
+		if (ctx.status == JobStatusCompleted || ctx.status == JobStatusProcessing) && ctx.matches != nil {
 			matchingItems := make([]*models.CloudItem, 0, len(ctx.matches))
 			for _, matchResult := range ctx.matches {
-				if matchResult.Index < len(ctx.allImages) {
-					item := ctx.allImages[matchResult.Index]
-					// Create a copy and add the match distance
-					itemCopy := *item
-					itemCopy.MatchDistance = &matchResult.Distance
-					matchingItems = append(matchingItems, &itemCopy)
+				if matchResult.Index <= after {
+					continue
+				}
+				if matchResult.Index < 0 || matchResult.Index >= len(ctx.allImages) {
+					s.recordOutOfRangeIndex(jobID, "match", matchResult.Index, len(ctx.allImages))
+					continue
+				}
+				if page.Size > 0 && len(matchingItems) == page.Size {
+					response.NextCursor = encodeCursor(s.cursorKey, matchCursor{
+						JobID:     jobID,
+						After:     after,
+						ExpiresAt: s.now().Add(s.cursorTTL).Unix(),
+					})
+					break
+				}
+
+				item := ctx.allImages[matchResult.Index]
+				// Create a copy and add the match distance
+				itemCopy := *item
+				itemCopy.MatchDistance = &matchResult.Distance
+				itemCopy.MatchConfidence = &matchResult.Confidence
+				itemCopy.MatchReference = matchResult.Reference
+				// Matches from mixed-provider jobs are downloaded by their own provider
+				if itemCopy.Provider == "" && ctx.token != nil {
+					itemCopy.Provider = ctx.token.Provider
 				}
+				matchingItems = append(matchingItems, &itemCopy)
+				after = matchResult.Index
 			}
 			response.Matches = matchingItems
 
-			// Clean up the job context after successful completion
-			s.jobManager.Delete(jobID)
+			// Completed jobs are kept until the expiry cleanup so they can be re-run
+		}
+
+		if ctx.status == JobStatusCompleted && ctx.options.includeAllFiles {
+			response.Listing = &FolderListing{
+				Images:     ctx.allImages,
+				OtherFiles: ctx.otherFiles,
+			}
+		}
+
+		if debug {
+			response.Diagnostics = buildDiagnostics(ctx)
 		}
 
-		// Also clean up on error or failed status
-		if ctx.status == "failed" || ctx.status == "error" {
+		// Also clean up failed and cancelled jobs
+		if ctx.status == JobStatusFailed || ctx.status == JobStatusCancelled {
 			s.jobManager.Delete(jobID)
 		}
 
-		return response, nil
+		return response, nil
+	}
+
+	// Fall back to querying Python service directly (for backwards compatibility)
+	var pythonStatus pythonJobStatusResponse
+	url := fmt.Sprintf("/face/job-status/%s", jobID)
+
+	if err := s.callPythonServiceGet(url, &pythonStatus); err != nil {
+		// Check if it's a "not found" error
+		if strings.Contains(err.Error(), "not found") || strings.Contains(err.Error(), "session not found") {
+			return nil, ErrJobNotFound
+		}
+		return nil, err
+	}
+
+	response := &JobStatusResponse{
+		JobID:           pythonStatus.JobID,
+		Status:          parsePythonJobStatus(pythonStatus.Status),
+		Progress:        pythonStatus.Progress,
+		CurrentImage:    pythonStatus.CurrentImage,
+		TotalImages:     pythonStatus.TotalImages,
+		MatchesFound:    pythonStatus.MatchesFound,
+		ImagesWithFaces: pythonStatus.ImagesWithFaces,
+		Message:         pythonStatus.Message,
+		Error:           pythonStatus.Error,
+	}
+	response.NoMatches = response.Status == JobStatusCompleted && response.MatchesFound == 0
+
+	return response, nil
+}
+
+// encodedImage is a downloaded and preprocessed image ready to send, along with a hash of its original content
+type encodedImage struct {
+	data  []byte
+	hash  string
+	index int // Position of the image in the batch it was downloaded with
+
+	// perceptual is the image's difference hash, set when near-duplicate dedup is on and the image could be decoded
+	perceptual    uint64
+	hasPerceptual bool
+}
+
+// statusPoll tracks consecutive failed status polls of one Python job
+type statusPoll struct {
+	failures    int
+	nextAttempt time.Time
+}
+
+// statusPollBackoff doubles the poll interval for each consecutive failure, up to maxStatusPollBackoff
+func statusPollBackoff(interval time.Duration, failures int) time.Duration {
+	backoff := interval
+	for i := 1; i < failures && backoff < maxStatusPollBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, maxStatusPollBackoff)
+}
+
+// statusPollDelay is how long to wait before the next round of status polls
+// It starts at interval and doubles with each round that saw no progress, up to maxInterval,
+// then is spread by statusPollJitter using jitter, a random value in [0, 1)
+func statusPollDelay(interval, maxInterval time.Duration, idlePolls int, jitter float64) time.Duration {
+	delay := interval
+	for i := 0; i < idlePolls && delay < maxInterval; i++ {
+		delay *= 2
+	}
+	delay = max(interval, min(delay, maxInterval))
+
+	return time.Duration(float64(delay) * (1 + statusPollJitter*(2*jitter-1)))
+}
+
+// completionMessage summarizes a completed job, telling images without faces apart from faces that did not match
+// Without matches it suggests what to try next, as an empty result otherwise reads like an error
+func completionMessage(totalImages, imagesWithFaces, matchesFound int) string {
+	switch {
+	case matchesFound > 0:
+		return fmt.Sprintf("Completed! Scanned %d images, %d had faces, found %d matches", totalImages, imagesWithFaces, matchesFound)
+	case imagesWithFaces == 0:
+		return fmt.Sprintf("Completed! No faces found in any of the %d images. Check that this is the folder you meant and that its photos show people's faces", totalImages)
+	default:
+		return fmt.Sprintf("Completed! No matches among %d images, although %d had faces. "+
+			"Try a clearer, front-facing reference photo, check that this is the right folder, or re-run unmatched images with a less strict threshold",
+			totalImages, imagesWithFaces)
+	}
+}
+
+// buildDiagnostics maps a job's per-image errors back to the images they belong to, and reports how many
+// images were not compared themselves because they duplicate another
+func buildDiagnostics(ctx *jobContext) *JobDiagnostics {
+	diagnostics := &JobDiagnostics{
+		ImageErrors:           make([]ImageError, 0, len(ctx.imageErrors)),
+		DuplicatesSkipped:     ctx.exactDuplicates,
+		NearDuplicatesSkipped: ctx.nearDuplicates,
+	}
+
+	for _, imageErr := range ctx.imageErrors {
+		if imageErr.Index < 0 || imageErr.Index >= len(ctx.allImages) {
+			continue
+		}
+
+		diagnostics.ImageErrors = append(diagnostics.ImageErrors, ImageError{
+			Item:  ctx.allImages[imageErr.Index],
+			Error: imageErr.Error,
+		})
+	}
+
+	return diagnostics
+}
+
+// freshToken returns the session's current token when the job's token is about to expire, so a long job
+// keeps downloading after the token it started with runs out; it keeps the job's token otherwise
+func (s *Service) freshToken(sessionID string, token *models.Token) *models.Token {
+	if s.tokens == nil || token == nil || token.RefreshToken == "" || !token.ExpiresWithin(models.TokenRefreshWindow) {
+		return token
+	}
+
+	current, err := s.tokens.GetSessionToken(sessionID, token.Provider, token.AccountID)
+	if err != nil {
+		log.Printf("Failed to get a fresh %s token, continuing with the current one: %v", token.Provider, err)
+		return token
+	}
+	return current
+}
+
+// CancelJob stops a running comparison job: no further images are downloaded or sent, and its status
+// reports it cancelled until read once. Batches already sent are cancelled in the Python service too
+func (s *Service) CancelJob(sessionID, jobID string) error {
+	// Other sessions' jobs are reported as missing, so job IDs can't be probed
+	if ctx, exists := s.jobManager.Get(jobID); !exists || ctx.sessionID != sessionID {
+		return ErrJobNotFound
+	}
+
+	if err := s.jobManager.MarkCancelled(jobID); err != nil {
+		return err
+	}
+
+	log.Printf("Job %s: cancelled", jobID)
+	return nil
+}
+
+// SubscribeJob returns a channel signalled whenever the session's job changes status, and a function that
+// stops the signals; the status itself is read with GetJobStatus
+func (s *Service) SubscribeJob(sessionID, jobID string) (<-chan struct{}, func(), error) {
+	// Other sessions' jobs are reported as missing, as in CancelJob
+	if ctx, exists := s.jobManager.Get(jobID); !exists || ctx.sessionID != sessionID {
+		return nil, nil, ErrJobNotFound
+	}
+
+	changes, unsubscribe, ok := s.jobManager.Subscribe(jobID)
+	if !ok {
+		return nil, nil, ErrJobNotFound
+	}
+	return changes, unsubscribe, nil
+}
+
+// DeleteSessionJobs removes all of a session's jobs, cancelling the running ones, and clears the
+// session's reference face too when asked to, so nothing of its comparisons is left behind
+func (s *Service) DeleteSessionJobs(sessionID string, clearReference bool) (*DeleteJobsResponse, error) {
+	cancelled, deleted := s.jobManager.DeleteSessionJobs(sessionID)
+	log.Printf("Session %s: cancelled %d and deleted %d jobs", sessionID, cancelled, deleted)
+
+	response := &DeleteJobsResponse{Cancelled: cancelled, Deleted: deleted}
+	if clearReference {
+		if err := s.ClearReferenceImage(sessionID); err != nil {
+			return nil, err
+		}
+		response.ReferenceCleared = true
+	}
+	return response, nil
+}
+
+// downloadAndEncodeBatch downloads images in parallel using a worker pool and encodes them as base64
+// Images that fail to download or preprocess are left out and returned as failures, so unreadable files
+// do not fail the job, however many of the batch they are; the batch only fails when the provider refused a
+// download in a way every later one would be refused too. Once ctx is done, workers skip the images they have
+// not started and the batch fails with its error. Images extracted from documents are read from the job's documents
+func (s *Service) downloadAndEncodeBatch(ctx context.Context, items []*models.CloudItem, token *models.Token, preprocess PreprocessSteps, documents *documentCache) ([]encodedImage, []FailedImage, error) {
+	// Pre-allocate results slice to maintain order
+	results := make([]encodedImage, len(items))
+
+	// Channel for work items
+	type job struct {
+		index int
+		item  *models.CloudItem
+	}
+	jobs := make(chan job, len(items))
+
+	// Channel for errors
+	type result struct {
+		index   int
+		encoded encodedImage
+		err     error
+	}
+	resultsChan := make(chan result, len(items))
+
+	// Start worker pool
+	var wg sync.WaitGroup
+	for w := 0; w < s.downloadWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				if err := ctx.Err(); err != nil {
+					resultsChan <- result{index: j.index, err: err}
+					continue
+				}
+				encoded, err := s.downloadAndEncodeImage(ctx, j.item, token, preprocess, documents)
+				resultsChan <- result{
+					index:   j.index,
+					encoded: encoded,
+					err:     err,
+				}
+			}
+		}()
+	}
+
+	// Send jobs to workers
+	for i, item := range items {
+		jobs <- job{index: i, item: item}
+	}
+	close(jobs)
+
+	// Wait for all workers to finish
+	go func() {
+		wg.Wait()
+		close(resultsChan)
+	}()
+
+	// Collect results
+	failedAt := make(map[int]error)
+	for res := range resultsChan {
+		if res.err != nil {
+			failedAt[res.index] = res.err
+			continue
+		}
+		res.encoded.index = res.index
+		results[res.index] = res.encoded
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	// Keep the batch order, leaving out the images that failed
+	encoded := make([]encodedImage, 0, len(items)-len(failedAt))
+	var failed []FailedImage
+	for i, item := range items {
+		if err, ok := failedAt[i]; ok {
+			if failsEveryDownload(err) {
+				return nil, nil, err
+			}
+			log.Printf("Skipping image %s that could not be downloaded: %v", item.Name, err)
+			failed = append(failed, FailedImage{Name: item.Name, Reason: err.Error()})
+			continue
+		}
+		encoded = append(encoded, results[i])
+	}
+
+	return encoded, failed, nil
+}
+
+// downloadAndEncodeImage downloads a single image, hashes its content, preprocesses it and encodes it to base64
+func (s *Service) downloadAndEncodeImage(ctx context.Context, item *models.CloudItem, token *models.Token, preprocess PreprocessSteps, documents *documentCache) (encodedImage, error) {
+	imageData, err := s.downloadImage(ctx, item, token, documents)
+	if err != nil {
+		return encodedImage{}, err
+	}
+
+	// Hash the original content so identical photos are recognized regardless of preprocessing
+	hash := sha256.Sum256(imageData)
+
+	imageData, err = preprocessImage(imageData, s.withEnhancements(preprocess), s.preprocessMaxDimension, s.decodeGuard)
+	if err != nil {
+		return encodedImage{}, fmt.Errorf("failed to preprocess image %s: %w", item.Name, err)
+	}
+
+	encoded := encodedImage{
+		data: imageData,
+		hash: hex.EncodeToString(hash[:]),
+	}
+	// Hashed after preprocessing, which has usually downscaled the image and so makes decoding cheaper
+	if s.dedupNearDuplicates {
+		encoded.perceptual, encoded.hasPerceptual = perceptualHash(imageData, s.decodeGuard)
+	}
+
+	return encoded, nil
+}
+
+// downloadImage downloads a single image, or extracts it from its source document
+// Failed downloads are retried with exponential backoff, unless the provider's answer won't change on retry;
+// once ctx is done it stops waiting for the next attempt and fails with ctx's error
+func (s *Service) downloadImage(ctx context.Context, item *models.CloudItem, token *models.Token, documents *documentCache) ([]byte, error) {
+	if item.SourceDocument != nil {
+		return documents.image(s, item, token)
+	}
+
+	backoff := s.downloadRetryBackoff
+	for attempt := 1; ; attempt++ {
+		imageData, err := s.downloadImageOnce(item, token)
+		if err == nil || attempt == maxImageDownloadAttempts || !retryableDownloadError(err) {
+			return imageData, err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// failsEveryDownload reports whether a failed download means the job's other downloads fail too, as when
+// the provider no longer accepts the token or its usage limit was reached
+func failsEveryDownload(err error) bool {
+	if errors.Is(err, models.ErrTokenExpired) {
+		return true
+	}
+
+	providerErr, ok := models.AsProviderError(err)
+	return ok && (providerErr.Code == models.ProviderErrorAuthExpired || providerErr.Code == models.ProviderErrorQuota)
+}
+
+// retryableDownloadError reports whether a failed download may succeed when tried again
+// Missing files, denied access, expired tokens and exhausted quotas fail the same way every time
+func retryableDownloadError(err error) bool {
+	providerErr, ok := models.AsProviderError(err)
+	if !ok {
+		return true
+	}
+
+	switch providerErr.Code {
+	case models.ProviderErrorNotFound, models.ProviderErrorForbidden, models.ProviderErrorAuthExpired, models.ProviderErrorQuota:
+		return false
+	default:
+		return true
+	}
+}
+
+// downloadImageOnce makes a single attempt at downloading an image
+func (s *Service) downloadImageOnce(item *models.CloudItem, token *models.Token) ([]byte, error) {
+	// Use FaceRecognitionOptimizedURL if available, otherwise use DownloadURL
+	itemToDownload := item
+	if item.FaceRecognitionOptimizedURL != "" {
+		itemCopy := *item
+		itemCopy.DownloadURL = item.FaceRecognitionOptimizedURL
+		itemToDownload = &itemCopy
+	}
+
+	// Hold a shared worker slot until the image is fully read
+	s.workers.Acquire()
+	defer s.workers.Release()
+
+	stream, err := s.storageService.GetFaceRecognitionOptimizedStream(itemToDownload, token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download image %s: %w", item.Name, err)
+	}
+	defer stream.Close()
+
+	imageData, err := io.ReadAll(stream)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image %s: %w", item.Name, err)
+	}
+
+	return imageData, nil
+}
+
+// RerunUnmatched starts a new comparison job over the images a completed job did not match,
+// typically with a looser threshold. The new job references the original so results can be merged
+func (s *Service) RerunUnmatched(sessionID, priorJobID string, threshold float64) (string, error) {
+	if s.drain.On() {
+		return "", ErrNewJobsDisabled
+	}
+
+	ctx, exists := s.jobManager.Snapshot(priorJobID)
+	if !exists || ctx.sessionID != sessionID {
+		return "", ErrJobNotFound
+	}
+
+	if ctx.status != JobStatusCompleted {
+		return "", ErrJobNotCompleted
 	}
 
-	// Fall back to querying Python service directly (for backwards compatibility)
-	var pythonStatus pythonJobStatusResponse
-	url := fmt.Sprintf("/face/job-status/%s", jobID)
+	matched := make(map[int]bool, len(ctx.matches))
+	for _, match := range ctx.matches {
+		matched[match.Index] = true
+	}
 
-	if err := s.callPythonServiceGet(url, &pythonStatus); err != nil {
-		// Check if it's a "not found" error
-		if strings.Contains(err.Error(), "not found") || strings.Contains(err.Error(), "session not found") {
-			return nil, ErrJobNotFound
+	unmatched := make([]*models.CloudItem, 0, len(ctx.allImages)-len(matched))
+	for i, item := range ctx.allImages {
+		if !matched[i] {
+			unmatched = append(unmatched, item)
 		}
-		return nil, err
 	}
 
-	response := &JobStatusResponse{
-		JobID:        pythonStatus.JobID,
-		Status:       pythonStatus.Status,
-		Progress:     pythonStatus.Progress,
-		CurrentImage: pythonStatus.CurrentImage,
-		TotalImages:  pythonStatus.TotalImages,
-		MatchesFound: pythonStatus.MatchesFound,
-		Message:      pythonStatus.Message,
-		Error:        pythonStatus.Error,
+	if len(unmatched) == 0 {
+		return "", ErrNothingToRerun
 	}
 
-	return response, nil
-}
-
-// downloadAndEncodeBatch downloads images in parallel using a worker pool and encodes them as base64
-func (s *Service) downloadAndEncodeBatch(items []*models.CloudItem, token *models.Token) ([]string, error) {
-	const numWorkers = 10
+	// The session may have registered a base face with another model since the original job ran
+	if err := s.sessionModels.check(sessionID, ctx.options.model); err != nil {
+		return "", err
+	}
 
-	// Pre-allocate results slice to maintain order
-	results := make([]string, len(items))
+	return s.processFolderInBatches(sessionID, unmatched, ctx.token, compareOptions{
+		threshold:   threshold,
+		rerunOf:     priorJobID,
+		preprocess:  ctx.options.preprocess,
+		aggregation: ctx.options.aggregation,
+		model:       ctx.options.model,
+	})
+}
 
-	// Channel for work items
-	type job struct {
-		index int
-		item  *models.CloudItem
+// SaveResult stores a manifest of a completed job's matches under a short token
+// Only the session that ran the job can save it or retrieve it later
+func (s *Service) SaveResult(sessionID, jobID string) (*ResultManifest, error) {
+	ctx, exists := s.jobManager.Snapshot(jobID)
+	if !exists || ctx.sessionID != sessionID {
+		return nil, ErrJobNotFound
 	}
-	jobs := make(chan job, len(items))
 
-	// Channel for errors
-	type result struct {
-		index   int
-		encoded string
-		err     error
+	if ctx.status != JobStatusCompleted {
+		return nil, ErrJobNotCompleted
 	}
-	resultsChan := make(chan result, len(items))
 
-	// Start worker pool
-	var wg sync.WaitGroup
-	for w := 0; w < numWorkers; w++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for j := range jobs {
-				encoded, err := s.downloadAndEncodeImage(j.item, token)
-				resultsChan <- result{
-					index:   j.index,
-					encoded: encoded,
-					err:     err,
-				}
-			}
-		}()
+	token, err := generateResultToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate result token: %w", err)
 	}
 
-	// Send jobs to workers
-	for i, item := range items {
-		jobs <- job{index: i, item: item}
+	now := s.now()
+	manifest := &ResultManifest{
+		Token:      token,
+		SessionID:  sessionID,
+		JobID:      jobID,
+		Provider:   ctx.token.Provider,
+		AccountID:  ctx.token.AccountID,
+		FolderLink: ctx.folderLink,
+		Matches:    make([]ManifestMatch, 0, len(ctx.matches)),
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(s.resultTTL),
+	}
+	if ctx.folder != nil {
+		manifest.FolderName = ctx.folder.Name
 	}
-	close(jobs)
-
-	// Wait for all workers to finish
-	go func() {
-		wg.Wait()
-		close(resultsChan)
-	}()
 
-	// Collect results
-	var firstErr error
-	for res := range resultsChan {
-		if res.err != nil && firstErr == nil {
-			firstErr = res.err
+	// Images extracted from a document are saved as the document, once
+	saved := make(map[string]bool, len(ctx.matches))
+	for _, match := range ctx.matches {
+		if match.Index < 0 || match.Index >= len(ctx.allImages) {
+			continue
 		}
-		if res.err == nil {
-			results[res.index] = res.encoded
+
+		item := ctx.allImages[match.Index].Stored()
+		if saved[item.ID] {
+			continue
 		}
+		saved[item.ID] = true
+
+		manifest.Matches = append(manifest.Matches, ManifestMatch{
+			ItemID:   item.ID,
+			DriveID:  item.DriveID,
+			Name:     item.Name,
+			Distance: match.Distance,
+		})
 	}
 
-	if firstErr != nil {
-		return nil, firstErr
+	if err := s.resultStore.SaveResult(manifest); err != nil {
+		return nil, fmt.Errorf("failed to save result: %w", err)
 	}
 
-	return results, nil
+	return manifest, nil
 }
 
-// downloadAndEncodeImage downloads a single image and encodes it to base64
-func (s *Service) downloadAndEncodeImage(item *models.CloudItem, token *models.Token) (string, error) {
-	// Use FaceRecognitionOptimizedURL if available, otherwise use DownloadURL
-	itemToDownload := item
-	if item.FaceRecognitionOptimizedURL != "" {
-		itemCopy := *item
-		itemCopy.DownloadURL = item.FaceRecognitionOptimizedURL
-		itemToDownload = &itemCopy
+// GetResultManifest returns a saved result manifest if it belongs to the session
+func (s *Service) GetResultManifest(sessionID, resultToken string) (*ResultManifest, error) {
+	manifest, err := s.resultStore.GetResult(resultToken)
+	if err != nil {
+		return nil, err
 	}
 
-	stream, err := s.storageService.GetFaceRecognitionOptimizedStream(itemToDownload, token)
-	if err != nil {
-		return "", fmt.Errorf("failed to download image %s: %w", item.Name, err)
+	// Other sessions get the same error as for unknown tokens so tokens can't be probed
+	if manifest.SessionID != sessionID {
+		return nil, ErrResultNotFound
 	}
-	defer stream.Close()
 
-	imageData, err := io.ReadAll(stream)
-	if err != nil {
-		return "", fmt.Errorf("failed to read image %s: %w", item.Name, err)
+	return manifest, nil
+}
+
+// ResolveResult re-resolves a manifest's matches from the provider so their URLs are fresh
+// Items that can no longer be resolved (e.g. deleted or unshared) are listed as unavailable
+func (s *Service) ResolveResult(manifest *ResultManifest, token *models.Token) *ResultResponse {
+	response := &ResultResponse{
+		Token:       manifest.Token,
+		JobID:       manifest.JobID,
+		FolderLink:  manifest.FolderLink,
+		FolderName:  manifest.FolderName,
+		CreatedAt:   manifest.CreatedAt,
+		Matches:     make([]*models.CloudItem, 0, len(manifest.Matches)),
+		Unavailable: make([]string, 0),
+	}
+
+	for _, match := range manifest.Matches {
+		item, err := s.storageService.GetItem(&models.CloudItem{
+			ID:       match.ItemID,
+			DriveID:  match.DriveID,
+			Name:     match.Name,
+			Provider: manifest.Provider,
+		}, token)
+		if err != nil {
+			response.Unavailable = append(response.Unavailable, match.Name)
+			continue
+		}
+
+		distance := match.Distance
+		item.MatchDistance = &distance
+		response.Matches = append(response.Matches, item)
 	}
 
-	return base64.StdEncoding.EncodeToString(imageData), nil
+	return response
 }
 
 // processFolderInBatches processes images in batches of 100 and creates a unified job
-func (s *Service) processFolderInBatches(sessionID string, allImages []*models.CloudItem, token *models.Token) (string, error) {
+func (s *Service) processFolderInBatches(sessionID string, allImages []*models.CloudItem, token *models.Token, opts compareOptions) (string, error) {
 	// Create a unified job ID for the client
-	unifiedJobID := fmt.Sprintf("batch-%d-%s", time.Now().Unix(), sessionID)
+	unifiedJobID := fmt.Sprintf("batch-%d-%s", time.Now().UnixNano(), sessionID)
 
 	// Store the job context
-	s.jobManager.Store(unifiedJobID, allImages, token)
+	ctx := s.jobManager.Store(unifiedJobID, sessionID, allImages, token, opts)
 
-	// Process batches in the background
-	go s.processBatchesBackground(unifiedJobID, sessionID, allImages, token)
+	// Process batches in the background, until done or cancelled
+	go s.processBatchesBackground(ctx, unifiedJobID, sessionID, allImages, token, opts)
 
 	return unifiedJobID, nil
 }
 
 // processBatchesBackground downloads and processes all image batches
-func (s *Service) processBatchesBackground(unifiedJobID, sessionID string, allImages []*models.CloudItem, token *models.Token) {
+// Byte-identical images (e.g. the same photo shared in several folders) are sent to Python only once,
+// as are near duplicates when enabled; either is reported with the result of the image it duplicates
+// It returns early once ctx is done, leaving the job's status to whoever cancelled it
+func (s *Service) processBatchesBackground(ctx context.Context, unifiedJobID, sessionID string, allImages []*models.CloudItem, token *models.Token, opts compareOptions) {
+	defer s.clearReferenceAfterJob(unifiedJobID, sessionID)
+
+	// Re-runs and drive comparisons extract document images again when they have any
+	documents := opts.documents
+	if documents == nil {
+		documents = newDocumentCache()
+	}
+	defer documents.close()
+
 	const batchSize = 100
 	totalImages := len(allImages)
 
 	// Split images into batches and send each to Python service
 	var pythonJobIDs []string
-	var batchOffsets []int // Track the starting index of each batch
+	var running []string     // Python jobs that have not finished yet, at most maxConcurrentBatches
+	var batchIndices [][]int // Global image index of each image in each Python job
+
+	firstByHash := make(map[string]int) // content hash -> global index of the first image with it
+	duplicates := make(map[int][]int)   // global index of a first image -> indices of its duplicates
+	var nearDuplicates nearDuplicateIndex
+	var exactCount, nearCount int
+
+	var downloaded int      // Images of the job that could be downloaded
+	var firstFailure string // Why the first image that could not be downloaded failed
 
 	for i := 0; i < totalImages; i += batchSize {
 		end := i + batchSize
@@ -298,37 +1317,221 @@ func (s *Service) processBatchesBackground(unifiedJobID, sessionID string, allIm
 
 		batch := allImages[i:end]
 
-		// Download and encode this batch
-		encodedImages, err := s.downloadAndEncodeBatch(batch, token)
+		// Wait until the batch fits in the memory shared by all jobs, then download and encode it
+		reserved := s.memoryBudget.acquire(int64(len(batch)) * imageReservationBytes)
+		if ctx.Err() != nil {
+			s.memoryBudget.release(reserved)
+			log.Printf("Job %s: stopped after %d of %d images as it was cancelled", unifiedJobID, i, totalImages)
+			s.cancelPythonJobs(pythonJobIDs)
+			return
+		}
+		token = s.freshToken(sessionID, token)
+		encodedImages, failedImages, err := s.downloadAndEncodeBatch(ctx, batch, token, opts.preprocess, documents)
 		if err != nil {
-			// Mark job as failed
+			s.memoryBudget.release(reserved)
+			if ctx.Err() != nil {
+				log.Printf("Job %s: stopped after %d of %d images as it was cancelled", unifiedJobID, i, totalImages)
+				s.cancelPythonJobs(pythonJobIDs)
+				return
+			}
+			// Batches already sent are of no use once the job fails
+			s.cancelPythonJobs(pythonJobIDs)
 			s.jobManager.MarkFailed(unifiedJobID, fmt.Sprintf("Failed to download batch: %v", err))
 			return
 		}
+		s.jobManager.RecordFailedImages(unifiedJobID, failedImages)
+		downloaded += len(encodedImages)
+		if firstFailure == "" && len(failedImages) > 0 {
+			firstFailure = failedImages[0].Reason
+		}
+		// Hold what the batch actually buffers rather than the estimate until it is sent
+		reserved = s.memoryBudget.resize(reserved, encodedSize(encodedImages))
+
+		// Drop images already seen in this job, remembering which item they duplicate
+		var uniqueImages [][]byte
+		var uniqueIndices []int
+		for _, image := range encodedImages {
+			index := i + image.index
+			if first, seen := firstByHash[image.hash]; seen {
+				duplicates[first] = append(duplicates[first], index)
+				exactCount++
+				continue
+			}
+
+			if image.hasPerceptual {
+				if first, found := nearDuplicates.find(image.perceptual); found {
+					// Later identical copies of this image are then exact duplicates of the same first image
+					firstByHash[image.hash] = first
+					duplicates[first] = append(duplicates[first], index)
+					nearCount++
+					continue
+				}
+				nearDuplicates.add(image.perceptual, index)
+			}
+
+			firstByHash[image.hash] = index
+			uniqueImages = append(uniqueImages, image.data)
+			uniqueIndices = append(uniqueIndices, index)
+		}
 
-		// Send batch to Python service
-		pythonJobID, err := s.startPythonCompareBatch(sessionID, encodedImages)
-		if err != nil {
-			s.jobManager.MarkFailed(unifiedJobID, fmt.Sprintf("Failed to start Python job: %v", err))
-			return
+		// Send batch to Python service, split into smaller requests if the payload is too large
+		offset := 0
+		for _, subBatch := range splitByPayloadSize(uniqueImages, s.maxBatchPayloadBytes, s.batchTransport) {
+			running, err = s.waitForBatchSlot(ctx, running)
+			if err != nil {
+				s.memoryBudget.release(reserved)
+				log.Printf("Job %s: stopped after %d of %d images as it was cancelled", unifiedJobID, i, totalImages)
+				s.cancelPythonJobs(pythonJobIDs)
+				return
+			}
+
+			pythonJobID, err := s.startPythonCompareBatch(sessionID, subBatch, opts)
+			if err != nil {
+				s.memoryBudget.release(reserved)
+				s.cancelPythonJobs(pythonJobIDs)
+				s.jobManager.MarkFailed(unifiedJobID, fmt.Sprintf("Failed to start Python job: %v", err))
+				return
+			}
+
+			pythonJobIDs = append(pythonJobIDs, pythonJobID)
+			running = append(running, pythonJobID)
+			batchIndices = append(batchIndices, uniqueIndices[offset:offset+len(subBatch)])
+			offset += len(subBatch)
 		}
+		s.memoryBudget.release(reserved)
+	}
+
+	// Unreadable files are skipped, unless not one image of the job could be read
+	if downloaded == 0 && firstFailure != "" {
+		s.jobManager.MarkFailed(unifiedJobID, fmt.Sprintf("Failed to download any image: %s", firstFailure))
+		return
+	}
 
-		pythonJobIDs = append(pythonJobIDs, pythonJobID)
-		batchOffsets = append(batchOffsets, i)
+	if err := validateBatchIndices(pythonJobIDs, batchIndices, totalImages); err != nil {
+		s.cancelPythonJobs(pythonJobIDs)
+		s.jobManager.MarkFailed(unifiedJobID, fmt.Sprintf("Invalid batch layout: %v", err))
+		return
 	}
 
+	s.jobManager.RecordDuplicates(unifiedJobID, exactCount, nearCount)
+
 	// Poll all Python jobs and aggregate results
-	s.aggregateBatchResults(unifiedJobID, pythonJobIDs, batchOffsets, totalImages)
+	s.aggregateBatchResults(ctx, unifiedJobID, pythonJobIDs, batchIndices, duplicates, totalImages, opts.threshold)
 }
 
-// startPythonCompareBatch sends a batch of images to Python service for async comparison
-func (s *Service) startPythonCompareBatch(sessionID string, encodedImages []string) (string, error) {
-	payload := pythonCompareBatchRequest{
-		SessionID: sessionID,
-		Images:    encodedImages,
+// waitForBatchSlot waits until fewer than maxConcurrentBatches of the job's Python jobs are running,
+// returning the ones still running; it fails only once ctx is done
+// A Python job whose status can't be read counts as running, aggregateBatchResults deals with its failures
+func (s *Service) waitForBatchSlot(ctx context.Context, running []string) ([]string, error) {
+	for len(running) >= s.maxConcurrentBatches {
+		select {
+		case <-ctx.Done():
+			return running, ctx.Err()
+		case <-time.After(s.statusPollInterval):
+		}
+
+		stillRunning := running[:0]
+		for _, pythonJobID := range running {
+			var status pythonJobStatusResponse
+			err := s.callPythonServiceGet(fmt.Sprintf("/face/job-status/%s", pythonJobID), &status)
+			if err != nil || parsePythonJobStatus(status.Status) == JobStatusProcessing {
+				stillRunning = append(stillRunning, pythonJobID)
+			}
+		}
+		running = stillRunning
+	}
+
+	return running, nil
+}
+
+// validateBatchIndices checks that every Python job has an index mapping and that the mappings
+// only reference images of the job, each at most once
+func validateBatchIndices(pythonJobIDs []string, batchIndices [][]int, totalImages int) error {
+	if len(batchIndices) != len(pythonJobIDs) {
+		return fmt.Errorf("%d index mappings for %d Python jobs", len(batchIndices), len(pythonJobIDs))
+	}
+
+	seen := make(map[int]bool)
+	for batch, indices := range batchIndices {
+		for _, index := range indices {
+			if index < 0 || index >= totalImages {
+				return fmt.Errorf("batch %d maps to index %d outside the %d images of the job", batch, index, totalImages)
+			}
+			if seen[index] {
+				return fmt.Errorf("batch %d maps to index %d which is already sent in another batch", batch, index)
+			}
+			seen[index] = true
+		}
+	}
+
+	return nil
+}
+
+// recordOutOfRangeIndex logs and counts an index that does not map to an image
+func (s *Service) recordOutOfRangeIndex(jobID, kind string, index, bound int) {
+	s.outOfRangeIndices.Add(1)
+	log.Printf("job %s: dropping %s with out-of-range index %d (valid range 0-%d)", jobID, kind, index, bound-1)
+}
+
+// OutOfRangeIndices returns how many match or error indices were dropped because they did not map to an image
+func (s *Service) OutOfRangeIndices() int64 {
+	return s.outOfRangeIndices.Load()
+}
+
+// splitByPayloadSize splits images into consecutive sub-batches whose payload with the given transport
+// stays under maxBytes
+// An image larger than maxBytes on its own is still sent, alone in its sub-batch
+func splitByPayloadSize(images [][]byte, maxBytes int, transport BatchTransport) [][][]byte {
+	var subBatches [][][]byte
+	start := 0
+	size := 0
+
+	for i, image := range images {
+		imageSize := transport.payloadSize(image)
+		if i > start && size+imageSize > maxBytes {
+			subBatches = append(subBatches, images[start:i])
+			start = i
+			size = 0
+		}
+		size += imageSize
+	}
+
+	if start < len(images) {
+		subBatches = append(subBatches, images[start:])
 	}
 
+	return subBatches
+}
+
+// startPythonCompareBatch sends a batch of images to Python service for async comparison,
+// using the configured transport
+func (s *Service) startPythonCompareBatch(sessionID string, images [][]byte, opts compareOptions) (string, error) {
 	var result pythonCompareBatchResponse
+
+	if s.batchTransport == TransportMultipart {
+		body, contentType, err := multipartBatchBody(sessionID, images, opts)
+		if err != nil {
+			return "", fmt.Errorf("failed to build request: %w", err)
+		}
+		if err := s.postToPythonService("/face/compare-batch-multipart", contentType, body, &result); err != nil {
+			return "", err
+		}
+		return result.JobID, nil
+	}
+
+	encodedImages := make([]string, len(images))
+	for i, image := range images {
+		encodedImages[i] = base64.StdEncoding.EncodeToString(image)
+	}
+
+	payload := pythonCompareBatchRequest{
+		SessionID:   sessionID,
+		Images:      encodedImages,
+		Threshold:   opts.threshold,
+		Aggregation: opts.aggregation,
+		Model:       opts.model,
+	}
+
 	if err := s.callPythonServicePost("/face/compare-batch", payload, &result); err != nil {
 		return "", err
 	}
@@ -337,52 +1540,88 @@ func (s *Service) startPythonCompareBatch(sessionID string, encodedImages []stri
 }
 
 // aggregateBatchResults polls Python jobs and combines their results
-func (s *Service) aggregateBatchResults(unifiedJobID string, pythonJobIDs []string, batchOffsets []int, totalImages int) {
+// Matches are mapped back to global image indices, and to every duplicate of a matched image unless collapsed
+// A non-zero maxDistance drops matches further than it, in case the Python service did not apply it
+// Polling stops once ctx is done
+func (s *Service) aggregateBatchResults(ctx context.Context, unifiedJobID string, pythonJobIDs []string, batchIndices [][]int, duplicates map[int][]int, totalImages int, maxDistance float64) {
 	// Track completion of all Python jobs
 	completedJobs := make(map[string]*pythonJobStatusResponse)
+	completedMatches := make(map[string][]pythonMatchResult) // Matches of each completed job, at global indices
+	latestStatus := make(map[string]*pythonJobStatusResponse)
+	polls := make(map[string]*statusPoll)
+	for _, pythonJobID := range pythonJobIDs {
+		polls[pythonJobID] = &statusPoll{}
+	}
+
+	// Poll until all jobs complete or one fails, slowing down while nothing changes
+	timer := time.NewTimer(statusPollDelay(s.statusPollInterval, s.statusPollMaxInterval, 0, mathrand.Float64()))
+	defer timer.Stop()
 
-	// Poll until all jobs complete or one fails
-	ticker := time.NewTicker(500 * time.Millisecond)
-	defer ticker.Stop()
+	var idlePolls, lastProcessed, lastCompleted int
 
-	timeout := time.After(60 * time.Minute)
+	// Checked on every poll against the service clock rather than a timer, so tests can drive it
+	deadline := s.now().Add(processingTimeout)
 
 	for {
 		select {
-		case <-timeout:
-			s.jobManager.MarkFailed(unifiedJobID, "Processing timeout")
+		case <-ctx.Done():
+			log.Printf("Job %s: stopped polling as it was cancelled", unifiedJobID)
+			s.cancelPythonJobs(pythonJobIDs)
 			return
-		case <-ticker.C:
-			allComplete := true
+		case <-timer.C:
+			now := s.now()
+			if !now.Before(deadline) {
+				s.jobManager.MarkFailed(unifiedJobID, "Processing timeout")
+				return
+			}
+
 			var totalProcessed int
 			var totalMatches int
 			var failedJob string
 
 			// Check status of each Python job
-			for _, pythonJobID := range pythonJobIDs {
+			for idx, pythonJobID := range pythonJobIDs {
 				if _, exists := completedJobs[pythonJobID]; exists {
 					continue
 				}
 
+				poll := polls[pythonJobID]
+				if now.Before(poll.nextAttempt) {
+					continue
+				}
+
 				var status pythonJobStatusResponse
 				url := fmt.Sprintf("/face/job-status/%s", pythonJobID)
 				if err := s.callPythonServiceGet(url, &status); err != nil {
-					s.jobManager.MarkFailed(unifiedJobID, fmt.Sprintf("Failed to get job status: %v", err))
-					return
+					poll.failures++
+					if poll.failures >= s.statusPollMaxFailures {
+						s.jobManager.MarkFailed(unifiedJobID, fmt.Sprintf("Failed to get job status: %v", err))
+						return
+					}
+
+					log.Printf("Job %s: status poll %d of %d for %s failed, retrying: %v", unifiedJobID, poll.failures, s.statusPollMaxFailures, pythonJobID, err)
+					poll.nextAttempt = now.Add(statusPollBackoff(s.statusPollInterval, poll.failures))
+					continue
 				}
+				poll.failures = 0
 
-				if status.Status == "failed" || status.Status == "error" {
+				pythonStatus := parsePythonJobStatus(status.Status)
+				if pythonStatus == JobStatusFailed {
 					failedJob = status.Error
 					break
 				}
 
-				if status.Status == "completed" {
+				if pythonStatus == JobStatusCompleted {
 					completedJobs[pythonJobID] = &status
-				} else {
-					allComplete = false
+					// Matches show up while later batches are still running
+					completedMatches[pythonJobID] = s.batchMatches(unifiedJobID, pythonJobID, &status, batchIndices[idx], duplicates, maxDistance)
+					s.jobManager.AppendMatches(unifiedJobID, completedMatches[pythonJobID])
 				}
+				latestStatus[pythonJobID] = &status
+			}
 
-				// Update progress - add current batch progress
+			// Update progress from the latest known status of every batch
+			for _, status := range latestStatus {
 				totalProcessed += status.CurrentImage
 				totalMatches += status.MatchesFound
 			}
@@ -395,28 +1634,112 @@ func (s *Service) aggregateBatchResults(unifiedJobID string, pythonJobIDs []stri
 				return
 			}
 
-			if allComplete {
-				// Aggregate all matches with adjusted indices
+			if len(completedJobs) == len(pythonJobIDs) {
+				// Aggregate all matches with adjusted indices, replacing the partial ones appended so far
 				var allMatches []pythonMatchResult
+				for _, pythonJobID := range pythonJobIDs {
+					allMatches = append(allMatches, completedMatches[pythonJobID]...)
+				}
+
+				slices.SortFunc(allMatches, func(a, b pythonMatchResult) int {
+					return a.Index - b.Index
+				})
+
+				// Collect images the Python service skipped, including every duplicate of them
+				var imageErrors []pythonImageError
+				for idx, pythonJobID := range pythonJobIDs {
+					indices := batchIndices[idx]
+					for _, imageErr := range completedJobs[pythonJobID].ImageErrors {
+						if imageErr.Index < 0 || imageErr.Index >= len(indices) {
+							s.recordOutOfRangeIndex(unifiedJobID, "image error from "+pythonJobID, imageErr.Index, len(indices))
+							continue
+						}
+
+						globalIndex := indices[imageErr.Index]
+						for _, index := range append([]int{globalIndex}, duplicates[globalIndex]...) {
+							imageErrors = append(imageErrors, pythonImageError{
+								Index: index,
+								Error: imageErr.Error,
+							})
+						}
+					}
+				}
+
+				slices.SortFunc(imageErrors, func(a, b pythonImageError) int {
+					return a.Index - b.Index
+				})
+
+				s.jobManager.RecordImageErrors(unifiedJobID, imageErrors)
+
+				// Count images with faces, including every duplicate of them, as they are part of the scanned total
+				var imagesWithFaces int
 				for idx, pythonJobID := range pythonJobIDs {
-					jobResult := completedJobs[pythonJobID]
-					offset := batchOffsets[idx]
-
-					// Adjust match indices to global positions
-					for _, match := range jobResult.Matches {
-						adjustedMatch := pythonMatchResult{
-							Index:    match.Index + offset,
-							Distance: match.Distance,
+					indices := batchIndices[idx]
+					for _, faceIndex := range completedJobs[pythonJobID].FaceIndices {
+						if faceIndex < 0 || faceIndex >= len(indices) {
+							s.recordOutOfRangeIndex(unifiedJobID, "face index from "+pythonJobID, faceIndex, len(indices))
+							continue
 						}
-						allMatches = append(allMatches, adjustedMatch)
+						imagesWithFaces += 1 + len(duplicates[indices[faceIndex]])
 					}
 				}
 
+				s.jobManager.RecordImagesWithFaces(unifiedJobID, imagesWithFaces)
+
+				// Recorded first, so an estimate made once the job shows as completed already includes it
+				if elapsed, exists := s.jobManager.Elapsed(unifiedJobID); exists {
+					s.throughput.record(totalImages, elapsed)
+				}
 				s.jobManager.MarkCompleted(unifiedJobID, allMatches)
 				return
 			}
+
+			// Progress, including a batch finishing, brings polling back to full speed
+			if totalProcessed != lastProcessed || len(completedJobs) != lastCompleted {
+				idlePolls = 0
+			} else {
+				idlePolls++
+			}
+			lastProcessed, lastCompleted = totalProcessed, len(completedJobs)
+
+			timer.Reset(statusPollDelay(s.statusPollInterval, s.statusPollMaxInterval, idlePolls, mathrand.Float64()))
+		}
+	}
+}
+
+// batchMatches maps the matches of a completed Python job to global image indices, leaving out
+// matches beyond maxDistance and adding the duplicates of each matched image unless they are collapsed
+func (s *Service) batchMatches(unifiedJobID, pythonJobID string, jobResult *pythonJobStatusResponse, indices []int, duplicates map[int][]int, maxDistance float64) []pythonMatchResult {
+	var matches []pythonMatchResult
+	for _, match := range jobResult.Matches {
+		if match.Index < 0 || match.Index >= len(indices) {
+			s.recordOutOfRangeIndex(unifiedJobID, "match from "+pythonJobID, match.Index, len(indices))
+			continue
+		}
+		if maxDistance > 0 && match.Distance > maxDistance {
+			continue
+		}
+
+		globalIndex := indices[match.Index]
+		matches = append(matches, pythonMatchResult{
+			Index:      globalIndex,
+			Distance:   match.Distance,
+			Confidence: match.Confidence,
+			Reference:  match.Reference,
+		})
+
+		if !s.collapseDuplicates {
+			for _, duplicateIndex := range duplicates[globalIndex] {
+				matches = append(matches, pythonMatchResult{
+					Index:      duplicateIndex,
+					Distance:   match.Distance,
+					Confidence: match.Confidence,
+					Reference:  match.Reference,
+				})
+			}
 		}
 	}
+	return matches
 }
 
 // callPythonServicePost is a generic helper for making HTTP POST calls to the Python service
@@ -426,16 +1749,46 @@ func (s *Service) callPythonServicePost(endpoint string, payload any, result any
 		return fmt.Errorf("failed to marshal request: %w", err)
 	}
 
+	return s.postToPythonService(endpoint, "application/json", bytes.NewReader(jsonData), result)
+}
+
+// registerRequestBody streams a pythonRegisterRequest with the image base64-encoded on the fly
+// Closing the body stops the encoding, which the HTTP client does when the request fails
+func registerRequestBody(sessionID string, model FaceModel, appendReference bool, image io.Reader) io.ReadCloser {
+	reader, writer := io.Pipe()
+
+	go func() {
+		sessionJSON, err := json.Marshal(sessionID)
+		if err == nil {
+			_, err = fmt.Fprintf(writer, `{"session_id":%s,"model":%q,"append":%t,"image":"`, sessionJSON, model, appendReference)
+		}
+		if err == nil {
+			encoder := base64.NewEncoder(base64.StdEncoding, writer)
+			if _, err = io.Copy(encoder, image); err == nil {
+				err = encoder.Close()
+			}
+		}
+		if err == nil {
+			_, err = io.WriteString(writer, `"}`)
+		}
+		writer.CloseWithError(err)
+	}()
+
+	return reader
+}
+
+// postToPythonService POSTs a body to the Python service and decodes the JSON response into result
+func (s *Service) postToPythonService(endpoint, contentType string, body io.Reader, result any) error {
 	url := s.pythonServiceURL + endpoint
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
 	defer cancel()
 
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, body)
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
-	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Type", contentType)
 
 	resp, err := s.httpClient.Do(req)
 	if err != nil {
@@ -443,16 +1796,16 @@ func (s *Service) callPythonServicePost(endpoint string, payload any, result any
 	}
 	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return fmt.Errorf("failed to read response from face comparison service")
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return handlePythonServiceError(resp.StatusCode, body, result)
+		return handlePythonServiceError(resp.StatusCode, respBody, result)
 	}
 
-	if err := json.Unmarshal(body, result); err != nil {
+	if err := json.Unmarshal(respBody, result); err != nil {
 		return fmt.Errorf("failed to parse response from face comparison service")
 	}
 
@@ -493,6 +1846,134 @@ func (s *Service) callPythonServiceGet(endpoint string, result any) error {
 	return nil
 }
 
+// SaveReferenceFace keeps the session's reference face encodings for the token's account,
+// so the account can use them again in later sessions without uploading a new image
+// Saving replaces anything the account saved before
+func (s *Service) SaveReferenceFace(sessionID string, token *models.Token) (*SavedReference, error) {
+	if s.referenceStore == nil {
+		return nil, ErrSavingDisabled
+	}
+
+	accountID, err := s.storageService.GetAccountID(token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to identify account: %w", err)
+	}
+
+	var result pythonSessionEncodings
+	if err := s.callPythonServiceGet(fmt.Sprintf("/face/session/%s/encodings", url.PathEscape(sessionID)), &result); err != nil {
+		if errors.Is(err, ErrSessionNotFound) {
+			return nil, ErrNoBaseFace
+		}
+		return nil, err
+	}
+
+	// Sessions the backend has no record of were registered before a restart, with the default model
+	model := FaceModelSmall
+	if recorded, exists := s.sessionModels.get(sessionID); exists {
+		model = recorded
+	}
+
+	reference := &SavedReference{
+		AccountID: accountID,
+		Encodings: result.Encodings,
+		Model:     model,
+		SavedAt:   s.now(),
+	}
+	if err := s.referenceStore.SaveReference(reference); err != nil {
+		return nil, fmt.Errorf("failed to save reference face: %w", err)
+	}
+
+	return reference, nil
+}
+
+// UseSavedReferenceFace registers the token's account's saved reference face for the session,
+// replacing any reference images the session had, and returns how many reference images it now has
+func (s *Service) UseSavedReferenceFace(sessionID string, token *models.Token) (int, error) {
+	if s.referenceStore == nil {
+		return 0, ErrSavingDisabled
+	}
+
+	accountID, err := s.storageService.GetAccountID(token)
+	if err != nil {
+		return 0, fmt.Errorf("failed to identify account: %w", err)
+	}
+
+	reference, err := s.referenceStore.GetReference(accountID)
+	if err != nil {
+		return 0, err
+	}
+
+	payload := pythonSessionEncodings{Encodings: reference.Encodings}
+	var result pythonRegisterResponse
+	if err := s.callPythonServicePost(fmt.Sprintf("/face/session/%s/encodings", url.PathEscape(sessionID)), payload, &result); err != nil {
+		return 0, err
+	}
+
+	model := reference.Model
+	if model == "" {
+		model = FaceModelSmall
+	}
+	s.sessionModels.set(sessionID, model)
+
+	return result.ReferenceCount, nil
+}
+
+// DeleteSavedReferenceFace forgets the reference face saved for the token's account
+// Sessions already using it keep their reference images until they are cleared or expire
+func (s *Service) DeleteSavedReferenceFace(token *models.Token) error {
+	if s.referenceStore == nil {
+		return ErrSavingDisabled
+	}
+
+	accountID, err := s.storageService.GetAccountID(token)
+	if err != nil {
+		return fmt.Errorf("failed to identify account: %w", err)
+	}
+
+	return s.referenceStore.DeleteReference(accountID)
+}
+
+// clearReferenceAfterJob clears the session's reference face once a job has finished, when configured to
+// It is kept while another job of the session still runs, and cleared when the last one finishes
+func (s *Service) clearReferenceAfterJob(jobID, sessionID string) {
+	if !s.autoClearReference {
+		return
+	}
+
+	if s.jobManager.HasRunningJobs(sessionID) {
+		log.Printf("Job %s: keeping the session's reference face for its other running jobs", jobID)
+		return
+	}
+
+	if err := s.ClearReferenceImage(sessionID); err != nil {
+		log.Printf("Job %s: failed to clear the session's reference face: %v", jobID, err)
+		return
+	}
+	log.Printf("Job %s: cleared the session's reference face after the job finished", jobID)
+}
+
+// cancelPythonJobs asks the face service to stop and forget the batches of a cancelled job
+// It is best effort: a batch that can't be cancelled finishes on its own and is never polled again
+func (s *Service) cancelPythonJobs(pythonJobIDs []string) {
+	for _, pythonJobID := range pythonJobIDs {
+		url := fmt.Sprintf("%s/face/job/%s", s.pythonServiceURL, pythonJobID)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		req, err := http.NewRequestWithContext(ctx, "DELETE", url, nil)
+		if err == nil {
+			var resp *http.Response
+			if resp, err = s.httpClient.Do(req); err == nil {
+				resp.Body.Close()
+			}
+		}
+		cancel()
+
+		if err != nil {
+			log.Printf("Failed to cancel face service job %s: %v", pythonJobID, err)
+		}
+	}
+}
+
 // ClearReferenceImage clears the reference face image for a session
 func (s *Service) ClearReferenceImage(sessionID string) error {
 	url := fmt.Sprintf("%s/face/session/%s", s.pythonServiceURL, sessionID)
@@ -515,11 +1996,19 @@ func (s *Service) ClearReferenceImage(sessionID string) error {
 		return fmt.Errorf("failed to clear reference image")
 	}
 
+	s.sessionModels.clear(sessionID)
+
 	return nil
 }
 
 // handleNetworkError provides user-friendly error messages for network errors
 func handleNetworkError(err error, url string) error {
+	// Failing to connect, even by timing out, means the service is down rather than slow
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return ErrServiceUnavailable
+	}
+
 	var netErr net.Error
 	if errors.As(err, &netErr) && netErr.Timeout() {
 		return ErrTimeout
@@ -562,6 +2051,9 @@ func handlePythonServiceError(statusCode int, body []byte, result any) error {
 		if strings.Contains(strings.ToLower(errorMsg), "multiple faces") {
 			return ErrMultipleFaces
 		}
+		if strings.Contains(strings.ToLower(errorMsg), "too many reference") {
+			return ErrTooManyReferences
+		}
 		return errors.New(errorMsg)
 	}
 
@@ -0,0 +1,2395 @@
+package face
+
+import (
+	"all-me-backend/internal/maintenance"
+	"all-me-backend/internal/providers/workers"
+	"all-me-backend/internal/storage"
+	"all-me-backend/pkg/clock"
+	"all-me-backend/pkg/models"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func createTestService(storageService StorageService, pythonURL string) *Service {
+	service := NewService(storageService, nil)
+	service.pythonServiceURL = pythonURL
+	service.downloadRetryBackoff = 0
+	return service
+}
+
+// mockPythonService is a Python service stub that completes every batch immediately
+// It reports no matches unless matchFirstImage is set, in which case each batch matches its first image,
+// and reports imageError for the second image of each batch when set
+// jobMatches overrides the matches reported for specific Python job IDs, and jobFaces the images in which faces were found
+// The first statusFailures job status requests fail with 503 Service Unavailable
+// JSON batches beyond the first maxBatches fail with 500 Internal Server Error, unless maxBatches is 0
+// Multipart batches are recorded in batches like JSON ones, with their image parts base64 encoded
+// sessionEncodings holds the reference encodings exported from and restored into each session
+type mockPythonService struct {
+	*httptest.Server
+
+	mu               sync.Mutex
+	batches          []pythonCompareBatchRequest
+	multipartCount   int
+	matchFirstImage  bool
+	imageError       string
+	jobMatches       map[string][]pythonMatchResult
+	jobFaces         map[string][]int
+	statusFailures   int
+	maxBatches       int
+	registerError    string // Error reported for register requests, which otherwise succeed
+	registeredBytes  int64  // Size of the last register request body, which is counted without buffering it
+	sessionEncodings map[string][][]float64
+	clearedSessions  []string          // Sessions whose reference face was cleared, in order
+	cancelledJobs    []string          // Batch jobs deleted through DELETE /face/job/{id}, in order
+	jobStatuses      map[string]string // Status reported for specific Python job IDs instead of "completed"
+}
+
+func newMockPythonServer(t *testing.T) *mockPythonService {
+	t.Helper()
+
+	mock := &mockPythonService{sessionEncodings: make(map[string][][]float64)}
+	mock.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch {
+		case r.URL.Path == "/face/register":
+			n, _ := io.Copy(io.Discard, r.Body)
+
+			mock.mu.Lock()
+			mock.registeredBytes = n
+			registerError := mock.registerError
+			mock.mu.Unlock()
+
+			json.NewEncoder(w).Encode(pythonRegisterResponse{Success: registerError == "", Error: registerError})
+		case r.URL.Path == "/face/compare-batch":
+			var req pythonCompareBatchRequest
+			json.NewDecoder(r.Body).Decode(&req)
+
+			mock.mu.Lock()
+			if mock.maxBatches > 0 && len(mock.batches) >= mock.maxBatches {
+				mock.mu.Unlock()
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{"detail": "Out of memory"})
+				return
+			}
+			mock.batches = append(mock.batches, req)
+			jobID := fmt.Sprintf("py-job-%d", len(mock.batches))
+			mock.mu.Unlock()
+
+			json.NewEncoder(w).Encode(pythonCompareBatchResponse{JobID: jobID, Status: "processing"})
+		case r.URL.Path == "/face/compare-batch-multipart":
+			if err := r.ParseMultipartForm(32 << 20); err != nil {
+				t.Errorf("Failed to parse multipart batch: %v", err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			req := pythonCompareBatchRequest{
+				SessionID:   r.FormValue("session_id"),
+				Aggregation: Aggregation(r.FormValue("aggregation")),
+				Model:       FaceModel(r.FormValue("model")),
+			}
+			fmt.Sscan(r.FormValue("threshold"), &req.Threshold)
+			for _, header := range r.MultipartForm.File["images"] {
+				file, _ := header.Open()
+				data, _ := io.ReadAll(file)
+				file.Close()
+				req.Images = append(req.Images, base64.StdEncoding.EncodeToString(data))
+			}
+
+			mock.mu.Lock()
+			mock.batches = append(mock.batches, req)
+			mock.multipartCount++
+			jobID := fmt.Sprintf("py-job-%d", len(mock.batches))
+			mock.mu.Unlock()
+
+			json.NewEncoder(w).Encode(pythonCompareBatchResponse{JobID: jobID, Status: "processing"})
+		case strings.HasPrefix(r.URL.Path, "/face/session/") && strings.HasSuffix(r.URL.Path, "/encodings"):
+			sessionID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/face/session/"), "/encodings")
+
+			mock.mu.Lock()
+			defer mock.mu.Unlock()
+
+			if r.Method == http.MethodPost {
+				var req pythonSessionEncodings
+				json.NewDecoder(r.Body).Decode(&req)
+				mock.sessionEncodings[sessionID] = req.Encodings
+				json.NewEncoder(w).Encode(pythonRegisterResponse{Success: true, ReferenceCount: len(req.Encodings)})
+				return
+			}
+
+			encodings, exists := mock.sessionEncodings[sessionID]
+			if !exists {
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(map[string]string{"detail": "Session not found"})
+				return
+			}
+			json.NewEncoder(w).Encode(pythonSessionEncodings{Encodings: encodings})
+		case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/face/job/"):
+			mock.mu.Lock()
+			mock.cancelledJobs = append(mock.cancelledJobs, strings.TrimPrefix(r.URL.Path, "/face/job/"))
+			mock.mu.Unlock()
+
+			json.NewEncoder(w).Encode(map[string]bool{"success": true})
+		case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/face/session/"):
+			mock.mu.Lock()
+			mock.clearedSessions = append(mock.clearedSessions, strings.TrimPrefix(r.URL.Path, "/face/session/"))
+			mock.mu.Unlock()
+
+			json.NewEncoder(w).Encode(map[string]bool{"success": true})
+		case strings.HasPrefix(r.URL.Path, "/face/job-status/"):
+			mock.mu.Lock()
+			fail := mock.statusFailures > 0
+			if fail {
+				mock.statusFailures--
+			}
+			mock.mu.Unlock()
+
+			if fail {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				json.NewEncoder(w).Encode(map[string]string{"detail": "temporarily unavailable"})
+				return
+			}
+
+			status := pythonJobStatusResponse{
+				JobID:  strings.TrimPrefix(r.URL.Path, "/face/job-status/"),
+				Status: "completed",
+			}
+
+			mock.mu.Lock()
+			if jobStatus, exists := mock.jobStatuses[status.JobID]; exists {
+				status.Status = jobStatus
+			}
+			if mock.matchFirstImage {
+				status.Matches = []pythonMatchResult{{Index: 0, Distance: 0.1}}
+				status.MatchesFound = 1
+			}
+			if matches, exists := mock.jobMatches[status.JobID]; exists {
+				status.Matches = matches
+				status.MatchesFound = len(matches)
+			}
+			if faces, exists := mock.jobFaces[status.JobID]; exists {
+				status.FaceIndices = faces
+				status.ImagesWithFaces = len(faces)
+			}
+			if mock.imageError != "" {
+				status.ImageErrors = []pythonImageError{{Index: 1, Error: mock.imageError}}
+			}
+			mock.mu.Unlock()
+
+			json.NewEncoder(w).Encode(status)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(mock.Close)
+
+	return mock
+}
+
+// submittedBatches returns a snapshot of the compare-batch requests received so far
+func (m *mockPythonService) submittedBatches() []pythonCompareBatchRequest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]pythonCompareBatchRequest(nil), m.batches...)
+}
+
+// waitForJobStatus polls the job manager until the job reaches the given status or the timeout elapses
+func waitForJobStatus(t *testing.T, service *Service, jobID string, status JobStatus) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if ctx, exists := service.jobManager.Get(jobID); exists {
+			service.jobManager.mu.RLock()
+			current := ctx.status
+			service.jobManager.mu.RUnlock()
+
+			if current == status {
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("job %s did not reach status %q in time", jobID, status)
+}
+
+func TestCompareFolderItemImages_SkipsShareLinkParsing(t *testing.T) {
+	pythonServer := newMockPythonServer(t)
+	storage := &mockStorageService{
+		images: []*models.CloudItem{
+			{ID: "img-1", Name: "a.jpg", MimeType: "image/jpeg", Provider: "onedrive"},
+		},
+	}
+	service := createTestService(storage, pythonServer.URL)
+
+	folder := &models.CloudItem{ID: "u!share-token", Name: "Event", IsFolder: true, Provider: "onedrive"}
+	token := &models.Token{AccessToken: "token", Provider: "onedrive"}
+
+	jobID, err := service.CompareFolderItemImages("session-1", folder, token, compareOptions{preprocess: DefaultPreprocessSteps, aggregation: AggregationAny, model: FaceModelSmall, recursive: true})
+	if err != nil {
+		t.Fatalf("CompareFolderItemImages failed: %v", err)
+	}
+
+	if jobID == "" {
+		t.Error("Expected a job ID, got empty string")
+	}
+
+	if storage.parseCalls != 0 {
+		t.Errorf("Expected ParseShareLink not to be called, got %d calls", storage.parseCalls)
+	}
+
+	if storage.listedFolder != folder {
+		t.Errorf("Expected ListImages to receive the provided folder item")
+	}
+}
+
+func TestCompareFolderItemImages_ProviderMismatch(t *testing.T) {
+	service := createTestService(&mockStorageService{}, "")
+
+	folder := &models.CloudItem{ID: "folder-id", IsFolder: true, Provider: "googledrive"}
+	token := &models.Token{AccessToken: "token", Provider: "onedrive"}
+
+	_, err := service.CompareFolderItemImages("session-1", folder, token, compareOptions{preprocess: DefaultPreprocessSteps, aggregation: AggregationAny, model: FaceModelSmall})
+	if !errors.Is(err, ErrInvalidFolderLink) {
+		t.Errorf("Expected ErrInvalidFolderLink, got: %v", err)
+	}
+}
+
+func TestValidateCompareFolderRequest_LinkOrFolder(t *testing.T) {
+	folder := &models.CloudItem{ID: "folder-id", IsFolder: true}
+
+	tests := []struct {
+		name    string
+		req     CompareFolderRequest
+		wantErr bool
+	}{
+		{"link only", CompareFolderRequest{SessionID: "s", Provider: "onedrive", FolderLink: "https://1drv.ms/f/abc"}, false},
+		{"folder only", CompareFolderRequest{SessionID: "s", Provider: "onedrive", Folder: folder}, false},
+		{"neither", CompareFolderRequest{SessionID: "s", Provider: "onedrive"}, true},
+		{"both", CompareFolderRequest{SessionID: "s", Provider: "onedrive", FolderLink: "https://1drv.ms/f/abc", Folder: folder}, true},
+		{"file item", CompareFolderRequest{SessionID: "s", Provider: "onedrive", Folder: &models.CloudItem{ID: "file-id"}}, true},
+		{"max distance in range", CompareFolderRequest{SessionID: "s", Provider: "onedrive", Folder: folder, MaxDistance: 0.4}, false},
+		{"max distance out of range", CompareFolderRequest{SessionID: "s", Provider: "onedrive", Folder: folder, MaxDistance: 1.5}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateCompareFolderRequest(&tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateCompareFolderRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDownloadAndEncodeBatch_GlobalDownloadCap(t *testing.T) {
+	storage := &mockStorageService{downloadDelay: 10 * time.Millisecond}
+	service := createTestService(storage, "")
+	service.workers = workers.NewPool(3)
+
+	token := &models.Token{AccessToken: "token", Provider: "onedrive"}
+
+	// Simulate several jobs downloading their batches at the same time
+	var wg sync.WaitGroup
+	for job := 0; job < 4; job++ {
+		items := make([]*models.CloudItem, 10)
+		for i := range items {
+			items[i] = &models.CloudItem{ID: fmt.Sprintf("job%d-img%d", job, i), Name: "img.jpg"}
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := service.downloadAndEncodeBatch(context.Background(), items, token, DefaultPreprocessSteps, newDocumentCache()); err != nil {
+				t.Errorf("downloadAndEncodeBatch failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if storage.maxActive > 3 {
+		t.Errorf("Expected at most 3 concurrent downloads across jobs, got %d", storage.maxActive)
+	}
+
+	if storage.maxActive == 0 {
+		t.Error("Expected downloads to be recorded")
+	}
+}
+
+func TestDownloadAndEncodeBatch_HonorsConfiguredWorkers(t *testing.T) {
+	t.Setenv("FACE_DOWNLOAD_WORKERS", "4")
+
+	storage := &mockStorageService{downloadDelay: 10 * time.Millisecond}
+	service := createTestService(storage, "")
+	service.workers = workers.NewPool(maxDownloadWorkers)
+
+	items := make([]*models.CloudItem, 20)
+	for i := range items {
+		items[i] = &models.CloudItem{ID: fmt.Sprintf("img%d", i), Name: "img.jpg"}
+	}
+	token := &models.Token{AccessToken: "token", Provider: "onedrive"}
+	if _, _, err := service.downloadAndEncodeBatch(context.Background(), items, token, DefaultPreprocessSteps, newDocumentCache()); err != nil {
+		t.Fatalf("downloadAndEncodeBatch failed: %v", err)
+	}
+
+	if storage.maxActive != 4 {
+		t.Errorf("Expected 4 concurrent downloads, got %d", storage.maxActive)
+	}
+}
+
+func TestNewService_BoundsDownloadWorkers(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int
+	}{
+		{"", defaultDownloadWorkers},
+		{"0", defaultDownloadWorkers},
+		{"25", 25},
+		{"500", maxDownloadWorkers},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("FACE_DOWNLOAD_WORKERS", tt.value)
+		if got := NewService(&mockStorageService{}, nil).downloadWorkers; got != tt.want {
+			t.Errorf("FACE_DOWNLOAD_WORKERS=%q: expected %d workers, got %d", tt.value, tt.want, got)
+		}
+	}
+}
+
+func TestRerunUnmatched_SubmitsOnlyUnmatchedImages(t *testing.T) {
+	pythonServer := newMockPythonServer(t)
+	service := createTestService(&mockStorageService{}, pythonServer.URL)
+
+	images := []*models.CloudItem{
+		{ID: "img-0", Name: "0.jpg"},
+		{ID: "img-1", Name: "1.jpg"},
+		{ID: "img-2", Name: "2.jpg"},
+		{ID: "img-3", Name: "3.jpg"},
+	}
+	token := &models.Token{AccessToken: "token", Provider: "onedrive"}
+
+	service.jobManager.Store("prior-job", "session-1", images, token, compareOptions{})
+	service.jobManager.MarkCompleted("prior-job", []pythonMatchResult{
+		{Index: 0, Distance: 0.3},
+		{Index: 2, Distance: 0.5},
+	})
+
+	jobID, err := service.RerunUnmatched("session-1", "prior-job", 0.8)
+	if err != nil {
+		t.Fatalf("RerunUnmatched failed: %v", err)
+	}
+
+	waitForJobStatus(t, service, jobID, JobStatusCompleted)
+
+	batches := pythonServer.submittedBatches()
+	if len(batches) != 1 {
+		t.Fatalf("Expected 1 batch submitted, got %d", len(batches))
+	}
+
+	if len(batches[0].Images) != 2 {
+		t.Errorf("Expected 2 unmatched images submitted, got %d", len(batches[0].Images))
+	}
+
+	if batches[0].Threshold != 0.8 {
+		t.Errorf("Expected threshold 0.8, got %v", batches[0].Threshold)
+	}
+
+	ctx, _ := service.jobManager.Get(jobID)
+	if ctx.allImages[0].ID != "img-1" || ctx.allImages[1].ID != "img-3" {
+		t.Errorf("Expected re-run to cover img-1 and img-3, got %s and %s", ctx.allImages[0].ID, ctx.allImages[1].ID)
+	}
+
+	status, err := service.GetJobStatus(jobID, false, MatchPage{})
+	if err != nil {
+		t.Fatalf("GetJobStatus failed: %v", err)
+	}
+	if status.RerunOf != "prior-job" {
+		t.Errorf("Expected rerun_of 'prior-job', got '%s'", status.RerunOf)
+	}
+}
+
+// TestRerunUnmatched_WhileJobFinishes reruns a job while its last matches come in, which -race checks
+// for unlocked reads of the job
+func TestRerunUnmatched_WhileJobFinishes(t *testing.T) {
+	pythonServer := newMockPythonServer(t)
+	service := createTestService(&mockStorageService{}, pythonServer.URL)
+
+	images := make([]*models.CloudItem, 20)
+	for i := range images {
+		images[i] = &models.CloudItem{ID: fmt.Sprintf("img-%d", i), Name: fmt.Sprintf("%d.jpg", i)}
+	}
+	service.jobManager.Store("prior-job", "session-1", images, &models.Token{AccessToken: "token", Provider: "onedrive"}, compareOptions{})
+
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		for i := range 10 {
+			service.jobManager.AppendMatches("prior-job", []pythonMatchResult{{Index: i, Distance: 0.3}})
+		}
+		service.jobManager.MarkCompleted("prior-job", []pythonMatchResult{{Index: 0, Distance: 0.3}})
+	}()
+
+	for done := false; !done; {
+		select {
+		case <-finished:
+			done = true
+		default:
+		}
+		if _, err := service.RerunUnmatched("session-1", "prior-job", 0.8); err != nil && !errors.Is(err, ErrJobNotCompleted) {
+			t.Fatalf("RerunUnmatched failed: %v", err)
+		}
+	}
+}
+
+func TestProcessBatches_SplitsLargePayloads(t *testing.T) {
+	pythonServer := newMockPythonServer(t)
+	pythonServer.matchFirstImage = true
+
+	service := createTestService(&mockStorageService{}, pythonServer.URL)
+
+	images := make([]*models.CloudItem, 5)
+	for i := range images {
+		images[i] = &models.CloudItem{ID: fmt.Sprintf("img-%d", i), Name: "img.jpg"}
+	}
+
+	// Each mock image encodes to 16 base64 characters, so two fit under the limit
+	service.maxBatchPayloadBytes = 2 * (16 + payloadBytesPerImage)
+
+	token := &models.Token{AccessToken: "token", Provider: "googledrive"}
+	jobID, err := service.processFolderInBatches("session-1", images, token, compareOptions{})
+	if err != nil {
+		t.Fatalf("processFolderInBatches failed: %v", err)
+	}
+
+	waitForJobStatus(t, service, jobID, JobStatusCompleted)
+
+	batches := pythonServer.submittedBatches()
+	if len(batches) != 3 {
+		t.Fatalf("Expected 3 sub-batches, got %d", len(batches))
+	}
+
+	status, err := service.GetJobStatus(jobID, false, MatchPage{})
+	if err != nil {
+		t.Fatalf("GetJobStatus failed: %v", err)
+	}
+
+	// Each sub-batch matched its first image, which must map back to the right global index
+	var matchedIDs []string
+	for _, match := range status.Matches {
+		matchedIDs = append(matchedIDs, match.ID)
+	}
+	if strings.Join(matchedIDs, ",") != "img-0,img-2,img-4" {
+		t.Errorf("Expected matches img-0,img-2,img-4, got %v", matchedIDs)
+	}
+}
+
+func TestSplitByPayloadSize(t *testing.T) {
+	images := [][]byte{[]byte("aaa"), []byte("bbb"), []byte("cccccccccccc"), []byte("d")}
+
+	tests := []struct {
+		transport BatchTransport
+		maxBytes  int
+		want      string
+	}{
+		// "aaa" and "bbb" fit together (7+7 bytes base64), the oversized image goes alone, then "d"
+		{TransportBase64, 14, "[2 1 1]"},
+		// Without base64 overhead, the 12 byte image and "d" fit together (140+129 bytes)
+		{TransportMultipart, 300, "[2 2]"},
+	}
+
+	for _, tt := range tests {
+		var sizes []int
+		for _, subBatch := range splitByPayloadSize(images, tt.maxBytes, tt.transport) {
+			sizes = append(sizes, len(subBatch))
+		}
+
+		if fmt.Sprint(sizes) != tt.want {
+			t.Errorf("Expected %s sub-batch sizes %s, got %v", tt.transport, tt.want, sizes)
+		}
+	}
+}
+
+func TestProcessBatches_MultipartTransport(t *testing.T) {
+	pythonServer := newMockPythonServer(t)
+	pythonServer.matchFirstImage = true
+
+	service := createTestService(&mockStorageService{}, pythonServer.URL)
+	service.batchTransport = TransportMultipart
+
+	images := []*models.CloudItem{
+		{ID: "img-1", Name: "a.jpg"},
+		{ID: "img-2", Name: "b.jpg"},
+	}
+
+	token := &models.Token{AccessToken: "token", Provider: "googledrive"}
+	jobID, err := service.processFolderInBatches("session-1", images, token, compareOptions{threshold: 0.8, aggregation: AggregationMean})
+	if err != nil {
+		t.Fatalf("processFolderInBatches failed: %v", err)
+	}
+
+	waitForJobStatus(t, service, jobID, JobStatusCompleted)
+
+	batches := pythonServer.submittedBatches()
+	if len(batches) != 1 || pythonServer.multipartCount != 1 {
+		t.Fatalf("Expected 1 multipart batch, got %d batches, %d multipart", len(batches), pythonServer.multipartCount)
+	}
+
+	batch := batches[0]
+	if batch.SessionID != "session-1" || batch.Threshold != 0.8 || batch.Aggregation != AggregationMean {
+		t.Errorf("Expected session-1 with threshold 0.8 and mean aggregation, got %+v", batch)
+	}
+
+	// Parts carry the raw image bytes in order, which the mock re-encodes for comparison
+	for i, id := range []string{"img-1", "img-2"} {
+		if decoded, _ := base64.StdEncoding.DecodeString(batch.Images[i]); string(decoded) != "image-"+id {
+			t.Errorf("Expected part %d to contain image-%s, got %q", i, id, decoded)
+		}
+	}
+
+	status, err := service.GetJobStatus(jobID, false, MatchPage{})
+	if err != nil {
+		t.Fatalf("GetJobStatus failed: %v", err)
+	}
+	if len(status.Matches) != 1 || status.Matches[0].ID != "img-1" {
+		t.Errorf("Expected img-1 to match, got %+v", status.Matches)
+	}
+}
+
+func TestParseBatchTransport(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    BatchTransport
+		wantErr bool
+	}{
+		{"", TransportBase64, false},
+		{"base64", TransportBase64, false},
+		{" Multipart ", TransportMultipart, false},
+		{"protobuf", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseBatchTransport(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseBatchTransport(%q) = %q, %v; expected %q, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestProcessBatches_DeduplicatesIdenticalImages(t *testing.T) {
+	tests := []struct {
+		name               string
+		collapseDuplicates bool
+		wantMatches        string
+	}{
+		{"surfaced", false, "shared-a,shared-b"},
+		{"collapsed", true, "shared-a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pythonServer := newMockPythonServer(t)
+			pythonServer.matchFirstImage = true
+
+			storage := &mockStorageService{contents: map[string]string{
+				"shared-a": "same-photo",
+				"shared-b": "same-photo",
+			}}
+			service := createTestService(storage, pythonServer.URL)
+			service.collapseDuplicates = tt.collapseDuplicates
+
+			images := []*models.CloudItem{
+				{ID: "shared-a", Name: "photo.jpg"},
+				{ID: "other", Name: "other.jpg"},
+				{ID: "shared-b", Name: "photo.jpg"},
+			}
+
+			token := &models.Token{AccessToken: "token", Provider: "googledrive"}
+			jobID, err := service.processFolderInBatches("session-1", images, token, compareOptions{})
+			if err != nil {
+				t.Fatalf("processFolderInBatches failed: %v", err)
+			}
+
+			waitForJobStatus(t, service, jobID, JobStatusCompleted)
+
+			batches := pythonServer.submittedBatches()
+			if len(batches) != 1 || len(batches[0].Images) != 2 {
+				t.Fatalf("Expected one batch with 2 unique images, got %d batches", len(batches))
+			}
+
+			status, err := service.GetJobStatus(jobID, false, MatchPage{})
+			if err != nil {
+				t.Fatalf("GetJobStatus failed: %v", err)
+			}
+
+			var matchedIDs []string
+			for _, match := range status.Matches {
+				matchedIDs = append(matchedIDs, match.ID)
+			}
+			if strings.Join(matchedIDs, ",") != tt.wantMatches {
+				t.Errorf("Expected matches %s, got %v", tt.wantMatches, matchedIDs)
+			}
+		})
+	}
+}
+
+func TestProcessBatches_SkipsImagesThatFailToDownload(t *testing.T) {
+	pythonServer := newMockPythonServer(t)
+	pythonServer.matchFirstImage = true
+
+	storage := &mockStorageService{downloadErrors: map[string]bool{"broken": true}}
+	service := createTestService(storage, pythonServer.URL)
+
+	images := []*models.CloudItem{
+		{ID: "broken", Name: "broken.jpg"},
+		{ID: "a", Name: "a.jpg"},
+		{ID: "b", Name: "b.jpg"},
+	}
+
+	token := &models.Token{AccessToken: "token", Provider: "googledrive"}
+	jobID, err := service.processFolderInBatches("session-1", images, token, compareOptions{})
+	if err != nil {
+		t.Fatalf("processFolderInBatches failed: %v", err)
+	}
+
+	waitForJobStatus(t, service, jobID, JobStatusCompleted)
+
+	batches := pythonServer.submittedBatches()
+	if len(batches) != 1 || len(batches[0].Images) != 2 {
+		t.Fatalf("Expected one batch with the 2 readable images, got %d batches", len(batches))
+	}
+
+	status, err := service.GetJobStatus(jobID, false, MatchPage{})
+	if err != nil {
+		t.Fatalf("GetJobStatus failed: %v", err)
+	}
+
+	// The first image sent matches, which must map back to a.jpg rather than the skipped image
+	if len(status.Matches) != 1 || status.Matches[0].ID != "a" {
+		t.Errorf("Expected a.jpg to match, got %v", status.Matches)
+	}
+	if len(status.FailedImages) != 1 || status.FailedImages[0].Name != "broken.jpg" || status.FailedImages[0].Reason == "" {
+		t.Errorf("Expected broken.jpg to be reported with a reason, got %+v", status.FailedImages)
+	}
+	if !strings.HasSuffix(status.Message, "Skipped 1 unreadable files") {
+		t.Errorf("Expected the message to mention the skipped file, got %q", status.Message)
+	}
+}
+
+func TestDownloadImage_RetriesTransientFailures(t *testing.T) {
+	storage := &mockStorageService{
+		flakyDownloads: map[string]int{"flaky": 2, "broken": 5},
+		missing:        map[string]bool{"missing": true},
+	}
+	service := createTestService(storage, "")
+	token := &models.Token{AccessToken: "token", Provider: "googledrive"}
+
+	if _, err := service.downloadImage(context.Background(), &models.CloudItem{ID: "flaky", Name: "flaky.jpg"}, token, newDocumentCache()); err != nil {
+		t.Errorf("Expected the third attempt to succeed, got %v", err)
+	}
+	if _, err := service.downloadImage(context.Background(), &models.CloudItem{ID: "broken", Name: "broken.jpg"}, token, newDocumentCache()); err == nil {
+		t.Error("Expected the download to fail once every attempt failed")
+	}
+	if _, err := service.downloadImage(context.Background(), &models.CloudItem{ID: "missing", Name: "missing.jpg"}, token, newDocumentCache()); err == nil {
+		t.Error("Expected a missing file to fail")
+	}
+
+	expected := map[string]int{"flaky": 3, "broken": maxImageDownloadAttempts, "missing": 1}
+	for id, attempts := range expected {
+		if storage.attempts[id] != attempts {
+			t.Errorf("Expected %d attempts for %s, got %d", attempts, id, storage.attempts[id])
+		}
+	}
+}
+
+func TestDownloadImage_StopsWaitingWhenCancelled(t *testing.T) {
+	storage := &mockStorageService{flakyDownloads: map[string]int{"flaky": 2}}
+	service := createTestService(storage, "")
+	service.downloadRetryBackoff = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := service.downloadImage(ctx, &models.CloudItem{ID: "flaky", Name: "flaky.jpg"}, &models.Token{AccessToken: "token", Provider: "googledrive"}, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the download to fail with the cancellation, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected cancelling to end the backoff at once, waited %v", elapsed)
+	}
+	if storage.attempts["flaky"] != 1 {
+		t.Errorf("Expected no attempt after cancelling, got %d attempts", storage.attempts["flaky"])
+	}
+}
+
+func TestProcessBatches_FailsWhenNoImageDownloads(t *testing.T) {
+	storage := &mockStorageService{downloadErrors: map[string]bool{"a": true, "b": true}}
+	service := createTestService(storage, newMockPythonServer(t).URL)
+
+	images := []*models.CloudItem{{ID: "a", Name: "a.jpg"}, {ID: "b", Name: "b.jpg"}}
+	jobID, err := service.processFolderInBatches("session-1", images, &models.Token{AccessToken: "token", Provider: "googledrive"}, compareOptions{})
+	if err != nil {
+		t.Fatalf("processFolderInBatches failed: %v", err)
+	}
+
+	waitForJobStatus(t, service, jobID, JobStatusFailed)
+}
+
+func TestProcessBatches_SkipsFailedFinalBatch(t *testing.T) {
+	pythonServer := newMockPythonServer(t)
+	storage := &mockStorageService{downloadErrors: map[string]bool{"img-100": true}}
+	service := createTestService(storage, pythonServer.URL)
+
+	// The second batch holds only the corrupt image
+	images := make([]*models.CloudItem, 101)
+	for i := range images {
+		images[i] = &models.CloudItem{ID: fmt.Sprintf("img-%d", i), Name: fmt.Sprintf("img-%d.jpg", i)}
+	}
+
+	jobID, err := service.processFolderInBatches("session-1", images, &models.Token{AccessToken: "token", Provider: "googledrive"}, compareOptions{})
+	if err != nil {
+		t.Fatalf("processFolderInBatches failed: %v", err)
+	}
+
+	waitForJobStatus(t, service, jobID, JobStatusCompleted)
+
+	status, err := service.GetJobStatus(jobID, false, MatchPage{})
+	if err != nil {
+		t.Fatalf("GetJobStatus failed: %v", err)
+	}
+	if len(status.FailedImages) != 1 || status.FailedImages[0].Name != "img-100.jpg" {
+		t.Errorf("Expected img-100.jpg to be reported as failed, got %+v", status.FailedImages)
+	}
+}
+
+func TestProcessBatches_FailsWhenProviderRefusesToken(t *testing.T) {
+	storage := &mockStorageService{signedOut: map[string]bool{"b": true}}
+	service := createTestService(storage, newMockPythonServer(t).URL)
+
+	images := []*models.CloudItem{{ID: "a", Name: "a.jpg"}, {ID: "b", Name: "b.jpg"}}
+	jobID, err := service.processFolderInBatches("session-1", images, &models.Token{AccessToken: "token", Provider: "googledrive"}, compareOptions{})
+	if err != nil {
+		t.Fatalf("processFolderInBatches failed: %v", err)
+	}
+
+	waitForJobStatus(t, service, jobID, JobStatusFailed)
+}
+
+func TestAutoClearReference_ClearsOnceTheSessionsJobsFinish(t *testing.T) {
+	pythonServer := newMockPythonServer(t)
+	service := createTestService(&mockStorageService{}, pythonServer.URL)
+	service.autoClearReference = true
+
+	cleared := func() []string {
+		pythonServer.mu.Lock()
+		defer pythonServer.mu.Unlock()
+		return append([]string(nil), pythonServer.clearedSessions...)
+	}
+
+	// Another job of the session is still running, so its reference face is kept
+	service.jobManager.Store("other-job", "session-1", nil, nil, compareOptions{})
+
+	images := []*models.CloudItem{{ID: "a", Name: "a.jpg"}}
+	token := &models.Token{AccessToken: "token", Provider: "googledrive"}
+	jobID, err := service.processFolderInBatches("session-1", images, token, compareOptions{})
+	if err != nil {
+		t.Fatalf("processFolderInBatches failed: %v", err)
+	}
+	waitForJobStatus(t, service, jobID, JobStatusCompleted)
+
+	if len(cleared()) != 0 {
+		t.Fatalf("Expected the reference face to be kept for the running job, got clears %v", cleared())
+	}
+
+	service.jobManager.MarkFailed("other-job", "failed")
+	service.clearReferenceAfterJob("other-job", "session-1")
+
+	if sessions := cleared(); len(sessions) != 1 || sessions[0] != "session-1" {
+		t.Errorf("Expected the session's reference face to be cleared once, got %v", sessions)
+	}
+}
+
+func TestGetJobStatus_ImagesWithFaces(t *testing.T) {
+	tests := []struct {
+		name        string
+		jobFaces    map[string][]int
+		wantFaces   int
+		wantMessage string
+	}{
+		{"faces without match", map[string][]int{"py-job-1": {0}}, 2, "Completed! No matches among 3 images, although 2 had faces. " +
+			"Try a clearer, front-facing reference photo, check that this is the right folder, or re-run unmatched images with a less strict threshold"},
+		{"no faces", nil, 0, "Completed! No faces found in any of the 3 images. Check that this is the folder you meant and that its photos show people's faces"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pythonServer := newMockPythonServer(t)
+			pythonServer.jobFaces = tt.jobFaces
+
+			// shared-a and shared-b are compared once, so a face in one counts for both
+			storage := &mockStorageService{contents: map[string]string{
+				"shared-a": "same-photo",
+				"shared-b": "same-photo",
+			}}
+			service := createTestService(storage, pythonServer.URL)
+
+			images := []*models.CloudItem{
+				{ID: "shared-a", Name: "photo.jpg"},
+				{ID: "other", Name: "other.jpg"},
+				{ID: "shared-b", Name: "photo.jpg"},
+			}
+
+			token := &models.Token{AccessToken: "token", Provider: "googledrive"}
+			jobID, err := service.processFolderInBatches("session-1", images, token, compareOptions{})
+			if err != nil {
+				t.Fatalf("processFolderInBatches failed: %v", err)
+			}
+
+			waitForJobStatus(t, service, jobID, JobStatusCompleted)
+
+			status, err := service.GetJobStatus(jobID, false, MatchPage{})
+			if err != nil {
+				t.Fatalf("GetJobStatus failed: %v", err)
+			}
+
+			if status.ImagesWithFaces != tt.wantFaces {
+				t.Errorf("Expected %d images with faces, got %d", tt.wantFaces, status.ImagesWithFaces)
+			}
+			if status.Message != tt.wantMessage {
+				t.Errorf("Expected message %q, got %q", tt.wantMessage, status.Message)
+			}
+			if !status.NoMatches {
+				t.Error("Expected no_matches to be set for a job without matches")
+			}
+		})
+	}
+}
+
+func TestGetJobStatus_MatchesKeepTheirProvider(t *testing.T) {
+	service := createTestService(&mockStorageService{}, "http://unused")
+
+	images := []*models.CloudItem{
+		{ID: "drive-photo", Name: "a.jpg", Provider: "googledrive"},
+		{ID: "onedrive-photo", Name: "b.jpg", Provider: "onedrive"},
+		{ID: "legacy-photo", Name: "c.jpg"},
+	}
+	token := &models.Token{AccessToken: "token", Provider: "googledrive"}
+	service.jobManager.Store("job-1", "session-1", images, token, compareOptions{})
+	service.jobManager.MarkCompleted("job-1", []pythonMatchResult{
+		{Index: 0, Distance: 0.2},
+		{Index: 1, Distance: 0.3},
+		{Index: 2, Distance: 0.4},
+	})
+
+	status, err := service.GetJobStatus("job-1", false, MatchPage{})
+	if err != nil {
+		t.Fatalf("GetJobStatus failed: %v", err)
+	}
+
+	wantProviders := []string{"googledrive", "onedrive", "googledrive"}
+	if len(status.Matches) != len(wantProviders) {
+		t.Fatalf("Expected %d matches, got %d", len(wantProviders), len(status.Matches))
+	}
+	for i, match := range status.Matches {
+		if match.Provider != wantProviders[i] {
+			t.Errorf("Expected match %s to come from %s, got %q", match.ID, wantProviders[i], match.Provider)
+		}
+	}
+
+	if images[2].Provider != "" {
+		t.Error("Expected the stored image to be left unchanged")
+	}
+}
+
+func TestAggregateBatchResults_RetriesStatusPolls(t *testing.T) {
+	tests := []struct {
+		name           string
+		statusFailures int
+		wantStatus     JobStatus
+	}{
+		{"intermittent failures", 2, JobStatusCompleted},
+		{"sustained failure", 100, JobStatusFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pythonServer := newMockPythonServer(t)
+			pythonServer.statusFailures = tt.statusFailures
+
+			service := createTestService(&mockStorageService{}, pythonServer.URL)
+			service.statusPollInterval = 5 * time.Millisecond
+			service.statusPollMaxFailures = 3
+
+			images := []*models.CloudItem{{ID: "img-1"}, {ID: "img-2"}}
+			token := &models.Token{AccessToken: "token", Provider: "googledrive"}
+			jobID, err := service.processFolderInBatches("session-1", images, token, compareOptions{})
+			if err != nil {
+				t.Fatalf("processFolderInBatches failed: %v", err)
+			}
+
+			waitForJobStatus(t, service, jobID, tt.wantStatus)
+		})
+	}
+}
+
+func TestAggregateBatchResults_BacksOffWithServiceClock(t *testing.T) {
+	pythonServer := newMockPythonServer(t)
+	pythonServer.statusFailures = 1
+	fakeClock := clock.NewFake(time.Now())
+	service := createTestService(&mockStorageService{}, pythonServer.URL)
+	service.jobManager = NewJobManagerWithClock(fakeClock)
+	service.statusPollInterval = time.Millisecond
+	service.statusPollMaxInterval = time.Millisecond
+
+	ctx := service.jobManager.Store("job-1", "session-1", []*models.CloudItem{{ID: "img-0"}}, nil, compareOptions{})
+
+	done := make(chan struct{})
+	go func() {
+		service.aggregateBatchResults(ctx, "job-1", []string{"py-a"}, [][]int{{0}}, nil, 1, 0)
+		close(done)
+	}()
+
+	// The failed poll is only retried once the service clock passes its backoff
+	select {
+	case <-done:
+		t.Fatal("Expected the failed poll to wait for its backoff")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	fakeClock.Advance(time.Second)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the poll to be retried once the backoff passed")
+	}
+
+	if job, _ := service.jobManager.Snapshot("job-1"); job.status != JobStatusCompleted {
+		t.Errorf("Expected the job to complete after the retry, got %q", job.status)
+	}
+}
+
+func TestStatusPollBackoff(t *testing.T) {
+	interval := 500 * time.Millisecond
+
+	expected := []time.Duration{500 * time.Millisecond, time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, maxStatusPollBackoff, maxStatusPollBackoff}
+	for i, want := range expected {
+		if got := statusPollBackoff(interval, i+1); got != want {
+			t.Errorf("Expected backoff %v after %d failures, got %v", want, i+1, got)
+		}
+	}
+}
+
+func TestStatusPollDelay(t *testing.T) {
+	interval := 500 * time.Millisecond
+	maxInterval := 5 * time.Second
+
+	// Without jitter, i.e. a jitter value of 0.5, idle rounds double the delay up to the cap
+	expected := []time.Duration{500 * time.Millisecond, time.Second, 2 * time.Second, 4 * time.Second, maxInterval, maxInterval}
+	for idlePolls, want := range expected {
+		if got := statusPollDelay(interval, maxInterval, idlePolls, 0.5); got != want {
+			t.Errorf("Expected delay %v after %d idle polls, got %v", want, idlePolls, got)
+		}
+	}
+
+	// Jitter spreads the delay by up to 10% either way
+	if got := statusPollDelay(interval, maxInterval, 10, 0); got != 4500*time.Millisecond {
+		t.Errorf("Expected the lowest jitter to shorten the delay to 4.5s, got %v", got)
+	}
+	if got := statusPollDelay(interval, maxInterval, 10, 0.999); got <= maxInterval || got > 5500*time.Millisecond {
+		t.Errorf("Expected the highest jitter to lengthen the delay up to 5.5s, got %v", got)
+	}
+
+	// A cap below the base interval never makes polling faster than the interval
+	if got := statusPollDelay(interval, 100*time.Millisecond, 3, 0.5); got != interval {
+		t.Errorf("Expected the base interval when the cap is lower, got %v", got)
+	}
+}
+
+func TestGetJobStatus_DebugListsImageErrors(t *testing.T) {
+	pythonServer := newMockPythonServer(t)
+	pythonServer.imageError = "cannot identify image file"
+
+	service := createTestService(&mockStorageService{}, pythonServer.URL)
+
+	images := []*models.CloudItem{
+		{ID: "img-0", Name: "0.jpg"},
+		{ID: "img-1", Name: "1.jpg"},
+		{ID: "img-2", Name: "2.jpg"},
+	}
+
+	token := &models.Token{AccessToken: "token", Provider: "googledrive"}
+	jobID, err := service.processFolderInBatches("session-1", images, token, compareOptions{})
+	if err != nil {
+		t.Fatalf("processFolderInBatches failed: %v", err)
+	}
+
+	waitForJobStatus(t, service, jobID, JobStatusCompleted)
+
+	status, err := service.GetJobStatus(jobID, false, MatchPage{})
+	if err != nil {
+		t.Fatalf("GetJobStatus failed: %v", err)
+	}
+	if status.Diagnostics != nil {
+		t.Errorf("Expected no diagnostics without debug, got %+v", status.Diagnostics)
+	}
+
+	status, err = service.GetJobStatus(jobID, true, MatchPage{})
+	if err != nil {
+		t.Fatalf("GetJobStatus failed: %v", err)
+	}
+	if status.Diagnostics == nil {
+		t.Fatal("Expected diagnostics with debug")
+	}
+
+	imageErrors := status.Diagnostics.ImageErrors
+	if len(imageErrors) != 1 {
+		t.Fatalf("Expected 1 image error, got %d", len(imageErrors))
+	}
+	if imageErrors[0].Item.ID != "img-1" || imageErrors[0].Error != "cannot identify image file" {
+		t.Errorf("Expected img-1 to be reported with its error, got %+v", imageErrors[0])
+	}
+}
+
+func TestProcessBatches_MultiBatchOffsets(t *testing.T) {
+	pythonServer := newMockPythonServer(t)
+	pythonServer.matchFirstImage = true
+
+	service := createTestService(&mockStorageService{}, pythonServer.URL)
+
+	// 250 images are sent as three batches of 100, 100 and 50
+	images := make([]*models.CloudItem, 250)
+	for i := range images {
+		images[i] = &models.CloudItem{ID: fmt.Sprintf("img-%d", i), Name: "img.jpg"}
+	}
+
+	token := &models.Token{AccessToken: "token", Provider: "googledrive"}
+	jobID, err := service.processFolderInBatches("session-1", images, token, compareOptions{})
+	if err != nil {
+		t.Fatalf("processFolderInBatches failed: %v", err)
+	}
+
+	waitForJobStatus(t, service, jobID, JobStatusCompleted)
+
+	status, err := service.GetJobStatus(jobID, false, MatchPage{})
+	if err != nil {
+		t.Fatalf("GetJobStatus failed: %v", err)
+	}
+
+	var matchedIDs []string
+	for _, match := range status.Matches {
+		matchedIDs = append(matchedIDs, match.ID)
+	}
+	if strings.Join(matchedIDs, ",") != "img-0,img-100,img-200" {
+		t.Errorf("Expected matches img-0,img-100,img-200, got %v", matchedIDs)
+	}
+	if service.OutOfRangeIndices() != 0 {
+		t.Errorf("Expected no out-of-range indices, got %d", service.OutOfRangeIndices())
+	}
+}
+
+func TestAggregateBatchResults_LogsOutOfRangeIndices(t *testing.T) {
+	pythonServer := newMockPythonServer(t)
+	pythonServer.jobMatches = map[string][]pythonMatchResult{
+		"py-a": {{Index: 1, Distance: 0.1}, {Index: 5, Distance: 0.2}},
+		"py-b": {{Index: 0, Distance: 0.3}, {Index: -1, Distance: 0.4}},
+	}
+
+	service := createTestService(&mockStorageService{}, pythonServer.URL)
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	images := make([]*models.CloudItem, 4)
+	for i := range images {
+		images[i] = &models.CloudItem{ID: fmt.Sprintf("img-%d", i)}
+	}
+	service.jobManager.Store("job-1", "session-1", images, nil, compareOptions{})
+
+	service.aggregateBatchResults(context.Background(), "job-1", []string{"py-a", "py-b"}, [][]int{{0, 1}, {2, 3}}, nil, len(images), 0)
+
+	status, err := service.GetJobStatus("job-1", false, MatchPage{})
+	if err != nil {
+		t.Fatalf("GetJobStatus failed: %v", err)
+	}
+
+	var matchedIDs []string
+	for _, match := range status.Matches {
+		matchedIDs = append(matchedIDs, match.ID)
+	}
+	if strings.Join(matchedIDs, ",") != "img-1,img-2" {
+		t.Errorf("Expected matches img-1,img-2, got %v", matchedIDs)
+	}
+	if service.OutOfRangeIndices() != 2 {
+		t.Errorf("Expected 2 out-of-range indices, got %d", service.OutOfRangeIndices())
+	}
+	if !strings.Contains(logs.String(), "out-of-range index 5") || !strings.Contains(logs.String(), "out-of-range index -1") {
+		t.Errorf("Expected both out-of-range indices to be logged, got %q", logs.String())
+	}
+
+	// A match that slipped through aggregation is still dropped, and counted, when building the response
+	ctx, _ := service.jobManager.Get("job-1")
+	service.jobManager.mu.Lock()
+	ctx.matches = []pythonMatchResult{{Index: 7, Distance: 0.1}}
+	service.jobManager.mu.Unlock()
+	status, err = service.GetJobStatus("job-1", false, MatchPage{})
+	if err != nil {
+		t.Fatalf("GetJobStatus failed: %v", err)
+	}
+	if len(status.Matches) != 0 {
+		t.Errorf("Expected no matches, got %d", len(status.Matches))
+	}
+	if service.OutOfRangeIndices() != 3 {
+		t.Errorf("Expected 3 out-of-range indices, got %d", service.OutOfRangeIndices())
+	}
+}
+
+func TestValidateBatchIndices(t *testing.T) {
+	tests := []struct {
+		name         string
+		jobIDs       []string
+		batchIndices [][]int
+		wantErr      bool
+	}{
+		{name: "valid", jobIDs: []string{"a", "b"}, batchIndices: [][]int{{0, 1}, {3}}},
+		{name: "missing mapping", jobIDs: []string{"a", "b"}, batchIndices: [][]int{{0, 1}}, wantErr: true},
+		{name: "beyond job", jobIDs: []string{"a"}, batchIndices: [][]int{{0, 4}}, wantErr: true},
+		{name: "negative", jobIDs: []string{"a"}, batchIndices: [][]int{{-1}}, wantErr: true},
+		{name: "sent twice", jobIDs: []string{"a", "b"}, batchIndices: [][]int{{0, 1}, {1}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		err := validateBatchIndices(tt.jobIDs, tt.batchIndices, 4)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: expected error %v, got %v", tt.name, tt.wantErr, err)
+		}
+	}
+}
+
+func TestRegisterRequestBody(t *testing.T) {
+	body := registerRequestBody(`session-"1"`, FaceModelLarge, true, strings.NewReader("image bytes"))
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatalf("Failed to read body: %v", err)
+	}
+
+	var req pythonRegisterRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		t.Fatalf("Expected valid JSON, got %q: %v", data, err)
+	}
+	if req.SessionID != `session-"1"` || req.Model != FaceModelLarge || !req.Append || req.Image != base64.StdEncoding.EncodeToString([]byte("image bytes")) {
+		t.Errorf("Unexpected register request %+v", req)
+	}
+}
+
+func TestCompare_RejectsModelMismatch(t *testing.T) {
+	pythonServer := newMockPythonServer(t)
+	storage := &mockStorageService{images: []*models.CloudItem{{ID: "img-1", Name: "a.jpg"}}}
+	service := createTestService(storage, pythonServer.URL)
+	token := &models.Token{AccessToken: "token", Provider: "googledrive"}
+
+	if _, err := service.RegisterBaseFace("session-1", bytes.NewReader([]byte("face")), PreprocessSteps{}, FaceModelLarge, false); err != nil {
+		t.Fatalf("RegisterBaseFace failed: %v", err)
+	}
+
+	_, err := service.CompareFolderImages("session-1", "https://drive.google.com/drive/folders/abc", token, compareOptions{preprocess: DefaultPreprocessSteps, aggregation: AggregationAny, model: FaceModelSmall})
+	if !errors.Is(err, ErrModelMismatch) {
+		t.Fatalf("Expected ErrModelMismatch comparing with another model, got %v", err)
+	}
+	if storage.parseCalls != 0 || len(pythonServer.submittedBatches()) != 0 {
+		t.Error("Expected the mismatch to be rejected before listing or submitting images")
+	}
+	if response := GetErrorResponse(err); response.StatusCode != http.StatusConflict {
+		t.Errorf("Expected status 409, got %d", response.StatusCode)
+	}
+
+	if _, err := service.CompareDriveImages("session-1", token, FaceModelSmall); !errors.Is(err, ErrModelMismatch) {
+		t.Errorf("Expected ErrModelMismatch for a drive comparison, got %v", err)
+	}
+
+	if _, err := service.RegisterBaseFace("session-1", bytes.NewReader([]byte("face")), PreprocessSteps{}, FaceModelSmall, true); !errors.Is(err, ErrModelMismatch) {
+		t.Errorf("Expected ErrModelMismatch appending a reference with another model, got %v", err)
+	}
+
+	jobID, err := service.CompareFolderImages("session-1", "https://drive.google.com/drive/folders/abc", token, compareOptions{preprocess: DefaultPreprocessSteps, aggregation: AggregationAny, model: FaceModelLarge})
+	if err != nil {
+		t.Fatalf("Expected comparing with the registered model to succeed, got %v", err)
+	}
+	waitForJobStatus(t, service, jobID, JobStatusCompleted)
+
+	if batches := pythonServer.submittedBatches(); len(batches) != 1 || batches[0].Model != FaceModelLarge {
+		t.Errorf("Expected the batch to be encoded with the large model, got %+v", batches)
+	}
+}
+
+func TestNewJobsDisabled_RejectsNewJobsButKeepsExistingOnes(t *testing.T) {
+	pythonServer := newMockPythonServer(t)
+	service := createTestService(&mockStorageService{}, pythonServer.URL)
+	service.drain = maintenance.NewSwitch(false)
+
+	token := &models.Token{AccessToken: "token", Provider: "googledrive"}
+	images := []*models.CloudItem{{ID: "img-1", Name: "a.jpg"}}
+	jobID, err := service.processFolderInBatches("session-1", images, token, compareOptions{})
+	if err != nil {
+		t.Fatalf("processFolderInBatches failed: %v", err)
+	}
+
+	// Draining starts while the job is already running, without restarting the server
+	service.drain.Set(true)
+
+	if _, err := service.CompareFolderImages("session-1", "https://drive.google.com/drive/folders/abc", token, compareOptions{preprocess: DefaultPreprocessSteps, aggregation: AggregationAny, model: FaceModelSmall}); !errors.Is(err, ErrNewJobsDisabled) {
+		t.Errorf("Expected ErrNewJobsDisabled for a folder comparison, got %v", err)
+	}
+	if _, err := service.CompareDriveImages("session-1", token, FaceModelSmall); !errors.Is(err, ErrNewJobsDisabled) {
+		t.Errorf("Expected ErrNewJobsDisabled for a drive comparison, got %v", err)
+	}
+
+	waitForJobStatus(t, service, jobID, JobStatusCompleted)
+
+	if _, err := service.RerunUnmatched("session-1", jobID, 0.8); !errors.Is(err, ErrNewJobsDisabled) {
+		t.Errorf("Expected ErrNewJobsDisabled for a re-run, got %v", err)
+	}
+
+	if _, err := service.GetJobStatus(jobID, false, MatchPage{}); err != nil {
+		t.Errorf("Expected the running job's status to stay available, got %v", err)
+	}
+
+	if response := GetErrorResponse(ErrNewJobsDisabled); response.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 Service Unavailable, got %d", response.StatusCode)
+	}
+
+	service.drain.Set(false)
+	if _, err := service.RerunUnmatched("session-1", jobID, 0.8); errors.Is(err, ErrNewJobsDisabled) {
+		t.Errorf("Expected new jobs to be accepted once draining stopped, got %v", err)
+	}
+}
+
+func TestSavedReferenceFace_ReusedInLaterSession(t *testing.T) {
+	pythonServer := newMockPythonServer(t)
+	encodings := [][]float64{{0.1, -0.2, 0.3}, {0.4, 0.5, -0.6}}
+	pythonServer.sessionEncodings["session-1"] = encodings
+
+	fakeClock := clock.NewFake(time.Now())
+	service := createTestService(&mockStorageService{}, pythonServer.URL)
+	service.jobManager = NewJobManagerWithClock(fakeClock)
+	store, err := NewFileReferenceStore(t.TempDir() + "/references.json")
+	if err != nil {
+		t.Fatalf("NewFileReferenceStore failed: %v", err)
+	}
+	service.referenceStore = store
+
+	token := &models.Token{AccessToken: "token", Provider: "googledrive"}
+	saved, err := service.SaveReferenceFace("session-1", token)
+	if err != nil {
+		t.Fatalf("SaveReferenceFace failed: %v", err)
+	}
+	if !saved.SavedAt.Equal(fakeClock.Now()) {
+		t.Errorf("Expected the reference to be stamped with the service clock, got %v", saved.SavedAt)
+	}
+
+	// The same account signing in again gets a new session and a new token for the same account
+	laterToken := &models.Token{AccessToken: "token", Provider: "googledrive"}
+	count, err := service.UseSavedReferenceFace("session-2", laterToken)
+	if err != nil {
+		t.Fatalf("UseSavedReferenceFace failed: %v", err)
+	}
+	if count != 2 || fmt.Sprint(pythonServer.sessionEncodings["session-2"]) != fmt.Sprint(encodings) {
+		t.Errorf("Expected session-2 to get both saved encodings, got %d: %v", count, pythonServer.sessionEncodings["session-2"])
+	}
+
+	otherAccount := &models.Token{AccessToken: "other", Provider: "googledrive"}
+	if _, err := service.UseSavedReferenceFace("session-3", otherAccount); !errors.Is(err, ErrNoSavedReference) {
+		t.Errorf("Expected ErrNoSavedReference for another account, got %v", err)
+	}
+
+	if err := service.DeleteSavedReferenceFace(laterToken); err != nil {
+		t.Fatalf("DeleteSavedReferenceFace failed: %v", err)
+	}
+	if _, err := service.UseSavedReferenceFace("session-2", laterToken); !errors.Is(err, ErrNoSavedReference) {
+		t.Errorf("Expected ErrNoSavedReference after deleting, got %v", err)
+	}
+}
+
+func TestSaveReferenceFace_Errors(t *testing.T) {
+	pythonServer := newMockPythonServer(t)
+	token := &models.Token{AccessToken: "token", Provider: "googledrive"}
+
+	service := createTestService(&mockStorageService{}, pythonServer.URL)
+	if _, err := service.SaveReferenceFace("session-1", token); !errors.Is(err, ErrSavingDisabled) {
+		t.Errorf("Expected ErrSavingDisabled without a store, got %v", err)
+	}
+
+	store, err := NewFileReferenceStore(t.TempDir() + "/references.json")
+	if err != nil {
+		t.Fatalf("NewFileReferenceStore failed: %v", err)
+	}
+	service.referenceStore = store
+
+	if _, err := service.SaveReferenceFace("session-without-face", token); !errors.Is(err, ErrNoBaseFace) {
+		t.Errorf("Expected ErrNoBaseFace for a session without a reference face, got %v", err)
+	}
+}
+
+func TestPythonServiceClient_FailsFastWhenUnreachable(t *testing.T) {
+	service := createTestService(&mockStorageService{}, "http://192.0.2.1:8081") // TEST-NET-1, never routed
+	service.httpClient = newPythonServiceClient(200*time.Millisecond, time.Second)
+
+	start := time.Now()
+	err := service.ClearReferenceImage("session-1")
+	elapsed := time.Since(start)
+
+	if !errors.Is(err, ErrServiceUnavailable) {
+		t.Errorf("Expected ErrServiceUnavailable, got %v", err)
+	}
+	if elapsed > 3*time.Second {
+		t.Errorf("Expected an unreachable service to fail within the connect timeout, took %v", elapsed)
+	}
+}
+
+func TestPythonServiceClient_TimesOutWaitingForResponse(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	service := createTestService(&mockStorageService{}, server.URL)
+	service.httpClient = newPythonServiceClient(time.Second, 200*time.Millisecond)
+
+	var result pythonCompareBatchResponse
+	if err := service.callPythonServicePost("/face/compare-batch", pythonCompareBatchRequest{}, &result); !errors.Is(err, ErrTimeout) {
+		t.Errorf("Expected ErrTimeout, got %v", err)
+	}
+}
+
+func TestRegisterBaseFace_StreamsLargeUpload(t *testing.T) {
+	pythonServer := newMockPythonServer(t)
+	service := createTestService(&mockStorageService{}, pythonServer.URL)
+
+	// Not a decodable image, so preprocessing passes it through unchanged
+	const uploadSize = 16 * 1024 * 1024
+	upload := bytes.NewReader(make([]byte, uploadSize))
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	if _, err := service.RegisterBaseFace("session-1", upload, DefaultPreprocessSteps, FaceModelSmall, false); err != nil {
+		t.Fatalf("RegisterBaseFace failed: %v", err)
+	}
+
+	runtime.ReadMemStats(&after)
+
+	// Buffering the upload or its base64 encoding would allocate at least the upload size
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > uploadSize/2 {
+		t.Errorf("Expected registering to stream the upload, but it allocated %d bytes for a %d byte upload", allocated, uploadSize)
+	}
+
+	if expected := int64(base64.StdEncoding.EncodedLen(uploadSize)); pythonServer.registeredBytes < expected {
+		t.Errorf("Expected at least %d bytes sent to the face service, got %d", expected, pythonServer.registeredBytes)
+	}
+}
+
+func TestRegisterBaseFaceFromItem(t *testing.T) {
+	pythonServer := newMockPythonServer(t)
+	storage := &mockStorageService{
+		images: []*models.CloudItem{
+			{ID: "photo", Name: "me.jpg", MimeType: "image/jpeg"},
+			{ID: "notes", Name: "notes.txt", MimeType: "text/plain"},
+		},
+		contents: map[string]string{"photo": string(encodeTestJPEG(t, 160, 120))},
+	}
+	service := createTestService(storage, pythonServer.URL)
+	token := &models.Token{AccessToken: "token", Provider: "googledrive"}
+
+	count, err := service.RegisterBaseFaceFromItem("session-1", &models.CloudItem{ID: "photo"}, token, DefaultPreprocessSteps, FaceModelSmall, false)
+	if err != nil {
+		t.Fatalf("RegisterBaseFaceFromItem failed: %v", err)
+	}
+	if count != 1 || pythonServer.registeredBytes == 0 {
+		t.Errorf("Expected the item to be registered as the only reference, got count %d and %d bytes sent", count, pythonServer.registeredBytes)
+	}
+
+	if _, err := service.RegisterBaseFaceFromItem("session-1", &models.CloudItem{ID: "missing"}, token, DefaultPreprocessSteps, FaceModelSmall, false); !errors.Is(err, ErrItemAccess) {
+		t.Errorf("Expected ErrItemAccess for an unknown item, got %v", err)
+	}
+	if _, err := service.RegisterBaseFaceFromItem("session-1", &models.CloudItem{ID: "notes"}, token, DefaultPreprocessSteps, FaceModelSmall, false); !errors.Is(err, ErrInvalidImageFormat) {
+		t.Errorf("Expected ErrInvalidImageFormat for a non-image item, got %v", err)
+	}
+
+	pythonServer.mu.Lock()
+	pythonServer.registerError = "Multiple faces detected"
+	pythonServer.mu.Unlock()
+	if _, err := service.RegisterBaseFaceFromItem("session-1", &models.CloudItem{ID: "photo"}, token, DefaultPreprocessSteps, FaceModelSmall, false); !errors.Is(err, ErrMultipleFaces) {
+		t.Errorf("Expected ErrMultipleFaces for a group photo, got %v", err)
+	}
+}
+
+func TestRegisterBaseFace_RejectsUnusableImages(t *testing.T) {
+	pythonServer := newMockPythonServer(t)
+	service := createTestService(&mockStorageService{}, pythonServer.URL)
+
+	// A HEIC file starts with an ftyp box naming its brand, this one holds nothing after it
+	heic := append([]byte{0, 0, 0, 24}, []byte("ftypheic\x00\x00\x00\x00mif1heic")...)
+
+	tests := []struct {
+		name    string
+		image   []byte
+		wantErr error
+	}{
+		{"unreadable HEIC", heic, ErrHEIFDecode},
+		{"too narrow", encodeTestJPEG(t, 60, 200), ErrImageTooSmall},
+		{"too short", encodeTestJPEG(t, 200, 79), ErrImageTooSmall},
+	}
+
+	for _, tt := range tests {
+		_, err := service.RegisterBaseFace("session-1", bytes.NewReader(tt.image), DefaultPreprocessSteps, FaceModelSmall, false)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.wantErr, err)
+		}
+	}
+	if pythonServer.registeredBytes != 0 {
+		t.Errorf("Expected no unusable image to reach the face service, %d bytes were sent", pythonServer.registeredBytes)
+	}
+
+	if _, err := service.RegisterBaseFace("session-1", bytes.NewReader(encodeTestJPEG(t, 80, 80)), DefaultPreprocessSteps, FaceModelSmall, false); err != nil {
+		t.Errorf("Expected an 80x80 image to be registered, got %v", err)
+	}
+
+	photo, err := os.ReadFile("testdata/sample.heic")
+	if err != nil {
+		t.Fatalf("Failed to read the HEIC fixture: %v", err)
+	}
+	if _, err := service.RegisterBaseFace("session-1", bytes.NewReader(photo), DefaultPreprocessSteps, FaceModelSmall, false); err != nil {
+		t.Errorf("Expected a HEIC photo to be registered, got %v", err)
+	}
+}
+
+func TestCompareFolderImages_SendsAggregationAndReferences(t *testing.T) {
+	pythonServer := newMockPythonServer(t)
+	closest := 1
+	pythonServer.jobMatches = map[string][]pythonMatchResult{
+		"py-job-1": {{Index: 1, Distance: 0.3, Reference: &closest}},
+	}
+	storage := &mockStorageService{
+		images: []*models.CloudItem{
+			{ID: "img-0", Name: "a.jpg", MimeType: "image/jpeg"},
+			{ID: "img-1", Name: "b.jpg", MimeType: "image/jpeg"},
+		},
+	}
+	service := createTestService(storage, pythonServer.URL)
+
+	token := &models.Token{AccessToken: "token", Provider: "googledrive"}
+	jobID, err := service.CompareFolderImages("session-1", "https://drive.google.com/drive/folders/abc", token, compareOptions{preprocess: DefaultPreprocessSteps, aggregation: AggregationMean, model: FaceModelSmall})
+	if err != nil {
+		t.Fatalf("CompareFolderImages failed: %v", err)
+	}
+
+	waitForJobStatus(t, service, jobID, JobStatusCompleted)
+
+	batches := pythonServer.submittedBatches()
+	if len(batches) != 1 || batches[0].Aggregation != AggregationMean {
+		t.Fatalf("Expected one batch with mean aggregation, got %+v", batches)
+	}
+
+	status, err := service.GetJobStatus(jobID, false, MatchPage{})
+	if err != nil {
+		t.Fatalf("GetJobStatus failed: %v", err)
+	}
+	if len(status.Matches) != 1 || status.Matches[0].MatchReference == nil || *status.Matches[0].MatchReference != 1 {
+		t.Errorf("Expected img-1 to match reference 1, got %+v", status.Matches)
+	}
+}
+
+func TestCompareFolderImages_AppliesMaxDistance(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxDistance float64
+		wantMatches []string
+	}{
+		{"default threshold", 0, []string{"img-0", "img-1"}},
+		{"stricter threshold", 0.25, []string{"img-0"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pythonServer := newMockPythonServer(t)
+			// Matches beyond the threshold are reported, as if the Python service had ignored it
+			pythonServer.jobMatches = map[string][]pythonMatchResult{
+				"py-job-1": {{Index: 0, Distance: 0.1}, {Index: 1, Distance: 0.4}},
+			}
+			storage := &mockStorageService{
+				images: []*models.CloudItem{
+					{ID: "img-0", Name: "a.jpg", MimeType: "image/jpeg"},
+					{ID: "img-1", Name: "b.jpg", MimeType: "image/jpeg"},
+				},
+			}
+			service := createTestService(storage, pythonServer.URL)
+
+			token := &models.Token{AccessToken: "token", Provider: "googledrive"}
+			jobID, err := service.CompareFolderImages("session-1", "https://drive.google.com/drive/folders/abc", token, compareOptions{threshold: tt.maxDistance, preprocess: DefaultPreprocessSteps, aggregation: AggregationAny, model: FaceModelSmall})
+			if err != nil {
+				t.Fatalf("CompareFolderImages failed: %v", err)
+			}
+
+			waitForJobStatus(t, service, jobID, JobStatusCompleted)
+
+			batches := pythonServer.submittedBatches()
+			if len(batches) != 1 || batches[0].Threshold != tt.maxDistance {
+				t.Fatalf("Expected one batch with threshold %v, got %+v", tt.maxDistance, batches)
+			}
+
+			status, err := service.GetJobStatus(jobID, false, MatchPage{})
+			if err != nil {
+				t.Fatalf("GetJobStatus failed: %v", err)
+			}
+
+			var matchedIDs []string
+			for _, match := range status.Matches {
+				matchedIDs = append(matchedIDs, match.ID)
+			}
+			if strings.Join(matchedIDs, ",") != strings.Join(tt.wantMatches, ",") {
+				t.Errorf("Expected matches %v, got %v", tt.wantMatches, matchedIDs)
+			}
+		})
+	}
+}
+
+func TestParseAggregation(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Aggregation
+		wantErr bool
+	}{
+		{"", AggregationAny, false},
+		{"any", AggregationAny, false},
+		{" Mean ", AggregationMean, false},
+		{"median", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseAggregation(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseAggregation(%q) = %q, %v; expected %q, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestRerunUnmatched_Errors(t *testing.T) {
+	service := createTestService(&mockStorageService{}, "")
+	images := []*models.CloudItem{{ID: "img-0"}}
+	token := &models.Token{AccessToken: "token", Provider: "onedrive"}
+
+	service.jobManager.Store("running-job", "session-1", images, token, compareOptions{})
+
+	if _, err := service.RerunUnmatched("session-1", "running-job", 0.8); !errors.Is(err, ErrJobNotCompleted) {
+		t.Errorf("Expected ErrJobNotCompleted, got: %v", err)
+	}
+
+	if _, err := service.RerunUnmatched("other-session", "running-job", 0.8); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Expected ErrJobNotFound for another session, got: %v", err)
+	}
+
+	service.jobManager.MarkCompleted("running-job", []pythonMatchResult{{Index: 0, Distance: 0.2}})
+	if _, err := service.RerunUnmatched("session-1", "running-job", 0.8); !errors.Is(err, ErrNothingToRerun) {
+		t.Errorf("Expected ErrNothingToRerun, got: %v", err)
+	}
+}
+
+func TestCompareDriveImages_UsesImageCap(t *testing.T) {
+	pythonServer := newMockPythonServer(t)
+	storage := &mockStorageService{}
+	for i := 0; i < 5; i++ {
+		storage.images = append(storage.images, &models.CloudItem{ID: fmt.Sprintf("img-%d", i), Name: "img.jpg"})
+	}
+
+	service := createTestService(storage, pythonServer.URL)
+	service.maxImagesPerJob = 3
+
+	token := &models.Token{AccessToken: "token", Provider: "googledrive"}
+	jobID, err := service.CompareDriveImages("session-1", token, FaceModelSmall)
+	if err != nil {
+		t.Fatalf("CompareDriveImages failed: %v", err)
+	}
+
+	if storage.driveLimit != 3 {
+		t.Errorf("Expected drive listing limit 3, got %d", storage.driveLimit)
+	}
+
+	ctx, _ := service.jobManager.Get(jobID)
+	if len(ctx.allImages) != 3 {
+		t.Errorf("Expected 3 images in job, got %d", len(ctx.allImages))
+	}
+
+	waitForJobStatus(t, service, jobID, JobStatusCompleted)
+}
+
+func TestCompareFolderImages_ReportsListingWarnings(t *testing.T) {
+	pythonServer := newMockPythonServer(t)
+	storage := &mockStorageService{
+		images:       []*models.CloudItem{{ID: "img-0", Name: "0.jpg"}},
+		listWarnings: []string{"Maximum folder depth 3 reached, deeper subfolders were skipped"},
+	}
+	service := createTestService(storage, pythonServer.URL)
+
+	token := &models.Token{AccessToken: "token", Provider: "googledrive"}
+	jobID, err := service.CompareFolderImages("session-1", "https://drive.google.com/drive/folders/abc", token, compareOptions{preprocess: DefaultPreprocessSteps, aggregation: AggregationAny, model: FaceModelSmall, recursive: true})
+	if err != nil {
+		t.Fatalf("CompareFolderImages failed: %v", err)
+	}
+
+	status, err := service.GetJobStatus(jobID, false, MatchPage{})
+	if err != nil {
+		t.Fatalf("GetJobStatus failed: %v", err)
+	}
+
+	if len(status.Warnings) != 1 || status.Warnings[0] != storage.listWarnings[0] {
+		t.Errorf("Expected listing warning on the job, got %v", status.Warnings)
+	}
+
+	waitForJobStatus(t, service, jobID, JobStatusCompleted)
+}
+
+func TestCompareFolderImages_IncludeAllFiles(t *testing.T) {
+	pythonServer := newMockPythonServer(t)
+	storage := &mockStorageService{
+		images:     []*models.CloudItem{{ID: "img-0", Name: "0.jpg"}, {ID: "img-1", Name: "1.jpg"}},
+		otherFiles: []*models.CloudItem{{ID: "doc-0", Name: "notes.txt"}},
+	}
+	service := createTestService(storage, pythonServer.URL)
+	token := &models.Token{AccessToken: "token", Provider: "googledrive"}
+
+	jobID, err := service.CompareFolderImages("session-1", "https://drive.google.com/drive/folders/abc", token, compareOptions{preprocess: DefaultPreprocessSteps, aggregation: AggregationAny, model: FaceModelSmall, includeAllFiles: true})
+	if err != nil {
+		t.Fatalf("CompareFolderImages failed: %v", err)
+	}
+	waitForJobStatus(t, service, jobID, JobStatusCompleted)
+
+	status, err := service.GetJobStatus(jobID, false, MatchPage{})
+	if err != nil {
+		t.Fatalf("GetJobStatus failed: %v", err)
+	}
+	if status.Listing == nil {
+		t.Fatal("Expected listing in completed response")
+	}
+	if len(status.Listing.Images) != 2 || len(status.Listing.OtherFiles) != 1 || status.Listing.OtherFiles[0].ID != "doc-0" {
+		t.Errorf("Expected 2 images and doc-0, got %d images and %v", len(status.Listing.Images), status.Listing.OtherFiles)
+	}
+
+	// The listing is left out unless requested
+	jobID, err = service.CompareFolderImages("session-1", "https://drive.google.com/drive/folders/abc", token, compareOptions{preprocess: DefaultPreprocessSteps, aggregation: AggregationAny, model: FaceModelSmall})
+	if err != nil {
+		t.Fatalf("CompareFolderImages failed: %v", err)
+	}
+	waitForJobStatus(t, service, jobID, JobStatusCompleted)
+
+	status, err = service.GetJobStatus(jobID, false, MatchPage{})
+	if err != nil {
+		t.Fatalf("GetJobStatus failed: %v", err)
+	}
+	if status.Listing != nil {
+		t.Errorf("Expected no listing by default, got %+v", status.Listing)
+	}
+}
+
+func TestSaveResult_PersistsAndResolvesManifest(t *testing.T) {
+	pythonServer := newMockPythonServer(t)
+	pythonServer.matchFirstImage = true
+	storage := &mockStorageService{
+		images: []*models.CloudItem{
+			{ID: "img-0", Name: "0.jpg", ThumbnailURL: "https://thumbnails.example/stale/img-0"},
+			{ID: "img-1", Name: "1.jpg"},
+		},
+	}
+	service := createTestService(storage, pythonServer.URL)
+	token := &models.Token{AccessToken: "token", Provider: "googledrive"}
+
+	folderLink := "https://drive.google.com/drive/folders/abc"
+	jobID, err := service.CompareFolderImages("session-1", folderLink, token, compareOptions{preprocess: DefaultPreprocessSteps, aggregation: AggregationAny, model: FaceModelSmall})
+	if err != nil {
+		t.Fatalf("CompareFolderImages failed: %v", err)
+	}
+	waitForJobStatus(t, service, jobID, JobStatusCompleted)
+
+	if _, err := service.SaveResult("session-2", jobID); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Expected ErrJobNotFound saving another session's job, got %v", err)
+	}
+
+	saved, err := service.SaveResult("session-1", jobID)
+	if err != nil {
+		t.Fatalf("SaveResult failed: %v", err)
+	}
+	if saved.Token == "" || !saved.ExpiresAt.After(time.Now()) {
+		t.Errorf("Expected a token with a future expiry, got %q expiring %v", saved.Token, saved.ExpiresAt)
+	}
+
+	// Another session can't read the result, and gets the same error as for an unknown token
+	if _, err := service.GetResultManifest("session-2", saved.Token); !errors.Is(err, ErrResultNotFound) {
+		t.Errorf("Expected ErrResultNotFound for another session, got %v", err)
+	}
+	if _, err := service.GetResultManifest("session-1", "unknown"); !errors.Is(err, ErrResultNotFound) {
+		t.Errorf("Expected ErrResultNotFound for an unknown token, got %v", err)
+	}
+
+	manifest, err := service.GetResultManifest("session-1", saved.Token)
+	if err != nil {
+		t.Fatalf("GetResultManifest failed: %v", err)
+	}
+	if manifest.FolderLink != folderLink || manifest.Provider != "googledrive" {
+		t.Errorf("Expected folder link and provider to be saved, got %q and %q", manifest.FolderLink, manifest.Provider)
+	}
+	if len(manifest.Matches) != 1 || manifest.Matches[0].ItemID != "img-0" || manifest.Matches[0].Distance != 0.1 {
+		t.Fatalf("Expected manifest to reference img-0 at distance 0.1, got %+v", manifest.Matches)
+	}
+
+	result := service.ResolveResult(manifest, token)
+	if len(result.Matches) != 1 || result.Matches[0].ThumbnailURL != "https://thumbnails.example/fresh/img-0" {
+		t.Fatalf("Expected img-0 with a fresh thumbnail URL, got %+v", result.Matches)
+	}
+	if result.Matches[0].MatchDistance == nil || *result.Matches[0].MatchDistance != 0.1 {
+		t.Errorf("Expected match distance 0.1, got %v", result.Matches[0].MatchDistance)
+	}
+
+	// Items the provider no longer returns are reported instead of failing the whole result
+	storage.images = storage.images[1:]
+	result = service.ResolveResult(manifest, token)
+	if len(result.Matches) != 0 || len(result.Unavailable) != 1 || result.Unavailable[0] != "0.jpg" {
+		t.Errorf("Expected 0.jpg to be unavailable, got matches %v and unavailable %v", result.Matches, result.Unavailable)
+	}
+}
+
+func TestSaveResult_RequiresCompletedJob(t *testing.T) {
+	service := createTestService(&mockStorageService{}, "")
+
+	service.jobManager.Store("job-1", "session-1", nil, &models.Token{Provider: "googledrive"}, compareOptions{})
+
+	if _, err := service.SaveResult("session-1", "job-1"); !errors.Is(err, ErrJobNotCompleted) {
+		t.Errorf("Expected ErrJobNotCompleted, got %v", err)
+	}
+	if _, err := service.SaveResult("session-1", "missing"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Expected ErrJobNotFound, got %v", err)
+	}
+}
+
+func TestSaveResult_ExpiresWithServiceClock(t *testing.T) {
+	fakeClock := clock.NewFake(time.Now())
+	service := createTestService(&mockStorageService{}, "")
+	service.jobManager = NewJobManagerWithClock(fakeClock)
+	service.resultStore = NewMemoryResultStoreWithClock(fakeClock)
+
+	images := []*models.CloudItem{{ID: "img-0"}, {ID: "img-1"}}
+	service.jobManager.Store("job-1", "session-1", images, &models.Token{Provider: "googledrive"}, compareOptions{})
+	service.jobManager.MarkCompleted("job-1", []pythonMatchResult{{Index: 1}})
+
+	saved, err := service.SaveResult("session-1", "job-1")
+	if err != nil {
+		t.Fatalf("SaveResult failed: %v", err)
+	}
+	if !saved.ExpiresAt.Equal(fakeClock.Now().Add(service.resultTTL)) {
+		t.Errorf("Expected the result to expire one TTL from the service clock, got %v", saved.ExpiresAt)
+	}
+
+	fakeClock.Advance(service.resultTTL - time.Second)
+	if _, err := service.GetResultManifest("session-1", saved.Token); err != nil {
+		t.Errorf("Expected the result to be readable within its TTL, got %v", err)
+	}
+
+	fakeClock.Advance(2 * time.Second)
+	if _, err := service.GetResultManifest("session-1", saved.Token); !errors.Is(err, ErrResultNotFound) {
+		t.Errorf("Expected ErrResultNotFound once the result's TTL passed, got %v", err)
+	}
+}
+
+func TestWithIdempotencyKey_ReturnsExistingJob(t *testing.T) {
+	service := createTestService(&mockStorageService{}, "")
+
+	var mu sync.Mutex
+	starts := 0
+	start := func() (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		starts++
+		time.Sleep(10 * time.Millisecond)
+		return fmt.Sprintf("job-%d", starts), nil
+	}
+
+	// Concurrent retries with the same key share the first job
+	var wg sync.WaitGroup
+	jobIDs := make([]string, 5)
+	for i := range jobIDs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			jobID, err := service.WithIdempotencyKey("session-1", "key-1", start)
+			if err != nil {
+				t.Errorf("WithIdempotencyKey failed: %v", err)
+			}
+			jobIDs[i] = jobID
+		}()
+	}
+	wg.Wait()
+
+	for _, jobID := range jobIDs {
+		if jobID != "job-1" {
+			t.Errorf("Expected every retry to get job-1, got %v", jobIDs)
+			break
+		}
+	}
+
+	// The same key in another session, a different key, or no key start new jobs
+	service.WithIdempotencyKey("session-2", "key-1", start)
+	service.WithIdempotencyKey("session-1", "key-2", start)
+	service.WithIdempotencyKey("session-1", "", start)
+
+	if starts != 4 {
+		t.Errorf("Expected 4 jobs to be started, got %d", starts)
+	}
+}
+
+func TestWithIdempotencyKey_ForgetsFailuresAndExpiredKeys(t *testing.T) {
+	service := createTestService(&mockStorageService{}, "")
+
+	failing := func() (string, error) { return "", ErrFolderAccess }
+	if _, err := service.WithIdempotencyKey("session-1", "key-1", failing); !errors.Is(err, ErrFolderAccess) {
+		t.Fatalf("Expected ErrFolderAccess, got: %v", err)
+	}
+
+	jobID, err := service.WithIdempotencyKey("session-1", "key-1", func() (string, error) { return "job-retry", nil })
+	if err != nil || jobID != "job-retry" {
+		t.Errorf("Expected a retry after failure to start a new job, got %q, %v", jobID, err)
+	}
+
+	service.idempotencyKeyTTL = 0
+	service.WithIdempotencyKey("session-1", "key-expiring", func() (string, error) { return "job-old", nil })
+
+	jobID, _ = service.WithIdempotencyKey("session-1", "key-expiring", func() (string, error) { return "job-new", nil })
+	if jobID != "job-new" {
+		t.Errorf("Expected an expired key to start a new job, got %q", jobID)
+	}
+}
+
+func TestCompareFolderImages_RejectsTooManyImages(t *testing.T) {
+	storage := &mockStorageService{}
+	for i := 0; i < 5; i++ {
+		storage.images = append(storage.images, &models.CloudItem{ID: fmt.Sprintf("img-%d", i)})
+	}
+
+	service := createTestService(storage, "")
+	service.maxImagesPerJob = 3
+
+	token := &models.Token{AccessToken: "token", Provider: "googledrive"}
+	_, err := service.CompareFolderImages("session-1", "https://drive.google.com/drive/folders/abc", token, compareOptions{preprocess: DefaultPreprocessSteps, aggregation: AggregationAny, model: FaceModelSmall})
+	if !errors.Is(err, ErrTooManyImages) {
+		t.Errorf("Expected ErrTooManyImages, got: %v", err)
+	}
+}
+
+func TestImageMimeTypes_MatchStorageListing(t *testing.T) {
+	t.Setenv("FACE_MIME_TYPES", "image/png, IMAGE/X-Custom")
+
+	storageService := storage.NewService(nil, nil, nil)
+	service := createTestService(storageService, "")
+
+	candidates := []string{"image/png", "image/x-custom", "Image/PNG; charset=binary", "image/jpeg", "image/heic", "text/plain"}
+	for _, mimeType := range candidates {
+		listed := storageService.IsImage(&models.CloudItem{MimeType: mimeType})
+
+		header := &multipart.FileHeader{Size: 10, Header: textproto.MIMEHeader{"Content-Type": {mimeType}}}
+		accepted := validateImageFile(header, service.ImageMimeTypes()) == nil
+
+		if listed != accepted {
+			t.Errorf("Expected %q to be treated the same by listing and upload, listed=%v accepted=%v", mimeType, listed, accepted)
+		}
+	}
+
+	if !storageService.IsImage(&models.CloudItem{MimeType: "image/x-custom"}) {
+		t.Error("Expected configured image/x-custom to be accepted")
+	}
+	if storageService.IsImage(&models.CloudItem{MimeType: "image/jpeg"}) {
+		t.Error("Expected image/jpeg to be rejected when not configured")
+	}
+}
+
+// mockStorageService is a test implementation of StorageService
+type mockStorageService struct {
+	mu           sync.Mutex
+	images       []*models.CloudItem
+	parseCalls   int
+	listedFolder *models.CloudItem
+	driveLimit   int
+
+	downloadDelay   time.Duration
+	activeDownloads int
+	maxActive       int
+	downloads       int // Image downloads started
+
+	contents       map[string]string // item ID -> image content, defaults to "image-<ID>"
+	downloadErrors map[string]bool   // item IDs whose image download fails
+	flakyDownloads map[string]int    // item ID -> number of attempts whose download fails before one succeeds
+	missing        map[string]bool   // item IDs the provider reports as not found
+	signedOut      map[string]bool   // item IDs the provider refuses as the token is no longer valid
+	attempts       map[string]int    // item ID -> image downloads attempted
+	fileDownloads  int               // Full file downloads, as made for documents
+	listWarnings   []string
+	otherFiles     []*models.CloudItem
+
+	copied     []string        // IDs of items copied into a folder, in order
+	copyErrors map[string]bool // item IDs whose copy fails
+
+	uploads      map[string]string // File name -> content of files uploaded into a folder
+	uploadErrors map[string]bool   // File names whose upload fails
+}
+
+func (m *mockStorageService) ParseShareLink(shareURL string, token *models.Token) (*models.CloudItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.parseCalls++
+	return &models.CloudItem{ID: "parsed-folder", IsFolder: true, Provider: token.Provider}, nil
+}
+
+func (m *mockStorageService) ListImages(item *models.CloudItem, token *models.Token, recursive bool, dates models.DateRange) ([]*models.CloudItem, []string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.listedFolder = item
+	return m.images, m.listWarnings, nil
+}
+
+func (m *mockStorageService) ListNonImageFiles(item *models.CloudItem, token *models.Token, recursive bool) ([]*models.CloudItem, error) {
+	return m.otherFiles, nil
+}
+
+// GetItem returns a copy of the listed image with a fresh thumbnail URL, or an error if it is not listed
+func (m *mockStorageService) GetItem(item *models.CloudItem, token *models.Token) (*models.CloudItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, image := range m.images {
+		if image.ID == item.ID {
+			resolved := *image
+			resolved.ThumbnailURL = "https://thumbnails.example/fresh/" + image.ID
+			return &resolved, nil
+		}
+	}
+	return nil, errors.New("item not found")
+}
+
+// GetAccountID derives the account from the access token, so tokens with the same access token share an account
+func (m *mockStorageService) GetAccountID(token *models.Token) (string, error) {
+	return token.Provider + ":account-" + token.AccessToken, nil
+}
+
+// CopyToFolder records the copied item, failing for items listed in copyErrors
+func (m *mockStorageService) CopyToFolder(item *models.CloudItem, destination *models.CloudItem, token *models.Token) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.copyErrors[item.ID] {
+		return errors.New("insufficient permissions")
+	}
+	m.copied = append(m.copied, item.ID)
+	return nil
+}
+
+// UploadFile records the uploaded content, failing for names listed in uploadErrors
+func (m *mockStorageService) UploadFile(destination *models.CloudItem, name, mimeType string, content []byte, token *models.Token) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.uploadErrors[name] {
+		return errors.New("insufficient permissions")
+	}
+	if m.uploads == nil {
+		m.uploads = make(map[string]string)
+	}
+	m.uploads[name] = string(content)
+	return nil
+}
+
+func (m *mockStorageService) ListAllImages(token *models.Token, limit int) ([]*models.CloudItem, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.driveLimit = limit
+	if limit > 0 && len(m.images) > limit {
+		return m.images[:limit], nil
+	}
+	return m.images, nil
+}
+
+func (m *mockStorageService) GetFileStream(item *models.CloudItem, token *models.Token) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.fileDownloads++
+	return io.NopCloser(strings.NewReader(m.contents[item.ID])), nil
+}
+
+func (m *mockStorageService) GetFaceRecognitionOptimizedStream(item *models.CloudItem, token *models.Token) (io.ReadCloser, error) {
+	m.mu.Lock()
+	m.downloads++
+	m.activeDownloads++
+	if m.activeDownloads > m.maxActive {
+		m.maxActive = m.activeDownloads
+	}
+	if m.attempts == nil {
+		m.attempts = make(map[string]int)
+	}
+	m.attempts[item.ID]++
+	attempt := m.attempts[item.ID]
+	m.mu.Unlock()
+
+	time.Sleep(m.downloadDelay)
+
+	m.mu.Lock()
+	m.activeDownloads--
+	m.mu.Unlock()
+
+	if m.downloadErrors[item.ID] {
+		return nil, errors.New("file is corrupt")
+	}
+	if m.missing[item.ID] {
+		return nil, models.NewProviderError("googledrive", http.StatusNotFound, models.ProviderErrorNotFound)
+	}
+	if m.signedOut[item.ID] {
+		return nil, models.NewProviderError("googledrive", http.StatusUnauthorized, models.ProviderErrorAuthExpired)
+	}
+	if attempt <= m.flakyDownloads[item.ID] {
+		return nil, errors.New("connection reset by peer")
+	}
+
+	content, exists := m.contents[item.ID]
+	if !exists {
+		content = "image-" + item.ID
+	}
+
+	return io.NopCloser(bytes.NewReader([]byte(content))), nil
+}
+
+// startedDownloads returns how many image downloads have started so far
+func (m *mockStorageService) startedDownloads() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.downloads
+}
+
+// mockTokenSource returns token for every session
+type mockTokenSource struct {
+	token *models.Token
+}
+
+func (m *mockTokenSource) GetSessionToken(sessionID, provider, accountID string) (*models.Token, error) {
+	return m.token, nil
+}
+
+func TestFreshToken_ReplacesTokenNearExpiry(t *testing.T) {
+	refreshed := &models.Token{AccessToken: "access-2", Provider: "onedrive", ExpiresAt: time.Now().Add(time.Hour)}
+	service := NewService(&mockStorageService{}, &mockTokenSource{token: refreshed})
+
+	valid := &models.Token{AccessToken: "access-1", Provider: "onedrive", RefreshToken: "refresh", ExpiresAt: time.Now().Add(time.Hour)}
+	if got := service.freshToken("session-1", valid); got != valid {
+		t.Errorf("Expected a token far from expiry to be kept, got %+v", got)
+	}
+
+	expiring := &models.Token{AccessToken: "access-1", Provider: "onedrive", RefreshToken: "refresh", ExpiresAt: time.Now().Add(time.Minute)}
+	if got := service.freshToken("session-1", expiring); got != refreshed {
+		t.Errorf("Expected the session's current token near expiry, got %+v", got)
+	}
+
+	unrefreshable := &models.Token{AccessToken: "access-1", Provider: "onedrive", ExpiresAt: time.Now().Add(time.Minute)}
+	if got := service.freshToken("session-1", unrefreshable); got != unrefreshable {
+		t.Errorf("Expected a token without a refresh token to be kept, got %+v", got)
+	}
+}
+
+func TestCancelJob_StopsDownloadsAndReportsCancelled(t *testing.T) {
+	pythonServer := newMockPythonServer(t)
+	storage := &mockStorageService{downloadDelay: 20 * time.Millisecond}
+	service := createTestService(storage, pythonServer.URL)
+	service.workers = workers.NewPool(2)
+
+	images := make([]*models.CloudItem, 250)
+	for i := range images {
+		images[i] = &models.CloudItem{ID: fmt.Sprintf("img-%d", i), Name: fmt.Sprintf("img-%d.jpg", i)}
+	}
+
+	token := &models.Token{AccessToken: "token", Provider: "googledrive"}
+	jobID, err := service.processFolderInBatches("session-1", images, token, compareOptions{})
+	if err != nil {
+		t.Fatalf("processFolderInBatches failed: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for storage.startedDownloads() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if err := service.CancelJob("session-1", jobID); err != nil {
+		t.Fatalf("CancelJob failed: %v", err)
+	}
+
+	// Downloads already under way finish, the remaining images are skipped
+	time.Sleep(100 * time.Millisecond)
+	downloads := storage.startedDownloads()
+	time.Sleep(100 * time.Millisecond)
+	if storage.startedDownloads() != downloads || downloads >= len(images) {
+		t.Errorf("Expected downloads to stop after cancelling, got %d then %d of %d", downloads, storage.startedDownloads(), len(images))
+	}
+	if batches := pythonServer.submittedBatches(); len(batches) != 0 {
+		t.Errorf("Expected no batch to be sent after cancelling, got %d", len(batches))
+	}
+	if inUse := service.memoryBudget.inUse(); inUse != 0 {
+		t.Errorf("Expected the cancelled job to release its memory budget, %d bytes still reserved", inUse)
+	}
+
+	status, err := service.GetJobStatus(jobID, false, MatchPage{})
+	if err != nil {
+		t.Fatalf("GetJobStatus failed: %v", err)
+	}
+	if status.Status != JobStatusCancelled {
+		t.Errorf("Expected status %q, got %q", JobStatusCancelled, status.Status)
+	}
+
+	// Like failed jobs, a cancelled job is removed once its status has been reported
+	if err := service.CancelJob("session-1", jobID); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Expected ErrJobNotFound once the cancelled job was reported, got %v", err)
+	}
+}
+
+func TestCancelJob_OtherSessionsJobNotFound(t *testing.T) {
+	service := createTestService(&mockStorageService{}, "")
+	service.jobManager.Store("job-1", "session-1", []*models.CloudItem{{ID: "img-0"}}, nil, compareOptions{})
+
+	if err := service.CancelJob("session-2", "job-1"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Expected ErrJobNotFound when cancelling another session's job, got %v", err)
+	}
+	if ctx, _ := service.jobManager.Get("job-1"); ctx.status != JobStatusProcessing {
+		t.Errorf("Expected another session's job to keep running, got %s", ctx.status)
+	}
+
+	if err := service.CancelJob("session-1", "job-1"); err != nil {
+		t.Errorf("Expected the job's own session to cancel it, got %v", err)
+	}
+}
+
+func TestWaitForBatchSlot_WaitsForRunningBatches(t *testing.T) {
+	pythonServer := newMockPythonServer(t)
+	pythonServer.jobStatuses = map[string]string{"py-a": "processing", "py-b": "processing"}
+	service := createTestService(&mockStorageService{}, pythonServer.URL)
+	service.statusPollInterval = time.Millisecond
+	service.maxConcurrentBatches = 2
+
+	// A finished batch frees its slot
+	running, err := service.waitForBatchSlot(context.Background(), []string{"py-a", "py-c"})
+	if err != nil || !slices.Equal(running, []string{"py-a"}) {
+		t.Fatalf("Expected only py-a to be left running, got %v (%v)", running, err)
+	}
+
+	// Below the limit nothing is polled
+	if running, err := service.waitForBatchSlot(context.Background(), []string{"py-a"}); err != nil || len(running) != 1 {
+		t.Errorf("Expected a free slot right away, got %v (%v)", running, err)
+	}
+
+	// With every slot taken, only cancelling the job ends the wait
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := service.waitForBatchSlot(ctx, []string{"py-a", "py-b"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the wait to end with the context, got %v", err)
+	}
+}
+
+func TestGetJobStatus_ReturnsMatchesOfFinishedBatches(t *testing.T) {
+	pythonServer := newMockPythonServer(t)
+	pythonServer.jobStatuses = map[string]string{"py-b": "processing"}
+	pythonServer.jobMatches = map[string][]pythonMatchResult{
+		"py-a": {{Index: 1, Distance: 0.2, Confidence: 0.97}},
+		"py-b": {{Index: 0, Distance: 0.3, Confidence: 0.96}},
+	}
+	service := createTestService(&mockStorageService{}, pythonServer.URL)
+	service.statusPollInterval = time.Millisecond
+
+	images := []*models.CloudItem{{ID: "img-0"}, {ID: "img-1"}, {ID: "img-2"}, {ID: "img-3"}}
+	ctx := service.jobManager.Store("job-1", "session-1", images, nil, compareOptions{})
+
+	done := make(chan struct{})
+	go func() {
+		service.aggregateBatchResults(ctx, "job-1", []string{"py-a", "py-b"}, [][]int{{0, 1}, {2, 3}}, nil, len(images), 0)
+		close(done)
+	}()
+
+	matchIDs := func() (JobStatus, []string) {
+		status, err := service.GetJobStatus("job-1", false, MatchPage{})
+		if err != nil {
+			t.Fatalf("GetJobStatus failed: %v", err)
+		}
+		var ids []string
+		for _, match := range status.Matches {
+			ids = append(ids, match.ID)
+		}
+		return status.Status, ids
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	status, ids := matchIDs()
+	for len(ids) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+		status, ids = matchIDs()
+	}
+	if status != JobStatusProcessing || !slices.Equal(ids, []string{"img-1"}) {
+		t.Fatalf("Expected the first batch's match while processing, got %s %v", status, ids)
+	}
+
+	pythonServer.mu.Lock()
+	delete(pythonServer.jobStatuses, "py-b")
+	pythonServer.mu.Unlock()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the job to complete")
+	}
+
+	if status, ids := matchIDs(); status != JobStatusCompleted || !slices.Equal(ids, []string{"img-1", "img-2"}) {
+		t.Errorf("Expected each match once in image order, got %s %v", status, ids)
+	}
+
+	response, _ := service.GetJobStatus("job-1", false, MatchPage{})
+	if confidence := response.Matches[0].MatchConfidence; confidence == nil || *confidence != 0.97 {
+		t.Errorf("Expected the face service's confidence on the match, got %v", confidence)
+	}
+}
+
+func TestAggregateBatchResults_TimesOutWithServiceClock(t *testing.T) {
+	pythonServer := newMockPythonServer(t)
+	pythonServer.jobStatuses = map[string]string{"py-a": "processing"}
+	fakeClock := clock.NewFake(time.Now())
+	service := createTestService(&mockStorageService{}, pythonServer.URL)
+	service.jobManager = NewJobManagerWithClock(fakeClock)
+	service.statusPollInterval = time.Millisecond
+	service.statusPollMaxInterval = time.Millisecond
+
+	ctx := service.jobManager.Store("job-1", "session-1", []*models.CloudItem{{ID: "img-0"}}, nil, compareOptions{})
+
+	done := make(chan struct{})
+	go func() {
+		service.aggregateBatchResults(ctx, "job-1", []string{"py-a"}, [][]int{{0}}, nil, 1, 0)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("Expected polling to continue before the processing timeout")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	fakeClock.Advance(processingTimeout)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected polling to stop once the processing timeout passed")
+	}
+
+	job, _ := service.jobManager.Snapshot("job-1")
+	if job.status != JobStatusFailed || job.errorMessage != "Processing timeout" {
+		t.Errorf("Expected the job to fail with a processing timeout, got %q: %q", job.status, job.errorMessage)
+	}
+}
+
+func TestProcessBatchesBackground_CancelsStartedBatchesOnFailure(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxBatches int
+		signedOut  map[string]bool
+	}{
+		{"later batch fails to download", 0, map[string]bool{"img-120": true}},
+		{"later batch fails to start", 1, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pythonServer := newMockPythonServer(t)
+			pythonServer.maxBatches = tt.maxBatches
+			pythonServer.jobStatuses = map[string]string{"py-job-1": "processing"}
+
+			var images []*models.CloudItem
+			for i := range 150 {
+				images = append(images, &models.CloudItem{ID: fmt.Sprintf("img-%d", i), Name: fmt.Sprintf("%d.jpg", i)})
+			}
+			service := createTestService(&mockStorageService{signedOut: tt.signedOut}, pythonServer.URL)
+
+			token := &models.Token{AccessToken: "token", Provider: "googledrive"}
+			jobID, err := service.processFolderInBatches("session-1", images, token, compareOptions{})
+			if err != nil {
+				t.Fatalf("processFolderInBatches failed: %v", err)
+			}
+			waitForJobStatus(t, service, jobID, JobStatusFailed)
+
+			pythonServer.mu.Lock()
+			defer pythonServer.mu.Unlock()
+			if !slices.Equal(pythonServer.cancelledJobs, []string{"py-job-1"}) {
+				t.Errorf("Expected the first batch to be cancelled, got %v", pythonServer.cancelledJobs)
+			}
+		})
+	}
+}
+
+func TestAggregateBatchResults_StopsPollingWhenCancelled(t *testing.T) {
+	pythonServer := newMockPythonServer(t)
+	service := createTestService(&mockStorageService{}, pythonServer.URL)
+
+	ctx := service.jobManager.Store("job-1", "session-1", []*models.CloudItem{{ID: "img-0"}}, nil, compareOptions{})
+	if err := service.CancelJob("session-1", "job-1"); err != nil {
+		t.Fatalf("CancelJob failed: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		service.aggregateBatchResults(ctx, "job-1", []string{"py-a"}, [][]int{{0}}, nil, 1, 0)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected polling to stop once the job was cancelled")
+	}
+
+	if job, _ := service.jobManager.Get("job-1"); job.status != JobStatusCancelled {
+		t.Errorf("Expected the job to stay cancelled, got %q", job.status)
+	}
+
+	pythonServer.mu.Lock()
+	defer pythonServer.mu.Unlock()
+	if !slices.Equal(pythonServer.cancelledJobs, []string{"py-a"}) {
+		t.Errorf("Expected the face service batch to be cancelled, got %v", pythonServer.cancelledJobs)
+	}
+}
@@ -0,0 +1,90 @@
+package face
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"mime/multipart"
+	"strconv"
+	"strings"
+)
+
+// BatchTransport selects how candidate images are sent to the Python service
+//
+// base64 (default) posts JSON to /face/compare-batch:
+//
+//	{"session_id": "...", "images": ["<base64>", ...], "threshold": 0.6, "aggregation": "any"}
+//
+// multipart posts multipart/form-data to /face/compare-batch-multipart, with a session_id field,
+// optional threshold and aggregation fields, and one binary "images" file part per image in order
+//
+// Both return the same {"job_id": "...", "status": "processing"} response, and match indices refer to
+// the order images were sent in. Multipart avoids the ~33% base64 overhead and the encoding work on
+// both sides, but needs a face service that supports it.
+type BatchTransport string
+
+const (
+	TransportBase64    BatchTransport = "base64"
+	TransportMultipart BatchTransport = "multipart"
+
+	// multipartBytesPerImage approximates the boundary and part headers around each image
+	multipartBytesPerImage = 128
+)
+
+// ParseBatchTransport parses a transport name, defaulting to base64
+func ParseBatchTransport(value string) (BatchTransport, error) {
+	switch BatchTransport(strings.ToLower(strings.TrimSpace(value))) {
+	case "", TransportBase64:
+		return TransportBase64, nil
+	case TransportMultipart:
+		return TransportMultipart, nil
+	default:
+		return "", fmt.Errorf("unknown batch transport %q, expected base64 or multipart", value)
+	}
+}
+
+// payloadSize returns roughly how many bytes an image takes in a request body sent with this transport
+func (t BatchTransport) payloadSize(image []byte) int {
+	if t == TransportMultipart {
+		return len(image) + multipartBytesPerImage
+	}
+	return base64.StdEncoding.EncodedLen(len(image)) + payloadBytesPerImage
+}
+
+// multipartBatchBody builds the multipart form for a compare batch, returning the body and its content type
+func multipartBatchBody(sessionID string, images [][]byte, opts compareOptions) (*bytes.Buffer, string, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	fields := map[string]string{"session_id": sessionID}
+	if opts.threshold > 0 {
+		fields["threshold"] = strconv.FormatFloat(opts.threshold, 'f', -1, 64)
+	}
+	if opts.aggregation != "" {
+		fields["aggregation"] = string(opts.aggregation)
+	}
+	if opts.model != "" {
+		fields["model"] = string(opts.model)
+	}
+	for name, value := range fields {
+		if err := writer.WriteField(name, value); err != nil {
+			return nil, "", err
+		}
+	}
+
+	for i, image := range images {
+		part, err := writer.CreateFormFile("images", fmt.Sprintf("image-%d", i))
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := part.Write(image); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, "", err
+	}
+
+	return &body, writer.FormDataContentType(), nil
+}
@@ -0,0 +1,57 @@
+// Package maintenance holds the switch that drains the server before a deploy: while it is on, new
+// comparison jobs and downloads are rejected and the ones already running finish
+package maintenance
+
+import (
+	"all-me-backend/pkg/config"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	shared     *Switch
+	sharedOnce sync.Once
+)
+
+// Switch tells whether new jobs are rejected; it can be flipped while the server runs, as the jobs
+// it drains only live in memory and would be lost by restarting to change it
+type Switch struct {
+	on atomic.Bool
+}
+
+// NewSwitch creates a switch that starts on or off
+func NewSwitch(on bool) *Switch {
+	s := &Switch{}
+	s.on.Store(on)
+	return s
+}
+
+// Shared returns the switch shared by every service accepting new jobs
+// It starts on when DISABLE_NEW_JOBS is set
+func Shared() *Switch {
+	sharedOnce.Do(func() {
+		shared = NewSwitch(config.GetBool("DISABLE_NEW_JOBS", false))
+	})
+
+	return shared
+}
+
+// On reports whether new jobs are currently rejected
+func (s *Switch) On() bool {
+	return s.on.Load()
+}
+
+// Set turns draining on or off
+func (s *Switch) Set(on bool) {
+	s.on.Store(on)
+}
+
+// Toggle flips the switch, returning whether it is now on
+func (s *Switch) Toggle() bool {
+	for {
+		current := s.on.Load()
+		if s.on.CompareAndSwap(current, !current) {
+			return !current
+		}
+	}
+}
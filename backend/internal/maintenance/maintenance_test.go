@@ -0,0 +1,19 @@
+package maintenance
+
+import "testing"
+
+func TestSwitch_Toggle(t *testing.T) {
+	s := NewSwitch(false)
+
+	if !s.Toggle() || !s.On() {
+		t.Error("Expected the switch to be on after toggling it once")
+	}
+	if s.Toggle() || s.On() {
+		t.Error("Expected the switch to be off after toggling it twice")
+	}
+
+	s.Set(true)
+	if !s.On() {
+		t.Error("Expected the switch to be on after setting it")
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package maintenance
+
+import "log"
+
+// ToggleOnSignal is unavailable without SIGUSR1; draining is then only set with DISABLE_NEW_JOBS
+func (s *Switch) ToggleOnSignal() {
+	log.Println("Toggling draining with SIGUSR1 is not supported on this platform")
+}
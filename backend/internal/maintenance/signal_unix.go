@@ -0,0 +1,27 @@
+//go:build unix
+
+package maintenance
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// ToggleOnSignal flips the switch each time the process receives SIGUSR1, so operators can start and
+// stop draining with `kill -USR1` without restarting
+func (s *Switch) ToggleOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+
+	go func() {
+		for range signals {
+			if s.Toggle() {
+				log.Println("Draining: new comparisons and downloads are rejected until the next SIGUSR1")
+			} else {
+				log.Println("Draining stopped: new comparisons and downloads are accepted again")
+			}
+		}
+	}()
+}
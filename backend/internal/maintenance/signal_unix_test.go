@@ -0,0 +1,26 @@
+//go:build unix
+
+package maintenance
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSwitch_ToggleOnSignal(t *testing.T) {
+	s := NewSwitch(false)
+	s.ToggleOnSignal()
+
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatalf("Failed to send SIGUSR1: %v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for !s.On() {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected SIGUSR1 to turn draining on")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -16,7 +16,8 @@ func CORSConfig() echo.MiddlewareFunc {
 		return middleware.CORSWithConfig(middleware.CORSConfig{
 			AllowOrigins:     []string{"http://localhost:4200", "http://localhost:3000"},
 			AllowMethods:     []string{echo.GET, echo.POST, echo.PUT, echo.DELETE, echo.OPTIONS},
-			AllowHeaders:     []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization},
+			AllowHeaders:     []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization, "If-None-Match", "Idempotency-Key"},
+			ExposeHeaders:    []string{"ETag"},
 			AllowCredentials: true,
 			MaxAge:           86400, // 24 hours
 		})
@@ -35,7 +36,8 @@ func CORSConfig() echo.MiddlewareFunc {
 	return middleware.CORSWithConfig(middleware.CORSConfig{
 		AllowOrigins:     allowedOrigins,
 		AllowMethods:     []string{echo.GET, echo.POST, echo.PUT, echo.DELETE, echo.OPTIONS},
-		AllowHeaders:     []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization},
+		AllowHeaders:     []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization, "If-None-Match", "Idempotency-Key"},
+		ExposeHeaders:    []string{"ETag"},
 		AllowCredentials: true,
 		MaxAge:           86400, // 24 hours
 	})
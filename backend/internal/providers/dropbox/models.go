@@ -0,0 +1,41 @@
+package dropbox
+
+// Metadata is a file or folder entry as returned by the Dropbox API
+// Entries listed through a shared link may leave out their path, as they can live outside the user's Dropbox
+type Metadata struct {
+	Tag            string `json:".tag"` // "file", "folder" or "deleted"
+	ID             string `json:"id"`   // "id:..."
+	Name           string `json:"name"`
+	PathLower      string `json:"path_lower,omitempty"`
+	ClientModified string `json:"client_modified,omitempty"` // RFC 3339, when the file was last changed on the uploading device
+	ServerModified string `json:"server_modified,omitempty"`
+	Size           int64  `json:"size,omitempty"`
+}
+
+type ListFolderResponse struct {
+	Entries []Metadata `json:"entries"`
+	Cursor  string     `json:"cursor"`
+	HasMore bool       `json:"has_more"`
+}
+
+// Account is the subset of users/get_current_account describing the signed-in user
+type Account struct {
+	AccountID string `json:"account_id"`
+	Name      struct {
+		DisplayName string `json:"display_name"`
+	} `json:"name"`
+	Email string `json:"email"`
+}
+
+// sharedLink identifies a shared link, and optionally a path within its folder, in API arguments
+type sharedLink struct {
+	URL  string `json:"url"`
+	Path string `json:"path,omitempty"`
+}
+
+// thumbnailResource selects the file a thumbnail is made of, by path or by shared link
+type thumbnailResource struct {
+	Tag  string `json:".tag"` // "path" or "link"
+	Path string `json:"path,omitempty"`
+	URL  string `json:"url,omitempty"`
+}
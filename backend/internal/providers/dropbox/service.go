@@ -0,0 +1,744 @@
+package dropbox
+
+import (
+	"all-me-backend/internal/providers/httptransport"
+	"all-me-backend/internal/providers/throttle"
+	"all-me-backend/pkg/config"
+	"all-me-backend/pkg/models"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+type Service struct {
+	httpClient *http.Client
+	apiURL     string // RPC endpoints, taking and returning JSON
+	contentURL string // Content endpoints, taking their arguments in the Dropbox-API-Arg header
+	config     *models.OAuthConfig
+}
+
+// defaultWriteScope lets saved matches be copied into the user's Dropbox
+const defaultWriteScope = "files.content.write"
+
+// Items are referenced by IDs that pass the backend's item ID checks:
+// items in the user's own Dropbox by their Dropbox ID without its "id:" prefix, or rootFolderID for the root;
+// items reached through a shared link by sharedPathPrefix and their base64url path within the shared folder,
+// with the link itself base64url encoded in DriveID after sharedLinkPrefix, as Dropbox only serves them through it
+const (
+	rootFolderID     = "root"
+	sharedPathPrefix = "p!"
+	sharedLinkPrefix = "u!"
+)
+
+// Thumbnail sizes requested from files/get_thumbnail_v2, fitted within the box
+const (
+	displayThumbnailSize         = "w480h320"  // Frontend display, about 400px
+	faceRecognitionThumbnailSize = "w1024h768" // Face recognition, about 800px
+)
+
+// maxListLimit is the most entries files/list_folder returns per call
+const maxListLimit = 2000
+
+// NewDropboxService creates a new Dropbox service
+func NewDropboxService() *Service {
+	scopes := []string{"account_info.read", "files.metadata.read", "files.content.read", "sharing.read"}
+	var writeScope string
+	if config.GetBool("SAVE_TO_DRIVE_ENABLED", false) {
+		writeScope = config.GetString("DROPBOX_WRITE_SCOPE", defaultWriteScope)
+		scopes = append(scopes, writeScope)
+	}
+
+	return &Service{
+		httpClient: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: throttle.NewTransport(throttle.ForProvider("dropbox"), httptransport.Shared()),
+		},
+		apiURL:     config.GetHTTPSURL("DROPBOX_API_URL", "https://api.dropboxapi.com/2"),
+		contentURL: config.GetHTTPSURL("DROPBOX_CONTENT_URL", "https://content.dropboxapi.com/2"),
+		// No RevokeURL: Dropbox revokes a token with the token itself as bearer, not with the form the auth
+		// service posts, so a signed-out token lapses when it expires within hours
+		config: &models.OAuthConfig{
+			ClientID:     os.Getenv("DROPBOX_CLIENT_ID"),
+			ClientSecret: os.Getenv("DROPBOX_CLIENT_SECRET"),
+			RedirectURI:  os.Getenv("DROPBOX_REDIRECT_URI"),
+			Scopes:       scopes,
+			WriteScope:   writeScope,
+			AuthURL:      config.GetHTTPSURL("DROPBOX_AUTH_URL", "https://www.dropbox.com/oauth2/authorize"),
+			TokenURL:     config.GetHTTPSURL("DROPBOX_TOKEN_URL", "https://api.dropboxapi.com/oauth2/token"),
+			Provider:     "dropbox",
+		},
+	}
+}
+
+// GetOAuthConfig returns the OAuth configuration for Dropbox
+func (s *Service) GetOAuthConfig() *models.OAuthConfig {
+	return s.config
+}
+
+// BuildAuthURL constructs the OAuth authorization URL for Dropbox
+func (s *Service) BuildAuthURL(state, codeChallenge string) (string, error) {
+	params := url.Values{}
+	params.Add("client_id", s.config.ClientID)
+	params.Add("redirect_uri", s.config.RedirectURI)
+	params.Add("response_type", "code")
+	params.Add("scope", strings.Join(s.config.Scopes, " "))
+	params.Add("state", state)
+	params.Add("code_challenge", codeChallenge)
+	params.Add("code_challenge_method", "S256")
+	// Offline access issues a refresh token, so long comparisons can outlive the short-lived access token
+	params.Add("token_access_type", "offline")
+
+	authURL := s.config.AuthURL + "?" + params.Encode()
+	return authURL, nil
+}
+
+// itemRef is where the Dropbox API finds an item
+type itemRef struct {
+	link string // Shared link the item is reached through, empty for items in the user's own Dropbox
+	path string // Path within the shared link's folder, or in the user's Dropbox: "" for the root, "id:..." by ID
+}
+
+// child returns the reference of an entry listed in the folder
+func (r itemRef) child(entry Metadata) itemRef {
+	if r.link == "" {
+		return itemRef{path: entry.ID}
+	}
+	return itemRef{link: r.link, path: r.path + "/" + entry.Name}
+}
+
+// resolveItem returns where the API finds an item, from the ID and DriveID it was listed with
+func resolveItem(item *models.CloudItem) (itemRef, error) {
+	if item.DriveID != "" {
+		encodedLink, ok := strings.CutPrefix(item.DriveID, sharedLinkPrefix)
+		link, err := base64.RawURLEncoding.DecodeString(encodedLink)
+		if !ok || err != nil {
+			return itemRef{}, errors.New("invalid Dropbox shared link reference")
+		}
+		if _, err := parseDropboxURL(string(link)); err != nil {
+			return itemRef{}, err
+		}
+
+		encodedPath, ok := strings.CutPrefix(item.ID, sharedPathPrefix)
+		itemPath, err := base64.RawURLEncoding.DecodeString(encodedPath)
+		if !ok || err != nil {
+			return itemRef{}, errors.New("invalid Dropbox item ID")
+		}
+
+		return itemRef{link: string(link), path: string(itemPath)}, nil
+	}
+
+	switch item.ID {
+	case "":
+		return itemRef{}, errors.New("invalid Dropbox item ID")
+	case rootFolderID:
+		return itemRef{}, nil
+	default:
+		return itemRef{path: "id:" + item.ID}, nil
+	}
+}
+
+// ListFolderContents lists a page of a Dropbox folder, continuing from the cursor in nextPageToken
+// Dropbox cannot filter by date, so the storage service leaves out files modified outside dates
+func (s *Service) ListFolderContents(item *models.CloudItem, token *models.Token, pageSize int, nextPageToken string, dates models.DateRange) ([]*models.CloudItem, string, error) {
+	folder, err := resolveItem(item)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var listing ListFolderResponse
+	if nextPageToken != "" {
+		err = s.rpc("files/list_folder/continue", map[string]any{"cursor": nextPageToken}, token, &listing)
+	} else {
+		args := map[string]any{"path": folder.path}
+		if pageSize > 0 {
+			args["limit"] = min(pageSize, maxListLimit)
+		}
+		if folder.link != "" {
+			args["shared_link"] = sharedLink{URL: folder.link}
+		}
+		err = s.rpc("files/list_folder", args, token, &listing)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	var items []*models.CloudItem
+	for _, entry := range listing.Entries {
+		if entry.Tag == "deleted" {
+			continue
+		}
+		items = append(items, s.convertEntry(entry, folder.child(entry)))
+	}
+
+	if !listing.HasMore {
+		return items, "", nil
+	}
+	return items, listing.Cursor, nil
+}
+
+// ListAllImages lists image files across the user's entire Dropbox, stopping once limit images are found
+func (s *Service) ListAllImages(token *models.Token, limit int) ([]*models.CloudItem, error) {
+	var images []*models.CloudItem
+	var cursor string
+
+	for {
+		var listing ListFolderResponse
+		var err error
+		if cursor != "" {
+			err = s.rpc("files/list_folder/continue", map[string]any{"cursor": cursor}, token, &listing)
+		} else {
+			err = s.rpc("files/list_folder", map[string]any{"path": "", "recursive": true, "limit": maxListLimit}, token, &listing)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range listing.Entries {
+			if entry.Tag != "file" || !strings.HasPrefix(mimeTypeOf(entry.Name), "image/") {
+				continue
+			}
+
+			images = append(images, s.convertEntry(entry, itemRef{path: entry.ID}))
+			if limit > 0 && len(images) >= limit {
+				return images, nil
+			}
+		}
+
+		if !listing.HasMore {
+			return images, nil
+		}
+		cursor = listing.Cursor
+	}
+}
+
+// convertEntry converts a Dropbox entry to CloudItem format, referenced by ref
+// Its URLs only describe the item; streams are always fetched by its ID
+func (s *Service) convertEntry(entry Metadata, ref itemRef) *models.CloudItem {
+	item := &models.CloudItem{
+		Name:     entry.Name,
+		IsFolder: entry.Tag == "folder",
+		Provider: "dropbox",
+	}
+
+	if ref.link != "" {
+		item.ID = sharedPathPrefix + base64.RawURLEncoding.EncodeToString([]byte(ref.path))
+		item.DriveID = sharedLinkPrefix + base64.RawURLEncoding.EncodeToString([]byte(ref.link))
+	} else {
+		item.ID = strings.TrimPrefix(entry.ID, "id:")
+	}
+
+	if !item.IsFolder {
+		item.Size = entry.Size
+		item.MimeType = mimeTypeOf(entry.Name)
+		item.DownloadURL = s.contentURL + "/files/download?" + itemQuery(item).Encode()
+
+		if strings.HasPrefix(item.MimeType, "image/") {
+			item.FaceRecognitionOptimizedURL = s.thumbnailURL(item, faceRecognitionThumbnailSize)
+			item.ThumbnailURL = s.thumbnailURL(item, displayThumbnailSize)
+		}
+	}
+
+	// Photos keep the time they were taken or edited on the device, an unparsable value leaves the time unset
+	modified := entry.ClientModified
+	if modified == "" {
+		modified = entry.ServerModified
+	}
+	item.ModifiedTime, _ = time.Parse(time.RFC3339, modified)
+
+	return item
+}
+
+// itemQuery carries an item's reference in the query of the URLs built for it
+func itemQuery(item *models.CloudItem) url.Values {
+	query := url.Values{}
+	query.Set("id", item.ID)
+	if item.DriveID != "" {
+		query.Set("drive_id", item.DriveID)
+	}
+	return query
+}
+
+// thumbnailURL builds the URL GetThumbnailStream serves a thumbnail of the item from
+func (s *Service) thumbnailURL(item *models.CloudItem, size string) string {
+	query := itemQuery(item)
+	query.Set("size", size)
+	return s.contentURL + "/files/get_thumbnail_v2?" + query.Encode()
+}
+
+// extensionMimeTypes covers the photo formats the system MIME table may not know
+var extensionMimeTypes = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+	".bmp":  "image/bmp",
+	".heic": "image/heic",
+	".heif": "image/heif",
+	".tif":  "image/tiff",
+	".tiff": "image/tiff",
+}
+
+// mimeTypeOf guesses a file's MIME type from its extension, as Dropbox does not report one
+func mimeTypeOf(name string) string {
+	extension := strings.ToLower(path.Ext(name))
+	if mimeType, ok := extensionMimeTypes[extension]; ok {
+		return mimeType
+	}
+	if mimeType := mime.TypeByExtension(extension); mimeType != "" {
+		return mimeType
+	}
+	return "application/octet-stream"
+}
+
+// GetItem fetches an item's metadata by its reference and builds its URLs server-side
+func (s *Service) GetItem(item *models.CloudItem, token *models.Token) (*models.CloudItem, error) {
+	ref, err := resolveItem(item)
+	if err != nil {
+		return nil, err
+	}
+
+	var metadata Metadata
+	if ref.link != "" {
+		err = s.rpc("sharing/get_shared_link_metadata", sharedLink{URL: ref.link, Path: ref.path}, token, &metadata)
+	} else {
+		err = s.rpc("files/get_metadata", map[string]any{"path": ref.path}, token, &metadata)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s.convertEntry(metadata, ref), nil
+}
+
+// GetFileStream retrieves a file stream for downloading (full resolution)
+func (s *Service) GetFileStream(item *models.CloudItem, token *models.Token) (io.ReadCloser, error) {
+	ref, err := resolveItem(item)
+	if err != nil {
+		return nil, err
+	}
+
+	if ref.link != "" {
+		return s.download("sharing/get_shared_link_file", sharedLink{URL: ref.link, Path: ref.path}, token)
+	}
+	return s.download("files/download", map[string]any{"path": ref.path}, token)
+}
+
+// GetFaceRecognitionOptimizedStream retrieves an optimized stream (about 800px) for face recognition processing
+func (s *Service) GetFaceRecognitionOptimizedStream(item *models.CloudItem, token *models.Token) (io.ReadCloser, error) {
+	if !strings.HasPrefix(mimeTypeOf(item.Name), "image/") {
+		// Fall back to full resolution for files Dropbox makes no thumbnails of
+		return s.GetFileStream(item, token)
+	}
+	return s.getThumbnail(item, faceRecognitionThumbnailSize, token)
+}
+
+// GetThumbnailStream retrieves a thumbnail from a URL built by thumbnailURL
+// Other URLs are rejected, so the user's token is never sent elsewhere
+func (s *Service) GetThumbnailStream(thumbnailURL string, token *models.Token) (io.ReadCloser, error) {
+	rawQuery, ok := strings.CutPrefix(thumbnailURL, s.contentURL+"/files/get_thumbnail_v2?")
+	if !ok {
+		return nil, fmt.Errorf("not a Dropbox thumbnail URL")
+	}
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("invalid thumbnail URL: %w", err)
+	}
+
+	size := query.Get("size")
+	if size != displayThumbnailSize && size != faceRecognitionThumbnailSize {
+		size = displayThumbnailSize
+	}
+
+	item := &models.CloudItem{ID: query.Get("id"), DriveID: query.Get("drive_id")}
+	return s.getThumbnail(item, size, token)
+}
+
+// getThumbnail fetches a JPEG thumbnail of the item fitted within size
+func (s *Service) getThumbnail(item *models.CloudItem, size string, token *models.Token) (io.ReadCloser, error) {
+	ref, err := resolveItem(item)
+	if err != nil {
+		return nil, err
+	}
+
+	resource := thumbnailResource{Tag: "path", Path: ref.path}
+	if ref.link != "" {
+		resource = thumbnailResource{Tag: "link", URL: ref.link, Path: ref.path}
+	}
+
+	return s.download("files/get_thumbnail_v2", map[string]any{
+		"resource": resource,
+		"format":   "jpeg",
+		"size":     size,
+		"mode":     "bestfit",
+	}, token)
+}
+
+// ParseShareLink parses a Dropbox folder share link and fetches the folder's details
+func (s *Service) ParseShareLink(shareURL string, token *models.Token) (*models.CloudItem, error) {
+	link, err := folderLink(shareURL)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, err := s.sharedFolderMetadata(link, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.convertEntry(*metadata, itemRef{link: link}), nil
+}
+
+// CanonicalFolderID returns a stable "dropbox:{id}" identifier for a folder link
+// Folders outside the user's Dropbox may be reported without an ID, so the link's path stands in for it
+func (s *Service) CanonicalFolderID(shareURL string, token *models.Token) (string, error) {
+	link, err := folderLink(shareURL)
+	if err != nil {
+		return "", err
+	}
+
+	metadata, err := s.sharedFolderMetadata(link, token)
+	if err != nil {
+		return "", err
+	}
+
+	if metadata.ID != "" {
+		return "dropbox:" + strings.TrimPrefix(metadata.ID, "id:"), nil
+	}
+
+	parsedURL, _ := url.Parse(link)
+	return "dropbox:" + parsedURL.Path, nil
+}
+
+// sharedFolderMetadata fetches a shared link's metadata, failing when it does not point to a folder
+func (s *Service) sharedFolderMetadata(link string, token *models.Token) (*Metadata, error) {
+	var metadata Metadata
+	if err := s.rpc("sharing/get_shared_link_metadata", sharedLink{URL: link}, token, &metadata); err != nil {
+		return nil, fmt.Errorf("failed to get folder info: %w", err)
+	}
+
+	if metadata.Tag != "folder" {
+		return nil, fmt.Errorf("the link points to a file; share the folder containing the photos instead")
+	}
+
+	return &metadata, nil
+}
+
+// GetRootFolder returns the root of the user's own Dropbox
+func (s *Service) GetRootFolder(token *models.Token) (*models.CloudItem, error) {
+	return &models.CloudItem{
+		ID:       rootFolderID,
+		Name:     "Dropbox",
+		IsFolder: true,
+		Provider: "dropbox",
+	}, nil
+}
+
+// GetAccountID returns the signed-in user's Dropbox account ID
+func (s *Service) GetAccountID(token *models.Token) (string, error) {
+	account, err := s.GetAccount(token)
+	if err != nil {
+		return "", err
+	}
+	return account.ID, nil
+}
+
+// GetAccount returns the signed-in user, identified by their account ID
+func (s *Service) GetAccount(token *models.Token) (*models.Account, error) {
+	var account Account
+	if err := s.rpc("users/get_current_account", nil, token, &account); err != nil {
+		return nil, err
+	}
+
+	if account.AccountID == "" {
+		return nil, fmt.Errorf("account ID missing from response")
+	}
+
+	return &models.Account{
+		Provider:    "dropbox",
+		ID:          account.AccountID,
+		DisplayName: account.Name.DisplayName,
+		Email:       account.Email,
+	}, nil
+}
+
+// CopyToFolder copies a file into a folder of the user's Dropbox, keeping its name and renaming on conflicts
+// Files of the user's Dropbox are copied server-side; files reached through a shared link cannot be,
+// so they are downloaded and uploaded again
+func (s *Service) CopyToFolder(item, destination *models.CloudItem, token *models.Token) error {
+	ref, err := resolveItem(item)
+	if err != nil {
+		return err
+	}
+
+	if ref.link != "" {
+		stream, err := s.GetFileStream(item, token)
+		if err != nil {
+			return err
+		}
+		defer stream.Close()
+
+		content, err := io.ReadAll(stream)
+		if err != nil {
+			return fmt.Errorf("failed to read file: %w", err)
+		}
+		return s.UploadFile(destination, item.Name, item.MimeType, content, token)
+	}
+
+	targetPath, err := destinationPath(destination, item.Name)
+	if err != nil {
+		return err
+	}
+
+	return s.rpc("files/copy_v2", map[string]any{
+		"from_path":  ref.path,
+		"to_path":    targetPath,
+		"autorename": true,
+	}, token, nil)
+}
+
+// UploadFile creates a file in a folder of the user's Dropbox, renaming it when the name is taken
+func (s *Service) UploadFile(destination *models.CloudItem, name, mimeType string, content []byte, token *models.Token) error {
+	targetPath, err := destinationPath(destination, name)
+	if err != nil {
+		return err
+	}
+
+	arg, err := apiArg(map[string]any{"path": targetPath, "mode": "add", "autorename": true})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", s.contentURL+"/files/upload", bytes.NewReader(content))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.AccessToken))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Dropbox-API-Arg", arg)
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return apiError("files/upload", resp.StatusCode, body)
+	}
+
+	return nil
+}
+
+// destinationPath returns the path of a new file in a folder of the user's own Dropbox
+// Dropbox resolves paths relative to an ID, so the folder's path need not be known
+func destinationPath(destination *models.CloudItem, name string) (string, error) {
+	folder, err := resolveItem(destination)
+	if err != nil {
+		return "", err
+	}
+	if folder.link != "" {
+		return "", errors.New("matches can only be saved into a folder of your own Dropbox")
+	}
+
+	return folder.path + "/" + name, nil
+}
+
+// rpc calls an RPC endpoint, which takes its arguments and returns its result as JSON; nil args send none
+func (s *Service) rpc(endpoint string, args any, token *models.Token, result any) error {
+	var body io.Reader
+	if args != nil {
+		payload, err := json.Marshal(args)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		body = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequest("POST", s.apiURL+"/"+endpoint, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.AccessToken))
+	if args != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return apiError(endpoint, resp.StatusCode, respBody)
+	}
+
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(respBody, result); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// download calls a content download endpoint, which takes its arguments in the Dropbox-API-Arg header
+// and returns the content as the response body
+func (s *Service) download(endpoint string, args any, token *models.Token) (io.ReadCloser, error) {
+	arg, err := apiArg(args)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", s.contentURL+"/"+endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create download request: %w", err)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.AccessToken))
+	req.Header.Set("Dropbox-API-Arg", arg)
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute download request: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return nil, apiError(endpoint, resp.StatusCode, body)
+	}
+
+	return resp.Body, nil
+}
+
+// apiArg encodes arguments for the Dropbox-API-Arg header, which must be ASCII, escaping other characters
+func apiArg(args any) (string, error) {
+	data, err := json.Marshal(args)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	var arg strings.Builder
+	for _, r := range string(data) {
+		if r < 0x80 {
+			arg.WriteRune(r)
+			continue
+		}
+		for _, unit := range utf16.Encode([]rune{r}) {
+			fmt.Fprintf(&arg, `\u%04x`, unit)
+		}
+	}
+	return arg.String(), nil
+}
+
+// parseDropboxURL parses a link and checks that it belongs to Dropbox
+func parseDropboxURL(shareURL string) (*url.URL, error) {
+	parsedURL, err := url.Parse(strings.TrimSpace(shareURL))
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL format: %w", err)
+	}
+
+	if parsedURL.Scheme != "https" {
+		return nil, fmt.Errorf("URL must use the https scheme")
+	}
+
+	host := strings.ToLower(parsedURL.Host)
+	if host != "dropbox.com" && host != "www.dropbox.com" {
+		return nil, fmt.Errorf("not a Dropbox share link (invalid host: %s)", host)
+	}
+
+	return parsedURL, nil
+}
+
+// folderLink checks that a link is a Dropbox folder share link, e.g. https://www.dropbox.com/scl/fo/...
+// or the older https://www.dropbox.com/sh/..., and returns it trimmed
+func folderLink(shareURL string) (string, error) {
+	parsedURL, err := parseDropboxURL(shareURL)
+	if err != nil {
+		return "", err
+	}
+
+	if !strings.HasPrefix(parsedURL.Path, "/scl/fo/") && !strings.HasPrefix(parsedURL.Path, "/sh/") {
+		return "", fmt.Errorf("not a Dropbox folder link; share the folder containing the photos")
+	}
+
+	return strings.TrimSpace(shareURL), nil
+}
+
+// DetectShareLink classifies a link by its shape alone, without calling the Dropbox API
+func (s *Service) DetectShareLink(shareURL string) models.ShareLinkKind {
+	parsedURL, err := parseDropboxURL(shareURL)
+	if err != nil {
+		return models.ShareLinkUnrecognized
+	}
+
+	switch {
+	case strings.HasPrefix(parsedURL.Path, "/scl/fo/"), strings.HasPrefix(parsedURL.Path, "/sh/"):
+		return models.ShareLinkFolder
+	case strings.HasPrefix(parsedURL.Path, "/scl/fi/"), strings.HasPrefix(parsedURL.Path, "/s/"):
+		return models.ShareLinkFile
+	default:
+		return models.ShareLinkUnrecognized
+	}
+}
+
+// errorSummaries maps fragments of Dropbox error summaries to the codes users are shown
+// Summaries read like "path/not_found/..."; ones matching nothing here are classified by HTTP status
+var errorSummaries = []struct {
+	fragment string
+	code     models.ProviderErrorCode
+}{
+	{"not_found", models.ProviderErrorNotFound},
+	{"shared_link_access_denied", models.ProviderErrorForbidden},
+	{"no_permission", models.ProviderErrorForbidden},
+	{"access_denied", models.ProviderErrorForbidden},
+	{"insufficient_space", models.ProviderErrorQuota},
+	{"too_many_write_operations", models.ProviderErrorRateLimited},
+	{"too_many_requests", models.ProviderErrorRateLimited},
+	{"expired_access_token", models.ProviderErrorAuthExpired},
+	{"invalid_access_token", models.ProviderErrorAuthExpired},
+}
+
+// apiError logs a failed Dropbox call with its raw response, which can name paths and shared links,
+// and returns an error carrying only a message fit for users
+func apiError(operation string, statusCode int, body []byte) error {
+	log.Printf("Dropbox %s failed (status %d): %s", operation, statusCode, string(body))
+
+	var errorResponse struct {
+		ErrorSummary string `json:"error_summary"`
+	}
+	json.Unmarshal(body, &errorResponse)
+
+	var code models.ProviderErrorCode
+	for _, summary := range errorSummaries {
+		if strings.Contains(errorResponse.ErrorSummary, summary.fragment) {
+			code = summary.code
+			break
+		}
+	}
+
+	return models.NewProviderError("dropbox", statusCode, code)
+}
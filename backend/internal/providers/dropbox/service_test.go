@@ -0,0 +1,224 @@
+package dropbox
+
+import (
+	"all-me-backend/pkg/models"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testFolderLink = "https://www.dropbox.com/scl/fo/abc123/xyz?rlkey=key&dl=0"
+
+func createTestService(baseURL string) *Service {
+	service := NewDropboxService()
+	service.apiURL = baseURL
+	service.contentURL = baseURL
+	return service
+}
+
+func TestParseShareLink_ListsSharedFolder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var args map[string]any
+		json.NewDecoder(r.Body).Decode(&args)
+
+		var response any
+		switch r.URL.Path {
+		case "/sharing/get_shared_link_metadata":
+			if args["url"] != testFolderLink {
+				t.Errorf("Expected the shared link, got %v", args["url"])
+			}
+			response = Metadata{Tag: "folder", ID: "id:shared", Name: "Wedding"}
+		case "/files/list_folder":
+			if args["path"] != "" || args["shared_link"].(map[string]any)["url"] != testFolderLink {
+				t.Errorf("Expected the shared folder's root to be listed, got %v", args)
+			}
+			response = ListFolderResponse{
+				Entries: []Metadata{
+					{Tag: "file", ID: "id:a", Name: "a.jpg", ClientModified: "2024-05-01T10:00:00Z"},
+					{Tag: "folder", ID: "id:b", Name: "Day 2"},
+				},
+				Cursor:  "cursor-1",
+				HasMore: true,
+			}
+		case "/files/list_folder/continue":
+			if args["cursor"] != "cursor-1" {
+				t.Errorf("Expected the previous cursor, got %v", args["cursor"])
+			}
+			response = ListFolderResponse{Entries: []Metadata{{Tag: "deleted", Name: "gone.jpg"}}}
+		default:
+			t.Errorf("Unexpected request path: %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	service := createTestService(server.URL)
+	token := &models.Token{AccessToken: "token", Provider: "dropbox"}
+
+	folder, err := service.ParseShareLink(testFolderLink, token)
+	if err != nil {
+		t.Fatalf("ParseShareLink failed: %v", err)
+	}
+	if !folder.IsFolder || folder.Name != "Wedding" {
+		t.Fatalf("Expected the Wedding folder, got %+v", folder)
+	}
+
+	items, nextPage, err := service.ListFolderContents(folder, token, 100, "", models.DateRange{})
+	if err != nil {
+		t.Fatalf("ListFolderContents failed: %v", err)
+	}
+	if len(items) != 2 || nextPage != "cursor-1" {
+		t.Fatalf("Expected 2 items and the next cursor, got %d items and %q", len(items), nextPage)
+	}
+
+	photo := items[0]
+	if photo.MimeType != "image/jpeg" || photo.ThumbnailURL == "" || photo.ModifiedTime.IsZero() {
+		t.Errorf("Expected a dated JPEG with a thumbnail, got %+v", photo)
+	}
+	if ref, err := resolveItem(photo); err != nil || ref.link != testFolderLink || ref.path != "/a.jpg" {
+		t.Errorf("Expected the photo to resolve to /a.jpg within the link, got %+v (%v)", ref, err)
+	}
+	for _, id := range []string{photo.ID, photo.DriveID} {
+		if strings.ContainsAny(id, ":/?=&") {
+			t.Errorf("Expected an ID safe to pass around, got %q", id)
+		}
+	}
+
+	items, nextPage, err = service.ListFolderContents(folder, token, 100, "cursor-1", models.DateRange{})
+	if err != nil || len(items) != 0 || nextPage != "" {
+		t.Errorf("Expected the last page to skip deleted entries, got %d items, %q (%v)", len(items), nextPage, err)
+	}
+}
+
+func TestParseShareLink_RejectsFileLinks(t *testing.T) {
+	service := createTestService("")
+
+	for _, link := range []string{
+		"https://www.dropbox.com/scl/fi/abc123/photo.jpg?rlkey=key",
+		"https://drive.google.com/drive/folders/1AbCdEfGhIjKlMnO",
+		"http://www.dropbox.com/scl/fo/abc123/xyz",
+	} {
+		if _, err := service.ParseShareLink(link, &models.Token{AccessToken: "token"}); err == nil {
+			t.Errorf("Expected %s to be rejected", link)
+		}
+	}
+}
+
+func TestGetThumbnailStream_OnlyFetchesOwnThumbnails(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var args struct {
+			Resource thumbnailResource `json:"resource"`
+			Size     string            `json:"size"`
+		}
+		if err := json.Unmarshal([]byte(r.Header.Get("Dropbox-API-Arg")), &args); err != nil {
+			t.Errorf("Expected the arguments in the Dropbox-API-Arg header: %v", err)
+		}
+		if args.Resource.Tag != "path" || args.Resource.Path != "id:photo" || args.Size != displayThumbnailSize {
+			t.Errorf("Expected a display thumbnail of id:photo, got %+v", args)
+		}
+		w.Write([]byte("jpeg"))
+	}))
+	defer server.Close()
+
+	service := createTestService(server.URL)
+	token := &models.Token{AccessToken: "token"}
+
+	item := service.convertEntry(Metadata{Tag: "file", ID: "id:photo", Name: "photo.jpg"}, itemRef{path: "id:photo"})
+	stream, err := service.GetThumbnailStream(item.ThumbnailURL, token)
+	if err != nil {
+		t.Fatalf("GetThumbnailStream failed: %v", err)
+	}
+	defer stream.Close()
+	if content, _ := io.ReadAll(stream); string(content) != "jpeg" {
+		t.Errorf("Expected the thumbnail content, got %q", content)
+	}
+
+	if _, err := service.GetThumbnailStream("https://attacker.example.com/files/get_thumbnail_v2?id=photo", token); err == nil {
+		t.Error("Expected a URL outside the Dropbox API to be rejected")
+	}
+}
+
+func TestGetAccount(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/users/get_current_account" || r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("Unexpected request: %s %s", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		w.Write([]byte(`{"account_id": "dbid:123", "name": {"display_name": "Ana"}, "email": "ana@example.com"}`))
+	}))
+	defer server.Close()
+
+	account, err := createTestService(server.URL).GetAccount(&models.Token{AccessToken: "token"})
+	if err != nil {
+		t.Fatalf("GetAccount failed: %v", err)
+	}
+	if account.ID != "dbid:123" || account.DisplayName != "Ana" || account.Email != "ana@example.com" {
+		t.Errorf("Unexpected account: %+v", account)
+	}
+}
+
+func TestDetectShareLink(t *testing.T) {
+	service := createTestService("")
+
+	tests := map[string]models.ShareLinkKind{
+		"https://www.dropbox.com/scl/fo/abc123/xyz?rlkey=key": models.ShareLinkFolder,
+		"https://www.dropbox.com/sh/abc123/xyz":               models.ShareLinkFolder,
+		"https://www.dropbox.com/scl/fi/abc123/a.jpg":         models.ShareLinkFile,
+		"https://www.dropbox.com/s/abc123/a.jpg":              models.ShareLinkFile,
+		"https://1drv.ms/f/s!AbCdEf":                          models.ShareLinkUnrecognized,
+	}
+
+	for link, expected := range tests {
+		if kind := service.DetectShareLink(link); kind != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, link, kind)
+		}
+	}
+}
+
+func TestBuildAuthURL_RequestsOfflineAccess(t *testing.T) {
+	authURL, err := createTestService("").BuildAuthURL("state", "challenge")
+	if err != nil {
+		t.Fatalf("BuildAuthURL failed: %v", err)
+	}
+
+	if !strings.HasPrefix(authURL, "https://www.dropbox.com/oauth2/authorize?") {
+		t.Errorf("Expected the Dropbox authorize URL, got %q", authURL)
+	}
+	for _, param := range []string{"token_access_type=offline", "code_challenge=challenge&code_challenge_method=S256"} {
+		if !strings.Contains(authURL, param) {
+			t.Errorf("Expected the auth URL to carry %s, got %q", param, authURL)
+		}
+	}
+}
+
+func TestAPIArg_EscapesNonASCII(t *testing.T) {
+	arg, err := apiArg(map[string]string{"path": "/Slike/Đurđevdan 😀.jpg"})
+	if err != nil {
+		t.Fatalf("apiArg failed: %v", err)
+	}
+
+	if arg != `{"path":"/Slike/\u0110ur\u0111evdan \ud83d\ude00.jpg"}` {
+		t.Errorf("Unexpected header value %s", arg)
+	}
+}
+
+func TestGetItem_MapsErrorSummaries(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"error_summary": "path/not_found/..", "error": {".tag": "path"}}`))
+	}))
+	defer server.Close()
+
+	_, err := createTestService(server.URL).GetItem(&models.CloudItem{ID: "abc123"}, &models.Token{AccessToken: "token"})
+
+	providerErr, ok := models.AsProviderError(err)
+	if !ok || providerErr.Code != models.ProviderErrorNotFound || providerErr.HTTPStatus() != http.StatusNotFound {
+		t.Fatalf("Expected a not found provider error, got %v", err)
+	}
+}
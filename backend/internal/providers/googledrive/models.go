@@ -10,6 +10,15 @@ type File struct {
 	ThumbnailURL string `json:"thumbnailLink"`
 }
 
+// About is the subset of the Drive about resource describing the signed-in user
+type About struct {
+	User struct {
+		PermissionID string `json:"permissionId"`
+		DisplayName  string `json:"displayName"`
+		EmailAddress string `json:"emailAddress"`
+	} `json:"user"`
+}
+
 type APIResponse struct {
 	Files         []File `json:"files"`
 	NextPageToken string `json:"nextPageToken,omitempty"`
@@ -1,14 +1,22 @@
 package googledrive
 
 import (
+	"all-me-backend/internal/providers/httptransport"
+	"all-me-backend/internal/providers/throttle"
+	"all-me-backend/pkg/config"
 	"all-me-backend/pkg/models"
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
 	"net/url"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -16,20 +24,38 @@ import (
 type Service struct {
 	httpClient *http.Client
 	baseURL    string
+	uploadURL  string
 	config     *models.OAuthConfig
 }
 
+// defaultWriteScope lets saved matches be copied into any folder the user picks
+// drive.file is not enough, as it only covers folders the app created or the user opened through a picker
+const defaultWriteScope = "https://www.googleapis.com/auth/drive"
+
 func NewGoogleDriveService() *Service {
+	scopes := []string{"https://www.googleapis.com/auth/drive.readonly"}
+	var writeScope string
+	if config.GetBool("SAVE_TO_DRIVE_ENABLED", false) {
+		writeScope = config.GetString("GOOGLEDRIVE_WRITE_SCOPE", defaultWriteScope)
+		scopes = append(scopes, writeScope)
+	}
+
 	return &Service{
-		httpClient: &http.Client{Timeout: 30 * time.Second},
-		baseURL:    "https://www.googleapis.com/drive/v3",
+		httpClient: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: throttle.NewTransport(throttle.ForProvider("googledrive"), httptransport.Shared()),
+		},
+		baseURL:   config.GetHTTPSURL("GOOGLEDRIVE_API_URL", "https://www.googleapis.com/drive/v3"),
+		uploadURL: config.GetHTTPSURL("GOOGLEDRIVE_UPLOAD_URL", "https://www.googleapis.com/upload/drive/v3"),
 		config: &models.OAuthConfig{
 			ClientID:     os.Getenv("GOOGLEDRIVE_CLIENT_ID"),
 			ClientSecret: os.Getenv("GOOGLEDRIVE_CLIENT_SECRET"),
 			RedirectURI:  os.Getenv("GOOGLEDRIVE_REDIRECT_URI"),
-			Scopes:       []string{"https://www.googleapis.com/auth/drive.readonly"},
-			AuthURL:      "https://accounts.google.com/o/oauth2/v2/auth",
-			TokenURL:     "https://oauth2.googleapis.com/token",
+			Scopes:       scopes,
+			WriteScope:   writeScope,
+			AuthURL:      config.GetHTTPSURL("GOOGLEDRIVE_AUTH_URL", "https://accounts.google.com/o/oauth2/v2/auth"),
+			TokenURL:     config.GetHTTPSURL("GOOGLEDRIVE_TOKEN_URL", "https://oauth2.googleapis.com/token"),
+			RevokeURL:    config.GetHTTPSURL("GOOGLEDRIVE_REVOKE_URL", "https://oauth2.googleapis.com/revoke"),
 			Provider:     "googledrive",
 		},
 	}
@@ -39,30 +65,46 @@ func (s *Service) GetOAuthConfig() *models.OAuthConfig {
 	return s.config
 }
 
-func (s *Service) BuildAuthURL(state string) (string, error) {
+func (s *Service) BuildAuthURL(state, codeChallenge string) (string, error) {
 	params := url.Values{}
 	params.Add("client_id", s.config.ClientID)
 	params.Add("redirect_uri", s.config.RedirectURI)
 	params.Add("response_type", "code")
 	params.Add("scope", strings.Join(s.config.Scopes, " "))
 	params.Add("state", state)
+	params.Add("code_challenge", codeChallenge)
+	params.Add("code_challenge_method", "S256")
+	// Offline access issues a refresh token; Google only does so when consent is given, so it is always asked for
+	params.Add("access_type", "offline")
+	params.Add("prompt", "consent")
 
 	authURL := s.config.AuthURL + "?" + params.Encode()
 	return authURL, nil
 }
 
+// listFields are the file fields requested from the Drive API for listings
+const listFields = "nextPageToken,files(id,name,mimeType,size,modifiedTime,webViewLink,thumbnailLink)"
+
+// rootFolderID is the Drive API alias for the root of the user's own drive
+const rootFolderID = "root"
+
+// folderMimeType is the MIME type Drive reports for folders
+const folderMimeType = "application/vnd.google-apps.folder"
+
 // ListFolderContents lists all items in a Google Drive folder with pagination support
-func (s *Service) ListFolderContents(item *models.CloudItem, token *models.Token, pageSize int, nextPageToken string) ([]*models.CloudItem, string, error) {
-	// Build the API URL with query parameters
-	baseURL := s.baseURL + "/files"
+// Files modified outside dates are filtered out by the Drive query, saving pages of results; folders are always listed
+func (s *Service) ListFolderContents(item *models.CloudItem, token *models.Token, pageSize int, nextPageToken string, dates models.DateRange) ([]*models.CloudItem, string, error) {
 	params := url.Values{}
 
 	// Query for all items in the specified folder (files and folders)
 	query := fmt.Sprintf("'%s' in parents", item.ID)
+	if !dates.IsZero() {
+		query += " and (mimeType = '" + folderMimeType + "' or (" + modifiedTimeQuery(dates) + "))"
+	}
 	params.Set("q", query)
 
 	// Request specific fields
-	params.Set("fields", "nextPageToken,files(id,name,mimeType,size,webViewLink,thumbnailLink)")
+	params.Set("fields", listFields)
 
 	// Add pagination parameters
 	if pageSize > 0 {
@@ -72,12 +114,75 @@ func (s *Service) ListFolderContents(item *models.CloudItem, token *models.Token
 		params.Set("pageToken", nextPageToken)
 	}
 
-	apiURL := fmt.Sprintf("%s?%s", baseURL, params.Encode())
+	driveResp, err := s.listFiles(params, token)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Convert Google Drive files to CloudItem format
+	var items []*models.CloudItem
+	for _, file := range driveResp.Files {
+		items = append(items, s.convertFileToCloudItem(file))
+	}
+
+	return items, driveResp.NextPageToken, nil
+}
+
+// modifiedTimeQuery is the Drive query condition matching files modified within dates
+func modifiedTimeQuery(dates models.DateRange) string {
+	var conditions []string
+	if !dates.After.IsZero() {
+		conditions = append(conditions, fmt.Sprintf("modifiedTime >= '%s'", dates.After.UTC().Format(time.RFC3339)))
+	}
+	if !dates.Before.IsZero() {
+		conditions = append(conditions, fmt.Sprintf("modifiedTime < '%s'", dates.Before.UTC().Format(time.RFC3339)))
+	}
+	return strings.Join(conditions, " and ")
+}
+
+// ListAllImages lists image files across the user's entire drive, stopping once limit images are found
+func (s *Service) ListAllImages(token *models.Token, limit int) ([]*models.CloudItem, error) {
+	const pageSize = 1000
+
+	var images []*models.CloudItem
+	var nextPageToken string
+
+	for {
+		params := url.Values{}
+		params.Set("q", "mimeType contains 'image/' and trashed = false")
+		params.Set("fields", listFields)
+		params.Set("pageSize", fmt.Sprintf("%d", pageSize))
+		if nextPageToken != "" {
+			params.Set("pageToken", nextPageToken)
+		}
+
+		driveResp, err := s.listFiles(params, token)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range driveResp.Files {
+			images = append(images, s.convertFileToCloudItem(file))
+			if limit > 0 && len(images) >= limit {
+				return images, nil
+			}
+		}
+
+		if driveResp.NextPageToken == "" {
+			return images, nil
+		}
+		nextPageToken = driveResp.NextPageToken
+	}
+}
+
+// listFiles executes a files.list request with the given query parameters
+func (s *Service) listFiles(params url.Values, token *models.Token) (*APIResponse, error) {
+	apiURL := fmt.Sprintf("%s/files?%s", s.baseURL, params.Encode())
 
 	// Create HTTP request
 	req, err := http.NewRequest("GET", apiURL, nil)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	// Add authorization header
@@ -87,62 +192,94 @@ func (s *Service) ListFolderContents(item *models.CloudItem, token *models.Token
 	// Execute request
 	resp, err := s.httpClient.Do(req)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to execute request: %w", err)
+		return nil, fmt.Errorf("failed to execute request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	// Check response status
 	if resp.StatusCode != http.StatusOK {
-		return nil, "", s.handleAPIError(resp)
+		return nil, s.handleAPIError(resp)
 	}
 
 	// Parse response
 	var driveResp APIResponse
 	if err := json.NewDecoder(resp.Body).Decode(&driveResp); err != nil {
-		return nil, "", fmt.Errorf("failed to decode response: %w", err)
+		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
 
-	// Convert Google Drive files to CloudItem format
-	var items []*models.CloudItem
-	for _, file := range driveResp.Files {
-		// Parse size (Google Drive returns size as string)
-		var size int64
-		if file.Size != "" {
-			fmt.Sscanf(file.Size, "%d", &size)
-		}
+	return &driveResp, nil
+}
 
-		// Check if this is a folder
-		isFolder := file.MimeType == "application/vnd.google-apps.folder"
-
-		// Set URLs for files (not folders)
-		var downloadURL, faceRecognitionOptimizedURL, thumbnailURL string
-		if !isFolder {
-			// Full resolution for downloads
-			downloadURL = fmt.Sprintf("%s/files/%s?alt=media", s.baseURL, file.ID)
-
-			// For images, add face recognition optimized and thumbnail URLs
-			if strings.HasPrefix(file.MimeType, "image/") {
-				// Face Recognition Optimized: 800px optimized size for face recognition processing
-				faceRecognitionOptimizedURL = fmt.Sprintf("%s/files/%s?alt=media&sz=s800", s.baseURL, file.ID)
-				// Thumbnail: 400px optimized size for frontend display
-				thumbnailURL = fmt.Sprintf("%s/files/%s?alt=media&sz=s400", s.baseURL, file.ID)
-			}
+// convertFileToCloudItem converts a Google Drive file to CloudItem format
+func (s *Service) convertFileToCloudItem(file File) *models.CloudItem {
+	// Check if this is a folder
+	isFolder := file.MimeType == folderMimeType
+
+	// Set URLs for files (not folders)
+	var downloadURL, faceRecognitionOptimizedURL, thumbnailURL string
+	if !isFolder {
+		// Full resolution for downloads
+		downloadURL = fmt.Sprintf("%s/files/%s?alt=media", s.baseURL, file.ID)
+
+		// For images, add face recognition optimized and thumbnail URLs
+		if strings.HasPrefix(file.MimeType, "image/") {
+			// Face Recognition Optimized: 800px optimized size for face recognition processing
+			faceRecognitionOptimizedURL = fmt.Sprintf("%s/files/%s?alt=media&sz=s800", s.baseURL, file.ID)
+			// Thumbnail: 400px optimized size for frontend display
+			thumbnailURL = fmt.Sprintf("%s/files/%s?alt=media&sz=s400", s.baseURL, file.ID)
 		}
+	}
 
-		cloudItem := &models.CloudItem{
-			ID:                          file.ID,
-			Name:                        file.Name,
-			MimeType:                    file.MimeType,
-			IsFolder:                    isFolder,
-			Provider:                    "googledrive",
-			DownloadURL:                 downloadURL,                 // Full resolution
-			FaceRecognitionOptimizedURL: faceRecognitionOptimizedURL, // 800px optimized for face recognition
-			ThumbnailURL:                thumbnailURL,                // 400px optimized for display
-		}
-		items = append(items, cloudItem)
+	// modifiedTime is RFC 3339, an unparsable value leaves the time unset
+	modifiedTime, _ := time.Parse(time.RFC3339, file.LastModified)
+
+	// Drive reports size as a decimal string, and none for folders and Google Docs
+	var size int64
+	if !isFolder {
+		size, _ = strconv.ParseInt(file.Size, 10, 64)
 	}
 
-	return items, driveResp.NextPageToken, nil
+	return &models.CloudItem{
+		ID:                          file.ID,
+		Name:                        file.Name,
+		MimeType:                    file.MimeType,
+		IsFolder:                    isFolder,
+		Provider:                    "googledrive",
+		DownloadURL:                 downloadURL,                 // Full resolution
+		FaceRecognitionOptimizedURL: faceRecognitionOptimizedURL, // 800px optimized for face recognition
+		ThumbnailURL:                thumbnailURL,                // 400px optimized for display
+		ModifiedTime:                modifiedTime,
+		Size:                        size,
+	}
+}
+
+// GetItem fetches a file's metadata by ID and builds its URLs server-side
+func (s *Service) GetItem(item *models.CloudItem, token *models.Token) (*models.CloudItem, error) {
+	apiURL := fmt.Sprintf("%s/files/%s?fields=id,name,mimeType,size,modifiedTime,webViewLink,thumbnailLink", s.baseURL, url.PathEscape(item.ID))
+
+	req, err := http.NewRequest("GET", apiURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.AccessToken))
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, s.handleAPIError(resp)
+	}
+
+	var file File
+	if err := json.NewDecoder(resp.Body).Decode(&file); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return s.convertFileToCloudItem(file), nil
 }
 
 // GetFileStream retrieves a file stream for downloading (full resolution)
@@ -190,7 +327,8 @@ func (s *Service) GetThumbnailStream(thumbnailURL string, token *models.Token) (
 
 	if resp.StatusCode != http.StatusOK {
 		resp.Body.Close()
-		return nil, fmt.Errorf("thumbnail request failed with status: %d", resp.StatusCode)
+		log.Printf("Google Drive thumbnail request failed with status %d", resp.StatusCode)
+		return nil, models.NewProviderError("googledrive", resp.StatusCode, "")
 	}
 
 	return resp.Body, nil
@@ -251,6 +389,185 @@ func (s *Service) ParseShareLink(shareURL string, token *models.Token) (*models.
 	return folderInfo, nil
 }
 
+// CanonicalFolderID returns a stable "googledrive:{folderID}" identifier for a folder link
+// Drive folder IDs never change, so only the "my-drive" alias needs resolving through the API
+func (s *Service) CanonicalFolderID(shareURL string, token *models.Token) (string, error) {
+	cleanURL := strings.TrimSuffix(strings.TrimSpace(shareURL), "/")
+
+	if err := s.validateShareLink(cleanURL); err != nil {
+		return "", err
+	}
+
+	parsedURL, err := url.Parse(cleanURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL format: %w", err)
+	}
+
+	folderID, err := s.extractFolderID(parsedURL)
+	if err != nil {
+		return "", err
+	}
+
+	if folderID == rootFolderID {
+		root, err := s.getFolderInfo(rootFolderID, token)
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve root folder: %w", err)
+		}
+		folderID = root.ID
+	}
+
+	return "googledrive:" + folderID, nil
+}
+
+// GetRootFolder returns the root of the user's own drive ("My Drive")
+func (s *Service) GetRootFolder(token *models.Token) (*models.CloudItem, error) {
+	folderInfo, err := s.getFolderInfo(rootFolderID, token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get root folder: %w", err)
+	}
+
+	folderInfo.Provider = "googledrive"
+
+	return folderInfo, nil
+}
+
+// GetAccountID returns the signed-in user's permission ID, which is stable for the Google account
+func (s *Service) GetAccountID(token *models.Token) (string, error) {
+	account, err := s.GetAccount(token)
+	if err != nil {
+		return "", err
+	}
+	return account.ID, nil
+}
+
+// GetAccount returns the signed-in user, identified by their permission ID
+func (s *Service) GetAccount(token *models.Token) (*models.Account, error) {
+	apiURL := fmt.Sprintf("%s/about?fields=user(permissionId,displayName,emailAddress)", s.baseURL)
+
+	req, err := http.NewRequest("GET", apiURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.AccessToken))
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, s.handleAPIError(resp)
+	}
+
+	var about About
+	if err := json.NewDecoder(resp.Body).Decode(&about); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	if about.User.PermissionID == "" {
+		return nil, fmt.Errorf("account ID missing from response")
+	}
+
+	return &models.Account{
+		Provider:    "googledrive",
+		ID:          about.User.PermissionID,
+		DisplayName: about.User.DisplayName,
+		Email:       about.User.EmailAddress,
+	}, nil
+}
+
+// CopyToFolder copies a file into a folder of the user's drive with files.copy, keeping its name
+// The copy is made server-side, so the file content never passes through the backend
+func (s *Service) CopyToFolder(item, destination *models.CloudItem, token *models.Token) error {
+	apiURL := fmt.Sprintf("%s/files/%s/copy?supportsAllDrives=true&fields=id", s.baseURL, url.PathEscape(item.ID))
+
+	payload, err := json.Marshal(map[string]any{
+		"name":    item.Name,
+		"parents": []string{destination.ID},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", apiURL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.AccessToken))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return s.handleAPIError(resp)
+	}
+
+	return nil
+}
+
+// UploadFile creates a file in a folder of the user's drive with a multipart upload of its metadata and content
+// Drive allows several files with the same name in a folder, so an existing file is never replaced
+func (s *Service) UploadFile(destination *models.CloudItem, name, mimeType string, content []byte, token *models.Token) error {
+	apiURL := fmt.Sprintf("%s/files?uploadType=multipart&supportsAllDrives=true&fields=id", s.uploadURL)
+
+	metadata, err := json.Marshal(map[string]any{
+		"name":    name,
+		"parents": []string{destination.ID},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	parts := []struct {
+		contentType string
+		data        []byte
+	}{
+		{"application/json; charset=UTF-8", metadata},
+		{mimeType, content},
+	}
+	for _, part := range parts {
+		partWriter, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {part.contentType}})
+		if err != nil {
+			return fmt.Errorf("failed to build upload: %w", err)
+		}
+		if _, err := partWriter.Write(part.data); err != nil {
+			return fmt.Errorf("failed to build upload: %w", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to build upload: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", apiURL, &body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.AccessToken))
+	req.Header.Set("Content-Type", "multipart/related; boundary="+writer.Boundary())
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return s.handleAPIError(resp)
+	}
+
+	return nil
+}
+
 // getFolderInfo retrieves information about a Google Drive folder (internal method)
 func (s *Service) getFolderInfo(folderID string, token *models.Token) (*models.CloudItem, error) {
 	// Build the API URL
@@ -285,7 +602,7 @@ func (s *Service) getFolderInfo(folderID string, token *models.Token) (*models.C
 	}
 
 	// Ensure it's a folder
-	if file.MimeType != "application/vnd.google-apps.folder" {
+	if file.MimeType != folderMimeType {
 		return nil, fmt.Errorf("item %s is not a folder", folderID)
 	}
 
@@ -297,22 +614,43 @@ func (s *Service) getFolderInfo(folderID string, token *models.Token) (*models.C
 	}, nil
 }
 
-// validateShareLink checks if the URL is a valid Google Drive share link
+// validateShareLink checks if the URL is a valid Google Drive share link or a folder in the user's own drive
 func (s *Service) validateShareLink(shareURL string) error {
+	parsedURL, err := s.parseDriveURL(shareURL)
+	if err != nil {
+		return err
+	}
+
+	// Check if the URL contains folder indicators
+	path := parsedURL.Path
+	query := parsedURL.Query()
+	hasFolderIndicator := strings.Contains(path, "/folders/") ||
+		strings.Contains(path, "/drive/") ||
+		query.Get("id") != ""
+
+	if !hasFolderIndicator {
+		return fmt.Errorf("URL does not appear to be a Google Drive folder link")
+	}
+
+	return nil
+}
+
+// parseDriveURL parses a URL and checks that it points at a Google Drive host
+func (s *Service) parseDriveURL(shareURL string) (*url.URL, error) {
 	// Clean the URL
 	cleanURL := strings.TrimSpace(shareURL)
 	if cleanURL == "" {
-		return fmt.Errorf("share URL cannot be empty")
+		return nil, fmt.Errorf("share URL cannot be empty")
 	}
 
 	parsedURL, err := url.Parse(cleanURL)
 	if err != nil {
-		return fmt.Errorf("invalid URL format: %w", err)
+		return nil, fmt.Errorf("invalid URL format: %w", err)
 	}
 
 	// Check if it has a valid scheme
 	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
-		return fmt.Errorf("URL must use http or https scheme")
+		return nil, fmt.Errorf("URL must use http or https scheme")
 	}
 
 	// Check if it's a Google Drive domain
@@ -331,21 +669,21 @@ func (s *Service) validateShareLink(shareURL string) error {
 	}
 
 	if !isValidHost {
-		return fmt.Errorf("not a Google Drive share link (invalid host: %s)", host)
+		return nil, fmt.Errorf("not a Google Drive share link (invalid host: %s)", host)
 	}
 
-	// Check if the URL contains folder indicators
-	path := parsedURL.Path
-	query := parsedURL.Query()
-	hasFolderIndicator := strings.Contains(path, "/folders/") ||
-		strings.Contains(path, "/drive/") ||
-		query.Get("id") != ""
+	return parsedURL, nil
+}
 
-	if !hasFolderIndicator {
-		return fmt.Errorf("URL does not appear to be a Google Drive folder link")
+// DetectShareLink classifies a link by its shape alone, without calling the Google Drive API
+func (s *Service) DetectShareLink(shareURL string) models.ShareLinkKind {
+	if _, err := s.parseDriveURL(shareURL); err != nil {
+		return models.ShareLinkUnrecognized
 	}
-
-	return nil
+	if err := s.validateShareLink(shareURL); err != nil {
+		return models.ShareLinkFile
+	}
+	return models.ShareLinkFolder
 }
 
 // extractFolderID extracts folder ID from various Google Drive URL formats
@@ -353,6 +691,11 @@ func (s *Service) extractFolderID(parsedURL *url.URL) (string, error) {
 	path := parsedURL.Path
 	query := parsedURL.Query()
 
+	// Format 0: /drive/my-drive or /drive/u/{user_number}/my-drive (the user's own drive, not a share)
+	if strings.HasSuffix(path, "/my-drive") {
+		return rootFolderID, nil
+	}
+
 	// Format 1: /drive/folders/{folder_id} (most common)
 	if strings.Contains(path, "/folders/") {
 		re := regexp.MustCompile(`/folders/([a-zA-Z0-9_-]+)`)
@@ -410,11 +753,37 @@ func (s *Service) looksLikeFolderID(str string) bool {
 	return matched
 }
 
+// errorReasons maps the reasons and statuses of Google Drive API errors to the codes users are shown
+// Reasons not listed here are classified by HTTP status
+var errorReasons = map[string]models.ProviderErrorCode{
+	"notFound":                    models.ProviderErrorNotFound,
+	"NOT_FOUND":                   models.ProviderErrorNotFound,
+	"forbidden":                   models.ProviderErrorForbidden,
+	"insufficientPermissions":     models.ProviderErrorForbidden,
+	"insufficientFilePermissions": models.ProviderErrorForbidden,
+	"appNotAuthorizedToFile":      models.ProviderErrorForbidden,
+	"cannotDownloadAbusiveFile":   models.ProviderErrorForbidden,
+	"domainPolicy":                models.ProviderErrorForbidden,
+	"PERMISSION_DENIED":           models.ProviderErrorForbidden,
+	"rateLimitExceeded":           models.ProviderErrorRateLimited,
+	"userRateLimitExceeded":       models.ProviderErrorRateLimited,
+	"sharingRateLimitExceeded":    models.ProviderErrorRateLimited,
+	"dailyLimitExceeded":          models.ProviderErrorQuota,
+	"quotaExceeded":               models.ProviderErrorQuota,
+	"storageQuotaExceeded":        models.ProviderErrorQuota,
+	"downloadQuotaExceeded":       models.ProviderErrorQuota,
+	"RESOURCE_EXHAUSTED":          models.ProviderErrorQuota,
+	"authError":                   models.ProviderErrorAuthExpired,
+	"UNAUTHENTICATED":             models.ProviderErrorAuthExpired,
+}
+
 // handleAPIError processes Google Drive API error responses
+// The raw error, which can name file IDs, is logged; the returned error only carries a message fit for users
 func (s *Service) handleAPIError(resp *http.Response) error {
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("API request failed with status %d", resp.StatusCode)
+		log.Printf("Google Drive API error (%d), body unreadable: %v", resp.StatusCode, err)
+		return models.NewProviderError("googledrive", resp.StatusCode, "")
 	}
 
 	var errorResponse struct {
@@ -422,13 +791,24 @@ func (s *Service) handleAPIError(resp *http.Response) error {
 			Code    int    `json:"code"`
 			Message string `json:"message"`
 			Status  string `json:"status"`
+			Errors  []struct {
+				Reason string `json:"reason"`
+			} `json:"errors"`
 		} `json:"error"`
 	}
 
 	if err := json.Unmarshal(body, &errorResponse); err != nil {
-		return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+		log.Printf("Google Drive API error (%d): %s", resp.StatusCode, string(body))
+		return models.NewProviderError("googledrive", resp.StatusCode, "")
 	}
 
-	return fmt.Errorf("google Drive API error (%d): %s - %s",
-		resp.StatusCode, errorResponse.Error.Status, errorResponse.Error.Message)
+	log.Printf("Google Drive API error (%d): %s - %s", resp.StatusCode, errorResponse.Error.Status, errorResponse.Error.Message)
+
+	// The detailed reason is more specific than the status, e.g. telling rate limits from other 403s
+	for _, detail := range errorResponse.Error.Errors {
+		if code, ok := errorReasons[detail.Reason]; ok {
+			return models.NewProviderError("googledrive", resp.StatusCode, code)
+		}
+	}
+	return models.NewProviderError("googledrive", resp.StatusCode, errorReasons[errorResponse.Error.Status])
 }
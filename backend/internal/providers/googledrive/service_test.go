@@ -0,0 +1,436 @@
+package googledrive
+
+import (
+	"all-me-backend/pkg/models"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func createTestService(baseURL string) *Service {
+	service := NewGoogleDriveService()
+	service.baseURL = baseURL
+	service.uploadURL = baseURL + "/upload"
+	return service
+}
+
+func TestListAllImages_SearchesWholeDrive(t *testing.T) {
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.Query().Get("q"))
+
+		var response APIResponse
+		if r.URL.Query().Get("pageToken") == "" {
+			response = APIResponse{
+				Files: []File{
+					{ID: "img-1", Name: "a.jpg", MimeType: "image/jpeg"},
+					{ID: "img-2", Name: "b.png", MimeType: "image/png"},
+				},
+				NextPageToken: "page-2",
+			}
+		} else {
+			response = APIResponse{
+				Files: []File{{ID: "img-3", Name: "c.jpg", MimeType: "image/jpeg"}},
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	service := createTestService(server.URL)
+	token := &models.Token{AccessToken: "token", Provider: "googledrive"}
+
+	images, err := service.ListAllImages(token, 0)
+	if err != nil {
+		t.Fatalf("ListAllImages failed: %v", err)
+	}
+
+	if len(images) != 3 {
+		t.Fatalf("Expected 3 images across pages, got %d", len(images))
+	}
+
+	if len(queries) != 2 {
+		t.Errorf("Expected 2 page requests, got %d", len(queries))
+	}
+
+	for _, query := range queries {
+		if query != "mimeType contains 'image/' and trashed = false" {
+			t.Errorf("Expected drive-wide image query, got %q", query)
+		}
+	}
+
+	if images[0].Provider != "googledrive" || images[0].FaceRecognitionOptimizedURL == "" {
+		t.Errorf("Expected converted Google Drive image, got %+v", images[0])
+	}
+}
+
+func TestListFolderContents_FiltersByModifiedTime(t *testing.T) {
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query().Get("q")
+		json.NewEncoder(w).Encode(APIResponse{})
+	}))
+	defer server.Close()
+
+	dates, err := models.ParseDateRange("2024-05-01", "2024-05-31")
+	if err != nil {
+		t.Fatalf("ParseDateRange failed: %v", err)
+	}
+
+	service := createTestService(server.URL)
+	if _, _, err := service.ListFolderContents(&models.CloudItem{ID: "folder"}, &models.Token{AccessToken: "token"}, 0, "", dates); err != nil {
+		t.Fatalf("ListFolderContents failed: %v", err)
+	}
+
+	want := "'folder' in parents and (mimeType = 'application/vnd.google-apps.folder' or " +
+		"(modifiedTime >= '2024-05-01T00:00:00Z' and modifiedTime < '2024-06-01T00:00:00Z'))"
+	if query != want {
+		t.Errorf("Expected query %q, got %q", want, query)
+	}
+}
+
+func TestConvertFileToCloudItem_SizeAndModifiedTime(t *testing.T) {
+	service := createTestService("")
+
+	photo := service.convertFileToCloudItem(File{ID: "1", Name: "a.jpg", MimeType: "image/jpeg", Size: "2048", LastModified: "2024-05-01T10:00:00Z"})
+	if photo.Size != 2048 || !photo.ModifiedTime.Equal(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected size 2048 modified 2024-05-01 10:00, got %d and %v", photo.Size, photo.ModifiedTime)
+	}
+
+	folder := service.convertFileToCloudItem(File{ID: "2", Name: "Album", MimeType: folderMimeType})
+	if folder.Size != 0 {
+		t.Errorf("Expected folders to report size 0, got %d", folder.Size)
+	}
+}
+
+func TestListAllImages_StopsAtLimit(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		json.NewEncoder(w).Encode(APIResponse{
+			Files: []File{
+				{ID: "img-1", Name: "a.jpg", MimeType: "image/jpeg"},
+				{ID: "img-2", Name: "b.jpg", MimeType: "image/jpeg"},
+			},
+			NextPageToken: "more",
+		})
+	}))
+	defer server.Close()
+
+	service := createTestService(server.URL)
+	images, err := service.ListAllImages(&models.Token{AccessToken: "token"}, 3)
+	if err != nil {
+		t.Fatalf("ListAllImages failed: %v", err)
+	}
+
+	if len(images) != 3 {
+		t.Errorf("Expected listing to stop at 3 images, got %d", len(images))
+	}
+
+	if requests != 2 {
+		t.Errorf("Expected 2 requests before reaching the limit, got %d", requests)
+	}
+}
+
+func TestParseShareLink_SharedAndMyDriveURLs(t *testing.T) {
+	const folderID = "1AbCdEfGhIjKlMnOpQrStUvWxYz012345"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/files/")
+
+		name := "Shared Folder"
+		if id == rootFolderID {
+			id = "0AresolvedRootId"
+			name = "My Drive"
+		}
+
+		json.NewEncoder(w).Encode(File{ID: id, Name: name, MimeType: "application/vnd.google-apps.folder"})
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		url      string
+		wantID   string
+		wantName string
+	}{
+		{"shared link", "https://drive.google.com/drive/folders/" + folderID + "?usp=sharing", folderID, "Shared Folder"},
+		{"own folder in multi-account URL", "https://drive.google.com/drive/u/1/folders/" + folderID, folderID, "Shared Folder"},
+		{"my drive", "https://drive.google.com/drive/my-drive", "0AresolvedRootId", "My Drive"},
+		{"my drive in multi-account URL", "https://drive.google.com/drive/u/0/my-drive", "0AresolvedRootId", "My Drive"},
+	}
+
+	service := createTestService(server.URL)
+	token := &models.Token{AccessToken: "token", Provider: "googledrive"}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			folder, err := service.ParseShareLink(tt.url, token)
+			if err != nil {
+				t.Fatalf("ParseShareLink failed: %v", err)
+			}
+
+			if folder.ID != tt.wantID || folder.Name != tt.wantName {
+				t.Errorf("Expected folder %s (%s), got %s (%s)", tt.wantID, tt.wantName, folder.ID, folder.Name)
+			}
+
+			if !folder.IsFolder || folder.Provider != "googledrive" {
+				t.Errorf("Expected a Google Drive folder, got %+v", folder)
+			}
+		})
+	}
+
+	if _, err := service.ParseShareLink("https://drive.google.com/file/d/"+folderID, token); err == nil {
+		t.Error("Expected error for a file link, got nil")
+	}
+}
+
+func TestCanonicalFolderID_SameFolderSameID(t *testing.T) {
+	const folderID = "1AbCdEfGhIjKlMnOpQrStUvWxYz012345"
+
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		json.NewEncoder(w).Encode(File{ID: folderID, Name: "My Drive", MimeType: "application/vnd.google-apps.folder"})
+	}))
+	defer server.Close()
+
+	service := createTestService(server.URL)
+	token := &models.Token{AccessToken: "token", Provider: "googledrive"}
+
+	links := []string{
+		"https://drive.google.com/drive/folders/" + folderID,
+		"https://drive.google.com/drive/folders/" + folderID + "?usp=sharing",
+		"https://drive.google.com/drive/u/1/folders/" + folderID + "/",
+		"https://drive.google.com/open?id=" + folderID,
+		"https://drive.google.com/drive/my-drive", // The user's root, which the server resolves to folderID
+	}
+
+	for _, link := range links {
+		id, err := service.CanonicalFolderID(link, token)
+		if err != nil {
+			t.Fatalf("CanonicalFolderID(%s) failed: %v", link, err)
+		}
+		if id != "googledrive:"+folderID {
+			t.Errorf("Expected googledrive:%s for %s, got %s", folderID, link, id)
+		}
+	}
+
+	if requests != 1 {
+		t.Errorf("Expected only the my-drive alias to be resolved through the API, got %d requests", requests)
+	}
+
+	if _, err := service.CanonicalFolderID("https://drive.google.com/file/d/"+folderID, token); err == nil {
+		t.Error("Expected error for a file link, got nil")
+	}
+}
+
+func TestGetRootFolder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/files/root" {
+			t.Errorf("Expected request for the root alias, got %s", r.URL.Path)
+		}
+
+		json.NewEncoder(w).Encode(File{ID: "0AresolvedRootId", Name: "My Drive", MimeType: "application/vnd.google-apps.folder"})
+	}))
+	defer server.Close()
+
+	service := createTestService(server.URL)
+	folder, err := service.GetRootFolder(&models.Token{AccessToken: "token"})
+	if err != nil {
+		t.Fatalf("GetRootFolder failed: %v", err)
+	}
+
+	if folder.ID != "0AresolvedRootId" || folder.Provider != "googledrive" {
+		t.Errorf("Expected resolved root folder, got %+v", folder)
+	}
+}
+
+func TestGetAccountID(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/about" || r.URL.Query().Get("fields") != "user(permissionId,displayName,emailAddress)" {
+			t.Errorf("Unexpected request: %s", r.URL)
+		}
+
+		w.Write([]byte(`{"user": {"permissionId": "01234567890123456789", "displayName": "Ana", "emailAddress": "ana@example.com"}}`))
+	}))
+	defer server.Close()
+
+	service := createTestService(server.URL)
+	accountID, err := service.GetAccountID(&models.Token{AccessToken: "token"})
+	if err != nil {
+		t.Fatalf("GetAccountID failed: %v", err)
+	}
+
+	if accountID != "01234567890123456789" {
+		t.Errorf("Expected the user's permission ID, got %s", accountID)
+	}
+
+	account, err := service.GetAccount(&models.Token{AccessToken: "token"})
+	if err != nil {
+		t.Fatalf("GetAccount failed: %v", err)
+	}
+	if account.ID != accountID || account.DisplayName != "Ana" || account.Email != "ana@example.com" {
+		t.Errorf("Expected the user's account details, got %+v", account)
+	}
+}
+
+func TestCopyToFolder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/files/file-1/copy" {
+			t.Errorf("Unexpected request: %s %s", r.Method, r.URL)
+		}
+
+		var body struct {
+			Name    string   `json:"name"`
+			Parents []string `json:"parents"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatalf("Failed to decode request: %v", err)
+		}
+		if body.Name != "photo.jpg" || len(body.Parents) != 1 || body.Parents[0] != "folder-1" {
+			t.Errorf("Expected photo.jpg copied into folder-1, got %+v", body)
+		}
+
+		w.Write([]byte(`{"id": "copy-1"}`))
+	}))
+	defer server.Close()
+
+	service := createTestService(server.URL)
+	item := &models.CloudItem{ID: "file-1", Name: "photo.jpg"}
+	folder := &models.CloudItem{ID: "folder-1", IsFolder: true}
+
+	if err := service.CopyToFolder(item, folder, &models.Token{AccessToken: "token"}); err != nil {
+		t.Fatalf("CopyToFolder failed: %v", err)
+	}
+}
+
+func TestUploadFile_SendsMetadataAndContent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/upload/files" || r.URL.Query().Get("uploadType") != "multipart" {
+			t.Errorf("Unexpected request: %s %s", r.Method, r.URL)
+		}
+
+		mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != "multipart/related" {
+			t.Fatalf("Expected a multipart/related upload, got %s", r.Header.Get("Content-Type"))
+		}
+		reader := multipart.NewReader(r.Body, params["boundary"])
+
+		metadataPart, err := reader.NextPart()
+		if err != nil {
+			t.Fatalf("Failed to read metadata part: %v", err)
+		}
+		var metadata struct {
+			Name    string   `json:"name"`
+			Parents []string `json:"parents"`
+		}
+		if err := json.NewDecoder(metadataPart).Decode(&metadata); err != nil {
+			t.Fatalf("Failed to decode metadata: %v", err)
+		}
+		if metadata.Name != "matches.json" || len(metadata.Parents) != 1 || metadata.Parents[0] != "folder-1" {
+			t.Errorf("Expected matches.json in folder-1, got %+v", metadata)
+		}
+
+		mediaPart, err := reader.NextPart()
+		if err != nil {
+			t.Fatalf("Failed to read media part: %v", err)
+		}
+		content, _ := io.ReadAll(mediaPart)
+		if mediaPart.Header.Get("Content-Type") != "application/json" || string(content) != `{"matches":[]}` {
+			t.Errorf("Unexpected media part %s: %s", mediaPart.Header.Get("Content-Type"), content)
+		}
+
+		w.Write([]byte(`{"id": "file-1"}`))
+	}))
+	defer server.Close()
+
+	service := createTestService(server.URL)
+	folder := &models.CloudItem{ID: "folder-1", IsFolder: true}
+
+	if err := service.UploadFile(folder, "matches.json", "application/json", []byte(`{"matches":[]}`), &models.Token{AccessToken: "token"}); err != nil {
+		t.Fatalf("UploadFile failed: %v", err)
+	}
+}
+
+func TestDetectShareLink(t *testing.T) {
+	service := createTestService("")
+
+	tests := map[string]models.ShareLinkKind{
+		"https://drive.google.com/drive/folders/1AbCdEfGhIjKlMnOpQrStUvWxYz": models.ShareLinkFolder,
+		"https://drive.google.com/drive/my-drive":                            models.ShareLinkFolder,
+		"https://drive.google.com/file/d/1AbCdEfGhIjKlMnOpQrStUvWxYz/view":   models.ShareLinkFile,
+		"https://1drv.ms/f/s!AbCdEf":                                         models.ShareLinkUnrecognized,
+		"ftp://drive.google.com/drive/folders/abc":                           models.ShareLinkUnrecognized,
+	}
+
+	for link, expected := range tests {
+		if kind := service.DetectShareLink(link); kind != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, link, kind)
+		}
+	}
+}
+
+func TestNewGoogleDriveService_UsesConfiguredEndpoints(t *testing.T) {
+	t.Setenv("GOOGLEDRIVE_API_URL", "https://drive.example.gov/drive/v3")
+	t.Setenv("GOOGLEDRIVE_UPLOAD_URL", "https://drive.example.gov/upload/drive/v3")
+	t.Setenv("GOOGLEDRIVE_AUTH_URL", "not a url")
+	t.Setenv("GOOGLEDRIVE_TOKEN_URL", "https://oauth.example.gov/token")
+
+	service := NewGoogleDriveService()
+
+	if service.baseURL != "https://drive.example.gov/drive/v3" || service.uploadURL != "https://drive.example.gov/upload/drive/v3" {
+		t.Errorf("Expected the configured API URLs, got %q and %q", service.baseURL, service.uploadURL)
+	}
+	if service.config.TokenURL != "https://oauth.example.gov/token" {
+		t.Errorf("Expected the configured token URL, got %q", service.config.TokenURL)
+	}
+	if service.config.AuthURL != "https://accounts.google.com/o/oauth2/v2/auth" {
+		t.Errorf("Expected the default auth URL for an invalid URL, got %q", service.config.AuthURL)
+	}
+}
+
+func TestHandleAPIError_MapsReasonsWithoutLeakingDetail(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		wantCode models.ProviderErrorCode
+	}{
+		{"not found", http.StatusNotFound, `{"error": {"code": 404, "message": "File not found: abc123", "errors": [{"reason": "notFound"}]}}`, models.ProviderErrorNotFound},
+		{"rate limit over status", http.StatusForbidden, `{"error": {"code": 403, "message": "User rate limit exceeded for abc123", "errors": [{"reason": "userRateLimitExceeded"}]}}`, models.ProviderErrorRateLimited},
+		{"quota", http.StatusForbidden, `{"error": {"code": 403, "message": "The download quota for abc123 has been exceeded", "errors": [{"reason": "downloadQuotaExceeded"}]}}`, models.ProviderErrorQuota},
+		{"status without reason", http.StatusUnauthorized, `{"error": {"code": 401, "message": "Invalid Credentials", "status": "UNAUTHENTICATED"}}`, models.ProviderErrorAuthExpired},
+		{"unknown reason falls back to status", http.StatusForbidden, `{"error": {"code": 403, "message": "abc123", "errors": [{"reason": "somethingNew"}]}}`, models.ProviderErrorForbidden},
+		{"not JSON", http.StatusInternalServerError, `<html>abc123</html>`, models.ProviderErrorFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			service := createTestService(server.URL)
+			_, err := service.GetItem(&models.CloudItem{ID: "abc123"}, &models.Token{AccessToken: "token"})
+
+			providerErr, ok := models.AsProviderError(err)
+			if !ok || providerErr.Code != tt.wantCode {
+				t.Fatalf("Expected a provider error with code %s, got %v", tt.wantCode, err)
+			}
+			if strings.Contains(err.Error(), "abc123") {
+				t.Errorf("Expected the message to leave out the file ID, got %q", err.Error())
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package httptransport
+
+import (
+	"all-me-backend/pkg/config"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Defaults sized for the shared worker pool: every concurrent provider request can keep its connection
+// alive between images instead of reconnecting, which the default of 2 idle connections per host forces
+const (
+	defaultMaxIdleConns        = 256
+	defaultMaxIdleConnsPerHost = 64
+	defaultMaxConnsPerHost     = 0 // Unlimited; the worker pool already bounds concurrency
+	defaultIdleConnTimeout     = 90
+)
+
+var (
+	shared     *http.Transport
+	sharedOnce sync.Once
+)
+
+// Shared returns the transport shared by every outbound client, so provider and face service
+// calls reuse one tuned pool of keep-alive and HTTP/2 connections
+func Shared() *http.Transport {
+	sharedOnce.Do(func() {
+		shared = New()
+	})
+
+	return shared
+}
+
+// New creates a transport tuned from HTTP_MAX_IDLE_CONNS, HTTP_MAX_IDLE_CONNS_PER_HOST,
+// HTTP_MAX_CONNS_PER_HOST and HTTP_IDLE_CONN_TIMEOUT_SECONDS
+// Clients needing their own timeouts start from New rather than changing the shared transport
+func New() *http.Transport {
+	maxIdleConns := config.GetInt("HTTP_MAX_IDLE_CONNS", defaultMaxIdleConns)
+	if maxIdleConns < 1 {
+		maxIdleConns = defaultMaxIdleConns
+	}
+
+	maxIdleConnsPerHost := config.GetInt("HTTP_MAX_IDLE_CONNS_PER_HOST", defaultMaxIdleConnsPerHost)
+	if maxIdleConnsPerHost < 1 {
+		maxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	}
+
+	maxConnsPerHost := config.GetInt("HTTP_MAX_CONNS_PER_HOST", defaultMaxConnsPerHost)
+	if maxConnsPerHost < 0 {
+		maxConnsPerHost = defaultMaxConnsPerHost
+	}
+
+	idleConnTimeout := config.GetInt("HTTP_IDLE_CONN_TIMEOUT_SECONDS", defaultIdleConnTimeout)
+	if idleConnTimeout < 1 {
+		idleConnTimeout = defaultIdleConnTimeout
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          maxIdleConns,
+		MaxIdleConnsPerHost:   maxIdleConnsPerHost,
+		MaxConnsPerHost:       maxConnsPerHost,
+		IdleConnTimeout:       time.Duration(idleConnTimeout) * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
@@ -1,21 +1,48 @@
 package onedrive
 
+import "time"
+
 type DriveItem struct {
-	ID   string `json:"id"`
+	ID              string              `json:"id"`
+	Name            string              `json:"name"`
+	File            *FileFacet          `json:"file,omitempty"`
+	Folder          *FolderFacet        `json:"folder,omitempty"`
+	Bundle          *BundleFacet        `json:"bundle,omitempty"` // Set for albums and other bundles, which are listed like folders
+	SpecialFolder   *SpecialFolderFacet `json:"specialFolder,omitempty"`
+	ParentReference *ItemReference      `json:"parentReference,omitempty"`
+	DownloadURL     string              `json:"@microsoft.graph.downloadUrl"`
+	Thumbnails      []ThumbnailSet      `json:"thumbnails,omitempty"`
+	LastModified    time.Time           `json:"lastModifiedDateTime"`
+	Size            int64               `json:"size"` // For folders, the total size of their contents
+}
+
+type FileFacet struct {
+	MimeType string `json:"mimeType"`
+}
+
+type FolderFacet struct {
+	ChildCount int `json:"childCount"`
+}
+
+// BundleFacet marks a bundle, a collection of items (such as a photo album) that live elsewhere in the drive
+type BundleFacet struct {
+	ChildCount int         `json:"childCount"`
+	Album      *AlbumFacet `json:"album,omitempty"`
+}
+
+// SpecialFolderFacet marks one of the drive's special folders, such as Documents, Photos or the Personal Vault
+type SpecialFolderFacet struct {
 	Name string `json:"name"`
-	File *struct {
-		MimeType string `json:"mimeType"`
-	} `json:"file,omitempty"`
-	Folder *struct {
-		ChildCount int `json:"childCount"`
-	} `json:"folder,omitempty"`
-	ParentReference *struct {
-		Path    string `json:"path"`
-		DriveId string `json:"driveId"`
-		Id      string `json:"id"`
-	} `json:"parentReference,omitempty"`
-	DownloadURL string         `json:"@microsoft.graph.downloadUrl"`
-	Thumbnails  []ThumbnailSet `json:"thumbnails,omitempty"`
+}
+
+type AlbumFacet struct {
+	CoverImageItemID string `json:"coverImageItemId,omitempty"`
+}
+
+type ItemReference struct {
+	Path    string `json:"path"`
+	DriveId string `json:"driveId"`
+	Id      string `json:"id"`
 }
 
 type ThumbnailSet struct {
@@ -32,6 +59,14 @@ type Thumbnail struct {
 	Height int    `json:"height"`
 }
 
+// User is the subset of the Graph /me resource identifying the signed-in user
+type User struct {
+	ID                string `json:"id"`
+	DisplayName       string `json:"displayName,omitempty"`
+	Mail              string `json:"mail,omitempty"`
+	UserPrincipalName string `json:"userPrincipalName,omitempty"` // Sign-in name, set when Mail is not
+}
+
 type APIResponse struct {
 	Value    []DriveItem `json:"value"`
 	NextLink string      `json:"@odata.nextLink,omitempty"`
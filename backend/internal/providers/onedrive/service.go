@@ -1,11 +1,17 @@
 package onedrive
 
 import (
+	"all-me-backend/internal/providers/httptransport"
+	"all-me-backend/internal/providers/throttle"
+	"all-me-backend/pkg/config"
 	"all-me-backend/pkg/models"
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"net/url"
 	"os"
@@ -19,18 +25,39 @@ type Service struct {
 	config     *models.OAuthConfig
 }
 
+// defaultWriteScope lets saved matches be copied into the user's drive
+const defaultWriteScope = "Files.ReadWrite.All"
+
+// thumbnailsExpand requests the preferred thumbnail sizes along with the standard ones they fall back to
+const thumbnailsExpand = "thumbnails($select=c400x400,large,medium,small)"
+
 // NewOneDriveService creates a new OneDrive service
 func NewOneDriveService() *Service {
+	// offline_access issues a refresh token, so long comparisons can outlive the one-hour access token
+	scopes := []string{"Files.Read.All", "offline_access"}
+	var writeScope string
+	if config.GetBool("SAVE_TO_DRIVE_ENABLED", false) {
+		writeScope = config.GetString("ONEDRIVE_WRITE_SCOPE", defaultWriteScope)
+		scopes = append(scopes, writeScope)
+	}
+
 	return &Service{
-		httpClient: &http.Client{Timeout: 30 * time.Second},
-		baseURL:    "https://graph.microsoft.com/v1.0",
+		httpClient: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: throttle.NewTransport(throttle.ForProvider("onedrive"), httptransport.Shared()),
+		},
+		// Sovereign clouds use their own endpoints, e.g. https://graph.microsoft.us/v1.0 for GCC High
+		baseURL: config.GetHTTPSURL("ONEDRIVE_API_URL", "https://graph.microsoft.com/v1.0"),
+		// No RevokeURL: the Microsoft identity platform has no endpoint revoking a single token, and its logout
+		// endpoint only ends the browser's sign-in; a signed-out token lapses when it expires within the hour
 		config: &models.OAuthConfig{
 			ClientID:     os.Getenv("ONEDRIVE_CLIENT_ID"),
 			ClientSecret: os.Getenv("ONEDRIVE_CLIENT_SECRET"),
 			RedirectURI:  os.Getenv("ONEDRIVE_REDIRECT_URI"),
-			Scopes:       []string{"Files.Read.All"},
-			AuthURL:      "https://login.microsoftonline.com/common/oauth2/v2.0/authorize",
-			TokenURL:     "https://login.microsoftonline.com/common/oauth2/v2.0/token",
+			Scopes:       scopes,
+			WriteScope:   writeScope,
+			AuthURL:      config.GetHTTPSURL("ONEDRIVE_AUTH_URL", "https://login.microsoftonline.com/common/oauth2/v2.0/authorize"),
+			TokenURL:     config.GetHTTPSURL("ONEDRIVE_TOKEN_URL", "https://login.microsoftonline.com/common/oauth2/v2.0/token"),
 			Provider:     "onedrive",
 		},
 	}
@@ -42,13 +69,15 @@ func (s *Service) GetOAuthConfig() *models.OAuthConfig {
 }
 
 // BuildAuthURL constructs the OAuth authorization URL for OneDrive
-func (s *Service) BuildAuthURL(state string) (string, error) {
+func (s *Service) BuildAuthURL(state, codeChallenge string) (string, error) {
 	params := url.Values{}
 	params.Add("client_id", s.config.ClientID)
 	params.Add("redirect_uri", s.config.RedirectURI)
 	params.Add("response_type", "code")
 	params.Add("scope", strings.Join(s.config.Scopes, " "))
 	params.Add("state", state)
+	params.Add("code_challenge", codeChallenge)
+	params.Add("code_challenge_method", "S256")
 	params.Add("response_mode", "query")
 
 	authURL := s.config.AuthURL + "?" + params.Encode()
@@ -77,8 +106,7 @@ func (s *Service) buildAPIURL(item *models.CloudItem, pageSize int, nextPageToke
 		params.Add("$top", fmt.Sprintf("%d", pageSize))
 	}
 	// Request custom thumbnail sizes: c400x400 for display, large (800px) for face recognition
-	// Format: $expand=thumbnails($select=c400x400,large)
-	params.Add("$expand", "thumbnails($select=c400x400,large)")
+	params.Add("$expand", thumbnailsExpand)
 
 	if isRootShare {
 		// This is the root shared folder - use shares API directly
@@ -110,8 +138,26 @@ func (s *Service) buildAPIURL(item *models.CloudItem, pageSize int, nextPageToke
 	return
 }
 
+// restrictedSpecialFolders are special folders Graph lists but apps cannot read into,
+// keyed by their specialFolder facet name
+var restrictedSpecialFolders = map[string]bool{
+	"vault": true, // Personal Vault, which stays locked to apps even while the user has it unlocked
+}
+
+// isRestricted reports whether an item is a special folder apps cannot access
+func (item DriveItem) isRestricted() bool {
+	return item.SpecialFolder != nil && restrictedSpecialFolders[strings.ToLower(item.SpecialFolder.Name)]
+}
+
 // ListFolderContents lists all items in a OneDrive folder with pagination support
-func (s *Service) ListFolderContents(item *models.CloudItem, token *models.Token, pageSize int, nextPageToken string) ([]*models.CloudItem, string, error) {
+// Restricted special folders are left out, as listing them would only fail
+// Graph cannot filter folder listings by date, so dates is applied by the storage service instead
+func (s *Service) ListFolderContents(item *models.CloudItem, token *models.Token, pageSize int, nextPageToken string, dates models.DateRange) ([]*models.CloudItem, string, error) {
+	// Page tokens are Graph nextLink URLs and can come from clients, so the token is only ever sent to Graph
+	if nextPageToken != "" && !strings.HasPrefix(nextPageToken, s.baseURL+"/") {
+		return nil, "", errors.New("invalid page token")
+	}
+
 	apiURL, shareToken, currentPath, driveID := s.buildAPIURL(item, pageSize, nextPageToken)
 
 	req, err := http.NewRequest("GET", apiURL, nil)
@@ -134,8 +180,7 @@ func (s *Service) ListFolderContents(item *models.CloudItem, token *models.Token
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, "", fmt.Errorf("OneDrive list API error (status %d) for folder ID '%s' at URL '%s': %s",
-			resp.StatusCode, item.ID, apiURL, string(body))
+		return nil, "", apiError(fmt.Sprintf("list of folder '%s' at URL '%s'", item.ID, apiURL), resp.StatusCode, body)
 	}
 
 	// Parse response as standard API response (both initial and paginated requests use same format)
@@ -147,6 +192,9 @@ func (s *Service) ListFolderContents(item *models.CloudItem, token *models.Token
 	// Convert OneDrive items to CloudItem format
 	var items []*models.CloudItem
 	for _, driveItem := range oneDriveResp.Value {
+		if driveItem.isRestricted() {
+			continue
+		}
 		cloudItem := s.convertDriveItemToCloudItem(driveItem, shareToken, currentPath, driveID)
 		items = append(items, cloudItem)
 	}
@@ -154,9 +202,241 @@ func (s *Service) ListFolderContents(item *models.CloudItem, token *models.Token
 	return items, oneDriveResp.NextLink, nil
 }
 
+// GetRootFolder returns the root of the user's own drive
+// "root" is a Graph alias, so it can be listed through /me/drive/items/root/children
+func (s *Service) GetRootFolder(token *models.Token) (*models.CloudItem, error) {
+	return &models.CloudItem{
+		ID:       "root",
+		Name:     "My files",
+		IsFolder: true,
+		Provider: "onedrive",
+	}, nil
+}
+
+// GetAccountID returns the signed-in user's Graph ID
+func (s *Service) GetAccountID(token *models.Token) (string, error) {
+	account, err := s.GetAccount(token)
+	if err != nil {
+		return "", err
+	}
+	return account.ID, nil
+}
+
+// GetAccount returns the signed-in user, identified by their Graph ID
+func (s *Service) GetAccount(token *models.Token) (*models.Account, error) {
+	req, err := http.NewRequest("GET", s.baseURL+"/me?$select=id,displayName,mail,userPrincipalName", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.AccessToken))
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, apiError("user lookup", resp.StatusCode, body)
+	}
+
+	var user User
+	if err := json.Unmarshal(body, &user); err != nil {
+		return nil, fmt.Errorf("failed to decode user response: %w", err)
+	}
+
+	if user.ID == "" {
+		return nil, fmt.Errorf("account ID missing from response")
+	}
+
+	email := user.Mail
+	if email == "" {
+		email = user.UserPrincipalName
+	}
+
+	return &models.Account{
+		Provider:    "onedrive",
+		ID:          user.ID,
+		DisplayName: user.DisplayName,
+		Email:       email,
+	}, nil
+}
+
+// CopyToFolder asks Graph to copy a file into a folder of the user's drive, renaming it on a name clash
+// Graph copies asynchronously, so a nil error means the copy was accepted rather than finished
+func (s *Service) CopyToFolder(item, destination *models.CloudItem, token *models.Token) error {
+	driveID, folderID, err := s.resolveFolderReference(destination, token)
+	if err != nil {
+		return err
+	}
+
+	var apiURL string
+	if item.DriveID != "" {
+		apiURL = fmt.Sprintf("%s/drives/%s/items/%s/copy", s.baseURL, url.PathEscape(item.DriveID), url.PathEscape(item.ID))
+	} else {
+		apiURL = fmt.Sprintf("%s/me/drive/items/%s/copy", s.baseURL, url.PathEscape(item.ID))
+	}
+	apiURL += "?@microsoft.graph.conflictBehavior=rename"
+
+	payload, err := json.Marshal(map[string]any{
+		"parentReference": map[string]string{"driveId": driveID, "id": folderID},
+		"name":            item.Name,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", apiURL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.AccessToken))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		return apiError("copy", resp.StatusCode, body)
+	}
+
+	return nil
+}
+
+// UploadFile writes a new file into a folder of the user's drive with a simple upload, renaming it on a name clash
+// Simple uploads suit the small files written by the backend; large files would need an upload session
+func (s *Service) UploadFile(destination *models.CloudItem, name, mimeType string, content []byte, token *models.Token) error {
+	driveID, folderID, err := s.resolveFolderReference(destination, token)
+	if err != nil {
+		return err
+	}
+
+	apiURL := fmt.Sprintf("%s/drives/%s/items/%s:/%s:/content?@microsoft.graph.conflictBehavior=rename",
+		s.baseURL, url.PathEscape(driveID), url.PathEscape(folderID), url.PathEscape(name))
+
+	req, err := http.NewRequest("PUT", apiURL, bytes.NewReader(content))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.AccessToken))
+	req.Header.Set("Content-Type", mimeType)
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		return apiError("upload", resp.StatusCode, body)
+	}
+
+	return nil
+}
+
+// resolveFolderReference returns the drive and item IDs Graph needs to address a folder as a copy target
+// Folders from the user's own drive, including the "root" alias, are looked up to find their drive
+func (s *Service) resolveFolderReference(folder *models.CloudItem, token *models.Token) (string, string, error) {
+	if folder.DriveID != "" && folder.ID != "root" {
+		return folder.DriveID, folder.ID, nil
+	}
+
+	apiURL := fmt.Sprintf("%s/me/drive/items/%s?$select=id,parentReference", s.baseURL, url.PathEscape(folder.ID))
+
+	req, err := http.NewRequest("GET", apiURL, nil)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.AccessToken))
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", "", apiError("destination folder lookup", resp.StatusCode, body)
+	}
+
+	var item DriveItem
+	if err := json.Unmarshal(body, &item); err != nil {
+		return "", "", fmt.Errorf("failed to decode destination folder: %w", err)
+	}
+
+	if item.ParentReference == nil || item.ParentReference.DriveId == "" {
+		return "", "", fmt.Errorf("destination folder has no drive")
+	}
+
+	return item.ParentReference.DriveId, item.ID, nil
+}
+
+// ListAllImages walks the user's entire drive from the root and collects image files,
+// stopping once limit images are found
+func (s *Service) ListAllImages(token *models.Token, limit int) ([]*models.CloudItem, error) {
+	const pageSize = 200
+
+	var images []*models.CloudItem
+	folders := []*models.CloudItem{{ID: "root", IsFolder: true}}
+
+	for len(folders) > 0 {
+		folder := folders[0]
+		folders = folders[1:]
+
+		var nextPageToken string
+		for {
+			items, nextToken, err := s.ListFolderContents(folder, token, pageSize, nextPageToken, models.DateRange{})
+			if err != nil {
+				return nil, err
+			}
+
+			for _, item := range items {
+				if item.IsFolder {
+					folders = append(folders, item)
+					continue
+				}
+
+				if strings.HasPrefix(item.MimeType, "image/") {
+					images = append(images, item)
+					if limit > 0 && len(images) >= limit {
+						return images, nil
+					}
+				}
+			}
+
+			if nextToken == "" {
+				break
+			}
+			nextPageToken = nextToken
+		}
+	}
+
+	return images, nil
+}
+
 // convertDriveItemToCloudItem converts a OneDrive DriveItem to CloudItem format
 func (s *Service) convertDriveItemToCloudItem(item DriveItem, shareToken string, parentPath string, parentDriveID string) *models.CloudItem {
-	isFolder := item.Folder != nil
+	// Albums are bundles rather than folders, but their contents are listed the same way
+	isFolder := item.Folder != nil || item.Bundle != nil
 
 	var mimeType string
 	if item.File != nil {
@@ -189,19 +469,23 @@ func (s *Service) convertDriveItemToCloudItem(item DriveItem, shareToken string,
 
 	if !isFolder && item.File != nil && strings.HasPrefix(mimeType, "image/") {
 		// Use thumbnail URLs from the API response if available
+		// Preferred sizes aren't always generated yet, e.g. for just-uploaded or unusual formats
+		var thumbnailSet ThumbnailSet
 		if len(item.Thumbnails) > 0 {
-			thumbnailSet := item.Thumbnails[0]
+			thumbnailSet = item.Thumbnails[0]
+		}
 
-			// Use large thumbnail (800px) for face recognition processing
-			if thumbnailSet.Large.URL != "" {
-				faceRecognitionOptimizedURL = thumbnailSet.Large.URL
-			}
+		// Use large thumbnail (800px) for face recognition processing, then medium, then the full image
+		faceRecognitionOptimizedURL = firstNonEmpty(thumbnailSet.Large.URL, thumbnailSet.Medium.URL, downloadURL)
 
-			// Use custom 400px thumbnail for display (higher quality than medium's 176px)
-			if thumbnailSet.C400x400.URL != "" {
-				thumbnailURL = thumbnailSet.C400x400.URL
-			}
-		}
+		// Use custom 400px thumbnail for display (higher quality than medium's 176px), then smaller ones
+		thumbnailURL = firstNonEmpty(thumbnailSet.C400x400.URL, thumbnailSet.Medium.URL, thumbnailSet.Small.URL)
+	}
+
+	// Folders report zero like on the other providers, rather than OneDrive's total of their contents
+	var size int64
+	if !isFolder {
+		size = item.Size
 	}
 
 	return &models.CloudItem{
@@ -216,9 +500,52 @@ func (s *Service) convertDriveItemToCloudItem(item DriveItem, shareToken string,
 		ParentShareToken:            shareToken,                  // Preserve share token for recursive access
 		ParentPath:                  itemPath,                    // Path from share root for API navigation
 		DriveID:                     driveID,                     // OneDrive drive ID for direct access
+		ModifiedTime:                item.LastModified,
+		Size:                        size,
 	}
 }
 
+// GetItem fetches an item's metadata by ID (and drive ID for shared items) and returns
+// it with a fresh pre-authenticated download URL
+func (s *Service) GetItem(item *models.CloudItem, token *models.Token) (*models.CloudItem, error) {
+	var apiURL string
+	if item.DriveID != "" {
+		apiURL = fmt.Sprintf("%s/drives/%s/items/%s", s.baseURL, url.PathEscape(item.DriveID), url.PathEscape(item.ID))
+	} else {
+		apiURL = fmt.Sprintf("%s/me/drive/items/%s", s.baseURL, url.PathEscape(item.ID))
+	}
+	apiURL += "?$expand=" + url.QueryEscape(thumbnailsExpand)
+
+	req, err := http.NewRequest("GET", apiURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.AccessToken))
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, apiError(fmt.Sprintf("lookup of item '%s'", item.ID), resp.StatusCode, body)
+	}
+
+	var driveItem DriveItem
+	if err := json.Unmarshal(body, &driveItem); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return s.convertDriveItemToCloudItem(driveItem, item.ParentShareToken, "", item.DriveID), nil
+}
+
 // GetFileStream retrieves a file stream for downloading (full resolution)
 func (s *Service) GetFileStream(item *models.CloudItem, token *models.Token) (io.ReadCloser, error) {
 	if item.DownloadURL == "" {
@@ -269,22 +596,21 @@ func (s *Service) downloadFromURL(url string, token *models.Token) (io.ReadClose
 	}
 
 	if downloadResp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(downloadResp.Body)
 		downloadResp.Body.Close()
-		return nil, fmt.Errorf("OneDrive download error (status %d)", downloadResp.StatusCode)
+		return nil, apiError("download", downloadResp.StatusCode, body)
 	}
 
 	return downloadResp.Body, nil
 }
 
 // ParseShareLink parses a OneDrive share link to extract folder information and fetch folder details
+// Album (bundle) links resolve to an item that is compared and downloaded like a folder
 func (s *Service) ParseShareLink(shareURL string, token *models.Token) (*models.CloudItem, error) {
 	if err := s.validateShareLink(shareURL); err != nil {
 		return nil, err
 	}
 
-	// Encode the share URL to get the share token
-	shareToken := s.encodeShareToken(shareURL)
-
 	// Use the shares API directly with the original URL
 	// This avoids the need to reconstruct URLs or hardcode user IDs
 	folderInfo, err := s.getFolderInfoFromShareURL(shareURL, token)
@@ -292,15 +618,72 @@ func (s *Service) ParseShareLink(shareURL string, token *models.Token) (*models.
 		return nil, fmt.Errorf("failed to get folder info: %w", err)
 	}
 
-	// Set the provider field and use share token as ID for ListFolderContents
 	folderInfo.Provider = "onedrive"
-	folderInfo.ID = shareToken // Use share token instead of actual folder ID
 
 	return folderInfo, nil
 }
 
-// getFolderInfoFromShareURL retrieves information about a OneDrive folder using the shares API
+// CanonicalFolderID returns a stable "onedrive:{driveID}/{itemID}" identifier for a folder link
+// Short and long links to the same folder encode to different share tokens, so the link is
+// resolved to its drive item through the shares API
+func (s *Service) CanonicalFolderID(shareURL string, token *models.Token) (string, error) {
+	if err := s.validateShareLink(shareURL); err != nil {
+		return "", err
+	}
+
+	item, err := s.getSharedDriveItem(shareURL, token)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve share link: %w", err)
+	}
+
+	var driveID string
+	if item.ParentReference != nil {
+		driveID = item.ParentReference.DriveId
+	}
+
+	return fmt.Sprintf("onedrive:%s/%s", driveID, item.ID), nil
+}
+
+// getFolderInfoFromShareURL retrieves information about a shared OneDrive folder or album using the shares API
 func (s *Service) getFolderInfoFromShareURL(shareURL string, token *models.Token) (*models.CloudItem, error) {
+	item, err := s.getSharedDriveItem(shareURL, token)
+	if err != nil {
+		return nil, err
+	}
+
+	if item.isRestricted() {
+		return nil, models.ErrRestrictedLocation
+	}
+
+	if item.Folder == nil && item.Bundle == nil {
+		return nil, fmt.Errorf("shared item '%s' is not a folder or album", item.Name)
+	}
+
+	// Extract drive ID from the response if available
+	var driveID string
+	if item.ParentReference != nil && item.ParentReference.DriveId != "" {
+		driveID = item.ParentReference.DriveId
+	}
+
+	// Folders are listed through the share token, which works for any recipient
+	// Album contents are references to items elsewhere in the owner's drive, which the shares API
+	// does not expand, so albums are listed as a drive item instead
+	id := s.encodeShareToken(shareURL)
+	if item.Bundle != nil && driveID != "" {
+		id = item.ID
+	}
+
+	return &models.CloudItem{
+		ID:       id,
+		Name:     item.Name,
+		MimeType: "application/vnd.onedrive.folder",
+		IsFolder: true,
+		DriveID:  driveID, // Store drive ID for subfolder navigation
+	}, nil
+}
+
+// getSharedDriveItem fetches the drive item a share link points at using the shares API
+func (s *Service) getSharedDriveItem(shareURL string, token *models.Token) (*DriveItem, error) {
 	// Encode the share URL for the shares API
 	shareToken := s.encodeShareToken(shareURL)
 
@@ -332,7 +715,7 @@ func (s *Service) getFolderInfoFromShareURL(shareURL string, token *models.Token
 
 	// Check response status
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("shares API failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, apiError("share lookup", resp.StatusCode, body)
 	}
 
 	// Parse response
@@ -341,37 +724,47 @@ func (s *Service) getFolderInfoFromShareURL(shareURL string, token *models.Token
 		return nil, fmt.Errorf("failed to decode shares response: %w", err)
 	}
 
-	// Extract drive ID from the response if available
-	var driveID string
-	if item.ParentReference != nil && item.ParentReference.DriveId != "" {
-		driveID = item.ParentReference.DriveId
-	}
-
-	return &models.CloudItem{
-		ID:       shareToken, // Use share token as ID for consistent access
-		Name:     item.Name,
-		MimeType: "application/vnd.onedrive.folder",
-		IsFolder: true,
-		DriveID:  driveID, // Store drive ID for subfolder navigation
-	}, nil
+	return &item, nil
 }
 
 // validateShareLink checks if the URL is a valid OneDrive share link
 func (s *Service) validateShareLink(shareURL string) error {
+	parsedURL, err := s.parseOneDriveURL(shareURL)
+	if err != nil {
+		return err
+	}
+
+	// Additional validation for 1drv.ms short links
+	if strings.ToLower(parsedURL.Host) == "1drv.ms" {
+		path := strings.Trim(parsedURL.Path, "/")
+		if path == "" {
+			return fmt.Errorf("OneDrive short link is missing path")
+		}
+		// Should start with /f/ for folders or /a/ for albums
+		if !strings.HasPrefix(path, "f/") && !strings.HasPrefix(path, "a/") {
+			return fmt.Errorf("OneDrive link does not appear to be a folder or album link")
+		}
+	}
+
+	return nil
+}
+
+// parseOneDriveURL parses a URL and checks that it points at a OneDrive host
+func (s *Service) parseOneDriveURL(shareURL string) (*url.URL, error) {
 	// Clean the URL
 	cleanURL := strings.TrimSpace(shareURL)
 	if cleanURL == "" {
-		return fmt.Errorf("share URL cannot be empty")
+		return nil, fmt.Errorf("share URL cannot be empty")
 	}
 
 	parsedURL, err := url.Parse(cleanURL)
 	if err != nil {
-		return fmt.Errorf("invalid URL format: %w", err)
+		return nil, fmt.Errorf("invalid URL format: %w", err)
 	}
 
 	// Check if it has a valid scheme
 	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
-		return fmt.Errorf("URL must use http or https scheme")
+		return nil, fmt.Errorf("URL must use http or https scheme")
 	}
 
 	// Check if it's a OneDrive domain
@@ -392,22 +785,21 @@ func (s *Service) validateShareLink(shareURL string) error {
 	}
 
 	if !isValidHost {
-		return fmt.Errorf("not a OneDrive share link (invalid host: %s)", host)
+		return nil, fmt.Errorf("not a OneDrive share link (invalid host: %s)", host)
 	}
 
-	// Additional validation for 1drv.ms short links
-	if host == "1drv.ms" {
-		path := strings.Trim(parsedURL.Path, "/")
-		if path == "" {
-			return fmt.Errorf("OneDrive short link is missing path")
-		}
-		// Should start with /f/ for folders
-		if !strings.HasPrefix(path, "f/") {
-			return fmt.Errorf("OneDrive link does not appear to be a folder link")
-		}
-	}
+	return parsedURL, nil
+}
 
-	return nil
+// DetectShareLink classifies a link by its shape alone, without calling the Graph API
+func (s *Service) DetectShareLink(shareURL string) models.ShareLinkKind {
+	if _, err := s.parseOneDriveURL(shareURL); err != nil {
+		return models.ShareLinkUnrecognized
+	}
+	if err := s.validateShareLink(shareURL); err != nil {
+		return models.ShareLinkFile
+	}
+	return models.ShareLinkFolder
 }
 
 // encodeShareToken encodes a share URL for use with OneDrive shares API
@@ -431,3 +823,40 @@ func (s *Service) encodeShareToken(shareURL string) string {
 
 	return shareToken
 }
+
+// firstNonEmpty returns the first non-empty URL, in order of preference
+func firstNonEmpty(urls ...string) string {
+	for _, u := range urls {
+		if u != "" {
+			return u
+		}
+	}
+	return ""
+}
+
+// graphErrorCodes maps Microsoft Graph error codes to the codes users are shown
+// Codes not listed here are classified by HTTP status
+var graphErrorCodes = map[string]models.ProviderErrorCode{
+	"itemNotFound":               models.ProviderErrorNotFound,
+	"accessDenied":               models.ProviderErrorForbidden,
+	"notAllowed":                 models.ProviderErrorForbidden,
+	"activityLimitReached":       models.ProviderErrorRateLimited,
+	"quotaLimitReached":          models.ProviderErrorQuota,
+	"InvalidAuthenticationToken": models.ProviderErrorAuthExpired,
+	"unauthenticated":            models.ProviderErrorAuthExpired,
+}
+
+// apiError logs a failed Graph call with its raw response, which can name items and share tokens,
+// and returns an error carrying only a message fit for users
+func apiError(operation string, statusCode int, body []byte) error {
+	log.Printf("OneDrive %s failed (status %d): %s", operation, statusCode, string(body))
+
+	var errorResponse struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	json.Unmarshal(body, &errorResponse)
+
+	return models.NewProviderError("onedrive", statusCode, graphErrorCodes[errorResponse.Error.Code])
+}
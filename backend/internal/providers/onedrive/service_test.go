@@ -0,0 +1,439 @@
+package onedrive
+
+import (
+	"all-me-backend/pkg/models"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func createTestService(baseURL string) *Service {
+	service := NewOneDriveService()
+	service.baseURL = baseURL
+	return service
+}
+
+func TestListAllImages_WalksDriveFromRoot(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var response APIResponse
+
+		switch r.URL.Path {
+		case "/me/drive/items/root/children":
+			response.Value = []DriveItem{
+				{ID: "photo-1", Name: "a.jpg", File: &FileFacet{MimeType: "image/jpeg"}},
+				{ID: "doc-1", Name: "notes.txt", File: &FileFacet{MimeType: "text/plain"}},
+				{ID: "album", Name: "Album", Folder: &FolderFacet{ChildCount: 1}},
+			}
+		case "/me/drive/items/album/children":
+			response.Value = []DriveItem{
+				{ID: "photo-2", Name: "b.png", File: &FileFacet{MimeType: "image/png"}},
+			}
+		default:
+			t.Errorf("Unexpected request path: %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	service := createTestService(server.URL)
+	token := &models.Token{AccessToken: "token", Provider: "onedrive"}
+
+	images, err := service.ListAllImages(token, 0)
+	if err != nil {
+		t.Fatalf("ListAllImages failed: %v", err)
+	}
+
+	if len(images) != 2 {
+		t.Fatalf("Expected 2 images, got %d", len(images))
+	}
+
+	if images[0].ID != "photo-1" || images[1].ID != "photo-2" {
+		t.Errorf("Expected photo-1 and photo-2, got %s and %s", images[0].ID, images[1].ID)
+	}
+}
+
+func TestCanonicalFolderID_SameFolderSameID(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/shares/u!") || !strings.HasSuffix(r.URL.Path, "/driveItem") {
+			t.Errorf("Unexpected request path: %s", r.URL.Path)
+		}
+
+		// Every link resolves to the same folder, as short and long links to one folder do
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(DriveItem{
+			ID:              "ABC123!456",
+			Name:            "Album",
+			Folder:          &FolderFacet{ChildCount: 3},
+			ParentReference: &ItemReference{DriveId: "abc123"},
+		})
+	}))
+	defer server.Close()
+
+	service := createTestService(server.URL)
+	token := &models.Token{AccessToken: "token", Provider: "onedrive"}
+
+	short, err := service.CanonicalFolderID("https://1drv.ms/f/s!AbCdEf", token)
+	if err != nil {
+		t.Fatalf("CanonicalFolderID failed: %v", err)
+	}
+	long, err := service.CanonicalFolderID("https://onedrive.live.com/?id=ABC123%21456&cid=abc123", token)
+	if err != nil {
+		t.Fatalf("CanonicalFolderID failed: %v", err)
+	}
+
+	if short != "onedrive:abc123/ABC123!456" || short != long {
+		t.Errorf("Expected both links to map to onedrive:abc123/ABC123!456, got %s and %s", short, long)
+	}
+
+	if _, err := service.CanonicalFolderID("https://1drv.ms/i/s!AbCdEf", token); err == nil {
+		t.Error("Expected error for a file link, got nil")
+	}
+}
+
+func TestParseShareLink_AlbumListsLikeFolder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var response any
+
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/shares/") && strings.HasSuffix(r.URL.Path, "/driveItem"):
+			response = DriveItem{
+				ID:              "OWNER!101",
+				Name:            "Wedding",
+				Bundle:          &BundleFacet{ChildCount: 2, Album: &AlbumFacet{CoverImageItemID: "OWNER!7"}},
+				ParentReference: &ItemReference{DriveId: "owner-drive"},
+			}
+		case r.URL.Path == "/drives/owner-drive/items/OWNER!101/children":
+			// Album children keep their own parent folders, wherever they are stored
+			response = APIResponse{Value: []DriveItem{
+				{ID: "OWNER!7", Name: "ceremony.jpg", File: &FileFacet{MimeType: "image/jpeg"}, ParentReference: &ItemReference{DriveId: "owner-drive", Id: "OWNER!5"}},
+				{ID: "OWNER!8", Name: "party.png", File: &FileFacet{MimeType: "image/png"}, ParentReference: &ItemReference{DriveId: "owner-drive", Id: "OWNER!6"}},
+			}}
+		default:
+			t.Errorf("Unexpected request path: %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	service := createTestService(server.URL)
+	token := &models.Token{AccessToken: "token", Provider: "onedrive"}
+
+	album, err := service.ParseShareLink("https://1drv.ms/a/s!AlbumLink", token)
+	if err != nil {
+		t.Fatalf("ParseShareLink failed: %v", err)
+	}
+
+	if !album.IsFolder || album.ID != "OWNER!101" || album.DriveID != "owner-drive" || album.Provider != "onedrive" {
+		t.Fatalf("Expected album to resolve to a folder-like drive item, got %+v", album)
+	}
+
+	items, _, err := service.ListFolderContents(album, token, 100, "", models.DateRange{})
+	if err != nil {
+		t.Fatalf("ListFolderContents failed: %v", err)
+	}
+
+	if len(items) != 2 || items[0].Name != "ceremony.jpg" || items[1].MimeType != "image/png" {
+		t.Fatalf("Expected the album's two photos, got %+v", items)
+	}
+	for _, item := range items {
+		if item.IsFolder || item.DriveID != "owner-drive" || item.Provider != "onedrive" {
+			t.Errorf("Expected an image in the owner's drive, got %+v", item)
+		}
+	}
+}
+
+func TestParseShareLink_RejectsSharedFile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(DriveItem{ID: "OWNER!7", Name: "photo.jpg", File: &FileFacet{MimeType: "image/jpeg"}})
+	}))
+	defer server.Close()
+
+	service := createTestService(server.URL)
+	if _, err := service.ParseShareLink("https://1drv.ms/f/s!NotAFolder", &models.Token{AccessToken: "token"}); err == nil {
+		t.Error("Expected error for a link to a file, got nil")
+	}
+}
+
+func TestParseShareLink_RejectsPersonalVault(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id": "OWNER!9", "name": "Personal Vault", "folder": {"childCount": 3}, "specialFolder": {"name": "vault"}}`))
+	}))
+	defer server.Close()
+
+	service := createTestService(server.URL)
+	_, err := service.ParseShareLink("https://1drv.ms/f/s!VaultLink", &models.Token{AccessToken: "token"})
+	if !errors.Is(err, models.ErrRestrictedLocation) {
+		t.Errorf("Expected ErrRestrictedLocation for a vault, got %v", err)
+	}
+}
+
+func TestListFolderContents_SkipsPersonalVault(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"value": [
+			{"id": "1", "name": "Personal Vault", "folder": {"childCount": 3}, "specialFolder": {"name": "vault"}},
+			{"id": "2", "name": "Pictures", "folder": {"childCount": 10}, "specialFolder": {"name": "photos"}},
+			{"id": "3", "name": "me.jpg", "file": {"mimeType": "image/jpeg"}}
+		]}`))
+	}))
+	defer server.Close()
+
+	service := createTestService(server.URL)
+	items, _, err := service.ListFolderContents(&models.CloudItem{ID: "root"}, &models.Token{AccessToken: "token"}, 100, "", models.DateRange{})
+	if err != nil {
+		t.Fatalf("ListFolderContents failed: %v", err)
+	}
+
+	if len(items) != 2 || items[0].Name != "Pictures" || items[1].Name != "me.jpg" {
+		t.Errorf("Expected the vault to be left out, got %+v", items)
+	}
+}
+
+func TestListFolderContents_RejectsForeignPageToken(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(`{"value": [{"id": "1", "name": "me.jpg", "file": {"mimeType": "image/jpeg"}}]}`))
+	}))
+	defer server.Close()
+
+	service := createTestService(server.URL)
+	folder := &models.CloudItem{ID: "root"}
+	token := &models.Token{AccessToken: "token"}
+
+	if _, _, err := service.ListFolderContents(folder, token, 100, "https://attacker.example/collect", models.DateRange{}); err == nil {
+		t.Error("Expected a page token outside the Graph API to be rejected")
+	}
+	if requests != 0 {
+		t.Errorf("Expected no request for a rejected page token, got %d", requests)
+	}
+
+	if _, _, err := service.ListFolderContents(folder, token, 100, server.URL+"/me/drive/items/root/children?$skiptoken=2", models.DateRange{}); err != nil || requests != 1 {
+		t.Errorf("Expected a Graph page token to be followed, got %v after %d requests", err, requests)
+	}
+}
+
+func TestConvertDriveItemToCloudItem_ReportsFileSizesOnly(t *testing.T) {
+	service := createTestService("")
+
+	photo := service.convertDriveItemToCloudItem(DriveItem{ID: "1", Name: "a.jpg", File: &FileFacet{MimeType: "image/jpeg"}, Size: 2048}, "", "", "")
+	if photo.Size != 2048 {
+		t.Errorf("Expected the file's size, got %d", photo.Size)
+	}
+
+	// OneDrive reports the total of a folder's contents, which the other providers do not
+	folder := service.convertDriveItemToCloudItem(DriveItem{ID: "2", Name: "Album", Folder: &FolderFacet{ChildCount: 3}, Size: 999999}, "", "", "")
+	if folder.Size != 0 {
+		t.Errorf("Expected folders to report size 0, got %d", folder.Size)
+	}
+}
+
+func TestListFolderContents_FallsBackToAvailableThumbnailSizes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if expand := r.URL.Query().Get("$expand"); expand != thumbnailsExpand {
+			t.Errorf("Expected thumbnails expanded with %q, got %q", thumbnailsExpand, expand)
+		}
+
+		w.Write([]byte(`{"value": [
+			{"id": "1", "name": "all.jpg", "file": {"mimeType": "image/jpeg"}, "@microsoft.graph.downloadUrl": "https://dl/1",
+				"thumbnails": [{"c400x400": {"url": "https://t/1-c400"}, "large": {"url": "https://t/1-large"}, "medium": {"url": "https://t/1-medium"}}]},
+			{"id": "2", "name": "medium.jpg", "file": {"mimeType": "image/jpeg"}, "@microsoft.graph.downloadUrl": "https://dl/2",
+				"thumbnails": [{"medium": {"url": "https://t/2-medium"}, "small": {"url": "https://t/2-small"}}]},
+			{"id": "3", "name": "small.heic", "file": {"mimeType": "image/heic"}, "@microsoft.graph.downloadUrl": "https://dl/3",
+				"thumbnails": [{"small": {"url": "https://t/3-small"}}]},
+			{"id": "4", "name": "new.jpg", "file": {"mimeType": "image/jpeg"}, "@microsoft.graph.downloadUrl": "https://dl/4"}
+		]}`))
+	}))
+	defer server.Close()
+
+	service := createTestService(server.URL)
+	items, _, err := service.ListFolderContents(&models.CloudItem{ID: "root"}, &models.Token{AccessToken: "token"}, 100, "", models.DateRange{})
+	if err != nil {
+		t.Fatalf("ListFolderContents failed: %v", err)
+	}
+
+	want := []struct{ thumbnail, faceRecognition string }{
+		{"https://t/1-c400", "https://t/1-large"},
+		{"https://t/2-medium", "https://t/2-medium"},
+		{"https://t/3-small", "https://dl/3"},
+		{"", "https://dl/4"},
+	}
+	if len(items) != len(want) {
+		t.Fatalf("Expected %d items, got %d", len(want), len(items))
+	}
+	for i, item := range items {
+		if item.ThumbnailURL != want[i].thumbnail || item.FaceRecognitionOptimizedURL != want[i].faceRecognition {
+			t.Errorf("%s: expected thumbnail %q and face recognition %q, got %q and %q",
+				item.Name, want[i].thumbnail, want[i].faceRecognition, item.ThumbnailURL, item.FaceRecognitionOptimizedURL)
+		}
+	}
+}
+
+func TestGetAccountID(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/me" {
+			t.Errorf("Unexpected request path: %s", r.URL.Path)
+		}
+
+		json.NewEncoder(w).Encode(User{ID: "48d31887-5fad-4d73-a9f5-3c356e68a038", DisplayName: "Ana", UserPrincipalName: "ana@example.com"})
+	}))
+	defer server.Close()
+
+	service := createTestService(server.URL)
+	accountID, err := service.GetAccountID(&models.Token{AccessToken: "token", Provider: "onedrive"})
+	if err != nil {
+		t.Fatalf("GetAccountID failed: %v", err)
+	}
+
+	if accountID != "48d31887-5fad-4d73-a9f5-3c356e68a038" {
+		t.Errorf("Expected the user's Graph ID, got %s", accountID)
+	}
+
+	// Accounts without a mailbox fall back to their sign-in name
+	account, err := service.GetAccount(&models.Token{AccessToken: "token", Provider: "onedrive"})
+	if err != nil {
+		t.Fatalf("GetAccount failed: %v", err)
+	}
+	if account.DisplayName != "Ana" || account.Email != "ana@example.com" {
+		t.Errorf("Expected the user's account details, got %+v", account)
+	}
+}
+
+func TestCopyToFolder_ResolvesRootFolderDrive(t *testing.T) {
+	var copied bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/me/drive/items/root":
+			json.NewEncoder(w).Encode(DriveItem{ID: "root-id", ParentReference: &ItemReference{DriveId: "drive-1"}})
+		case r.Method == http.MethodPost && r.URL.Path == "/drives/shared-drive/items/file-1/copy":
+			var body struct {
+				ParentReference map[string]string `json:"parentReference"`
+				Name            string            `json:"name"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Fatalf("Failed to decode request: %v", err)
+			}
+			if body.ParentReference["driveId"] != "drive-1" || body.ParentReference["id"] != "root-id" || body.Name != "photo.jpg" {
+				t.Errorf("Expected photo.jpg copied into root-id of drive-1, got %+v", body)
+			}
+			copied = true
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			t.Errorf("Unexpected request: %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	service := createTestService(server.URL)
+	item := &models.CloudItem{ID: "file-1", Name: "photo.jpg", DriveID: "shared-drive"}
+	folder := &models.CloudItem{ID: "root", IsFolder: true}
+
+	if err := service.CopyToFolder(item, folder, &models.Token{AccessToken: "token"}); err != nil {
+		t.Fatalf("CopyToFolder failed: %v", err)
+	}
+	if !copied {
+		t.Error("Expected the copy request to be sent")
+	}
+}
+
+func TestUploadFile_PutsContentIntoFolder(t *testing.T) {
+	var uploaded string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.URL.Path != "/drives/drive-1/items/folder-1:/matches.csv:/content" {
+			t.Errorf("Unexpected request: %s %s", r.Method, r.URL)
+		}
+		if r.URL.Query().Get("@microsoft.graph.conflictBehavior") != "rename" || r.Header.Get("Content-Type") != "text/csv" {
+			t.Errorf("Expected a renaming CSV upload, got %s with %s", r.URL.RawQuery, r.Header.Get("Content-Type"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		uploaded = string(body)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	service := createTestService(server.URL)
+	folder := &models.CloudItem{ID: "folder-1", DriveID: "drive-1", IsFolder: true}
+
+	if err := service.UploadFile(folder, "matches.csv", "text/csv", []byte("name\n"), &models.Token{AccessToken: "token"}); err != nil {
+		t.Fatalf("UploadFile failed: %v", err)
+	}
+	if uploaded != "name\n" {
+		t.Errorf("Expected the content to be uploaded, got %q", uploaded)
+	}
+}
+
+func TestDetectShareLink(t *testing.T) {
+	service := createTestService("")
+
+	tests := map[string]models.ShareLinkKind{
+		"https://1drv.ms/f/s!AbCdEf":                              models.ShareLinkFolder,
+		"https://1drv.ms/a/s!AbCdEf":                              models.ShareLinkFolder,
+		"https://onedrive.live.com/?id=ABC123&cid=DEF456":         models.ShareLinkFolder,
+		"https://1drv.ms/i/s!AbCdEf":                              models.ShareLinkFile,
+		"https://drive.google.com/drive/folders/1AbCdEfGhIjKlMnO": models.ShareLinkUnrecognized,
+	}
+
+	for link, expected := range tests {
+		if kind := service.DetectShareLink(link); kind != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, link, kind)
+		}
+	}
+}
+
+func TestNewOneDriveService_UsesConfiguredEndpoints(t *testing.T) {
+	t.Setenv("ONEDRIVE_API_URL", "https://graph.microsoft.us/v1.0/")
+	t.Setenv("ONEDRIVE_AUTH_URL", "https://login.microsoftonline.us/tenant/oauth2/v2.0/authorize")
+	t.Setenv("ONEDRIVE_TOKEN_URL", "http://login.microsoftonline.us/tenant/oauth2/v2.0/token")
+
+	service := NewOneDriveService()
+
+	if service.baseURL != "https://graph.microsoft.us/v1.0" {
+		t.Errorf("Expected the configured API URL without its trailing slash, got %q", service.baseURL)
+	}
+	if service.config.AuthURL != "https://login.microsoftonline.us/tenant/oauth2/v2.0/authorize" {
+		t.Errorf("Expected the configured auth URL, got %q", service.config.AuthURL)
+	}
+	// Token URLs must use https, so a plain http one falls back to the default
+	if service.config.TokenURL != "https://login.microsoftonline.com/common/oauth2/v2.0/token" {
+		t.Errorf("Expected the default token URL for an http URL, got %q", service.config.TokenURL)
+	}
+
+	authURL, err := service.BuildAuthURL("state", "challenge")
+	if err != nil || !strings.HasPrefix(authURL, "https://login.microsoftonline.us/tenant/oauth2/v2.0/authorize?") {
+		t.Errorf("Expected the auth redirect to use the configured URL, got %q (%v)", authURL, err)
+	}
+	if !strings.Contains(authURL, "code_challenge=challenge&code_challenge_method=S256") {
+		t.Errorf("Expected the auth redirect to carry the S256 code challenge, got %q", authURL)
+	}
+}
+
+func TestGetItem_MapsGraphErrorCodes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": {"code": "itemNotFound", "message": "Item abc123 does not exist"}}`))
+	}))
+	defer server.Close()
+
+	service := createTestService(server.URL)
+	_, err := service.GetItem(&models.CloudItem{ID: "abc123", DriveID: "drive"}, &models.Token{AccessToken: "token"})
+
+	providerErr, ok := models.AsProviderError(err)
+	if !ok || providerErr.Code != models.ProviderErrorNotFound || providerErr.HTTPStatus() != http.StatusNotFound {
+		t.Fatalf("Expected a not found provider error, got %v", err)
+	}
+	if strings.Contains(err.Error(), "abc123") {
+		t.Errorf("Expected the message to leave out the item ID, got %q", err.Error())
+	}
+}
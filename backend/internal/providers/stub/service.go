@@ -0,0 +1,374 @@
+package stub
+
+import (
+	"all-me-backend/pkg/models"
+	"bytes"
+	"embed"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+//go:embed images/*.jpg
+var bundledImages embed.FS
+
+const (
+	rootFolderID   = "stub-root"
+	nestedFolderID = "stub-day-2"
+	accessToken    = "stub-access-token"
+	authCode       = "stub-auth-code"
+	refreshToken   = "stub-refresh-token"
+)
+
+type folder struct {
+	item     *models.CloudItem
+	children []*models.CloudItem
+}
+
+// Service is a local development provider that serves bundled test images from fake folders
+// It stands in for a real provider name so the rest of the pipeline runs unchanged
+type Service struct {
+	provider string
+	images   map[string][]byte // item ID -> image data
+	folders  map[string]*folder
+	config   *models.OAuthConfig
+}
+
+// NewStubService creates a stub provider registered under the given provider name
+// Images are loaded from STUB_IMAGES_DIR when set, otherwise the bundled samples are used
+func NewStubService(provider string) (*Service, error) {
+	baseURL := strings.TrimSuffix(os.Getenv("STUB_BASE_URL"), "/")
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
+	}
+
+	s := &Service{
+		provider: provider,
+		images:   make(map[string][]byte),
+		folders:  make(map[string]*folder),
+		config: &models.OAuthConfig{
+			ClientID:     "stub-client-id",
+			ClientSecret: "stub-client-secret",
+			RedirectURI:  fmt.Sprintf("%s/auth/%s/callback", baseURL, provider),
+			Scopes:       []string{"stub.read"},
+			AuthURL:      fmt.Sprintf("%s/auth/%s/callback", baseURL, provider),
+			TokenURL:     fmt.Sprintf("%s/stub/%s/token", baseURL, provider),
+			Provider:     provider,
+		},
+	}
+
+	var imageFS fs.FS
+	if dir := os.Getenv("STUB_IMAGES_DIR"); dir != "" {
+		imageFS = os.DirFS(dir)
+	} else {
+		sub, err := fs.Sub(bundledImages, "images")
+		if err != nil {
+			return nil, err
+		}
+		imageFS = sub
+	}
+
+	if err := s.loadImages(imageFS); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// loadImages reads all images from the filesystem and splits them across the root and a nested folder
+func (s *Service) loadImages(imageFS fs.FS) error {
+	entries, err := fs.ReadDir(imageFS, ".")
+	if err != nil {
+		return fmt.Errorf("failed to read stub images: %w", err)
+	}
+
+	root := &folder{item: s.folderItem(rootFolderID, "Stub Event")}
+	nested := &folder{item: s.folderItem(nestedFolderID, "Day 2")}
+	root.children = append(root.children, nested.item)
+
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && mimeTypeForName(entry.Name()) != "" {
+			names = append(names, entry.Name())
+		}
+	}
+	slices.Sort(names)
+
+	if len(names) == 0 {
+		return fmt.Errorf("no stub images found")
+	}
+
+	for i, name := range names {
+		data, err := fs.ReadFile(imageFS, name)
+		if err != nil {
+			return fmt.Errorf("failed to read stub image %s: %w", name, err)
+		}
+
+		id := fmt.Sprintf("stub-image-%d", i+1)
+		s.images[id] = data
+
+		// Put the last third of the images in the nested folder to exercise recursive listing
+		target := root
+		if i >= len(names)-len(names)/3 {
+			target = nested
+		}
+		image := s.imageItem(id, name)
+		image.Size = int64(len(data))
+		target.children = append(target.children, image)
+	}
+
+	s.folders[root.item.ID] = root
+	s.folders[nested.item.ID] = nested
+
+	return nil
+}
+
+func (s *Service) folderItem(id, name string) *models.CloudItem {
+	return &models.CloudItem{
+		ID:       id,
+		Name:     name,
+		MimeType: "application/vnd.stub.folder",
+		IsFolder: true,
+		Provider: s.provider,
+	}
+}
+
+func (s *Service) imageItem(id, name string) *models.CloudItem {
+	imageURL := "stub://" + id
+	return &models.CloudItem{
+		ID:                          id,
+		Name:                        name,
+		MimeType:                    mimeTypeForName(name),
+		Provider:                    s.provider,
+		DownloadURL:                 imageURL,
+		FaceRecognitionOptimizedURL: imageURL,
+		ThumbnailURL:                imageURL,
+	}
+}
+
+// GetOAuthConfig returns the stub OAuth configuration
+func (s *Service) GetOAuthConfig() *models.OAuthConfig {
+	return s.config
+}
+
+// BuildAuthURL skips the consent screen by pointing straight at the backend callback with a fixed code
+// The fake token endpoint accepts any code verifier, so the code challenge is not passed on
+func (s *Service) BuildAuthURL(state, codeChallenge string) (string, error) {
+	params := url.Values{}
+	params.Add("code", authCode)
+	params.Add("state", state)
+
+	return s.config.AuthURL + "?" + params.Encode(), nil
+}
+
+// RegisterRoutes registers the fake token endpoint used to complete the stubbed login
+func (s *Service) RegisterRoutes(e *echo.Echo) {
+	e.POST("/stub/"+s.provider+"/token", s.handleToken)
+}
+
+func (s *Service) handleToken(c echo.Context) error {
+	valid := c.FormValue("code") == authCode
+	if c.FormValue("grant_type") == "refresh_token" {
+		valid = c.FormValue("refresh_token") == refreshToken
+	}
+	if !valid {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "invalid_grant",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"access_token":  accessToken,
+		"refresh_token": refreshToken,
+		"scope":         strings.Join(s.config.Scopes, " "),
+		"expires_in":    3600,
+	})
+}
+
+// ParseShareLink accepts any http(s) link and resolves it to the stub root folder
+func (s *Service) ParseShareLink(shareURL string, token *models.Token) (*models.CloudItem, error) {
+	parsedURL, err := url.Parse(strings.TrimSpace(shareURL))
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL format: %w", err)
+	}
+
+	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
+		return nil, fmt.Errorf("URL must use http or https scheme")
+	}
+
+	root := *s.folders[rootFolderID].item
+	return &root, nil
+}
+
+// CanonicalFolderID maps every link to the stub root folder, matching ParseShareLink
+func (s *Service) CanonicalFolderID(shareURL string, token *models.Token) (string, error) {
+	folder, err := s.ParseShareLink(shareURL, token)
+	if err != nil {
+		return "", err
+	}
+	return folder.Provider + ":" + folder.ID, nil
+}
+
+// GetAccountID returns the same fake account for every token, so saved data survives signing in again
+func (s *Service) GetAccountID(token *models.Token) (string, error) {
+	return "stub-user", nil
+}
+
+// GetAccount returns the fake account of GetAccountID
+func (s *Service) GetAccount(token *models.Token) (*models.Account, error) {
+	return &models.Account{Provider: token.Provider, ID: "stub-user", DisplayName: "Stub User"}, nil
+}
+
+// DetectShareLink treats any http or https link as a folder, matching ParseShareLink
+func (s *Service) DetectShareLink(shareURL string) models.ShareLinkKind {
+	parsedURL, err := url.Parse(strings.TrimSpace(shareURL))
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") {
+		return models.ShareLinkUnrecognized
+	}
+	return models.ShareLinkFolder
+}
+
+// GetRootFolder returns the stub root folder
+func (s *Service) GetRootFolder(token *models.Token) (*models.CloudItem, error) {
+	root := *s.folders[rootFolderID].item
+	return &root, nil
+}
+
+// ListFolderContents lists a fake folder, using the item offset as the page token
+// dates is applied by the storage service
+func (s *Service) ListFolderContents(item *models.CloudItem, token *models.Token, pageSize int, nextPageToken string, dates models.DateRange) ([]*models.CloudItem, string, error) {
+	f, exists := s.folders[item.ID]
+	if !exists {
+		return nil, "", fmt.Errorf("stub folder not found: %s", item.ID)
+	}
+
+	start := 0
+	if nextPageToken != "" {
+		offset, err := strconv.Atoi(nextPageToken)
+		if err != nil || offset < 0 || offset > len(f.children) {
+			return nil, "", fmt.Errorf("invalid page token: %s", nextPageToken)
+		}
+		start = offset
+	}
+
+	end := len(f.children)
+	if pageSize > 0 && start+pageSize < end {
+		end = start + pageSize
+	}
+
+	items := make([]*models.CloudItem, 0, end-start)
+	for _, child := range f.children[start:end] {
+		itemCopy := *child
+		items = append(items, &itemCopy)
+	}
+
+	var next string
+	if end < len(f.children) {
+		next = strconv.Itoa(end)
+	}
+
+	return items, next, nil
+}
+
+// ListAllImages returns every image across the fake folders, up to limit items
+func (s *Service) ListAllImages(token *models.Token, limit int) ([]*models.CloudItem, error) {
+	var images []*models.CloudItem
+	for _, folderID := range []string{rootFolderID, nestedFolderID} {
+		for _, child := range s.folders[folderID].children {
+			if child.IsFolder {
+				continue
+			}
+
+			itemCopy := *child
+			images = append(images, &itemCopy)
+			if limit > 0 && len(images) >= limit {
+				return images, nil
+			}
+		}
+	}
+
+	return images, nil
+}
+
+// GetItem looks up an image item by ID
+func (s *Service) GetItem(item *models.CloudItem, token *models.Token) (*models.CloudItem, error) {
+	for _, f := range s.folders {
+		for _, child := range f.children {
+			if child.ID == item.ID {
+				itemCopy := *child
+				return &itemCopy, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("stub item not found: %s", item.ID)
+}
+
+// GetFileStream returns the bundled image data for an item
+func (s *Service) GetFileStream(item *models.CloudItem, token *models.Token) (io.ReadCloser, error) {
+	return s.openImage(item.ID)
+}
+
+// GetFaceRecognitionOptimizedStream returns the same image data as GetFileStream
+func (s *Service) GetFaceRecognitionOptimizedStream(item *models.CloudItem, token *models.Token) (io.ReadCloser, error) {
+	return s.openImage(item.ID)
+}
+
+// CopyToFolder checks that both the image and the destination folder exist, without storing a copy,
+// as the stub's folders are fixed at startup
+func (s *Service) CopyToFolder(item, destination *models.CloudItem, token *models.Token) error {
+	if _, exists := s.images[item.ID]; !exists {
+		return fmt.Errorf("stub image not found: %s", item.ID)
+	}
+	if _, exists := s.folders[destination.ID]; !exists {
+		return fmt.Errorf("stub folder not found: %s", destination.ID)
+	}
+	return nil
+}
+
+// UploadFile checks that the destination folder exists and discards the content, like CopyToFolder
+func (s *Service) UploadFile(destination *models.CloudItem, name, mimeType string, content []byte, token *models.Token) error {
+	if _, exists := s.folders[destination.ID]; !exists {
+		return fmt.Errorf("stub folder not found: %s", destination.ID)
+	}
+	return nil
+}
+
+// GetThumbnailStream resolves a stub:// thumbnail URL to its image data
+func (s *Service) GetThumbnailStream(thumbnailURL string, token *models.Token) (io.ReadCloser, error) {
+	id, found := strings.CutPrefix(thumbnailURL, "stub://")
+	if !found {
+		return nil, fmt.Errorf("not a stub thumbnail URL: %s", thumbnailURL)
+	}
+
+	return s.openImage(id)
+}
+
+func (s *Service) openImage(id string) (io.ReadCloser, error) {
+	data, exists := s.images[id]
+	if !exists {
+		return nil, fmt.Errorf("stub image not found: %s", id)
+	}
+
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func mimeTypeForName(name string) string {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".jpg", ".jpeg":
+		return "image/jpeg"
+	case ".png":
+		return "image/png"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,218 @@
+package stub
+
+import (
+	"all-me-backend/pkg/models"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/labstack/echo/v4"
+)
+
+func createTestService(t *testing.T) *Service {
+	t.Helper()
+
+	service, err := NewStubService("onedrive")
+	if err != nil {
+		t.Fatalf("Failed to create stub service: %v", err)
+	}
+	return service
+}
+
+func TestStubService_ParseShareLink(t *testing.T) {
+	service := createTestService(t)
+
+	folder, err := service.ParseShareLink("https://example.com/any/link", nil)
+	if err != nil {
+		t.Fatalf("ParseShareLink failed: %v", err)
+	}
+
+	if folder.ID != rootFolderID || !folder.IsFolder {
+		t.Errorf("Expected root folder, got %+v", folder)
+	}
+
+	if folder.Provider != "onedrive" {
+		t.Errorf("Expected provider 'onedrive', got '%s'", folder.Provider)
+	}
+
+	if _, err := service.ParseShareLink("not-a-url", nil); err == nil {
+		t.Error("Expected error for link without scheme, got nil")
+	}
+}
+
+func TestStubService_CanonicalFolderID(t *testing.T) {
+	service := createTestService(t)
+
+	first, err := service.CanonicalFolderID("https://example.com/any/link", nil)
+	if err != nil {
+		t.Fatalf("CanonicalFolderID failed: %v", err)
+	}
+	second, _ := service.CanonicalFolderID("https://example.com/other/link", nil)
+
+	if first != "onedrive:"+rootFolderID || first != second {
+		t.Errorf("Expected every link to map to the root folder, got %s and %s", first, second)
+	}
+}
+
+func TestStubService_ListFolderContents_Pagination(t *testing.T) {
+	service := createTestService(t)
+	root := &models.CloudItem{ID: rootFolderID}
+
+	var all []*models.CloudItem
+	pageToken := ""
+	pages := 0
+	for {
+		items, next, err := service.ListFolderContents(root, nil, 2, pageToken, models.DateRange{})
+		if err != nil {
+			t.Fatalf("ListFolderContents failed: %v", err)
+		}
+		if len(items) > 2 {
+			t.Errorf("Expected at most 2 items per page, got %d", len(items))
+		}
+
+		all = append(all, items...)
+		pages++
+
+		if next == "" {
+			break
+		}
+		pageToken = next
+	}
+
+	if pages < 2 {
+		t.Errorf("Expected multiple pages, got %d", pages)
+	}
+
+	if len(all) != len(service.folders[rootFolderID].children) {
+		t.Errorf("Expected %d items, got %d", len(service.folders[rootFolderID].children), len(all))
+	}
+
+	hasSubfolder := false
+	for _, item := range all {
+		if item.IsFolder && item.ID == nestedFolderID {
+			hasSubfolder = true
+		}
+	}
+	if !hasSubfolder {
+		t.Error("Expected root folder to contain the nested folder")
+	}
+}
+
+func TestStubService_StreamsBundledImages(t *testing.T) {
+	service := createTestService(t)
+
+	items, _, err := service.ListFolderContents(&models.CloudItem{ID: nestedFolderID}, nil, 0, "", models.DateRange{})
+	if err != nil {
+		t.Fatalf("ListFolderContents failed: %v", err)
+	}
+	if len(items) == 0 {
+		t.Fatal("Expected nested folder to contain images")
+	}
+
+	image := items[0]
+	if image.MimeType != "image/jpeg" {
+		t.Errorf("Expected image/jpeg, got %s", image.MimeType)
+	}
+
+	stream, err := service.GetFaceRecognitionOptimizedStream(image, nil)
+	if err != nil {
+		t.Fatalf("GetFaceRecognitionOptimizedStream failed: %v", err)
+	}
+	defer stream.Close()
+
+	data, err := io.ReadAll(stream)
+	if err != nil {
+		t.Fatalf("Failed to read stream: %v", err)
+	}
+
+	// JPEG files start with the SOI marker
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		t.Error("Expected JPEG image data")
+	}
+
+	thumb, err := service.GetThumbnailStream(image.ThumbnailURL, nil)
+	if err != nil {
+		t.Fatalf("GetThumbnailStream failed: %v", err)
+	}
+	thumb.Close()
+
+	if _, err := service.GetFileStream(&models.CloudItem{ID: "missing"}, nil); err == nil {
+		t.Error("Expected error for unknown image, got nil")
+	}
+}
+
+func TestStubService_LoadImagesFromDirectory(t *testing.T) {
+	service := &Service{
+		provider: "googledrive",
+		images:   make(map[string][]byte),
+		folders:  make(map[string]*folder),
+	}
+
+	imageFS := fstest.MapFS{
+		"me.jpg":    {Data: []byte("jpeg")},
+		"group.png": {Data: []byte("png")},
+		"notes.txt": {Data: []byte("ignored")},
+	}
+
+	if err := service.loadImages(imageFS); err != nil {
+		t.Fatalf("loadImages failed: %v", err)
+	}
+
+	if len(service.images) != 2 {
+		t.Errorf("Expected 2 images, got %d", len(service.images))
+	}
+}
+
+func TestStubService_StubbedLogin(t *testing.T) {
+	service := createTestService(t)
+
+	authURL, err := service.BuildAuthURL("test-state", "test-challenge")
+	if err != nil {
+		t.Fatalf("BuildAuthURL failed: %v", err)
+	}
+
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("Invalid auth URL: %v", err)
+	}
+
+	if !strings.HasSuffix(parsed.Path, "/auth/onedrive/callback") {
+		t.Errorf("Expected auth URL to point at the callback, got %s", parsed.Path)
+	}
+
+	if parsed.Query().Get("state") != "test-state" {
+		t.Errorf("Expected state to be preserved, got %s", parsed.Query().Get("state"))
+	}
+
+	e := echo.New()
+	service.RegisterRoutes(e)
+
+	form := url.Values{}
+	form.Set("code", parsed.Query().Get("code"))
+	form.Set("grant_type", "authorization_code")
+
+	req := httptest.NewRequest(http.MethodPost, "/stub/onedrive/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+
+	var tokenResponse struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &tokenResponse); err != nil {
+		t.Fatalf("Failed to decode token response: %v", err)
+	}
+
+	if tokenResponse.AccessToken == "" {
+		t.Error("Expected an access token")
+	}
+}
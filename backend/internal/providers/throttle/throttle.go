@@ -0,0 +1,178 @@
+package throttle
+
+import (
+	"all-me-backend/pkg/config"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPauseSeconds    = 10
+	defaultMaxPauseSeconds = 300
+)
+
+var (
+	gates   = make(map[string]*Gate)
+	gatesMu sync.Mutex
+)
+
+// Gate pauses all requests to a provider after it signals throttling
+// A 429 (or 503) response pauses new requests for the Retry-After duration,
+// so the app backs off instead of extending the throttle on its shared credentials
+type Gate struct {
+	mu           sync.Mutex
+	pausedUntil  time.Time
+	defaultPause time.Duration
+	maxPause     time.Duration
+
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// ForProvider returns the gate shared by every client talking to the given provider
+func ForProvider(provider string) *Gate {
+	gatesMu.Lock()
+	defer gatesMu.Unlock()
+
+	gate, exists := gates[provider]
+	if !exists {
+		gate = NewGate()
+		gates[provider] = gate
+	}
+
+	return gate
+}
+
+// NewGate creates a gate configured from PROVIDER_THROTTLE_DEFAULT_SECONDS (pause used when a
+// throttled response has no Retry-After) and PROVIDER_THROTTLE_MAX_SECONDS (upper bound on any pause)
+func NewGate() *Gate {
+	defaultPause := config.GetInt("PROVIDER_THROTTLE_DEFAULT_SECONDS", defaultPauseSeconds)
+	if defaultPause < 0 {
+		defaultPause = defaultPauseSeconds
+	}
+
+	maxPause := config.GetInt("PROVIDER_THROTTLE_MAX_SECONDS", defaultMaxPauseSeconds)
+	if maxPause < 0 {
+		maxPause = defaultMaxPauseSeconds
+	}
+
+	return &Gate{
+		defaultPause: time.Duration(defaultPause) * time.Second,
+		maxPause:     time.Duration(maxPause) * time.Second,
+		now:          time.Now,
+		sleep:        sleepContext,
+	}
+}
+
+// Wait blocks until the gate is open or the context is done
+func (g *Gate) Wait(ctx context.Context) error {
+	g.mu.Lock()
+	remaining := g.pausedUntil.Sub(g.now())
+	g.mu.Unlock()
+
+	if remaining <= 0 {
+		return nil
+	}
+
+	return g.sleep(ctx, remaining)
+}
+
+// Observe closes the gate when a response shows the provider is throttling
+func (g *Gate) Observe(resp *http.Response) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return
+	}
+
+	pause, ok := g.parseRetryAfter(resp.Header.Get("Retry-After"))
+	if !ok {
+		// A 503 without Retry-After is usually a transient error rather than throttling
+		if resp.StatusCode != http.StatusTooManyRequests {
+			return
+		}
+		pause = g.defaultPause
+	}
+
+	if pause > g.maxPause {
+		pause = g.maxPause
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	// Never shorten a pause another response already set
+	if until := g.now().Add(pause); until.After(g.pausedUntil) {
+		g.pausedUntil = until
+	}
+}
+
+// parseRetryAfter accepts both forms of Retry-After: delay seconds or an HTTP date
+func (g *Gate) parseRetryAfter(value string) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		pause := date.Sub(g.now())
+		if pause < 0 {
+			pause = 0
+		}
+		return pause, true
+	}
+
+	return 0, false
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Transport is an http.RoundTripper that waits on a gate before each request
+// and updates it from each response
+type Transport struct {
+	gate *Gate
+	base http.RoundTripper
+}
+
+// NewTransport wraps base with the given gate
+func NewTransport(gate *Gate, base http.RoundTripper) *Transport {
+	return &Transport{
+		gate: gate,
+		base: base,
+	}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.gate.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	t.gate.Observe(resp)
+
+	return resp, nil
+}
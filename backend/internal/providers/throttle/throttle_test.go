@@ -0,0 +1,151 @@
+package throttle
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// createTestGate returns a gate with a fake clock that records requested sleeps instead of blocking
+func createTestGate() (*Gate, *[]time.Duration) {
+	current := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	var mu sync.Mutex
+	var sleeps []time.Duration
+
+	gate := NewGate()
+	gate.now = func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return current
+	}
+	gate.sleep = func(ctx context.Context, d time.Duration) error {
+		mu.Lock()
+		defer mu.Unlock()
+		sleeps = append(sleeps, d)
+		current = current.Add(d)
+		return nil
+	}
+
+	return gate, &sleeps
+}
+
+func TestTransport_WaitsForRetryAfter(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	gate, sleeps := createTestGate()
+	client := &http.Client{Transport: NewTransport(gate, http.DefaultTransport)}
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("First request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429, got %d", resp.StatusCode)
+	}
+
+	if len(*sleeps) != 0 {
+		t.Errorf("Expected first request not to wait, got sleeps %v", *sleeps)
+	}
+
+	resp, err = client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Second request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if len(*sleeps) != 1 || (*sleeps)[0] != 7*time.Second {
+		t.Errorf("Expected second request to wait 7s, got sleeps %v", *sleeps)
+	}
+
+	// The pause has elapsed, so the next request goes straight through
+	resp, err = client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Third request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if len(*sleeps) != 1 {
+		t.Errorf("Expected no further waits, got sleeps %v", *sleeps)
+	}
+}
+
+func TestGate_Observe(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		retryAfter string
+		wantPause  time.Duration
+	}{
+		{"retry after seconds", http.StatusTooManyRequests, "30", 30 * time.Second},
+		{"retry after date", http.StatusTooManyRequests, "Wed, 01 Jan 2025 12:01:00 GMT", time.Minute},
+		{"missing retry after", http.StatusTooManyRequests, "", defaultPauseSeconds * time.Second},
+		{"capped at max", http.StatusTooManyRequests, "86400", defaultMaxPauseSeconds * time.Second},
+		{"service unavailable with retry after", http.StatusServiceUnavailable, "5", 5 * time.Second},
+		{"service unavailable without retry after", http.StatusServiceUnavailable, "", 0},
+		{"success", http.StatusOK, "30", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gate, sleeps := createTestGate()
+
+			resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+			if tt.retryAfter != "" {
+				resp.Header.Set("Retry-After", tt.retryAfter)
+			}
+			gate.Observe(resp)
+
+			if err := gate.Wait(context.Background()); err != nil {
+				t.Fatalf("Wait failed: %v", err)
+			}
+
+			var got time.Duration
+			if len(*sleeps) > 0 {
+				got = (*sleeps)[0]
+			}
+			if got != tt.wantPause {
+				t.Errorf("Expected pause %v, got %v", tt.wantPause, got)
+			}
+		})
+	}
+}
+
+func TestGate_KeepsLongerPause(t *testing.T) {
+	gate, sleeps := createTestGate()
+
+	long := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"60"}}}
+	short := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"1"}}}
+	gate.Observe(long)
+	gate.Observe(short)
+
+	gate.Wait(context.Background())
+
+	if len(*sleeps) != 1 || (*sleeps)[0] != time.Minute {
+		t.Errorf("Expected the longer 60s pause to be kept, got sleeps %v", *sleeps)
+	}
+}
+
+func TestForProvider_SharesGate(t *testing.T) {
+	if ForProvider("googledrive") != ForProvider("googledrive") {
+		t.Error("Expected the same gate for the same provider")
+	}
+
+	if ForProvider("googledrive") == ForProvider("onedrive") {
+		t.Error("Expected separate gates for different providers")
+	}
+}
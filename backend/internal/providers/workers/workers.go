@@ -0,0 +1,67 @@
+package workers
+
+import (
+	"all-me-backend/pkg/config"
+	"log"
+	"sync"
+)
+
+const defaultMaxConcurrentRequests = 30
+
+var (
+	shared     *Pool
+	sharedOnce sync.Once
+)
+
+// Pool bounds how much work runs against cloud providers at once
+// Recursive listings and image downloads draw from the same pool, so several large jobs
+// together never exceed the provider connections and goroutines it allows
+type Pool struct {
+	slots chan struct{}
+}
+
+// NewPool creates a pool that runs at most size units of work at a time
+func NewPool(size int) *Pool {
+	return &Pool{slots: make(chan struct{}, max(size, 1))}
+}
+
+// Shared returns the pool shared by every service talking to providers
+// It is sized by PROVIDER_MAX_CONCURRENT_REQUESTS, falling back to FACE_MAX_CONCURRENT_DOWNLOADS,
+// which capped downloads alone before listings shared the pool
+func Shared() *Pool {
+	sharedOnce.Do(func() {
+		size := config.GetInt("PROVIDER_MAX_CONCURRENT_REQUESTS", config.GetInt("FACE_MAX_CONCURRENT_DOWNLOADS", defaultMaxConcurrentRequests))
+		if size < 1 {
+			log.Printf("PROVIDER_MAX_CONCURRENT_REQUESTS must be positive, using default %d", defaultMaxConcurrentRequests)
+			size = defaultMaxConcurrentRequests
+		}
+		shared = NewPool(size)
+	})
+
+	return shared
+}
+
+// Acquire blocks until a slot is free; the caller must Release it when done
+func (p *Pool) Acquire() {
+	p.slots <- struct{}{}
+}
+
+// TryAcquire takes a slot if one is free right away, reporting whether it did
+func (p *Pool) TryAcquire() bool {
+	select {
+	case p.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Release frees a slot taken with Acquire or TryAcquire
+func (p *Pool) Release() {
+	<-p.slots
+}
+
+// Size returns how many units of work the pool runs at once
+func (p *Pool) Size() int {
+	return cap(p.slots)
+}
@@ -0,0 +1,25 @@
+package workers
+
+import "testing"
+
+func TestPool_TryAcquireRespectsSize(t *testing.T) {
+	pool := NewPool(2)
+
+	if !pool.TryAcquire() || !pool.TryAcquire() {
+		t.Fatal("Expected both slots to be free")
+	}
+	if pool.TryAcquire() {
+		t.Error("Expected a full pool to refuse another slot")
+	}
+
+	pool.Release()
+	if !pool.TryAcquire() {
+		t.Error("Expected a released slot to be available again")
+	}
+}
+
+func TestNewPool_AlwaysHasASlot(t *testing.T) {
+	if size := NewPool(0).Size(); size != 1 {
+		t.Errorf("Expected a pool of at least 1, got %d", size)
+	}
+}
@@ -0,0 +1,67 @@
+package settings
+
+import (
+	"all-me-backend/internal/face"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+type Handler struct {
+	faceService     FaceService
+	downloadService DownloadService
+	authService     AuthService
+}
+
+func NewHandler(faceService FaceService, downloadService DownloadService, authService AuthService) *Handler {
+	return &Handler{
+		faceService:     faceService,
+		downloadService: downloadService,
+		authService:     authService,
+	}
+}
+
+func (h *Handler) RegisterRoutes(e *echo.Echo) {
+	e.GET("/config", h.GetConfig)
+	e.GET("/capabilities", h.GetCapabilities)
+}
+
+// GetConfig handles GET /config
+// It returns the limits enforced by the server so the frontend can validate input against the same rules
+func (h *Handler) GetConfig(c echo.Context) error {
+	return c.JSON(http.StatusOK, ConfigResponse{
+		MaxBaseFaceSize:      face.MaxBaseFaceSize,
+		BaseFaceContentTypes: h.faceService.BaseFaceMimeTypes(),
+		ImageMimeTypes:       h.faceService.ImageMimeTypes(),
+		MaxImagesPerJob:      h.faceService.MaxImagesPerJob(),
+		MaxZipFiles:          h.downloadService.MaxZipFiles(),
+		NewJobsDisabled:      h.faceService.NewJobsDisabled() || h.downloadService.NewJobsDisabled(),
+	})
+}
+
+// GetCapabilities handles GET /capabilities
+// It describes the providers, formats, limits and optional features of this deployment, so the frontend
+// can adapt to it and operators can check the effective configuration
+func (h *Handler) GetCapabilities(c echo.Context) error {
+	providers := h.authService.EnabledProviders()
+	if providers == nil {
+		providers = []string{}
+	}
+
+	return c.JSON(http.StatusOK, CapabilitiesResponse{
+		Providers:            providers,
+		BaseFaceContentTypes: h.faceService.BaseFaceMimeTypes(),
+		MaxBaseFaceSize:      face.MaxBaseFaceSize,
+		MaxImagesPerJob:      h.faceService.MaxImagesPerJob(),
+		MaxZipFiles:          h.downloadService.MaxZipFiles(),
+		DownloadFormats:      h.downloadService.ArchiveFormats(),
+		TokenRefresh:         true, // Always on: tokens are refreshed whenever the provider issued a refresh token
+		PKCE:                 true, // Sign-in sends an S256 code challenge and proves it when exchanging the code
+		Features: CapabilitiesFeatures{
+			SaveToDrive:     h.faceService.SaveToDriveEnabled(),
+			SavedReferences: h.faceService.SavedReferencesEnabled(),
+			DocumentImages:  h.faceService.DocumentImagesEnabled(),
+			NewJobsDisabled: h.faceService.NewJobsDisabled() || h.downloadService.NewJobsDisabled(),
+		},
+	})
+}
@@ -0,0 +1,166 @@
+package settings
+
+import (
+	"all-me-backend/internal/face"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestGetConfig_ReturnsServerLimits(t *testing.T) {
+	e := echo.New()
+	handler := NewHandler(&mockFaceService{maxImages: 1234, mimeTypes: []string{"image/jpeg", "image/png"}, baseFaceTypes: []string{"image/jpeg", "image/png", "image/heic"}}, &mockDownloadService{maxZipFiles: 56}, &mockAuthService{})
+	handler.RegisterRoutes(e)
+
+	req := httptest.NewRequest(http.MethodGet, "/config", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+
+	var response ConfigResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if response.MaxBaseFaceSize != face.MaxBaseFaceSize {
+		t.Errorf("Expected max base face size %d, got %d", face.MaxBaseFaceSize, response.MaxBaseFaceSize)
+	}
+
+	if expected := []string{"image/jpeg", "image/png", "image/heic"}; !slices.Equal(response.BaseFaceContentTypes, expected) {
+		t.Errorf("Expected base face content types %v, got %v", expected, response.BaseFaceContentTypes)
+	}
+
+	expectedTypes := []string{"image/jpeg", "image/png"}
+
+	if !slices.Equal(response.ImageMimeTypes, expectedTypes) {
+		t.Errorf("Expected image mime types %v, got %v", expectedTypes, response.ImageMimeTypes)
+	}
+
+	if response.MaxImagesPerJob != 1234 {
+		t.Errorf("Expected max images per job 1234, got %d", response.MaxImagesPerJob)
+	}
+
+	if response.MaxZipFiles != 56 {
+		t.Errorf("Expected max ZIP files 56, got %d", response.MaxZipFiles)
+	}
+
+	if response.NewJobsDisabled {
+		t.Error("Expected new jobs to be enabled by default")
+	}
+}
+
+func TestGetConfig_ReportsDisabledNewJobs(t *testing.T) {
+	e := echo.New()
+	NewHandler(&mockFaceService{newJobsDisabled: true}, &mockDownloadService{}, &mockAuthService{}).RegisterRoutes(e)
+
+	req := httptest.NewRequest(http.MethodGet, "/config", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	var response ConfigResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if !response.NewJobsDisabled {
+		t.Error("Expected new_jobs_disabled to be reported")
+	}
+}
+
+func TestGetCapabilities_ReflectsConfiguration(t *testing.T) {
+	e := echo.New()
+	faceService := &mockFaceService{maxImages: 500, mimeTypes: []string{"image/jpeg"}, baseFaceTypes: []string{"image/jpeg"}, saveToDrive: true}
+	NewHandler(faceService, &mockDownloadService{maxZipFiles: 10}, &mockAuthService{providers: []string{"onedrive"}}).RegisterRoutes(e)
+
+	req := httptest.NewRequest(http.MethodGet, "/capabilities", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	var response CapabilitiesResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if !slices.Equal(response.Providers, []string{"onedrive"}) {
+		t.Errorf("Expected only the configured provider, got %v", response.Providers)
+	}
+	if response.MaxImagesPerJob != 500 || response.MaxZipFiles != 10 || response.MaxBaseFaceSize != face.MaxBaseFaceSize {
+		t.Errorf("Expected the configured limits, got %+v", response)
+	}
+	if !slices.Equal(response.DownloadFormats, []string{"zip"}) || !slices.Equal(response.BaseFaceContentTypes, []string{"image/jpeg"}) {
+		t.Errorf("Expected the supported formats, got %+v", response)
+	}
+	if !response.Features.SaveToDrive || response.Features.SavedReferences || !response.TokenRefresh {
+		t.Errorf("Expected the enabled features to be reported, got %+v", response)
+	}
+}
+
+// mockFaceService is a test implementation of FaceService
+type mockFaceService struct {
+	maxImages       int
+	mimeTypes       []string
+	baseFaceTypes   []string
+	newJobsDisabled bool
+	saveToDrive     bool
+}
+
+func (m *mockFaceService) MaxImagesPerJob() int {
+	return m.maxImages
+}
+
+func (m *mockFaceService) ImageMimeTypes() []string {
+	return m.mimeTypes
+}
+
+func (m *mockFaceService) BaseFaceMimeTypes() []string {
+	return m.baseFaceTypes
+}
+
+func (m *mockFaceService) NewJobsDisabled() bool {
+	return m.newJobsDisabled
+}
+
+func (m *mockFaceService) SaveToDriveEnabled() bool {
+	return m.saveToDrive
+}
+
+func (m *mockFaceService) SavedReferencesEnabled() bool {
+	return false
+}
+
+func (m *mockFaceService) DocumentImagesEnabled() bool {
+	return false
+}
+
+// mockDownloadService is a test implementation of DownloadService
+type mockDownloadService struct {
+	maxZipFiles int
+}
+
+func (m *mockDownloadService) MaxZipFiles() int {
+	return m.maxZipFiles
+}
+
+func (m *mockDownloadService) NewJobsDisabled() bool {
+	return false
+}
+
+func (m *mockDownloadService) ArchiveFormats() []string {
+	return []string{"zip"}
+}
+
+// mockAuthService is a test implementation of AuthService
+type mockAuthService struct {
+	providers []string
+}
+
+func (m *mockAuthService) EnabledProviders() []string {
+	return m.providers
+}
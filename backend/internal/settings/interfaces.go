@@ -0,0 +1,21 @@
+package settings
+
+type FaceService interface {
+	MaxImagesPerJob() int
+	ImageMimeTypes() []string
+	BaseFaceMimeTypes() []string
+	NewJobsDisabled() bool
+	SaveToDriveEnabled() bool
+	SavedReferencesEnabled() bool
+	DocumentImagesEnabled() bool
+}
+
+type DownloadService interface {
+	MaxZipFiles() int
+	NewJobsDisabled() bool
+	ArchiveFormats() []string
+}
+
+type AuthService interface {
+	EnabledProviders() []string
+}
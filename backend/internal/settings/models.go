@@ -0,0 +1,35 @@
+package settings
+
+// ConfigResponse describes the server-side limits the frontend should apply before sending requests
+type ConfigResponse struct {
+	MaxBaseFaceSize      int64    `json:"max_base_face_size"`
+	BaseFaceContentTypes []string `json:"base_face_content_types"`
+	ImageMimeTypes       []string `json:"image_mime_types"`
+	MaxImagesPerJob      int      `json:"max_images_per_job"`
+	MaxZipFiles          int      `json:"max_zip_files"`
+	// NewJobsDisabled is set while the server drains for maintenance and rejects new comparisons and downloads
+	NewJobsDisabled bool `json:"new_jobs_disabled"`
+}
+
+// CapabilitiesResponse describes what this deployment offers, computed from its effective configuration
+type CapabilitiesResponse struct {
+	Providers            []string `json:"providers"` // Providers users can sign in with
+	BaseFaceContentTypes []string `json:"base_face_content_types"`
+	MaxBaseFaceSize      int64    `json:"max_base_face_size"`
+	MaxImagesPerJob      int      `json:"max_images_per_job"`
+	MaxZipFiles          int      `json:"max_zip_files"`
+	DownloadFormats      []string `json:"download_formats"`
+	// TokenRefresh is set when expiring provider tokens are refreshed without signing in again
+	TokenRefresh bool `json:"token_refresh"`
+	// PKCE is set when sign-in uses proof keys for code exchange
+	PKCE     bool                 `json:"pkce"`
+	Features CapabilitiesFeatures `json:"features"`
+}
+
+// CapabilitiesFeatures reports the optional features enabled in this deployment
+type CapabilitiesFeatures struct {
+	SaveToDrive     bool `json:"save_to_drive"`
+	SavedReferences bool `json:"saved_references"`
+	DocumentImages  bool `json:"document_images"` // Photos embedded in PDFs are compared
+	NewJobsDisabled bool `json:"new_jobs_disabled"`
+}
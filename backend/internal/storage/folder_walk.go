@@ -0,0 +1,123 @@
+package storage
+
+import "all-me-backend/pkg/models"
+
+// folderWalk tracks how deep a recursive listing went
+type folderWalk struct {
+	dates        models.DateRange // Files modified outside it are left out of every listed folder
+	deepestDepth int
+	maxDepthHit  bool
+}
+
+// walkedFolder is a folder visited by a recursive listing, with its listed items once done
+type walkedFolder struct {
+	item       *models.CloudItem
+	depth      int // 0 for the starting folder
+	items      []*models.CloudItem
+	err        error
+	subfolders map[*models.CloudItem]*walkedFolder // Subfolders that were listed, by their item
+}
+
+// listFiles lists the files in a folder that keep accepts
+// When recursive, subfolders are listed concurrently, each listing holding a slot of the shared
+// worker pool, and files are returned in the order of a depth-first walk
+func (s *Service) listFiles(item *models.CloudItem, token *models.Token, recursive bool, walk *folderWalk, keep func(*models.CloudItem) bool) ([]*models.CloudItem, error) {
+	root := &walkedFolder{item: item}
+
+	s.workers.Acquire()
+	s.listWalkedFolder(root, token, walk)
+	if root.err != nil {
+		return nil, root.err
+	}
+
+	if recursive {
+		s.walkSubfolders(root, token, walk)
+	}
+
+	return collectFiles(root, recursive, keep), nil
+}
+
+// listWalkedFolder lists a folder's items and releases the worker slot the caller acquired for it
+func (s *Service) listWalkedFolder(folder *walkedFolder, token *models.Token, walk *folderWalk) {
+	defer s.workers.Release()
+	folder.items, folder.err = s.ListFolderContents(folder.item, token, walk.dates)
+}
+
+// walkSubfolders lists every subfolder below root, up to the maximum depth
+// A single coordinator hands folders to at most one goroutine per pool slot, so a large tree never
+// spawns more goroutines than the pool allows; subfolders that fail to list are skipped
+func (s *Service) walkSubfolders(root *walkedFolder, token *models.Token, walk *folderWalk) {
+	results := make(chan *walkedFolder)
+	pending := s.expandFolder(root, walk)
+	inFlight := 0
+
+	for len(pending) > 0 || inFlight > 0 {
+		if len(pending) > 0 && inFlight < s.workers.Size() {
+			// Wait for a slot only when nothing is in flight, otherwise a finished listing may free one
+			acquired := inFlight == 0
+			if acquired {
+				s.workers.Acquire()
+			} else {
+				acquired = s.workers.TryAcquire()
+			}
+
+			if acquired {
+				folder := pending[0]
+				pending = pending[1:]
+				inFlight++
+				go func() {
+					s.listWalkedFolder(folder, token, walk)
+					results <- folder
+				}()
+				continue
+			}
+		}
+
+		folder := <-results
+		inFlight--
+		if folder.err == nil {
+			pending = append(pending, s.expandFolder(folder, walk)...)
+		}
+	}
+}
+
+// expandFolder records a listed folder's depth and returns its subfolders to list next
+func (s *Service) expandFolder(folder *walkedFolder, walk *folderWalk) []*walkedFolder {
+	walk.deepestDepth = max(walk.deepestDepth, folder.depth)
+
+	var subfolders []*walkedFolder
+	for _, item := range folder.items {
+		if !item.IsFolder {
+			continue
+		}
+		if folder.depth >= s.recursionMaxDepth {
+			walk.maxDepthHit = true
+			continue
+		}
+
+		subfolder := &walkedFolder{item: item, depth: folder.depth + 1}
+		if folder.subfolders == nil {
+			folder.subfolders = make(map[*models.CloudItem]*walkedFolder)
+		}
+		folder.subfolders[item] = subfolder
+		subfolders = append(subfolders, subfolder)
+	}
+
+	return subfolders
+}
+
+// collectFiles gathers the files keep accepts, depth first in listing order
+func collectFiles(folder *walkedFolder, recursive bool, keep func(*models.CloudItem) bool) []*models.CloudItem {
+	files := make([]*models.CloudItem, 0)
+	for _, item := range folder.items {
+		if item.IsFolder && recursive {
+			if subfolder, listed := folder.subfolders[item]; listed && subfolder.err == nil {
+				files = append(files, collectFiles(subfolder, recursive, keep)...)
+			}
+		} else if !item.IsFolder && keep(item) {
+			files = append(files, item)
+		}
+	}
+
+	return files
+}
@@ -2,8 +2,16 @@ package storage
 
 import (
 	"all-me-backend/pkg/models"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
 )
@@ -22,14 +30,59 @@ func NewHandler(service *Service, sessionStore models.SessionStore) *Handler {
 
 func (h *Handler) RegisterRoutes(e *echo.Echo) {
 	e.GET("/storage/folder-contents", h.GetFolderContents)
+	e.GET("/storage/folder-contents/stream", h.StreamFolderContents)
+	e.GET("/storage/my-drive", h.GetMyDriveContents)
+	e.GET("/storage/detect", h.DetectShareLink)
+	e.GET("/storage/recent", h.GetRecentFolders)
+}
+
+// GetRecentFolders handles GET /storage/recent
+// It returns the folder links the session opened recently, so the frontend can offer them again
+func (h *Handler) GetRecentFolders(c echo.Context) error {
+	sessionID := c.QueryParam("session_id")
+	if sessionID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "session_id query parameter is required",
+		})
+	}
+
+	folders, err := h.sessionStore.GetRecentFolders(sessionID)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": fmt.Sprintf("Authentication failed: %v", err),
+		})
+	}
+
+	return c.JSON(http.StatusOK, RecentFoldersResponse{
+		Folders: append([]models.RecentFolder{}, folders...),
+	})
+}
+
+// DetectShareLink handles GET /storage/detect
+// It tells which providers a share link belongs to before the user has signed in to any of them
+func (h *Handler) DetectShareLink(c echo.Context) error {
+	shareURL := c.QueryParam("url")
+	if shareURL == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "url query parameter is required",
+		})
+	}
+
+	return c.JSON(http.StatusOK, h.service.DetectShareLink(shareURL))
 }
 
 // GetFolderContents handles GET /storage/folder-contents
 // It retrieves folder metadata and all contents (files and folders) from a cloud storage share link
+// Optional modified_after and modified_before parameters leave out files modified outside that range
+// account_id selects one of the provider's accounts when several are signed in
+// With page_size or page_token, one page of the folder is returned along with the next page's token,
+// so folders too large to list at once can be listed page by page
 func (h *Handler) GetFolderContents(c echo.Context) error {
 	shareURL := c.QueryParam("share_url")
 	sessionID := c.QueryParam("session_id")
 	provider := c.QueryParam("provider")
+	accountID := c.QueryParam("account_id")
+	pageToken := c.QueryParam("page_token")
 
 	if shareURL == "" {
 		return c.JSON(http.StatusBadRequest, map[string]string{
@@ -43,35 +96,296 @@ func (h *Handler) GetFolderContents(c echo.Context) error {
 		})
 	}
 
-	if provider == "" {
+	dates, err := models.ParseDateRange(c.QueryParam("modified_after"), c.QueryParam("modified_before"))
+	if err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "provider query parameter is required",
+			"error": err.Error(),
 		})
 	}
 
-	token, err := h.sessionStore.GetSessionToken(sessionID, provider)
+	pageSize := 0
+	if rawPageSize := c.QueryParam("page_size"); rawPageSize != "" {
+		pageSize, err = strconv.Atoi(rawPageSize)
+		if err != nil || pageSize < 1 {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "page_size must be a positive integer",
+			})
+		}
+	}
+	paged := pageSize > 0 || pageToken != ""
+
+	token, status, err := h.resolveToken(sessionID, provider, accountID)
 	if err != nil {
-		return c.JSON(http.StatusUnauthorized, map[string]string{
-			"error": fmt.Sprintf("Authentication failed: %v", err),
+		return c.JSON(status, tokenErrorResponse(err))
+	}
+
+	folder, err := h.service.ParseShareLink(shareURL, token)
+	if errors.Is(err, models.ErrRestrictedLocation) {
+		return c.JSON(http.StatusForbidden, map[string]string{
+			"error": models.ErrRestrictedLocation.Error(),
 		})
 	}
+	if err != nil {
+		return respondWithProviderError(c, http.StatusBadRequest, "Failed to parse share link", err)
+	}
+
+	// Later pages continue a listing that was already recorded
+	if pageToken == "" {
+		if err := h.sessionStore.RecordRecentFolder(sessionID, models.RecentFolder{Link: strings.TrimSpace(shareURL), Provider: token.Provider, Name: folder.Name}); err != nil {
+			log.Printf("Failed to record recent folder: %v", err)
+		}
+	}
+
+	if paged {
+		contents, nextPageToken, err := h.service.ListFolderContentsPage(folder, token, pageSize, pageToken, dates)
+		if err != nil {
+			return respondWithProviderError(c, http.StatusInternalServerError, "Failed to list folder contents", err)
+		}
+
+		return c.JSON(http.StatusOK, GetFolderContentsResponse{
+			Folder:        folder,
+			Contents:      contents,
+			NextPageToken: nextPageToken,
+		})
+	}
+
+	contents, err := h.service.ListFolderContents(folder, token, dates)
+	if err != nil {
+		return respondWithProviderError(c, http.StatusInternalServerError, "Failed to list folder contents", err)
+	}
+
+	return h.respondWithListing(c, folder, contents)
+}
+
+// StreamFolderContents handles GET /storage/folder-contents/stream
+// It takes the same parameters as GetFolderContents, except paging, and writes the listing as
+// newline-delimited JSON (FolderStreamLine), flushing after each provider page so huge folders render
+// progressively over one connection without being held in memory
+// Failures before the stream starts are reported as usual; later ones end the stream with an error line
+func (h *Handler) StreamFolderContents(c echo.Context) error {
+	shareURL := c.QueryParam("share_url")
+	sessionID := c.QueryParam("session_id")
+	provider := c.QueryParam("provider")
+	accountID := c.QueryParam("account_id")
+
+	if shareURL == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "share_url query parameter is required",
+		})
+	}
+
+	if sessionID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "session_id query parameter is required",
+		})
+	}
+
+	dates, err := models.ParseDateRange(c.QueryParam("modified_after"), c.QueryParam("modified_before"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	token, status, err := h.resolveToken(sessionID, provider, accountID)
+	if err != nil {
+		return c.JSON(status, tokenErrorResponse(err))
+	}
 
 	folder, err := h.service.ParseShareLink(shareURL, token)
+	if errors.Is(err, models.ErrRestrictedLocation) {
+		return c.JSON(http.StatusForbidden, map[string]string{
+			"error": models.ErrRestrictedLocation.Error(),
+		})
+	}
+	if err != nil {
+		return respondWithProviderError(c, http.StatusBadRequest, "Failed to parse share link", err)
+	}
+
+	if err := h.sessionStore.RecordRecentFolder(sessionID, models.RecentFolder{Link: strings.TrimSpace(shareURL), Provider: token.Provider, Name: folder.Name}); err != nil {
+		log.Printf("Failed to record recent folder: %v", err)
+	}
+
+	response := c.Response()
+	response.Header().Set(echo.HeaderContentType, "application/x-ndjson")
+	response.Header().Set("Cache-Control", "no-store")
+	response.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(response)
+	if err := encoder.Encode(FolderStreamLine{Folder: folder}); err != nil {
+		return nil
+	}
+	response.Flush()
+
+	err = h.service.StreamFolderContents(folder, token, dates, func(items []*models.CloudItem) error {
+		// Stop listing for a client that went away
+		if err := c.Request().Context().Err(); err != nil {
+			return err
+		}
+
+		for _, item := range items {
+			if err := encoder.Encode(FolderStreamLine{Item: item}); err != nil {
+				return err
+			}
+		}
+		response.Flush()
+		return nil
+	})
+	if c.Request().Context().Err() != nil {
+		return nil
+	}
+
+	last := FolderStreamLine{Done: true}
 	if err != nil {
+		last = streamErrorLine("Failed to list folder contents", err)
+	}
+	encoder.Encode(last)
+	response.Flush()
+	return nil
+}
+
+// streamErrorLine is the last line of a stream that failed, worded like respondWithProviderError
+func streamErrorLine(message string, err error) FolderStreamLine {
+	if providerErr, ok := models.AsProviderError(err); ok {
+		return FolderStreamLine{Error: message + ": " + providerErr.Error(), Code: string(providerErr.Code)}
+	}
+	return FolderStreamLine{Error: fmt.Sprintf("%s: %v", message, err)}
+}
+
+// GetMyDriveContents handles GET /storage/my-drive
+// It lists the root of the user's own drive, for browsing folders that were never shared
+// It accepts the same modified_after and modified_before parameters as GetFolderContents
+func (h *Handler) GetMyDriveContents(c echo.Context) error {
+	sessionID := c.QueryParam("session_id")
+	provider := c.QueryParam("provider")
+	accountID := c.QueryParam("account_id")
+
+	if sessionID == "" {
 		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": fmt.Sprintf("Failed to parse share link: %v", err),
+			"error": "session_id query parameter is required",
 		})
 	}
 
-	contents, err := h.service.ListFolderContents(folder, token)
+	dates, err := models.ParseDateRange(c.QueryParam("modified_after"), c.QueryParam("modified_before"))
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{
-			"error": fmt.Sprintf("Failed to list folder contents: %v", err),
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
 		})
 	}
 
-	return c.JSON(http.StatusOK, GetFolderContentsResponse{
+	token, status, err := h.resolveToken(sessionID, provider, accountID)
+	if err != nil {
+		return c.JSON(status, tokenErrorResponse(err))
+	}
+
+	folder, err := h.service.GetRootFolder(token)
+	if err != nil {
+		return respondWithProviderError(c, http.StatusInternalServerError, "Failed to get root folder", err)
+	}
+
+	contents, err := h.service.ListFolderContents(folder, token, dates)
+	if err != nil {
+		return respondWithProviderError(c, http.StatusInternalServerError, "Failed to list folder contents", err)
+	}
+
+	return h.respondWithListing(c, folder, contents)
+}
+
+// respondWithListing writes a folder listing with an ETag over its items, or 304 Not Modified
+// when the client's If-None-Match already has the current listing
+// Listings beyond the configured maximum are truncated, so huge folders cannot produce huge responses
+func (h *Handler) respondWithListing(c echo.Context, folder *models.CloudItem, contents []*models.CloudItem) error {
+	etag := listingETag(folder, contents)
+
+	// no-cache makes the browser revalidate with If-None-Match instead of reusing the listing blindly
+	c.Response().Header().Set("ETag", etag)
+	c.Response().Header().Set("Cache-Control", "private, no-cache")
+
+	if etagMatches(c.Request().Header.Get("If-None-Match"), etag) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	response := GetFolderContentsResponse{
 		Folder:   folder,
 		Contents: contents,
+	}
+
+	if truncated, wasTruncated := h.service.TruncateListing(contents); wasTruncated {
+		response.Contents = truncated
+		response.Truncated = true
+		response.TotalItems = len(contents)
+		response.Message = fmt.Sprintf("Showing the first %d of %d items. Open a subfolder to see fewer items at once; comparing this folder still checks every image in it.",
+			len(truncated), len(contents))
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// listingETag hashes the folder and the IDs, names and modified times of its items
+// The hash follows the provider's listing order, which is stable between requests
+func listingETag(folder *models.CloudItem, contents []*models.CloudItem) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\x00%s\n", folder.Provider, folder.ID)
+	for _, item := range contents {
+		fmt.Fprintf(hash, "%s\x00%s\x00%s\n", item.ID, item.Name, item.ModifiedTime.UTC().Format(time.RFC3339Nano))
+	}
+
+	return `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header value matches the ETag
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveToken finds the session's token for the requested (or only connected) provider and account
+// On failure it also returns the HTTP status to respond with
+func (h *Handler) resolveToken(sessionID, provider, accountID string) (*models.Token, int, error) {
+	provider, err := models.ResolveProvider(h.sessionStore, sessionID, provider)
+	if errors.Is(err, models.ErrAmbiguousProvider) {
+		return nil, http.StatusBadRequest, err
+	}
+	if err != nil {
+		return nil, http.StatusUnauthorized, fmt.Errorf("Authentication failed: %w", err)
+	}
+
+	token, err := h.sessionStore.GetSessionToken(sessionID, provider, accountID)
+	if errors.Is(err, models.ErrAmbiguousAccount) {
+		return nil, http.StatusBadRequest, err
+	}
+	if err != nil {
+		return nil, http.StatusUnauthorized, fmt.Errorf("Authentication failed: %w", err)
+	}
+
+	return token, http.StatusOK, nil
+}
+
+// tokenErrorResponse is the body of a failed token lookup, with a code telling the frontend when to sign in again
+func tokenErrorResponse(err error) map[string]string {
+	response := map[string]string{"error": err.Error()}
+	if code := models.TokenErrorCode(err); code != "" {
+		response["code"] = code
+	}
+	return response
+}
+
+// respondWithProviderError reports a failed provider call, with the status and code of a ProviderError
+// so the frontend can tell users what went wrong, e.g. that a folder is no longer shared
+func respondWithProviderError(c echo.Context, status int, message string, err error) error {
+	if providerErr, ok := models.AsProviderError(err); ok {
+		return c.JSON(providerErr.HTTPStatus(), map[string]string{
+			"error": message + ": " + providerErr.Error(),
+			"code":  string(providerErr.Code),
+		})
+	}
+
+	return c.JSON(status, map[string]string{
+		"error": fmt.Sprintf("%s: %v", message, err),
 	})
 }
@@ -0,0 +1,366 @@
+package storage
+
+import (
+	"all-me-backend/pkg/models"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestGetMyDriveContents_ETag(t *testing.T) {
+	modified := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	tree := map[string][]*models.CloudItem{
+		"root": {
+			{ID: "a", Name: "a.jpg", MimeType: "image/jpeg", ModifiedTime: modified},
+			{ID: "b", Name: "b.jpg", MimeType: "image/jpeg", ModifiedTime: modified},
+		},
+	}
+
+	e := echo.New()
+	NewHandler(NewService(&mockProvider{tree: tree}, &mockProvider{}, &mockProvider{}), &mockSessionStore{}).RegisterRoutes(e)
+
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/storage/my-drive?session_id=session-1&provider=googledrive", nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := get("")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("Expected 200 with an ETag, got %d and %q", first.Code, etag)
+	}
+
+	unchanged := get(etag)
+	if unchanged.Code != http.StatusNotModified {
+		t.Errorf("Expected 304 for an unchanged folder, got %d", unchanged.Code)
+	}
+	if unchanged.Body.Len() != 0 {
+		t.Errorf("Expected empty body for 304, got %q", unchanged.Body.String())
+	}
+
+	tree["root"][1].ModifiedTime = modified.Add(time.Minute)
+
+	changed := get(etag)
+	if changed.Code != http.StatusOK {
+		t.Errorf("Expected 200 after an item was modified, got %d", changed.Code)
+	}
+	if changed.Header().Get("ETag") == etag {
+		t.Error("Expected a new ETag after an item was modified")
+	}
+}
+
+func TestGetFolderContents_RemembersRecentFolders(t *testing.T) {
+	tree := map[string][]*models.CloudItem{"shared": {{ID: "a", Name: "a.jpg", MimeType: "image/jpeg"}}}
+
+	e := echo.New()
+	NewHandler(NewService(&mockProvider{tree: tree}, &mockProvider{}, &mockProvider{}), &mockSessionStore{}).RegisterRoutes(e)
+
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	for _, link := range []string{"https://drive.google.com/drive/folders/one", "https://drive.google.com/drive/folders/two"} {
+		rec := get("/storage/folder-contents?session_id=session-1&provider=googledrive&share_url=" + url.QueryEscape(link))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200 listing %s, got %d: %s", link, rec.Code, rec.Body.String())
+		}
+	}
+
+	rec := get("/storage/recent?session_id=session-1")
+	var response RecentFoldersResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(response.Folders) != 2 || !strings.HasSuffix(response.Folders[0].Link, "/two") || response.Folders[0].Name != "Shared" {
+		t.Errorf("Expected the two links, most recent first, got %+v", response.Folders)
+	}
+
+	rec = get("/storage/recent?session_id=session-2")
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != `{"folders":[]}` {
+		t.Errorf("Expected an empty list for a session without recent folders, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestGetFolderContents_Paged(t *testing.T) {
+	e := echo.New()
+	NewHandler(NewService(&mockProvider{}, &mockProvider{}, &mockProvider{}), &mockSessionStore{}).RegisterRoutes(e)
+
+	get := func(query string) (*httptest.ResponseRecorder, GetFolderContentsResponse) {
+		rec := httptest.NewRecorder()
+		target := "/storage/folder-contents?session_id=session-1&provider=googledrive&share_url=" + url.QueryEscape("https://drive.google.com/drive/folders/one") + query
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+
+		var response GetFolderContentsResponse
+		json.Unmarshal(rec.Body.Bytes(), &response)
+		return rec, response
+	}
+
+	rec, first := get("&page_size=50")
+	if rec.Code != http.StatusOK || len(first.Contents) != 1 || first.Contents[0].ID != "first" || first.NextPageToken != "page-2" {
+		t.Fatalf("Expected the first page with a next page token, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec, last := get("&page_token=" + first.NextPageToken)
+	if rec.Code != http.StatusOK || len(last.Contents) != 1 || last.Contents[0].ID != "second" || strings.Contains(rec.Body.String(), "next_page_token") {
+		t.Errorf("Expected the last page without a next page token, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	// Without paging parameters the whole folder is listed
+	rec, whole := get("")
+	if rec.Code != http.StatusOK || len(whole.Contents) != 2 || whole.NextPageToken != "" {
+		t.Errorf("Expected the whole folder, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	if rec, _ := get("&page_size=0"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a page size that is not positive, got %d", rec.Code)
+	}
+}
+
+func TestGetMyDriveContents_TruncatesLargeListings(t *testing.T) {
+	tree := map[string][]*models.CloudItem{
+		"root": {
+			{ID: "c", Name: "c.jpg", MimeType: "image/jpeg"},
+			{ID: "b", Name: "b.jpg", MimeType: "image/jpeg"},
+			{ID: "sub", Name: "sub", IsFolder: true},
+			{ID: "a", Name: "a.jpg", MimeType: "image/jpeg"},
+		},
+	}
+
+	service := NewService(&mockProvider{tree: tree}, &mockProvider{}, &mockProvider{})
+	service.maxListingItems = 2
+
+	e := echo.New()
+	NewHandler(service, &mockSessionStore{}).RegisterRoutes(e)
+
+	req := httptest.NewRequest(http.MethodGet, "/storage/my-drive?session_id=session-1&provider=googledrive", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	var response GetFolderContentsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if !response.Truncated || response.TotalItems != 4 || response.Message == "" {
+		t.Errorf("Expected a truncated listing of 4 items with guidance, got %+v", response)
+	}
+	if len(response.Contents) != 2 || response.Contents[0].ID != "sub" || response.Contents[1].ID != "a" {
+		t.Errorf("Expected the subfolder and first image to be kept, got %v", response.Contents)
+	}
+
+	service.maxListingItems = 4
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/storage/my-drive?session_id=session-1&provider=googledrive", nil))
+	if strings.Contains(rec.Body.String(), "truncated") {
+		t.Errorf("Expected no truncation flag for a listing within the limit, got %s", rec.Body.String())
+	}
+}
+
+func TestGetMyDriveContents_ProviderError(t *testing.T) {
+	e := echo.New()
+	provider := &mockProvider{listErr: models.NewProviderError("googledrive", http.StatusForbidden, models.ProviderErrorRateLimited)}
+	NewHandler(NewService(provider, &mockProvider{}, &mockProvider{}), &mockSessionStore{}).RegisterRoutes(e)
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/storage/my-drive?session_id=session-1&provider=googledrive", nil))
+
+	var body map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if rec.Code != http.StatusTooManyRequests || body["code"] != string(models.ProviderErrorRateLimited) {
+		t.Errorf("Expected 429 with code %q, got %d %v", models.ProviderErrorRateLimited, rec.Code, body)
+	}
+}
+
+func TestGetMyDriveContents_ExpiredToken(t *testing.T) {
+	e := echo.New()
+	NewHandler(NewService(&mockProvider{}, &mockProvider{}, &mockProvider{}), &mockSessionStore{tokenErr: models.ErrTokenExpired}).RegisterRoutes(e)
+
+	req := httptest.NewRequest(http.MethodGet, "/storage/my-drive?session_id=session-1&provider=googledrive", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	var body map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if rec.Code != http.StatusUnauthorized || body["code"] != models.ErrorCodeTokenExpired {
+		t.Errorf("Expected 401 with code %q, got %d %v", models.ErrorCodeTokenExpired, rec.Code, body)
+	}
+}
+
+func TestDetectShareLink_WithoutSession(t *testing.T) {
+	tests := []struct {
+		name          string
+		googleDrive   models.ShareLinkKind
+		oneDrive      models.ShareLinkKind
+		wantProviders string
+		wantKind      models.ShareLinkKind
+	}{
+		{"single provider folder", models.ShareLinkFolder, "", "googledrive", models.ShareLinkFolder},
+		{"file link", "", models.ShareLinkFile, "onedrive", models.ShareLinkFile},
+		{"folder wins over file", models.ShareLinkFile, models.ShareLinkFolder, "googledrive,onedrive", models.ShareLinkFolder},
+		{"unrecognized", "", "", "", models.ShareLinkUnrecognized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			service := NewService(&mockProvider{linkKind: tt.googleDrive}, &mockProvider{linkKind: tt.oneDrive}, &mockProvider{})
+			NewHandler(service, &mockSessionStore{}).RegisterRoutes(e)
+
+			req := httptest.NewRequest(http.MethodGet, "/storage/detect?url="+url.QueryEscape("https://example.com/share"), nil)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d", rec.Code)
+			}
+
+			var response DetectShareLinkResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if strings.Join(response.Providers, ",") != tt.wantProviders {
+				t.Errorf("Expected providers %q, got %v", tt.wantProviders, response.Providers)
+			}
+			if response.Kind != tt.wantKind {
+				t.Errorf("Expected kind %s, got %s", tt.wantKind, response.Kind)
+			}
+		})
+	}
+}
+
+func TestDetectShareLink_RequiresURL(t *testing.T) {
+	e := echo.New()
+	NewHandler(NewService(&mockProvider{}, &mockProvider{}, &mockProvider{}), &mockSessionStore{}).RegisterRoutes(e)
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/storage/detect", nil))
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", rec.Code)
+	}
+}
+
+func TestEtagMatches(t *testing.T) {
+	tests := []struct {
+		ifNoneMatch string
+		want        bool
+	}{
+		{`"abc"`, true},
+		{`W/"abc"`, true},
+		{`"xyz", "abc"`, true},
+		{`*`, true},
+		{`"xyz"`, false},
+		{``, false},
+	}
+
+	for _, tt := range tests {
+		if got := etagMatches(tt.ifNoneMatch, `"abc"`); got != tt.want {
+			t.Errorf("etagMatches(%q) = %v, want %v", tt.ifNoneMatch, got, tt.want)
+		}
+	}
+}
+
+// mockSessionStore is a test implementation of models.SessionStore with a Google Drive token for every session
+// Token lookups fail with tokenErr when set
+type mockSessionStore struct {
+	sessions map[string]*models.UserSession // Sessions that recorded recent folders
+	tokenErr error
+}
+
+func (m *mockSessionStore) RecordRecentFolder(sessionID string, folder models.RecentFolder) error {
+	if m.sessions == nil {
+		m.sessions = make(map[string]*models.UserSession)
+	}
+	if m.sessions[sessionID] == nil {
+		m.sessions[sessionID] = &models.UserSession{SessionID: sessionID}
+	}
+	m.sessions[sessionID].AddRecentFolder(folder, 5)
+	return nil
+}
+
+func (m *mockSessionStore) GetRecentFolders(sessionID string) ([]models.RecentFolder, error) {
+	if session, exists := m.sessions[sessionID]; exists {
+		return session.RecentFolders, nil
+	}
+	return nil, nil
+}
+
+func (m *mockSessionStore) GetSessionToken(sessionID, provider, accountID string) (*models.Token, error) {
+	if m.tokenErr != nil {
+		return nil, m.tokenErr
+	}
+	return &models.Token{AccessToken: "token", Provider: provider}, nil
+}
+
+func (m *mockSessionStore) GetSessionProviders(sessionID string) ([]string, error) {
+	return []string{"googledrive"}, nil
+}
+
+func (m *mockSessionStore) HasScope(sessionID, provider, accountID, scope string) (bool, error) {
+	return true, nil
+}
+
+func TestStreamFolderContents_WritesEachPage(t *testing.T) {
+	stream := func(provider *mockProvider) []FolderStreamLine {
+		t.Helper()
+		e := echo.New()
+		NewHandler(NewService(provider, &mockProvider{}, &mockProvider{}), &mockSessionStore{}).RegisterRoutes(e)
+
+		req := httptest.NewRequest(http.MethodGet, "/storage/folder-contents/stream?session_id=session-1&provider=googledrive&share_url="+url.QueryEscape("https://drive.google.com/drive/folders/abc"), nil)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/x-ndjson" {
+			t.Fatalf("Expected a 200 ndjson stream, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+		}
+
+		var lines []FolderStreamLine
+		decoder := json.NewDecoder(rec.Body)
+		for decoder.More() {
+			var line FolderStreamLine
+			if err := decoder.Decode(&line); err != nil {
+				t.Fatalf("Failed to decode line: %v", err)
+			}
+			lines = append(lines, line)
+		}
+		return lines
+	}
+
+	// The mock provider returns one item on each of two pages
+	lines := stream(&mockProvider{})
+	if len(lines) != 4 {
+		t.Fatalf("Expected the folder, 2 items and the end, got %+v", lines)
+	}
+	if lines[0].Folder == nil || lines[0].Folder.ID != "shared" {
+		t.Errorf("Expected the folder first, got %+v", lines[0])
+	}
+	if lines[1].Item == nil || lines[1].Item.ID != "first" || lines[2].Item == nil || lines[2].Item.ID != "second" {
+		t.Errorf("Expected the items in page order, got %+v and %+v", lines[1], lines[2])
+	}
+	if !lines[3].Done {
+		t.Errorf("Expected the stream to end with done, got %+v", lines[3])
+	}
+
+	lines = stream(&mockProvider{listErr: models.NewProviderError("googledrive", http.StatusNotFound, "")})
+	last := lines[len(lines)-1]
+	if last.Done || last.Code != string(models.ProviderErrorNotFound) {
+		t.Errorf("Expected the stream to end with the provider error, got %+v", last)
+	}
+}
@@ -7,8 +7,23 @@ import (
 
 // Provider defines the interface for storage operations with cloud providers
 type Provider interface {
-	ListFolderContents(item *models.CloudItem, token *models.Token, pageSize int, nextPageToken string) ([]*models.CloudItem, string, error)
+	// ListFolderContents lists a page of a folder; providers may leave out files modified outside dates,
+	// the storage service filters them either way
+	ListFolderContents(item *models.CloudItem, token *models.Token, pageSize int, nextPageToken string, dates models.DateRange) ([]*models.CloudItem, string, error)
+	ListAllImages(token *models.Token, limit int) ([]*models.CloudItem, error)
+	GetItem(item *models.CloudItem, token *models.Token) (*models.CloudItem, error)
 	GetFileStream(item *models.CloudItem, token *models.Token) (io.ReadCloser, error)
 	GetFaceRecognitionOptimizedStream(item *models.CloudItem, token *models.Token) (io.ReadCloser, error)
 	ParseShareLink(shareURL string, token *models.Token) (*models.CloudItem, error)
+	// CanonicalFolderID returns a provider-namespaced ID ("{provider}:{id}") that is the same for
+	// every link to a folder, for caching and deduplicating work across link formats
+	CanonicalFolderID(shareURL string, token *models.Token) (string, error)
+	DetectShareLink(shareURL string) models.ShareLinkKind
+	GetRootFolder(token *models.Token) (*models.CloudItem, error)
+	// GetAccountID returns the provider's stable ID for the signed-in account, which survives new sign-ins
+	GetAccountID(token *models.Token) (string, error)
+	// CopyToFolder copies a file into a folder of the token's own drive; it needs a write scope
+	CopyToFolder(item, destination *models.CloudItem, token *models.Token) error
+	// UploadFile writes a new file with the given content into a folder of the token's own drive; it needs a write scope
+	UploadFile(destination *models.CloudItem, name, mimeType string, content []byte, token *models.Token) error
 }
@@ -2,7 +2,35 @@ package storage
 
 import "all-me-backend/pkg/models"
 
+// DetectShareLinkResponse lists the providers a share link may belong to and what it points to
+type DetectShareLinkResponse struct {
+	Providers []string             `json:"providers"`
+	Kind      models.ShareLinkKind `json:"kind"`
+}
+
+// RecentFoldersResponse lists the folder links a session opened recently, most recent first
+type RecentFoldersResponse struct {
+	Folders []models.RecentFolder `json:"folders"`
+}
+
 type GetFolderContentsResponse struct {
 	Folder   *models.CloudItem   `json:"folder"`
 	Contents []*models.CloudItem `json:"contents"`
+	// Truncated is set when the folder has more items than a listing returns; TotalItems and Message
+	// then report how many it has and how to work with the rest
+	Truncated  bool   `json:"truncated,omitempty"`
+	TotalItems int    `json:"total_items,omitempty"`
+	Message    string `json:"message,omitempty"`
+	// NextPageToken is set on a paged listing with more pages; pass it as page_token to get the next one
+	NextPageToken string `json:"next_page_token,omitempty"`
+}
+
+// FolderStreamLine is one line of a streamed folder listing: the folder first, then one line per item,
+// and last either Done or Error, so clients can tell a finished listing from a dropped connection
+type FolderStreamLine struct {
+	Folder *models.CloudItem `json:"folder,omitempty"`
+	Item   *models.CloudItem `json:"item,omitempty"`
+	Done   bool              `json:"done,omitempty"`
+	Error  string            `json:"error,omitempty"`
+	Code   string            `json:"code,omitempty"`
 }
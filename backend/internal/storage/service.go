@@ -1,31 +1,115 @@
 package storage
 
 import (
+	"all-me-backend/internal/providers/workers"
+	"all-me-backend/pkg/config"
+	"all-me-backend/pkg/mediatypes"
 	"all-me-backend/pkg/models"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/url"
 	"slices"
 	"strings"
 )
 
+// Page size limits per provider; Google Drive accepts up to 1000 items per page, OneDrive caps at 200
+// and Dropbox at 2000
+const (
+	defaultGoogleDrivePageSize = 1000
+	maxGoogleDrivePageSize     = 1000
+	defaultOneDrivePageSize    = 200
+	maxOneDrivePageSize        = 200
+	defaultDropboxPageSize     = 1000
+	maxDropboxPageSize         = 2000
+)
+
+// defaultMaxListingItems caps the items returned by a folder listing response
+const defaultMaxListingItems = 5000
+
+// Recursion limits for listing images in nested folders
+const (
+	defaultRecursionWarnDepth = 5
+	defaultRecursionMaxDepth  = 20
+)
+
+// ErrMissingToken is returned when a provider call is made without a token, instead of panicking
+var ErrMissingToken = errors.New("no provider token, sign in again")
+
 type Service struct {
-	googleDriveStorage Provider
-	oneDriveStorage    Provider
+	googleDriveStorage  Provider
+	oneDriveStorage     Provider
+	dropboxStorage      Provider
+	googleDrivePageSize int
+	oneDrivePageSize    int
+	dropboxPageSize     int
+	recursionWarnDepth  int // Depth beyond which listing continues but a warning is reported
+	recursionMaxDepth   int // Depth beyond which subfolders are not descended into
+	imageMimeTypes      []string
+	workers             *workers.Pool // Shared with image downloads, bounds concurrent folder listings
+	maxListingItems     int           // Items a folder listing response returns at most; comparisons still see every item
 }
 
 func NewService(
 	googleDriveStorage Provider,
 	oneDriveStorage Provider,
+	dropboxStorage Provider,
 ) *Service {
 	return &Service{
-		googleDriveStorage: googleDriveStorage,
-		oneDriveStorage:    oneDriveStorage,
+		googleDriveStorage:  googleDriveStorage,
+		oneDriveStorage:     oneDriveStorage,
+		dropboxStorage:      dropboxStorage,
+		googleDrivePageSize: pageSizeFromEnv("GOOGLEDRIVE_PAGE_SIZE", defaultGoogleDrivePageSize, maxGoogleDrivePageSize),
+		oneDrivePageSize:    pageSizeFromEnv("ONEDRIVE_PAGE_SIZE", defaultOneDrivePageSize, maxOneDrivePageSize),
+		dropboxPageSize:     pageSizeFromEnv("DROPBOX_PAGE_SIZE", defaultDropboxPageSize, maxDropboxPageSize),
+		recursionWarnDepth:  config.GetInt("RECURSION_WARN_DEPTH", defaultRecursionWarnDepth),
+		recursionMaxDepth:   config.GetInt("RECURSION_MAX_DEPTH", defaultRecursionMaxDepth),
+		imageMimeTypes:      mediatypes.FaceComparableFromEnv(),
+		maxListingItems:     maxListingItemsFromEnv(),
+		workers:             workers.Shared(),
+	}
+}
+
+// maxListingItemsFromEnv reads the folder listing response cap, falling back to the default when not positive
+func maxListingItemsFromEnv() int {
+	maxItems := config.GetInt("MAX_LISTING_ITEMS", defaultMaxListingItems)
+	if maxItems < 1 {
+		log.Printf("MAX_LISTING_ITEMS must be positive, using default %d", defaultMaxListingItems)
+		return defaultMaxListingItems
+	}
+	return maxItems
+}
+
+// TruncateListing caps a sorted folder listing at the configured maximum, reporting whether items were dropped
+// Listings are sorted folders first, so subfolders to narrow the listing down stay visible
+func (s *Service) TruncateListing(contents []*models.CloudItem) ([]*models.CloudItem, bool) {
+	if len(contents) <= s.maxListingItems {
+		return contents, false
+	}
+	return contents[:s.maxListingItems], true
+}
+
+// pageSizeFromEnv reads a listing page size from the environment, clamped to [1, maxSize]
+func pageSizeFromEnv(key string, defaultSize, maxSize int) int {
+	pageSize := config.GetInt(key, defaultSize)
+	if pageSize < 1 {
+		log.Printf("%s must be positive, using default %d", key, defaultSize)
+		return defaultSize
+	}
+	if pageSize > maxSize {
+		log.Printf("%s exceeds the provider maximum, clamping to %d", key, maxSize)
+		return maxSize
 	}
+	return pageSize
 }
 
 // ParseShareLink extracts folder ID and provider from a cloud storage share link
 func (s *Service) ParseShareLink(shareURL string, token *models.Token) (*models.CloudItem, error) {
+	if token == nil {
+		return nil, ErrMissingToken
+	}
+
 	cleanURL := strings.TrimSpace(shareURL)
 	parsedURL, err := url.Parse(cleanURL)
 	if err != nil {
@@ -42,98 +126,401 @@ func (s *Service) ParseShareLink(shareURL string, token *models.Token) (*models.
 		return s.oneDriveStorage.ParseShareLink(cleanURL, token)
 	case "googledrive":
 		return s.googleDriveStorage.ParseShareLink(cleanURL, token)
+	case "dropbox":
+		return s.dropboxStorage.ParseShareLink(cleanURL, token)
+	default:
+		return nil, fmt.Errorf("unsupported provider: %s", token.Provider)
+	}
+}
+
+// CanonicalFolderID resolves a folder share link to a stable, provider-namespaced ID
+// Different links to the same folder (short and long forms, extra query parameters) share one ID
+func (s *Service) CanonicalFolderID(shareURL string, token *models.Token) (string, error) {
+	if token == nil {
+		return "", ErrMissingToken
+	}
+
+	cleanURL := strings.TrimSpace(shareURL)
+
+	switch token.Provider {
+	case "onedrive":
+		return s.oneDriveStorage.CanonicalFolderID(cleanURL, token)
+	case "googledrive":
+		return s.googleDriveStorage.CanonicalFolderID(cleanURL, token)
+	case "dropbox":
+		return s.dropboxStorage.CanonicalFolderID(cleanURL, token)
+	default:
+		return "", fmt.Errorf("unsupported provider: %s", token.Provider)
+	}
+}
+
+// GetAccountID returns a provider-namespaced ID ("{provider}:{id}") for the account a token belongs to
+func (s *Service) GetAccountID(token *models.Token) (string, error) {
+	if token == nil {
+		return "", ErrMissingToken
+	}
+
+	var provider Provider
+	switch token.Provider {
+	case "onedrive":
+		provider = s.oneDriveStorage
+	case "googledrive":
+		provider = s.googleDriveStorage
+	case "dropbox":
+		provider = s.dropboxStorage
+	default:
+		return "", fmt.Errorf("unsupported provider: %s", token.Provider)
+	}
+
+	accountID, err := provider.GetAccountID(token)
+	if err != nil {
+		return "", err
+	}
+
+	return token.Provider + ":" + accountID, nil
+}
+
+// DetectShareLink reports which providers recognize a share link and whether it looks like a folder or a file
+// Only the URL's shape is checked, so no token is needed
+func (s *Service) DetectShareLink(shareURL string) *DetectShareLinkResponse {
+	response := &DetectShareLinkResponse{
+		Providers: []string{},
+		Kind:      models.ShareLinkUnrecognized,
+	}
+
+	providers := []struct {
+		name     string
+		provider Provider
+	}{
+		{"googledrive", s.googleDriveStorage},
+		{"onedrive", s.oneDriveStorage},
+		{"dropbox", s.dropboxStorage},
+	}
+	for _, p := range providers {
+		kind := p.provider.DetectShareLink(shareURL)
+		if kind == models.ShareLinkUnrecognized {
+			continue
+		}
+
+		response.Providers = append(response.Providers, p.name)
+		if response.Kind != models.ShareLinkFolder {
+			response.Kind = kind
+		}
+	}
+
+	return response
+}
+
+// GetRootFolder returns the root folder of the user's own drive, for browsing without a share link
+func (s *Service) GetRootFolder(token *models.Token) (*models.CloudItem, error) {
+	if token == nil {
+		return nil, ErrMissingToken
+	}
+
+	switch token.Provider {
+	case "onedrive":
+		return s.oneDriveStorage.GetRootFolder(token)
+	case "googledrive":
+		return s.googleDriveStorage.GetRootFolder(token)
+	case "dropbox":
+		return s.dropboxStorage.GetRootFolder(token)
 	default:
 		return nil, fmt.Errorf("unsupported provider: %s", token.Provider)
 	}
 }
 
 // ListFolderContents lists all items (files and folders) in the specified folder
-func (s *Service) ListFolderContents(item *models.CloudItem, token *models.Token) ([]*models.CloudItem, error) {
+// Files modified outside dates are left out; subfolders are always listed
+func (s *Service) ListFolderContents(item *models.CloudItem, token *models.Token, dates models.DateRange) ([]*models.CloudItem, error) {
+	if token == nil {
+		return nil, ErrMissingToken
+	}
+
 	switch token.Provider {
 	case "onedrive":
-		return s.listAllItemsWithPagination(item, token, s.oneDriveStorage)
+		return s.listAllItemsWithPagination(item, token, s.oneDriveStorage, s.oneDrivePageSize, dates)
 	case "googledrive":
-		return s.listAllItemsWithPagination(item, token, s.googleDriveStorage)
+		return s.listAllItemsWithPagination(item, token, s.googleDriveStorage, s.googleDrivePageSize, dates)
+	case "dropbox":
+		return s.listAllItemsWithPagination(item, token, s.dropboxStorage, s.dropboxPageSize, dates)
 	default:
 		return nil, fmt.Errorf("unsupported provider: %s", token.Provider)
 	}
 }
 
-// ListImages lists all image files in the specified folder
-func (s *Service) ListImages(item *models.CloudItem, token *models.Token, recursive bool) ([]*models.CloudItem, error) {
-	allItems, err := s.ListFolderContents(item, token)
+// ListFolderContentsPage lists one page of the folder, returning the token of the next page, empty on the last one
+// pageSize is clamped to the provider's maximum and the configured page size is used when it is not positive;
+// items are filtered and sorted like ListFolderContents, but only within the page
+func (s *Service) ListFolderContentsPage(item *models.CloudItem, token *models.Token, pageSize int, pageToken string, dates models.DateRange) ([]*models.CloudItem, string, error) {
+	if token == nil {
+		return nil, "", ErrMissingToken
+	}
+
+	var provider Provider
+	var defaultSize, maxSize int
+	switch token.Provider {
+	case "onedrive":
+		provider, defaultSize, maxSize = s.oneDriveStorage, s.oneDrivePageSize, maxOneDrivePageSize
+	case "googledrive":
+		provider, defaultSize, maxSize = s.googleDriveStorage, s.googleDrivePageSize, maxGoogleDrivePageSize
+	case "dropbox":
+		provider, defaultSize, maxSize = s.dropboxStorage, s.dropboxPageSize, maxDropboxPageSize
+	default:
+		return nil, "", fmt.Errorf("unsupported provider: %s", token.Provider)
+	}
+
+	if pageSize < 1 {
+		pageSize = defaultSize
+	}
+	pageSize = min(pageSize, maxSize)
+
+	items, nextToken, err := provider.ListFolderContents(item, token, pageSize, pageToken, dates)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list folder contents: %w", err)
+	}
+
+	var pageItems []*models.CloudItem
+	for _, listed := range items {
+		if listed.IsFolder || dates.Contains(listed.ModifiedTime) {
+			pageItems = append(pageItems, listed)
+		}
+	}
+	s.sortCloudItems(pageItems)
+
+	return pageItems, nextToken, nil
+}
+
+// ListImages lists all image files in the specified folder that were modified within dates
+// When recursive, subfolders are descended into up to the configured maximum depth, and the
+// returned warnings report trees deeper than the warning depth or cut off at the maximum depth
+func (s *Service) ListImages(item *models.CloudItem, token *models.Token, recursive bool, dates models.DateRange) ([]*models.CloudItem, []string, error) {
+	walk := &folderWalk{dates: dates}
+
+	images, err := s.listFiles(item, token, recursive, walk, s.IsImage)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	images := make([]*models.CloudItem, 0)
-	for _, currentItem := range allItems {
-		if currentItem.IsFolder && recursive {
-			// Recursively get images from subfolder
-			subImages, err := s.ListImages(currentItem, token, recursive)
-			if err != nil {
-				continue
-			}
+	var warnings []string
+	if walk.deepestDepth > s.recursionWarnDepth {
+		warnings = append(warnings, fmt.Sprintf("Folder tree reaches depth %d, deeper than the recommended %d", walk.deepestDepth, s.recursionWarnDepth))
+	}
+	if walk.maxDepthHit {
+		warnings = append(warnings, fmt.Sprintf("Maximum folder depth %d reached, deeper subfolders were skipped", s.recursionMaxDepth))
+	}
+
+	return images, warnings, nil
+}
+
+// ListNonImageFiles lists the files ListImages skips, walking subfolders the same way
+func (s *Service) ListNonImageFiles(item *models.CloudItem, token *models.Token, recursive bool) ([]*models.CloudItem, error) {
+	return s.listFiles(item, token, recursive, &folderWalk{}, func(file *models.CloudItem) bool {
+		return !s.IsImage(file)
+	})
+}
+
+// IsImage reports whether a file is one of the image types accepted for face comparison
+func (s *Service) IsImage(file *models.CloudItem) bool {
+	return mediatypes.Contains(s.imageMimeTypes, file.MimeType)
+}
+
+// ImageMimeTypes returns the mime types treated as candidate images in folders
+func (s *Service) ImageMimeTypes() []string {
+	return s.imageMimeTypes
+}
+
+// ListAllImages lists image files across the user's entire drive, up to limit items
+func (s *Service) ListAllImages(token *models.Token, limit int) ([]*models.CloudItem, error) {
+	if token == nil {
+		return nil, ErrMissingToken
+	}
+
+	var allItems []*models.CloudItem
+	var err error
 
-			images = append(images, subImages...)
-		} else if !currentItem.IsFolder && IsImageMimeType(currentItem.MimeType) {
-			images = append(images, currentItem)
+	switch token.Provider {
+	case "onedrive":
+		allItems, err = s.oneDriveStorage.ListAllImages(token, limit)
+	case "googledrive":
+		allItems, err = s.googleDriveStorage.ListAllImages(token, limit)
+	case "dropbox":
+		allItems, err = s.dropboxStorage.ListAllImages(token, limit)
+	default:
+		return nil, fmt.Errorf("unsupported provider: %s", token.Provider)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list drive images: %w", err)
+	}
+
+	images := make([]*models.CloudItem, 0, len(allItems))
+	for _, item := range allItems {
+		if !item.IsFolder && s.IsImage(item) {
+			images = append(images, item)
 		}
 	}
 
 	return images, nil
 }
 
+// GetItem re-fetches an item's metadata from the provider by its ID
+// The returned item carries server-derived URLs, so client-supplied URLs are never trusted
+func (s *Service) GetItem(item *models.CloudItem, token *models.Token) (*models.CloudItem, error) {
+	if token == nil {
+		return nil, ErrMissingToken
+	}
+
+	switch token.Provider {
+	case "onedrive":
+		return s.oneDriveStorage.GetItem(item, token)
+	case "googledrive":
+		return s.googleDriveStorage.GetItem(item, token)
+	case "dropbox":
+		return s.dropboxStorage.GetItem(item, token)
+	default:
+		return nil, fmt.Errorf("unsupported provider: %s", token.Provider)
+	}
+}
+
 // GetFileStream retrieves a file stream for downloading (full resolution)
 func (s *Service) GetFileStream(item *models.CloudItem, token *models.Token) (io.ReadCloser, error) {
+	if token == nil {
+		return nil, ErrMissingToken
+	}
+
 	switch token.Provider {
 	case "onedrive":
 		return s.oneDriveStorage.GetFileStream(item, token)
 	case "googledrive":
 		return s.googleDriveStorage.GetFileStream(item, token)
+	case "dropbox":
+		return s.dropboxStorage.GetFileStream(item, token)
 	default:
 		return nil, fmt.Errorf("unsupported provider: %s", token.Provider)
 	}
 }
 
+// CopyToFolder copies a file into a folder of the user's own drive on the token's provider
+func (s *Service) CopyToFolder(item, destination *models.CloudItem, token *models.Token) error {
+	if token == nil {
+		return ErrMissingToken
+	}
+
+	switch token.Provider {
+	case "onedrive":
+		return s.oneDriveStorage.CopyToFolder(item, destination, token)
+	case "googledrive":
+		return s.googleDriveStorage.CopyToFolder(item, destination, token)
+	case "dropbox":
+		return s.dropboxStorage.CopyToFolder(item, destination, token)
+	default:
+		return fmt.Errorf("unsupported provider: %s", token.Provider)
+	}
+}
+
+// UploadFile writes a new file into a folder of the user's own drive on the token's provider
+func (s *Service) UploadFile(destination *models.CloudItem, name, mimeType string, content []byte, token *models.Token) error {
+	if token == nil {
+		return ErrMissingToken
+	}
+
+	switch token.Provider {
+	case "onedrive":
+		return s.oneDriveStorage.UploadFile(destination, name, mimeType, content, token)
+	case "googledrive":
+		return s.googleDriveStorage.UploadFile(destination, name, mimeType, content, token)
+	case "dropbox":
+		return s.dropboxStorage.UploadFile(destination, name, mimeType, content, token)
+	default:
+		return fmt.Errorf("unsupported provider: %s", token.Provider)
+	}
+}
+
 // GetFaceRecognitionOptimizedStream retrieves a 800px image stream optimized for face recognition processing
 func (s *Service) GetFaceRecognitionOptimizedStream(item *models.CloudItem, token *models.Token) (io.ReadCloser, error) {
+	if token == nil {
+		return nil, ErrMissingToken
+	}
+
 	switch token.Provider {
 	case "onedrive":
 		return s.oneDriveStorage.GetFaceRecognitionOptimizedStream(item, token)
 	case "googledrive":
 		return s.googleDriveStorage.GetFaceRecognitionOptimizedStream(item, token)
+	case "dropbox":
+		return s.dropboxStorage.GetFaceRecognitionOptimizedStream(item, token)
 	default:
 		return nil, fmt.Errorf("unsupported provider: %s", token.Provider)
 	}
 }
 
+// StreamFolderContents lists the folder like ListFolderContents, but hands each provider page to onPage
+// as soon as it is fetched instead of collecting the whole folder first
+// Items are sorted within each page only; an error from onPage stops the listing and is returned
+func (s *Service) StreamFolderContents(item *models.CloudItem, token *models.Token, dates models.DateRange, onPage func([]*models.CloudItem) error) error {
+	if token == nil {
+		return ErrMissingToken
+	}
+
+	switch token.Provider {
+	case "onedrive":
+		return s.forEachPage(item, token, s.oneDriveStorage, s.oneDrivePageSize, dates, onPage)
+	case "googledrive":
+		return s.forEachPage(item, token, s.googleDriveStorage, s.googleDrivePageSize, dates, onPage)
+	case "dropbox":
+		return s.forEachPage(item, token, s.dropboxStorage, s.dropboxPageSize, dates, onPage)
+	default:
+		return fmt.Errorf("unsupported provider: %s", token.Provider)
+	}
+}
+
 // listAllItemsWithPagination handles pagination for listing all items from cloud storage
-func (s *Service) listAllItemsWithPagination(item *models.CloudItem, token *models.Token, provider Provider) ([]*models.CloudItem, error) {
-	const pageSize = 100
+func (s *Service) listAllItemsWithPagination(item *models.CloudItem, token *models.Token, provider Provider, pageSize int, dates models.DateRange) ([]*models.CloudItem, error) {
 	var allItems []*models.CloudItem
+
+	err := s.forEachPage(item, token, provider, pageSize, dates, func(items []*models.CloudItem) error {
+		allItems = append(allItems, items...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Sort items: folders first, then images, then other files
+	s.sortCloudItems(allItems)
+
+	return allItems, nil
+}
+
+// forEachPage fetches the folder page by page, passing each page's items within dates to onPage
+func (s *Service) forEachPage(item *models.CloudItem, token *models.Token, provider Provider, pageSize int, dates models.DateRange, onPage func([]*models.CloudItem) error) error {
 	var nextPageToken string
 
 	for {
 		// Get current page of items (files and folders)
-		items, nextToken, err := provider.ListFolderContents(item, token, pageSize, nextPageToken)
+		items, nextToken, err := provider.ListFolderContents(item, token, pageSize, nextPageToken, dates)
 		if err != nil {
-			return nil, fmt.Errorf("failed to list folder contents: %w", err)
+			return fmt.Errorf("failed to list folder contents: %w", err)
 		}
 
-		allItems = append(allItems, items...)
+		// Not every provider can filter by date itself
+		var pageItems []*models.CloudItem
+		for _, listed := range items {
+			if listed.IsFolder || dates.Contains(listed.ModifiedTime) {
+				pageItems = append(pageItems, listed)
+			}
+		}
+		s.sortCloudItems(pageItems)
+
+		if err := onPage(pageItems); err != nil {
+			return err
+		}
 
 		// Check if there are more pages
 		if nextToken == "" {
-			break
+			return nil
 		}
 		nextPageToken = nextToken
 	}
-
-	// Sort items: folders first, then images, then other files
-	s.sortCloudItems(allItems)
-
-	return allItems, nil
 }
 
 // sortCloudItems sorts items by type: folders first, then images, then other files
@@ -149,8 +536,8 @@ func (s *Service) sortCloudItems(items []*models.CloudItem) {
 
 		// If both are not folders, images come before other files
 		if !a.IsFolder && !b.IsFolder {
-			aIsImage := IsImageMimeType(a.MimeType)
-			bIsImage := IsImageMimeType(b.MimeType)
+			aIsImage := s.IsImage(a)
+			bIsImage := s.IsImage(b)
 
 			if aIsImage && !bIsImage {
 				return -1
@@ -164,16 +551,3 @@ func (s *Service) sortCloudItems(items []*models.CloudItem) {
 		return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
 	})
 }
-
-func IsImageMimeType(mimeType string) bool {
-	imageMimeTypes := []string{
-		"image/jpeg",
-		"image/jpg",
-		"image/png",
-		"image/gif",
-		"image/webp",
-		"image/bmp",
-		"image/svg+xml",
-	}
-	return slices.Contains(imageMimeTypes, mimeType)
-}
@@ -0,0 +1,453 @@
+package storage
+
+import (
+	"all-me-backend/internal/providers/workers"
+	"all-me-backend/pkg/models"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestListFolderContents_UsesConfiguredPageSize(t *testing.T) {
+	t.Setenv("GOOGLEDRIVE_PAGE_SIZE", "500")
+	t.Setenv("ONEDRIVE_PAGE_SIZE", "5000")
+
+	googleDrive := &mockProvider{}
+	oneDrive := &mockProvider{}
+	service := NewService(googleDrive, oneDrive, &mockProvider{})
+
+	folder := &models.CloudItem{ID: "folder"}
+
+	if _, err := service.ListFolderContents(folder, &models.Token{Provider: "googledrive"}, models.DateRange{}); err != nil {
+		t.Fatalf("ListFolderContents failed: %v", err)
+	}
+	if _, err := service.ListFolderContents(folder, &models.Token{Provider: "onedrive"}, models.DateRange{}); err != nil {
+		t.Fatalf("ListFolderContents failed: %v", err)
+	}
+
+	if len(googleDrive.pageSizes) != 2 || googleDrive.pageSizes[0] != 500 {
+		t.Errorf("Expected Google Drive page size 500, got %v", googleDrive.pageSizes)
+	}
+
+	if len(oneDrive.pageSizes) != 2 || oneDrive.pageSizes[0] != maxOneDrivePageSize {
+		t.Errorf("Expected OneDrive page size clamped to %d, got %v", maxOneDrivePageSize, oneDrive.pageSizes)
+	}
+}
+
+func TestPageSizeFromEnv(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  int
+	}{
+		{"unset", "", 200},
+		{"valid", "50", 50},
+		{"above max", "1000", 200},
+		{"zero", "0", 200},
+		{"negative", "-10", 200},
+		{"invalid", "many", 200},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TEST_PAGE_SIZE", tt.value)
+
+			if got := pageSizeFromEnv("TEST_PAGE_SIZE", 200, 200); got != tt.want {
+				t.Errorf("Expected page size %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestListImages_RecursionDepthLimits(t *testing.T) {
+	// A chain of nested folders root -> d1 -> d2 -> d3 -> d4, each holding one image
+	tree := make(map[string][]*models.CloudItem)
+	parent := "root"
+	for depth := 1; depth <= 4; depth++ {
+		folderID := fmt.Sprintf("d%d", depth)
+		tree[parent] = []*models.CloudItem{
+			{ID: folderID, IsFolder: true},
+			{ID: parent + "-img", Name: parent + ".jpg", MimeType: "image/jpeg"},
+		}
+		parent = folderID
+	}
+	tree[parent] = []*models.CloudItem{{ID: parent + "-img", Name: parent + ".jpg", MimeType: "image/jpeg"}}
+
+	tests := []struct {
+		name         string
+		warnDepth    int
+		maxDepth     int
+		wantImages   int
+		wantWarnings []string
+	}{
+		{"within limits", 10, 10, 5, nil},
+		{"warn only", 2, 10, 5, []string{"Folder tree reaches depth 4, deeper than the recommended 2"}},
+		{"hard stop", 2, 3, 4, []string{
+			"Folder tree reaches depth 3, deeper than the recommended 2",
+			"Maximum folder depth 3 reached, deeper subfolders were skipped",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("RECURSION_WARN_DEPTH", strconv.Itoa(tt.warnDepth))
+			t.Setenv("RECURSION_MAX_DEPTH", strconv.Itoa(tt.maxDepth))
+
+			service := NewService(&mockProvider{tree: tree}, &mockProvider{}, &mockProvider{})
+
+			images, warnings, err := service.ListImages(&models.CloudItem{ID: "root"}, &models.Token{Provider: "googledrive"}, true, models.DateRange{})
+			if err != nil {
+				t.Fatalf("ListImages failed: %v", err)
+			}
+
+			if len(images) != tt.wantImages {
+				t.Errorf("Expected %d images, got %d", tt.wantImages, len(images))
+			}
+
+			if !slices.Equal(warnings, tt.wantWarnings) {
+				t.Errorf("Expected warnings %q, got %q", tt.wantWarnings, warnings)
+			}
+		})
+	}
+}
+
+func TestListImages_ListsSubfoldersConcurrentlyWithinPool(t *testing.T) {
+	// root holds 8 folders, each holding an image and a nested folder with another image
+	tree := map[string][]*models.CloudItem{}
+	var want []string
+	for i := range 8 {
+		folderID := fmt.Sprintf("f%d", i)
+		nestedID := folderID + "-nested"
+		tree["root"] = append(tree["root"], &models.CloudItem{ID: folderID, Name: folderID, IsFolder: true})
+		tree[folderID] = []*models.CloudItem{
+			{ID: nestedID, Name: nestedID, IsFolder: true},
+			{ID: folderID + "-img", Name: folderID + ".jpg", MimeType: "image/jpeg"},
+		}
+		tree[nestedID] = []*models.CloudItem{{ID: nestedID + "-img", Name: nestedID + ".jpg", MimeType: "image/jpeg"}}
+		want = append(want, nestedID+"-img", folderID+"-img")
+	}
+	tree["root"] = append(tree["root"], &models.CloudItem{ID: "root-img", Name: "root.jpg", MimeType: "image/jpeg"})
+	want = append(want, "root-img")
+
+	provider := &mockProvider{tree: tree, listDelay: 5 * time.Millisecond}
+	service := NewService(provider, &mockProvider{}, &mockProvider{})
+	service.workers = workers.NewPool(3)
+
+	images, _, err := service.ListImages(&models.CloudItem{ID: "root"}, &models.Token{Provider: "googledrive"}, true, models.DateRange{})
+	if err != nil {
+		t.Fatalf("ListImages failed: %v", err)
+	}
+
+	var ids []string
+	for _, image := range images {
+		ids = append(ids, image.ID)
+	}
+	if !slices.Equal(ids, want) {
+		t.Errorf("Expected images in depth-first order %v, got %v", want, ids)
+	}
+
+	if provider.maxActive > 3 {
+		t.Errorf("Expected at most 3 concurrent listings, got %d", provider.maxActive)
+	}
+	if provider.maxActive < 2 {
+		t.Errorf("Expected subfolders to be listed concurrently, got at most %d at a time", provider.maxActive)
+	}
+}
+
+func TestService_NilTokenReturnsError(t *testing.T) {
+	service := NewService(&mockProvider{}, &mockProvider{}, &mockProvider{})
+	item := &models.CloudItem{ID: "item"}
+	folder := &models.CloudItem{ID: "folder", IsFolder: true}
+
+	calls := map[string]func() error{
+		"ParseShareLink": func() error {
+			_, err := service.ParseShareLink("https://drive.google.com/drive/folders/abc", nil)
+			return err
+		},
+		"CanonicalFolderID": func() error {
+			_, err := service.CanonicalFolderID("https://drive.google.com/drive/folders/abc", nil)
+			return err
+		},
+		"GetAccountID": func() error {
+			_, err := service.GetAccountID(nil)
+			return err
+		},
+		"GetRootFolder": func() error {
+			_, err := service.GetRootFolder(nil)
+			return err
+		},
+		"ListFolderContents": func() error {
+			_, err := service.ListFolderContents(folder, nil, models.DateRange{})
+			return err
+		},
+		"ListImages": func() error {
+			_, _, err := service.ListImages(folder, nil, true, models.DateRange{})
+			return err
+		},
+		"ListNonImageFiles": func() error {
+			_, err := service.ListNonImageFiles(folder, nil, true)
+			return err
+		},
+		"ListAllImages": func() error {
+			_, err := service.ListAllImages(nil, 10)
+			return err
+		},
+		"GetItem": func() error {
+			_, err := service.GetItem(item, nil)
+			return err
+		},
+		"GetFileStream": func() error {
+			_, err := service.GetFileStream(item, nil)
+			return err
+		},
+		"GetFaceRecognitionOptimizedStream": func() error {
+			_, err := service.GetFaceRecognitionOptimizedStream(item, nil)
+			return err
+		},
+		"CopyToFolder": func() error {
+			return service.CopyToFolder(item, folder, nil)
+		},
+		"UploadFile": func() error {
+			return service.UploadFile(folder, "matches.csv", "text/csv", nil, nil)
+		},
+	}
+
+	for name, call := range calls {
+		t.Run(name, func(t *testing.T) {
+			if err := call(); !errors.Is(err, ErrMissingToken) {
+				t.Errorf("Expected ErrMissingToken, got %v", err)
+			}
+		})
+	}
+}
+
+func TestListNonImageFiles(t *testing.T) {
+	tree := map[string][]*models.CloudItem{
+		"root": {
+			{ID: "sub", IsFolder: true},
+			{ID: "photo", Name: "photo.jpg", MimeType: "image/jpeg"},
+			{ID: "notes", Name: "notes.txt", MimeType: "text/plain"},
+		},
+		"sub": {
+			{ID: "video", Name: "clip.mp4", MimeType: "video/mp4"},
+			{ID: "nested", Name: "nested.png", MimeType: "image/png"},
+		},
+	}
+	service := NewService(&mockProvider{tree: tree}, &mockProvider{}, &mockProvider{})
+	token := &models.Token{Provider: "googledrive"}
+
+	files, err := service.ListNonImageFiles(&models.CloudItem{ID: "root"}, token, true)
+	if err != nil {
+		t.Fatalf("ListNonImageFiles failed: %v", err)
+	}
+
+	var ids []string
+	for _, file := range files {
+		ids = append(ids, file.ID)
+	}
+	if !slices.Equal(ids, []string{"video", "notes"}) {
+		t.Errorf("Expected non-image files [video notes], got %v", ids)
+	}
+
+	files, err = service.ListNonImageFiles(&models.CloudItem{ID: "root"}, token, false)
+	if err != nil {
+		t.Fatalf("ListNonImageFiles failed: %v", err)
+	}
+	if len(files) != 1 || files[0].ID != "notes" {
+		t.Errorf("Expected only notes without recursion, got %v", files)
+	}
+}
+
+func TestParseDateRange(t *testing.T) {
+	tests := []struct {
+		name       string
+		after      string
+		before     string
+		wantAfter  time.Time
+		wantBefore time.Time
+		wantErr    bool
+	}{
+		{"empty", "", "", time.Time{}, time.Time{}, false},
+		{"dates", "2024-05-01", "2024-05-31", time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), false},
+		{"timestamp", "2024-05-01T12:30:00+02:00", "", time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC), time.Time{}, false},
+		{"invalid", "May 1st", "", time.Time{}, time.Time{}, true},
+		{"reversed", "2024-06-01", "2024-05-01", time.Time{}, time.Time{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dates, err := models.ParseDateRange(tt.after, tt.before)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !dates.After.Equal(tt.wantAfter) || !dates.Before.Equal(tt.wantBefore) {
+				t.Errorf("Expected range %v - %v, got %v - %v", tt.wantAfter, tt.wantBefore, dates.After, dates.Before)
+			}
+		})
+	}
+}
+
+func TestListImages_FiltersByModifiedTime(t *testing.T) {
+	inRange := time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC)
+	tooOld := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	provider := &mockProvider{tree: map[string][]*models.CloudItem{
+		"root": {
+			{ID: "recent", Name: "recent.jpg", MimeType: "image/jpeg", ModifiedTime: inRange},
+			{ID: "old", Name: "old.jpg", MimeType: "image/jpeg", ModifiedTime: tooOld},
+			{ID: "unknown", Name: "unknown.jpg", MimeType: "image/jpeg"},
+			{ID: "album", Name: "Album", IsFolder: true, ModifiedTime: tooOld},
+		},
+		"album": {
+			{ID: "nested", Name: "nested.jpg", MimeType: "image/jpeg", ModifiedTime: inRange},
+		},
+	}}
+	service := NewService(provider, &mockProvider{}, &mockProvider{})
+
+	dates, err := models.ParseDateRange("2024-05-01", "2024-05-31")
+	if err != nil {
+		t.Fatalf("ParseDateRange failed: %v", err)
+	}
+
+	images, _, err := service.ListImages(&models.CloudItem{ID: "root"}, &models.Token{Provider: "googledrive"}, true, dates)
+	if err != nil {
+		t.Fatalf("ListImages failed: %v", err)
+	}
+
+	var ids []string
+	for _, image := range images {
+		ids = append(ids, image.ID)
+	}
+	slices.Sort(ids)
+	// Folders are walked whatever their own modified time, and images without one are kept
+	if !slices.Equal(ids, []string{"nested", "recent", "unknown"}) {
+		t.Errorf("Expected images [nested recent unknown], got %v", ids)
+	}
+}
+
+func TestListFolderContentsPage_ReturnsOnePage(t *testing.T) {
+	oneDrive := &mockProvider{}
+	service := NewService(&mockProvider{}, oneDrive, &mockProvider{})
+
+	folder := &models.CloudItem{ID: "folder"}
+	token := &models.Token{Provider: "onedrive"}
+
+	items, next, err := service.ListFolderContentsPage(folder, token, 1000, "", models.DateRange{})
+	if err != nil {
+		t.Fatalf("ListFolderContentsPage failed: %v", err)
+	}
+	if len(items) != 1 || items[0].ID != "first" || next != "page-2" {
+		t.Errorf("Expected the first page and the next page's token, got %v and %q", items, next)
+	}
+
+	items, next, err = service.ListFolderContentsPage(folder, token, 0, next, models.DateRange{})
+	if err != nil {
+		t.Fatalf("ListFolderContentsPage failed: %v", err)
+	}
+	if len(items) != 1 || items[0].ID != "second" || next != "" {
+		t.Errorf("Expected the last page without a next token, got %v and %q", items, next)
+	}
+
+	// Requested sizes are clamped to the provider maximum, and unset ones use the configured size
+	if !slices.Equal(oneDrive.pageSizes, []int{maxOneDrivePageSize, defaultOneDrivePageSize}) {
+		t.Errorf("Expected page sizes [%d %d], got %v", maxOneDrivePageSize, defaultOneDrivePageSize, oneDrive.pageSizes)
+	}
+}
+
+// mockProvider is a test implementation of Provider
+// It serves folders from tree when set, otherwise every folder returns two pages
+// Share links are reported as linkKind, or unrecognized when unset
+// listDelay slows each listing down so concurrent listings overlap, tracked in active and maxActive
+// listErr, when set, fails every listing
+type mockProvider struct {
+	mu        sync.Mutex
+	pageSizes []int
+	listDelay time.Duration
+	active    int
+	maxActive int
+	tree      map[string][]*models.CloudItem
+	linkKind  models.ShareLinkKind
+	listErr   error
+}
+
+func (m *mockProvider) ListFolderContents(item *models.CloudItem, token *models.Token, pageSize int, nextPageToken string, dates models.DateRange) ([]*models.CloudItem, string, error) {
+	m.mu.Lock()
+	m.pageSizes = append(m.pageSizes, pageSize)
+	m.active++
+	m.maxActive = max(m.maxActive, m.active)
+	m.mu.Unlock()
+
+	time.Sleep(m.listDelay)
+
+	m.mu.Lock()
+	m.active--
+	m.mu.Unlock()
+
+	if m.listErr != nil {
+		return nil, "", m.listErr
+	}
+
+	if m.tree != nil {
+		return m.tree[item.ID], "", nil
+	}
+
+	if nextPageToken == "" {
+		return []*models.CloudItem{{ID: "first", Name: "a.jpg"}}, "page-2", nil
+	}
+	return []*models.CloudItem{{ID: "second", Name: "b.jpg"}}, "", nil
+}
+
+func (m *mockProvider) ListAllImages(token *models.Token, limit int) ([]*models.CloudItem, error) {
+	return nil, nil
+}
+
+func (m *mockProvider) GetItem(item *models.CloudItem, token *models.Token) (*models.CloudItem, error) {
+	return item, nil
+}
+
+func (m *mockProvider) GetFileStream(item *models.CloudItem, token *models.Token) (io.ReadCloser, error) {
+	return nil, nil
+}
+
+func (m *mockProvider) GetFaceRecognitionOptimizedStream(item *models.CloudItem, token *models.Token) (io.ReadCloser, error) {
+	return nil, nil
+}
+
+func (m *mockProvider) ParseShareLink(shareURL string, token *models.Token) (*models.CloudItem, error) {
+	return &models.CloudItem{ID: "shared", Name: "Shared", IsFolder: true, Provider: token.Provider}, nil
+}
+
+func (m *mockProvider) CanonicalFolderID(shareURL string, token *models.Token) (string, error) {
+	return token.Provider + ":" + shareURL, nil
+}
+
+func (m *mockProvider) CopyToFolder(item, destination *models.CloudItem, token *models.Token) error {
+	return nil
+}
+
+func (m *mockProvider) UploadFile(destination *models.CloudItem, name, mimeType string, content []byte, token *models.Token) error {
+	return nil
+}
+
+func (m *mockProvider) GetAccountID(token *models.Token) (string, error) {
+	return "account-" + token.AccessToken, nil
+}
+
+func (m *mockProvider) DetectShareLink(shareURL string) models.ShareLinkKind {
+	if m.linkKind == "" {
+		return models.ShareLinkUnrecognized
+	}
+	return m.linkKind
+}
+
+func (m *mockProvider) GetRootFolder(token *models.Token) (*models.CloudItem, error) {
+	return &models.CloudItem{ID: "root", Name: "My Drive", IsFolder: true, Provider: "googledrive"}, nil
+}
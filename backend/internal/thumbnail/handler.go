@@ -1,7 +1,9 @@
 package thumbnail
 
 import (
+	"all-me-backend/pkg/config"
 	"all-me-backend/pkg/models"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -9,17 +11,23 @@ import (
 	"github.com/labstack/echo/v4"
 )
 
+const defaultCacheControl = "public, max-age=3600" // Cache for 1 hour
+
 type Handler struct {
 	sessionStore       models.SessionStore
 	googleDriveService Provider
 	oneDriveService    Provider
+	dropboxService     Provider
+	cacheControl       string
 }
 
-func NewHandler(sessionStore models.SessionStore, googleDriveService Provider, oneDriveService Provider) *Handler {
+func NewHandler(sessionStore models.SessionStore, googleDriveService Provider, oneDriveService Provider, dropboxService Provider) *Handler {
 	return &Handler{
 		sessionStore:       sessionStore,
 		googleDriveService: googleDriveService,
 		oneDriveService:    oneDriveService,
+		dropboxService:     dropboxService,
+		cacheControl:       config.GetString("THUMBNAIL_CACHE_CONTROL", defaultCacheControl),
 	}
 }
 
@@ -31,6 +39,7 @@ func (h *Handler) handleThumbnailProxy(c echo.Context) error {
 	sessionID := c.QueryParam("session_id")
 	thumbnailURL := c.QueryParam("url")
 	provider := c.QueryParam("provider")
+	accountID := c.QueryParam("account_id")
 
 	if sessionID == "" {
 		return c.JSON(http.StatusBadRequest, map[string]string{
@@ -44,14 +53,25 @@ func (h *Handler) handleThumbnailProxy(c echo.Context) error {
 		})
 	}
 
-	if provider == "" {
+	provider, err := models.ResolveProvider(h.sessionStore, sessionID, provider)
+	if errors.Is(err, models.ErrAmbiguousProvider) {
 		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error": "provider is required",
+			"error": err.Error(),
+		})
+	}
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{
+			"error": fmt.Sprintf("Authentication failed: %v", err),
 		})
 	}
 
 	// Get token from session
-	token, err := h.sessionStore.GetSessionToken(sessionID, provider)
+	token, err := h.sessionStore.GetSessionToken(sessionID, provider, accountID)
+	if errors.Is(err, models.ErrAmbiguousAccount) {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{
 			"error": fmt.Sprintf("Authentication failed: %v", err),
@@ -65,6 +85,8 @@ func (h *Handler) handleThumbnailProxy(c echo.Context) error {
 		thumbnailStream, err = h.googleDriveService.GetThumbnailStream(thumbnailURL, token)
 	case "onedrive":
 		thumbnailStream, err = h.oneDriveService.GetThumbnailStream(thumbnailURL, token)
+	case "dropbox":
+		thumbnailStream, err = h.dropboxService.GetThumbnailStream(thumbnailURL, token)
 	default:
 		return c.JSON(http.StatusBadRequest, map[string]string{
 			"error": fmt.Sprintf("unsupported provider: %s", provider),
@@ -79,8 +101,8 @@ func (h *Handler) handleThumbnailProxy(c echo.Context) error {
 	defer thumbnailStream.Close()
 
 	// Set cache headers
-	c.Response().Header().Set("Cache-Control", "public, max-age=3600") // Cache for 1 hour
-	c.Response().Header().Set("Content-Type", "image/jpeg")            // Default to JPEG, could be improved
+	c.Response().Header().Set("Cache-Control", h.cacheControl)
+	c.Response().Header().Set("Content-Type", "image/jpeg") // Default to JPEG, could be improved
 
 	_, err = io.Copy(c.Response().Writer, thumbnailStream)
 	return err
@@ -4,14 +4,21 @@ import (
 	"all-me-backend/internal/auth"
 	"all-me-backend/internal/download"
 	"all-me-backend/internal/face"
+	"all-me-backend/internal/maintenance"
 	"all-me-backend/internal/middleware"
+	"all-me-backend/internal/providers/dropbox"
 	"all-me-backend/internal/providers/googledrive"
 	"all-me-backend/internal/providers/onedrive"
+	"all-me-backend/internal/providers/stub"
+	"all-me-backend/internal/settings"
 	"all-me-backend/internal/storage"
 	"all-me-backend/internal/thumbnail"
+	"all-me-backend/pkg/config"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -30,9 +37,34 @@ func main() {
 	e := echo.New()
 	initialize(e)
 
+	// kill -USR1 starts or stops draining, see DISABLE_NEW_JOBS
+	maintenance.Shared().ToggleOnSignal()
+
 	// Start server
-	log.Println("Starting All Me server on :8080")
-	log.Fatal(http.ListenAndServe(":8080", e))
+	certFile, keyFile, err := tlsFilesFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid TLS configuration: %v", err)
+	}
+
+	server := newServer(e)
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", server.Addr, err)
+	}
+
+	if certFile != "" {
+		log.Printf("Starting All Me server on %s with HTTPS", server.Addr)
+	} else {
+		log.Printf("Starting All Me server on %s", server.Addr)
+	}
+	log.Fatal(serve(server, listener, certFile, keyFile))
+}
+
+// cloudProvider is implemented by every provider service wired into the app
+type cloudProvider interface {
+	auth.Provider
+	storage.Provider
+	thumbnail.Provider
 }
 
 func initialize(e *echo.Echo) {
@@ -40,21 +72,30 @@ func initialize(e *echo.Echo) {
 	e.GET("/health", handleHealth)
 
 	// Initialize provider services
-	googleDriveService := googledrive.NewGoogleDriveService()
-	oneDriveService := onedrive.NewOneDriveService()
+	var googleDriveService, oneDriveService, dropboxService cloudProvider
+	if os.Getenv("USE_STUB_PROVIDER") == "true" {
+		googleDriveService, oneDriveService, dropboxService = initializeStubProviders(e)
+	} else {
+		googleDriveService = googledrive.NewGoogleDriveService()
+		oneDriveService = onedrive.NewOneDriveService()
+		dropboxService = dropbox.NewDropboxService()
+	}
 
 	// Initialize auth service with provider dependencies
-	authService := auth.NewService(googleDriveService, oneDriveService)
-	authHandler := auth.NewHandler(authService)
+	authService := auth.NewServiceWithStore(googleDriveService, oneDriveService, dropboxService, newAuthStore())
+	authHandler, err := auth.NewHandler(authService)
+	if err != nil {
+		log.Fatalf("Failed to initialize auth handler: %v", err)
+	}
 	authHandler.RegisterRoutes(e)
 
 	// Initialize storage service with provider dependencies
-	storageService := storage.NewService(googleDriveService, oneDriveService)
+	storageService := storage.NewService(googleDriveService, oneDriveService, dropboxService)
 	storageHandler := storage.NewHandler(storageService, authService)
 	storageHandler.RegisterRoutes(e)
 
 	// Initialize face service with storage service dependency
-	faceService := face.NewService(storageService)
+	faceService := face.NewService(storageService, authService)
 	faceHandler := face.NewHandler(faceService, authService)
 	faceHandler.RegisterRoutes(e)
 
@@ -63,8 +104,12 @@ func initialize(e *echo.Echo) {
 	downloadHandler := download.NewHandler(downloadService, authService)
 	downloadHandler.RegisterRoutes(e)
 
+	// Expose server-side limits and capabilities so the frontend stays in sync with them
+	settingsHandler := settings.NewHandler(faceService, downloadService, authService)
+	settingsHandler.RegisterRoutes(e)
+
 	// Initialize thumbnail proxy handler with provider services
-	thumbnailHandler := thumbnail.NewHandler(authService, googleDriveService, oneDriveService)
+	thumbnailHandler := thumbnail.NewHandler(authService, googleDriveService, oneDriveService, dropboxService)
 	thumbnailHandler.RegisterRoutes(e)
 
 	// Middleware
@@ -74,6 +119,69 @@ func initialize(e *echo.Echo) {
 	e.Use(middleware.CORSConfig())
 }
 
+// newAuthStore returns where OAuth states and sessions are kept, as chosen by SESSION_BACKEND
+// Redis keeps sessions across restarts and shares them between instances; when SESSION_BACKEND is unset,
+// Redis is used if REDIS_ADDR is set and memory otherwise
+func newAuthStore() auth.Store {
+	addr := os.Getenv("REDIS_ADDR")
+
+	backend := strings.ToLower(strings.TrimSpace(os.Getenv("SESSION_BACKEND")))
+	if backend == "" {
+		backend = "memory"
+		if addr != "" {
+			backend = "redis"
+		}
+	}
+
+	switch backend {
+	case "memory":
+		return auth.NewMemoryStore()
+	case "redis":
+		if addr == "" {
+			log.Fatal("SESSION_BACKEND is redis but REDIS_ADDR is not set")
+		}
+	default:
+		log.Fatalf("Unknown SESSION_BACKEND %q, expected memory or redis", backend)
+	}
+
+	db := config.GetInt("REDIS_DB", 0)
+	if db < 0 {
+		db = 0
+	}
+
+	store, err := auth.NewRedisStore(addr, os.Getenv("REDIS_PASSWORD"), db)
+	if err != nil {
+		log.Fatalf("Failed to connect to Redis at %s: %v", addr, err)
+	}
+	log.Printf("Storing sessions in Redis at %s", addr)
+	return store
+}
+
+// initializeStubProviders replaces the real providers with local stubs for development without credentials
+func initializeStubProviders(e *echo.Echo) (cloudProvider, cloudProvider, cloudProvider) {
+	log.Println("USE_STUB_PROVIDER is enabled, serving bundled test images instead of real cloud providers")
+
+	googleDriveStub, err := stub.NewStubService("googledrive")
+	if err != nil {
+		log.Fatalf("Failed to initialize stub provider: %v", err)
+	}
+	googleDriveStub.RegisterRoutes(e)
+
+	oneDriveStub, err := stub.NewStubService("onedrive")
+	if err != nil {
+		log.Fatalf("Failed to initialize stub provider: %v", err)
+	}
+	oneDriveStub.RegisterRoutes(e)
+
+	dropboxStub, err := stub.NewStubService("dropbox")
+	if err != nil {
+		log.Fatalf("Failed to initialize stub provider: %v", err)
+	}
+	dropboxStub.RegisterRoutes(e)
+
+	return googleDriveStub, oneDriveStub, dropboxStub
+}
+
 // handleHealth returns the health status of the backend service
 func handleHealth(c echo.Context) error {
 	response := map[string]interface{}{
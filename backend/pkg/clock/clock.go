@@ -0,0 +1,43 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the current time; expiry logic takes one so tests can control time without sleeping
+type Clock interface {
+	Now() time.Time
+}
+
+// Real is the system clock
+type Real struct{}
+
+func (Real) Now() time.Time {
+	return time.Now()
+}
+
+// Fake is a clock that only moves when advanced, for deterministic tests
+type Fake struct {
+	now time.Time
+	mu  sync.Mutex
+}
+
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.now
+}
+
+// Advance moves the clock forward by d
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.now = f.now.Add(d)
+}
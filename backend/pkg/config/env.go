@@ -0,0 +1,66 @@
+package config
+
+import (
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// GetString returns the value of an environment variable or the default if unset
+func GetString(key, defaultValue string) string {
+	if value := strings.TrimSpace(os.Getenv(key)); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// GetInt returns an integer environment variable or the default if unset or invalid
+func GetInt(key string, defaultValue int) int {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return defaultValue
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid value for %s (%q), using default %d", key, value, defaultValue)
+		return defaultValue
+	}
+
+	return parsed
+}
+
+// GetHTTPSURL returns an https URL environment variable without its trailing slash,
+// or the default if unset or not an absolute https URL
+func GetHTTPSURL(key, defaultValue string) string {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return defaultValue
+	}
+
+	parsed, err := url.Parse(value)
+	if err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+		log.Printf("Invalid value for %s (%q), expected an https URL, using default %s", key, value, defaultValue)
+		return defaultValue
+	}
+
+	return strings.TrimRight(value, "/")
+}
+
+// GetBool returns a boolean environment variable or the default if unset or invalid
+func GetBool(key string, defaultValue bool) bool {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return defaultValue
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Invalid value for %s (%q), using default %t", key, value, defaultValue)
+		return defaultValue
+	}
+
+	return parsed
+}
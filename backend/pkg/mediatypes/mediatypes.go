@@ -0,0 +1,51 @@
+package mediatypes
+
+import (
+	"all-me-backend/pkg/config"
+	"mime"
+	"slices"
+	"strings"
+)
+
+// DefaultFaceComparable are the image types the face service decodes out of the box
+var DefaultFaceComparable = []string{
+	"image/jpeg",
+	"image/jpg",
+	"image/png",
+	"image/gif",
+	"image/webp",
+	"image/bmp",
+}
+
+// FaceComparableFromEnv returns the MIME types accepted for face comparison, both for folder
+// images and base face uploads, from the comma-separated FACE_MIME_TYPES variable
+func FaceComparableFromEnv() []string {
+	value := config.GetString("FACE_MIME_TYPES", "")
+	if value == "" {
+		return DefaultFaceComparable
+	}
+
+	var types []string
+	for _, mimeType := range strings.Split(value, ",") {
+		if mimeType = normalize(mimeType); mimeType != "" && !slices.Contains(types, mimeType) {
+			types = append(types, mimeType)
+		}
+	}
+	if len(types) == 0 {
+		return DefaultFaceComparable
+	}
+
+	return types
+}
+
+// Contains reports whether mimeType is one of types, ignoring case and parameters such as charset
+func Contains(types []string, mimeType string) bool {
+	return slices.Contains(types, normalize(mimeType))
+}
+
+func normalize(mimeType string) string {
+	if mediaType, _, err := mime.ParseMediaType(mimeType); err == nil {
+		return mediaType
+	}
+	return strings.ToLower(strings.TrimSpace(mimeType))
+}
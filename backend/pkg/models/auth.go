@@ -1,68 +1,278 @@
 package models
 
 import (
+	"errors"
+	"slices"
+	"strings"
 	"time"
 )
 
 // Token represents an OAuth token for cloud storage providers
 type Token struct {
-	AccessToken string `json:"access_token"`
-	Provider    string `json:"provider"` // "onedrive" or "googledrive"
-	Scope       string `json:"scope,omitempty"`
+	AccessToken string    `json:"access_token"`
+	Provider    string    `json:"provider"` // "onedrive", "googledrive" or "dropbox"
+	Scope       string    `json:"scope,omitempty"`
+	ExpiresAt   time.Time `json:"expires_at,omitzero"` // Zero when the provider did not report an expiry
+	// RefreshToken obtains a new access token without the user signing in again, empty when none was issued
+	RefreshToken string `json:"refresh_token,omitempty"`
+	// AccountID and AccountName identify the signed-in account, empty for tokens stored before accounts were tracked
+	AccountID   string `json:"account_id,omitempty"`
+	AccountName string `json:"account_name,omitempty"`
 }
 
+// Account is a provider account signed in to a session
+type Account struct {
+	Provider    string `json:"provider"`
+	ID          string `json:"account_id"`
+	DisplayName string `json:"display_name,omitempty"`
+	Email       string `json:"email,omitempty"`
+}
+
+// TokenKey returns the key a session stores the account's token under
+// Tokens without an account ID are keyed by their provider alone
+func TokenKey(provider, accountID string) string {
+	if accountID == "" {
+		return provider
+	}
+	return provider + ":" + accountID
+}
+
+// TokenKeyProvider returns the provider of a token key
+func TokenKeyProvider(key string) string {
+	provider, _, _ := strings.Cut(key, ":")
+	return provider
+}
+
+// IsExpired checks if the access token has passed its expiry
+// Tokens without a known expiry are treated as valid
+func (t *Token) IsExpired() bool {
+	return !t.ExpiresAt.IsZero() && !time.Now().Before(t.ExpiresAt)
+}
+
+// ExpiresWithin checks if the access token expires within d, so it can be refreshed ahead of time
+// Tokens without a known expiry never do
+func (t *Token) ExpiresWithin(d time.Duration) bool {
+	return !t.ExpiresAt.IsZero() && time.Until(t.ExpiresAt) < d
+}
+
+// HasScope reports whether the provider granted the scope when the token was issued
+// Tokens whose grant was not reported are treated as having every requested scope
+// Microsoft may report scopes qualified with their resource, e.g. https://graph.microsoft.com/Files.ReadWrite.All
+func (t *Token) HasScope(scope string) bool {
+	if t.Scope == "" {
+		return true
+	}
+
+	for _, granted := range strings.Fields(t.Scope) {
+		if granted == scope || strings.HasSuffix(granted, "/"+scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// TokenRefreshWindow is how long before its expiry an access token is refreshed when a refresh token is available
+const TokenRefreshWindow = 5 * time.Minute
+
+// ScopeWrite stands for the provider's configured write scope when checking a session's scopes
+const ScopeWrite = "write"
+
 // OAuthConfig holds OAuth configuration for a specific provider
 type OAuthConfig struct {
 	ClientID     string   `json:"client_id"`
 	ClientSecret string   `json:"client_secret"`
 	RedirectURI  string   `json:"redirect_uri"`
 	Scopes       []string `json:"scopes"`
+	WriteScope   string   `json:"write_scope,omitempty"` // Empty when write features are disabled
 	AuthURL      string   `json:"auth_url"`
 	TokenURL     string   `json:"token_url"`
+	RevokeURL    string   `json:"revoke_url,omitempty"` // Empty when the provider cannot revoke tokens
 	Provider     string   `json:"provider"`
 }
 
 // UserSession represents a user's session with authentication tokens for multiple providers
 type UserSession struct {
-	SessionID    string            `json:"session_id"`
-	Tokens       map[string]*Token `json:"tokens"` // map of provider -> token
-	CreatedAt    time.Time         `json:"created_at"`
-	LastAccessed time.Time         `json:"last_accessed"`
+	SessionID     string            `json:"session_id"`
+	Tokens        map[string]*Token `json:"tokens"` // map of TokenKey -> token
+	CreatedAt     time.Time         `json:"created_at"`
+	LastAccessed  time.Time         `json:"last_accessed"`
+	RecentFolders []RecentFolder    `json:"recent_folders,omitempty"` // Most recent first
+}
+
+// RecentFolder is a folder share link a session opened, remembered so it can be offered again
+type RecentFolder struct {
+	Link     string    `json:"link"`
+	Provider string    `json:"provider"`
+	Name     string    `json:"name,omitempty"`
+	UsedAt   time.Time `json:"used_at"`
+}
+
+// AddRecentFolder moves the folder to the front of the session's recent folders, keeping at most limit
+// A folder recorded without a name keeps the name it was recorded with before
+func (s *UserSession) AddRecentFolder(folder RecentFolder, limit int) {
+	recent := []RecentFolder{folder}
+	for _, existing := range s.RecentFolders {
+		if existing.Link != folder.Link || existing.Provider != folder.Provider {
+			recent = append(recent, existing)
+		} else if recent[0].Name == "" {
+			recent[0].Name = existing.Name
+		}
+	}
+
+	if len(recent) > limit {
+		recent = recent[:limit]
+	}
+	s.RecentFolders = recent
 }
 
-// IsExpired checks if the session has expired (24-hour TTL)
-func (s *UserSession) IsExpired() bool {
-	return time.Since(s.LastAccessed) > 24*time.Hour
+// ClearRecentFolders forgets the recent folders opened with the provider's account
+func (s *UserSession) ClearRecentFolders(provider string) {
+	recent := s.RecentFolders[:0]
+	for _, folder := range s.RecentFolders {
+		if folder.Provider != provider {
+			recent = append(recent, folder)
+		}
+	}
+	s.RecentFolders = recent
+}
+
+// SessionTTL is how long a session stays valid after it was last accessed
+const SessionTTL = 24 * time.Hour
+
+// IsExpired checks if the session has expired at the given time
+func (s *UserSession) IsExpired(now time.Time) bool {
+	return now.Sub(s.LastAccessed) > SessionTTL
 }
 
 // UpdateLastAccessed updates the last accessed timestamp
-func (s *UserSession) UpdateLastAccessed() {
-	s.LastAccessed = time.Now()
+func (s *UserSession) UpdateLastAccessed(now time.Time) {
+	s.LastAccessed = now
 }
 
-// GetToken retrieves the token for a specific provider
-func (s *UserSession) GetToken(provider string) *Token {
+// GetToken retrieves the token stored under a TokenKey
+func (s *UserSession) GetToken(key string) *Token {
 	if s.Tokens == nil {
 		return nil
 	}
-	return s.Tokens[provider]
+	return s.Tokens[key]
 }
 
-// SetToken sets the token for a specific provider
+// SetToken sets the token for a provider, keyed by the token's account
 func (s *UserSession) SetToken(provider string, token *Token) {
 	if s.Tokens == nil {
 		s.Tokens = make(map[string]*Token)
 	}
-	s.Tokens[provider] = token
+	key := provider
+	if token != nil {
+		key = TokenKey(provider, token.AccountID)
+	}
+	s.Tokens[key] = token
+}
+
+// FindToken returns the token of the provider's account, or of its only account when accountID is empty
+// Several accounts of the provider without an accountID are reported as ErrAmbiguousAccount
+func (s *UserSession) FindToken(provider, accountID string) (*Token, error) {
+	if accountID != "" {
+		if token := s.GetToken(TokenKey(provider, accountID)); token != nil {
+			return token, nil
+		}
+		return nil, errors.New("no token found for account: " + accountID)
+	}
+
+	var found *Token
+	for _, key := range s.TokenKeys(provider) {
+		if found != nil {
+			return nil, ErrAmbiguousAccount
+		}
+		found = s.Tokens[key]
+	}
+	if found == nil {
+		return nil, errors.New("no token found for provider: " + provider)
+	}
+	return found, nil
+}
+
+// TokenKeys returns the sorted keys of the provider's tokens, one per signed-in account
+func (s *UserSession) TokenKeys(provider string) []string {
+	var keys []string
+	for key, token := range s.Tokens {
+		if token != nil && TokenKeyProvider(key) == provider {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// Providers returns the sorted providers with at least one account signed in
+func (s *UserSession) Providers() []string {
+	var providers []string
+	for key, token := range s.Tokens {
+		if provider := TokenKeyProvider(key); token != nil && !slices.Contains(providers, provider) {
+			providers = append(providers, provider)
+		}
+	}
+	slices.Sort(providers)
+	return providers
 }
 
-// HasTokenForProvider checks if a valid token exists for the provider
+// HasTokenForProvider checks if a token exists for any of the provider's accounts
 func (s *UserSession) HasTokenForProvider(provider string) bool {
-	token := s.GetToken(provider)
-	return token != nil && token.Provider == provider
+	return len(s.TokenKeys(provider)) > 0
+}
+
+var (
+	ErrNoProviderConnected = errors.New("no provider connected for this session")
+	ErrAmbiguousProvider   = errors.New("multiple providers connected for this session, provider must be specified")
+	ErrAmbiguousAccount    = errors.New("multiple accounts connected for this provider, account_id must be specified")
+	// ErrTokenExpired is returned for a session token past its expiry that could not be refreshed
+	ErrTokenExpired = errors.New("access token expired, sign in again")
+)
+
+// ErrorCodeTokenExpired is the machine-readable code of responses failing with ErrTokenExpired,
+// telling the frontend to start the provider's sign-in again
+const ErrorCodeTokenExpired = "token_expired"
+
+// TokenErrorCode returns the machine-readable code for a failed session token lookup, empty when there is none
+func TokenErrorCode(err error) string {
+	if errors.Is(err, ErrTokenExpired) {
+		return ErrorCodeTokenExpired
+	}
+	return ""
 }
 
 // SessionStore interface for retrieving sessions
 type SessionStore interface {
-	GetSessionToken(sessionID, provider string) (*Token, error)
+	// GetSessionToken returns the session's token for the provider's account, or an error; never nil without an error
+	// An empty accountID selects the provider's only account, see UserSession.FindToken
+	// A token past its expiry is reported as ErrTokenExpired
+	GetSessionToken(sessionID, provider, accountID string) (*Token, error)
+	GetSessionProviders(sessionID string) ([]string, error)
+	// RecordRecentFolder remembers a folder link the session opened successfully
+	RecordRecentFolder(sessionID string, folder RecentFolder) error
+	GetRecentFolders(sessionID string) ([]RecentFolder, error)
+	// HasScope reports whether the session's token for the provider's account was granted the scope, or ScopeWrite
+	HasScope(sessionID, provider, accountID, scope string) (bool, error)
+}
+
+// ResolveProvider returns the requested provider, or infers it from the session when omitted
+// Inference only succeeds when the session has exactly one connected provider
+func ResolveProvider(store SessionStore, sessionID, provider string) (string, error) {
+	if provider != "" {
+		return provider, nil
+	}
+
+	providers, err := store.GetSessionProviders(sessionID)
+	if err != nil {
+		return "", err
+	}
+
+	switch len(providers) {
+	case 0:
+		return "", ErrNoProviderConnected
+	case 1:
+		return providers[0], nil
+	default:
+		return "", ErrAmbiguousProvider
+	}
 }
@@ -0,0 +1,91 @@
+package models
+
+import (
+	"errors"
+	"net/http"
+)
+
+// ProviderErrorCode classifies a failed provider API call; it is the machine-readable code sent to the
+// frontend, which can show its own translation instead of the English message
+type ProviderErrorCode string
+
+const (
+	ProviderErrorNotFound    ProviderErrorCode = "provider_not_found"
+	ProviderErrorForbidden   ProviderErrorCode = "provider_forbidden"
+	ProviderErrorRateLimited ProviderErrorCode = "provider_rate_limited"
+	ProviderErrorQuota       ProviderErrorCode = "provider_quota_exceeded"
+	ProviderErrorAuthExpired ProviderErrorCode = "provider_auth_expired"
+	ProviderErrorFailed      ProviderErrorCode = "provider_failed"
+)
+
+// providerErrorMessages are the messages shown to users for each code
+// They never include provider detail, which can carry file IDs and URLs
+var providerErrorMessages = map[ProviderErrorCode]string{
+	ProviderErrorNotFound:    "the file or folder was not found, or is no longer shared",
+	ProviderErrorForbidden:   "you do not have permission to access this file or folder",
+	ProviderErrorRateLimited: "the storage provider is receiving too many requests, please try again in a moment",
+	ProviderErrorQuota:       "the storage provider's usage limit was reached, please try again later",
+	ProviderErrorAuthExpired: "your sign-in with the storage provider has expired, sign in again",
+	ProviderErrorFailed:      "the storage provider could not complete the request, please try again",
+}
+
+// ProviderError is a failed provider API call, reported to users by its code's message only
+// Providers log the raw error detail where they create it
+type ProviderError struct {
+	Provider   string
+	StatusCode int
+	Code       ProviderErrorCode
+}
+
+// NewProviderError creates the error of a failed call, classified by code, or by the HTTP status when code is empty
+func NewProviderError(provider string, statusCode int, code ProviderErrorCode) *ProviderError {
+	if code == "" {
+		code = ProviderErrorCodeForStatus(statusCode)
+	}
+	return &ProviderError{Provider: provider, StatusCode: statusCode, Code: code}
+}
+
+func (e *ProviderError) Error() string {
+	return providerErrorMessages[e.Code]
+}
+
+// HTTPStatus returns the status to respond with when a request fails with the error
+func (e *ProviderError) HTTPStatus() int {
+	switch e.Code {
+	case ProviderErrorNotFound:
+		return http.StatusNotFound
+	case ProviderErrorForbidden:
+		return http.StatusForbidden
+	case ProviderErrorRateLimited, ProviderErrorQuota:
+		return http.StatusTooManyRequests
+	case ProviderErrorAuthExpired:
+		return http.StatusUnauthorized
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// ProviderErrorCodeForStatus classifies a failed call by its HTTP status alone, for errors without a known reason
+func ProviderErrorCodeForStatus(statusCode int) ProviderErrorCode {
+	switch statusCode {
+	case http.StatusNotFound, http.StatusGone:
+		return ProviderErrorNotFound
+	case http.StatusForbidden:
+		return ProviderErrorForbidden
+	case http.StatusTooManyRequests:
+		return ProviderErrorRateLimited
+	case http.StatusUnauthorized:
+		return ProviderErrorAuthExpired
+	case http.StatusInsufficientStorage:
+		return ProviderErrorQuota
+	default:
+		return ProviderErrorFailed
+	}
+}
+
+// AsProviderError returns the provider error err wraps, if any
+func AsProviderError(err error) (*ProviderError, bool) {
+	var providerErr *ProviderError
+	ok := errors.As(err, &providerErr)
+	return providerErr, ok
+}
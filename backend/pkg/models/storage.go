@@ -1,24 +1,124 @@
 package models
 
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrRestrictedLocation is returned for folders in locations apps cannot read, such as OneDrive Personal Vault
+var ErrRestrictedLocation = errors.New("this folder is in a restricted location, such as Personal Vault, that apps cannot access; move the photos to a regular folder and share that instead")
+
 // CloudItem represents a file in cloud storage
 type CloudItem struct {
-	ID                          string   `json:"id"`
-	Name                        string   `json:"name"`
-	MimeType                    string   `json:"mime_type"`
-	IsFolder                    bool     `json:"is_folder"`
-	Provider                    string   `json:"provider"`                                 // "onedrive" or "googledrive"
-	DownloadURL                 string   `json:"download_url"`                             // Full resolution (for ZIP downloads)
-	FaceRecognitionOptimizedURL string   `json:"face_recognition_optimized_url,omitempty"` // 800px optimized for face recognition
-	ThumbnailURL                string   `json:"thumbnail_url,omitempty"`                  // 400px optimized for frontend display
-	MatchDistance               *float64 `json:"match_distance,omitempty"`                 // Face recognition match distance (0.0-1.0, lower is better)
-	ParentShareToken            string   `json:"-"`                                        // OneDrive share token for accessing subfolders (not sent to frontend)
-	ParentPath                  string   `json:"-"`                                        // Path from share root to this item (not sent to frontend)
-	DriveID                     string   `json:"-"`                                        // OneDrive drive ID for direct access (not sent to frontend)
+	ID                          string    `json:"id"`
+	Name                        string    `json:"name"`
+	MimeType                    string    `json:"mime_type"`
+	IsFolder                    bool      `json:"is_folder"`
+	Provider                    string    `json:"provider"`                                 // "onedrive", "googledrive" or "dropbox"
+	DownloadURL                 string    `json:"download_url"`                             // Full resolution (for ZIP downloads)
+	FaceRecognitionOptimizedURL string    `json:"face_recognition_optimized_url,omitempty"` // 800px optimized for face recognition
+	ThumbnailURL                string    `json:"thumbnail_url,omitempty"`                  // 400px optimized for frontend display
+	MatchDistance               *float64  `json:"match_distance,omitempty"`                 // Face recognition match distance (0.0-1.0, lower is better)
+	MatchConfidence             *float64  `json:"match_confidence,omitempty"`               // Match distance calibrated for the face model (0.0-1.0, higher is better)
+	MatchReference              *int      `json:"match_reference,omitempty"`                // Index of the reference image the match was closest to
+	ParentShareToken            string    `json:"-"`                                        // OneDrive share token for accessing subfolders (not sent to frontend)
+	ParentPath                  string    `json:"-"`                                        // Path from share root to this item (not sent to frontend)
+	DriveID                     string    `json:"drive_id,omitempty"`                       // OneDrive drive ID, needed to re-resolve the item server-side
+	ModifiedTime                time.Time `json:"modified_time,omitzero"`                   // Last modification time reported by the provider
+	Size                        int64     `json:"size"`                                     // Bytes; 0 for folders and for files the provider reports no size for
+
+	// Images extracted from a document, such as a PDF contact sheet, reference the document they came from
+	SourceDocument *CloudItem `json:"source_document,omitempty"`
+	SourceImage    int        `json:"source_image,omitempty"` // Position of the image in the document, from 1
+	SourcePage     int        `json:"source_page,omitempty"`  // Page of the document the image is shown on, from 1
 }
 
+// Stored returns the item as stored by the provider: the source document for images extracted from one
+func (c *CloudItem) Stored() *CloudItem {
+	if c.SourceDocument != nil {
+		return c.SourceDocument
+	}
+	return c
+}
+
+// ShareLinkKind is what a share link points to, judged by the shape of the URL alone
+type ShareLinkKind string
+
+const (
+	ShareLinkFolder       ShareLinkKind = "folder"
+	ShareLinkFile         ShareLinkKind = "file"
+	ShareLinkUnrecognized ShareLinkKind = "unrecognized"
+)
+
 // DownloadRequest represents a request to download files
 type DownloadRequest struct {
 	Files  []*CloudItem `json:"files"`
 	Token  *Token       `json:"token"`
 	Format string       `json:"format"` // "single" or "zip"
 }
+
+// DateRange limits listings to files modified within it; a zero bound leaves that side open
+// After is inclusive and Before exclusive
+type DateRange struct {
+	After  time.Time
+	Before time.Time
+}
+
+// IsZero reports whether the range is open on both sides and so filters nothing
+func (r DateRange) IsZero() bool {
+	return r.After.IsZero() && r.Before.IsZero()
+}
+
+// Contains reports whether a modification time falls within the range
+// Files without a known modification time are kept, as they may well belong to it
+func (r DateRange) Contains(modified time.Time) bool {
+	if modified.IsZero() {
+		return true
+	}
+	return (r.After.IsZero() || !modified.Before(r.After)) && (r.Before.IsZero() || modified.Before(r.Before))
+}
+
+// ParseDateRange parses the modified_after and modified_before values of a request
+// Each is an RFC 3339 timestamp or a YYYY-MM-DD date in UTC; a date as modified_before includes that whole day,
+// so the same date for both selects a single day
+func ParseDateRange(after, before string) (DateRange, error) {
+	var r DateRange
+	var err error
+
+	if r.After, _, err = parseRangeBound(after); err != nil {
+		return DateRange{}, fmt.Errorf("invalid modified_after: %w", err)
+	}
+
+	var dateOnly bool
+	if r.Before, dateOnly, err = parseRangeBound(before); err != nil {
+		return DateRange{}, fmt.Errorf("invalid modified_before: %w", err)
+	}
+	if dateOnly {
+		r.Before = r.Before.AddDate(0, 0, 1)
+	}
+
+	if !r.After.IsZero() && !r.Before.IsZero() && !r.After.Before(r.Before) {
+		return DateRange{}, errors.New("modified_after must be before modified_before")
+	}
+
+	return r, nil
+}
+
+// parseRangeBound parses one bound of a date range, reporting whether it was a date without a time
+func parseRangeBound(value string) (time.Time, bool, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, false, nil
+	}
+
+	if date, err := time.Parse(time.DateOnly, value); err == nil {
+		return date, true, nil
+	}
+	timestamp, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("%q is not a YYYY-MM-DD date or RFC 3339 timestamp", value)
+	}
+	return timestamp, false, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"all-me-backend/pkg/config"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Server defaults; the write timeout is generous because ZIP downloads are streamed in a single response
+const (
+	defaultServerAddr        = ":8080"
+	defaultReadHeaderTimeout = 10   // seconds
+	defaultReadTimeout       = 300  // seconds
+	defaultWriteTimeout      = 3600 // seconds
+	defaultIdleTimeout       = 120  // seconds
+)
+
+// newServer builds the HTTP server with timeouts from the environment, so slow clients cannot hold connections open
+func newServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              config.GetString("SERVER_ADDR", defaultServerAddr),
+		Handler:           handler,
+		ReadHeaderTimeout: timeoutFromEnv("SERVER_READ_HEADER_TIMEOUT_SECONDS", defaultReadHeaderTimeout),
+		ReadTimeout:       timeoutFromEnv("SERVER_READ_TIMEOUT_SECONDS", defaultReadTimeout),
+		WriteTimeout:      timeoutFromEnv("SERVER_WRITE_TIMEOUT_SECONDS", defaultWriteTimeout),
+		IdleTimeout:       timeoutFromEnv("SERVER_IDLE_TIMEOUT_SECONDS", defaultIdleTimeout),
+	}
+}
+
+// timeoutFromEnv reads a timeout in seconds, where 0 disables it
+func timeoutFromEnv(key string, defaultSeconds int) time.Duration {
+	seconds := config.GetInt(key, defaultSeconds)
+	if seconds < 0 {
+		log.Printf("%s must not be negative, using default %d", key, defaultSeconds)
+		seconds = defaultSeconds
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// tlsFilesFromEnv returns the certificate and key for built-in HTTPS, both empty when TLS is terminated elsewhere
+func tlsFilesFromEnv() (string, string, error) {
+	certFile := config.GetString("TLS_CERT_FILE", "")
+	keyFile := config.GetString("TLS_KEY_FILE", "")
+	if (certFile == "") != (keyFile == "") {
+		return "", "", errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	return certFile, keyFile, nil
+}
+
+// serve accepts connections on listener, over HTTPS when a certificate and key are given
+func serve(server *http.Server, listener net.Listener, certFile, keyFile string) error {
+	if certFile != "" {
+		return server.ServeTLS(listener, certFile, keyFile)
+	}
+	return server.Serve(listener)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewServer_ConfiguredTimeouts(t *testing.T) {
+	t.Setenv("SERVER_ADDR", "127.0.0.1:9090")
+	t.Setenv("SERVER_READ_HEADER_TIMEOUT_SECONDS", "5")
+	t.Setenv("SERVER_WRITE_TIMEOUT_SECONDS", "0")
+	t.Setenv("SERVER_IDLE_TIMEOUT_SECONDS", "-1")
+
+	server := newServer(http.NotFoundHandler())
+
+	if server.Addr != "127.0.0.1:9090" {
+		t.Errorf("Expected address 127.0.0.1:9090, got %s", server.Addr)
+	}
+	if server.ReadHeaderTimeout != 5*time.Second {
+		t.Errorf("Expected read header timeout 5s, got %v", server.ReadHeaderTimeout)
+	}
+	if server.ReadTimeout != defaultReadTimeout*time.Second {
+		t.Errorf("Expected default read timeout, got %v", server.ReadTimeout)
+	}
+	if server.WriteTimeout != 0 {
+		t.Errorf("Expected write timeout to be disabled, got %v", server.WriteTimeout)
+	}
+	if server.IdleTimeout != defaultIdleTimeout*time.Second {
+		t.Errorf("Expected negative idle timeout to fall back to the default, got %v", server.IdleTimeout)
+	}
+}
+
+func TestTLSFilesFromEnv_RequiresBoth(t *testing.T) {
+	t.Setenv("TLS_CERT_FILE", "cert.pem")
+	t.Setenv("TLS_KEY_FILE", "")
+
+	if _, _, err := tlsFilesFromEnv(); err == nil {
+		t.Error("Expected error when only the certificate is set")
+	}
+}
+
+func TestServe_HTTPS(t *testing.T) {
+	certFile, keyFile := writeSelfSignedCert(t)
+
+	server := newServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go serve(server, listener, certFile, keyFile)
+	t.Cleanup(func() { server.Close() })
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	resp, err := client.Get("https://" + listener.Addr().String())
+	if err != nil {
+		t.Fatalf("HTTPS request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.TLS == nil || string(body) != "ok" {
+		t.Errorf("Expected an HTTPS response with body ok, got TLS=%v body %q", resp.TLS != nil, body)
+	}
+}
+
+// writeSelfSignedCert writes a certificate and key for 127.0.0.1 to temporary files
+func writeSelfSignedCert(t *testing.T) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0o600); err != nil {
+		t.Fatalf("Failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+
+	return certFile, keyFile
+}
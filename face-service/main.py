@@ -1,7 +1,7 @@
-from fastapi import FastAPI, HTTPException, BackgroundTasks
+from fastapi import FastAPI, HTTPException, BackgroundTasks, File, Form, UploadFile
 from pydantic import BaseModel
 import logging
-from typing import Dict, Optional, List
+from typing import Dict, Optional, List, Union
 import numpy as np
 import face_recognition
 import base64
@@ -11,13 +11,19 @@ import uuid
 from datetime import datetime, timedelta
 import asyncio
 import threading
+import os
+import json
 
 logging.basicConfig(level=logging.INFO)
 logger = logging.getLogger(__name__)
 
+MAX_REFERENCE_IMAGES = 5
+
+ENCODING_SIZE = 128
+
 class SessionData:
-    def __init__(self, encoding: np.ndarray):
-        self.encoding = encoding
+    def __init__(self, encodings: List[np.ndarray]):
+        self.encodings = encodings
         self.created_at = datetime.now()
         self.last_accessed = datetime.now()
 
@@ -27,14 +33,31 @@ class SessionStore:
         self.session_ttl = timedelta(hours=24)
         self._start_cleanup_task()
     
-    def store(self, session_id: str, encoding: np.ndarray) -> None:
-        self.sessions[session_id] = SessionData(encoding)
+    def store(self, session_id: str, encoding: np.ndarray) -> int:
+        self.sessions[session_id] = SessionData([encoding])
+        return 1
     
-    def retrieve(self, session_id: str) -> Optional[np.ndarray]:
+    def replace(self, session_id: str, encodings: List[np.ndarray]) -> int:
+        """Replace a session's reference encodings, e.g. with ones saved from an earlier session"""
+        self.sessions[session_id] = SessionData(encodings)
+        return len(encodings)
+    
+    def append(self, session_id: str, encoding: np.ndarray) -> int:
+        """Add a reference encoding to a session, returning how many it has"""
+        session_data = self.sessions.get(session_id)
+        if session_data is None:
+            return self.store(session_id, encoding)
+        if len(session_data.encodings) >= MAX_REFERENCE_IMAGES:
+            raise ValueError(f"Too many reference images, at most {MAX_REFERENCE_IMAGES} can be registered")
+        session_data.encodings.append(encoding)
+        session_data.last_accessed = datetime.now()
+        return len(session_data.encodings)
+    
+    def retrieve(self, session_id: str) -> Optional[List[np.ndarray]]:
         session_data = self.sessions.get(session_id)
         if session_data:
             session_data.last_accessed = datetime.now()
-            return session_data.encoding
+            return session_data.encodings
         return None
     
     def delete(self, session_id: str) -> bool:
@@ -72,9 +95,16 @@ class SessionStore:
         cleanup_thread.start()
 
 class MatchResult:
-    def __init__(self, index: int, distance: float):
+    def __init__(self, index: int, distance: float, confidence: float, reference: Optional[int] = None):
         self.index = index
         self.distance = distance
+        self.confidence = confidence
+        self.reference = reference
+
+class ImageError:
+    def __init__(self, index: int, error: str):
+        self.index = index
+        self.error = error
 
 class JobStatus:
     def __init__(self, job_id: str, total_images: int):
@@ -84,7 +114,10 @@ class JobStatus:
         self.current_image = 0
         self.total_images = total_images
         self.matches_found = 0
+        self.images_with_faces = 0
         self.matches: List[MatchResult] = []
+        self.face_indices: List[int] = []
+        self.image_errors: List[ImageError] = []
         self.message = "Starting processing..."
         self.error: Optional[str] = None
         self.created_at = datetime.now()
@@ -101,21 +134,25 @@ class JobStore:
     def get_job(self, job_id: str) -> Optional[JobStatus]:
         return self.jobs.get(job_id)
     
-    def update_progress(self, job_id: str, current: int, matches_found: int):
+    def update_progress(self, job_id: str, current: int, matches_found: int, images_with_faces: int):
         job = self.jobs.get(job_id)
         if job:
             job.current_image = current
             job.matches_found = matches_found
+            job.images_with_faces = images_with_faces
             job.progress = int((current / job.total_images) * 100) if job.total_images > 0 else 0
             job.message = f"Processing image {current} of {job.total_images}"
     
-    def complete_job(self, job_id: str, matches: List[MatchResult]):
+    def complete_job(self, job_id: str, matches: List[MatchResult], image_errors: List[ImageError], face_indices: List[int]):
         job = self.jobs.get(job_id)
         if job:
             job.status = "completed"
             job.progress = 100
             job.matches = matches
+            job.image_errors = image_errors
+            job.face_indices = face_indices
             job.matches_found = len(matches)
+            job.images_with_faces = len(face_indices)
             job.message = f"Completed! Found {len(matches)} matches"
     
     def fail_job(self, job_id: str, error: str):
@@ -154,16 +191,86 @@ async def health_check():
 class RegisterRequest(BaseModel):
     session_id: str
     image: str  # base64 encoded image
+    append: bool = False  # add to the session's reference images instead of replacing them
+    model: str = "small"  # landmark model faces are aligned with before encoding, "small" or "large"
 
 class RegisterResponse(BaseModel):
     success: bool
+    reference_count: int  # reference images registered for the session
+
+class SessionEncodings(BaseModel):
+    encodings: List[List[float]]  # reference face encodings, one per reference image
 
 class ErrorResponse(BaseModel):
     error: str
 
+DEFAULT_MATCH_THRESHOLD = 0.7
+
+# Landmark models face_recognition can align faces with; encodings from different models are not comparable
+FACE_MODELS = ("small", "large")
+
+# Per model calibration: the default match threshold, and a curve of (distance, confidence) points
+# that distances are mapped to confidences by, interpolating linearly between points
+# These are rough defaults; FACE_CALIBRATION_FILE can point to a JSON file with values measured on real photos,
+# e.g. {"large": {"threshold": 0.65, "curve": [[0.0, 1.0], [0.45, 0.9], [0.65, 0.5], [0.8, 0.0]]}}
+DEFAULT_CALIBRATION = {
+    "small": {"threshold": DEFAULT_MATCH_THRESHOLD, "curve": [[0.0, 1.0], [0.4, 0.95], [0.6, 0.5], [0.8, 0.0]]},
+    "large": {"threshold": DEFAULT_MATCH_THRESHOLD, "curve": [[0.0, 1.0], [0.4, 0.95], [0.6, 0.5], [0.8, 0.0]]},
+}
+
+def validate_calibration(model: str, calibration: dict):
+    """Raise ValueError unless a model's calibration has a threshold and an increasing curve of confidences in [0, 1]"""
+    threshold = calibration.get("threshold")
+    if not isinstance(threshold, (int, float)) or not 0 < threshold <= 1:
+        raise ValueError(f"{model}: threshold must be a number in (0, 1]")
+    
+    curve = calibration.get("curve")
+    if not isinstance(curve, list) or len(curve) < 2 or any(not isinstance(point, list) or len(point) != 2 for point in curve):
+        raise ValueError(f"{model}: curve must be a list of at least two [distance, confidence] points")
+    
+    distances = [point[0] for point in curve]
+    if any(b <= a for a, b in zip(distances, distances[1:])):
+        raise ValueError(f"{model}: curve distances must be increasing")
+    if any(not 0 <= point[1] <= 1 for point in curve):
+        raise ValueError(f"{model}: curve confidences must be between 0 and 1")
+
+def load_calibration() -> Dict[str, dict]:
+    """Load the calibration of each model, overriding the defaults with FACE_CALIBRATION_FILE if it is set"""
+    calibration = {model: dict(values) for model, values in DEFAULT_CALIBRATION.items()}
+    
+    path = os.environ.get("FACE_CALIBRATION_FILE")
+    if not path:
+        return calibration
+    
+    try:
+        with open(path) as file:
+            overrides = json.load(file)
+        for model, values in overrides.items():
+            if model not in FACE_MODELS:
+                raise ValueError(f"unknown model {model}")
+            merged = {**calibration[model], **values}
+            validate_calibration(model, merged)
+            calibration[model] = merged
+    except (OSError, ValueError, AttributeError, TypeError) as e:
+        logger.error(f"Ignoring calibration file {path}: {e}")
+        return {model: dict(values) for model, values in DEFAULT_CALIBRATION.items()}
+    
+    logger.info(f"Loaded face calibration from {path}")
+    return calibration
+
+CALIBRATION = load_calibration()
+
+def match_confidence(model: str, distance: float) -> float:
+    """Map a match distance to a confidence between 0 and 1 along the model's calibration curve"""
+    curve = CALIBRATION[model]["curve"]
+    return float(np.interp(distance, [point[0] for point in curve], [point[1] for point in curve]))
+
 class CompareBatchRequest(BaseModel):
     session_id: str
     images: List[str]  # list of base64 encoded images
+    threshold: Optional[float] = None  # maximum match distance, defaults to the model's calibrated threshold
+    aggregation: str = "any"  # "any" matches faces close to any reference, "mean" to the mean reference encoding
+    model: str = "small"  # must be the model the session's reference faces were encoded with
 
 class CompareBatchResponse(BaseModel):
     job_id: str
@@ -172,6 +279,12 @@ class CompareBatchResponse(BaseModel):
 class MatchResultModel(BaseModel):
     index: int
     distance: float
+    confidence: float  # distance mapped along the model's calibration curve, 1 being certain
+    reference: Optional[int] = None  # closest reference image, only set with the "any" aggregation
+
+class ImageErrorModel(BaseModel):
+    index: int
+    error: str
 
 class JobStatusResponse(BaseModel):
     job_id: str
@@ -180,14 +293,20 @@ class JobStatusResponse(BaseModel):
     current_image: int
     total_images: int
     matches_found: int
+    images_with_faces: int = 0  # images in which at least one face was detected
     message: str
     matches: Optional[List[MatchResultModel]] = None
+    image_errors: Optional[List[ImageErrorModel]] = None  # images in a completed batch that could not be processed
+    face_indices: Optional[List[int]] = None  # indices of the images counted in images_with_faces, once completed
     error: Optional[str] = None
 
 @app.post("/face/register", response_model=RegisterResponse)
 async def register_face(request: RegisterRequest):
     """Register a base face for a session"""
     try:
+        if request.model not in FACE_MODELS:
+            raise HTTPException(status_code=400, detail=f"Unknown model: {request.model}")
+        
         # Decode base64 image
         try:
             image_data = base64.b64decode(request.image)
@@ -214,15 +333,21 @@ async def register_face(request: RegisterRequest):
             raise HTTPException(status_code=400, detail="Multiple faces detected, please use image with single face")
         
         # Extract face encoding
-        face_encodings = face_recognition.face_encodings(image_array, face_locations)
+        face_encodings = face_recognition.face_encodings(image_array, face_locations, model=request.model)
         
         if len(face_encodings) == 0:
             raise HTTPException(status_code=500, detail="Failed to extract face encoding")
         
         face_encoding = face_encodings[0]
         
-        session_store.store(request.session_id, face_encoding)
-        return RegisterResponse(success=True)
+        if request.append:
+            try:
+                reference_count = session_store.append(request.session_id, face_encoding)
+            except ValueError as e:
+                raise HTTPException(status_code=400, detail=str(e))
+        else:
+            reference_count = session_store.store(request.session_id, face_encoding)
+        return RegisterResponse(success=True, reference_count=reference_count)
         
     except HTTPException:
         raise
@@ -230,20 +355,30 @@ async def register_face(request: RegisterRequest):
         logger.error(f"Unexpected error in register_face: {e}")
         raise HTTPException(status_code=500, detail="Internal server error")
 
-def process_batch_background(job_id: str, session_id: str, images: List[str]):
-    """Background task to process images"""
+def process_batch_background(job_id: str, session_id: str, images: List[Union[str, bytes]], threshold: float = DEFAULT_MATCH_THRESHOLD, aggregation: str = "any", model: str = "small"):
+    """Background task to process images, given as base64 strings or raw bytes"""
     try:
-        base_encoding = session_store.retrieve(session_id)
-        if base_encoding is None:
+        reference_encodings = session_store.retrieve(session_id)
+        if reference_encodings is None:
             job_store.fail_job(job_id, "Session not found")
             return
         
+        if aggregation == "mean":
+            reference_encodings = [np.mean(reference_encodings, axis=0)]
+        
         matches = []
+        image_errors = []
+        face_indices = []
         total_images = len(images)
         
-        for idx, image_base64 in enumerate(images):
+        for idx, image in enumerate(images):
+            # A deleted job was cancelled by the backend, so stop working on it
+            if job_store.get_job(job_id) is None:
+                logger.info(f"Job {job_id} was cancelled after {idx} of {total_images} images")
+                return
+            
             try:
-                image_data = base64.b64decode(image_base64)
+                image_data = image if isinstance(image, bytes) else base64.b64decode(image)
                 image = Image.open(BytesIO(image_data))
                 if image.mode != 'RGB':
                     image = image.convert('RGB')
@@ -252,52 +387,71 @@ def process_batch_background(job_id: str, session_id: str, images: List[str]):
                 face_locations = face_recognition.face_locations(image_array)
                 
                 if len(face_locations) > 0:
-                    face_encodings = face_recognition.face_encodings(image_array, face_locations)
+                    face_indices.append(idx)
+                    face_encodings = face_recognition.face_encodings(image_array, face_locations, model=model)
                     
-                    # Compare all faces in the image and keep the best match
+                    # Compare all faces in the image with every reference and keep the best match
                     best_distance = float('inf')
+                    best_reference = None
                     
                     for face_encoding in face_encodings:
-                        # Calculate face distance
-                        distances = face_recognition.face_distance([base_encoding], face_encoding)
-                        distance = distances[0]
+                        # Calculate face distance to each reference
+                        distances = face_recognition.face_distance(reference_encodings, face_encoding)
+                        reference = int(np.argmin(distances))
+                        distance = distances[reference]
                         
-                        # Use 0.7 as the maximum threshold and track the best matching distance
-                        if distance <= 0.7 and distance < best_distance:
+                        # Only keep distances within the threshold and track the best matching distance
+                        if distance <= threshold and distance < best_distance:
                             best_distance = distance
+                            best_reference = reference
                     
                     # If any face matched, add the image with the best distance
-                    if best_distance <= 0.7:
-                        matches.append(MatchResult(idx, float(best_distance)))
+                    if best_distance <= threshold:
+                        if aggregation == "mean":
+                            best_reference = None
+                        matches.append(MatchResult(idx, float(best_distance), match_confidence(model, best_distance), best_reference))
                 
-                job_store.update_progress(job_id, idx + 1, len(matches))
+                job_store.update_progress(job_id, idx + 1, len(matches), len(face_indices))
                         
             except Exception as e:
                 logger.warning(f"Failed to process image at index {idx} for job {job_id}: {e}")
+                image_errors.append(ImageError(idx, str(e)))
+                job_store.update_progress(job_id, idx + 1, len(matches), len(face_indices))
                 continue
         
-        job_store.complete_job(job_id, matches)
+        job_store.complete_job(job_id, matches, image_errors, face_indices)
         
     except Exception as e:
         logger.error(f"Unexpected error in background processing for job {job_id}: {e}")
         job_store.fail_job(job_id, str(e))
 
+def start_batch_job(background_tasks: BackgroundTasks, session_id: str, images: List[Union[str, bytes]], threshold: Optional[float], aggregation: str, model: str) -> CompareBatchResponse:
+    """Validate a batch comparison request and start its background job"""
+    reference_encodings = session_store.retrieve(session_id)
+    if reference_encodings is None:
+        raise HTTPException(status_code=404, detail="Session not found")
+    
+    if aggregation not in ("any", "mean"):
+        raise HTTPException(status_code=400, detail=f"Unknown aggregation: {aggregation}")
+    
+    if model not in FACE_MODELS:
+        raise HTTPException(status_code=400, detail=f"Unknown model: {model}")
+    
+    job_id = job_store.create_job(len(images))
+    
+    threshold = threshold if threshold else CALIBRATION[model]["threshold"]
+    background_tasks.add_task(process_batch_background, job_id, session_id, images, threshold, aggregation, model)
+    
+    return CompareBatchResponse(
+        job_id=job_id,
+        status="processing"
+    )
+
 @app.post("/face/compare-batch", response_model=CompareBatchResponse)
 async def compare_batch(request: CompareBatchRequest, background_tasks: BackgroundTasks):
-    """Start a batch comparison job"""
+    """Start a batch comparison job with base64 encoded images"""
     try:
-        base_encoding = session_store.retrieve(request.session_id)
-        if base_encoding is None:
-            raise HTTPException(status_code=404, detail="Session not found")
-        
-        job_id = job_store.create_job(len(request.images))
-        
-        background_tasks.add_task(process_batch_background, job_id, request.session_id, request.images)
-        
-        return CompareBatchResponse(
-            job_id=job_id,
-            status="processing"
-        )
+        return start_batch_job(background_tasks, request.session_id, request.images, request.threshold, request.aggregation, request.model)
         
     except HTTPException:
         raise
@@ -305,6 +459,26 @@ async def compare_batch(request: CompareBatchRequest, background_tasks: Backgrou
         logger.error(f"Unexpected error in compare_batch: {e}")
         raise HTTPException(status_code=500, detail="Internal server error")
 
+@app.post("/face/compare-batch-multipart", response_model=CompareBatchResponse)
+async def compare_batch_multipart(
+    background_tasks: BackgroundTasks,
+    session_id: str = Form(...),
+    threshold: Optional[float] = Form(None),
+    aggregation: str = Form("any"),
+    model: str = Form("small"),
+    images: List[UploadFile] = File(...),
+):
+    """Start a batch comparison job with images sent as binary multipart parts, in order"""
+    try:
+        image_data = [await upload.read() for upload in images]
+        return start_batch_job(background_tasks, session_id, image_data, threshold, aggregation, model)
+        
+    except HTTPException:
+        raise
+    except Exception as e:
+        logger.error(f"Unexpected error in compare_batch_multipart: {e}")
+        raise HTTPException(status_code=500, detail="Internal server error")
+
 @app.get("/face/job-status/{job_id}", response_model=JobStatusResponse)
 async def get_job_status(job_id: str):
     """Get the status of a comparison job"""
@@ -317,7 +491,15 @@ async def get_job_status(job_id: str):
         # Convert MatchResult objects to MatchResultModel for the response
         matches_data = None
         if job.status == "completed" and job.matches:
-            matches_data = [MatchResultModel(index=m.index, distance=m.distance) for m in job.matches]
+            matches_data = [MatchResultModel(index=m.index, distance=m.distance, confidence=m.confidence, reference=m.reference) for m in job.matches]
+        
+        image_errors_data = None
+        if job.status == "completed" and job.image_errors:
+            image_errors_data = [ImageErrorModel(index=e.index, error=e.error) for e in job.image_errors]
+        
+        face_indices_data = None
+        if job.status == "completed":
+            face_indices_data = job.face_indices
         
         return JobStatusResponse(
             job_id=job.job_id,
@@ -326,8 +508,11 @@ async def get_job_status(job_id: str):
             current_image=job.current_image,
             total_images=job.total_images,
             matches_found=job.matches_found,
+            images_with_faces=job.images_with_faces,
             message=job.message,
             matches=matches_data,
+            image_errors=image_errors_data,
+            face_indices=face_indices_data,
             error=job.error
         )
         
@@ -337,6 +522,38 @@ async def get_job_status(job_id: str):
         logger.error(f"Unexpected error in get_job_status: {e}")
         raise HTTPException(status_code=500, detail="Internal server error")
 
+@app.delete("/face/job/{job_id}")
+async def cancel_job(job_id: str):
+    """Cancel a comparison job, stopping its processing and discarding its results"""
+    if not job_store.delete_job(job_id):
+        raise HTTPException(status_code=404, detail="Job not found")
+    
+    return {"success": True}
+
+@app.get("/face/session/{session_id}/encodings", response_model=SessionEncodings)
+async def get_session_encodings(session_id: str):
+    """Export a session's reference encodings so they can be saved and restored later"""
+    reference_encodings = session_store.retrieve(session_id)
+    if reference_encodings is None:
+        raise HTTPException(status_code=404, detail="Session not found")
+    
+    return SessionEncodings(encodings=[encoding.tolist() for encoding in reference_encodings])
+
+@app.post("/face/session/{session_id}/encodings", response_model=RegisterResponse)
+async def restore_session_encodings(session_id: str, request: SessionEncodings):
+    """Restore previously exported reference encodings into a session, replacing its references"""
+    if not request.encodings:
+        raise HTTPException(status_code=400, detail="No reference encodings provided")
+    
+    if len(request.encodings) > MAX_REFERENCE_IMAGES:
+        raise HTTPException(status_code=400, detail=f"Too many reference images, at most {MAX_REFERENCE_IMAGES} can be registered")
+    
+    if any(len(encoding) != ENCODING_SIZE for encoding in request.encodings):
+        raise HTTPException(status_code=400, detail=f"Reference encodings must have {ENCODING_SIZE} values")
+    
+    reference_count = session_store.replace(session_id, [np.array(encoding) for encoding in request.encodings])
+    return RegisterResponse(success=True, reference_count=reference_count)
+
 @app.delete("/face/session/{session_id}")
 async def delete_session(session_id: str):
     try:
@@ -46,6 +46,7 @@ export class ResultsComponent implements OnInit {
   isDownloading: boolean = false;
   totalImages: number = 0;
   totalMatches: number = 0;
+  noMatchesMessage: string = '';      // Guidance from the backend when a search found nothing
   gridCols: number = 4;
 
   readonly STRONG_MATCH_THRESHOLD = 0.5;  // Distance < 0.5 = strong match
@@ -66,6 +67,7 @@ export class ResultsComponent implements OnInit {
       this.provider = state.provider;
       this.totalImages = state.totalImages || 0;
       this.totalMatches = state.totalMatches || this.matches.length;
+      this.noMatchesMessage = state.noMatchesMessage || '';
       
       // Separate matches into strong and weak based on distance
       // Strong: distance < 0.5, Weak: distance >= 0.5
@@ -251,7 +253,7 @@ export class ResultsComponent implements OnInit {
     this.isDownloading = true;
     this.errorMessage = '';
 
-    this.downloadService.downloadZip(files, sessionId, this.provider).subscribe({
+    this.downloadService.downloadZip(files, sessionId).subscribe({
       next: (blob) => {
         this.downloadService.triggerDownload(blob, `allme-photos-${Date.now()}.zip`);
         this.isDownloading = false;
@@ -13,7 +13,6 @@ import { MatSnackBarModule } from '@angular/material/snack-bar';
 import { MatProgressSpinnerModule } from '@angular/material/progress-spinner';
 import { MatPaginatorModule } from '@angular/material/paginator';
 import { FormsModule } from '@angular/forms';
-import { HttpClient } from '@angular/common/http';
 import { BreakpointObserver, Breakpoints } from '@angular/cdk/layout';
 import { CloudItem } from '../../models/auth.model';
 import { FaceService } from '../../services/face.service';
@@ -50,7 +49,6 @@ export class SearchComponent implements OnInit, OnDestroy {
   private readonly authService = inject(AuthService);
   private readonly breakpointObserver = inject(BreakpointObserver);
   private readonly notificationService = inject(NotificationService);
-  private readonly http = inject(HttpClient);
 
   provider: 'onedrive' | 'googledrive' = 'onedrive';
   contents: CloudItem[] = [];
@@ -72,6 +70,7 @@ export class SearchComponent implements OnInit, OnDestroy {
   totalImages: number = 0;
   matchesFound: number = 0;
   matchingMessage: string = '';
+  matchingJobId: string | null = null;
   gridCols: number = 4;
   recursiveSearch: boolean = false;
 
@@ -288,6 +287,7 @@ export class SearchComponent implements OnInit, OnDestroy {
     this.faceService.compareFolder(sessionId, this.folderLink, this.provider, this.recursiveSearch).subscribe({
       next: (response) => {
         const jobId = response.job_id;
+        this.matchingJobId = jobId;
 
         this.faceService.pollJobStatus(jobId).subscribe({
           next: (status) => {
@@ -297,19 +297,22 @@ export class SearchComponent implements OnInit, OnDestroy {
             this.matchesFound = status.matches_found;
             this.matchingMessage = status.message;
 
-            if (status.status === 'completed' && status.matches) {
+            if (status.status === 'completed' && (status.matches || status.no_matches)) {
               this.isMatching = false;
               this.router.navigate(['/results'], {
                 state: {
-                  matches: status.matches,
+                  matches: status.matches ?? [],
                   provider: this.provider,
                   totalImages: status.total_images,
-                  totalMatches: status.matches_found
+                  totalMatches: status.matches_found,
+                  noMatchesMessage: status.no_matches ? status.message : ''
                 }
               });
             } else if (status.status === 'failed') {
               this.isMatching = false;
               this.errorMessage = status.error || 'Face matching failed. Please try again.';
+            } else if (status.status === 'cancelled') {
+              this.isMatching = false;
             }
           },
           error: (error) => {
@@ -320,11 +323,30 @@ export class SearchComponent implements OnInit, OnDestroy {
       },
       error: (error) => {
         this.isMatching = false;
+        if (error.error?.code === 'token_expired') {
+          // The provider's sign-in expired, so sign in again rather than showing a generic failure
+          window.location.href = this.authService.initiateOAuth(this.provider);
+          return;
+        }
         this.errorMessage = error.message || 'Failed to start face matching. Please try again.';
       }
     });
   }
 
+  cancelMatching(): void {
+    const sessionId = this.authService.getSessionId();
+    if (!sessionId || !this.matchingJobId) {
+      return;
+    }
+
+    this.matchingMessage = 'Cancelling...';
+    this.faceService.cancelJob(sessionId, this.matchingJobId).subscribe({
+      error: (error) => {
+        this.errorMessage = error.error?.error || 'Failed to cancel face matching.';
+      }
+    });
+  }
+
   onReferenceSourceChange(): void {
     // Clear error and upload status when switching source
     this.errorMessage = '';
@@ -373,61 +395,33 @@ export class SearchComponent implements OnInit, OnDestroy {
     this.errorMessage = '';
     this.uploadComplete = false;
 
-    // Download the image from cloud storage using the download URL
-    this.http.get(item.download_url, { responseType: 'blob' }).subscribe({
-      next: (blob) => {
-        // Convert blob to file
-        const file = new File([blob], item.name, { type: item.mime_type });
-
-        // Upload to face service
-        this.faceService.registerBaseFace(sessionId, file).subscribe({
-          next: (response) => {
-            this.isUploading = false;
-            this.uploadComplete = response.success;
-
-            // Only update preview and selection after successful validation
-            this.selectedCloudImage = item;
-            // Use thumbnail for display (400px optimized)
-            this.referenceImageUrl = item.thumbnail_url || item.download_url;
-            this.referenceImageProvider = item.provider;
-
-            // Exit selection mode on success
-            this.isSelectingFromFolder = false;
-
-            this.notificationService.showSuccess(`✓ Reference image ready: ${item.name}`);
-
-            // Scroll to upload section after everything is loaded and ready
-            setTimeout(() => {
-              this.scrollToUploadSection();
-            }, 100);
-          },
-          error: (error) => {
-            this.isUploading = false;
-            this.uploadComplete = false;
+    // The backend downloads the image from the provider itself, so its download URL is never needed here
+    this.faceService.registerFromFolderItem(sessionId, item).subscribe({
+      next: (response) => {
+        this.isUploading = false;
+        this.uploadComplete = response.success;
 
-            // Keep previous selection on error - don't change anything
-            this.selectedCloudImage = previousCloudImage;
-            this.referenceImageUrl = previousReferenceUrl;
-            this.referenceImageProvider = previousProvider;
+        // Only update preview and selection after successful validation
+        this.selectedCloudImage = item;
+        // Use thumbnail for display (400px optimized)
+        this.referenceImageUrl = item.thumbnail_url || item.download_url;
+        this.referenceImageProvider = item.provider;
 
-            // Stay in selection mode so user can try another image
-            this.isSelectingFromFolder = true;
+        // Exit selection mode on success
+        this.isSelectingFromFolder = false;
 
-            this.errorMessage = error.message || 'Failed to upload reference image. Please try again.';
-            this.notificationService.showError(this.errorMessage);
+        this.notificationService.showSuccess(`✓ Reference image ready: ${item.name}`);
 
-            // Scroll to upload section to show error message
-            setTimeout(() => {
-              this.scrollToUploadSection();
-            }, 100);
-          }
-        });
+        // Scroll to upload section after everything is loaded and ready
+        setTimeout(() => {
+          this.scrollToUploadSection();
+        }, 100);
       },
-      error: () => {
+      error: (error) => {
         this.isUploading = false;
         this.uploadComplete = false;
 
-        // Keep previous selection on error
+        // Keep previous selection on error - don't change anything
         this.selectedCloudImage = previousCloudImage;
         this.referenceImageUrl = previousReferenceUrl;
         this.referenceImageProvider = previousProvider;
@@ -435,7 +429,7 @@ export class SearchComponent implements OnInit, OnDestroy {
         // Stay in selection mode so user can try another image
         this.isSelectingFromFolder = true;
 
-        this.errorMessage = 'Failed to download image from cloud storage';
+        this.errorMessage = error.error?.error || error.message || 'Failed to use this image as reference. Please try again.';
         this.notificationService.showError(this.errorMessage);
 
         // Scroll to upload section to show error message
@@ -445,6 +439,8 @@ export class SearchComponent implements OnInit, OnDestroy {
       }
     });
   }
+    });
+  }
 
   private scrollToUploadSection(): void {
     if (this.uploadSection) {
@@ -2,11 +2,25 @@ export interface Token {
   access_token: string;
   provider: 'onedrive' | 'googledrive';
   scope?: string;
+  account_id?: string;
+  account_name?: string;
 }
 
 export interface UserSession {
   session_id: string;
-  tokens: { [provider: string]: Token };
+  tokens: { [key: string]: Token }; // Keyed by provider, or provider:account_id
+}
+
+// Account is a provider account signed in to the session; several accounts of a provider can be
+export interface Account {
+  provider: 'onedrive' | 'googledrive';
+  account_id: string; // Empty for an account signed in before accounts were tracked
+  display_name?: string;
+  email?: string;
+}
+
+export interface AccountsResponse {
+  accounts: Account[];
 }
 
 export interface CloudItem {
@@ -19,4 +33,12 @@ export interface CloudItem {
   face_recognition_optimized_url?: string;      // 800px optimized for face recognition
   thumbnail_url?: string;    // 400px for frontend display
   match_distance?: number;   // Face recognition match distance (0.0-1.0, lower is better)
+  match_confidence?: number; // Match distance calibrated for the face model (0.0-1.0, higher is better)
+  match_reference?: number;  // Index of the reference image the match was closest to
+  drive_id?: string;         // OneDrive drive ID, echoed back so the backend can re-resolve the item
+  modified_time?: string;    // Last modification time reported by the provider
+  size?: number;             // Bytes, 0 for folders and files the provider reports no size for
+  source_document?: CloudItem; // Document, such as a PDF contact sheet, an extracted image came from
+  source_image?: number;     // Position of the extracted image in source_document, from 1
+  source_page?: number;      // Page of source_document the extracted image is shown on, from 1
 }
\ No newline at end of file
@@ -0,0 +1,25 @@
+export interface ServerConfig {
+  max_base_face_size: number;          // Bytes
+  base_face_content_types: string[];  // image_mime_types plus HEIC/HEIF, which the server transcodes
+  image_mime_types: string[];          // Mime types considered candidate images in folders
+  max_images_per_job: number;
+  max_zip_files: number;
+  new_jobs_disabled: boolean;          // Server is draining for maintenance, new comparisons and downloads are rejected
+}
+
+export interface ServerCapabilities {
+  providers: string[];                 // Providers users can sign in with
+  base_face_content_types: string[];
+  max_base_face_size: number;          // Bytes
+  max_images_per_job: number;
+  max_zip_files: number;
+  download_formats: string[];
+  token_refresh: boolean;              // Expiring provider tokens are refreshed without signing in again
+  pkce: boolean;
+  features: {
+    save_to_drive: boolean;
+    saved_references: boolean;
+    document_images: boolean;          // Photos embedded in PDFs are compared
+    new_jobs_disabled: boolean;
+  };
+}
@@ -3,32 +3,131 @@ import { CloudItem } from './auth.model';
 export interface GetFolderContentsResponse {
   folder: CloudItem;
   contents: CloudItem[];
+  truncated?: boolean;                // Set when the folder has more items than a listing returns
+  total_items?: number;
+  message?: string;
+  next_page_token?: string;           // Set on a paged listing with more pages
+}
+
+export interface DetectShareLinkResponse {
+  providers: string[];                // Providers whose link format matches, empty when unrecognized
+  kind: 'folder' | 'file' | 'unrecognized';
+}
+
+export interface RecentFolder {
+  link: string;
+  provider: string;
+  name?: string;
+  used_at: string;
+}
+
+export interface RecentFoldersResponse {
+  folders: RecentFolder[];                // Most recent first
 }
 
 export interface FaceRegisterResponse {
   success: boolean;
+  reference_count: number;            // Reference images registered for the session
+}
+
+export interface SaveReferenceResponse {
+  success: boolean;
+  saved_at: string;
 }
 
+export type Aggregation = 'any' | 'mean';
+
 export interface CompareFolderRequest {
   session_id: string;
   folder_link: string;
   provider: string;
   recursive?: boolean;
+  preprocess?: string;
+  aggregation?: Aggregation;          // How several reference images are combined, 'any' by default
+  include_all_files?: boolean;
+  max_distance?: number;              // Maximum match distance (0-1), lower is stricter; the service default when unset
+  modified_after?: string;            // YYYY-MM-DD or RFC3339, only images modified within the range are compared
+  modified_before?: string;
 }
 
+export type JobStatus = 'queued' | 'processing' | 'stalled' | 'completed' | 'failed' | 'cancelled';
+
 export interface CompareFolderResponse {
   job_id: string;
-  status: string;
+  status: JobStatus;
 }
 
 export interface JobStatusResponse {
   job_id: string;
-  status: string;
+  status: JobStatus;
   progress: number;
   current_image: number;
   total_images: number;
   matches_found: number;
+  images_with_faces: number;          // Images with any detectable face, 0 with no matches means no faces were found
+  no_matches: boolean;                // Completed without matches, the message then suggests what to try
   message: string;
   matches?: CloudItem[];
+  next_cursor?: string;               // Set when requested with page_size and more matches remain
+  warnings?: string[];
+  failed_images?: FailedImage[];      // Images left out because they could not be downloaded or read
   error?: string;
+  listing?: FolderListing;
+}
+
+export interface FailedImage {
+  name: string;
+  reason: string;
+}
+
+export interface SaveResultResponse {
+  token: string;
+  expires_at: string;
+}
+
+export interface SavedResultResponse {
+  token: string;
+  job_id: string;
+  folder_link?: string;
+  folder_name?: string;
+  created_at: string;
+  matches: CloudItem[];
+  unavailable: string[];
+}
+
+export interface EstimateResponse {
+  image_count: number;
+  estimated_seconds: number;
+  images_per_second: number;
+  based_on_jobs: number;
+  exceeds_limit: boolean;
+  message: string;
+  warnings?: string[];
+}
+
+export interface SaveToDriveResponse {
+  save_job_id: string;
+  status: string;
+}
+
+export interface SaveToDriveOptions {
+  export_formats?: ('csv' | 'json')[];    // Also write matches.csv / matches.json into the folder
+  skip_photos?: boolean;                  // Write only the results files
+}
+
+export interface SaveJobStatusResponse {
+  save_job_id: string;
+  status: 'processing' | 'completed' | 'failed';
+  destination: CloudItem;
+  progress: number;
+  copied: number;
+  uploaded: string[];                     // Results files written, e.g. matches.csv
+  total: number;                          // Photos to copy plus results files to write
+  failures: { name: string; error: string }[];
+  message: string;
+}
+
+export interface FolderListing {
+  images: CloudItem[];
+  other_files: CloudItem[];
 }
\ No newline at end of file
@@ -2,11 +2,17 @@ import { Injectable } from '@angular/core';
 import { HttpClient, HttpParams } from '@angular/common/http';
 import { Observable } from 'rxjs';
 import { environment } from '../../environments/environment';
+import { AccountsResponse } from '../models/auth.model';
 
 export interface SessionValidationResponse {
   valid: boolean;
   requires_auth: boolean;
   provider?: string;
+  expired?: boolean;
+  code?: string;       // 'token_expired' when the provider token expired and could not be refreshed
+  expires_at?: string;
+  expires_in?: number; // Seconds until the provider token expires, when known
+  account_id?: string;
 }
 
 @Injectable({
@@ -25,15 +31,23 @@ export class AuthService {
     return `${this.apiUrl}/auth/${provider}/login?session_id=${sessionId}`;
   }
 
-  validateSession(provider: string): Observable<SessionValidationResponse> {
+  validateSession(provider: string, accountId?: string): Observable<SessionValidationResponse> {
     const sessionId = this.getOrCreateSessionId();
-    const params = new HttpParams()
+    let params = new HttpParams()
       .set('session_id', sessionId)
       .set('provider', provider);
+    if (accountId) {
+      params = params.set('account_id', accountId);
+    }
     
     return this.http.get<SessionValidationResponse>(`${this.apiUrl}/auth/validate-session`, { params });
   }
 
+  listAccounts(): Observable<AccountsResponse> {
+    const params = new HttpParams().set('session_id', this.getOrCreateSessionId());
+    return this.http.get<AccountsResponse>(`${this.apiUrl}/auth/accounts`, { params });
+  }
+
   getSessionId(): string | null {
     return this.sessionId;
   }
@@ -61,7 +75,7 @@ export class AuthService {
     sessionStorage.removeItem('provider');
   }
 
-  signOutProvider(provider: string): Observable<any> {
+  signOutProvider(provider: string, accountId?: string): Observable<any> {
     const sessionId = this.getSessionId();
     if (!sessionId) {
       return new Observable(observer => {
@@ -70,9 +84,12 @@ export class AuthService {
       });
     }
 
-    const params = new HttpParams()
+    let params = new HttpParams()
       .set('session_id', sessionId)
       .set('provider', provider);
+    if (accountId) {
+      params = params.set('account_id', accountId);
+    }
     
     return this.http.post(`${this.apiUrl}/auth/signout`, null, { params });
   }
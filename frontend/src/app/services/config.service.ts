@@ -0,0 +1,26 @@
+import { Injectable, inject } from '@angular/core';
+import { HttpClient } from '@angular/common/http';
+import { Observable, shareReplay } from 'rxjs';
+import { ServerCapabilities, ServerConfig } from '../models/config.model';
+import { environment } from '../../environments/environment';
+
+@Injectable({
+  providedIn: 'root'
+})
+export class ConfigService {
+  private readonly http = inject(HttpClient);
+  private readonly apiUrl = environment.apiUrl;
+
+  // Limits rarely change, so the first response is shared with every subscriber
+  private readonly config$ = this.http.get<ServerConfig>(`${this.apiUrl}/config`).pipe(shareReplay(1));
+  private readonly capabilities$ = this.http.get<ServerCapabilities>(`${this.apiUrl}/capabilities`).pipe(shareReplay(1));
+
+  getConfig(): Observable<ServerConfig> {
+    return this.config$;
+  }
+
+  // Providers and features of this deployment, for showing only what it offers
+  getCapabilities(): Observable<ServerCapabilities> {
+    return this.capabilities$;
+  }
+}
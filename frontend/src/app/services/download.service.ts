@@ -11,7 +11,8 @@ export class DownloadService {
   private readonly http = inject(HttpClient);
   private readonly apiUrl = environment.apiUrl;
 
-  downloadZip(files: CloudItem[], sessionId: string, provider: string): Observable<Blob> {
+  // Without a provider, each file is downloaded from the provider it names
+  downloadZip(files: CloudItem[], sessionId: string, provider?: string): Observable<Blob> {
     return this.http.post(`${this.apiUrl}/downloads/zip`, { 
       files,
       session_id: sessionId,
@@ -1,7 +1,8 @@
 import { Injectable, inject } from '@angular/core';
-import { HttpClient } from '@angular/common/http';
+import { HttpClient, HttpParams } from '@angular/common/http';
 import { Observable, interval, switchMap, takeWhile, map, startWith } from 'rxjs';
-import { FaceRegisterResponse, CompareFolderRequest, CompareFolderResponse, JobStatusResponse } from '../models/search.model';
+import { FaceRegisterResponse, CompareFolderRequest, CompareFolderResponse, EstimateResponse, JobStatusResponse, SaveResultResponse, SavedResultResponse, SaveReferenceResponse, SaveToDriveOptions, SaveToDriveResponse, SaveJobStatusResponse } from '../models/search.model';
+import { CloudItem } from '../models/auth.model';
 import { environment } from '../../environments/environment';
 
 @Injectable({
@@ -11,21 +12,50 @@ export class FaceService {
   private readonly http = inject(HttpClient);
   private readonly apiUrl = environment.apiUrl;
 
-  registerBaseFace(sessionId: string, imageFile: File): Observable<FaceRegisterResponse> {
+  registerBaseFace(sessionId: string, imageFile: File, append: boolean = false): Observable<FaceRegisterResponse> {
     const formData = new FormData();
     formData.append('image', imageFile);
     formData.append('session_id', sessionId);
+    if (append) {
+      formData.append('append', 'true');
+    }
     return this.http.post<FaceRegisterResponse>(`${this.apiUrl}/face/register-base`, formData);
   }
 
-  compareFolder(sessionId: string, folderLink: string, provider: string, recursive: boolean = false): Observable<CompareFolderResponse> {
+  // Registers a photo from the folder being browsed as the reference; the backend downloads it from the provider
+  registerFromFolderItem(sessionId: string, item: CloudItem, append: boolean = false): Observable<FaceRegisterResponse> {
+    return this.http.post<FaceRegisterResponse>(`${this.apiUrl}/face/register-from-folder-item`, {
+      session_id: sessionId,
+      provider: item.provider,
+      item_id: item.id,
+      drive_id: item.drive_id,
+      append: append
+    });
+  }
+
+  compareFolder(sessionId: string, folderLink: string, provider: string, recursive: boolean = false,
+                modifiedAfter?: string, modifiedBefore?: string): Observable<CompareFolderResponse> {
     const request: CompareFolderRequest = {
       session_id: sessionId,
       folder_link: folderLink,
       provider: provider,
-      recursive: recursive
+      recursive: recursive,
+      modified_after: modifiedAfter,
+      modified_before: modifiedBefore
     };
-    return this.http.post<CompareFolderResponse>(`${this.apiUrl}/face/compare-folder`, request);
+    // A fresh key per comparison lets the backend return the same job if this request is retried
+    const headers = { 'Idempotency-Key': crypto.randomUUID() };
+    return this.http.post<CompareFolderResponse>(`${this.apiUrl}/face/compare-folder`, request, { headers });
+  }
+
+  // Counts the folder's photos and predicts how long comparing them would take, without starting a job
+  estimateComparison(sessionId: string, folderLink: string, provider: string, recursive: boolean = false): Observable<EstimateResponse> {
+    return this.http.post<EstimateResponse>(`${this.apiUrl}/face/estimate`, {
+      session_id: sessionId,
+      folder_link: folderLink,
+      provider: provider,
+      recursive: recursive
+    });
   }
 
   getJobStatus(jobId: string): Observable<JobStatusResponse> {
@@ -36,11 +66,49 @@ export class FaceService {
     return interval(pollIntervalMs).pipe(
       startWith(0),
       switchMap(() => this.getJobStatus(jobId)),
-      takeWhile((status) => status.status === 'processing', true)
+      takeWhile((status) => !['completed', 'failed', 'cancelled'].includes(status.status), true)
     );
   }
 
+  // Stops a running comparison; polling then reports the job as cancelled
+  cancelJob(sessionId: string, jobId: string): Observable<CompareFolderResponse> {
+    const params = new HttpParams().set('session_id', sessionId);
+    return this.http.delete<CompareFolderResponse>(`${this.apiUrl}/face/job/${jobId}`, { params });
+  }
+
+  saveResult(sessionId: string, jobId: string): Observable<SaveResultResponse> {
+    return this.http.post<SaveResultResponse>(`${this.apiUrl}/face/job/${jobId}/save`, { session_id: sessionId });
+  }
+
+  getSavedResult(sessionId: string, token: string): Observable<SavedResultResponse> {
+    const params = new HttpParams().set('session_id', sessionId);
+    return this.http.get<SavedResultResponse>(`${this.apiUrl}/face/result/${token}`, { params });
+  }
+
   clearReferenceImage(sessionId: string): Observable<any> {
     return this.http.delete(`${this.apiUrl}/face/clear-reference/${sessionId}`);
   }
+
+  // Keeps the session's reference face for the signed-in account; only call this when the user opts in
+  saveReferenceFace(sessionId: string, provider?: string): Observable<SaveReferenceResponse> {
+    return this.http.post<SaveReferenceResponse>(`${this.apiUrl}/face/saved-reference`, { session_id: sessionId, provider });
+  }
+
+  useSavedReferenceFace(sessionId: string, provider?: string): Observable<FaceRegisterResponse> {
+    return this.http.post<FaceRegisterResponse>(`${this.apiUrl}/face/use-saved-reference`, { session_id: sessionId, provider });
+  }
+
+  deleteSavedReferenceFace(sessionId: string, provider?: string): Observable<any> {
+    const params = provider ? new HttpParams().set('provider', provider) : undefined;
+    return this.http.delete(`${this.apiUrl}/face/saved-reference/${sessionId}`, { params });
+  }
+
+  saveMatchesToDrive(sessionId: string, jobId: string, destination: CloudItem, options: SaveToDriveOptions = {}): Observable<SaveToDriveResponse> {
+    return this.http.post<SaveToDriveResponse>(`${this.apiUrl}/face/job/${jobId}/save-to-drive`, { session_id: sessionId, destination, ...options });
+  }
+
+  getSaveJobStatus(sessionId: string, saveJobId: string): Observable<SaveJobStatusResponse> {
+    const params = new HttpParams().set('session_id', sessionId);
+    return this.http.get<SaveJobStatusResponse>(`${this.apiUrl}/face/save-job/${saveJobId}`, { params });
+  }
 }
@@ -1,7 +1,7 @@
 import { Injectable, inject } from '@angular/core';
 import { HttpClient, HttpParams } from '@angular/common/http';
 import { Observable } from 'rxjs';
-import { GetFolderContentsResponse } from '../models/search.model';
+import { DetectShareLinkResponse, GetFolderContentsResponse, RecentFoldersResponse } from '../models/search.model';
 import { environment } from '../../environments/environment';
 
 @Injectable({
@@ -11,12 +11,39 @@ export class StorageService {
   private readonly http = inject(HttpClient);
   private readonly apiUrl = environment.apiUrl;
 
-  getFolderContents(shareUrl: string, sessionId: string, provider: string): Observable<GetFolderContentsResponse> {
-    const params = new HttpParams()
+  getFolderContents(shareUrl: string, sessionId: string, provider: string, pageSize?: number, pageToken?: string): Observable<GetFolderContentsResponse> {
+    let params = new HttpParams()
       .set('share_url', shareUrl)
       .set('session_id', sessionId)
       .set('provider', provider);
+    if (pageSize) {
+      params = params.set('page_size', pageSize);
+    }
+    if (pageToken) {
+      params = params.set('page_token', pageToken);
+    }
 
     return this.http.get<GetFolderContentsResponse>(`${this.apiUrl}/storage/folder-contents`, { params });
   }
+
+  getMyDriveContents(sessionId: string, provider: string): Observable<GetFolderContentsResponse> {
+    const params = new HttpParams()
+      .set('session_id', sessionId)
+      .set('provider', provider);
+
+    return this.http.get<GetFolderContentsResponse>(`${this.apiUrl}/storage/my-drive`, { params });
+  }
+
+  detectShareLink(url: string): Observable<DetectShareLinkResponse> {
+    const params = new HttpParams().set('url', url);
+
+    return this.http.get<DetectShareLinkResponse>(`${this.apiUrl}/storage/detect`, { params });
+  }
+
+  // Folder links the session opened recently, most recent first, for pre-filling the link input
+  getRecentFolders(sessionId: string): Observable<RecentFoldersResponse> {
+    const params = new HttpParams().set('session_id', sessionId);
+
+    return this.http.get<RecentFoldersResponse>(`${this.apiUrl}/storage/recent`, { params });
+  }
 }
\ No newline at end of file